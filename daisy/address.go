@@ -0,0 +1,141 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+)
+
+const defaultAddressType = "EXTERNAL"
+
+var (
+	addressURLRgx     = regexp.MustCompile(fmt.Sprintf(`^(projects/(?P<project>%[1]s)/)?regions/(?P<region>%[2]s)/addresses/(?P<address>%[2]s)$`, projectRgxStr, rfc1035))
+	validNetworkTiers = []string{"PREMIUM", "STANDARD"}
+)
+
+func (w *Workflow) addressExists(project, region, address string) (bool, DError) {
+	return w.addressCache.resourceExists(func(project, region string, opts ...daisyCompute.ListCallOption) (interface{}, error) {
+		return w.ComputeClient.ListAddresses(project, region)
+	}, project, region, address)
+}
+
+// Address is used to reserve a static external GCE IP address, e.g. for an
+// instance that needs to keep its IP across a stop and start.
+type Address struct {
+	compute.Address
+	Resource
+
+	// labels are set on the address when it's created, compute.Address has
+	// no Labels field.
+	labels map[string]string
+}
+
+// MarshalJSON is a hacky workaround to compute.Address's implementation.
+func (a *Address) MarshalJSON() ([]byte, error) {
+	return json.Marshal(*a)
+}
+
+func (a *Address) populate(ctx context.Context, s *Step) DError {
+	var errs DError
+	a.Name, a.Region, errs = a.Resource.populateWithRegion(ctx, s, a.Name, a.Region)
+	a.AddressType = strOr(a.AddressType, defaultAddressType)
+	a.Description = strOr(a.Description, defaultDescription("Address", s.w.Name, s.w.username))
+	a.link = fmt.Sprintf("projects/%s/regions/%s/addresses/%s", a.Project, a.Region, a.Name)
+	a.labels = withRunIDLabel(a.labels, s.w.RunID())
+	return errs
+}
+
+func (a *Address) validate(ctx context.Context, s *Step) DError {
+	pre := fmt.Sprintf("cannot create address %q", a.daisyName)
+	errs := a.Resource.validateWithRegion(ctx, s, a.Region, pre)
+
+	if a.AddressType != defaultAddressType {
+		errs = addErrs(errs, Errf("%s: bad AddressType %q, only %q addresses are supported", pre, a.AddressType, defaultAddressType))
+	}
+	if a.NetworkTier != "" && !strIn(a.NetworkTier, validNetworkTiers) {
+		errs = addErrs(errs, Errf("%s: bad NetworkTier %q, must be one of %q", pre, a.NetworkTier, validNetworkTiers))
+	}
+	if a.Address.Address != "" && net.ParseIP(a.Address.Address) == nil {
+		errs = addErrs(errs, Errf("%s: bad Address %q, must be an IP address", pre, a.Address.Address))
+	}
+
+	// Register creation.
+	errs = addErrs(errs, s.w.addresses.regCreate(a.daisyName, &a.Resource, s, false))
+	return errs
+}
+
+type addressRegistry struct {
+	baseResourceRegistry
+}
+
+func newAddressRegistry(w *Workflow) *addressRegistry {
+	ar := &addressRegistry{baseResourceRegistry: baseResourceRegistry{w: w, typeName: "address", urlRgx: addressURLRgx}}
+	ar.baseResourceRegistry.deleteFn = ar.deleteFn
+	ar.init()
+	return ar
+}
+
+func (ar *addressRegistry) deleteFn(res *Resource) DError {
+	m := NamedSubexp(addressURLRgx, res.link)
+	err := ar.w.ComputeClient.DeleteAddress(m["project"], m["region"], m["address"])
+	if gErr, ok := err.(*googleapi.Error); ok && gErr.Code == http.StatusNotFound {
+		return typedErr(resourceDNEError, "failed to release address", err)
+	}
+	return newErr("failed to release address", err)
+}
+
+// isAddressReference reports whether natIP, the NatIP of an access config,
+// refers to an address by name or URL rather than being an IP address.
+func isAddressReference(natIP string) bool {
+	return natIP != "" && net.ParseIP(natIP) == nil
+}
+
+// validateNatIP registers step s as a user of the address natIP refers to
+// and checks it is in the region of zone, where the instance is created.
+func (ar *addressRegistry) validateNatIP(instance, natIP, zone string, s *Step) DError {
+	res, err := ar.regUse(natIP, s)
+	if err != nil {
+		return Errf("cannot create instance %q: bad access config NatIP: %v", instance, err)
+	}
+	region := NamedSubexp(addressURLRgx, res.link)["region"]
+	if want := getRegionFromZone(zone); region != want {
+		return Errf("cannot create instance %q: address %q is in region %q, it must be in the instance's region %q", instance, natIP, region, want)
+	}
+	return nil
+}
+
+// natIP returns the IP address of the address natIP refers to, once the
+// address exists.
+func (ar *addressRegistry) natIP(natIP string) (string, DError) {
+	res, ok := ar.get(natIP)
+	if !ok {
+		return "", Errf("missing reference for address %q", natIP)
+	}
+	m := NamedSubexp(addressURLRgx, res.link)
+	a, err := ar.w.ComputeClient.GetAddress(m["project"], m["region"], m["address"])
+	if err != nil {
+		return "", typedErr(apiError, "failed to get address", err)
+	}
+	return a.Address, nil
+}
@@ -0,0 +1,134 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"google.golang.org/api/compute/v1"
+)
+
+func TestAddressPopulate(t *testing.T) {
+	w := testWorkflow()
+	s, _ := w.NewStep("s")
+	a := &Address{Address: compute.Address{Name: "a"}}
+	if err := a.populate(context.Background(), s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	name := w.genName("a")
+	if a.Name != name || a.Region != testRegion || a.AddressType != defaultAddressType {
+		t.Errorf("got Name %q, Region %q and AddressType %q, want %q, %q and %q", a.Name, a.Region, a.AddressType, name, testRegion, defaultAddressType)
+	}
+	if want := fmt.Sprintf("projects/%s/regions/%s/addresses/%s", testProject, testRegion, name); a.link != want {
+		t.Errorf("got link %q, want %q", a.link, want)
+	}
+}
+
+func TestAddressValidate(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		desc      string
+		a         *Address
+		shouldErr bool
+	}{
+		{"good case", &Address{Address: compute.Address{Name: "a"}}, false},
+		{"network tier case", &Address{Address: compute.Address{Name: "a", NetworkTier: "STANDARD"}}, false},
+		{"internal case", &Address{Address: compute.Address{Name: "a", AddressType: "INTERNAL"}}, true},
+		{"bad network tier case", &Address{Address: compute.Address{Name: "a", NetworkTier: "GOLD"}}, true},
+		{"bad address case", &Address{Address: compute.Address{Name: "a", Address: "not-an-ip"}}, true},
+		{"exists case", &Address{Address: compute.Address{Name: testAddress}, Resource: Resource{ExactName: true}}, true},
+	}
+	for _, tt := range tests {
+		w := testWorkflow()
+		w.ComputeClient.(*daisyCompute.TestClient).ListRegionsFn = func(_ string, _ ...daisyCompute.ListCallOption) ([]*compute.Region, error) {
+			return []*compute.Region{{Name: testRegion}}, nil
+		}
+		s, _ := w.NewStep("s")
+		if err := tt.a.populate(ctx, s); err != nil {
+			t.Fatalf("%s: unexpected populate error: %v", tt.desc, err)
+		}
+		if err := tt.a.validate(ctx, s); (err != nil) != tt.shouldErr {
+			t.Errorf("%s: got error %v, want error %t", tt.desc, err, tt.shouldErr)
+		}
+	}
+}
+
+func TestAddressValidateNatIP(t *testing.T) {
+	w := testWorkflow()
+	creator, _ := w.NewStep("creator")
+	user, _ := w.NewStep("user")
+	other, _ := w.NewStep("other")
+	w.AddDependency(user, creator)
+	for name, region := range map[string]string{"a": testRegion, "b": "other-region"} {
+		res := &Resource{link: fmt.Sprintf("projects/%s/regions/%s/addresses/%s", testProject, region, name)}
+		if err := w.addresses.regCreate(name, res, creator, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		desc      string
+		natIP     string
+		s         *Step
+		shouldErr bool
+	}{
+		{"good case", "a", user, false},
+		{"other region case", "b", user, true},
+		{"no dependency case", "a", other, true},
+		{"missing address case", "c", user, true},
+	}
+	for _, tt := range tests {
+		if err := w.addresses.validateNatIP("i", tt.natIP, testZone, tt.s); (err != nil) != tt.shouldErr {
+			t.Errorf("%s: got error %v, want error %t", tt.desc, err, tt.shouldErr)
+		}
+	}
+}
+
+func TestInstanceResolveNatIPs(t *testing.T) {
+	w := testWorkflow()
+	s, _ := w.NewStep("s")
+	link := fmt.Sprintf("projects/%s/regions/%s/addresses/a-real", testProject, testRegion)
+	if err := w.addresses.regCreate("a", &Resource{link: link}, s, true); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	w.ComputeClient.(*daisyCompute.TestClient).GetAddressFn = func(p, r, name string) (*compute.Address, error) {
+		got = append(got, fmt.Sprintf("projects/%s/regions/%s/addresses/%s", p, r, name))
+		return &compute.Address{Address: "203.0.113.10"}, nil
+	}
+
+	i := &Instance{Instance: compute.Instance{NetworkInterfaces: []*compute.NetworkInterface{
+		{AccessConfigs: []*compute.AccessConfig{{NatIP: "a"}}},
+		{AccessConfigs: []*compute.AccessConfig{{NatIP: "198.51.100.1"}, {}}},
+	}}}
+	if err := i.resolveNatIPs(w); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var natIPs []string
+	for _, n := range i.NetworkInterfaces {
+		for _, ac := range n.AccessConfigs {
+			natIPs = append(natIPs, ac.NatIP)
+		}
+	}
+	if diffRes := diff(natIPs, []string{"203.0.113.10", "198.51.100.1", ""}, 0); diffRes != "" {
+		t.Errorf("NatIPs not as expected: (-got,+want)\n%s", diffRes)
+	}
+	if diffRes := diff(got, []string{link}, 0); diffRes != "" {
+		t.Errorf("addresses looked up not as expected: (-got,+want)\n%s", diffRes)
+	}
+}
@@ -0,0 +1,61 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"google.golang.org/api/googleapi"
+)
+
+// canAdopt reports whether an existing GCE resource may be adopted by the
+// workflow in place of creating res.
+func (w *Workflow) canAdopt(res *Resource) bool {
+	return w.AdoptExistingResources && res.ExactName
+}
+
+func isNotFound(err error) bool {
+	apiErr, ok := err.(*googleapi.Error)
+	return ok && apiErr.Code == http.StatusNotFound
+}
+
+// adoptMismatches collects the differences between a resource as configured
+// in a workflow and an existing GCE resource of the same name.
+type adoptMismatches []string
+
+// compareLink compares the resource names of two links, an empty want
+// matches anything.
+func (m *adoptMismatches) compareLink(field, want, got string) {
+	if want != "" && path.Base(want) != path.Base(got) {
+		*m = append(*m, fmt.Sprintf("%s is %q, want %q", field, path.Base(got), path.Base(want)))
+	}
+}
+
+// compareInt compares two values, a zero want matches anything.
+func (m *adoptMismatches) compareInt(field string, want, got int64) {
+	if want != 0 && want != got {
+		*m = append(*m, fmt.Sprintf("%s is %d, want %d", field, got, want))
+	}
+}
+
+func (m adoptMismatches) err(typeName, name string) DError {
+	if len(m) == 0 {
+		return nil
+	}
+	return Errf("cannot adopt existing %s %q, configuration does not match: %s", typeName, name, strings.Join(m, ", "))
+}
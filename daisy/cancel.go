@@ -0,0 +1,112 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"fmt"
+)
+
+// Kinds of CancelReason.
+const (
+	// CancelReasonUser is a cancellation from outside the workflow, by
+	// CancelWithReason or by closing Cancel.
+	CancelReasonUser = "User"
+	// CancelReasonStepTimeout is a step not completing within its Timeout.
+	CancelReasonStepTimeout = "StepTimeout"
+	// CancelReasonStepFailure is a step returning an error, which stops the
+	// steps running alongside it.
+	CancelReasonStepFailure = "StepFailure"
+)
+
+// CancelReason records why a workflow's steps were canceled. Only the first
+// cause is kept, e.g. a step timing out rather than the steps it stopped.
+type CancelReason struct {
+	Kind string
+	// Step that timed out or failed, unset for CancelReasonUser.
+	Step string
+	// Message describes the cause, e.g. the step error.
+	Message string
+}
+
+func (r *CancelReason) String() string {
+	var s string
+	switch r.Kind {
+	case CancelReasonStepTimeout:
+		s = fmt.Sprintf("step %q timed out", r.Step)
+	case CancelReasonStepFailure:
+		s = fmt.Sprintf("step %q failed", r.Step)
+	default:
+		s = "canceled by user"
+	}
+	if r.Message != "" {
+		s += ": " + r.Message
+	}
+	return s
+}
+
+// CancelWithReason cancels the workflow like closing Cancel, recording
+// message, e.g. "interrupted", as the reason. Included and sub workflows
+// record the reason on the workflow running them.
+func (w *Workflow) CancelWithReason(message string) {
+	w.setCancelReason(CancelReason{Kind: CancelReasonUser, Message: message})
+	root := w.rootWorkflow()
+	root.cancelMx.Lock()
+	defer root.cancelMx.Unlock()
+	if !w.isCanceled() {
+		close(w.Cancel)
+	}
+}
+
+// CancelReason returns why the workflow's steps were canceled, or nil if
+// they weren't.
+func (w *Workflow) CancelReason() *CancelReason {
+	w = w.rootWorkflow()
+	w.cancelMx.Lock()
+	defer w.cancelMx.Unlock()
+	if w.cancelReason == nil {
+		return nil
+	}
+	r := *w.cancelReason
+	return &r
+}
+
+// setCancelReason records r on the top level workflow unless a reason has
+// already been recorded.
+func (w *Workflow) setCancelReason(r CancelReason) {
+	w = w.rootWorkflow()
+	w.cancelMx.Lock()
+	defer w.cancelMx.Unlock()
+	if w.cancelReason == nil {
+		w.cancelReason = &r
+	}
+}
+
+// canceledErr returns the error for a step stopped by the workflow being
+// canceled, recording a user cancellation if no other cause was recorded.
+func (s *Step) canceledErr(stepType string) DError {
+	s.w.setCancelReason(CancelReason{Kind: CancelReasonUser})
+	return typedErrf(workflowCanceledError, "Step %q (%s) is canceled: %s", s.name, stepType, s.w.CancelReason())
+}
+
+// timedOut reports whether the workflow was stopped by a step timing out or
+// by ctx reaching its deadline.
+func (w *Workflow) timedOut(ctx context.Context) bool {
+	if ctx.Err() == context.DeadlineExceeded {
+		return true
+	}
+	r := w.CancelReason()
+	return r != nil && r.Kind == CancelReasonStepTimeout
+}
@@ -0,0 +1,156 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCancelReasonString(t *testing.T) {
+	tests := []struct {
+		r    CancelReason
+		want string
+	}{
+		{CancelReason{Kind: CancelReasonUser}, "canceled by user"},
+		{CancelReason{Kind: CancelReasonUser, Message: "Ctrl-C caught"}, "canceled by user: Ctrl-C caught"},
+		{CancelReason{Kind: CancelReasonStepTimeout, Step: "s", Message: "too slow"}, `step "s" timed out: too slow`},
+		{CancelReason{Kind: CancelReasonStepFailure, Step: "s", Message: "bad"}, `step "s" failed: bad`},
+	}
+
+	for _, tt := range tests {
+		if got := tt.r.String(); got != tt.want {
+			t.Errorf("%+v: got %q, want %q", tt.r, got, tt.want)
+		}
+	}
+}
+
+func TestCancelWithReason(t *testing.T) {
+	parent := testWorkflow()
+	w := testWorkflow()
+	w.parent = parent
+	w.Cancel = parent.Cancel
+
+	if r := parent.CancelReason(); r != nil {
+		t.Errorf("want no reason before canceling, got %v", r)
+	}
+	w.CancelWithReason("first")
+	w.CancelWithReason("second")
+	if !parent.isCanceled() {
+		t.Error("Cancel not closed")
+	}
+	want := &CancelReason{Kind: CancelReasonUser, Message: "first"}
+	if diffRes := diff(parent.CancelReason(), want, 0); diffRes != "" {
+		t.Errorf("CancelReason does not match expectation: (-got +want)\n%s", diffRes)
+	}
+	if diffRes := diff(w.CancelReason(), want, 0); diffRes != "" {
+		t.Errorf("sub workflow CancelReason does not match expectation: (-got +want)\n%s", diffRes)
+	}
+}
+
+func TestRunStepCancelReason(t *testing.T) {
+	tests := []struct {
+		desc     string
+		timeout  time.Duration
+		runImpl  func(context.Context, *Step) DError
+		wantKind string
+	}{
+		{"success case", time.Minute, func(context.Context, *Step) DError { return nil }, ""},
+		{"failure case", time.Minute, func(context.Context, *Step) DError { return Errf("fail") }, CancelReasonStepFailure},
+		{"timeout case", time.Nanosecond, func(context.Context, *Step) DError {
+			time.Sleep(time.Second)
+			return nil
+		}, CancelReasonStepTimeout},
+	}
+
+	for _, tt := range tests {
+		w := testWorkflow()
+		s, _ := w.NewStep("test")
+		s.timeout = tt.timeout
+		s.testType = &mockStep{runImpl: tt.runImpl}
+		err := w.runStep(context.Background(), s)
+
+		r := w.CancelReason()
+		if tt.wantKind == "" {
+			if r != nil {
+				t.Errorf("%s: want no reason, got %v", tt.desc, r)
+			}
+			continue
+		}
+		if r == nil {
+			t.Errorf("%s: want %s reason, got none", tt.desc, tt.wantKind)
+			continue
+		}
+		if r.Kind != tt.wantKind || r.Step != "test" || r.Message != err.Error() {
+			t.Errorf("%s: unexpected reason, got %+v, want kind %s for step %q with message %q", tt.desc, r, tt.wantKind, "test", err)
+		}
+	}
+}
+
+func TestStepCanceledErr(t *testing.T) {
+	tests := []struct {
+		desc    string
+		cancel  func(w *Workflow)
+		wantErr string
+	}{
+		{"closed Cancel case", func(w *Workflow) { close(w.Cancel) }, `Step "s" (mockStep) is canceled: canceled by user`},
+		{"user reason case", func(w *Workflow) { w.CancelWithReason("Ctrl-C caught") }, `Step "s" (mockStep) is canceled: canceled by user: Ctrl-C caught`},
+		{"sibling failure case", func(w *Workflow) {
+			w.setCancelReason(CancelReason{Kind: CancelReasonStepFailure, Step: "other", Message: "bad"})
+			close(w.Cancel)
+		}, `Step "s" (mockStep) is canceled: step "other" failed: bad`},
+	}
+
+	for _, tt := range tests {
+		w := testWorkflow()
+		s, _ := w.NewStep("s")
+		s.testType = &mockStep{runImpl: func(context.Context, *Step) DError {
+			tt.cancel(w)
+			return nil
+		}}
+		err := s.run(context.Background())
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: got error %v, want one containing %q", tt.desc, err, tt.wantErr)
+		} else if !err.CausedByErrType(workflowCanceledError) {
+			t.Errorf("%s: error is not a %s: %v", tt.desc, workflowCanceledError, err)
+		}
+	}
+}
+
+func TestRunReturnsCancelReason(t *testing.T) {
+	w := testWorkflow()
+	var s1Ran bool
+	w.Steps = map[string]*Step{
+		"s0": {name: "s0", w: w, testType: &mockStep{runImpl: func(context.Context, *Step) DError {
+			w.CancelWithReason("stop")
+			return nil
+		}}},
+		"s1": {name: "s1", w: w, testType: &mockStep{runImpl: func(context.Context, *Step) DError {
+			s1Ran = true
+			return nil
+		}}},
+	}
+	w.Dependencies = map[string][]string{"s1": {"s0"}}
+
+	err := w.Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "canceled by user: stop") {
+		t.Errorf("got error %v, want one with the cancel reason", err)
+	}
+	if s1Ran {
+		t.Error("step s1 ran after the workflow was canceled")
+	}
+}
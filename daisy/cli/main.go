@@ -24,6 +24,7 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
@@ -41,12 +42,16 @@ var (
 	print              = flag.Bool("print", false, "print out the parsed workflow for debugging")
 	printPerf          = flag.Bool("print_perf", false, "print out the performance profile")
 	validate           = flag.Bool("validate", false, "validate the workflow and exit")
+	validateOffline    = flag.Bool("validate_offline", false, "validate the workflow file(s) without API access and exit")
 	format             = flag.Bool("format_workflow", false, "format the workflow file(s) and exit")
 	defaultTimeout     = flag.String("default_timeout", "", "sets the default timeout for the workflow")
 	ce                 = flag.String("compute_endpoint_override", "", "API endpoint to override default")
+	se                 = flag.String("storage_endpoint_override", "", "Cloud Storage API endpoint to override default")
+	userAgent          = flag.String("user_agent", "", "user-agent to send with API requests, overrides what is set in workflow")
 	gcsLogsDisabled    = flag.Bool("disable_gcs_logging", false, "do not stream logs to GCS")
 	cloudLogsDisabled  = flag.Bool("disable_cloud_logging", false, "do not stream logs to Cloud Logging")
 	stdoutLogsDisabled = flag.Bool("disable_stdout_logging", false, "do not display individual workflow logs on stdout")
+	serialStdout       = flag.Bool("serial_output_stdout", false, "also display instance serial port output on stdout, prefixed with the instance name")
 )
 
 const (
@@ -75,7 +80,7 @@ func populateVars(input string) map[string]string {
 	return varMap
 }
 
-func parseWorkflow(ctx context.Context, path string, varMap map[string]string, project, zone, gcsPath, oauth, dTimeout, cEndpoint string, disableGCSLogs, diableCloudLogs, disableStdoutLogs bool) (*daisy.Workflow, error) {
+func parseWorkflow(ctx context.Context, path string, varMap map[string]string, project, zone, gcsPath, oauth, dTimeout, cEndpoint, sEndpoint, ua string, disableGCSLogs, diableCloudLogs, disableStdoutLogs, serialStdout bool) (*daisy.Workflow, error) {
 	w, err := daisy.NewFromFile(path)
 	if err != nil {
 		return nil, err
@@ -101,7 +106,7 @@ Loop:
 	}
 	if zone != "" {
 		w.Zone = zone
-	} else if w.Zone == "" && metadata.OnGCE() {
+	} else if w.Zone == "" && w.Region == "" && metadata.OnGCE() {
 		w.Zone, err = metadata.Zone()
 		if err != nil {
 			return nil, fmt.Errorf("Failed to get GCE zone from metadata: %v", err)
@@ -120,6 +125,12 @@ Loop:
 	if cEndpoint != "" {
 		w.ComputeEndpoint = cEndpoint
 	}
+	if sEndpoint != "" {
+		w.StorageEndpoint = sEndpoint
+	}
+	if ua != "" {
+		w.UserAgent = ua
+	}
 
 	if disableGCSLogs {
 		w.DisableGCSLogging()
@@ -130,6 +141,9 @@ Loop:
 	if disableStdoutLogs {
 		w.DisableStdoutLogging()
 	}
+	if serialStdout {
+		w.EnableSerialOutputStdout()
+	}
 
 	return w, nil
 }
@@ -160,6 +174,9 @@ func addFlags(args []string) {
 }
 
 func fmtWorkflow(path string) error {
+	if ext := strings.ToLower(filepath.Ext(path)); ext == ".yaml" || ext == ".yml" {
+		return fmt.Errorf("%s: only JSON workflow files can be formatted", path)
+	}
 	f, err := os.OpenFile(path, os.O_RDWR, 0)
 	if err != nil {
 		return err
@@ -239,13 +256,32 @@ func main() {
 		return
 	}
 
+	if *validateOffline {
+		failed := false
+		for _, path := range flag.Args() {
+			fmt.Printf("[Daisy] Validating workflow file %q offline\n", path)
+			staticErrs, liveErrs := daisy.ValidateWorkflowFile(path)
+			if liveErrs != nil {
+				fmt.Printf("[Daisy] Skipped checks that need API access for %q: %v\n", path, liveErrs)
+			}
+			if staticErrs != nil {
+				fmt.Fprintf(os.Stderr, "[Daisy] Error validating workflow file %q: %v\n", path, staticErrs)
+				failed = true
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+		return
+	}
+
 	ctx := context.Background()
 
 	var ws []*daisy.Workflow
 	varMap := populateVars(*variables)
 
 	for _, path := range flag.Args() {
-		w, err := parseWorkflow(ctx, path, varMap, *project, *zone, *gcsPath, *oauth, *defaultTimeout, *ce, *gcsLogsDisabled, *cloudLogsDisabled, *stdoutLogsDisabled)
+		w, err := parseWorkflow(ctx, path, varMap, *project, *zone, *gcsPath, *oauth, *defaultTimeout, *ce, *se, *userAgent, *gcsLogsDisabled, *cloudLogsDisabled, *stdoutLogsDisabled, *serialStdout)
 		if err != nil {
 			log.Fatalf("error parsing workflow %q: %v", path, err)
 		}
@@ -261,7 +297,7 @@ func main() {
 			select {
 			case <-c:
 				fmt.Printf("\nCtrl-C caught, sending cancel signal to %q...\n", w.Name)
-				close(w.Cancel)
+				w.CancelWithReason("Ctrl-C caught")
 				errors <- fmt.Errorf("workflow %q was canceled", w.Name)
 			case <-w.Cancel:
 			}
@@ -284,6 +320,8 @@ func main() {
 			if *printPerf {
 				defer printPerfProfile(w)
 			}
+			// Let background cleanup finish before exiting.
+			defer w.WaitForCleanup()
 			fmt.Printf("[Daisy] Running workflow %q (id=%s)\n", w.Name, w.ID())
 			if err := w.Run(ctx); err != nil {
 				errors <- fmt.Errorf("%s: %v", w.Name, err)
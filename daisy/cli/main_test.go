@@ -81,7 +81,9 @@ func TestParseWorkflows(t *testing.T) {
 	oauth := "oauthpath"
 	dTimeout := "10m"
 	endpoint := "endpoint"
-	w, err := parseWorkflow(context.Background(), path, varMap, project, zone, gcsPath, oauth, dTimeout, endpoint, true, true, true)
+	storageEndpoint := "storage-endpoint"
+	ua := "useragent"
+	w, err := parseWorkflow(context.Background(), path, varMap, project, zone, gcsPath, oauth, dTimeout, endpoint, storageEndpoint, ua, true, true, true, false)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -95,6 +97,8 @@ func TestParseWorkflows(t *testing.T) {
 		{w.OAuthPath, oauth},
 		{w.DefaultTimeout, dTimeout},
 		{w.ComputeEndpoint, endpoint},
+		{w.StorageEndpoint, storageEndpoint},
+		{w.UserAgent, ua},
 	}
 
 	for _, tt := range tests {
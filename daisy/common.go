@@ -179,6 +179,17 @@ func traverseData(v reflect.Value, f func(reflect.Value) DError) DError {
 		if v.IsNil() {
 			return nil
 		}
+		if v.Kind() == reflect.Interface && v.Elem().Kind() != reflect.Ptr {
+			// Values held by an interface, e.g. decoded JSON, can't be set.
+			// Modify a copy and put it back.
+			e := reflect.New(v.Elem().Type()).Elem()
+			e.Set(v.Elem())
+			if err := traverseData(e, f); err != nil {
+				return err
+			}
+			v.Set(e)
+			return nil
+		}
 		// I'm a pointer, dereference me.
 		return traverseData(v.Elem(), f)
 	}
@@ -226,10 +237,6 @@ func traverseData(v reflect.Value, f func(reflect.Value) DError) DError {
 	return nil
 }
 
-func xor(x, y bool) bool {
-	return x != y
-}
-
 // CombineGuestOSFeatures merges two slices of Guest OS features and returns a
 // new slice instance. Duplicates are removed.
 func CombineGuestOSFeatures(features1 []*compute.GuestOsFeature,
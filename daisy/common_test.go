@@ -222,6 +222,7 @@ func TestSubstitute(t *testing.T) {
 		String         string
 		StringMap      map[string]string
 		SliceStringMap map[string][]string
+		InterfaceMap   map[string]interface{}
 		Steps          map[string]*Step
 		private        string
 	}
@@ -352,6 +353,11 @@ func TestSubstitute(t *testing.T) {
 				},
 			},
 		},
+		{ // 10
+			strings.NewReplacer("key1", "value1", "key2", "value2"),
+			test{InterfaceMap: map[string]interface{}{"k": map[string]interface{}{"a": "key1", "b": []interface{}{"key2", 1.0, true}}}},
+			test{InterfaceMap: map[string]interface{}{"k": map[string]interface{}{"a": "value1", "b": []interface{}{"value2", 1.0, true}}}},
+		},
 	}
 
 	for i, tt := range tests {
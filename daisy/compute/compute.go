@@ -16,11 +16,16 @@
 package compute
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"math/rand"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/oauth2"
@@ -34,24 +39,39 @@ import (
 // Client is a client for interacting with Google Cloud Compute.
 type Client interface {
 	AttachDisk(project, zone, instance string, d *compute.AttachedDisk) error
+	ForceAttachDisk(project, zone, instance string, d *compute.AttachedDisk) error
 	DetachDisk(project, zone, instance, disk string) error
 	CreateDisk(project, zone string, d *compute.Disk) error
+	CreateDiskWithProvisionedPerformance(project, zone string, d *compute.Disk, iops, throughput int64) error
+	CreateDiskWithOptions(project, zone string, d *compute.Disk, opts DiskOptions) error
+	CreateAddress(project, region string, a *compute.Address) error
+	CreateAddressWithLabels(project, region string, a *compute.Address, labels map[string]string) error
 	CreateForwardingRule(project, region string, fr *compute.ForwardingRule) error
+	CreateForwardingRuleWithLabels(project, region string, fr *compute.ForwardingRule, labels map[string]string) error
 	CreateFirewallRule(project string, i *compute.Firewall) error
 	CreateImage(project string, i *compute.Image) error
 	CreateImageBeta(project string, i *computeBeta.Image) error
+	CreateImageWithArchitecture(project string, i *compute.Image, architecture string) error
 	CreateInstance(project, zone string, i *compute.Instance) error
 	CreateInstanceBeta(project, zone string, i *computeBeta.Instance) error
+	CreateInstanceWithTerminationAction(project, zone string, i *compute.Instance, action string) error
+	CreateInstanceBetaWithTerminationAction(project, zone string, i *computeBeta.Instance, action string) error
+	CreateInstanceWithOptions(project, zone string, i *compute.Instance, opts InstanceOptions) error
+	CreateInstanceBetaWithOptions(project, zone string, i *computeBeta.Instance, opts InstanceOptions) error
+	CreateInstanceGroup(project, zone string, ig *compute.InstanceGroup) error
 	CreateNetwork(project string, n *compute.Network) error
 	CreateSubnetwork(project, region string, n *compute.Subnetwork) error
 	CreateTargetInstance(project, zone string, ti *compute.TargetInstance) error
 	DeleteDisk(project, zone, name string) error
+	DeleteAddress(project, region, name string) error
 	DeleteForwardingRule(project, region, name string) error
 	DeleteFirewallRule(project, name string) error
 	DeleteImage(project, name string) error
 	DeleteInstance(project, zone, name string) error
+	DeleteInstanceGroup(project, zone, name string) error
 	StartInstance(project, zone, name string) error
 	StopInstance(project, zone, name string) error
+	SetMachineType(project, zone, name, machineType string) error
 	DeleteNetwork(project, name string) error
 	DeleteSubnetwork(project, region, name string) error
 	DeleteTargetInstance(project, zone, name string) error
@@ -59,10 +79,15 @@ type Client interface {
 	GetMachineType(project, zone, machineType string) (*compute.MachineType, error)
 	GetProject(project string) (*compute.Project, error)
 	GetSerialPortOutput(project, zone, name string, port, start int64) (*compute.SerialPortOutput, error)
+	GetScreenshot(project, zone, name string) ([]byte, error)
 	GetZone(project, zone string) (*compute.Zone, error)
+	GetRegion(project, region string) (*compute.Region, error)
 	GetInstance(project, zone, name string) (*compute.Instance, error)
 	GetInstanceBeta(project, zone, name string) (*computeBeta.Instance, error)
+	GetInstanceGroup(project, zone, name string) (*compute.InstanceGroup, error)
 	GetDisk(project, zone, name string) (*compute.Disk, error)
+	GetRegionDisk(project, region, name string) (*compute.Disk, error)
+	GetAddress(project, region, name string) (*compute.Address, error)
 	GetForwardingRule(project, region, name string) (*compute.ForwardingRule, error)
 	GetFirewallRule(project, name string) (*compute.Firewall, error)
 	GetImage(project, name string) (*compute.Image, error)
@@ -74,6 +99,7 @@ type Client interface {
 	GetTargetInstance(project, zone, name string) (*compute.TargetInstance, error)
 	InstanceStatus(project, zone, name string) (string, error)
 	InstanceStopped(project, zone, name string) (bool, error)
+	InstancePreempted(project, zone, name string) (bool, error)
 	ListMachineTypes(project, zone string, opts ...ListCallOption) ([]*compute.MachineType, error)
 	ListLicenses(project string, opts ...ListCallOption) ([]*compute.License, error)
 	ListZones(project string, opts ...ListCallOption) ([]*compute.Zone, error)
@@ -82,9 +108,13 @@ type Client interface {
 	ListInstances(project, zone string, opts ...ListCallOption) ([]*compute.Instance, error)
 	AggregatedListDisks(project string, opts ...ListCallOption) ([]*compute.Disk, error)
 	ListDisks(project, zone string, opts ...ListCallOption) ([]*compute.Disk, error)
+	AggregatedListAddresses(project string, opts ...ListCallOption) ([]*compute.Address, error)
+	ListAddresses(project, region string, opts ...ListCallOption) ([]*compute.Address, error)
+	AggregatedListForwardingRules(project string, opts ...ListCallOption) ([]*compute.ForwardingRule, error)
 	ListForwardingRules(project, zone string, opts ...ListCallOption) ([]*compute.ForwardingRule, error)
 	ListFirewallRules(project string, opts ...ListCallOption) ([]*compute.Firewall, error)
 	ListImages(project string, opts ...ListCallOption) ([]*compute.Image, error)
+	CreateSnapshot(project, zone, disk string, s *compute.Snapshot, guestFlush bool) error
 	GetSnapshot(project, name string) (*compute.Snapshot, error)
 	ListSnapshots(project string, opts ...ListCallOption) ([]*compute.Snapshot, error)
 	DeleteSnapshot(project, name string) error
@@ -96,6 +126,8 @@ type Client interface {
 	SetInstanceMetadata(project, zone, name string, md *compute.Metadata) error
 	SetCommonInstanceMetadata(project string, md *compute.Metadata) error
 	SetDiskAutoDelete(project, zone, instance string, autoDelete bool, deviceName string) error
+	AddInstanceGroupInstances(project, zone, name string, instances []string) error
+	RemoveInstanceGroupInstances(project, zone, name string, instances []string) error
 
 	// Beta API calls
 	GetGuestAttributes(project, zone, name, queryPath, variableKey string) (*computeBeta.GuestAttributes, error)
@@ -145,6 +177,10 @@ func (o OrderBy) listCallOptionApply(i interface{}) interface{} {
 		return c.OrderBy(string(o))
 	case *compute.SubnetworksAggregatedListCall:
 		return c.OrderBy(string(o))
+	case *compute.AddressesAggregatedListCall:
+		return c.OrderBy(string(o))
+	case *compute.ForwardingRulesAggregatedListCall:
+		return c.OrderBy(string(o))
 	}
 	return i
 }
@@ -180,6 +216,10 @@ func (o Filter) listCallOptionApply(i interface{}) interface{} {
 		return c.Filter(string(o))
 	case *compute.SubnetworksAggregatedListCall:
 		return c.Filter(string(o))
+	case *compute.AddressesAggregatedListCall:
+		return c.Filter(string(o))
+	case *compute.ForwardingRulesAggregatedListCall:
+		return c.Filter(string(o))
 	}
 	return i
 }
@@ -192,15 +232,81 @@ type clientImpl interface {
 }
 
 type client struct {
-	i       clientImpl
-	hc      *http.Client
-	raw     *compute.Service
-	rawBeta *computeBeta.Service
+	i           clientImpl
+	hc          *http.Client
+	raw         *compute.Service
+	rawBeta     *computeBeta.Service
+	isRetriable func(error) bool
+	onWarnings  func(op *compute.Operation)
+	// warningsMx guards onWarnings, a pointer as NewTestClient copies client.
+	warningsMx *sync.Mutex
+}
+
+// IsRetriable is the default classification of a failed API call as worth
+// attempting again: connection resets, unexpected EOFs, 5xx responses and
+// 429 Too Many Requests.
+func IsRetriable(err error) bool {
+	apiErr, ok := err.(*googleapi.Error)
+	switch {
+	case !ok:
+		return strings.Contains(err.Error(), "connection reset by peer") || strings.Contains(err.Error(), "unexpected EOF")
+	case apiErr.Code >= 500 && apiErr.Code <= 599:
+		return true
+	case apiErr.Code >= 429:
+		// Too many API requests.
+		return true
+	}
+	return false
+}
+
+// SetIsRetriable sets the function c uses to decide whether a failed API call
+// is attempted again, in place of IsRetriable. It has no effect on clients
+// not created by NewClient.
+func SetIsRetriable(c Client, isRetriable func(error) bool) {
+	switch c := c.(type) {
+	case *client:
+		c.isRetriable = isRetriable
+	case *TestClient:
+		c.client.isRetriable = isRetriable
+	}
+}
+
+// SetOperationWarningsHandler sets a function c calls with each operation
+// it waits for that completes with warnings, e.g. a disk size that was
+// rounded up, whether or not the operation failed. A handler already set is
+// kept, the operations of a client shared by several callers can't be told
+// apart. It returns a function that removes onWarnings again, or nil if
+// onWarnings wasn't set. It has no effect on clients not created by
+// NewClient, callers that wrap a client pass the client they wrap.
+func SetOperationWarningsHandler(c Client, onWarnings func(op *compute.Operation)) func() {
+	switch c := c.(type) {
+	case *client:
+		return c.setOperationWarningsHandler(onWarnings)
+	case *TestClient:
+		return c.client.setOperationWarningsHandler(onWarnings)
+	}
+	return nil
+}
+
+func (c *client) setOperationWarningsHandler(onWarnings func(op *compute.Operation)) func() {
+	c.warningsMx.Lock()
+	defer c.warningsMx.Unlock()
+	if c.onWarnings != nil {
+		return nil
+	}
+	c.onWarnings = onWarnings
+	return func() {
+		c.warningsMx.Lock()
+		defer c.warningsMx.Unlock()
+		c.onWarnings = nil
+	}
 }
 
 // shouldRetryWithWait returns true if the HTTP response / error indicates
-// that the request should be attempted again.
-func shouldRetryWithWait(tripper http.RoundTripper, err error, multiplier int) bool {
+// that the request should be attempted again, waiting before returning.
+// isRetriable classifies err, IsRetriable is used if it's nil. Requests are
+// always retried if the oauth token is no longer valid.
+func shouldRetryWithWait(tripper http.RoundTripper, err error, multiplier int, isRetriable func(error) bool) bool {
 	if err == nil {
 		return false
 	}
@@ -211,25 +317,13 @@ func shouldRetryWithWait(tripper http.RoundTripper, err error, multiplier int) b
 			tkValid = tk.Valid()
 		}
 	}
-
-	apiErr, ok := err.(*googleapi.Error)
-	var retry bool
-	switch {
-	case !ok && (strings.Contains(err.Error(), "connection reset by peer") || strings.Contains(err.Error(), "unexpected EOF")):
-		retry = true
-	case !ok && tkValid:
-		// Not a googleapi.Error and the token is still valid.
-		return false
-	case apiErr.Code >= 500 && apiErr.Code <= 599:
-		retry = true
-	case apiErr.Code >= 429:
-		// Too many API requests.
-		retry = true
-	case !tkValid:
-		// This was probably a failure to get new token from metadata server.
-		retry = true
+	if isRetriable == nil {
+		isRetriable = IsRetriable
 	}
-	if !retry {
+
+	// An invalid token was probably a failure to get a new token from the
+	// metadata server.
+	if tkValid && !isRetriable(err) {
 		return false
 	}
 
@@ -260,7 +354,7 @@ func NewClient(ctx context.Context, opts ...option.ClientOption) (Client, error)
 	if ep != "" {
 		rawBetaService.BasePath = ep
 	}
-	c := &client{hc: hc, raw: rawService, rawBeta: rawBetaService}
+	c := &client{hc: hc, raw: rawService, rawBeta: rawBetaService, warningsMx: &sync.Mutex{}}
 	c.i = c
 
 	return c, nil
@@ -268,6 +362,9 @@ func NewClient(ctx context.Context, opts ...option.ClientOption) (Client, error)
 
 // BasePath returns the base path for this client.
 func (c *client) BasePath() string {
+	if c.raw == nil {
+		return ""
+	}
 	return c.raw.BasePath
 }
 
@@ -320,6 +417,12 @@ func (c *client) operationsWaitHelper(project, name string, getOperation operati
 			time.Sleep(1 * time.Second)
 			continue
 		case "DONE":
+			c.warningsMx.Lock()
+			onWarnings := c.onWarnings
+			c.warningsMx.Unlock()
+			if len(op.Warnings) > 0 && onWarnings != nil {
+				onWarnings(op)
+			}
 			if op.Error != nil {
 				var operrs string
 				for _, operr := range op.Error.Errors {
@@ -345,7 +448,7 @@ func (c *client) Retry(f func(opts ...googleapi.CallOption) (*compute.Operation,
 		if err == nil {
 			return op, nil
 		}
-		if !shouldRetryWithWait(c.hc.Transport, err, i) {
+		if !shouldRetryWithWait(c.hc.Transport, err, i, c.isRetriable) {
 			return nil, err
 		}
 	}
@@ -361,7 +464,7 @@ func (c *client) RetryBeta(f func(opts ...googleapi.CallOption) (*computeBeta.Op
 		if err == nil {
 			return op, nil
 		}
-		if !shouldRetryWithWait(c.hc.Transport, err, i) {
+		if !shouldRetryWithWait(c.hc.Transport, err, i, c.isRetriable) {
 			return nil, err
 		}
 	}
@@ -378,6 +481,18 @@ func (c *client) AttachDisk(project, zone, instance string, d *compute.AttachedD
 	return c.i.zoneOperationsWait(project, zone, op.Name)
 }
 
+// ForceAttachDisk attaches a GCE regional persistent disk to an instance like
+// AttachDisk, even if it is attached to another instance, e.g. one in a zone
+// that is down.
+func (c *client) ForceAttachDisk(project, zone, instance string, d *compute.AttachedDisk) error {
+	op, err := c.Retry(c.raw.Instances.AttachDisk(project, zone, instance, d).ForceAttach(true).Do)
+	if err != nil {
+		return err
+	}
+
+	return c.i.zoneOperationsWait(project, zone, op.Name)
+}
+
 // DetachDisk detaches a GCE persistent disk to an instance.
 func (c *client) DetachDisk(project, zone, instance, disk string) error {
 	op, err := c.Retry(c.raw.Instances.DetachDisk(project, zone, instance, disk).Do)
@@ -407,6 +522,114 @@ func (c *client) CreateDisk(project, zone string, d *compute.Disk) error {
 	return nil
 }
 
+// CreateDiskWithProvisionedPerformance creates a GCE disk like CreateDisk,
+// setting ProvisionedIops and ProvisionedThroughput (MB/s) when non-zero, e.g.
+// for hyperdisk types.
+func (c *client) CreateDiskWithProvisionedPerformance(project, zone string, d *compute.Disk, iops, throughput int64) error {
+	return c.CreateDiskWithOptions(project, zone, d, DiskOptions{ProvisionedIops: iops, ProvisionedThroughput: throughput})
+}
+
+// DiskOptions are disk fields that the compute API version used by this
+// package has no field for.
+type DiskOptions struct {
+	// ProvisionedIops and ProvisionedThroughput (MB/s) are set when non-zero.
+	ProvisionedIops, ProvisionedThroughput int64
+	// ResourceManagerTags sets params.resourceManagerTags, mapping tag keys,
+	// e.g. "tagKeys/123", to tag values, e.g. "tagValues/456".
+	ResourceManagerTags map[string]string
+}
+
+// CreateDiskWithOptions creates a GCE disk like CreateDisk, setting the
+// fields in opts. The compute API version used by this package has no such
+// fields, so the insert request is built here instead of by the generated
+// client.
+func (c *client) CreateDiskWithOptions(project, zone string, d *compute.Disk, opts DiskOptions) error {
+	op, err := c.Retry(func(_ ...googleapi.CallOption) (*compute.Operation, error) {
+		op := &compute.Operation{}
+		err := c.insertRaw(c.raw.BasePath, "{project}/zones/{zone}/disks", map[string]string{"project": project, "zone": zone}, d, func(body map[string]interface{}) {
+			if opts.ProvisionedIops != 0 {
+				body["provisionedIops"] = strconv.FormatInt(opts.ProvisionedIops, 10)
+			}
+			if opts.ProvisionedThroughput != 0 {
+				body["provisionedThroughput"] = strconv.FormatInt(opts.ProvisionedThroughput, 10)
+			}
+			if len(opts.ResourceManagerTags) > 0 {
+				body["params"] = map[string]interface{}{"resourceManagerTags": opts.ResourceManagerTags}
+			}
+		}, op)
+		return op, err
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := c.i.zoneOperationsWait(project, zone, op.Name); err != nil {
+		return err
+	}
+
+	var createdDisk *compute.Disk
+	if createdDisk, err = c.i.GetDisk(project, zone, d.Name); err != nil {
+		return err
+	}
+	*d = *createdDisk
+	return nil
+}
+
+// CreateAddress creates a GCE address.
+func (c *client) CreateAddress(project, region string, a *compute.Address) error {
+	op, err := c.Retry(c.raw.Addresses.Insert(project, region, a).Do)
+	if err != nil {
+		return err
+	}
+
+	if err := c.i.regionOperationsWait(project, region, op.Name); err != nil {
+		return err
+	}
+
+	var createdAddress *compute.Address
+	if createdAddress, err = c.i.GetAddress(project, region, a.Name); err != nil {
+		return err
+	}
+	*a = *createdAddress
+	return nil
+}
+
+// CreateAddressWithLabels creates a GCE address like CreateAddress, setting
+// its labels. The compute API version used by this package has no address
+// labels field, so the insert request is built here instead of by the
+// generated client.
+func (c *client) CreateAddressWithLabels(project, region string, a *compute.Address, labels map[string]string) error {
+	op, err := c.Retry(func(_ ...googleapi.CallOption) (*compute.Operation, error) {
+		op := &compute.Operation{}
+		err := c.insertRaw(c.raw.BasePath, "{project}/regions/{region}/addresses", map[string]string{"project": project, "region": region}, a, setLabels(labels), op)
+		return op, err
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := c.i.regionOperationsWait(project, region, op.Name); err != nil {
+		return err
+	}
+
+	var createdAddress *compute.Address
+	if createdAddress, err = c.i.GetAddress(project, region, a.Name); err != nil {
+		return err
+	}
+	*a = *createdAddress
+	return nil
+}
+
+// setLabels returns an insertRaw setFields function setting the labels of
+// the inserted resource, if any.
+func setLabels(labels map[string]string) func(map[string]interface{}) {
+	return func(body map[string]interface{}) {
+		if len(labels) > 0 {
+			body["labels"] = labels
+		}
+	}
+}
+
 // CreateForwardingRule creates a GCE forwarding rule.
 func (c *client) CreateForwardingRule(project, region string, fr *compute.ForwardingRule) error {
 	op, err := c.Retry(c.raw.ForwardingRules.Insert(project, region, fr).Do)
@@ -426,6 +649,32 @@ func (c *client) CreateForwardingRule(project, region string, fr *compute.Forwar
 	return nil
 }
 
+// CreateForwardingRuleWithLabels creates a GCE forwarding rule like
+// CreateForwardingRule, setting its labels. The compute API version used by
+// this package has no forwarding rule labels field, so the insert request is
+// built here instead of by the generated client.
+func (c *client) CreateForwardingRuleWithLabels(project, region string, fr *compute.ForwardingRule, labels map[string]string) error {
+	op, err := c.Retry(func(_ ...googleapi.CallOption) (*compute.Operation, error) {
+		op := &compute.Operation{}
+		err := c.insertRaw(c.raw.BasePath, "{project}/regions/{region}/forwardingRules", map[string]string{"project": project, "region": region}, fr, setLabels(labels), op)
+		return op, err
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := c.i.regionOperationsWait(project, region, op.Name); err != nil {
+		return err
+	}
+
+	var createdForwardingRule *compute.ForwardingRule
+	if createdForwardingRule, err = c.i.GetForwardingRule(project, region, fr.Name); err != nil {
+		return err
+	}
+	*fr = *createdForwardingRule
+	return nil
+}
+
 func (c *client) CreateFirewallRule(project string, i *compute.Firewall) error {
 	op, err := c.Retry(c.raw.Firewalls.Insert(project, i).Do)
 	if err != nil {
@@ -466,6 +715,73 @@ func (c *client) CreateImage(project string, i *compute.Image) error {
 	return nil
 }
 
+// CreateImageWithArchitecture creates a GCE image like CreateImage, setting
+// the image's architecture, e.g. "ARM64". The compute API version used by this
+// package has no Image.Architecture field, so the insert request is built
+// here instead of by the generated client.
+func (c *client) CreateImageWithArchitecture(project string, i *compute.Image, architecture string) error {
+	op, err := c.Retry(func(_ ...googleapi.CallOption) (*compute.Operation, error) {
+		return c.insertImageWithArchitecture(project, i, architecture)
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := c.i.globalOperationsWait(project, op.Name); err != nil {
+		return err
+	}
+
+	var createdImage *compute.Image
+	if createdImage, err = c.i.GetImage(project, i.Name); err != nil {
+		return err
+	}
+	*i = *createdImage
+	return nil
+}
+
+func (c *client) insertImageWithArchitecture(project string, i *compute.Image, architecture string) (*compute.Operation, error) {
+	op := &compute.Operation{}
+	err := c.insertRaw(c.raw.BasePath, "{project}/global/images", map[string]string{"project": project}, i, func(body map[string]interface{}) {
+		body["architecture"] = architecture
+	}, op)
+	return op, err
+}
+
+// insertRaw POSTs v, after setFields has added fields to its JSON, to the
+// insert method at relPath of the API at basePath and decodes the returned
+// operation into op.
+func (c *client) insertRaw(basePath, relPath string, params map[string]string, v json.Marshaler, setFields func(map[string]interface{}), op interface{}) error {
+	b, err := v.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(b, &body); err != nil {
+		return err
+	}
+	setFields(body)
+	if b, err = json.Marshal(body); err != nil {
+		return err
+	}
+
+	u := googleapi.ResolveRelative(basePath, relPath) + "?alt=json&prettyPrint=false"
+	req, err := http.NewRequest("POST", u, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	googleapi.Expand(req.URL, params)
+	req.Header.Set("Content-Type", "application/json")
+	res, err := c.hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer googleapi.CloseBody(res)
+	if err := googleapi.CheckResponse(res); err != nil {
+		return err
+	}
+	return json.NewDecoder(res.Body).Decode(op)
+}
+
 // CreateImageBeta creates a GCE image using Beta API.
 // Only one of sourceDisk or sourceFile must be specified, sourceDisk is the
 // url (full or partial) to the source disk, sourceFile is the full Google
@@ -525,6 +841,134 @@ func (c *client) CreateInstanceBeta(project, zone string, i *computeBeta.Instanc
 	return nil
 }
 
+// InstanceOptions are instance fields that the compute API version used by
+// this package has no field for.
+type InstanceOptions struct {
+	// TerminationAction sets Scheduling.InstanceTerminationAction, e.g. "DELETE".
+	TerminationAction string
+	// NetworkInterfaceStacks sets the IP stack of the instance's network
+	// interface at the same index.
+	NetworkInterfaceStacks []NetworkInterfaceStack
+	// ResourceManagerTags sets params.resourceManagerTags of the instance and
+	// initializeParams.resourceManagerTags of the disks created with it,
+	// mapping tag keys, e.g. "tagKeys/123", to tag values, e.g.
+	// "tagValues/456".
+	ResourceManagerTags map[string]string
+}
+
+// NetworkInterfaceStack is the IP stack of a network interface.
+type NetworkInterfaceStack struct {
+	// StackType is "IPV4_ONLY" or "IPV4_IPV6".
+	StackType string `json:",omitempty"`
+	// Ipv6AccessType is "INTERNAL" or "EXTERNAL". An EXTERNAL interface is
+	// given an external IPv6 address.
+	Ipv6AccessType string `json:",omitempty"`
+}
+
+// CreateInstanceWithTerminationAction creates a GCE instance like
+// CreateInstance, setting Scheduling.InstanceTerminationAction, e.g. "DELETE".
+func (c *client) CreateInstanceWithTerminationAction(project, zone string, i *compute.Instance, action string) error {
+	return c.CreateInstanceWithOptions(project, zone, i, InstanceOptions{TerminationAction: action})
+}
+
+// CreateInstanceBetaWithTerminationAction is CreateInstanceWithTerminationAction
+// using the Beta API.
+func (c *client) CreateInstanceBetaWithTerminationAction(project, zone string, i *computeBeta.Instance, action string) error {
+	return c.CreateInstanceBetaWithOptions(project, zone, i, InstanceOptions{TerminationAction: action})
+}
+
+// CreateInstanceWithOptions creates a GCE instance like CreateInstance,
+// setting the fields in opts. The compute API version used by this package
+// has no such fields, so the insert request is built here instead of by the
+// generated client.
+func (c *client) CreateInstanceWithOptions(project, zone string, i *compute.Instance, opts InstanceOptions) error {
+	op, err := c.Retry(func(_ ...googleapi.CallOption) (*compute.Operation, error) {
+		op := &compute.Operation{}
+		err := c.insertRaw(c.raw.BasePath, "{project}/zones/{zone}/instances", map[string]string{"project": project, "zone": zone}, i, setInstanceOptions(opts), op)
+		return op, err
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := c.i.zoneOperationsWait(project, zone, op.Name); err != nil {
+		return err
+	}
+
+	var createdInstance *compute.Instance
+	if createdInstance, err = c.i.GetInstance(project, zone, i.Name); err != nil {
+		return err
+	}
+	*i = *createdInstance
+	return nil
+}
+
+// CreateInstanceBetaWithOptions is CreateInstanceWithOptions using the Beta
+// API.
+func (c *client) CreateInstanceBetaWithOptions(project, zone string, i *computeBeta.Instance, opts InstanceOptions) error {
+	op, err := c.RetryBeta(func(_ ...googleapi.CallOption) (*computeBeta.Operation, error) {
+		op := &computeBeta.Operation{}
+		err := c.insertRaw(c.rawBeta.BasePath, "{project}/zones/{zone}/instances", map[string]string{"project": project, "zone": zone}, i, setInstanceOptions(opts), op)
+		return op, err
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := c.i.zoneOperationsWait(project, zone, op.Name); err != nil {
+		return err
+	}
+
+	var createdInstance *computeBeta.Instance
+	if createdInstance, err = c.i.GetInstanceBeta(project, zone, i.Name); err != nil {
+		return err
+	}
+	*i = *createdInstance
+	return nil
+}
+
+func setInstanceOptions(opts InstanceOptions) func(map[string]interface{}) {
+	return func(body map[string]interface{}) {
+		if opts.TerminationAction != "" {
+			scheduling, _ := body["scheduling"].(map[string]interface{})
+			if scheduling == nil {
+				scheduling = map[string]interface{}{}
+			}
+			scheduling["instanceTerminationAction"] = opts.TerminationAction
+			body["scheduling"] = scheduling
+		}
+		nics, _ := body["networkInterfaces"].([]interface{})
+		for idx, st := range opts.NetworkInterfaceStacks {
+			if idx >= len(nics) {
+				break
+			}
+			nic, _ := nics[idx].(map[string]interface{})
+			if nic == nil {
+				continue
+			}
+			if st.StackType != "" {
+				nic["stackType"] = st.StackType
+			}
+			if st.Ipv6AccessType != "" {
+				nic["ipv6AccessType"] = st.Ipv6AccessType
+			}
+			if st.Ipv6AccessType == "EXTERNAL" {
+				nic["ipv6AccessConfigs"] = []interface{}{map[string]interface{}{"name": "external-ipv6", "type": "DIRECT_IPV6"}}
+			}
+		}
+		if len(opts.ResourceManagerTags) > 0 {
+			body["params"] = map[string]interface{}{"resourceManagerTags": opts.ResourceManagerTags}
+			disks, _ := body["disks"].([]interface{})
+			for _, d := range disks {
+				d, _ := d.(map[string]interface{})
+				if ip, _ := d["initializeParams"].(map[string]interface{}); ip != nil {
+					ip["resourceManagerTags"] = opts.ResourceManagerTags
+				}
+			}
+		}
+	}
+}
+
 func (c *client) CreateNetwork(project string, n *compute.Network) error {
 	op, err := c.Retry(c.raw.Networks.Insert(project, n).Do)
 	if err != nil {
@@ -581,6 +1025,25 @@ func (c *client) CreateTargetInstance(project, zone string, ti *compute.TargetIn
 	return nil
 }
 
+// CreateInstanceGroup creates a GCE unmanaged instance group.
+func (c *client) CreateInstanceGroup(project, zone string, ig *compute.InstanceGroup) error {
+	op, err := c.Retry(c.raw.InstanceGroups.Insert(project, zone, ig).Do)
+	if err != nil {
+		return err
+	}
+
+	if err := c.i.zoneOperationsWait(project, zone, op.Name); err != nil {
+		return err
+	}
+
+	var createdInstanceGroup *compute.InstanceGroup
+	if createdInstanceGroup, err = c.i.GetInstanceGroup(project, zone, ig.Name); err != nil {
+		return err
+	}
+	*ig = *createdInstanceGroup
+	return nil
+}
+
 // DeleteFirewallRule deletes a GCE FirewallRule.
 func (c *client) DeleteFirewallRule(project, name string) error {
 	op, err := c.Retry(c.raw.Firewalls.Delete(project, name).Do)
@@ -621,6 +1084,16 @@ func (c *client) SetDiskAutoDelete(project, zone, instance string, autoDelete bo
 	return c.i.zoneOperationsWait(project, zone, op.Name)
 }
 
+// DeleteAddress deletes a GCE Address.
+func (c *client) DeleteAddress(project, region, name string) error {
+	op, err := c.Retry(c.raw.Addresses.Delete(project, region, name).Do)
+	if err != nil {
+		return err
+	}
+
+	return c.i.regionOperationsWait(project, region, op.Name)
+}
+
 // DeleteForwardingRule deletes a GCE ForwardingRule.
 func (c *client) DeleteForwardingRule(project, region, name string) error {
 	op, err := c.Retry(c.raw.ForwardingRules.Delete(project, region, name).Do)
@@ -661,6 +1134,17 @@ func (c *client) StopInstance(project, zone, name string) error {
 	return c.i.zoneOperationsWait(project, zone, op.Name)
 }
 
+// SetMachineType changes the machine type of a stopped GCE instance.
+func (c *client) SetMachineType(project, zone, name, machineType string) error {
+	req := &compute.InstancesSetMachineTypeRequest{MachineType: machineType}
+	op, err := c.Retry(c.raw.Instances.SetMachineType(project, zone, name, req).Do)
+	if err != nil {
+		return err
+	}
+
+	return c.i.zoneOperationsWait(project, zone, op.Name)
+}
+
 // DeleteNetwork deletes a GCE network.
 func (c *client) DeleteNetwork(project, name string) error {
 	op, err := c.Retry(c.raw.Networks.Delete(project, name).Do)
@@ -691,6 +1175,16 @@ func (c *client) DeleteTargetInstance(project, zone, name string) error {
 	return c.i.zoneOperationsWait(project, zone, op.Name)
 }
 
+// DeleteInstanceGroup deletes a GCE unmanaged instance group.
+func (c *client) DeleteInstanceGroup(project, zone, name string) error {
+	op, err := c.Retry(c.raw.InstanceGroups.Delete(project, zone, name).Do)
+	if err != nil {
+		return err
+	}
+
+	return c.i.zoneOperationsWait(project, zone, op.Name)
+}
+
 // DeprecateImage sets deprecation status on a GCE image.
 func (c *client) DeprecateImage(project, name string, deprecationstatus *compute.DeprecationStatus) error {
 	op, err := c.Retry(c.raw.Images.Deprecate(project, name, deprecationstatus).Do)
@@ -704,7 +1198,7 @@ func (c *client) DeprecateImage(project, name string, deprecationstatus *compute
 // GetMachineType gets a GCE MachineType.
 func (c *client) GetMachineType(project, zone, machineType string) (*compute.MachineType, error) {
 	mt, err := c.raw.MachineTypes.Get(project, zone, machineType).Do()
-	if shouldRetryWithWait(c.hc.Transport, err, 2) {
+	if shouldRetryWithWait(c.hc.Transport, err, 2, c.isRetriable) {
 		return c.raw.MachineTypes.Get(project, zone, machineType).Do()
 	}
 	return mt, err
@@ -719,7 +1213,7 @@ func (c *client) ListMachineTypes(project, zone string, opts ...ListCallOption)
 		call = opt.listCallOptionApply(call).(*compute.MachineTypesListCall)
 	}
 	for mtl, err := call.PageToken(pt).Do(); ; mtl, err = call.PageToken(pt).Do() {
-		if shouldRetryWithWait(c.hc.Transport, err, 2) {
+		if shouldRetryWithWait(c.hc.Transport, err, 2, c.isRetriable) {
 			mtl, err = call.PageToken(pt).Do()
 		}
 		if err != nil {
@@ -737,7 +1231,7 @@ func (c *client) ListMachineTypes(project, zone string, opts ...ListCallOption)
 // GetProject gets a GCE Project.
 func (c *client) GetProject(project string) (*compute.Project, error) {
 	p, err := c.raw.Projects.Get(project).Do()
-	if shouldRetryWithWait(c.hc.Transport, err, 2) {
+	if shouldRetryWithWait(c.hc.Transport, err, 2, c.isRetriable) {
 		return c.raw.Projects.Get(project).Do()
 	}
 	return p, err
@@ -746,16 +1240,52 @@ func (c *client) GetProject(project string) (*compute.Project, error) {
 // GetSerialPortOutput gets the serial port output of a GCE instance.
 func (c *client) GetSerialPortOutput(project, zone, name string, port, start int64) (*compute.SerialPortOutput, error) {
 	sp, err := c.raw.Instances.GetSerialPortOutput(project, zone, name).Start(start).Port(port).Do()
-	if shouldRetryWithWait(c.hc.Transport, err, 2) {
+	if shouldRetryWithWait(c.hc.Transport, err, 2, c.isRetriable) {
 		return c.raw.Instances.GetSerialPortOutput(project, zone, name).Start(start).Port(port).Do()
 	}
 	return sp, err
 }
 
+// GetScreenshot gets a PNG screenshot of the display of a GCE instance. The
+// instance needs a display device enabled.
+func (c *client) GetScreenshot(project, zone, name string) ([]byte, error) {
+	data, err := c.getScreenshot(project, zone, name)
+	if shouldRetryWithWait(c.hc.Transport, err, 2, c.isRetriable) {
+		return c.getScreenshot(project, zone, name)
+	}
+	return data, err
+}
+
+// getScreenshot is made without the generated client, whose version has no
+// getScreenshot method.
+func (c *client) getScreenshot(project, zone, name string) ([]byte, error) {
+	u := googleapi.ResolveRelative(c.raw.BasePath, "{project}/zones/{zone}/instances/{instance}/screenshot") + "?alt=json&prettyPrint=false"
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	googleapi.Expand(req.URL, map[string]string{"project": project, "zone": zone, "instance": name})
+	res, err := c.hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer googleapi.CloseBody(res)
+	if err := googleapi.CheckResponse(res); err != nil {
+		return nil, err
+	}
+	var screenshot struct {
+		Contents string `json:"contents"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&screenshot); err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(screenshot.Contents)
+}
+
 // GetZone gets a GCE Zone.
 func (c *client) GetZone(project, zone string) (*compute.Zone, error) {
 	z, err := c.raw.Zones.Get(project, zone).Do()
-	if shouldRetryWithWait(c.hc.Transport, err, 2) {
+	if shouldRetryWithWait(c.hc.Transport, err, 2, c.isRetriable) {
 		return c.raw.Zones.Get(project, zone).Do()
 	}
 	return z, err
@@ -770,7 +1300,7 @@ func (c *client) ListZones(project string, opts ...ListCallOption) ([]*compute.Z
 		call = opt.listCallOptionApply(call).(*compute.ZonesListCall)
 	}
 	for zl, err := call.PageToken(pt).Do(); ; zl, err = call.PageToken(pt).Do() {
-		if shouldRetryWithWait(c.hc.Transport, err, 2) {
+		if shouldRetryWithWait(c.hc.Transport, err, 2, c.isRetriable) {
 			zl, err = call.PageToken(pt).Do()
 		}
 		if err != nil {
@@ -785,6 +1315,15 @@ func (c *client) ListZones(project string, opts ...ListCallOption) ([]*compute.Z
 	}
 }
 
+// GetRegion gets a GCE Region.
+func (c *client) GetRegion(project, region string) (*compute.Region, error) {
+	r, err := c.raw.Regions.Get(project, region).Do()
+	if shouldRetryWithWait(c.hc.Transport, err, 2, c.isRetriable) {
+		return c.raw.Regions.Get(project, region).Do()
+	}
+	return r, err
+}
+
 // ListRegions gets a list GCE Regions.
 func (c *client) ListRegions(project string, opts ...ListCallOption) ([]*compute.Region, error) {
 	var rs []*compute.Region
@@ -794,7 +1333,7 @@ func (c *client) ListRegions(project string, opts ...ListCallOption) ([]*compute
 		call = opt.listCallOptionApply(call).(*compute.RegionsListCall)
 	}
 	for rl, err := call.PageToken(pt).Do(); ; rl, err = call.PageToken(pt).Do() {
-		if shouldRetryWithWait(c.hc.Transport, err, 2) {
+		if shouldRetryWithWait(c.hc.Transport, err, 2, c.isRetriable) {
 			rl, err = call.PageToken(pt).Do()
 		}
 		if err != nil {
@@ -812,7 +1351,7 @@ func (c *client) ListRegions(project string, opts ...ListCallOption) ([]*compute
 // GetInstance gets a GCE Instance using GA API.
 func (c *client) GetInstance(project, zone, name string) (*compute.Instance, error) {
 	i, err := c.raw.Instances.Get(project, zone, name).Do()
-	if shouldRetryWithWait(c.hc.Transport, err, 2) {
+	if shouldRetryWithWait(c.hc.Transport, err, 2, c.isRetriable) {
 		return c.raw.Instances.Get(project, zone, name).Do()
 	}
 	return i, err
@@ -821,7 +1360,7 @@ func (c *client) GetInstance(project, zone, name string) (*compute.Instance, err
 // GetInstance gets a GCE Instance using GA API.
 func (c *client) GetInstanceBeta(project, zone, name string) (*computeBeta.Instance, error) {
 	i, err := c.rawBeta.Instances.Get(project, zone, name).Do()
-	if shouldRetryWithWait(c.hc.Transport, err, 2) {
+	if shouldRetryWithWait(c.hc.Transport, err, 2, c.isRetriable) {
 		return c.rawBeta.Instances.Get(project, zone, name).Do()
 	}
 	return i, err
@@ -836,7 +1375,7 @@ func (c *client) AggregatedListInstances(project string, opts ...ListCallOption)
 		call = opt.listCallOptionApply(call).(*compute.InstancesAggregatedListCall)
 	}
 	for ial, err := call.PageToken(pt).Do(); ; ial, err = call.PageToken(pt).Do() {
-		if shouldRetryWithWait(c.hc.Transport, err, 2) {
+		if shouldRetryWithWait(c.hc.Transport, err, 2, c.isRetriable) {
 			ial, err = call.PageToken(pt).Do()
 		}
 		if err != nil {
@@ -861,7 +1400,7 @@ func (c *client) ListInstances(project, zone string, opts ...ListCallOption) ([]
 		call = opt.listCallOptionApply(call).(*compute.InstancesListCall)
 	}
 	for il, err := call.PageToken(pt).Do(); ; il, err = call.PageToken(pt).Do() {
-		if shouldRetryWithWait(c.hc.Transport, err, 2) {
+		if shouldRetryWithWait(c.hc.Transport, err, 2, c.isRetriable) {
 			il, err = call.PageToken(pt).Do()
 		}
 		if err != nil {
@@ -879,12 +1418,21 @@ func (c *client) ListInstances(project, zone string, opts ...ListCallOption) ([]
 // GetDisk gets a GCE Disk.
 func (c *client) GetDisk(project, zone, name string) (*compute.Disk, error) {
 	d, err := c.raw.Disks.Get(project, zone, name).Do()
-	if shouldRetryWithWait(c.hc.Transport, err, 2) {
+	if shouldRetryWithWait(c.hc.Transport, err, 2, c.isRetriable) {
 		return c.raw.Disks.Get(project, zone, name).Do()
 	}
 	return d, err
 }
 
+// GetRegionDisk gets a GCE regional Disk.
+func (c *client) GetRegionDisk(project, region, name string) (*compute.Disk, error) {
+	d, err := c.raw.RegionDisks.Get(project, region, name).Do()
+	if shouldRetryWithWait(c.hc.Transport, err, 2, c.isRetriable) {
+		return c.raw.RegionDisks.Get(project, region, name).Do()
+	}
+	return d, err
+}
+
 // AggregatedListDisks gets an aggregated list of GCE Disks.
 func (c *client) AggregatedListDisks(project string, opts ...ListCallOption) ([]*compute.Disk, error) {
 	var is []*compute.Disk
@@ -894,7 +1442,7 @@ func (c *client) AggregatedListDisks(project string, opts ...ListCallOption) ([]
 		call = opt.listCallOptionApply(call).(*compute.DisksAggregatedListCall)
 	}
 	for ial, err := call.PageToken(pt).Do(); ; ial, err = call.PageToken(pt).Do() {
-		if shouldRetryWithWait(c.hc.Transport, err, 2) {
+		if shouldRetryWithWait(c.hc.Transport, err, 2, c.isRetriable) {
 			ial, err = call.PageToken(pt).Do()
 		}
 		if err != nil {
@@ -919,7 +1467,7 @@ func (c *client) ListDisks(project, zone string, opts ...ListCallOption) ([]*com
 		call = opt.listCallOptionApply(call).(*compute.DisksListCall)
 	}
 	for dl, err := call.PageToken(pt).Do(); ; dl, err = call.PageToken(pt).Do() {
-		if shouldRetryWithWait(c.hc.Transport, err, 2) {
+		if shouldRetryWithWait(c.hc.Transport, err, 2, c.isRetriable) {
 			dl, err = call.PageToken(pt).Do()
 		}
 		if err != nil {
@@ -934,15 +1482,99 @@ func (c *client) ListDisks(project, zone string, opts ...ListCallOption) ([]*com
 	}
 }
 
+// GetAddress gets a GCE Address.
+func (c *client) GetAddress(project, region, name string) (*compute.Address, error) {
+	n, err := c.raw.Addresses.Get(project, region, name).Do()
+	if shouldRetryWithWait(c.hc.Transport, err, 2, c.isRetriable) {
+		return c.raw.Addresses.Get(project, region, name).Do()
+	}
+	return n, err
+}
+
 // GetForwardingRule gets a GCE ForwardingRule.
 func (c *client) GetForwardingRule(project, region, name string) (*compute.ForwardingRule, error) {
 	n, err := c.raw.ForwardingRules.Get(project, region, name).Do()
-	if shouldRetryWithWait(c.hc.Transport, err, 2) {
+	if shouldRetryWithWait(c.hc.Transport, err, 2, c.isRetriable) {
 		return c.raw.ForwardingRules.Get(project, region, name).Do()
 	}
 	return n, err
 }
 
+// AggregatedListAddresses gets an aggregated list of GCE Addresses.
+func (c *client) AggregatedListAddresses(project string, opts ...ListCallOption) ([]*compute.Address, error) {
+	var as []*compute.Address
+	var pt string
+	call := c.raw.Addresses.AggregatedList(project)
+	for _, opt := range opts {
+		call = opt.listCallOptionApply(call).(*compute.AddressesAggregatedListCall)
+	}
+	for aal, err := call.PageToken(pt).Do(); ; aal, err = call.PageToken(pt).Do() {
+		if shouldRetryWithWait(c.hc.Transport, err, 2, c.isRetriable) {
+			aal, err = call.PageToken(pt).Do()
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, asl := range aal.Items {
+			as = append(as, asl.Addresses...)
+		}
+		if aal.NextPageToken == "" {
+			return as, nil
+		}
+		pt = aal.NextPageToken
+	}
+}
+
+// ListAddresses gets a list of GCE Addresses.
+func (c *client) ListAddresses(project, region string, opts ...ListCallOption) ([]*compute.Address, error) {
+	var as []*compute.Address
+	var pt string
+	call := c.raw.Addresses.List(project, region)
+	for _, opt := range opts {
+		call = opt.listCallOptionApply(call).(*compute.AddressesListCall)
+	}
+	for al, err := call.PageToken(pt).Do(); ; al, err = call.PageToken(pt).Do() {
+		if shouldRetryWithWait(c.hc.Transport, err, 2, c.isRetriable) {
+			al, err = call.PageToken(pt).Do()
+		}
+		if err != nil {
+			return nil, err
+		}
+		as = append(as, al.Items...)
+
+		if al.NextPageToken == "" {
+			return as, nil
+		}
+		pt = al.NextPageToken
+	}
+}
+
+// AggregatedListForwardingRules gets an aggregated list of GCE
+// ForwardingRules.
+func (c *client) AggregatedListForwardingRules(project string, opts ...ListCallOption) ([]*compute.ForwardingRule, error) {
+	var frs []*compute.ForwardingRule
+	var pt string
+	call := c.raw.ForwardingRules.AggregatedList(project)
+	for _, opt := range opts {
+		call = opt.listCallOptionApply(call).(*compute.ForwardingRulesAggregatedListCall)
+	}
+	for fral, err := call.PageToken(pt).Do(); ; fral, err = call.PageToken(pt).Do() {
+		if shouldRetryWithWait(c.hc.Transport, err, 2, c.isRetriable) {
+			fral, err = call.PageToken(pt).Do()
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, frsl := range fral.Items {
+			frs = append(frs, frsl.ForwardingRules...)
+		}
+		if fral.NextPageToken == "" {
+			return frs, nil
+		}
+		pt = fral.NextPageToken
+	}
+}
+
 // ListForwardingRules gets a list of GCE ForwardingRules.
 func (c *client) ListForwardingRules(project, region string, opts ...ListCallOption) ([]*compute.ForwardingRule, error) {
 	var frs []*compute.ForwardingRule
@@ -952,7 +1584,7 @@ func (c *client) ListForwardingRules(project, region string, opts ...ListCallOpt
 		call = opt.listCallOptionApply(call).(*compute.ForwardingRulesListCall)
 	}
 	for frl, err := call.PageToken(pt).Do(); ; frl, err = call.PageToken(pt).Do() {
-		if shouldRetryWithWait(c.hc.Transport, err, 2) {
+		if shouldRetryWithWait(c.hc.Transport, err, 2, c.isRetriable) {
 			frl, err = call.PageToken(pt).Do()
 		}
 		if err != nil {
@@ -970,7 +1602,7 @@ func (c *client) ListForwardingRules(project, region string, opts ...ListCallOpt
 // GetFirewallRule gets a GCE FirewallRule.
 func (c *client) GetFirewallRule(project, name string) (*compute.Firewall, error) {
 	i, err := c.raw.Firewalls.Get(project, name).Do()
-	if shouldRetryWithWait(c.hc.Transport, err, 2) {
+	if shouldRetryWithWait(c.hc.Transport, err, 2, c.isRetriable) {
 		return c.raw.Firewalls.Get(project, name).Do()
 	}
 	return i, err
@@ -985,7 +1617,7 @@ func (c *client) ListFirewallRules(project string, opts ...ListCallOption) ([]*c
 		call = opt.listCallOptionApply(call).(*compute.FirewallsListCall)
 	}
 	for il, err := call.PageToken(pt).Do(); ; il, err = call.PageToken(pt).Do() {
-		if shouldRetryWithWait(c.hc.Transport, err, 2) {
+		if shouldRetryWithWait(c.hc.Transport, err, 2, c.isRetriable) {
 			il, err = call.PageToken(pt).Do()
 		}
 		if err != nil {
@@ -1003,7 +1635,7 @@ func (c *client) ListFirewallRules(project string, opts ...ListCallOption) ([]*c
 // GetImage gets a GCE Image.
 func (c *client) GetImage(project, name string) (*compute.Image, error) {
 	i, err := c.raw.Images.Get(project, name).Do()
-	if shouldRetryWithWait(c.hc.Transport, err, 2) {
+	if shouldRetryWithWait(c.hc.Transport, err, 2, c.isRetriable) {
 		return c.raw.Images.Get(project, name).Do()
 	}
 	return i, err
@@ -1012,7 +1644,7 @@ func (c *client) GetImage(project, name string) (*compute.Image, error) {
 // GetImageBeta gets a GCE Image using Beta API
 func (c *client) GetImageBeta(project, name string) (*computeBeta.Image, error) {
 	i, err := c.rawBeta.Images.Get(project, name).Do()
-	if shouldRetryWithWait(c.hc.Transport, err, 2) {
+	if shouldRetryWithWait(c.hc.Transport, err, 2, c.isRetriable) {
 		return c.rawBeta.Images.Get(project, name).Do()
 	}
 	return i, err
@@ -1021,7 +1653,7 @@ func (c *client) GetImageBeta(project, name string) (*computeBeta.Image, error)
 // GetImageFromFamily gets a GCE Image from an image family.
 func (c *client) GetImageFromFamily(project, family string) (*compute.Image, error) {
 	i, err := c.raw.Images.GetFromFamily(project, family).Do()
-	if shouldRetryWithWait(c.hc.Transport, err, 2) {
+	if shouldRetryWithWait(c.hc.Transport, err, 2, c.isRetriable) {
 		return c.raw.Images.GetFromFamily(project, family).Do()
 	}
 	return i, err
@@ -1036,7 +1668,7 @@ func (c *client) ListImages(project string, opts ...ListCallOption) ([]*compute.
 		call = opt.listCallOptionApply(call).(*compute.ImagesListCall)
 	}
 	for il, err := call.PageToken(pt).Do(); ; il, err = call.PageToken(pt).Do() {
-		if shouldRetryWithWait(c.hc.Transport, err, 2) {
+		if shouldRetryWithWait(c.hc.Transport, err, 2, c.isRetriable) {
 			il, err = call.PageToken(pt).Do()
 		}
 		if err != nil {
@@ -1051,10 +1683,31 @@ func (c *client) ListImages(project string, opts ...ListCallOption) ([]*compute.
 	}
 }
 
+// CreateSnapshot creates a GCE snapshot of a zonal disk. If guestFlush is
+// set, the guest agent on the instance the disk is attached to flushes the
+// disk's buffers before the snapshot is taken.
+func (c *client) CreateSnapshot(project, zone, disk string, s *compute.Snapshot, guestFlush bool) error {
+	op, err := c.Retry(c.raw.Disks.CreateSnapshot(project, zone, disk, s).GuestFlush(guestFlush).Do)
+	if err != nil {
+		return err
+	}
+
+	if err := c.i.zoneOperationsWait(project, zone, op.Name); err != nil {
+		return err
+	}
+
+	var createdSnapshot *compute.Snapshot
+	if createdSnapshot, err = c.i.GetSnapshot(project, s.Name); err != nil {
+		return err
+	}
+	*s = *createdSnapshot
+	return nil
+}
+
 // GetSnapshot gets a GCE Snapshot.
 func (c *client) GetSnapshot(project, name string) (*compute.Snapshot, error) {
 	n, err := c.raw.Snapshots.Get(project, name).Do()
-	if shouldRetryWithWait(c.hc.Transport, err, 2) {
+	if shouldRetryWithWait(c.hc.Transport, err, 2, c.isRetriable) {
 		return c.raw.Snapshots.Get(project, name).Do()
 	}
 	return n, err
@@ -1079,7 +1732,7 @@ func (c *client) ListSnapshots(project string, opts ...ListCallOption) ([]*compu
 		call = opt.listCallOptionApply(call).(*compute.SnapshotsListCall)
 	}
 	for sl, err := call.PageToken(pt).Do(); ; sl, err = call.PageToken(pt).Do() {
-		if shouldRetryWithWait(c.hc.Transport, err, 2) {
+		if shouldRetryWithWait(c.hc.Transport, err, 2, c.isRetriable) {
 			sl, err = call.PageToken(pt).Do()
 		}
 		if err != nil {
@@ -1097,7 +1750,7 @@ func (c *client) ListSnapshots(project string, opts ...ListCallOption) ([]*compu
 // GetNetwork gets a GCE Network.
 func (c *client) GetNetwork(project, name string) (*compute.Network, error) {
 	n, err := c.raw.Networks.Get(project, name).Do()
-	if shouldRetryWithWait(c.hc.Transport, err, 2) {
+	if shouldRetryWithWait(c.hc.Transport, err, 2, c.isRetriable) {
 		return c.raw.Networks.Get(project, name).Do()
 	}
 	return n, err
@@ -1112,7 +1765,7 @@ func (c *client) ListNetworks(project string, opts ...ListCallOption) ([]*comput
 		call = opt.listCallOptionApply(call).(*compute.NetworksListCall)
 	}
 	for nl, err := call.PageToken(pt).Do(); ; nl, err = call.PageToken(pt).Do() {
-		if shouldRetryWithWait(c.hc.Transport, err, 2) {
+		if shouldRetryWithWait(c.hc.Transport, err, 2, c.isRetriable) {
 			nl, err = call.PageToken(pt).Do()
 		}
 		if err != nil {
@@ -1130,7 +1783,7 @@ func (c *client) ListNetworks(project string, opts ...ListCallOption) ([]*comput
 // GetSubnetwork gets a GCE subnetwork.
 func (c *client) GetSubnetwork(project, region, name string) (*compute.Subnetwork, error) {
 	n, err := c.raw.Subnetworks.Get(project, region, name).Do()
-	if shouldRetryWithWait(c.hc.Transport, err, 2) {
+	if shouldRetryWithWait(c.hc.Transport, err, 2, c.isRetriable) {
 		return c.raw.Subnetworks.Get(project, region, name).Do()
 	}
 	return n, err
@@ -1145,7 +1798,7 @@ func (c *client) AggregatedListSubnetworks(project string, opts ...ListCallOptio
 		call = opt.listCallOptionApply(call).(*compute.SubnetworksAggregatedListCall)
 	}
 	for sal, err := call.PageToken(pt).Do(); ; sal, err = call.PageToken(pt).Do() {
-		if shouldRetryWithWait(c.hc.Transport, err, 2) {
+		if shouldRetryWithWait(c.hc.Transport, err, 2, c.isRetriable) {
 			sal, err = call.PageToken(pt).Do()
 		}
 		if err != nil {
@@ -1170,7 +1823,7 @@ func (c *client) ListSubnetworks(project, region string, opts ...ListCallOption)
 		call = opt.listCallOptionApply(call).(*compute.SubnetworksListCall)
 	}
 	for nl, err := call.PageToken(pt).Do(); ; nl, err = call.PageToken(pt).Do() {
-		if shouldRetryWithWait(c.hc.Transport, err, 2) {
+		if shouldRetryWithWait(c.hc.Transport, err, 2, c.isRetriable) {
 			nl, err = call.PageToken(pt).Do()
 		}
 		if err != nil {
@@ -1188,12 +1841,53 @@ func (c *client) ListSubnetworks(project, region string, opts ...ListCallOption)
 // GetTargetInstance gets a GCE TargetInstance.
 func (c *client) GetTargetInstance(project, zone, name string) (*compute.TargetInstance, error) {
 	n, err := c.raw.TargetInstances.Get(project, zone, name).Do()
-	if shouldRetryWithWait(c.hc.Transport, err, 2) {
+	if shouldRetryWithWait(c.hc.Transport, err, 2, c.isRetriable) {
 		return c.raw.TargetInstances.Get(project, zone, name).Do()
 	}
 	return n, err
 }
 
+// GetInstanceGroup gets a GCE unmanaged instance group.
+func (c *client) GetInstanceGroup(project, zone, name string) (*compute.InstanceGroup, error) {
+	ig, err := c.raw.InstanceGroups.Get(project, zone, name).Do()
+	if shouldRetryWithWait(c.hc.Transport, err, 2, c.isRetriable) {
+		return c.raw.InstanceGroups.Get(project, zone, name).Do()
+	}
+	return ig, err
+}
+
+func instanceReferences(instances []string) []*compute.InstanceReference {
+	var refs []*compute.InstanceReference
+	for _, i := range instances {
+		refs = append(refs, &compute.InstanceReference{Instance: i})
+	}
+	return refs
+}
+
+// AddInstanceGroupInstances adds instances, given by URL, to a GCE unmanaged
+// instance group.
+func (c *client) AddInstanceGroupInstances(project, zone, name string, instances []string) error {
+	req := &compute.InstanceGroupsAddInstancesRequest{Instances: instanceReferences(instances)}
+	op, err := c.Retry(c.raw.InstanceGroups.AddInstances(project, zone, name, req).Do)
+	if err != nil {
+		return err
+	}
+
+	return c.i.zoneOperationsWait(project, zone, op.Name)
+}
+
+// RemoveInstanceGroupInstances removes instances, given by URL, from a GCE
+// unmanaged instance group.
+func (c *client) RemoveInstanceGroupInstances(project, zone, name string, instances []string) error {
+	req := &compute.InstanceGroupsRemoveInstancesRequest{Instances: instanceReferences(instances)}
+	op, err := c.Retry(c.raw.InstanceGroups.RemoveInstances(project, zone, name, req).Do)
+	if err != nil {
+		return err
+	}
+
+	return c.i.zoneOperationsWait(project, zone, op.Name)
+}
+
 // ListTargetInstances gets a list of GCE TargetInstances.
 func (c *client) ListTargetInstances(project, zone string, opts ...ListCallOption) ([]*compute.TargetInstance, error) {
 	var tis []*compute.TargetInstance
@@ -1203,7 +1897,7 @@ func (c *client) ListTargetInstances(project, zone string, opts ...ListCallOptio
 		call = opt.listCallOptionApply(call).(*compute.TargetInstancesListCall)
 	}
 	for til, err := call.PageToken(pt).Do(); ; til, err = call.PageToken(pt).Do() {
-		if shouldRetryWithWait(c.hc.Transport, err, 2) {
+		if shouldRetryWithWait(c.hc.Transport, err, 2, c.isRetriable) {
 			til, err = call.PageToken(pt).Do()
 		}
 		if err != nil {
@@ -1221,7 +1915,7 @@ func (c *client) ListTargetInstances(project, zone string, opts ...ListCallOptio
 // GetLicense gets a GCE License.
 func (c *client) GetLicense(project, name string) (*compute.License, error) {
 	l, err := c.raw.Licenses.Get(project, name).Do()
-	if shouldRetryWithWait(c.hc.Transport, err, 2) {
+	if shouldRetryWithWait(c.hc.Transport, err, 2, c.isRetriable) {
 		return c.raw.Licenses.Get(project, name).Do()
 	}
 	return l, err
@@ -1236,7 +1930,7 @@ func (c *client) ListLicenses(project string, opts ...ListCallOption) ([]*comput
 		call = opt.listCallOptionApply(call).(*compute.LicensesListCall)
 	}
 	for ll, err := call.PageToken(pt).Do(); ; ll, err = call.PageToken(pt).Do() {
-		if shouldRetryWithWait(c.hc.Transport, err, 2) {
+		if shouldRetryWithWait(c.hc.Transport, err, 2, c.isRetriable) {
 			ll, err = call.PageToken(pt).Do()
 		}
 		if err != nil {
@@ -1254,7 +1948,7 @@ func (c *client) ListLicenses(project string, opts ...ListCallOption) ([]*comput
 // InstanceStatus returns an instances Status.
 func (c *client) InstanceStatus(project, zone, name string) (string, error) {
 	is, err := c.raw.Instances.Get(project, zone, name).Do()
-	if shouldRetryWithWait(c.hc.Transport, err, 2) {
+	if shouldRetryWithWait(c.hc.Transport, err, 2, c.isRetriable) {
 		is, err = c.raw.Instances.Get(project, zone, name).Do()
 	}
 
@@ -1265,6 +1959,7 @@ func (c *client) InstanceStatus(project, zone, name string) (string, error) {
 }
 
 // InstanceStopped checks if a GCE instance is in a 'TERMINATED' or 'STOPPED' state.
+// A suspended instance is reported as an error as it won't stop by itself.
 func (c *client) InstanceStopped(project, zone, name string) (bool, error) {
 	status, err := c.i.InstanceStatus(project, zone, name)
 	if err != nil {
@@ -1275,11 +1970,31 @@ func (c *client) InstanceStopped(project, zone, name string) (bool, error) {
 		return false, nil
 	case "TERMINATED", "STOPPED":
 		return true, nil
+	case "SUSPENDING", "SUSPENDED":
+		return false, fmt.Errorf("instance is %s, it won't stop until it's resumed", status)
 	default:
 		return false, fmt.Errorf("unexpected instance status %q", status)
 	}
 }
 
+// InstancePreempted checks if GCE preempted a GCE instance, by looking for a
+// compute.instances.preempted operation targeting it.
+func (c *client) InstancePreempted(project, zone, name string) (bool, error) {
+	i, err := c.i.GetInstance(project, zone, name)
+	if err != nil {
+		return false, err
+	}
+	call := c.raw.ZoneOperations.List(project, zone).Filter(fmt.Sprintf(`(operationType = "compute.instances.preempted") AND (targetId = %d)`, i.Id))
+	ol, err := call.Do()
+	if shouldRetryWithWait(c.hc.Transport, err, 2, c.isRetriable) {
+		ol, err = call.Do()
+	}
+	if err != nil {
+		return false, err
+	}
+	return len(ol.Items) > 0, nil
+}
+
 // ResizeDisk resizes a GCE persistent disk. You can only increase the size of the disk.
 func (c *client) ResizeDisk(project, zone, disk string, drr *compute.DisksResizeRequest) error {
 	op, err := c.Retry(c.raw.Disks.Resize(project, zone, disk, drr).Do)
@@ -1319,7 +2034,7 @@ func (c *client) GetGuestAttributes(project, zone, name, queryPath, variableKey
 		call = call.VariableKey(variableKey)
 	}
 	a, err := call.Do()
-	if shouldRetryWithWait(c.hc.Transport, err, 2) {
+	if shouldRetryWithWait(c.hc.Transport, err, 2, c.isRetriable) {
 		return call.Do()
 	}
 	return a, err
@@ -1334,7 +2049,7 @@ func (c *client) ListMachineImages(project string, opts ...ListCallOption) ([]*c
 		call = opt.listCallOptionApply(call).(*computeBeta.MachineImagesListCall)
 	}
 	for il, err := call.PageToken(pt).Do(); ; il, err = call.PageToken(pt).Do() {
-		if shouldRetryWithWait(c.hc.Transport, err, 2) {
+		if shouldRetryWithWait(c.hc.Transport, err, 2, c.isRetriable) {
 			il, err = call.PageToken(pt).Do()
 		}
 		if err != nil {
@@ -1383,7 +2098,7 @@ func (c *client) CreateMachineImage(project string, mi *computeBeta.MachineImage
 // GetMachineImage gets a GCE Machine Image using Beta API
 func (c *client) GetMachineImage(project, name string) (*computeBeta.MachineImage, error) {
 	i, err := c.rawBeta.MachineImages.Get(project, name).Do()
-	if shouldRetryWithWait(c.hc.Transport, err, 2) {
+	if shouldRetryWithWait(c.hc.Transport, err, 2, c.isRetriable) {
 		return c.rawBeta.MachineImages.Get(project, name).Do()
 	}
 	return i, err
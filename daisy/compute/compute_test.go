@@ -37,6 +37,7 @@ var (
 	testDisk2                = "test-disk2"
 	testResize         int64 = 128
 	testForwardingRule       = "test-forwarding-rule"
+	testAddress              = "test-address"
 	testFirewallRule         = "test-firewall-rule"
 	testImage                = "test-image"
 	testImageBeta            = "test-image-beta"
@@ -46,6 +47,7 @@ var (
 	testNetwork              = "test-network"
 	testSubnetwork           = "test-subnetwork"
 	testTargetInstance       = "test-target-instance"
+	testInstanceGroup        = "test-instance-group"
 )
 
 func TestShouldRetryWithWait(t *testing.T) {
@@ -64,12 +66,114 @@ func TestShouldRetryWithWait(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		if got := shouldRetryWithWait(nil, tt.err, 0); got != tt.want {
+		if got := shouldRetryWithWait(nil, tt.err, 0, nil); got != tt.want {
 			t.Errorf("%s case: shouldRetryWithWait == %t, want %t", tt.desc, got, tt.want)
 		}
 	}
 }
 
+func TestShouldRetryWithWaitIsRetriable(t *testing.T) {
+	// Quota errors are retried, server errors aren't.
+	isRetriable := func(err error) bool {
+		apiErr, ok := err.(*googleapi.Error)
+		return ok && apiErr.Code == 403
+	}
+	tests := []struct {
+		desc string
+		err  error
+		want bool
+	}{
+		{"nil error", nil, false},
+		{"403 error", &googleapi.Error{Code: 403}, true},
+		{"500 error", &googleapi.Error{Code: 500}, false},
+	}
+
+	for _, tt := range tests {
+		if got := shouldRetryWithWait(nil, tt.err, 0, isRetriable); got != tt.want {
+			t.Errorf("%s case: shouldRetryWithWait == %t, want %t", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestSetIsRetriable(t *testing.T) {
+	_, c, err := NewTestClient(func(w http.ResponseWriter, r *http.Request) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var classified []error
+	SetIsRetriable(c, func(err error) bool {
+		classified = append(classified, err)
+		return true
+	})
+
+	calls := 0
+	wantErr := &googleapi.Error{Code: 403}
+	_, err = c.Retry(func(_ ...googleapi.CallOption) (*compute.Operation, error) {
+		calls++
+		if calls == 1 {
+			return nil, wantErr
+		}
+		return &compute.Operation{}, nil
+	})
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("got %d calls, want 2", calls)
+	}
+	if len(classified) != 1 || classified[0] != wantErr {
+		t.Errorf("IsRetriable got %v, want [%v]", classified, wantErr)
+	}
+}
+
+func TestSetOperationWarningsHandler(t *testing.T) {
+	_, c, err := NewTestClient(func(w http.ResponseWriter, r *http.Request) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []*compute.Operation
+	unset := SetOperationWarningsHandler(c, func(op *compute.Operation) { got = append(got, op) })
+	if unset == nil {
+		t.Fatal("handler not set")
+	}
+	// The handler set first is kept.
+	if SetOperationWarningsHandler(c, func(op *compute.Operation) { t.Error("second handler called") }) != nil {
+		t.Error("second handler set while the first is")
+	}
+
+	warnings := []*compute.OperationWarnings{{Code: "DISK_SIZE_LARGER_THAN_IMAGE_SIZE", Message: "rounded up"}}
+	tests := []struct {
+		desc       string
+		op         *compute.Operation
+		shouldErr  bool
+		wantCalled bool
+	}{
+		{"no warnings case", &compute.Operation{Status: "DONE"}, false, false},
+		{"warnings case", &compute.Operation{Status: "DONE", Warnings: warnings}, false, true},
+		{"failed operation case", &compute.Operation{Status: "DONE", Warnings: warnings, Error: &compute.OperationError{}}, true, true},
+	}
+	for _, tt := range tests {
+		got = nil
+		err := c.operationsWaitHelper(testProject, "op", func() (*compute.Operation, error) { return tt.op, nil })
+		if (err != nil) != tt.shouldErr {
+			t.Errorf("%s: got error %v, want error %t", tt.desc, err, tt.shouldErr)
+		}
+		if called := len(got) == 1 && got[0] == tt.op; called != tt.wantCalled || len(got) > 1 {
+			t.Errorf("%s: handler got %v, want called with the operation: %t", tt.desc, got, tt.wantCalled)
+		}
+	}
+
+	unset()
+	got = nil
+	c.operationsWaitHelper(testProject, "op", func() (*compute.Operation, error) { return tests[1].op, nil })
+	if got != nil {
+		t.Errorf("removed handler got %v", got)
+	}
+	if unset := SetOperationWarningsHandler(c, func(op *compute.Operation) {}); unset == nil {
+		t.Error("handler not set after the first was removed")
+	}
+}
+
 func TestCreates(t *testing.T) {
 	var getURL, insertURL *string
 	var getErr, insertErr, waitErr error
@@ -121,6 +225,7 @@ func TestCreates(t *testing.T) {
 
 	d := &compute.Disk{Name: testDisk}
 	fr := &compute.ForwardingRule{Name: testForwardingRule}
+	a := &compute.Address{Name: testAddress}
 	fir := &compute.Firewall{Name: testFirewallRule}
 	im := &compute.Image{Name: testImage}
 	imBeta := &computeBeta.Image{Name: testImageBeta}
@@ -130,6 +235,7 @@ func TestCreates(t *testing.T) {
 	n := &compute.Network{Name: testNetwork}
 	sn := &compute.Subnetwork{Name: testSubnetwork}
 	ti := &compute.TargetInstance{Name: testTargetInstance}
+	ig := &compute.InstanceGroup{Name: testInstanceGroup}
 	creates := []struct {
 		name              string
 		do                func() error
@@ -144,6 +250,22 @@ func TestCreates(t *testing.T) {
 			&compute.Disk{Name: testDisk, SelfLink: "foo"},
 			d,
 		},
+		{
+			"disksWithProvisionedPerformance",
+			func() error { return c.CreateDiskWithProvisionedPerformance(testProject, testZone, d, 10000, 500) },
+			fmt.Sprintf("/%s/zones/%s/disks/%s?alt=json&prettyPrint=false", testProject, testZone, testDisk),
+			fmt.Sprintf("/%s/zones/%s/disks?alt=json&prettyPrint=false", testProject, testZone),
+			&compute.Disk{Name: testDisk, SelfLink: "foo"},
+			d,
+		},
+		{
+			"addresses",
+			func() error { return c.CreateAddress(testProject, testRegion, a) },
+			fmt.Sprintf("/%s/regions/%s/addresses/%s?alt=json&prettyPrint=false", testProject, testRegion, testAddress),
+			fmt.Sprintf("/%s/regions/%s/addresses?alt=json&prettyPrint=false", testProject, testRegion),
+			&compute.Address{Name: testAddress, Address: "203.0.113.10", SelfLink: "foo"},
+			a,
+		},
 		{
 			"forwardingRules",
 			func() error { return c.CreateForwardingRule(testProject, testRegion, fr) },
@@ -168,6 +290,14 @@ func TestCreates(t *testing.T) {
 			&compute.Image{Name: testImage, SelfLink: "foo"},
 			im,
 		},
+		{
+			"imagesWithArchitecture",
+			func() error { return c.CreateImageWithArchitecture(testProject, im, "ARM64") },
+			fmt.Sprintf("/%s/global/images/%s?alt=json&prettyPrint=false", testProject, testImage),
+			fmt.Sprintf("/%s/global/images?alt=json&prettyPrint=false", testProject),
+			&compute.Image{Name: testImage, SelfLink: "foo"},
+			im,
+		},
 		{
 			"images",
 			func() error { return c.CreateImageBeta(testProject, imBeta) },
@@ -200,6 +330,40 @@ func TestCreates(t *testing.T) {
 			&computeBeta.Instance{Name: testInstanceBeta, SelfLink: "foo"},
 			inBeta,
 		},
+		{
+			"instancesWithTerminationAction",
+			func() error { return c.CreateInstanceWithTerminationAction(testProject, testZone, in, "DELETE") },
+			fmt.Sprintf("/%s/zones/%s/instances/%s?alt=json&prettyPrint=false", testProject, testZone, testInstance),
+			fmt.Sprintf("/%s/zones/%s/instances?alt=json&prettyPrint=false", testProject, testZone),
+			&compute.Instance{Name: testInstance, SelfLink: "foo"},
+			in,
+		},
+		{
+			"instancesBetaWithTerminationAction",
+			func() error {
+				return c.CreateInstanceBetaWithTerminationAction(testProject, testZone, inBeta, "DELETE")
+			},
+			fmt.Sprintf("/%s/zones/%s/instances/%s?alt=json&prettyPrint=false", testProject, testZone, testInstanceBeta),
+			fmt.Sprintf("/%s/zones/%s/instances?alt=json&prettyPrint=false", testProject, testZone),
+			&computeBeta.Instance{Name: testInstanceBeta, SelfLink: "foo"},
+			inBeta,
+		},
+		{
+			"instancesWithOptions",
+			func() error { return c.CreateInstanceWithOptions(testProject, testZone, in, InstanceOptions{}) },
+			fmt.Sprintf("/%s/zones/%s/instances/%s?alt=json&prettyPrint=false", testProject, testZone, testInstance),
+			fmt.Sprintf("/%s/zones/%s/instances?alt=json&prettyPrint=false", testProject, testZone),
+			&compute.Instance{Name: testInstance, SelfLink: "foo"},
+			in,
+		},
+		{
+			"instancesBetaWithOptions",
+			func() error { return c.CreateInstanceBetaWithOptions(testProject, testZone, inBeta, InstanceOptions{}) },
+			fmt.Sprintf("/%s/zones/%s/instances/%s?alt=json&prettyPrint=false", testProject, testZone, testInstanceBeta),
+			fmt.Sprintf("/%s/zones/%s/instances?alt=json&prettyPrint=false", testProject, testZone),
+			&computeBeta.Instance{Name: testInstanceBeta, SelfLink: "foo"},
+			inBeta,
+		},
 		{
 			"networks",
 			func() error { return c.CreateNetwork(testProject, n) },
@@ -224,6 +388,14 @@ func TestCreates(t *testing.T) {
 			&compute.TargetInstance{Name: testTargetInstance, SelfLink: "foo"},
 			ti,
 		},
+		{
+			"instanceGroups",
+			func() error { return c.CreateInstanceGroup(testProject, testZone, ig) },
+			fmt.Sprintf("/%s/zones/%s/instanceGroups/%s?alt=json&prettyPrint=false", testProject, testZone, testInstanceGroup),
+			fmt.Sprintf("/%s/zones/%s/instanceGroups?alt=json&prettyPrint=false", testProject, testZone),
+			&compute.InstanceGroup{Name: testInstanceGroup, SelfLink: "foo"},
+			ig,
+		},
 	}
 
 	for _, create := range creates {
@@ -247,6 +419,353 @@ func TestCreates(t *testing.T) {
 	}
 }
 
+func TestCreateImageWithArchitecture(t *testing.T) {
+	var gotBody map[string]interface{}
+	insertURL := fmt.Sprintf("/%s/global/images?alt=json&prettyPrint=false", testProject)
+	getURL := fmt.Sprintf("/%s/global/images/%s?alt=json&prettyPrint=false", testProject, testImage)
+	svr, c, err := NewTestClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" && r.URL.String() == insertURL {
+			if err := json.NewDecoder(r.Body).Decode(&gotBody); err != nil {
+				t.Fatal(err)
+			}
+			fmt.Fprint(w, `{"name":"op"}`)
+		} else if r.Method == "GET" && r.URL.String() == getURL {
+			fmt.Fprintf(w, `{"name":%q,"selfLink":"foo"}`, testImage)
+		} else {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, "URL and Method not recognized:", r.Method, r.URL)
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svr.Close()
+	c.globalOperationsWaitFn = func(_, _ string) error { return nil }
+
+	im := &compute.Image{Name: testImage, SourceDisk: "disk"}
+	if err := c.CreateImageWithArchitecture(testProject, im, "ARM64"); err != nil {
+		t.Fatalf("error running CreateImageWithArchitecture: %v", err)
+	}
+	want := map[string]interface{}{"name": testImage, "sourceDisk": "disk", "architecture": "ARM64"}
+	if diff := pretty.Compare(gotBody, want); diff != "" {
+		t.Errorf("insert request body does not match expectation: (-got +want)\n%s", diff)
+	}
+	if im.SelfLink != "foo" {
+		t.Errorf("image not updated from the created image, got SelfLink %q", im.SelfLink)
+	}
+}
+
+func TestCreateInstanceWithTerminationAction(t *testing.T) {
+	var gotBody map[string]interface{}
+	insertURL := fmt.Sprintf("/%s/zones/%s/instances?alt=json&prettyPrint=false", testProject, testZone)
+	getURL := fmt.Sprintf("/%s/zones/%s/instances/%s?alt=json&prettyPrint=false", testProject, testZone, testInstance)
+	svr, c, err := NewTestClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" && r.URL.String() == insertURL {
+			if err := json.NewDecoder(r.Body).Decode(&gotBody); err != nil {
+				t.Fatal(err)
+			}
+			fmt.Fprint(w, `{"name":"op"}`)
+		} else if r.Method == "GET" && r.URL.String() == getURL {
+			fmt.Fprintf(w, `{"name":%q,"selfLink":"foo"}`, testInstance)
+		} else {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, "URL and Method not recognized:", r.Method, r.URL)
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svr.Close()
+	c.zoneOperationsWaitFn = func(_, _, _ string) error { return nil }
+
+	in := &compute.Instance{Name: testInstance, Scheduling: &compute.Scheduling{Preemptible: true}}
+	if err := c.CreateInstanceWithTerminationAction(testProject, testZone, in, "DELETE"); err != nil {
+		t.Fatalf("error running CreateInstanceWithTerminationAction: %v", err)
+	}
+	want := map[string]interface{}{"name": testInstance, "scheduling": map[string]interface{}{"preemptible": true, "instanceTerminationAction": "DELETE"}}
+	if diff := pretty.Compare(gotBody, want); diff != "" {
+		t.Errorf("insert request body does not match expectation: (-got +want)\n%s", diff)
+	}
+	if in.SelfLink != "foo" {
+		t.Errorf("instance not updated from the created instance, got SelfLink %q", in.SelfLink)
+	}
+}
+
+func TestCreateInstanceWithOptions(t *testing.T) {
+	var gotBody map[string]interface{}
+	insertURL := fmt.Sprintf("/%s/zones/%s/instances?alt=json&prettyPrint=false", testProject, testZone)
+	getURL := fmt.Sprintf("/%s/zones/%s/instances/%s?alt=json&prettyPrint=false", testProject, testZone, testInstance)
+	svr, c, err := NewTestClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" && r.URL.String() == insertURL {
+			if err := json.NewDecoder(r.Body).Decode(&gotBody); err != nil {
+				t.Fatal(err)
+			}
+			fmt.Fprint(w, `{"name":"op"}`)
+		} else if r.Method == "GET" && r.URL.String() == getURL {
+			fmt.Fprintf(w, `{"name":%q,"selfLink":"foo"}`, testInstance)
+		} else {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, "URL and Method not recognized:", r.Method, r.URL)
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svr.Close()
+	c.zoneOperationsWaitFn = func(_, _, _ string) error { return nil }
+
+	in := &compute.Instance{Name: testInstance, NetworkInterfaces: []*compute.NetworkInterface{{Subnetwork: "a"}, {Subnetwork: "b"}, {Subnetwork: "c"}}}
+	opts := InstanceOptions{NetworkInterfaceStacks: []NetworkInterfaceStack{{StackType: "IPV4_IPV6", Ipv6AccessType: "EXTERNAL"}, {}, {StackType: "IPV4_IPV6", Ipv6AccessType: "INTERNAL"}}}
+	if err := c.CreateInstanceWithOptions(testProject, testZone, in, opts); err != nil {
+		t.Fatalf("error running CreateInstanceWithOptions: %v", err)
+	}
+	want := map[string]interface{}{"name": testInstance, "networkInterfaces": []interface{}{
+		map[string]interface{}{"subnetwork": "a", "stackType": "IPV4_IPV6", "ipv6AccessType": "EXTERNAL", "ipv6AccessConfigs": []interface{}{map[string]interface{}{"name": "external-ipv6", "type": "DIRECT_IPV6"}}},
+		map[string]interface{}{"subnetwork": "b"},
+		map[string]interface{}{"subnetwork": "c", "stackType": "IPV4_IPV6", "ipv6AccessType": "INTERNAL"},
+	}}
+	if diff := pretty.Compare(gotBody, want); diff != "" {
+		t.Errorf("insert request body does not match expectation: (-got +want)\n%s", diff)
+	}
+	if in.SelfLink != "foo" {
+		t.Errorf("instance not updated from the created instance, got SelfLink %q", in.SelfLink)
+	}
+}
+
+func TestCreateInstanceWithResourceManagerTags(t *testing.T) {
+	var gotBody map[string]interface{}
+	insertURL := fmt.Sprintf("/%s/zones/%s/instances?alt=json&prettyPrint=false", testProject, testZone)
+	getURL := fmt.Sprintf("/%s/zones/%s/instances/%s?alt=json&prettyPrint=false", testProject, testZone, testInstance)
+	svr, c, err := NewTestClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" && r.URL.String() == insertURL {
+			if err := json.NewDecoder(r.Body).Decode(&gotBody); err != nil {
+				t.Fatal(err)
+			}
+			fmt.Fprint(w, `{"name":"op"}`)
+		} else if r.Method == "GET" && r.URL.String() == getURL {
+			fmt.Fprintf(w, `{"name":%q,"selfLink":"foo"}`, testInstance)
+		} else {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, "URL and Method not recognized:", r.Method, r.URL)
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svr.Close()
+	c.zoneOperationsWaitFn = func(_, _, _ string) error { return nil }
+
+	in := &compute.Instance{Name: testInstance, Disks: []*compute.AttachedDisk{
+		{InitializeParams: &compute.AttachedDiskInitializeParams{DiskName: "boot"}},
+		{Source: "zones/z/disks/existing"},
+	}}
+	tags := map[string]string{"tagKeys/123": "tagValues/456"}
+	if err := c.CreateInstanceWithOptions(testProject, testZone, in, InstanceOptions{ResourceManagerTags: tags}); err != nil {
+		t.Fatalf("error running CreateInstanceWithOptions: %v", err)
+	}
+	wantTags := map[string]interface{}{"tagKeys/123": "tagValues/456"}
+	want := map[string]interface{}{"name": testInstance, "params": map[string]interface{}{"resourceManagerTags": wantTags}, "disks": []interface{}{
+		map[string]interface{}{"initializeParams": map[string]interface{}{"diskName": "boot", "resourceManagerTags": wantTags}},
+		map[string]interface{}{"source": "zones/z/disks/existing"},
+	}}
+	if diff := pretty.Compare(gotBody, want); diff != "" {
+		t.Errorf("insert request body does not match expectation: (-got +want)\n%s", diff)
+	}
+}
+
+func TestCreateDiskWithOptions(t *testing.T) {
+	var gotBody map[string]interface{}
+	insertURL := fmt.Sprintf("/%s/zones/%s/disks?alt=json&prettyPrint=false", testProject, testZone)
+	getURL := fmt.Sprintf("/%s/zones/%s/disks/%s?alt=json&prettyPrint=false", testProject, testZone, testDisk)
+	svr, c, err := NewTestClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" && r.URL.String() == insertURL {
+			if err := json.NewDecoder(r.Body).Decode(&gotBody); err != nil {
+				t.Fatal(err)
+			}
+			fmt.Fprint(w, `{"name":"op"}`)
+		} else if r.Method == "GET" && r.URL.String() == getURL {
+			fmt.Fprintf(w, `{"name":%q,"selfLink":"foo"}`, testDisk)
+		} else {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, "URL and Method not recognized:", r.Method, r.URL)
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svr.Close()
+	c.zoneOperationsWaitFn = func(_, _, _ string) error { return nil }
+
+	d := &compute.Disk{Name: testDisk, Type: "hyperdisk-balanced"}
+	opts := DiskOptions{ProvisionedIops: 10000, ResourceManagerTags: map[string]string{"tagKeys/123": "tagValues/456"}}
+	if err := c.CreateDiskWithOptions(testProject, testZone, d, opts); err != nil {
+		t.Fatalf("error running CreateDiskWithOptions: %v", err)
+	}
+	want := map[string]interface{}{"name": testDisk, "type": "hyperdisk-balanced", "provisionedIops": "10000", "params": map[string]interface{}{"resourceManagerTags": map[string]interface{}{"tagKeys/123": "tagValues/456"}}}
+	if diff := pretty.Compare(gotBody, want); diff != "" {
+		t.Errorf("insert request body does not match expectation: (-got +want)\n%s", diff)
+	}
+	if d.SelfLink != "foo" {
+		t.Errorf("disk not updated from the created disk, got SelfLink %q", d.SelfLink)
+	}
+}
+
+func TestCreateAddressWithLabels(t *testing.T) {
+	var gotBody map[string]interface{}
+	insertURL := fmt.Sprintf("/%s/regions/%s/addresses?alt=json&prettyPrint=false", testProject, testRegion)
+	getURL := fmt.Sprintf("/%s/regions/%s/addresses/a?alt=json&prettyPrint=false", testProject, testRegion)
+	svr, c, err := NewTestClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" && r.URL.String() == insertURL {
+			if err := json.NewDecoder(r.Body).Decode(&gotBody); err != nil {
+				t.Fatal(err)
+			}
+			fmt.Fprint(w, `{"name":"op"}`)
+		} else if r.Method == "GET" && r.URL.String() == getURL {
+			fmt.Fprint(w, `{"name":"a","selfLink":"foo"}`)
+		} else {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, "URL and Method not recognized:", r.Method, r.URL)
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svr.Close()
+	c.regionOperationsWaitFn = func(_, _, _ string) error { return nil }
+
+	a := &compute.Address{Name: "a", AddressType: "EXTERNAL"}
+	if err := c.CreateAddressWithLabels(testProject, testRegion, a, map[string]string{"foo": "bar"}); err != nil {
+		t.Fatalf("error running CreateAddressWithLabels: %v", err)
+	}
+	want := map[string]interface{}{"name": "a", "addressType": "EXTERNAL", "labels": map[string]interface{}{"foo": "bar"}}
+	if diff := pretty.Compare(gotBody, want); diff != "" {
+		t.Errorf("insert request body does not match expectation: (-got +want)\n%s", diff)
+	}
+	if a.SelfLink != "foo" {
+		t.Errorf("address not updated from the created address, got SelfLink %q", a.SelfLink)
+	}
+}
+
+func TestCreateDiskWithProvisionedPerformance(t *testing.T) {
+	var gotBody map[string]interface{}
+	insertURL := fmt.Sprintf("/%s/zones/%s/disks?alt=json&prettyPrint=false", testProject, testZone)
+	getURL := fmt.Sprintf("/%s/zones/%s/disks/%s?alt=json&prettyPrint=false", testProject, testZone, testDisk)
+	svr, c, err := NewTestClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" && r.URL.String() == insertURL {
+			gotBody = nil
+			if err := json.NewDecoder(r.Body).Decode(&gotBody); err != nil {
+				t.Fatal(err)
+			}
+			fmt.Fprint(w, `{"name":"op"}`)
+		} else if r.Method == "GET" && r.URL.String() == getURL {
+			fmt.Fprintf(w, `{"name":%q,"selfLink":"foo"}`, testDisk)
+		} else {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, "URL and Method not recognized:", r.Method, r.URL)
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svr.Close()
+	c.zoneOperationsWaitFn = func(_, _, _ string) error { return nil }
+
+	tests := []struct {
+		desc             string
+		iops, throughput int64
+		want             map[string]interface{}
+	}{
+		{"both case", 10000, 500, map[string]interface{}{"name": testDisk, "type": "hyperdisk-balanced", "provisionedIops": "10000", "provisionedThroughput": "500"}},
+		{"iops only case", 10000, 0, map[string]interface{}{"name": testDisk, "type": "hyperdisk-balanced", "provisionedIops": "10000"}},
+		{"throughput only case", 0, 500, map[string]interface{}{"name": testDisk, "type": "hyperdisk-balanced", "provisionedThroughput": "500"}},
+	}
+	for _, tt := range tests {
+		d := &compute.Disk{Name: testDisk, Type: "hyperdisk-balanced"}
+		if err := c.CreateDiskWithProvisionedPerformance(testProject, testZone, d, tt.iops, tt.throughput); err != nil {
+			t.Fatalf("%s: error running CreateDiskWithProvisionedPerformance: %v", tt.desc, err)
+		}
+		if diff := pretty.Compare(gotBody, tt.want); diff != "" {
+			t.Errorf("%s: insert request body does not match expectation: (-got +want)\n%s", tt.desc, diff)
+		}
+		if d.SelfLink != "foo" {
+			t.Errorf("%s: disk not updated from the created disk, got SelfLink %q", tt.desc, d.SelfLink)
+		}
+	}
+}
+
+func TestGetScreenshot(t *testing.T) {
+	getURL := fmt.Sprintf("/%s/zones/%s/instances/%s/screenshot?alt=json&prettyPrint=false", testProject, testZone, testInstance)
+	svr, c, err := NewTestClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" && r.URL.String() == getURL {
+			fmt.Fprint(w, `{"kind":"compute#screenshot","contents":"iVBORw0K"}`)
+		} else {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, "URL and Method not recognized:", r.Method, r.URL)
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svr.Close()
+
+	got, err := c.GetScreenshot(testProject, testZone, testInstance)
+	if err != nil {
+		t.Fatalf("error running GetScreenshot: %v", err)
+	}
+	if want := "\x89PNG\r\n"; string(got) != want {
+		t.Errorf("got screenshot %q, want %q", got, want)
+	}
+	if _, err := c.GetScreenshot(testProject, testZone, "bad"); err == nil {
+		t.Error("expected an error for an unknown instance")
+	}
+}
+
+func TestInstancePreempted(t *testing.T) {
+	getURL := fmt.Sprintf("/%s/zones/%s/instances/%s?alt=json&prettyPrint=false", testProject, testZone, testInstance)
+	listPath := fmt.Sprintf("/%s/zones/%s/operations", testProject, testZone)
+	var ops string
+	var filter string
+	svr, c, err := NewTestClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" && r.URL.String() == getURL {
+			fmt.Fprint(w, `{"id":"123","name":"test-instance"}`)
+		} else if r.Method == "GET" && r.URL.Path == listPath {
+			filter = r.URL.Query().Get("filter")
+			fmt.Fprintf(w, `{"items":[%s]}`, ops)
+		} else {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintln(w, "URL and Method not recognized:", r.Method, r.URL)
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svr.Close()
+
+	tests := []struct {
+		desc, ops string
+		want      bool
+	}{
+		{"not preempted case", "", false},
+		{"preempted case", `{"operationType":"compute.instances.preempted","targetId":"123"}`, true},
+	}
+	for _, tt := range tests {
+		ops = tt.ops
+		got, err := c.InstancePreempted(testProject, testZone, testInstance)
+		if err != nil {
+			t.Fatalf("%s: error running InstancePreempted: %v", tt.desc, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %t, want %t", tt.desc, got, tt.want)
+		}
+		if want := `(operationType = "compute.instances.preempted") AND (targetId = 123)`; filter != want {
+			t.Errorf("%s: got filter %q, want %q", tt.desc, filter, want)
+		}
+	}
+	if _, err := c.InstancePreempted(testProject, testZone, "bad"); err == nil {
+		t.Error("expected an error for an unknown instance")
+	}
+}
+
 func TestStarts(t *testing.T) {
 	var startURL, opGetURL string
 	svr, c, err := NewTestClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -323,6 +842,12 @@ func TestDeletes(t *testing.T) {
 			fmt.Sprintf("/%s/zones/%s/disks/%s?alt=json&prettyPrint=false", testProject, testZone, testDisk),
 			fmt.Sprintf("/%s/zones/%s/operations//wait?alt=json&prettyPrint=false", testProject, testZone),
 		},
+		{
+			"addresses",
+			func() error { return c.DeleteAddress(testProject, testRegion, testAddress) },
+			fmt.Sprintf("/%s/regions/%s/addresses/%s?alt=json&prettyPrint=false", testProject, testRegion, testAddress),
+			fmt.Sprintf("/%s/regions/%s/operations//wait?alt=json&prettyPrint=false", testProject, testRegion),
+		},
 		{
 			"forwardingRules",
 			func() error { return c.DeleteForwardingRule(testProject, testRegion, testForwardingRule) },
@@ -371,6 +896,12 @@ func TestDeletes(t *testing.T) {
 			fmt.Sprintf("/%s/zones/%s/targetInstances/%s?alt=json&prettyPrint=false", testProject, testZone, testTargetInstance),
 			fmt.Sprintf("/%s/zones/%s/operations//wait?alt=json&prettyPrint=false", testProject, testZone),
 		},
+		{
+			"instanceGroups",
+			func() error { return c.DeleteInstanceGroup(testProject, testZone, testInstanceGroup) },
+			fmt.Sprintf("/%s/zones/%s/instanceGroups/%s?alt=json&prettyPrint=false", testProject, testZone, testInstanceGroup),
+			fmt.Sprintf("/%s/zones/%s/operations//wait?alt=json&prettyPrint=false", testProject, testZone),
+		},
 	}
 
 	for _, d := range deletes {
@@ -424,6 +955,27 @@ func TestAttachDisk(t *testing.T) {
 	}
 }
 
+func TestForceAttachDisk(t *testing.T) {
+	svr, c, err := NewTestClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" && r.URL.String() == fmt.Sprintf("/%s/zones/%s/instances/%s/attachDisk?alt=json&forceAttach=true&prettyPrint=false", testProject, testZone, testInstance) {
+			fmt.Fprint(w, `{}`)
+		} else if r.Method == "POST" && r.URL.String() == fmt.Sprintf("/%s/zones/%s/operations//wait?alt=json&prettyPrint=false", testProject, testZone) {
+			fmt.Fprint(w, `{"Status":"DONE"}`)
+		} else {
+			w.WriteHeader(500)
+			fmt.Fprintln(w, "URL and Method not recognized:", r.Method, r.URL)
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer svr.Close()
+
+	if err := c.ForceAttachDisk(testProject, testZone, testInstance, &compute.AttachedDisk{}); err != nil {
+		t.Fatalf("error running ForceAttachDisk: %v", err)
+	}
+}
+
 func TestDetachDisk(t *testing.T) {
 	svr, c, err := NewTestClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == "POST" && r.URL.String() == fmt.Sprintf("/%s/zones/%s/instances/%s/detachDisk?alt=json&deviceName=%s&prettyPrint=false", testProject, testZone, testInstance, testDisk) {
@@ -48,64 +48,81 @@ func NewTestClient(handleFunc http.HandlerFunc) (*httptest.Server, *TestClient,
 type TestClient struct {
 	client
 
-	AttachDiskFn                func(project, zone, instance string, d *compute.AttachedDisk) error
-	DetachDiskFn                func(project, zone, instance, disk string) error
-	CreateDiskFn                func(project, zone string, d *compute.Disk) error
-	CreateForwardingRuleFn      func(project, region string, fr *compute.ForwardingRule) error
-	CreateFirewallRuleFn        func(project string, i *compute.Firewall) error
-	CreateImageFn               func(project string, i *compute.Image) error
-	CreateInstanceFn            func(project, zone string, i *compute.Instance) error
-	CreateNetworkFn             func(project string, n *compute.Network) error
-	CreateSubnetworkFn          func(project, region string, n *compute.Subnetwork) error
-	CreateTargetInstanceFn      func(project, zone string, ti *compute.TargetInstance) error
-	StartInstanceFn             func(project, zone, name string) error
-	StopInstanceFn              func(project, zone, name string) error
-	DeleteDiskFn                func(project, zone, name string) error
-	DeleteForwardingRuleFn      func(project, region, name string) error
-	DeleteFirewallRuleFn        func(project, name string) error
-	DeleteImageFn               func(project, name string) error
-	DeleteInstanceFn            func(project, zone, name string) error
-	DeleteNetworkFn             func(project, name string) error
-	DeleteSubnetworkFn          func(project, region, name string) error
-	DeleteTargetInstanceFn      func(project, zone, name string) error
-	DeprecateImageFn            func(project, name string, deprecationstatus *compute.DeprecationStatus) error
-	GetMachineTypeFn            func(project, zone, machineType string) (*compute.MachineType, error)
-	ListMachineTypesFn          func(project, zone string, opts ...ListCallOption) ([]*compute.MachineType, error)
-	GetProjectFn                func(project string) (*compute.Project, error)
-	GetSerialPortOutputFn       func(project, zone, name string, port, start int64) (*compute.SerialPortOutput, error)
-	GetZoneFn                   func(project, zone string) (*compute.Zone, error)
-	ListZonesFn                 func(project string, opts ...ListCallOption) ([]*compute.Zone, error)
-	GetInstanceFn               func(project, zone, name string) (*compute.Instance, error)
-	AggregatedListInstancesFn   func(project string, opts ...ListCallOption) ([]*compute.Instance, error)
-	ListInstancesFn             func(project, zone string, opts ...ListCallOption) ([]*compute.Instance, error)
-	ListSnapshotsFn             func(project string, opts ...ListCallOption) ([]*compute.Snapshot, error)
-	GetSnapshotFn               func(project, name string) (*compute.Snapshot, error)
-	DeleteSnapshotFn            func(project, name string) error
-	GetDiskFn                   func(project, zone, name string) (*compute.Disk, error)
-	AggregatedListDisksFn       func(project string, opts ...ListCallOption) ([]*compute.Disk, error)
-	ListDisksFn                 func(project, zone string, opts ...ListCallOption) ([]*compute.Disk, error)
-	GetForwardingRuleFn         func(project, region, name string) (*compute.ForwardingRule, error)
-	ListForwardingRulesFn       func(project, region string, opts ...ListCallOption) ([]*compute.ForwardingRule, error)
-	GetFirewallRuleFn           func(project, name string) (*compute.Firewall, error)
-	ListFirewallRulesFn         func(project string, opts ...ListCallOption) ([]*compute.Firewall, error)
-	GetImageFn                  func(project, name string) (*compute.Image, error)
-	GetImageFromFamilyFn        func(project, family string) (*compute.Image, error)
-	ListImagesFn                func(project string, opts ...ListCallOption) ([]*compute.Image, error)
-	GetLicenseFn                func(project, name string) (*compute.License, error)
-	ListLicensesFn              func(project string, opts ...ListCallOption) ([]*compute.License, error)
-	GetNetworkFn                func(project, name string) (*compute.Network, error)
-	AggregatedListSubnetworksFn func(project string, opts ...ListCallOption) ([]*compute.Subnetwork, error)
-	ListNetworksFn              func(project string, opts ...ListCallOption) ([]*compute.Network, error)
-	GetSubnetworkFn             func(project, region, name string) (*compute.Subnetwork, error)
-	ListSubnetworksFn           func(project, region string, opts ...ListCallOption) ([]*compute.Subnetwork, error)
-	GetTargetInstanceFn         func(project, zone, name string) (*compute.TargetInstance, error)
-	ListTargetInstancesFn       func(project, zone string, opts ...ListCallOption) ([]*compute.TargetInstance, error)
-	InstanceStatusFn            func(project, zone, name string) (string, error)
-	InstanceStoppedFn           func(project, zone, name string) (bool, error)
-	ResizeDiskFn                func(project, zone, disk string, drr *compute.DisksResizeRequest) error
-	SetInstanceMetadataFn       func(project, zone, name string, md *compute.Metadata) error
-	SetCommonInstanceMetadataFn func(project string, md *compute.Metadata) error
-	RetryFn                     func(f func(opts ...googleapi.CallOption) (*compute.Operation, error), opts ...googleapi.CallOption) (op *compute.Operation, err error)
+	AttachDiskFn                   func(project, zone, instance string, d *compute.AttachedDisk) error
+	ForceAttachDiskFn              func(project, zone, instance string, d *compute.AttachedDisk) error
+	DetachDiskFn                   func(project, zone, instance, disk string) error
+	CreateDiskFn                   func(project, zone string, d *compute.Disk) error
+	CreateAddressFn                func(project, region string, a *compute.Address) error
+	CreateForwardingRuleFn         func(project, region string, fr *compute.ForwardingRule) error
+	CreateFirewallRuleFn           func(project string, i *compute.Firewall) error
+	CreateImageFn                  func(project string, i *compute.Image) error
+	CreateImageWithArchitectureFn  func(project string, i *compute.Image, architecture string) error
+	CreateInstanceFn               func(project, zone string, i *compute.Instance) error
+	CreateNetworkFn                func(project string, n *compute.Network) error
+	CreateSubnetworkFn             func(project, region string, n *compute.Subnetwork) error
+	CreateTargetInstanceFn         func(project, zone string, ti *compute.TargetInstance) error
+	CreateInstanceGroupFn          func(project, zone string, ig *compute.InstanceGroup) error
+	StartInstanceFn                func(project, zone, name string) error
+	StopInstanceFn                 func(project, zone, name string) error
+	SetMachineTypeFn               func(project, zone, name, machineType string) error
+	DeleteDiskFn                   func(project, zone, name string) error
+	DeleteAddressFn                func(project, region, name string) error
+	DeleteForwardingRuleFn         func(project, region, name string) error
+	DeleteFirewallRuleFn           func(project, name string) error
+	DeleteImageFn                  func(project, name string) error
+	DeleteInstanceFn               func(project, zone, name string) error
+	DeleteNetworkFn                func(project, name string) error
+	DeleteSubnetworkFn             func(project, region, name string) error
+	DeleteTargetInstanceFn         func(project, zone, name string) error
+	DeleteInstanceGroupFn          func(project, zone, name string) error
+	DeprecateImageFn               func(project, name string, deprecationstatus *compute.DeprecationStatus) error
+	GetMachineTypeFn               func(project, zone, machineType string) (*compute.MachineType, error)
+	ListMachineTypesFn             func(project, zone string, opts ...ListCallOption) ([]*compute.MachineType, error)
+	GetProjectFn                   func(project string) (*compute.Project, error)
+	GetSerialPortOutputFn          func(project, zone, name string, port, start int64) (*compute.SerialPortOutput, error)
+	GetZoneFn                      func(project, zone string) (*compute.Zone, error)
+	GetRegionFn                    func(project, region string) (*compute.Region, error)
+	ListZonesFn                    func(project string, opts ...ListCallOption) ([]*compute.Zone, error)
+	ListRegionsFn                  func(project string, opts ...ListCallOption) ([]*compute.Region, error)
+	GetInstanceFn                  func(project, zone, name string) (*compute.Instance, error)
+	AggregatedListInstancesFn      func(project string, opts ...ListCallOption) ([]*compute.Instance, error)
+	ListInstancesFn                func(project, zone string, opts ...ListCallOption) ([]*compute.Instance, error)
+	ListSnapshotsFn                func(project string, opts ...ListCallOption) ([]*compute.Snapshot, error)
+	CreateSnapshotFn               func(project, zone, disk string, s *compute.Snapshot, guestFlush bool) error
+	GetSnapshotFn                  func(project, name string) (*compute.Snapshot, error)
+	DeleteSnapshotFn               func(project, name string) error
+	GetDiskFn                      func(project, zone, name string) (*compute.Disk, error)
+	GetRegionDiskFn                func(project, region, name string) (*compute.Disk, error)
+	AggregatedListDisksFn          func(project string, opts ...ListCallOption) ([]*compute.Disk, error)
+	ListDisksFn                    func(project, zone string, opts ...ListCallOption) ([]*compute.Disk, error)
+	GetAddressFn                   func(project, region, name string) (*compute.Address, error)
+	GetForwardingRuleFn            func(project, region, name string) (*compute.ForwardingRule, error)
+	ListAddressesFn                func(project, region string, opts ...ListCallOption) ([]*compute.Address, error)
+	ListForwardingRulesFn          func(project, region string, opts ...ListCallOption) ([]*compute.ForwardingRule, error)
+	GetFirewallRuleFn              func(project, name string) (*compute.Firewall, error)
+	ListFirewallRulesFn            func(project string, opts ...ListCallOption) ([]*compute.Firewall, error)
+	GetImageFn                     func(project, name string) (*compute.Image, error)
+	GetImageFromFamilyFn           func(project, family string) (*compute.Image, error)
+	ListImagesFn                   func(project string, opts ...ListCallOption) ([]*compute.Image, error)
+	GetLicenseFn                   func(project, name string) (*compute.License, error)
+	ListLicensesFn                 func(project string, opts ...ListCallOption) ([]*compute.License, error)
+	GetNetworkFn                   func(project, name string) (*compute.Network, error)
+	AggregatedListSubnetworksFn    func(project string, opts ...ListCallOption) ([]*compute.Subnetwork, error)
+	ListNetworksFn                 func(project string, opts ...ListCallOption) ([]*compute.Network, error)
+	GetSubnetworkFn                func(project, region, name string) (*compute.Subnetwork, error)
+	ListSubnetworksFn              func(project, region string, opts ...ListCallOption) ([]*compute.Subnetwork, error)
+	GetTargetInstanceFn            func(project, zone, name string) (*compute.TargetInstance, error)
+	ListTargetInstancesFn          func(project, zone string, opts ...ListCallOption) ([]*compute.TargetInstance, error)
+	GetInstanceGroupFn             func(project, zone, name string) (*compute.InstanceGroup, error)
+	AddInstanceGroupInstancesFn    func(project, zone, name string, instances []string) error
+	RemoveInstanceGroupInstancesFn func(project, zone, name string, instances []string) error
+	InstanceStatusFn               func(project, zone, name string) (string, error)
+	InstanceStoppedFn              func(project, zone, name string) (bool, error)
+	InstancePreemptedFn            func(project, zone, name string) (bool, error)
+	ResizeDiskFn                   func(project, zone, disk string, drr *compute.DisksResizeRequest) error
+	SetInstanceMetadataFn          func(project, zone, name string, md *compute.Metadata) error
+	SetCommonInstanceMetadataFn    func(project string, md *compute.Metadata) error
+	RetryFn                        func(f func(opts ...googleapi.CallOption) (*compute.Operation, error), opts ...googleapi.CallOption) (op *compute.Operation, err error)
 
 	// Beta API calls
 	GetGuestAttributesFn func(project, zone, name, queryPath, variableKey string) (*computeBeta.GuestAttributes, error)
@@ -115,6 +132,18 @@ type TestClient struct {
 	GetMachineImageFn    func(project, name string) (*computeBeta.MachineImage, error)
 	CreateInstanceBetaFn func(project, zone string, i *computeBeta.Instance) error
 
+	CreateInstanceWithTerminationActionFn     func(project, zone string, i *compute.Instance, action string) error
+	CreateInstanceBetaWithTerminationActionFn func(project, zone string, i *computeBeta.Instance, action string) error
+	CreateInstanceWithOptionsFn               func(project, zone string, i *compute.Instance, opts InstanceOptions) error
+	CreateInstanceBetaWithOptionsFn           func(project, zone string, i *computeBeta.Instance, opts InstanceOptions) error
+	CreateDiskWithProvisionedPerformanceFn    func(project, zone string, d *compute.Disk, iops, throughput int64) error
+	CreateDiskWithOptionsFn                   func(project, zone string, d *compute.Disk, opts DiskOptions) error
+	GetScreenshotFn                           func(project, zone, name string) ([]byte, error)
+	CreateAddressWithLabelsFn                 func(project, region string, a *compute.Address, labels map[string]string) error
+	AggregatedListAddressesFn                 func(project string, opts ...ListCallOption) ([]*compute.Address, error)
+	CreateForwardingRuleWithLabelsFn          func(project, region string, fr *compute.ForwardingRule, labels map[string]string) error
+	AggregatedListForwardingRulesFn           func(project string, opts ...ListCallOption) ([]*compute.ForwardingRule, error)
+
 	zoneOperationsWaitFn   func(project, zone, name string) error
 	regionOperationsWaitFn func(project, region, name string) error
 	globalOperationsWaitFn func(project, name string) error
@@ -136,6 +165,14 @@ func (c *TestClient) AttachDisk(project, zone, instance string, ad *compute.Atta
 	return c.client.AttachDisk(project, zone, instance, ad)
 }
 
+// ForceAttachDisk uses the override method ForceAttachDiskFn or the real implementation.
+func (c *TestClient) ForceAttachDisk(project, zone, instance string, ad *compute.AttachedDisk) error {
+	if c.ForceAttachDiskFn != nil {
+		return c.ForceAttachDiskFn(project, zone, instance, ad)
+	}
+	return c.client.ForceAttachDisk(project, zone, instance, ad)
+}
+
 // DetachDisk uses the override method DetachDiskFn or the real implementation.
 func (c *TestClient) DetachDisk(project, zone, instance, disk string) error {
 	if c.DetachDiskFn != nil {
@@ -152,6 +189,38 @@ func (c *TestClient) CreateDisk(project, zone string, d *compute.Disk) error {
 	return c.client.CreateDisk(project, zone, d)
 }
 
+// CreateDiskWithProvisionedPerformance uses the override method CreateDiskWithProvisionedPerformanceFn or the real implementation.
+func (c *TestClient) CreateDiskWithProvisionedPerformance(project, zone string, d *compute.Disk, iops, throughput int64) error {
+	if c.CreateDiskWithProvisionedPerformanceFn != nil {
+		return c.CreateDiskWithProvisionedPerformanceFn(project, zone, d, iops, throughput)
+	}
+	return c.client.CreateDiskWithProvisionedPerformance(project, zone, d, iops, throughput)
+}
+
+// CreateDiskWithOptions uses the override method CreateDiskWithOptionsFn or the real implementation.
+func (c *TestClient) CreateDiskWithOptions(project, zone string, d *compute.Disk, opts DiskOptions) error {
+	if c.CreateDiskWithOptionsFn != nil {
+		return c.CreateDiskWithOptionsFn(project, zone, d, opts)
+	}
+	return c.client.CreateDiskWithOptions(project, zone, d, opts)
+}
+
+// CreateAddress uses the override method CreateAddressFn or the real implementation.
+func (c *TestClient) CreateAddress(project, region string, a *compute.Address) error {
+	if c.CreateAddressFn != nil {
+		return c.CreateAddressFn(project, region, a)
+	}
+	return c.client.CreateAddress(project, region, a)
+}
+
+// CreateAddressWithLabels uses the override method CreateAddressWithLabelsFn or the real implementation.
+func (c *TestClient) CreateAddressWithLabels(project, region string, a *compute.Address, labels map[string]string) error {
+	if c.CreateAddressWithLabelsFn != nil {
+		return c.CreateAddressWithLabelsFn(project, region, a, labels)
+	}
+	return c.client.CreateAddressWithLabels(project, region, a, labels)
+}
+
 // CreateForwardingRule uses the override method CreateForwardingRuleFn or the real implementation.
 func (c *TestClient) CreateForwardingRule(project, region string, fr *compute.ForwardingRule) error {
 	if c.CreateForwardingRuleFn != nil {
@@ -160,6 +229,14 @@ func (c *TestClient) CreateForwardingRule(project, region string, fr *compute.Fo
 	return c.client.CreateForwardingRule(project, region, fr)
 }
 
+// CreateForwardingRuleWithLabels uses the override method CreateForwardingRuleWithLabelsFn or the real implementation.
+func (c *TestClient) CreateForwardingRuleWithLabels(project, region string, fr *compute.ForwardingRule, labels map[string]string) error {
+	if c.CreateForwardingRuleWithLabelsFn != nil {
+		return c.CreateForwardingRuleWithLabelsFn(project, region, fr, labels)
+	}
+	return c.client.CreateForwardingRuleWithLabels(project, region, fr, labels)
+}
+
 // CreateFirewallRule uses the override method CreateFirewallRuleFn or the real implementation.
 func (c *TestClient) CreateFirewallRule(project string, i *compute.Firewall) error {
 	if c.CreateFirewallRuleFn != nil {
@@ -176,6 +253,14 @@ func (c *TestClient) CreateImage(project string, i *compute.Image) error {
 	return c.client.CreateImage(project, i)
 }
 
+// CreateImageWithArchitecture uses the override method CreateImageWithArchitectureFn or the real implementation.
+func (c *TestClient) CreateImageWithArchitecture(project string, i *compute.Image, architecture string) error {
+	if c.CreateImageWithArchitectureFn != nil {
+		return c.CreateImageWithArchitectureFn(project, i, architecture)
+	}
+	return c.client.CreateImageWithArchitecture(project, i, architecture)
+}
+
 // CreateInstance uses the override method CreateInstanceFn or the real implementation.
 func (c *TestClient) CreateInstance(project, zone string, i *compute.Instance) error {
 	if c.CreateInstanceFn != nil {
@@ -184,6 +269,22 @@ func (c *TestClient) CreateInstance(project, zone string, i *compute.Instance) e
 	return c.client.CreateInstance(project, zone, i)
 }
 
+// CreateInstanceWithTerminationAction uses the override method CreateInstanceWithTerminationActionFn or the real implementation.
+func (c *TestClient) CreateInstanceWithTerminationAction(project, zone string, i *compute.Instance, action string) error {
+	if c.CreateInstanceWithTerminationActionFn != nil {
+		return c.CreateInstanceWithTerminationActionFn(project, zone, i, action)
+	}
+	return c.client.CreateInstanceWithTerminationAction(project, zone, i, action)
+}
+
+// CreateInstanceWithOptions uses the override method CreateInstanceWithOptionsFn or the real implementation.
+func (c *TestClient) CreateInstanceWithOptions(project, zone string, i *compute.Instance, opts InstanceOptions) error {
+	if c.CreateInstanceWithOptionsFn != nil {
+		return c.CreateInstanceWithOptionsFn(project, zone, i, opts)
+	}
+	return c.client.CreateInstanceWithOptions(project, zone, i, opts)
+}
+
 // CreateNetwork uses the override method CreateNetworkFn or the real implementation.
 func (c *TestClient) CreateNetwork(project string, n *compute.Network) error {
 	if c.CreateNetworkFn != nil {
@@ -224,6 +325,14 @@ func (c *TestClient) StopInstance(project, zone, name string) error {
 	return c.client.StopInstance(project, zone, name)
 }
 
+// SetMachineType uses the override method SetMachineTypeFn or the real implementation.
+func (c *TestClient) SetMachineType(project, zone, name, machineType string) error {
+	if c.SetMachineTypeFn != nil {
+		return c.SetMachineTypeFn(project, zone, name, machineType)
+	}
+	return c.client.SetMachineType(project, zone, name, machineType)
+}
+
 // DeleteDisk uses the override method DeleteDiskFn or the real implementation.
 func (c *TestClient) DeleteDisk(project, zone, name string) error {
 	if c.DeleteDiskFn != nil {
@@ -232,6 +341,14 @@ func (c *TestClient) DeleteDisk(project, zone, name string) error {
 	return c.client.DeleteDisk(project, zone, name)
 }
 
+// DeleteAddress uses the override method DeleteAddressFn or the real implementation.
+func (c *TestClient) DeleteAddress(project, region, name string) error {
+	if c.DeleteAddressFn != nil {
+		return c.DeleteAddressFn(project, region, name)
+	}
+	return c.client.DeleteAddress(project, region, name)
+}
+
 // DeleteForwardingRule uses the override method DeleteForwardingRuleFn or the real implementation.
 func (c *TestClient) DeleteForwardingRule(project, region, name string) error {
 	if c.DeleteForwardingRuleFn != nil {
@@ -288,6 +405,46 @@ func (c *TestClient) DeleteTargetInstance(project, zone, name string) error {
 	return c.client.DeleteTargetInstance(project, zone, name)
 }
 
+// CreateInstanceGroup uses the override method CreateInstanceGroupFn or the real implementation.
+func (c *TestClient) CreateInstanceGroup(project, zone string, ig *compute.InstanceGroup) error {
+	if c.CreateInstanceGroupFn != nil {
+		return c.CreateInstanceGroupFn(project, zone, ig)
+	}
+	return c.client.CreateInstanceGroup(project, zone, ig)
+}
+
+// DeleteInstanceGroup uses the override method DeleteInstanceGroupFn or the real implementation.
+func (c *TestClient) DeleteInstanceGroup(project, zone, name string) error {
+	if c.DeleteInstanceGroupFn != nil {
+		return c.DeleteInstanceGroupFn(project, zone, name)
+	}
+	return c.client.DeleteInstanceGroup(project, zone, name)
+}
+
+// GetInstanceGroup uses the override method GetInstanceGroupFn or the real implementation.
+func (c *TestClient) GetInstanceGroup(project, zone, name string) (*compute.InstanceGroup, error) {
+	if c.GetInstanceGroupFn != nil {
+		return c.GetInstanceGroupFn(project, zone, name)
+	}
+	return c.client.GetInstanceGroup(project, zone, name)
+}
+
+// AddInstanceGroupInstances uses the override method AddInstanceGroupInstancesFn or the real implementation.
+func (c *TestClient) AddInstanceGroupInstances(project, zone, name string, instances []string) error {
+	if c.AddInstanceGroupInstancesFn != nil {
+		return c.AddInstanceGroupInstancesFn(project, zone, name, instances)
+	}
+	return c.client.AddInstanceGroupInstances(project, zone, name, instances)
+}
+
+// RemoveInstanceGroupInstances uses the override method RemoveInstanceGroupInstancesFn or the real implementation.
+func (c *TestClient) RemoveInstanceGroupInstances(project, zone, name string, instances []string) error {
+	if c.RemoveInstanceGroupInstancesFn != nil {
+		return c.RemoveInstanceGroupInstancesFn(project, zone, name, instances)
+	}
+	return c.client.RemoveInstanceGroupInstances(project, zone, name, instances)
+}
+
 // DeprecateImage uses the override method DeprecateImageFn or the real implementation.
 func (c *TestClient) DeprecateImage(project, name string, deprecationstatus *compute.DeprecationStatus) error {
 	if c.DeprecateImageFn != nil {
@@ -328,6 +485,14 @@ func (c *TestClient) GetZone(project, zone string) (*compute.Zone, error) {
 	return c.client.GetZone(project, zone)
 }
 
+// GetRegion uses the override method GetRegionFn or the real implementation.
+func (c *TestClient) GetRegion(project, region string) (*compute.Region, error) {
+	if c.GetRegionFn != nil {
+		return c.GetRegionFn(project, region)
+	}
+	return c.client.GetRegion(project, region)
+}
+
 // ListZones uses the override method ListZonesFn or the real implementation.
 func (c *TestClient) ListZones(project string, opts ...ListCallOption) ([]*compute.Zone, error) {
 	if c.ListZonesFn != nil {
@@ -336,9 +501,25 @@ func (c *TestClient) ListZones(project string, opts ...ListCallOption) ([]*compu
 	return c.client.ListZones(project, opts...)
 }
 
+// ListRegions uses the override method ListRegionsFn or the real implementation.
+func (c *TestClient) ListRegions(project string, opts ...ListCallOption) ([]*compute.Region, error) {
+	if c.ListRegionsFn != nil {
+		return c.ListRegionsFn(project, opts...)
+	}
+	return c.client.ListRegions(project, opts...)
+}
+
+// CreateSnapshot uses the override method CreateSnapshotFn or the real implementation.
+func (c *TestClient) CreateSnapshot(project, zone, disk string, s *compute.Snapshot, guestFlush bool) error {
+	if c.CreateSnapshotFn != nil {
+		return c.CreateSnapshotFn(project, zone, disk, s, guestFlush)
+	}
+	return c.client.CreateSnapshot(project, zone, disk, s, guestFlush)
+}
+
 // GetSnapshot uses the override method GetSnapshotFn or the real implementation.
 func (c *TestClient) GetSnapshot(project, name string) (*compute.Snapshot, error) {
-	if c.GetInstanceFn != nil {
+	if c.GetSnapshotFn != nil {
 		return c.GetSnapshotFn(project, name)
 	}
 	return c.client.GetSnapshot(project, name)
@@ -346,7 +527,7 @@ func (c *TestClient) GetSnapshot(project, name string) (*compute.Snapshot, error
 
 // ListSnapshots uses the override method ListSnapshotsFn or the real implementation.
 func (c *TestClient) ListSnapshots(project string, opts ...ListCallOption) ([]*compute.Snapshot, error) {
-	if c.GetInstanceFn != nil {
+	if c.ListSnapshotsFn != nil {
 		return c.ListSnapshotsFn(project, opts...)
 	}
 	return c.client.ListSnapshots(project, opts...)
@@ -354,7 +535,7 @@ func (c *TestClient) ListSnapshots(project string, opts ...ListCallOption) ([]*c
 
 // DeleteSnapshot uses the override method DeleteSnapshotFn or the real implementation.
 func (c *TestClient) DeleteSnapshot(project, name string) error {
-	if c.GetInstanceFn != nil {
+	if c.DeleteSnapshotFn != nil {
 		return c.DeleteSnapshotFn(project, name)
 	}
 	return c.client.DeleteSnapshot(project, name)
@@ -392,6 +573,14 @@ func (c *TestClient) GetDisk(project, zone, name string) (*compute.Disk, error)
 	return c.client.GetDisk(project, zone, name)
 }
 
+// GetRegionDisk uses the override method GetRegionDiskFn or the real implementation.
+func (c *TestClient) GetRegionDisk(project, region, name string) (*compute.Disk, error) {
+	if c.GetRegionDiskFn != nil {
+		return c.GetRegionDiskFn(project, region, name)
+	}
+	return c.client.GetRegionDisk(project, region, name)
+}
+
 // AggregatedListDisks uses the override method ListInstancesFn or the real implementation.
 func (c *TestClient) AggregatedListDisks(project string, opts ...ListCallOption) ([]*compute.Disk, error) {
 	if c.AggregatedListDisksFn != nil {
@@ -408,6 +597,14 @@ func (c *TestClient) ListDisks(project, zone string, opts ...ListCallOption) ([]
 	return c.client.ListDisks(project, zone, opts...)
 }
 
+// GetAddress uses the override method GetAddressFn or the real implementation.
+func (c *TestClient) GetAddress(project, region, name string) (*compute.Address, error) {
+	if c.GetAddressFn != nil {
+		return c.GetAddressFn(project, region, name)
+	}
+	return c.client.GetAddress(project, region, name)
+}
+
 // GetForwardingRule uses the override method GetForwardingRuleFn or the real implementation.
 func (c *TestClient) GetForwardingRule(project, region, name string) (*compute.ForwardingRule, error) {
 	if c.GetForwardingRuleFn != nil {
@@ -416,6 +613,30 @@ func (c *TestClient) GetForwardingRule(project, region, name string) (*compute.F
 	return c.client.GetForwardingRule(project, region, name)
 }
 
+// AggregatedListAddresses uses the override method AggregatedListAddressesFn or the real implementation.
+func (c *TestClient) AggregatedListAddresses(project string, opts ...ListCallOption) ([]*compute.Address, error) {
+	if c.AggregatedListAddressesFn != nil {
+		return c.AggregatedListAddressesFn(project, opts...)
+	}
+	return c.client.AggregatedListAddresses(project, opts...)
+}
+
+// ListAddresses uses the override method ListAddressesFn or the real implementation.
+func (c *TestClient) ListAddresses(project, region string, opts ...ListCallOption) ([]*compute.Address, error) {
+	if c.ListAddressesFn != nil {
+		return c.ListAddressesFn(project, region, opts...)
+	}
+	return c.client.ListAddresses(project, region, opts...)
+}
+
+// AggregatedListForwardingRules uses the override method AggregatedListForwardingRulesFn or the real implementation.
+func (c *TestClient) AggregatedListForwardingRules(project string, opts ...ListCallOption) ([]*compute.ForwardingRule, error) {
+	if c.AggregatedListForwardingRulesFn != nil {
+		return c.AggregatedListForwardingRulesFn(project, opts...)
+	}
+	return c.client.AggregatedListForwardingRules(project, opts...)
+}
+
 // ListForwardingRules uses the override method ListForwardingRulesFn or the real implementation.
 func (c *TestClient) ListForwardingRules(project, region string, opts ...ListCallOption) ([]*compute.ForwardingRule, error) {
 	if c.ListForwardingRulesFn != nil {
@@ -536,6 +757,14 @@ func (c *TestClient) ListTargetInstances(project, zone string, opts ...ListCallO
 	return c.client.ListTargetInstances(project, zone, opts...)
 }
 
+// GetScreenshot uses the override method GetScreenshotFn or the real implementation.
+func (c *TestClient) GetScreenshot(project, zone, name string) ([]byte, error) {
+	if c.GetScreenshotFn != nil {
+		return c.GetScreenshotFn(project, zone, name)
+	}
+	return c.client.GetScreenshot(project, zone, name)
+}
+
 // GetSerialPortOutput uses the override method GetSerialPortOutputFn or the real implementation.
 func (c *TestClient) GetSerialPortOutput(project, zone, name string, port, start int64) (*compute.SerialPortOutput, error) {
 	if c.GetSerialPortOutputFn != nil {
@@ -568,6 +797,14 @@ func (c *TestClient) InstanceStopped(project, zone, name string) (bool, error) {
 	return c.client.InstanceStopped(project, zone, name)
 }
 
+// InstancePreempted uses the override method InstancePreemptedFn or the real implementation.
+func (c *TestClient) InstancePreempted(project, zone, name string) (bool, error) {
+	if c.InstancePreemptedFn != nil {
+		return c.InstancePreemptedFn(project, zone, name)
+	}
+	return c.client.InstancePreempted(project, zone, name)
+}
+
 // ResizeDisk uses the override method ResizeDiskFn or the real implementation.
 func (c *TestClient) ResizeDisk(project, zone, disk string, drr *compute.DisksResizeRequest) error {
 	if c.ResizeDiskFn != nil {
@@ -655,3 +892,19 @@ func (c *TestClient) CreateInstanceBeta(project, zone string, i *computeBeta.Ins
 	}
 	return c.client.CreateInstanceBeta(project, zone, i)
 }
+
+// CreateInstanceBetaWithTerminationAction uses the override method CreateInstanceBetaWithTerminationActionFn or the real implementation.
+func (c *TestClient) CreateInstanceBetaWithTerminationAction(project, zone string, i *computeBeta.Instance, action string) error {
+	if c.CreateInstanceBetaWithTerminationActionFn != nil {
+		return c.CreateInstanceBetaWithTerminationActionFn(project, zone, i, action)
+	}
+	return c.client.CreateInstanceBetaWithTerminationAction(project, zone, i, action)
+}
+
+// CreateInstanceBetaWithOptions uses the override method CreateInstanceBetaWithOptionsFn or the real implementation.
+func (c *TestClient) CreateInstanceBetaWithOptions(project, zone string, i *computeBeta.Instance, opts InstanceOptions) error {
+	if c.CreateInstanceBetaWithOptionsFn != nil {
+		return c.CreateInstanceBetaWithOptionsFn(project, zone, i, opts)
+	}
+	return c.client.CreateInstanceBetaWithOptions(project, zone, i, opts)
+}
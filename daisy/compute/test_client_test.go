@@ -51,10 +51,12 @@ func TestTestClient(t *testing.T) {
 		{"create firewall rule", func() { c.CreateFirewallRule("a", &compute.Firewall{}) }, "/a/global/firewalls?alt=json&prettyPrint=false"},
 		{"create image", func() { c.CreateImage("a", &compute.Image{}) }, "/a/global/images?alt=json&prettyPrint=false"},
 		{"create instance", func() { c.CreateInstance("a", "b", &compute.Instance{}) }, "/a/zones/b/instances?alt=json&prettyPrint=false"},
+		{"create snapshot", func() { c.CreateSnapshot("a", "b", "c", &compute.Snapshot{}, true) }, "/a/zones/b/disks/c/createSnapshot?alt=json&guestFlush=true&prettyPrint=false"},
 		{"create network", func() { c.CreateNetwork("a", &compute.Network{}) }, "/a/global/networks?alt=json&prettyPrint=false"},
 		{"create subnetwork", func() { c.CreateSubnetwork("a", "b", &compute.Subnetwork{}) }, "/a/regions/b/subnetworks?alt=json&prettyPrint=false"},
 		{"instances start", func() { c.StartInstance("a", "b", "c") }, "/a/zones/b/instances/c/start?alt=json&prettyPrint=false"},
 		{"instances stop", func() { c.StopInstance("a", "b", "c") }, "/a/zones/b/instances/c/stop?alt=json&prettyPrint=false"},
+		{"instances set machine type", func() { c.SetMachineType("a", "b", "c", "d") }, "/a/zones/b/instances/c/setMachineType?alt=json&prettyPrint=false"},
 		{"delete disk", func() { c.DeleteDisk("a", "b", "c") }, "/a/zones/b/disks/c?alt=json&prettyPrint=false"},
 		{"delete firewall rule", func() { c.DeleteFirewallRule("a", "b") }, "/a/global/firewalls/b?alt=json&prettyPrint=false"},
 		{"delete image", func() { c.DeleteImage("a", "b") }, "/a/global/images/b?alt=json&prettyPrint=false"},
@@ -70,6 +72,7 @@ func TestTestClient(t *testing.T) {
 		{"list firewall rules", func() { c.ListFirewallRules("a", listOpts...) }, "/a/global/firewalls?alt=json&filter=foo&orderBy=foo&pageToken=&prettyPrint=false"},
 		{"get zone", func() { c.GetZone("a", "b") }, "/a/zones/b?alt=json&prettyPrint=false"},
 		{"list zones", func() { c.ListZones("a", listOpts...) }, "/a/zones?alt=json&filter=foo&orderBy=foo&pageToken=&prettyPrint=false"},
+		{"get region", func() { c.GetRegion("a", "b") }, "/a/regions/b?alt=json&prettyPrint=false"},
 		{"get instance", func() { c.GetInstance("a", "b", "c") }, "/a/zones/b/instances/c?alt=json&prettyPrint=false"},
 		{"aggregated list instances", func() { c.AggregatedListInstances("a", listOpts...) }, "/a/aggregated/instances?alt=json&filter=foo&orderBy=foo&pageToken=&prettyPrint=false"},
 		{"list instances", func() { c.ListInstances("a", "b", listOpts...) }, "/a/zones/b/instances?alt=json&filter=foo&orderBy=foo&pageToken=&prettyPrint=false"},
@@ -85,6 +88,8 @@ func TestTestClient(t *testing.T) {
 		{"get disk", func() { c.GetDisk("a", "b", "c") }, "/a/zones/b/disks/c?alt=json&prettyPrint=false"},
 		{"aggregated list disks", func() { c.AggregatedListDisks("a", listOpts...) }, "/a/aggregated/disks?alt=json&filter=foo&orderBy=foo&pageToken=&prettyPrint=false"},
 		{"list disks", func() { c.ListDisks("a", "b", listOpts...) }, "/a/zones/b/disks?alt=json&filter=foo&orderBy=foo&pageToken=&prettyPrint=false"},
+		{"aggregated list addresses", func() { c.AggregatedListAddresses("a", listOpts...) }, "/a/aggregated/addresses?alt=json&filter=foo&orderBy=foo&pageToken=&prettyPrint=false"},
+		{"aggregated list forwarding rules", func() { c.AggregatedListForwardingRules("a", listOpts...) }, "/a/aggregated/forwardingRules?alt=json&filter=foo&orderBy=foo&pageToken=&prettyPrint=false"},
 		{"instance status", func() { c.InstanceStatus("a", "b", "c") }, "/a/zones/b/instances/c?alt=json&prettyPrint=false"},
 		{"instance stopped", func() { c.InstanceStopped("a", "b", "c") }, "/a/zones/b/instances/c?alt=json&prettyPrint=false"},
 		{"set instance metadata", func() { c.SetInstanceMetadata("a", "b", "c", nil) }, "/a/zones/b/instances/c/setMetadata?alt=json&prettyPrint=false"},
@@ -97,6 +102,11 @@ func TestTestClient(t *testing.T) {
 		{"get machine image", func() { c.GetMachineImage("a", "b") }, "/a/global/machineImages/b?alt=json&prettyPrint=false"},
 		{"list machine images", func() { c.ListMachineImages("a", listOpts...) }, "/a/global/machineImages?alt=json&filter=foo&orderBy=foo&pageToken=&prettyPrint=false"},
 		{"delete machine image", func() { c.DeleteMachineImage("a", "b") }, "/a/global/machineImages/b?alt=json&prettyPrint=false"},
+		{"create instance group", func() { c.CreateInstanceGroup("a", "b", &compute.InstanceGroup{}) }, "/a/zones/b/instanceGroups?alt=json&prettyPrint=false"},
+		{"get instance group", func() { c.GetInstanceGroup("a", "b", "c") }, "/a/zones/b/instanceGroups/c?alt=json&prettyPrint=false"},
+		{"delete instance group", func() { c.DeleteInstanceGroup("a", "b", "c") }, "/a/zones/b/instanceGroups/c?alt=json&prettyPrint=false"},
+		{"add instance group instances", func() { c.AddInstanceGroupInstances("a", "b", "c", []string{"d"}) }, "/a/zones/b/instanceGroups/c/addInstances?alt=json&prettyPrint=false"},
+		{"remove instance group instances", func() { c.RemoveInstanceGroupInstances("a", "b", "c", []string{"d"}) }, "/a/zones/b/instanceGroups/c/removeInstances?alt=json&prettyPrint=false"},
 	}
 
 	runTests := func() {
@@ -133,10 +143,12 @@ func TestTestClient(t *testing.T) {
 	c.CreateFirewallRuleFn = func(_ string, _ *compute.Firewall) error { fakeCalled = true; return nil }
 	c.CreateImageFn = func(_ string, _ *compute.Image) error { fakeCalled = true; return nil }
 	c.CreateInstanceFn = func(_, _ string, _ *compute.Instance) error { fakeCalled = true; return nil }
+	c.CreateSnapshotFn = func(_, _, _ string, _ *compute.Snapshot, _ bool) error { fakeCalled = true; return nil }
 	c.CreateNetworkFn = func(_ string, _ *compute.Network) error { fakeCalled = true; return nil }
 	c.CreateSubnetworkFn = func(_, _ string, _ *compute.Subnetwork) error { fakeCalled = true; return nil }
 	c.StartInstanceFn = func(_, _, _ string) error { fakeCalled = true; return nil }
 	c.StopInstanceFn = func(_, _, _ string) error { fakeCalled = true; return nil }
+	c.SetMachineTypeFn = func(_, _, _, _ string) error { fakeCalled = true; return nil }
 	c.DeleteDiskFn = func(_, _, _ string) error { fakeCalled = true; return nil }
 	c.DeleteFirewallRuleFn = func(_, _ string) error { fakeCalled = true; return nil }
 	c.DeleteImageFn = func(_, _ string) error { fakeCalled = true; return nil }
@@ -150,6 +162,7 @@ func TestTestClient(t *testing.T) {
 	}
 	c.GetProjectFn = func(_ string) (*compute.Project, error) { fakeCalled = true; return nil, nil }
 	c.GetZoneFn = func(_, _ string) (*compute.Zone, error) { fakeCalled = true; return nil, nil }
+	c.GetRegionFn = func(_, _ string) (*compute.Region, error) { fakeCalled = true; return nil, nil }
 	c.ListZonesFn = func(_ string, _ ...ListCallOption) ([]*compute.Zone, error) {
 		fakeCalled = true
 		return nil, nil
@@ -177,6 +190,14 @@ func TestTestClient(t *testing.T) {
 		fakeCalled = true
 		return nil, nil
 	}
+	c.AggregatedListAddressesFn = func(_ string, _ ...ListCallOption) ([]*compute.Address, error) {
+		fakeCalled = true
+		return nil, nil
+	}
+	c.AggregatedListForwardingRulesFn = func(_ string, _ ...ListCallOption) ([]*compute.ForwardingRule, error) {
+		fakeCalled = true
+		return nil, nil
+	}
 	c.GetImageFromFamilyFn = func(_, _ string) (*compute.Image, error) { fakeCalled = true; return nil, nil }
 	c.GetImageFn = func(_, _ string) (*compute.Image, error) { fakeCalled = true; return nil, nil }
 	c.ListImagesFn = func(_ string, _ ...ListCallOption) ([]*compute.Image, error) {
@@ -218,6 +239,11 @@ func TestTestClient(t *testing.T) {
 		return nil, nil
 	}
 	c.DeleteMachineImageFn = func(_, _ string) error { fakeCalled = true; return nil }
+	c.CreateInstanceGroupFn = func(_, _ string, _ *compute.InstanceGroup) error { fakeCalled = true; return nil }
+	c.GetInstanceGroupFn = func(_, _, _ string) (*compute.InstanceGroup, error) { fakeCalled = true; return nil, nil }
+	c.DeleteInstanceGroupFn = func(_, _, _ string) error { fakeCalled = true; return nil }
+	c.AddInstanceGroupInstancesFn = func(_, _, _ string, _ []string) error { fakeCalled = true; return nil }
+	c.RemoveInstanceGroupInstancesFn = func(_, _, _ string, _ []string) error { fakeCalled = true; return nil }
 	wantFakeCalled = true
 	wantRealCalled = false
 	runTests()
@@ -0,0 +1,131 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+)
+
+const (
+	containerDeclarationMetadataKey = "gce-container-declaration"
+	defaultContainerImage           = "projects/cos-cloud/global/images/family/cos-stable"
+	defaultContainerRestartPolicy   = "Never"
+)
+
+var (
+	// containerImageRgx matches a container image reference such as
+	// "gcr.io/my-project/builder:v1" or "ubuntu@sha256:<digest>".
+	containerImageRgx             = regexp.MustCompile(`^([a-zA-Z0-9.-]+(:[0-9]+)?/)?[a-z0-9]+([._-][a-z0-9]+)*(/[a-z0-9]+([._-][a-z0-9]+)*)*(:[a-zA-Z0-9_][a-zA-Z0-9_.-]{0,127})?(@sha256:[a-f0-9]{64})?$`)
+	validContainerRestartPolicies = []string{"Always", "OnFailure", "Never"}
+)
+
+// Container is a container to run on a Container-Optimized OS instance. It
+// is passed to the instance in the gce-container-declaration metadata key.
+type Container struct {
+	// Image is the container image to run, e.g. "gcr.io/my-project/builder:v1".
+	Image string `json:",omitempty"`
+	// Command overrides the image's entrypoint.
+	Command []string `json:",omitempty"`
+	// Args overrides the image's default arguments.
+	Args []string `json:",omitempty"`
+	// Env sets environment variables in the container.
+	Env map[string]string `json:",omitempty"`
+	// Privileged runs the container in privileged mode.
+	Privileged bool `json:",omitempty"`
+	// RestartPolicy is one of "Always", "OnFailure" or "Never". Defaults to
+	// "Never" so the container runs once.
+	RestartPolicy string `json:",omitempty"`
+}
+
+type containerEnvVar struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type containerSpec struct {
+	Name            string            `json:"name"`
+	Image           string            `json:"image"`
+	Command         []string          `json:"command,omitempty"`
+	Args            []string          `json:"args,omitempty"`
+	Env             []containerEnvVar `json:"env,omitempty"`
+	SecurityContext struct {
+		Privileged bool `json:"privileged"`
+	} `json:"securityContext"`
+	Stdin bool `json:"stdin"`
+	TTY   bool `json:"tty"`
+}
+
+// containerDeclaration is the format of the gce-container-declaration
+// metadata value. It is read as YAML, of which JSON is a subset.
+type containerDeclaration struct {
+	Spec struct {
+		Containers    []containerSpec `json:"containers"`
+		RestartPolicy string          `json:"restartPolicy"`
+	} `json:"spec"`
+}
+
+// declaration returns the gce-container-declaration metadata value that runs
+// the container as name.
+func (c *Container) declaration(name string) (string, DError) {
+	cs := containerSpec{Name: name, Image: c.Image, Command: c.Command, Args: c.Args}
+	var keys []string
+	for k := range c.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		cs.Env = append(cs.Env, containerEnvVar{Name: k, Value: c.Env[k]})
+	}
+	cs.SecurityContext.Privileged = c.Privileged
+	var d containerDeclaration
+	d.Spec.Containers = []containerSpec{cs}
+	d.Spec.RestartPolicy = strOr(c.RestartPolicy, defaultContainerRestartPolicy)
+	b, err := json.Marshal(d)
+	if err != nil {
+		return "", newErr("failed to marshal container declaration", err)
+	}
+	return string(b), nil
+}
+
+func (c *Container) validate(pre string) DError {
+	var errs DError
+	if c.Image == "" {
+		errs = addErrs(errs, Errf("%s: Container Image must be set", pre))
+	} else if !containerImageRgx.MatchString(c.Image) {
+		errs = addErrs(errs, Errf("%s: bad Container Image %q", pre, c.Image))
+	}
+	if c.RestartPolicy != "" && !strIn(c.RestartPolicy, validContainerRestartPolicies) {
+		errs = addErrs(errs, Errf("%s: bad Container RestartPolicy %q, must be one of %q", pre, c.RestartPolicy, validContainerRestartPolicies))
+	}
+	return errs
+}
+
+// populateContainerMetadata sets the container declaration metadata.
+func (ib *InstanceBase) populateContainerMetadata(ii InstanceInterface) DError {
+	if ib.Container == nil {
+		return nil
+	}
+	if _, ok := ii.getMetadata()[containerDeclarationMetadataKey]; ok {
+		return Errf("Container is set but metadata %q is also set", containerDeclarationMetadataKey)
+	}
+	d, err := ib.Container.declaration(ii.getName())
+	if err != nil {
+		return err
+	}
+	ii.getMetadata()[containerDeclarationMetadataKey] = d
+	return nil
+}
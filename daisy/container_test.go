@@ -0,0 +1,89 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	computeBeta "google.golang.org/api/compute/v0.beta"
+	"google.golang.org/api/compute/v1"
+)
+
+func TestContainerDeclaration(t *testing.T) {
+	c := &Container{
+		Image:      "gcr.io/my-project/builder:v1",
+		Args:       []string{"--out", "gs://bkt"},
+		Env:        map[string]string{"B": "2", "A": "1"},
+		Privileged: true,
+	}
+	got, err := c.declaration("i1")
+
+	assert.Nil(t, err)
+	assert.Equal(t, `{"spec":{"containers":[{"name":"i1","image":"gcr.io/my-project/builder:v1","args":["--out","gs://bkt"],`+
+		`"env":[{"name":"A","value":"1"},{"name":"B","value":"2"}],"securityContext":{"privileged":true},"stdin":false,"tty":false}],`+
+		`"restartPolicy":"Never"}}`, got)
+}
+
+func TestContainerValidate(t *testing.T) {
+	tests := []struct {
+		desc    string
+		c       Container
+		wantErr string
+	}{
+		{"simple image", Container{Image: "ubuntu"}, ""},
+		{"registry image with tag", Container{Image: "gcr.io/my-project/builder:v1", RestartPolicy: "OnFailure"}, ""},
+		{"registry with port", Container{Image: "localhost:5000/builder"}, ""},
+		{"digest", Container{Image: "ubuntu@sha256:" + strings.Repeat("a", 64)}, ""},
+		{"no image", Container{}, "pre: Container Image must be set"},
+		{"bad image", Container{Image: "gcr.io/My Project/builder"}, `pre: bad Container Image "gcr.io/My Project/builder"`},
+		{"bad restart policy", Container{Image: "ubuntu", RestartPolicy: "Sometimes"}, `pre: bad Container RestartPolicy "Sometimes", must be one of ["Always" "OnFailure" "Never"]`},
+	}
+
+	for _, tt := range tests {
+		err := tt.c.validate("pre")
+		if tt.wantErr == "" {
+			assert.Nil(t, err, tt.desc)
+		} else {
+			assert.EqualError(t, err, tt.wantErr, tt.desc)
+		}
+	}
+}
+
+func TestInstancePopulateContainer(t *testing.T) {
+	w := testWorkflow()
+	s, _ := w.NewStep("s")
+
+	i := &Instance{InstanceBase: InstanceBase{Container: &Container{Image: "ubuntu"}}}
+	i.Name = "i1"
+	assert.Nil(t, i.InstanceBase.populate(context.Background(), i, s))
+	assert.Equal(t, 1, len(i.Disks))
+	assert.Equal(t, defaultContainerImage, i.Disks[0].InitializeParams.SourceImage)
+	assert.True(t, i.Disks[0].Boot)
+	assert.Contains(t, i.Metadata[containerDeclarationMetadataKey], `"image":"ubuntu"`)
+
+	ib := &InstanceBeta{InstanceBase: InstanceBase{Container: &Container{Image: "ubuntu"}}}
+	ib.Name = "i2"
+	ib.Disks = []*computeBeta.AttachedDisk{{Source: "d1"}}
+	assert.Nil(t, ib.InstanceBase.populate(context.Background(), ib, s))
+	assert.Equal(t, 1, len(ib.Disks))
+	assert.Equal(t, "d1", ib.Disks[0].Source)
+
+	i = &Instance{InstanceBase: InstanceBase{Container: &Container{Image: "ubuntu"}}, Metadata: map[string]string{containerDeclarationMetadataKey: "spec: {}"}}
+	i.Disks = []*compute.AttachedDisk{{Source: "d1"}}
+	assert.EqualError(t, i.InstanceBase.populate(context.Background(), i, s), `Container is set but metadata "gce-container-declaration" is also set`)
+}
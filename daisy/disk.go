@@ -19,6 +19,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"path"
 	"regexp"
 	"strconv"
 	"strings"
@@ -29,8 +30,12 @@ import (
 )
 
 var (
-	diskURLRgx       = regexp.MustCompile(fmt.Sprintf(`^(projects/(?P<project>%[1]s)/)?zones/(?P<zone>%[2]s)/disks/(?P<disk>%[2]s)(/resize)?$`, projectRgxStr, rfc1035))
-	deviceNameURLRgx = regexp.MustCompile(fmt.Sprintf(`^(projects/(?P<project>%[1]s)/)?zones/(?P<zone>%[2]s)/devices/(?P<disk>%[2]s)$`, projectRgxStr, rfc1035))
+	diskURLRgx = regexp.MustCompile(fmt.Sprintf(`^(projects/(?P<project>%[1]s)/)?zones/(?P<zone>%[2]s)/disks/(?P<disk>%[2]s)(/resize)?$`, projectRgxStr, rfc1035))
+	// regionalDiskURLRgx matches existing regional disks, which Daisy can
+	// attach but not create.
+	regionalDiskURLRgx = regexp.MustCompile(fmt.Sprintf(`^(projects/(?P<project>%[1]s)/)?regions/(?P<region>%[2]s)/disks/(?P<disk>%[2]s)$`, projectRgxStr, rfc1035))
+	anyDiskURLRgx      = regexp.MustCompile(fmt.Sprintf(`(%s)|(%s)`, diskURLRgx, regionalDiskURLRgx))
+	deviceNameURLRgx   = regexp.MustCompile(fmt.Sprintf(`^(projects/(?P<project>%[1]s)/)?zones/(?P<zone>%[2]s)/devices/(?P<disk>%[2]s)$`, projectRgxStr, rfc1035))
 )
 
 // diskExists should only be used during validation for existing GCE disks
@@ -41,6 +46,36 @@ func (w *Workflow) diskExists(project, zone, disk string) (bool, DError) {
 	}, project, zone, disk)
 }
 
+// regionalDiskExists should only be used during validation for existing GCE
+// regional disks.
+func (w *Workflow) regionalDiskExists(project, region, disk string) (bool, DError) {
+	if _, err := w.ComputeClient.GetRegionDisk(project, region, disk); err != nil {
+		if gErr, ok := err.(*googleapi.Error); ok && gErr.Code == http.StatusNotFound {
+			return false, nil
+		}
+		return false, typedErr(apiError, "failed to get regional disk", err)
+	}
+	return true, nil
+}
+
+// validateRegionalDiskZone checks that the regional disk at link is
+// replicated to zone, the zone of the instance it is attached to.
+func validateRegionalDiskZone(w *Workflow, pre, link, zone string) DError {
+	m := NamedSubexp(regionalDiskURLRgx, link)
+	d, err := w.ComputeClient.GetRegionDisk(m["project"], m["region"], m["disk"])
+	if err != nil {
+		return typedErrf(apiError, "%s: failed to get regional disk %q: %v", pre, link, err)
+	}
+	var zones []string
+	for _, z := range d.ReplicaZones {
+		if path.Base(z) == path.Base(zone) {
+			return nil
+		}
+		zones = append(zones, path.Base(z))
+	}
+	return Errf("%s: regional disk %q is replicated to zones %q, not to the instance zone %q", pre, link, zones, path.Base(zone))
+}
+
 // isDiskAttached should only be used during validation for existing attached GCE disks
 // and should not be relied or populated for daisy created resources.
 func isDiskAttached(client daisyCompute.Client, deviceName, project, zone, instance string) (bool, DError) {
@@ -75,6 +110,25 @@ type Disk struct {
 
 	// Fallback to pd-standard when quota is not enough for higher-level pd
 	FallbackToPdStandard bool `json:"fallbackToPdStandard,omitempty"`
+
+	// IOPS and throughput, in MB/s, to provision for hyperdisk types.
+	ProvisionedIops       string `json:"provisionedIops,omitempty"`
+	ProvisionedThroughput string `json:"provisionedThroughput,omitempty"`
+	provisionedIops       int64
+	provisionedThroughput int64
+
+	// Resource manager tags to set on the disk, e.g. for IAM conditions.
+	// Keys are "tagKeys/<id>" and values "tagValues/<id>".
+	ResourceManagerTags map[string]string `json:"resourceManagerTags,omitempty"`
+}
+
+// provisionedPerformance lists, for disk types that accept them, whether
+// ProvisionedIops and ProvisionedThroughput can be set.
+var provisionedPerformance = map[string]struct{ iops, throughput bool }{
+	"hyperdisk-balanced":   {iops: true, throughput: true},
+	"hyperdisk-extreme":    {iops: true},
+	"hyperdisk-ml":         {throughput: true},
+	"hyperdisk-throughput": {throughput: true},
 }
 
 // MarshalJSON is a hacky workaround to prevent Disk from using compute.Disk's implementation.
@@ -87,6 +141,7 @@ func (d *Disk) populate(ctx context.Context, s *Step) DError {
 	d.Name, d.Zone, errs = d.Resource.populateWithZone(ctx, s, d.Name, d.Zone)
 
 	d.Description = strOr(d.Description, fmt.Sprintf("Disk created by Daisy in workflow %q on behalf of %s.", s.w.Name, s.w.username))
+	d.Labels = withRunIDLabel(d.Labels, s.w.RunID())
 	if d.SizeGb != "" {
 		size, err := strconv.ParseInt(d.SizeGb, 10, 64)
 		if err != nil {
@@ -94,6 +149,20 @@ func (d *Disk) populate(ctx context.Context, s *Step) DError {
 		}
 		d.Disk.SizeGb = size
 	}
+	if d.ProvisionedIops != "" {
+		iops, err := strconv.ParseInt(d.ProvisionedIops, 10, 64)
+		if err != nil {
+			errs = addErrs(errs, Errf("cannot parse ProvisionedIops: %s, err: %v", d.ProvisionedIops, err))
+		}
+		d.provisionedIops = iops
+	}
+	if d.ProvisionedThroughput != "" {
+		throughput, err := strconv.ParseInt(d.ProvisionedThroughput, 10, 64)
+		if err != nil {
+			errs = addErrs(errs, Errf("cannot parse ProvisionedThroughput: %s, err: %v", d.ProvisionedThroughput, err))
+		}
+		d.provisionedThroughput = throughput
+	}
 
 	if d.IsWindows != "" {
 		isWindows, err := strconv.ParseBool(d.IsWindows)
@@ -106,7 +175,7 @@ func (d *Disk) populate(ctx context.Context, s *Step) DError {
 	}
 
 	if imageURLRgx.MatchString(d.SourceImage) {
-		d.SourceImage = extendPartialURL(d.SourceImage, d.Project)
+		d.SourceImage = s.w.imageURL(d.SourceImage, d.Project)
 	}
 	if d.Type == "" {
 		d.Type = fmt.Sprintf("projects/%s/zones/%s/diskTypes/pd-standard", d.Project, d.Zone)
@@ -125,7 +194,24 @@ func (d *Disk) validate(ctx context.Context, s *Step) DError {
 
 	if !diskTypeURLRgx.MatchString(d.Type) {
 		errs = addErrs(errs, Errf("%s: bad disk type: %q", pre, d.Type))
+	} else {
+		dt := NamedSubexp(diskTypeURLRgx, d.Type)["disktype"]
+		pp := provisionedPerformance[dt]
+		if d.ProvisionedIops != "" && !pp.iops {
+			errs = addErrs(errs, Errf("%s: ProvisionedIops can't be set for disk type %q", pre, dt))
+		}
+		if d.ProvisionedThroughput != "" && !pp.throughput {
+			errs = addErrs(errs, Errf("%s: ProvisionedThroughput can't be set for disk type %q", pre, dt))
+		}
 	}
+	if d.provisionedIops < 0 {
+		errs = addErrs(errs, Errf("%s: ProvisionedIops must not be negative, got %d", pre, d.provisionedIops))
+	}
+	if d.provisionedThroughput < 0 {
+		errs = addErrs(errs, Errf("%s: ProvisionedThroughput must not be negative, got %d", pre, d.provisionedThroughput))
+	}
+	errs = addErrs(errs, validateResourceManagerTags(pre, d.ResourceManagerTags))
+	errs = addErrs(errs, validateLabels(pre, d.Labels))
 
 	if d.SourceImage != "" {
 		if _, err := s.w.images.regUse(d.SourceImage, s); err != nil {
@@ -134,12 +220,48 @@ func (d *Disk) validate(ctx context.Context, s *Step) DError {
 	} else if d.Disk.SizeGb == 0 {
 		errs = addErrs(errs, Errf("%s: SizeGb and SourceImage not set", pre))
 	}
+	errs = addErrs(errs, s.w.validateLicenses(d.Licenses, false, pre))
 
 	// Register creation.
-	errs = addErrs(errs, s.w.disks.regCreate(d.daisyName, &d.Resource, s, false))
+	errs = addErrs(errs, s.w.disks.regCreate(d.daisyName, &d.Resource, s, s.w.canAdopt(&d.Resource)))
 	return errs
 }
 
+// create creates the disk in GCE, using the provisioned performance settings
+// and resource manager tags if any are set.
+func (d *Disk) create(client daisyCompute.Client) error {
+	if len(d.ResourceManagerTags) > 0 {
+		return client.CreateDiskWithOptions(d.Project, d.Zone, &d.Disk, daisyCompute.DiskOptions{ProvisionedIops: d.provisionedIops, ProvisionedThroughput: d.provisionedThroughput, ResourceManagerTags: d.ResourceManagerTags})
+	}
+	if d.provisionedIops != 0 || d.provisionedThroughput != 0 {
+		return client.CreateDiskWithProvisionedPerformance(d.Project, d.Zone, &d.Disk, d.provisionedIops, d.provisionedThroughput)
+	}
+	return client.CreateDisk(d.Project, d.Zone, &d.Disk)
+}
+
+// adoptExisting checks whether the disk already exists in GCE and, if its
+// configuration matches, reports that it can be used instead of creating it.
+func (d *Disk) adoptExisting(w *Workflow) (bool, DError) {
+	existing, err := w.ComputeClient.GetDisk(d.Project, d.Zone, d.Name)
+	if err != nil {
+		if isNotFound(err) {
+			return false, nil
+		}
+		return false, typedErr(apiError, "failed to get existing disk", err)
+	}
+
+	var m adoptMismatches
+	m.compareLink("Type", d.Type, existing.Type)
+	m.compareInt("SizeGb", d.Disk.SizeGb, existing.SizeGb)
+	if !strings.Contains(d.SourceImage, "/family/") {
+		m.compareLink("SourceImage", d.SourceImage, existing.SourceImage)
+	}
+	if err := m.err("disk", d.Name); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 type diskAttachment struct {
 	mode               string
 	attacher, detacher *Step
@@ -152,7 +274,7 @@ type diskRegistry struct {
 }
 
 func newDiskRegistry(w *Workflow) *diskRegistry {
-	dr := &diskRegistry{baseResourceRegistry: baseResourceRegistry{w: w, typeName: "disk", urlRgx: diskURLRgx}}
+	dr := &diskRegistry{baseResourceRegistry: baseResourceRegistry{w: w, typeName: "disk", urlRgx: anyDiskURLRgx}}
 	dr.baseResourceRegistry.deleteFn = dr.deleteFn
 	dr.init()
 	return dr
@@ -164,6 +286,9 @@ func (dr *diskRegistry) init() {
 }
 
 func (dr *diskRegistry) deleteFn(res *Resource) DError {
+	if regionalDiskURLRgx.MatchString(res.link) {
+		return Errf("failed to delete disk %q: deleting regional disks is not supported", res.link)
+	}
 	m := NamedSubexp(diskURLRgx, res.link)
 	err := dr.w.ComputeClient.DeleteDisk(m["project"], m["zone"], m["disk"])
 	if gErr, ok := err.(*googleapi.Error); ok && gErr.Code == http.StatusNotFound {
@@ -222,8 +347,8 @@ func (dr *diskRegistry) regAttach(dName, iName, mode string, s *Step) DError {
 			} else if strIn(diskModeRW, []string{mode, att.mode}) {
 				// Can't have concurrent attachment in RW mode.
 				return Errf(
-					"%s: concurrent RW attachment of disk %q between instances %q (%s) and %q (%s)",
-					pre, dName, iName, mode, attIName, att.mode)
+					"%s: concurrent RW attachment of disk %q between instances %q (%s) and %q (%s); attach it %s to both instances, or detach it from %q first",
+					pre, dName, iName, mode, attIName, att.mode, diskModeRO, attIName)
 			}
 		}
 	}
@@ -237,6 +362,32 @@ func (dr *diskRegistry) regAttach(dName, iName, mode string, s *Step) DError {
 	return nil
 }
 
+// attachedRW reports whether the dName disk is attached READ_WRITE to an
+// instance other than iName, by an earlier or the current step.
+func (dr *diskRegistry) attachedRW(dName, iName string) bool {
+	dr.mx.Lock()
+	defer dr.mx.Unlock()
+
+	for attIName, att := range dr.attachments[dName] {
+		if attIName != iName && att.mode == diskModeRW {
+			return true
+		}
+	}
+	return false
+}
+
+// attachedInstances returns the instances the dName disk is attached to.
+func (dr *diskRegistry) attachedInstances(dName string) []string {
+	dr.mx.Lock()
+	defer dr.mx.Unlock()
+
+	var is []string
+	for iName := range dr.attachments[dName] {
+		is = append(is, iName)
+	}
+	return is
+}
+
 // regDetach marks s as the detacher for the dName disk and iName instance.
 // Returns an error if dName or iName don't exist or if detachHelper returns an error.
 func (dr *diskRegistry) regDetach(dName, iName string, isAttached bool, s *Step) DError {
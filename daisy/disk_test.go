@@ -3,6 +3,7 @@ package daisy
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"testing"
 
 	"google.golang.org/api/compute/v1"
@@ -75,6 +76,30 @@ func TestDiskPopulate(t *testing.T) {
 			nil,
 			true,
 		},
+		{
+			"provisioned performance case",
+			&Disk{Disk: compute.Disk{Name: name, Type: "hyperdisk-balanced"}, ProvisionedIops: "10000", ProvisionedThroughput: "500"},
+			&Disk{
+				Disk:                  compute.Disk{Name: genName, Type: fmt.Sprintf("projects/%s/zones/%s/diskTypes/hyperdisk-balanced", w.Project, w.Zone), Zone: w.Zone},
+				ProvisionedIops:       "10000",
+				ProvisionedThroughput: "500",
+				provisionedIops:       10000,
+				provisionedThroughput: 500,
+			},
+			false,
+		},
+		{
+			"bad ProvisionedIops case",
+			&Disk{Disk: compute.Disk{Name: "foo", Type: "hyperdisk-balanced"}, ProvisionedIops: "lots"},
+			nil,
+			true,
+		},
+		{
+			"bad ProvisionedThroughput case",
+			&Disk{Disk: compute.Disk{Name: "foo", Type: "hyperdisk-balanced"}, ProvisionedThroughput: "lots"},
+			nil,
+			true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -83,6 +108,7 @@ func TestDiskPopulate(t *testing.T) {
 		// Test sanitation -- clean/set irrelevant fields.
 		if tt.want != nil {
 			tt.want.Description = tt.input.Description
+			tt.want.Labels = map[string]string{RunIDLabel: w.RunID()}
 		}
 		tt.input.Resource = Resource{} // These fields are tested in resource_test.
 
@@ -343,6 +369,7 @@ func TestDiskValidate(t *testing.T) {
 	w.images.m = map[string]*Resource{"i1": {creator: iCreator}} // "i1" resource
 
 	ty := fmt.Sprintf("projects/%s/zones/%s/diskTypes/%s", w.Project, w.Zone, "pd-standard")
+	hdType := func(t string) string { return fmt.Sprintf("projects/%s/zones/%s/diskTypes/%s", w.Project, w.Zone, t) }
 	tests := []struct {
 		desc      string
 		d         *Disk
@@ -393,6 +420,66 @@ func TestDiskValidate(t *testing.T) {
 			&Disk{Disk: compute.Disk{Name: "d7", SizeGb: 1, Type: "t!"}},
 			true,
 		},
+		{
+			"hyperdisk provisioned performance case",
+			&Disk{Disk: compute.Disk{Name: "d8", SizeGb: 1, Type: hdType("hyperdisk-balanced")}, ProvisionedIops: "10000", ProvisionedThroughput: "500"},
+			false,
+		},
+		{
+			"hyperdisk-extreme IOPS case",
+			&Disk{Disk: compute.Disk{Name: "d9", SizeGb: 1, Type: hdType("hyperdisk-extreme")}, ProvisionedIops: "10000"},
+			false,
+		},
+		{
+			"hyperdisk-extreme throughput case",
+			&Disk{Disk: compute.Disk{Name: "d10", SizeGb: 1, Type: hdType("hyperdisk-extreme")}, ProvisionedThroughput: "500"},
+			true,
+		},
+		{
+			"hyperdisk-throughput IOPS case",
+			&Disk{Disk: compute.Disk{Name: "d11", SizeGb: 1, Type: hdType("hyperdisk-throughput")}, ProvisionedIops: "10000"},
+			true,
+		},
+		{
+			"pd-standard IOPS case",
+			&Disk{Disk: compute.Disk{Name: "d12", SizeGb: 1, Type: ty}, ProvisionedIops: "10000"},
+			true,
+		},
+		{
+			"pd-standard throughput case",
+			&Disk{Disk: compute.Disk{Name: "d13", SizeGb: 1, Type: ty}, ProvisionedThroughput: "500"},
+			true,
+		},
+		{
+			"negative IOPS case",
+			&Disk{Disk: compute.Disk{Name: "d14", SizeGb: 1, Type: hdType("hyperdisk-balanced")}, ProvisionedIops: "-1"},
+			true,
+		},
+		{
+			"licenses case",
+			&Disk{Disk: compute.Disk{Name: "d15", SizeGb: 1, Type: ty, Licenses: []string{fmt.Sprintf("projects/%s/global/licenses/%s", testProject, testLicense)}}},
+			false,
+		},
+		{
+			"license dne case",
+			&Disk{Disk: compute.Disk{Name: "d16", SizeGb: 1, Type: ty, Licenses: []string{fmt.Sprintf("projects/%s/global/licenses/dne", testProject)}}},
+			true,
+		},
+		{
+			"bad license url case",
+			&Disk{Disk: compute.Disk{Name: "d17", SizeGb: 1, Type: ty, Licenses: []string{"licenses/" + testLicense}}},
+			true,
+		},
+		{
+			"resource manager tags case",
+			&Disk{Disk: compute.Disk{Name: "d18", SizeGb: 1, Type: ty}, ResourceManagerTags: map[string]string{"tagKeys/123": "tagValues/456"}},
+			false,
+		},
+		{
+			"bad resource manager tags case",
+			&Disk{Disk: compute.Disk{Name: "d19", SizeGb: 1, Type: ty}, ResourceManagerTags: map[string]string{"env": "tagValues/456"}},
+			true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -402,6 +489,9 @@ func TestDiskValidate(t *testing.T) {
 		tt.d.link = fmt.Sprintf("projects/%s/zones/%s/disks/%s", w.Project, w.Zone, tt.d.Name)
 		tt.d.Project = w.Project
 		tt.d.Zone = w.Zone
+		if tt.d.ProvisionedIops != "" {
+			tt.d.provisionedIops, _ = strconv.ParseInt(tt.d.ProvisionedIops, 10, 64)
+		}
 
 		s.CreateDisks = &CreateDisks{tt.d}
 		err := s.validate(context.Background())
@@ -25,6 +25,9 @@ const (
 	fileIOError               = "FileIOError"
 	resourceDNEError          = "ResourceDoesNotExist"
 	imageObsoleteDeletedError = "ImageObsoleteOrDeleted"
+	policyViolationError      = "PolicyViolation"
+	workflowCanceledError     = "WorkflowCanceled"
+	instancePreemptedError    = "InstancePreempted"
 
 	apiError    = "APIError"
 	apiError404 = "APIError404"
@@ -77,10 +80,11 @@ func Errf(format string, a ...interface{}) DError {
 }
 
 // wrapErrf returns a DError by keeping errors type and replacing original error message.
+// e stays reachable with errors.Is and errors.As.
 func wrapErrf(e DError, formatPrefix string, a ...interface{}) DError {
 	f := fmt.Sprintf("%v: %v", formatPrefix, strings.Join(e.AnonymizedErrs(), "; "))
 	return &dErrImpl{
-		errs:           []error{fmt.Errorf("%v: %v", fmt.Sprintf(formatPrefix, a...), e.Error())},
+		errs:           []error{fmt.Errorf("%v: %w", fmt.Sprintf(formatPrefix, a...), e)},
 		errsType:       e.errorsType(),
 		anonymizedErrs: []string{f},
 	}
@@ -208,3 +212,12 @@ func (e *dErrImpl) CausedByErrType(t string) bool {
 	}
 	return false
 }
+
+// Unwrap returns the error held by a DError with a single error, so callers
+// can inspect e.g. the *googleapi.Error returned by an API call.
+func (e *dErrImpl) Unwrap() error {
+	if e.len() != 1 {
+		return nil
+	}
+	return e.errs[0]
+}
@@ -18,6 +18,8 @@ import (
 	"errors"
 	"strings"
 	"testing"
+
+	"google.golang.org/api/googleapi"
 )
 
 func TestAddErrs(t *testing.T) {
@@ -147,3 +149,23 @@ func TestNestedAnonymizedDErrorMessage(t *testing.T) {
 	}
 
 }
+
+func TestWrapErrfUnwrap(t *testing.T) {
+	apiErr := &googleapi.Error{Code: 403, Message: "denied"}
+	e := wrapErrf(wrapErrf(typedErr(apiError, "failed to create instances", apiErr), "instance %s", "i"), "step %q run error", "s")
+
+	wantMsg := `APIError: step "s" run error: APIError: instance i: APIError: googleapi: Error 403: denied`
+	if got := e.Error(); got != wantMsg {
+		t.Errorf("unexpected error message, got: %q, want: %q", got, wantMsg)
+	}
+	var gotAPIErr *googleapi.Error
+	if !errors.As(e, &gotAPIErr) || gotAPIErr != apiErr {
+		t.Errorf("wrapped error doesn't unwrap to the API error, got: %v", gotAPIErr)
+	}
+
+	// A DError with multiple errors doesn't unwrap.
+	multi := addErrs(nil, errors.New("a"), errors.New("b"))
+	if got := errors.Unwrap(multi); got != nil {
+		t.Errorf("multiple error DError unwrapped to %v, want nil", got)
+	}
+}
@@ -0,0 +1,1024 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// Package fakes provides in-memory Compute and Storage backends for running
+// whole Daisy workflows in tests, without GCP.
+//
+//	c, _ := fakes.NewCompute("my-project", "us-central1-a")
+//	c.AddImage("debian-cloud", &compute.Image{Name: "debian-10-v1", Family: "debian-10"})
+//	c.ScriptInstance("inst-foo", fakes.InstanceScript{SerialOutput: map[int64]string{1: "BuildSuccess"}})
+//	defer c.Close()
+//	s, _ := fakes.NewStorage("my-bucket")
+//	defer s.Close()
+//	w.ComputeClient, w.StorageClient = c, s.Client
+//
+// After the run, Created, Deleted and Exists tell which resources the
+// workflow made and cleaned up.
+package fakes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	computeBeta "google.golang.org/api/compute/v0.beta"
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+)
+
+const selfLinkPrefix = "https://www.googleapis.com/compute/v1/"
+
+// InstanceScript scripts the behaviour of an instance once it is created.
+type InstanceScript struct {
+	// SerialOutput is the output of each serial port, by port number. It can
+	// be read while the instance is running.
+	SerialOutput map[int64]string
+	// StopAfter, if set, is how long after creation the instance stops by
+	// itself, as if it shut down at the end of its startup script.
+	StopAfter time.Duration
+	// Preempted makes the stop after StopAfter a preemption by GCE.
+	Preempted bool
+}
+
+// Compute is a daisyCompute.Client that keeps disks, images, instances,
+// networks, subnetworks and firewall rules in memory. Projects, zones,
+// machine types and licenses are accepted as long as they were added. Calls
+// for other resources fail with a 400 error.
+type Compute struct {
+	*daisyCompute.TestClient
+
+	server    *httptest.Server
+	mu        sync.Mutex
+	projects  map[string]bool
+	resources map[string]interface{}
+	scripts   map[string]InstanceScript
+	timers    []*time.Timer
+	created   []string
+	deleted   []string
+}
+
+// NewCompute returns a Compute with project and zones added to it, along
+// with the project's default network. zones defaults to us-central1-a.
+func NewCompute(project string, zones ...string) (*Compute, error) {
+	ts, tc, err := daisyCompute.NewTestClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"error":{"code":400,"message":"fakes: %s %s is not supported"}}`, r.Method, r.URL.Path)
+	}))
+	if err != nil {
+		return nil, err
+	}
+	c := &Compute{
+		TestClient: tc,
+		server:     ts,
+		projects:   map[string]bool{},
+		resources:  map[string]interface{}{},
+		scripts:    map[string]InstanceScript{},
+	}
+	c.setFns()
+
+	if len(zones) == 0 {
+		zones = []string{"us-central1-a"}
+	}
+	c.AddProject(project)
+	for _, z := range zones {
+		c.AddZone(project, z)
+	}
+	c.resources[fmt.Sprintf("projects/%s/global/networks/default", project)] = &compute.Network{
+		Name:     "default",
+		SelfLink: selfLinkPrefix + fmt.Sprintf("projects/%s/global/networks/default", project),
+	}
+	return c, nil
+}
+
+// AddProject makes project known to the fake.
+func (c *Compute) AddProject(project string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.projects[project] = true
+}
+
+// AddZone adds zone, and the region it is in, to project.
+func (c *Compute) AddZone(project, zone string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.projects[project] = true
+	region := zone
+	if i := strings.LastIndex(zone, "-"); i > 0 {
+		region = zone[:i]
+	}
+	regionPath := fmt.Sprintf("projects/%s/regions/%s", project, region)
+	c.resources[fmt.Sprintf("projects/%s/zones/%s", project, zone)] = &compute.Zone{
+		Name:     zone,
+		Region:   selfLinkPrefix + regionPath,
+		Status:   "UP",
+		SelfLink: selfLinkPrefix + fmt.Sprintf("projects/%s/zones/%s", project, zone),
+	}
+	if _, ok := c.resources[regionPath]; !ok {
+		c.resources[regionPath] = &compute.Region{Name: region, Status: "UP", SelfLink: selfLinkPrefix + regionPath}
+	}
+}
+
+// AddImage adds an existing image, such as a public source image, to
+// project. It is not reported by Created.
+func (c *Compute) AddImage(project string, i *compute.Image) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.projects[project] = true
+	p := fmt.Sprintf("projects/%s/global/images/%s", project, i.Name)
+	i.SelfLink = selfLinkPrefix + p
+	i.Status = "READY"
+	c.resources[p] = i
+}
+
+// AddLicense adds an existing license to project.
+func (c *Compute) AddLicense(project, name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.projects[project] = true
+	p := fmt.Sprintf("projects/%s/global/licenses/%s", project, name)
+	c.resources[p] = &compute.License{Name: name, SelfLink: selfLinkPrefix + p}
+}
+
+// ScriptInstance sets the behaviour of the instance called name, in any
+// project or zone, once it is created. As Daisy adds a suffix to the names
+// in a workflow, name also matches instances whose name is name followed by
+// "-" and some suffix.
+func (c *Compute) ScriptInstance(name string, s InstanceScript) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.scripts[name] = s
+}
+
+// Created returns the partial URLs, e.g. "projects/p/zones/z/disks/d", of
+// the resources created through the fake, in the order they were created.
+// Disks created along with an instance are included.
+func (c *Compute) Created() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.created...)
+}
+
+// Deleted returns the partial URLs of the resources deleted through the
+// fake, in the order they were deleted. Auto-deleted disks are included.
+func (c *Compute) Deleted() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.deleted...)
+}
+
+// Exists reports whether the resource at the partial URL p exists.
+func (c *Compute) Exists(p string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.resources[resourcePath(p)]
+	return ok
+}
+
+// Close stops the timers of scripted instances and shuts down the server
+// that unsupported calls go to.
+func (c *Compute) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, t := range c.timers {
+		t.Stop()
+	}
+	c.server.Close()
+}
+
+func notFound(p string) error {
+	return &googleapi.Error{Code: http.StatusNotFound, Message: fmt.Sprintf("The resource '%s' was not found", p)}
+}
+
+func alreadyExists(p string) error {
+	return &googleapi.Error{Code: http.StatusConflict, Message: fmt.Sprintf("The resource '%s' already exists", p)}
+}
+
+func inUse(p, user string) error {
+	return &googleapi.Error{Code: http.StatusBadRequest, Message: fmt.Sprintf("The resource '%s' is already being used by '%s'", p, user)}
+}
+
+// resourcePath strips the API prefix from a resource URL.
+func resourcePath(url string) string {
+	if i := strings.Index(url, "projects/"); i >= 0 {
+		return url[i:]
+	}
+	return url
+}
+
+// link returns the partial URL of the resource of the given kind in the
+// given scope, accepting either a name or a URL as ref.
+func link(scope, kind, ref string) string {
+	if strings.Contains(ref, "/") {
+		return resourcePath(ref)
+	}
+	return fmt.Sprintf("%s/%s/%s", scope, kind, ref)
+}
+
+func (c *Compute) ensureScope(project string, zoneOrRegion ...string) error {
+	if !c.projects[project] {
+		return notFound("projects/" + project)
+	}
+	for _, p := range zoneOrRegion {
+		if _, ok := c.resources[p]; !ok {
+			return notFound(p)
+		}
+	}
+	return nil
+}
+
+func (c *Compute) insert(p string, r interface{}) error {
+	if _, ok := c.resources[p]; ok {
+		return alreadyExists(p)
+	}
+	c.resources[p] = r
+	c.created = append(c.created, p)
+	return nil
+}
+
+func (c *Compute) get(p string) (interface{}, error) {
+	r, ok := c.resources[p]
+	if !ok {
+		return nil, notFound(p)
+	}
+	return r, nil
+}
+
+func (c *Compute) remove(p string) error {
+	if _, ok := c.resources[p]; !ok {
+		return notFound(p)
+	}
+	delete(c.resources, p)
+	c.deleted = append(c.deleted, p)
+	return nil
+}
+
+// list returns the resources directly under the partial URL prefix, sorted by
+// URL.
+func (c *Compute) list(prefix string) []interface{} {
+	var ps []string
+	for p := range c.resources {
+		if strings.HasPrefix(p, prefix+"/") && !strings.Contains(strings.TrimPrefix(p, prefix+"/"), "/") {
+			ps = append(ps, p)
+		}
+	}
+	sort.Strings(ps)
+	var rs []interface{}
+	for _, p := range ps {
+		rs = append(rs, c.resources[p])
+	}
+	return rs
+}
+
+// convert copies src into dst through their JSON representation, e.g. from a
+// Beta API resource into its GA counterpart.
+func convert(src, dst interface{}) error {
+	b, err := json.Marshal(src)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, dst)
+}
+
+func (c *Compute) setFns() {
+	c.GetProjectFn = func(project string) (*compute.Project, error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if err := c.ensureScope(project); err != nil {
+			return nil, err
+		}
+		return &compute.Project{Name: project}, nil
+	}
+	c.GetZoneFn = func(project, zone string) (*compute.Zone, error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		r, err := c.get(fmt.Sprintf("projects/%s/zones/%s", project, zone))
+		if err != nil {
+			return nil, err
+		}
+		return r.(*compute.Zone), nil
+	}
+	c.ListZonesFn = func(project string, _ ...daisyCompute.ListCallOption) ([]*compute.Zone, error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if err := c.ensureScope(project); err != nil {
+			return nil, err
+		}
+		var zs []*compute.Zone
+		for _, r := range c.list(fmt.Sprintf("projects/%s/zones", project)) {
+			if z, ok := r.(*compute.Zone); ok {
+				zs = append(zs, z)
+			}
+		}
+		return zs, nil
+	}
+	c.GetRegionFn = func(project, region string) (*compute.Region, error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		r, err := c.get(fmt.Sprintf("projects/%s/regions/%s", project, region))
+		if err != nil {
+			return nil, err
+		}
+		return r.(*compute.Region), nil
+	}
+	c.GetMachineTypeFn = func(project, zone, machineType string) (*compute.MachineType, error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if err := c.ensureScope(project, fmt.Sprintf("projects/%s/zones/%s", project, zone)); err != nil {
+			return nil, err
+		}
+		p := fmt.Sprintf("projects/%s/zones/%s/machineTypes/%s", project, zone, machineType)
+		return &compute.MachineType{Name: machineType, Zone: zone, GuestCpus: 1, SelfLink: selfLinkPrefix + p}, nil
+	}
+	c.ListMachineTypesFn = func(project, zone string, _ ...daisyCompute.ListCallOption) ([]*compute.MachineType, error) {
+		// Every machine type exists; GetMachineType is used for ones not listed.
+		return nil, nil
+	}
+	c.ListLicensesFn = func(project string, _ ...daisyCompute.ListCallOption) ([]*compute.License, error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		var ls []*compute.License
+		for _, r := range c.list(fmt.Sprintf("projects/%s/global/licenses", project)) {
+			ls = append(ls, r.(*compute.License))
+		}
+		return ls, nil
+	}
+	c.GetLicenseFn = func(project, name string) (*compute.License, error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		r, err := c.get(fmt.Sprintf("projects/%s/global/licenses/%s", project, name))
+		if err != nil {
+			return nil, err
+		}
+		return r.(*compute.License), nil
+	}
+	c.ListMachineImagesFn = func(project string, _ ...daisyCompute.ListCallOption) ([]*computeBeta.MachineImage, error) {
+		return nil, nil
+	}
+	c.SetCommonInstanceMetadataFn = func(project string, md *compute.Metadata) error {
+		return nil
+	}
+	c.setDiskFns()
+	c.setImageFns()
+	c.setSnapshotFns()
+	c.setInstanceFns()
+	c.setNetworkFns()
+}
+
+func (c *Compute) setDiskFns() {
+	c.CreateDiskFn = func(project, zone string, d *compute.Disk) error {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.createDisk(project, zone, d)
+	}
+	c.GetDiskFn = func(project, zone, name string) (*compute.Disk, error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		r, err := c.get(fmt.Sprintf("projects/%s/zones/%s/disks/%s", project, zone, name))
+		if err != nil {
+			return nil, err
+		}
+		d := *r.(*compute.Disk)
+		return &d, nil
+	}
+	c.ListDisksFn = func(project, zone string, _ ...daisyCompute.ListCallOption) ([]*compute.Disk, error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		var ds []*compute.Disk
+		for _, r := range c.list(fmt.Sprintf("projects/%s/zones/%s/disks", project, zone)) {
+			d := *r.(*compute.Disk)
+			ds = append(ds, &d)
+		}
+		return ds, nil
+	}
+	c.AggregatedListDisksFn = func(project string, _ ...daisyCompute.ListCallOption) ([]*compute.Disk, error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		var ds []*compute.Disk
+		for _, r := range c.resources {
+			if d, ok := r.(*compute.Disk); ok && strings.HasPrefix(resourcePath(d.SelfLink), "projects/"+project+"/") {
+				dc := *d
+				ds = append(ds, &dc)
+			}
+		}
+		sort.Slice(ds, func(i, j int) bool { return ds[i].SelfLink < ds[j].SelfLink })
+		return ds, nil
+	}
+	c.ResizeDiskFn = func(project, zone, disk string, drr *compute.DisksResizeRequest) error {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		r, err := c.get(fmt.Sprintf("projects/%s/zones/%s/disks/%s", project, zone, disk))
+		if err != nil {
+			return err
+		}
+		r.(*compute.Disk).SizeGb = drr.SizeGb
+		return nil
+	}
+	c.DeleteDiskFn = func(project, zone, name string) error {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		p := fmt.Sprintf("projects/%s/zones/%s/disks/%s", project, zone, name)
+		r, err := c.get(p)
+		if err != nil {
+			return err
+		}
+		if users := r.(*compute.Disk).Users; len(users) > 0 {
+			return inUse(p, resourcePath(users[0]))
+		}
+		return c.remove(p)
+	}
+}
+
+// createDisk creates d, which must have a Name, with its size defaulting to
+// that of its source image. c.mu must be held.
+func (c *Compute) createDisk(project, zone string, d *compute.Disk) error {
+	zonePath := fmt.Sprintf("projects/%s/zones/%s", project, zone)
+	if err := c.ensureScope(project, zonePath); err != nil {
+		return err
+	}
+	if d.SourceImage != "" {
+		img, err := c.resolveImage(project, d.SourceImage)
+		if err != nil {
+			return err
+		}
+		if d.SizeGb == 0 {
+			d.SizeGb = img.DiskSizeGb
+		}
+	}
+	if d.SizeGb == 0 {
+		d.SizeGb = 10
+	}
+	p := fmt.Sprintf("%s/disks/%s", zonePath, d.Name)
+	d.Zone = selfLinkPrefix + zonePath
+	d.SelfLink = selfLinkPrefix + p
+	d.Status = "READY"
+	dc := *d
+	return c.insert(p, &dc)
+}
+
+func (c *Compute) setImageFns() {
+	c.CreateImageFn = func(project string, i *compute.Image) error {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if err := c.ensureScope(project); err != nil {
+			return err
+		}
+		if i.SourceDisk != "" {
+			if _, err := c.get(resourcePath(i.SourceDisk)); err != nil {
+				return err
+			}
+		}
+		if i.SourceImage != "" {
+			if _, err := c.resolveImage(project, i.SourceImage); err != nil {
+				return err
+			}
+		}
+		if i.SourceSnapshot != "" {
+			if _, err := c.get(resourcePath(i.SourceSnapshot)); err != nil {
+				return err
+			}
+		}
+		p := fmt.Sprintf("projects/%s/global/images/%s", project, i.Name)
+		i.SelfLink = selfLinkPrefix + p
+		i.Status = "READY"
+		i.CreationTimestamp = time.Now().Format(time.RFC3339Nano)
+		ic := *i
+		return c.insert(p, &ic)
+	}
+	c.GetImageFn = func(project, name string) (*compute.Image, error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		r, err := c.get(fmt.Sprintf("projects/%s/global/images/%s", project, name))
+		if err != nil {
+			return nil, err
+		}
+		i := *r.(*compute.Image)
+		return &i, nil
+	}
+	c.GetImageFromFamilyFn = func(project, family string) (*compute.Image, error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.imageFromFamily(project, family)
+	}
+	c.ListImagesFn = func(project string, _ ...daisyCompute.ListCallOption) ([]*compute.Image, error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		var is []*compute.Image
+		for _, r := range c.list(fmt.Sprintf("projects/%s/global/images", project)) {
+			i := *r.(*compute.Image)
+			is = append(is, &i)
+		}
+		return is, nil
+	}
+	c.DeprecateImageFn = func(project, name string, ds *compute.DeprecationStatus) error {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		r, err := c.get(fmt.Sprintf("projects/%s/global/images/%s", project, name))
+		if err != nil {
+			return err
+		}
+		r.(*compute.Image).Deprecated = ds
+		return nil
+	}
+	c.DeleteImageFn = func(project, name string) error {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.remove(fmt.Sprintf("projects/%s/global/images/%s", project, name))
+	}
+}
+
+func (c *Compute) setSnapshotFns() {
+	c.CreateSnapshotFn = func(project, zone, disk string, sn *compute.Snapshot, _ bool) error {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if err := c.ensureScope(project); err != nil {
+			return err
+		}
+		dp := fmt.Sprintf("projects/%s/zones/%s/disks/%s", project, zone, disk)
+		if _, err := c.get(dp); err != nil {
+			return err
+		}
+		p := fmt.Sprintf("projects/%s/global/snapshots/%s", project, sn.Name)
+		sn.SourceDisk = selfLinkPrefix + dp
+		sn.SelfLink = selfLinkPrefix + p
+		sn.Status = "READY"
+		snc := *sn
+		return c.insert(p, &snc)
+	}
+	c.GetSnapshotFn = func(project, name string) (*compute.Snapshot, error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		r, err := c.get(fmt.Sprintf("projects/%s/global/snapshots/%s", project, name))
+		if err != nil {
+			return nil, err
+		}
+		sn := *r.(*compute.Snapshot)
+		return &sn, nil
+	}
+	c.DeleteSnapshotFn = func(project, name string) error {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.remove(fmt.Sprintf("projects/%s/global/snapshots/%s", project, name))
+	}
+}
+
+// imageFromFamily returns the newest image in family that isn't deprecated.
+// c.mu must be held.
+func (c *Compute) imageFromFamily(project, family string) (*compute.Image, error) {
+	var newest *compute.Image
+	for _, r := range c.list(fmt.Sprintf("projects/%s/global/images", project)) {
+		i := r.(*compute.Image)
+		if i.Family != family || i.Deprecated != nil {
+			continue
+		}
+		if newest == nil || i.CreationTimestamp > newest.CreationTimestamp {
+			newest = i
+		}
+	}
+	if newest == nil {
+		return nil, notFound(fmt.Sprintf("projects/%s/global/images/family/%s", project, family))
+	}
+	i := *newest
+	return &i, nil
+}
+
+// resolveImage returns the image referenced by ref, which is either a name,
+// a URL or a family URL. c.mu must be held.
+func (c *Compute) resolveImage(project, ref string) (*compute.Image, error) {
+	p := link("projects/"+project+"/global", "images", ref)
+	if strings.HasPrefix(p, "global/") {
+		p = fmt.Sprintf("projects/%s/%s", project, p)
+	}
+	if strings.Contains(p, "/images/family/") {
+		parts := strings.Split(p, "/")
+		return c.imageFromFamily(parts[1], parts[len(parts)-1])
+	}
+	r, err := c.get(p)
+	if err != nil {
+		return nil, err
+	}
+	return r.(*compute.Image), nil
+}
+
+func (c *Compute) setInstanceFns() {
+	c.CreateInstanceFn = func(project, zone string, i *compute.Instance) error {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.createInstance(project, zone, i)
+	}
+	c.CreateInstanceBetaFn = func(project, zone string, i *computeBeta.Instance) error {
+		ga := &compute.Instance{}
+		if err := convert(i, ga); err != nil {
+			return err
+		}
+		c.mu.Lock()
+		err := c.createInstance(project, zone, ga)
+		c.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		return convert(ga, i)
+	}
+	c.GetInstanceFn = func(project, zone, name string) (*compute.Instance, error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		r, err := c.get(fmt.Sprintf("projects/%s/zones/%s/instances/%s", project, zone, name))
+		if err != nil {
+			return nil, err
+		}
+		i := *r.(*compute.Instance)
+		return &i, nil
+	}
+	c.ListInstancesFn = func(project, zone string, _ ...daisyCompute.ListCallOption) ([]*compute.Instance, error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		var is []*compute.Instance
+		for _, r := range c.list(fmt.Sprintf("projects/%s/zones/%s/instances", project, zone)) {
+			i := *r.(*compute.Instance)
+			is = append(is, &i)
+		}
+		return is, nil
+	}
+	c.InstanceStatusFn = func(project, zone, name string) (string, error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		r, err := c.get(fmt.Sprintf("projects/%s/zones/%s/instances/%s", project, zone, name))
+		if err != nil {
+			return "", err
+		}
+		return r.(*compute.Instance).Status, nil
+	}
+	c.InstanceStoppedFn = func(project, zone, name string) (bool, error) {
+		status, err := c.InstanceStatus(project, zone, name)
+		return status == "TERMINATED" || status == "STOPPED", err
+	}
+	c.InstancePreemptedFn = func(project, zone, name string) (bool, error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		r, err := c.get(fmt.Sprintf("projects/%s/zones/%s/instances/%s", project, zone, name))
+		if err != nil {
+			return false, err
+		}
+		return r.(*compute.Instance).Status == "TERMINATED" && c.script(name).Preempted, nil
+	}
+	c.StartInstanceFn = func(project, zone, name string) error {
+		return c.setInstanceStatus(project, zone, name, "RUNNING")
+	}
+	c.StopInstanceFn = func(project, zone, name string) error {
+		return c.setInstanceStatus(project, zone, name, "TERMINATED")
+	}
+	c.SetMachineTypeFn = func(project, zone, name, machineType string) error {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		r, err := c.get(fmt.Sprintf("projects/%s/zones/%s/instances/%s", project, zone, name))
+		if err != nil {
+			return err
+		}
+		r.(*compute.Instance).MachineType = machineType
+		return nil
+	}
+	c.SetInstanceMetadataFn = func(project, zone, name string, md *compute.Metadata) error {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		r, err := c.get(fmt.Sprintf("projects/%s/zones/%s/instances/%s", project, zone, name))
+		if err != nil {
+			return err
+		}
+		r.(*compute.Instance).Metadata = md
+		return nil
+	}
+	c.GetSerialPortOutputFn = func(project, zone, name string, port, start int64) (*compute.SerialPortOutput, error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		p := fmt.Sprintf("projects/%s/zones/%s/instances/%s", project, zone, name)
+		r, err := c.get(p)
+		if err != nil {
+			return nil, err
+		}
+		if status := r.(*compute.Instance).Status; status != "RUNNING" {
+			return nil, &googleapi.Error{Code: http.StatusBadRequest, Message: fmt.Sprintf("The resource '%s' is not ready, it is %s", p, status)}
+		}
+		out := c.script(name).SerialOutput[port]
+		if start > int64(len(out)) {
+			start = int64(len(out))
+		}
+		return &compute.SerialPortOutput{Contents: out[start:], Start: start, Next: int64(len(out))}, nil
+	}
+	c.AttachDiskFn = func(project, zone, instance string, ad *compute.AttachedDisk) error {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		r, err := c.get(fmt.Sprintf("projects/%s/zones/%s/instances/%s", project, zone, instance))
+		if err != nil {
+			return err
+		}
+		i := r.(*compute.Instance)
+		if err := c.attachDisk(project, zone, i, ad, len(i.Disks)); err != nil {
+			return err
+		}
+		i.Disks = append(i.Disks, ad)
+		return nil
+	}
+	c.DetachDiskFn = func(project, zone, instance, deviceName string) error {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		r, err := c.get(fmt.Sprintf("projects/%s/zones/%s/instances/%s", project, zone, instance))
+		if err != nil {
+			return err
+		}
+		i := r.(*compute.Instance)
+		for n, ad := range i.Disks {
+			if ad.DeviceName == deviceName {
+				c.removeUser(resourcePath(ad.Source), i.SelfLink)
+				i.Disks = append(i.Disks[:n], i.Disks[n+1:]...)
+				return nil
+			}
+		}
+		return notFound(fmt.Sprintf("%s/devices/%s", resourcePath(i.SelfLink), deviceName))
+	}
+	c.DeleteInstanceFn = func(project, zone, name string) error {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		p := fmt.Sprintf("projects/%s/zones/%s/instances/%s", project, zone, name)
+		r, err := c.get(p)
+		if err != nil {
+			return err
+		}
+		i := r.(*compute.Instance)
+		for _, ad := range i.Disks {
+			dp := resourcePath(ad.Source)
+			c.removeUser(dp, i.SelfLink)
+			if ad.AutoDelete {
+				c.remove(dp)
+			}
+		}
+		return c.remove(p)
+	}
+}
+
+// createInstance creates i, along with the disks it initializes, and starts
+// its script. c.mu must be held.
+func (c *Compute) createInstance(project, zone string, i *compute.Instance) error {
+	zonePath := fmt.Sprintf("projects/%s/zones/%s", project, zone)
+	if err := c.ensureScope(project, zonePath); err != nil {
+		return err
+	}
+	p := fmt.Sprintf("%s/instances/%s", zonePath, i.Name)
+	if _, ok := c.resources[p]; ok {
+		return alreadyExists(p)
+	}
+	for _, nic := range i.NetworkInterfaces {
+		if nic.Network == "" {
+			continue
+		}
+		if _, err := c.get(link(fmt.Sprintf("projects/%s/global", project), "networks", nic.Network)); err != nil {
+			return err
+		}
+	}
+	i.SelfLink = selfLinkPrefix + p
+	i.Zone = selfLinkPrefix + zonePath
+	for n, ad := range i.Disks {
+		if err := c.attachDisk(project, zone, i, ad, n); err != nil {
+			return err
+		}
+	}
+	i.Status = "RUNNING"
+	ic := *i
+	c.insert(p, &ic)
+
+	if d := c.script(i.Name).StopAfter; d > 0 {
+		c.timers = append(c.timers, time.AfterFunc(d, func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			if r, ok := c.resources[p]; ok && r == &ic {
+				ic.Status = "TERMINATED"
+			}
+		}))
+	}
+	return nil
+}
+
+// attachDisk attaches the disk ad refers to to i, first creating it if ad
+// has InitializeParams. c.mu must be held.
+func (c *Compute) attachDisk(project, zone string, i *compute.Instance, ad *compute.AttachedDisk, n int) error {
+	if ad.InitializeParams != nil {
+		name := ad.InitializeParams.DiskName
+		if name == "" {
+			name = i.Name
+			if n > 0 {
+				name = fmt.Sprintf("%s-%d", i.Name, n)
+			}
+		}
+		d := &compute.Disk{
+			Name:        name,
+			SizeGb:      ad.InitializeParams.DiskSizeGb,
+			SourceImage: ad.InitializeParams.SourceImage,
+			Type:        ad.InitializeParams.DiskType,
+		}
+		if err := c.createDisk(project, zone, d); err != nil {
+			return err
+		}
+		ad.Source = d.SelfLink
+	}
+	r, err := c.get(link(fmt.Sprintf("projects/%s/zones/%s", project, zone), "disks", ad.Source))
+	if err != nil {
+		return err
+	}
+	d := r.(*compute.Disk)
+	if len(d.Users) > 0 && ad.Mode != "READ_ONLY" {
+		return inUse(resourcePath(d.SelfLink), resourcePath(d.Users[0]))
+	}
+	d.Users = append(d.Users, i.SelfLink)
+	ad.Source = d.SelfLink
+	if ad.DeviceName == "" {
+		ad.DeviceName = d.Name
+	}
+	return nil
+}
+
+// script returns the script for the instance called name, preferring an
+// exact match over the longest matching prefix. c.mu must be held.
+func (c *Compute) script(name string) InstanceScript {
+	if s, ok := c.scripts[name]; ok {
+		return s
+	}
+	var match string
+	for k := range c.scripts {
+		if strings.HasPrefix(name, k+"-") && len(k) > len(match) {
+			match = k
+		}
+	}
+	return c.scripts[match]
+}
+
+// removeUser removes user from the users of the disk at p. c.mu must be held.
+func (c *Compute) removeUser(p, user string) {
+	r, ok := c.resources[p].(*compute.Disk)
+	if !ok {
+		return
+	}
+	for n, u := range r.Users {
+		if u == user {
+			r.Users = append(r.Users[:n], r.Users[n+1:]...)
+			return
+		}
+	}
+}
+
+func (c *Compute) setInstanceStatus(project, zone, name, status string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r, err := c.get(fmt.Sprintf("projects/%s/zones/%s/instances/%s", project, zone, name))
+	if err != nil {
+		return err
+	}
+	r.(*compute.Instance).Status = status
+	return nil
+}
+
+func (c *Compute) setNetworkFns() {
+	c.CreateNetworkFn = func(project string, n *compute.Network) error {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if err := c.ensureScope(project); err != nil {
+			return err
+		}
+		p := fmt.Sprintf("projects/%s/global/networks/%s", project, n.Name)
+		n.SelfLink = selfLinkPrefix + p
+		nc := *n
+		return c.insert(p, &nc)
+	}
+	c.GetNetworkFn = func(project, name string) (*compute.Network, error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		r, err := c.get(fmt.Sprintf("projects/%s/global/networks/%s", project, name))
+		if err != nil {
+			return nil, err
+		}
+		n := *r.(*compute.Network)
+		return &n, nil
+	}
+	c.ListNetworksFn = func(project string, _ ...daisyCompute.ListCallOption) ([]*compute.Network, error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		var ns []*compute.Network
+		for _, r := range c.list(fmt.Sprintf("projects/%s/global/networks", project)) {
+			n := *r.(*compute.Network)
+			ns = append(ns, &n)
+		}
+		return ns, nil
+	}
+	c.DeleteNetworkFn = func(project, name string) error {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		p := fmt.Sprintf("projects/%s/global/networks/%s", project, name)
+		// Like GCE, refuse to delete a network that is still in use.
+		for rp, r := range c.resources {
+			var network string
+			switch r := r.(type) {
+			case *compute.Subnetwork:
+				network = r.Network
+			case *compute.Firewall:
+				network = r.Network
+			}
+			if network != "" && resourcePath(network) == p {
+				return inUse(p, rp)
+			}
+		}
+		return c.remove(p)
+	}
+
+	c.CreateSubnetworkFn = func(project, region string, s *compute.Subnetwork) error {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		regionPath := fmt.Sprintf("projects/%s/regions/%s", project, region)
+		if err := c.ensureScope(project, regionPath); err != nil {
+			return err
+		}
+		if _, err := c.get(link(fmt.Sprintf("projects/%s/global", project), "networks", s.Network)); err != nil {
+			return err
+		}
+		p := fmt.Sprintf("%s/subnetworks/%s", regionPath, s.Name)
+		s.SelfLink = selfLinkPrefix + p
+		s.Region = selfLinkPrefix + regionPath
+		sc := *s
+		return c.insert(p, &sc)
+	}
+	c.GetSubnetworkFn = func(project, region, name string) (*compute.Subnetwork, error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		r, err := c.get(fmt.Sprintf("projects/%s/regions/%s/subnetworks/%s", project, region, name))
+		if err != nil {
+			return nil, err
+		}
+		s := *r.(*compute.Subnetwork)
+		return &s, nil
+	}
+	c.ListSubnetworksFn = func(project, region string, _ ...daisyCompute.ListCallOption) ([]*compute.Subnetwork, error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		var ss []*compute.Subnetwork
+		for _, r := range c.list(fmt.Sprintf("projects/%s/regions/%s/subnetworks", project, region)) {
+			s := *r.(*compute.Subnetwork)
+			ss = append(ss, &s)
+		}
+		return ss, nil
+	}
+	c.DeleteSubnetworkFn = func(project, region, name string) error {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.remove(fmt.Sprintf("projects/%s/regions/%s/subnetworks/%s", project, region, name))
+	}
+
+	c.CreateFirewallRuleFn = func(project string, f *compute.Firewall) error {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if err := c.ensureScope(project); err != nil {
+			return err
+		}
+		p := fmt.Sprintf("projects/%s/global/firewalls/%s", project, f.Name)
+		f.SelfLink = selfLinkPrefix + p
+		fc := *f
+		return c.insert(p, &fc)
+	}
+	c.GetFirewallRuleFn = func(project, name string) (*compute.Firewall, error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		r, err := c.get(fmt.Sprintf("projects/%s/global/firewalls/%s", project, name))
+		if err != nil {
+			return nil, err
+		}
+		f := *r.(*compute.Firewall)
+		return &f, nil
+	}
+	c.ListFirewallRulesFn = func(project string, _ ...daisyCompute.ListCallOption) ([]*compute.Firewall, error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		var fs []*compute.Firewall
+		for _, r := range c.list(fmt.Sprintf("projects/%s/global/firewalls", project)) {
+			f := *r.(*compute.Firewall)
+			fs = append(fs, &f)
+		}
+		return fs, nil
+	}
+	c.DeleteFirewallRuleFn = func(project, name string) error {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.remove(fmt.Sprintf("projects/%s/global/firewalls/%s", project, name))
+	}
+}
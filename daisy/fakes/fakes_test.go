@@ -0,0 +1,174 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package fakes_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/GoogleCloudPlatform/compute-image-tools/daisy"
+	"github.com/GoogleCloudPlatform/compute-image-tools/daisy/fakes"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/compute/v1"
+)
+
+const testWorkflow = `{
+  "Name": "build",
+  "Project": "p",
+  "Zone": "us-central1-a",
+  "GCSPath": "gs://bkt/daisy",
+  "Sources": {"startup.sh": "./startup.sh"},
+  "Steps": {
+    "create-disk": {
+      "CreateDisks": [{"Name": "disk", "SourceImage": "projects/debian-cloud/global/images/family/debian-10"}]
+    },
+    "create-instance": {
+      "CreateInstances": [{
+        "Name": "builder",
+        "Disks": [{"Source": "disk"}],
+        "StartupScript": "startup.sh",
+        "SerialShutdownGracePeriod": "1ms"
+      }]
+    },
+    "wait": {
+      "WaitForInstancesSignal": [{
+        "Name": "builder",
+        "Interval": "10ms",
+        "SerialOutput": {"Port": 1, "SuccessMatch": "BuildSuccess"}
+      }]
+    },
+    "wait-stopped": {
+      "WaitForInstancesSignal": [{"Name": "builder", "Interval": "10ms", "Stopped": true}]
+    },
+    "create-image": {
+      "CreateImages": [{"Name": "image", "SourceDisk": "disk", "NoCleanup": true, "RealName": "image"}]
+    }
+  },
+  "Dependencies": {
+    "create-instance": ["create-disk"],
+    "wait": ["create-instance"],
+    "wait-stopped": ["wait"],
+    "create-image": ["wait-stopped"]
+  }
+}`
+
+func TestWorkflowRun(t *testing.T) {
+	td, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+	wfPath := filepath.Join(td, "build.wf.json")
+	if err := ioutil.WriteFile(wfPath, []byte(testWorkflow), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(td, "startup.sh"), []byte("echo BuildSuccess"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := fakes.NewCompute("p", "us-central1-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.AddImage("debian-cloud", &compute.Image{Name: "debian-10-v1", Family: "debian-10", DiskSizeGb: 10})
+	c.ScriptInstance("builder", fakes.InstanceScript{
+		SerialOutput: map[int64]string{1: "starting\nBuildSuccess\n"},
+		StopAfter:    100 * time.Millisecond,
+	})
+	s, err := fakes.NewStorage("bkt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	w, err := daisy.NewFromFile(wfPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.ComputeClient = c
+	w.StorageClient = s.Client
+	w.DisableCloudLogging()
+	w.DisableStdoutLogging()
+	if err := w.Run(context.Background()); err != nil {
+		t.Fatalf("error running workflow: %v", err)
+	}
+
+	var created, deleted []string
+	for _, r := range c.Created() {
+		created = append(created, path.Join(path.Base(path.Dir(r)), path.Base(r)))
+	}
+	for _, r := range c.Deleted() {
+		deleted = append(deleted, path.Join(path.Base(path.Dir(r)), path.Base(r)))
+	}
+	assert.Len(t, created, 3)
+	assert.True(t, strings.HasPrefix(created[0], "disks/disk-build-"), created[0])
+	assert.True(t, strings.HasPrefix(created[1], "instances/builder-build-"), created[1])
+	assert.Equal(t, "images/image", created[2])
+	assert.ElementsMatch(t, []string{created[0], created[1]}, deleted)
+	assert.True(t, c.Exists("projects/p/global/images/image"))
+
+	var sources []string
+	for _, o := range s.Objects() {
+		if strings.HasSuffix(o, "/sources/startup.sh") {
+			sources = append(sources, o)
+		}
+	}
+	if assert.Len(t, sources, 1) {
+		b, _ := s.ReadObject("bkt", strings.TrimPrefix(sources[0], "gs://bkt/"))
+		assert.Equal(t, "echo BuildSuccess", string(b))
+	}
+}
+
+func TestDeleteDiskInUse(t *testing.T) {
+	c, err := fakes.NewCompute("p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	assert.Nil(t, c.CreateDisk("p", "us-central1-a", &compute.Disk{Name: "d"}))
+	assert.Nil(t, c.CreateInstance("p", "us-central1-a", &compute.Instance{Name: "i", Disks: []*compute.AttachedDisk{{Source: "d"}}}))
+	assert.NotNil(t, c.DeleteDisk("p", "us-central1-a", "d"))
+	assert.Nil(t, c.DeleteInstance("p", "us-central1-a", "i"))
+	assert.Nil(t, c.DeleteDisk("p", "us-central1-a", "d"))
+	assert.Equal(t, []string{"projects/p/zones/us-central1-a/instances/i", "projects/p/zones/us-central1-a/disks/d"}, c.Deleted())
+}
+
+func TestInstancePreempted(t *testing.T) {
+	c, err := fakes.NewCompute("p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.ScriptInstance("i", fakes.InstanceScript{StopAfter: 10 * time.Millisecond, Preempted: true})
+
+	assert.Nil(t, c.CreateInstance("p", "us-central1-a", &compute.Instance{Name: "i"}))
+	preempted, err := c.InstancePreempted("p", "us-central1-a", "i")
+	assert.Nil(t, err)
+	assert.False(t, preempted, "preempted before it stopped")
+	assert.Eventually(t, func() bool {
+		preempted, _ := c.InstancePreempted("p", "us-central1-a", "i")
+		return preempted
+	}, time.Second, 5*time.Millisecond)
+	_, err = c.InstancePreempted("p", "us-central1-a", "dne")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,461 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package fakes
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+type object struct {
+	data        []byte
+	contentType string
+	metadata    map[string]string
+	generation  int64
+	updated     time.Time
+}
+
+// Storage serves an in-memory GCS JSON and download API for Client.
+// Buckets, object uploads, downloads, listing, copying and deletion are
+// supported; ACL changes are accepted and ignored.
+type Storage struct {
+	// Client is a storage client for the fake.
+	Client *storage.Client
+
+	server *httptest.Server
+
+	mu         sync.Mutex
+	buckets    map[string]bool
+	objects    map[string]*object
+	uploads    map[string]*upload
+	generation int64
+	deleted    []string
+}
+
+type upload struct {
+	bucket, name string
+	meta         objectResource
+	data         bytes.Buffer
+}
+
+// objectResource is the JSON API representation of an object.
+type objectResource struct {
+	Bucket      string            `json:"bucket"`
+	Name        string            `json:"name"`
+	Size        string            `json:"size,omitempty"`
+	ContentType string            `json:"contentType,omitempty"`
+	Crc32c      string            `json:"crc32c,omitempty"`
+	Generation  string            `json:"generation,omitempty"`
+	Updated     string            `json:"updated,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+// NewStorage starts a Storage with buckets already created. Close shuts it
+// down.
+func NewStorage(buckets ...string) (*Storage, error) {
+	s := &Storage{
+		buckets: map[string]bool{},
+		objects: map[string]*object{},
+		uploads: map[string]*upload{},
+	}
+	for _, b := range buckets {
+		s.buckets[b] = true
+	}
+	// Downloads always use https.
+	s.server = httptest.NewTLSServer(http.HandlerFunc(s.serveHTTP))
+	c, err := storage.NewClient(context.Background(), option.WithEndpoint(s.server.URL), option.WithHTTPClient(s.server.Client()))
+	if err != nil {
+		s.server.Close()
+		return nil, err
+	}
+	s.Client = c
+	return s, nil
+}
+
+// Close shuts down the server.
+func (s *Storage) Close() {
+	s.server.Close()
+}
+
+// WriteObject creates or replaces an object, e.g. to seed a source file.
+func (s *Storage) WriteObject(bucket, name string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.buckets[bucket] = true
+	s.put(bucket, name, data, objectResource{})
+}
+
+// ReadObject returns the contents of an object and whether it exists.
+func (s *Storage) ReadObject(bucket, name string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.objects[bucket+"/"+name]
+	if !ok {
+		return nil, false
+	}
+	return append([]byte(nil), o.data...), true
+}
+
+// Objects returns the gs:// paths of all objects, sorted.
+func (s *Storage) Objects() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ps []string
+	for p := range s.objects {
+		ps = append(ps, "gs://"+p)
+	}
+	sort.Strings(ps)
+	return ps
+}
+
+// Deleted returns the gs:// paths of the objects that were deleted, in the
+// order they were deleted.
+func (s *Storage) Deleted() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.deleted...)
+}
+
+// put stores an object. s.mu must be held.
+func (s *Storage) put(bucket, name string, data []byte, meta objectResource) *object {
+	s.generation++
+	o := &object{
+		data:        data,
+		contentType: meta.ContentType,
+		metadata:    meta.Metadata,
+		generation:  s.generation,
+		updated:     time.Now(),
+	}
+	s.objects[bucket+"/"+name] = o
+	return o
+}
+
+func (o *object) resource(bucket, name string) objectResource {
+	sum := make([]byte, 4)
+	binary.BigEndian.PutUint32(sum, crc32.Checksum(o.data, crc32cTable))
+	return objectResource{
+		Bucket:      bucket,
+		Name:        name,
+		Size:        strconv.Itoa(len(o.data)),
+		ContentType: o.contentType,
+		Crc32c:      base64.StdEncoding.EncodeToString(sum),
+		Generation:  strconv.FormatInt(o.generation, 10),
+		Updated:     o.updated.Format(time.RFC3339Nano),
+		Metadata:    o.metadata,
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, format string, a ...interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	msg, _ := json.Marshal(fmt.Sprintf(format, a...))
+	fmt.Fprintf(w, `{"error":{"code":%d,"message":%s}}`, code, msg)
+}
+
+// pathSegments splits the escaped request path, unescaping each segment so
+// object names containing "/" stay whole.
+func pathSegments(r *http.Request) []string {
+	var segs []string
+	for _, seg := range strings.Split(strings.Trim(r.URL.EscapedPath(), "/"), "/") {
+		if u, err := url.PathUnescape(seg); err == nil {
+			seg = u
+		}
+		segs = append(segs, seg)
+	}
+	return segs
+}
+
+func (s *Storage) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	q := r.URL.Query()
+	segs := pathSegments(r)
+	switch {
+	case q.Get("upload_id") != "":
+		s.serveUploadChunk(w, r, q.Get("upload_id"))
+	case len(segs) == 6 && segs[0] == "upload" && segs[5] == "o" && r.Method == "POST":
+		s.serveUpload(w, r, segs[4])
+	case q.Get("alt") == "" && r.Method == "GET" && len(segs) >= 2:
+		// Downloads use the XML API path, where the object name isn't escaped.
+		s.serveDownload(w, r, segs[0], strings.Join(segs[1:], "/"))
+	case len(segs) == 1 && segs[0] == "b":
+		s.serveBuckets(w, r)
+	case len(segs) == 2 && segs[0] == "b" && r.Method == "GET":
+		if !s.buckets[segs[1]] {
+			writeError(w, http.StatusNotFound, "bucket %q not found", segs[1])
+			return
+		}
+		writeJSON(w, map[string]string{"name": segs[1]})
+	case len(segs) == 3 && segs[0] == "b" && segs[2] == "o" && r.Method == "GET":
+		s.serveList(w, r, segs[1])
+	case len(segs) == 4 && segs[0] == "b" && segs[2] == "o":
+		s.serveObject(w, r, segs[1], segs[3])
+	case len(segs) == 9 && segs[0] == "b" && segs[4] == "rewriteTo" && r.Method == "POST":
+		s.serveRewrite(w, segs[1], segs[3], segs[6], segs[8])
+	case len(segs) >= 5 && segs[0] == "b" && segs[4] == "acl":
+		writeJSON(w, map[string]string{})
+	default:
+		writeError(w, http.StatusBadRequest, "fakes: %s %s is not supported", r.Method, r.URL.Path)
+	}
+}
+
+func (s *Storage) serveBuckets(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		var items []map[string]string
+		var names []string
+		for b := range s.buckets {
+			names = append(names, b)
+		}
+		sort.Strings(names)
+		for _, b := range names {
+			items = append(items, map[string]string{"name": b})
+		}
+		writeJSON(w, map[string]interface{}{"items": items})
+	case "POST":
+		var b struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+			writeError(w, http.StatusBadRequest, "bad bucket: %v", err)
+			return
+		}
+		if s.buckets[b.Name] {
+			writeError(w, http.StatusConflict, "bucket %q already exists", b.Name)
+			return
+		}
+		s.buckets[b.Name] = true
+		writeJSON(w, b)
+	default:
+		writeError(w, http.StatusBadRequest, "fakes: %s %s is not supported", r.Method, r.URL.Path)
+	}
+}
+
+func (s *Storage) serveUpload(w http.ResponseWriter, r *http.Request, bucket string) {
+	if !s.buckets[bucket] {
+		writeError(w, http.StatusNotFound, "bucket %q not found", bucket)
+		return
+	}
+	switch r.URL.Query().Get("uploadType") {
+	case "multipart":
+		_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "bad upload: %v", err)
+			return
+		}
+		mr := multipart.NewReader(r.Body, params["boundary"])
+		var meta objectResource
+		var data []byte
+		for i := 0; i < 2; i++ {
+			p, err := mr.NextPart()
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "bad upload: %v", err)
+				return
+			}
+			b, _ := ioutil.ReadAll(p)
+			if i == 0 {
+				err = json.Unmarshal(b, &meta)
+			} else {
+				data = b
+			}
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "bad upload metadata: %v", err)
+				return
+			}
+		}
+		writeJSON(w, s.put(bucket, meta.Name, data, meta).resource(bucket, meta.Name))
+	case "resumable":
+		var meta objectResource
+		if err := json.NewDecoder(r.Body).Decode(&meta); err != nil {
+			writeError(w, http.StatusBadRequest, "bad upload metadata: %v", err)
+			return
+		}
+		id := strconv.Itoa(len(s.uploads) + 1)
+		s.uploads[id] = &upload{bucket: bucket, name: meta.Name, meta: meta}
+		w.Header().Set("Location", fmt.Sprintf("%s/upload/storage/v1/b/%s/o?uploadType=resumable&upload_id=%s", s.server.URL, bucket, id))
+		writeJSON(w, map[string]string{})
+	default:
+		writeError(w, http.StatusBadRequest, "fakes: upload type %q is not supported", r.URL.Query().Get("uploadType"))
+	}
+}
+
+// serveUploadChunk appends a chunk to a resumable upload, creating the object
+// with the final chunk, whose Content-Range has the total size.
+func (s *Storage) serveUploadChunk(w http.ResponseWriter, r *http.Request, id string) {
+	u, ok := s.uploads[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "upload %q not found", id)
+		return
+	}
+	b, _ := ioutil.ReadAll(r.Body)
+	u.data.Write(b)
+	if strings.HasSuffix(r.Header.Get("Content-Range"), "/*") {
+		w.Header().Set("X-Http-Status-Code-Override", "308")
+		w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", u.data.Len()-1))
+		return
+	}
+	delete(s.uploads, id)
+	writeJSON(w, s.put(u.bucket, u.name, u.data.Bytes(), u.meta).resource(u.bucket, u.name))
+}
+
+func (s *Storage) serveDownload(w http.ResponseWriter, r *http.Request, bucket, name string) {
+	o, ok := s.objects[bucket+"/"+name]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", o.contentType)
+	w.Header().Set("X-Goog-Generation", strconv.FormatInt(o.generation, 10))
+	w.Header().Set("X-Goog-Metageneration", "1")
+	rng := strings.TrimPrefix(r.Header.Get("Range"), "bytes=")
+	if rng == "" {
+		w.Write(o.data)
+		return
+	}
+	size := int64(len(o.data))
+	start, end := int64(0), size-1
+	parts := strings.SplitN(rng, "-", 2)
+	if parts[0] == "" {
+		// A suffix range, the last n bytes.
+		n, _ := strconv.ParseInt(parts[1], 10, 64)
+		if start = size - n; start < 0 {
+			start = 0
+		}
+	} else {
+		start, _ = strconv.ParseInt(parts[0], 10, 64)
+		if len(parts) == 2 && parts[1] != "" {
+			if e, _ := strconv.ParseInt(parts[1], 10, 64); e < end {
+				end = e
+			}
+		}
+	}
+	if start > end {
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+	w.WriteHeader(http.StatusPartialContent)
+	w.Write(o.data[start : end+1])
+}
+
+func (s *Storage) serveList(w http.ResponseWriter, r *http.Request, bucket string) {
+	if !s.buckets[bucket] {
+		writeError(w, http.StatusNotFound, "bucket %q not found", bucket)
+		return
+	}
+	prefix := r.URL.Query().Get("prefix")
+	delim := r.URL.Query().Get("delimiter")
+	var names []string
+	for p := range s.objects {
+		if name := strings.TrimPrefix(p, bucket+"/"); name != p && strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var items []objectResource
+	var prefixes []string
+	for _, name := range names {
+		if delim != "" {
+			if i := strings.Index(name[len(prefix):], delim); i >= 0 {
+				if p := name[:len(prefix)+i+len(delim)]; len(prefixes) == 0 || prefixes[len(prefixes)-1] != p {
+					prefixes = append(prefixes, p)
+				}
+				continue
+			}
+		}
+		items = append(items, s.objects[bucket+"/"+name].resource(bucket, name))
+	}
+	writeJSON(w, map[string]interface{}{"kind": "storage#objects", "items": items, "prefixes": prefixes})
+}
+
+func (s *Storage) serveObject(w http.ResponseWriter, r *http.Request, bucket, name string) {
+	p := bucket + "/" + name
+	o, ok := s.objects[p]
+	if !ok {
+		writeError(w, http.StatusNotFound, "object gs://%s not found", p)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		writeJSON(w, o.resource(bucket, name))
+	case "PATCH":
+		var meta objectResource
+		if err := json.NewDecoder(r.Body).Decode(&meta); err != nil {
+			writeError(w, http.StatusBadRequest, "bad object metadata: %v", err)
+			return
+		}
+		if meta.ContentType != "" {
+			o.contentType = meta.ContentType
+		}
+		if meta.Metadata != nil {
+			o.metadata = meta.Metadata
+		}
+		writeJSON(w, o.resource(bucket, name))
+	case "DELETE":
+		delete(s.objects, p)
+		s.deleted = append(s.deleted, "gs://"+p)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusBadRequest, "fakes: %s %s is not supported", r.Method, r.URL.Path)
+	}
+}
+
+func (s *Storage) serveRewrite(w http.ResponseWriter, srcBucket, srcName, dstBucket, dstName string) {
+	o, ok := s.objects[srcBucket+"/"+srcName]
+	if !ok {
+		writeError(w, http.StatusNotFound, "object gs://%s/%s not found", srcBucket, srcName)
+		return
+	}
+	if !s.buckets[dstBucket] {
+		writeError(w, http.StatusNotFound, "bucket %q not found", dstBucket)
+		return
+	}
+	c := s.put(dstBucket, dstName, append([]byte(nil), o.data...), objectResource{ContentType: o.contentType, Metadata: o.metadata})
+	size := strconv.Itoa(len(c.data))
+	writeJSON(w, map[string]interface{}{
+		"kind":                "storage#rewriteResponse",
+		"done":                true,
+		"objectSize":          size,
+		"totalBytesRewritten": size,
+		"resource":            c.resource(dstBucket, dstName),
+	})
+}
@@ -40,6 +40,10 @@ func (w *Workflow) forwardingRuleExists(project, region, forwardingRule string)
 type ForwardingRule struct {
 	compute.ForwardingRule
 	Resource
+
+	// labels are set on the forwarding rule when it's created,
+	// compute.ForwardingRule has no Labels field.
+	labels map[string]string
 }
 
 // MarshalJSON is a hacky workaround to compute.ForwardingRule's implementation.
@@ -59,6 +63,7 @@ func (fr *ForwardingRule) populate(ctx context.Context, s *Step) DError {
 
 	fr.Description = strOr(fr.Description, defaultDescription("ForwardingRule", s.w.Name, s.w.username))
 	fr.link = fmt.Sprintf("projects/%s/regions/%s/forwardingRules/%s", fr.Project, fr.Region, fr.Name)
+	fr.labels = withRunIDLabel(fr.labels, s.w.RunID())
 	return errs
 }
 
@@ -19,4 +19,5 @@ require (
 	google.golang.org/api v0.20.0
 	google.golang.org/genproto v0.0.0-20200318110522-7735f76e9fa5
 	google.golang.org/grpc v1.28.0
+	gopkg.in/yaml.v2 v2.2.2
 )
@@ -21,6 +21,7 @@ import (
 	"net/http"
 	"path"
 	"regexp"
+	"strings"
 
 	computeBeta "google.golang.org/api/compute/v0.beta"
 	"google.golang.org/api/compute/v1"
@@ -30,9 +31,70 @@ import (
 )
 
 var (
-	imageURLRgx = regexp.MustCompile(fmt.Sprintf(`^(projects/(?P<project>%[1]s)/)?global/images\/((family/(?P<family>%[2]s))?|(?P<image>%[2]s))$`, projectRgxStr, rfc1035))
+	snapshotURLRgx = regexp.MustCompile(fmt.Sprintf(`^(projects/(?P<project>%[1]s)/)?global/snapshots/(?P<snapshot>%[2]s)$`, projectRgxStr, rfc1035))
+	imageURLRgx    = regexp.MustCompile(fmt.Sprintf(`^(projects/(?P<project>%[1]s)/)?global/images\/((family/(?P<family>%[2]s))?|(?P<image>%[2]s))$`, projectRgxStr, rfc1035))
+	// regionRgx matches GCE region names such as us-central1.
+	regionRgx = regexp.MustCompile(`^[a-z]+-[a-z]+[0-9]+$`)
+	// multiRegionStorageLocations are the multi-region image storage locations.
+	multiRegionStorageLocations = []string{"asia", "eu", "us"}
+	// validImageArchitectures are the values allowed for Image.Architecture.
+	validImageArchitectures = []string{imageArchitectureARM64, imageArchitectureX86}
 )
 
+const (
+	imageArchitectureARM64 = "ARM64"
+	imageArchitectureX86   = "X86_64"
+)
+
+// ImageBase.SourceDiskConsistency values.
+const (
+	sourceDiskRequireStopped = "REQUIRE_STOPPED"
+	sourceDiskGuestFlush     = "GUEST_FLUSH"
+)
+
+var validSourceDiskConsistencies = []string{sourceDiskRequireStopped, sourceDiskGuestFlush}
+
+// publicImageProjects maps the prefix of public image and image family names
+// to the project that holds them.
+var publicImageProjects = []struct{ prefix, project string }{
+	{"centos-", "centos-cloud"},
+	{"cos-", "cos-cloud"},
+	{"debian-", "debian-cloud"},
+	{"fedora-coreos-", "fedora-coreos-cloud"},
+	{"rhel-", "rhel-cloud"},
+	{"rocky-linux-", "rocky-linux-cloud"},
+	{"sles-", "suse-cloud"},
+	{"ubuntu-", "ubuntu-os-cloud"},
+	{"windows-", "windows-cloud"},
+}
+
+// imageURL extends a partial image URL that has no project. The workflow's
+// ImageProject is used if set. Otherwise project is used, unless the image
+// doesn't exist there and its name matches a public image project.
+func (w *Workflow) imageURL(url, project string) string {
+	if strings.HasPrefix(url, "projects/") {
+		return url
+	}
+	if w.ImageProject != "" {
+		return extendPartialURL(url, w.ImageProject)
+	}
+	extended := extendPartialURL(url, project)
+	if w.ComputeClient == nil {
+		return extended
+	}
+	if exists, err := w.resourceExists(extended); err != nil || exists {
+		return extended
+	}
+	result := NamedSubexp(imageURLRgx, extended)
+	name := strOr(result["family"], result["image"])
+	for _, p := range publicImageProjects {
+		if strings.HasPrefix(name, p.prefix) {
+			return extendPartialURL(url, p.project)
+		}
+	}
+	return extended
+}
+
 // imageExists should only be used during validation for existing GCE images
 // and should not be relied or populated for daisy created resources.
 func (w *Workflow) imageExists(project, family, image string) (bool, DError) {
@@ -54,6 +116,9 @@ func (w *Workflow) imageExists(project, family, image string) (bool, DError) {
 			if apiErr, ok := err.(*googleapi.Error); ok && apiErr.Code == http.StatusNotFound {
 				return false, nil
 			}
+			if isForbidden(err) {
+				return false, imageAccessErr(project, "family/"+family, err)
+			}
 			return false, typedErr(apiError, "failed to get image from family", err)
 		}
 		if img.Deprecated != nil {
@@ -70,11 +135,19 @@ func (w *Workflow) imageExists(project, family, image string) (bool, DError) {
 	}
 	w.imageCache.mu.Lock()
 	defer w.imageCache.mu.Unlock()
+	var listErr error
 	err := w.imageCache.loadCache(func(project string, opts ...daisyCompute.ListCallOption) (interface{}, error) {
-		return w.ComputeClient.ListImages(project)
+		is, err := w.ComputeClient.ListImages(project)
+		listErr = err
+		return is, err
 	}, project, image)
 	if err != nil {
-		return false, err
+		if !isForbidden(listErr) {
+			return false, err
+		}
+		// Images can be shared with accounts that can't list the images in
+		// their project, e.g. a base image project, get the image instead.
+		return w.getImageExists(project, image)
 	}
 
 	for _, i := range w.imageCache.exists[project] {
@@ -89,6 +162,57 @@ func (w *Workflow) imageExists(project, family, image string) (bool, DError) {
 	return false, nil
 }
 
+// getImageExists is imageExists for an image in a project whose images
+// can't be listed.
+func (w *Workflow) getImageExists(project, image string) (bool, DError) {
+	img, err := w.ComputeClient.GetImage(project, image)
+	if err != nil {
+		if apiErr, ok := err.(*googleapi.Error); ok && apiErr.Code == http.StatusNotFound {
+			return false, nil
+		}
+		if isForbidden(err) {
+			return false, imageAccessErr(project, image, err)
+		}
+		return false, typedErr(apiError, "failed to get image", err)
+	}
+	if img.Deprecated != nil && (img.Deprecated.State == "OBSOLETE" || img.Deprecated.State == "DELETED") {
+		return true, typedErrf(imageObsoleteDeletedError, "image %q in state %q", image, img.Deprecated.State)
+	}
+	return true, nil
+}
+
+// imageAccessErr is the error for an image, possibly in another project,
+// that the workflow isn't allowed to read.
+func imageAccessErr(project, image string, err error) DError {
+	return typedErrf(apiError, "no access to image %q in project %q, the workflow's account needs the compute.images.get and compute.images.useReadOnly permissions on it, e.g. with roles/compute.imageUser: %v", image, project, err)
+}
+
+// isForbidden reports whether err is a forbidden error from the GCE API.
+func isForbidden(err error) bool {
+	apiErr, ok := err.(*googleapi.Error)
+	return ok && apiErr.Code == http.StatusForbidden
+}
+
+// imageDiskSizeGb returns the minimum size of disks created from the existing
+// image at link, or 0 if the image can't be read.
+func (w *Workflow) imageDiskSizeGb(link string) int64 {
+	m := NamedSubexp(imageURLRgx, link)
+	if m == nil {
+		return 0
+	}
+	var img *compute.Image
+	var err error
+	if m["family"] != "" {
+		img, err = w.ComputeClient.GetImageFromFamily(m["project"], m["family"])
+	} else {
+		img, err = w.ComputeClient.GetImage(m["project"], m["image"])
+	}
+	if err != nil || img == nil {
+		return 0
+	}
+	return img.DiskSizeGb
+}
+
 //ImageInterface represent abstract Image across different API stages (Alpha, Beta, API)
 type ImageInterface interface {
 	getName() string
@@ -99,13 +223,17 @@ type ImageInterface interface {
 	setSourceDisk(sourceDisk string)
 	getSourceImage() string
 	setSourceImage(sourceImage string)
+	getSourceSnapshot() string
+	setSourceSnapshot(sourceSnapshot string)
 	hasRawDisk() bool
 	getRawDiskSource() string
 	setRawDiskSource(rawDiskSource string)
+	getStorageLocations() []string
 	create(cc daisyCompute.Client) error
 	markCreatedInWorkflow()
 	delete(cc daisyCompute.Client) error
 	populateGuestOSFeatures()
+	setRunIDLabel(id string)
 }
 
 //ImageBase is a base struct for GA/Beta images. It holds the shared properties between the two.
@@ -118,6 +246,108 @@ type ImageBase struct {
 
 	//Ignores license validation if 403/forbidden returned
 	IgnoreLicenseValidationIfForbidden bool `json:",omitempty"`
+
+	// SourceInstance is an instance, by name or partial URL, whose boot disk
+	// the image is created from, in place of SourceDisk. The instance is
+	// stopped first if it is running so the image is consistent.
+	SourceInstance string `json:",omitempty"`
+
+	// SourceDiskConsistency is how the image is kept consistent when its
+	// SourceDisk or SourceInstance's boot disk may be in use.
+	// "REQUIRE_STOPPED" fails if an instance the disk is attached to is
+	// running, rather than stopping SourceInstance. "GUEST_FLUSH" creates the
+	// image from a snapshot of the disk taken once the guest agent has
+	// flushed the disk's buffers, leaving a running instance running. Unset,
+	// SourceInstance is stopped and SourceDisk is imaged as is.
+	SourceDiskConsistency string `json:",omitempty"`
+
+	// DeprecatePrevious sets the deprecation status of an earlier image once
+	// the workflow succeeds, with this image as its replacement.
+	DeprecatePrevious *PreviousImageDeprecation `json:",omitempty"`
+}
+
+// PreviousImageDeprecation is the deprecation status to set on an earlier
+// image once the workflow that created the image replacing it succeeds.
+type PreviousImageDeprecation struct {
+	// Image to deprecate, either a workflow image name, a partial URL or the
+	// name of an image in the new image's project.
+	Image string
+	// State to set, ACTIVE, DEPRECATED, OBSOLETE or DELETED. Defaults to
+	// DEPRECATED.
+	State string `json:",omitempty"`
+	link  string
+}
+
+var previousImageDeprecationStates = []string{"ACTIVE", "DEPRECATED", "OBSOLETE", "DELETED"}
+
+func (dp *PreviousImageDeprecation) validate(s *Step, project, pre string) DError {
+	if !strIn(dp.State, previousImageDeprecationStates) {
+		return Errf("%s: DeprecatePrevious.State of %q not in %q", pre, dp.State, previousImageDeprecationStates)
+	}
+	// regUse needs the partial url of a non daisy resource.
+	lookup := dp.Image
+	if _, ok := s.w.images.get(dp.Image); !ok && rfc1035Rgx.MatchString(dp.Image) {
+		lookup = fmt.Sprintf("projects/%s/global/images/%s", project, dp.Image)
+	}
+	r, err := s.w.images.regUse(lookup, s)
+	if err != nil {
+		return Errf("%s: can't deprecate previous image %q: %v", pre, dp.Image, err)
+	}
+	if NamedSubexp(imageURLRgx, r.link)["image"] == "" {
+		return Errf("%s: can't deprecate previous image %q: not an image name", pre, dp.Image)
+	}
+	dp.link = r.link
+	return nil
+}
+
+// deprecate sets the deprecation status of the previous image, pointing to
+// replacement unless the image is made ACTIVE again.
+func (dp *PreviousImageDeprecation) deprecate(s *Step, replacement string) DError {
+	m := NamedSubexp(imageURLRgx, dp.link)
+	status := &compute.DeprecationStatus{State: dp.State}
+	if dp.State != "ACTIVE" {
+		status.Replacement = replacement
+	}
+	s.w.LogStepInfo(s.name, "CreateImages", "Previous image %q --> %q.", m["image"], dp.State)
+	if err := s.w.ComputeClient.DeprecateImage(m["project"], m["image"], status); err != nil {
+		return typedErrf(apiError, "failed to deprecate previous image %q: %v", dp.link, err)
+	}
+	return nil
+}
+
+// imageDeprecation is the DeprecatePrevious of an image created by step s.
+type imageDeprecation struct {
+	s     *Step
+	dp    *PreviousImageDeprecation
+	image *Resource
+}
+
+// addImageDeprecation defers the DeprecatePrevious of image, created by step
+// s, until the workflow succeeds, so that a failed run leaves the previous
+// image as it was.
+func (w *Workflow) addImageDeprecation(s *Step, dp *PreviousImageDeprecation, image *Resource) {
+	root := w.rootWorkflow()
+	root.imageDeprecationsMx.Lock()
+	defer root.imageDeprecationsMx.Unlock()
+	root.imageDeprecations = append(root.imageDeprecations, imageDeprecation{s, dp, image})
+}
+
+// deprecatePreviousImages sets the deprecation status of the previous images
+// of the images the workflow created, skipping those of images that were
+// deleted or that cleanup deletes.
+func (w *Workflow) deprecatePreviousImages() DError {
+	w.imageDeprecationsMx.Lock()
+	deprecations := append([]imageDeprecation(nil), w.imageDeprecations...)
+	w.imageDeprecationsMx.Unlock()
+	var errs DError
+	for _, d := range deprecations {
+		if d.image.deleted || (d.image.creator != nil && !d.s.w.images.keep(d.image)) {
+			w.LogWorkflowInfo("Not deprecating previous image %q, image %q doesn't outlive the workflow.", d.dp.Image, d.image.RealName)
+			continue
+		}
+		errs = addErrs(errs, d.dp.deprecate(d.s, d.image.link))
+	}
+	return errs
 }
 
 // Image is used to create a GCE image using GA API.
@@ -128,6 +358,11 @@ type Image struct {
 
 	// GuestOsFeatures to set for the image.
 	GuestOsFeatures guestOsFeatures `json:"guestOsFeatures,omitempty"`
+
+	// Architecture of the image, ARM64 or X86_64. Instances in the workflow
+	// that boot from the image must use a machine type of the same
+	// architecture.
+	Architecture string `json:",omitempty"`
 }
 
 func (i *Image) getName() string {
@@ -161,6 +396,14 @@ func (i *Image) setSourceImage(sourceImage string) {
 	i.SourceImage = sourceImage
 }
 
+func (i *Image) getSourceSnapshot() string {
+	return i.SourceSnapshot
+}
+
+func (i *Image) setSourceSnapshot(sourceSnapshot string) {
+	i.SourceSnapshot = sourceSnapshot
+}
+
 func (i *Image) hasRawDisk() bool {
 	return i.RawDisk != nil
 }
@@ -173,10 +416,30 @@ func (i *Image) setRawDiskSource(rawDiskSource string) {
 	i.RawDisk.Source = rawDiskSource
 }
 
+func (i *Image) getStorageLocations() []string {
+	return i.StorageLocations
+}
+
 func (i *Image) create(cc daisyCompute.Client) error {
+	if i.Architecture != "" {
+		return cc.CreateImageWithArchitecture(i.Project, &i.Image, i.Architecture)
+	}
 	return cc.CreateImage(i.Project, &i.Image)
 }
 
+// validateArchitecture checks Architecture and records it for validating the
+// machine types of instances that boot from the image.
+func (i *Image) validateArchitecture(s *Step) DError {
+	if i.Architecture == "" {
+		return nil
+	}
+	if !strIn(i.Architecture, validImageArchitectures) {
+		return Errf("cannot create image %q: bad Architecture %q, must be one of %v", i.daisyName, i.Architecture, validImageArchitectures)
+	}
+	s.w.images.setArchitecture(i.daisyName, i.Architecture)
+	return nil
+}
+
 func (i *Image) markCreatedInWorkflow() {
 	i.createdInWorkflow = true
 }
@@ -194,6 +457,10 @@ func (i *Image) populateGuestOSFeatures() {
 	}
 }
 
+func (i *Image) setRunIDLabel(id string) {
+	i.Labels = withRunIDLabel(i.Labels, id)
+}
+
 // ImageBeta is used to create a GCE image using Beta API.
 // Supported sources are a GCE disk or a RAW image listed in Workflow.Sources.
 type ImageBeta struct {
@@ -236,6 +503,14 @@ func (i *ImageBeta) setSourceImage(sourceImage string) {
 	i.SourceImage = sourceImage
 }
 
+func (i *ImageBeta) getSourceSnapshot() string {
+	return i.SourceSnapshot
+}
+
+func (i *ImageBeta) setSourceSnapshot(sourceSnapshot string) {
+	i.SourceSnapshot = sourceSnapshot
+}
+
 func (i *ImageBeta) hasRawDisk() bool {
 	return i.RawDisk != nil
 }
@@ -248,6 +523,10 @@ func (i *ImageBeta) setRawDiskSource(rawDiskSource string) {
 	i.RawDisk.Source = rawDiskSource
 }
 
+func (i *ImageBeta) getStorageLocations() []string {
+	return i.StorageLocations
+}
+
 func (i *ImageBeta) create(cc daisyCompute.Client) error {
 	return cc.CreateImageBeta(i.Project, &i.Image)
 }
@@ -269,6 +548,10 @@ func (i *ImageBeta) populateGuestOSFeatures() {
 	}
 }
 
+func (i *ImageBeta) setRunIDLabel(id string) {
+	i.Labels = withRunIDLabel(i.Labels, id)
+}
+
 // MarshalJSON is a hacky workaround to prevent Image from using compute.Image's implementation.
 func (i *Image) MarshalJSON() ([]byte, error) {
 	return json.Marshal(*i)
@@ -302,7 +585,15 @@ func (ib *ImageBase) populate(ctx context.Context, ii ImageInterface, s *Step) D
 	}
 
 	if imageURLRgx.MatchString(ii.getSourceImage()) {
-		ii.setSourceImage(extendPartialURL(ii.getSourceImage(), ib.Project))
+		ii.setSourceImage(s.w.imageURL(ii.getSourceImage(), ib.Project))
+	}
+
+	if instanceURLRgx.MatchString(ib.SourceInstance) {
+		ib.SourceInstance = extendPartialURL(ib.SourceInstance, ib.Project)
+	}
+
+	if snap := ii.getSourceSnapshot(); snapshotURLRgx.MatchString(snap) {
+		ii.setSourceSnapshot(extendPartialURL(snap, ib.Project))
 	}
 
 	if ii.hasRawDisk() {
@@ -315,7 +606,14 @@ func (ib *ImageBase) populate(ctx context.Context, ii ImageInterface, s *Step) D
 		}
 	}
 	ib.link = fmt.Sprintf("projects/%s/global/images/%s", ib.Project, ii.getName())
+	if dp := ib.DeprecatePrevious; dp != nil {
+		dp.State = strOr(dp.State, "DEPRECATED")
+		if imageURLRgx.MatchString(dp.Image) {
+			dp.Image = extendPartialURL(dp.Image, ib.Project)
+		}
+	}
 	ii.populateGuestOSFeatures()
+	ii.setRunIDLabel(s.w.RunID())
 	return errs
 }
 
@@ -323,8 +621,49 @@ func (ib *ImageBase) validate(ctx context.Context, ii ImageInterface, licenses [
 	pre := fmt.Sprintf("cannot create image %q", ib.daisyName)
 	errs := ib.Resource.validate(ctx, s, pre)
 
-	if !xor(!xor(ii.getSourceDisk() == "", ii.getSourceImage() == ""), !ii.hasRawDisk()) {
-		errs = addErrs(errs, Errf("%s: must provide either SourceImage, SourceDisk or RawDisk, exclusively", pre))
+	var sources int
+	for _, set := range []bool{ii.getSourceDisk() != "", ii.getSourceImage() != "", ii.getSourceSnapshot() != "", ii.hasRawDisk(), ib.SourceInstance != ""} {
+		if set {
+			sources++
+		}
+	}
+	if sources != 1 {
+		errs = addErrs(errs, Errf("%s: must provide either SourceImage, SourceDisk, SourceSnapshot, SourceInstance or RawDisk, exclusively", pre))
+	}
+
+	// Source snapshot checking. A name is a snapshot a SnapshotInstanceDisks
+	// step creates, resolved once it's registered at run time, or otherwise
+	// that of a snapshot in the image's project.
+	if snap := ii.getSourceSnapshot(); snap != "" && !(rfc1035Rgx.MatchString(snap) && s.w.snapshots.createdBefore(snap, s)) {
+		if rfc1035Rgx.MatchString(snap) {
+			snap = fmt.Sprintf("projects/%s/global/snapshots/%s", ib.Project, snap)
+			ii.setSourceSnapshot(snap)
+		}
+		if !snapshotURLRgx.MatchString(snap) {
+			errs = addErrs(errs, Errf("%s: bad SourceSnapshot: %q", pre, snap))
+		} else if _, err := s.w.snapshots.regUse(snap, s); err != nil {
+			errs = addErrs(errs, Errf("%s: failed to get source snapshot %q: %v", pre, snap, err))
+		}
+	}
+
+	// Source disk consistency checking.
+	if c := ib.SourceDiskConsistency; c != "" {
+		if !strIn(c, validSourceDiskConsistencies) {
+			errs = addErrs(errs, Errf("%s: bad SourceDiskConsistency %q, must be one of %q", pre, c, validSourceDiskConsistencies))
+		}
+		if ii.getSourceDisk() == "" && ib.SourceInstance == "" {
+			errs = addErrs(errs, Errf("%s: SourceDiskConsistency can only be set with SourceDisk or SourceInstance", pre))
+		}
+		if c == sourceDiskGuestFlush && regionalDiskURLRgx.MatchString(ii.getSourceDisk()) {
+			errs = addErrs(errs, Errf("%s: SourceDiskConsistency %s needs a zonal SourceDisk, got %q", pre, c, ii.getSourceDisk()))
+		}
+	}
+
+	// Source instance checking.
+	if ib.SourceInstance != "" {
+		if _, err := s.w.instances.regUse(ib.SourceInstance, s); err != nil {
+			errs = addErrs(errs, newErr("failed to get source instance", err))
+		}
 	}
 
 	// Source disk checking.
@@ -332,6 +671,11 @@ func (ib *ImageBase) validate(ctx context.Context, ii ImageInterface, licenses [
 		if _, err := s.w.disks.regUse(ii.getSourceDisk(), s); err != nil {
 			errs = addErrs(errs, newErr("failed to get source disk", err))
 		}
+		for _, iName := range s.w.disks.attachedInstances(ii.getSourceDisk()) {
+			if inst := s.w.instances.createdInstance(iName); inst != nil && len(inst.ClearMetadataOnStop) > 0 && !s.w.instances.stoppedBefore(iName, s) {
+				errs = addErrs(errs, Errf("%s: SourceDisk %q is attached to instance %q, which sets ClearMetadataOnStop, the step must depend on a StopInstances step stopping it", pre, ii.getSourceDisk(), iName))
+			}
+		}
 	}
 
 	// Source image checking.
@@ -340,6 +684,10 @@ func (ib *ImageBase) validate(ctx context.Context, ii ImageInterface, licenses [
 		errs = addErrs(errs, err)
 	}
 
+	if ib.DeprecatePrevious != nil {
+		errs = addErrs(errs, ib.DeprecatePrevious.validate(s, ib.Project, pre))
+	}
+
 	// RawDisk.Source checking.
 	if ii.hasRawDisk() {
 		sBkt, sObj, err := splitGCSPath(ii.getRawDiskSource())
@@ -353,23 +701,139 @@ func (ib *ImageBase) validate(ctx context.Context, ii ImageInterface, licenses [
 		}
 	}
 
-	// License checking.
-	for _, l := range licenses {
-		result := NamedSubexp(licenseURLRegex, l)
-		if exists, err := s.w.licenseExists(result["project"], result["license"]); err != nil {
-			if !(isGoogleAPIForbiddenError(err) && ib.IgnoreLicenseValidationIfForbidden) {
-				errs = addErrs(errs, Errf("%s: bad license lookup: %q, error: %v", pre, l, err))
-			}
-		} else if !exists {
-			errs = addErrs(errs, Errf("%s: license does not exist: %q", pre, l))
+	// StorageLocations checking.
+	for _, l := range ii.getStorageLocations() {
+		if !regionRgx.MatchString(l) && !strIn(l, multiRegionStorageLocations) {
+			errs = addErrs(errs, Errf("%s: unknown storage location %q, must be a region or one of %q", pre, l, multiRegionStorageLocations))
 		}
 	}
 
+	// License checking.
+	errs = addErrs(errs, s.w.validateLicenses(licenses, ib.IgnoreLicenseValidationIfForbidden, pre))
+
 	// Register image creation.
 	errs = addErrs(errs, s.w.images.regCreate(ib.daisyName, &ib.Resource, s, ib.OverWrite))
 	return errs
 }
 
+// useSourceInstanceBootDisk sets the image's SourceDisk to the boot disk of
+// SourceInstance, stopping the instance first if it isn't stopped. The
+// instance's ClearMetadataOnStop keys are removed while it's running.
+func (ib *ImageBase) useSourceInstanceBootDisk(ii ImageInterface, s *Step) DError {
+	w := s.w
+	link := ib.SourceInstance
+	var clearKeys []string
+	if ir, ok := w.instances.get(ib.SourceInstance); ok {
+		link = ir.link
+		if i := createdInstanceBase(ir); i != nil {
+			clearKeys = i.ClearMetadataOnStop
+		}
+	}
+	m := NamedSubexp(instanceURLRgx, link)
+	stopped, err := w.ComputeClient.InstanceStopped(m["project"], m["zone"], m["instance"])
+	if err != nil {
+		return typedErr(apiError, "failed to check whether source instance is stopped", err)
+	}
+	if !stopped && ib.SourceDiskConsistency != sourceDiskRequireStopped && len(clearKeys) > 0 {
+		if err := w.clearMetadataBeforeStop(m["project"], m["zone"], m["instance"], clearKeys); err != nil {
+			return err
+		}
+	}
+	switch {
+	case stopped:
+	case ib.SourceDiskConsistency == sourceDiskRequireStopped:
+		return Errf("source instance %q is running, SourceDiskConsistency %s requires it to be stopped", m["instance"], sourceDiskRequireStopped)
+	case ib.SourceDiskConsistency == sourceDiskGuestFlush:
+		// The image is created from a guest flushed snapshot instead.
+	default:
+		w.LogStepInfo(s.name, "CreateImages", "Stopping instance %q before creating image %q from its boot disk.", m["instance"], ii.getName())
+		if err := w.ComputeClient.StopInstance(m["project"], m["zone"], m["instance"]); err != nil {
+			return typedErr(apiError, "failed to stop source instance", err)
+		}
+	}
+	inst, err := w.ComputeClient.GetInstance(m["project"], m["zone"], m["instance"])
+	if err != nil {
+		return typedErr(apiError, "failed to get source instance", err)
+	}
+	for _, d := range inst.Disks {
+		if !d.Boot {
+			continue
+		}
+		// Validation only sees SourceInstance, not its boot disk.
+		if ib.SourceDiskConsistency == sourceDiskGuestFlush && regionalDiskURLRgx.MatchString(partialURL(d.Source)) {
+			return Errf("SourceDiskConsistency %s needs a zonal source disk, the boot disk of source instance %q is %q", sourceDiskGuestFlush, m["instance"], d.Source)
+		}
+		ii.setSourceDisk(d.Source)
+		return nil
+	}
+	return Errf("source instance %q has no boot disk", m["instance"])
+}
+
+// checkSourceDiskStopped returns an error if an instance the image's
+// SourceDisk is attached to isn't stopped.
+func (ib *ImageBase) checkSourceDiskStopped(ii ImageInterface, s *Step) DError {
+	w := s.w
+	link := partialURL(ii.getSourceDisk())
+	var d *compute.Disk
+	var err error
+	if m := NamedSubexp(regionalDiskURLRgx, link); m != nil {
+		d, err = w.ComputeClient.GetRegionDisk(strOr(m["project"], ib.Project), m["region"], m["disk"])
+	} else {
+		m = NamedSubexp(diskURLRgx, link)
+		d, err = w.ComputeClient.GetDisk(strOr(m["project"], ib.Project), m["zone"], m["disk"])
+	}
+	if err != nil {
+		return typedErr(apiError, "failed to get source disk", err)
+	}
+	for _, u := range d.Users {
+		m := NamedSubexp(instanceURLRgx, partialURL(u))
+		stopped, err := w.ComputeClient.InstanceStopped(m["project"], m["zone"], m["instance"])
+		if err != nil {
+			return typedErr(apiError, "failed to check whether source disk instance is stopped", err)
+		}
+		if !stopped {
+			return Errf("source disk %q is attached to running instance %q, SourceDiskConsistency %s requires it to be stopped", d.Name, m["instance"], sourceDiskRequireStopped)
+		}
+	}
+	return nil
+}
+
+// useGuestFlushedSnapshot replaces the image's SourceDisk with a snapshot of
+// it taken once the guest has flushed the disk's buffers. It returns a
+// function that deletes the snapshot, to call once the image is created. The
+// snapshot is registered as created by s, so the workflow's cleanup deletes
+// it if that fails.
+func (ib *ImageBase) useGuestFlushedSnapshot(ii ImageInterface, s *Step) (func(), DError) {
+	w := s.w
+	m := NamedSubexp(diskURLRgx, partialURL(ii.getSourceDisk()))
+	if m == nil {
+		return nil, Errf("SourceDiskConsistency %s needs a zonal source disk, got %q", sourceDiskGuestFlush, ii.getSourceDisk())
+	}
+	name := ib.daisyName + "-flush"
+	res := &Resource{daisyName: name, RealName: w.genName(name), Project: strOr(m["project"], ib.Project)}
+	res.link = fmt.Sprintf("projects/%s/global/snapshots/%s", res.Project, res.RealName)
+	sn := &compute.Snapshot{
+		Name:        res.RealName,
+		Description: fmt.Sprintf("Guest flushed snapshot for image %q created by Daisy in workflow %q.", ii.getName(), w.Name),
+		Labels:      withRunIDLabel(nil, w.RunID()),
+	}
+	if err := w.snapshots.regCreate(name, res, s); err != nil {
+		return nil, err
+	}
+	w.LogStepInfo(s.name, "CreateImages", "Creating guest flushed snapshot %q of disk %q for image %q.", sn.Name, m["disk"], ii.getName())
+	if err := w.ComputeClient.CreateSnapshot(res.Project, m["zone"], m["disk"], sn, true); err != nil {
+		return nil, typedErr(apiError, "failed to create guest flushed snapshot of source disk", err)
+	}
+	res.createdInWorkflow = true
+	ii.setSourceDisk("")
+	ii.setSourceSnapshot(res.link)
+	return func() {
+		if err := w.snapshots.delete(name); err != nil {
+			w.LogStepInfo(s.name, "CreateImages", "Failed to delete guest flushed snapshot %q, it is deleted during cleanup: %v", sn.Name, err)
+		}
+	}, nil
+}
+
 func isGoogleAPIForbiddenError(err DError) bool {
 	dErrConcrete, isDErrConcrete := err.(*dErrImpl)
 	if isDErrConcrete && len(dErrConcrete.errs) > 0 {
@@ -383,15 +847,32 @@ func isGoogleAPIForbiddenError(err DError) bool {
 
 type imageRegistry struct {
 	baseResourceRegistry
+	architectures map[string]string
 }
 
 func newImageRegistry(w *Workflow) *imageRegistry {
 	ir := &imageRegistry{baseResourceRegistry: baseResourceRegistry{w: w, typeName: "image", urlRgx: imageURLRgx}}
 	ir.baseResourceRegistry.deleteFn = ir.deleteFn
 	ir.init()
+	ir.architectures = map[string]string{}
 	return ir
 }
 
+func (ir *imageRegistry) setArchitecture(name, architecture string) {
+	ir.mx.Lock()
+	defer ir.mx.Unlock()
+	ir.architectures[name] = architecture
+}
+
+// architecture returns the Architecture of the image created in the workflow
+// with the given name, if one was set.
+func (ir *imageRegistry) architecture(name string) (string, bool) {
+	ir.mx.Lock()
+	defer ir.mx.Unlock()
+	a, ok := ir.architectures[name]
+	return a, ok
+}
+
 func (ir *imageRegistry) deleteFn(res *Resource) DError {
 	m := NamedSubexp(imageURLRgx, res.link)
 	err := ir.w.ComputeClient.DeleteImage(m["project"], m["image"])
@@ -16,13 +16,18 @@ package daisy
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"reflect"
 	"strconv"
+	"strings"
 	"testing"
 
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
 	computeBeta "google.golang.org/api/compute/v0.beta"
 	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
 )
 
 func TestUnmarshalJSON(t *testing.T) {
@@ -94,6 +99,24 @@ func TestImagePopulate(t *testing.T) {
 			&Image{Image: compute.Image{SourceImage: "projects/p/global/images/i"}},
 			false,
 		},
+		{
+			"SourceSnapshot name case",
+			&Image{ImageBase: ImageBase{Resource: Resource{Project: "p"}}, Image: compute.Image{SourceSnapshot: "snap"}},
+			&Image{Image: compute.Image{SourceSnapshot: "snap"}},
+			false,
+		},
+		{
+			"SourceSnapshot URL case",
+			&Image{Image: compute.Image{SourceSnapshot: "projects/p2/global/snapshots/snap"}},
+			&Image{Image: compute.Image{SourceSnapshot: "projects/p2/global/snapshots/snap"}},
+			false,
+		},
+		{
+			"extend SourceSnapshot URL case",
+			&Image{ImageBase: ImageBase{Resource: Resource{Project: "p"}}, Image: compute.Image{SourceSnapshot: "global/snapshots/snap"}},
+			&Image{Image: compute.Image{SourceSnapshot: "projects/p/global/snapshots/snap"}},
+			false,
+		},
 		{
 			"RawDisk.Source from Sources case",
 			&Image{Image: compute.Image{RawDisk: &compute.ImageRawDisk{Source: "d"}}},
@@ -127,6 +150,7 @@ func TestImagePopulate(t *testing.T) {
 		if tt.want != nil {
 			tt.want.Name = tt.input.RealName
 			tt.want.Description = tt.input.Description
+			tt.want.Labels = map[string]string{RunIDLabel: w.RunID()}
 		}
 		tt.input.Resource = Resource{} // These fields are tested in resource_test.
 
@@ -223,6 +247,7 @@ func TestImageBetaPopulate(t *testing.T) {
 		if tt.want != nil {
 			tt.want.Name = tt.input.RealName
 			tt.want.Description = tt.input.Description
+			tt.want.Labels = map[string]string{RunIDLabel: w.RunID()}
 		}
 		tt.input.Resource = Resource{} // These fields are tested in resource_test.
 
@@ -247,6 +272,13 @@ func TestImageValidate(t *testing.T) {
 	d3Creator, e4 := w.NewStep("d3Creator")
 	si1Creator, e5 := w.NewStep("si1Creator")
 	e6 := w.AddDependency(d2Deleter, d2Creator)
+	inst1Creator, _ := w.NewStep("inst1Creator")
+	inst1Creator.CreateInstances = &CreateInstances{Instances: []*Instance{{}}}
+	snapshotter, _ := w.NewStep("snapshotter")
+	snapshotter.SnapshotInstanceDisks = &SnapshotInstanceDisks{{Instance: "build"}}
+	if err := w.instances.regCreate("inst1", &Resource{link: fmt.Sprintf("projects/%s/zones/%s/instances/inst1", w.Project, w.Zone)}, false, inst1Creator); err != nil {
+		t.Fatal(err)
+	}
 
 	// Set up some test resources
 	e7 := w.disks.regCreate("d1", &Resource{link: fmt.Sprintf("projects/%s/zones/%s/disks/d1", w.Project, w.Zone)}, d1Creator, false)
@@ -258,6 +290,12 @@ func TestImageValidate(t *testing.T) {
 	if errs := addErrs(nil, e1, e2, e3, e4, e5, e6, e7, e8, e9, e10, e11); errs != nil {
 		t.Fatalf("test set up error: %v", errs)
 	}
+	w.ComputeClient.(*daisyCompute.TestClient).GetSnapshotFn = func(project, name string) (*compute.Snapshot, error) {
+		if project == w.Project && name == "snap" {
+			return &compute.Snapshot{Name: name}, nil
+		}
+		return nil, &googleapi.Error{Code: http.StatusNotFound}
+	}
 
 	tests := []struct {
 		desc      string
@@ -268,22 +306,47 @@ func TestImageValidate(t *testing.T) {
 		{"good licenses case", &Image{Image: compute.Image{Name: "i2", SourceDisk: "d1", Licenses: []string{fmt.Sprintf("projects/%s/global/licenses/%s", w.Project, testLicense)}}}, false},
 		{"good image case", &Image{Image: compute.Image{Name: "i3", SourceImage: "si1"}}, false},
 		{"good raw disk case", &Image{Image: compute.Image{Name: "i4", RawDisk: &compute.ImageRawDisk{Source: "https://storage.cloud.google.com/bucket/object"}}}, false},
+		{"good region storage location case", &Image{Image: compute.Image{Name: "i7", SourceDisk: "d1", StorageLocations: []string{"europe-west1"}}}, false},
+		{"good multi-region storage location case", &Image{Image: compute.Image{Name: "i8", SourceDisk: "d1", StorageLocations: []string{"eu"}}}, false},
 		{"good disk url case ", &Image{Image: compute.Image{Name: "i5", SourceDisk: fmt.Sprintf("projects/%s/zones/%s/disks/%s", testProject, testZone, testDisk)}}, false},
 		{"bad license case", &Image{Image: compute.Image{Name: "i6", SourceDisk: "d1", Licenses: []string{fmt.Sprintf("projects/%s/global/licenses/bad", testProject)}}}, true},
+		{"bad license url case", &Image{Image: compute.Image{Name: "i9", SourceDisk: "d1", Licenses: []string{"global/licenses/" + testLicense}}}, true},
 		{"bad dupe name case", &Image{Image: compute.Image{Name: "i1", SourceDisk: "d1"}}, true},
 		{"bad missing dep on disk creator case", &Image{Image: compute.Image{Name: "i5", SourceDisk: "d3"}}, true},
 		{"bad disk deleted case", &Image{Image: compute.Image{Name: "i6", SourceDisk: "d2"}}, true},
 		{"bad image case", &Image{Image: compute.Image{Name: "i6", SourceImage: "si2"}}, true},
 		{"bad raw disk URL dne case", &Image{Image: compute.Image{Name: "i6", RawDisk: &compute.ImageRawDisk{Source: "https://storage.cloud.google.com/bucket/dne"}}}, true},
 		{"bad raw disk case", &Image{Image: compute.Image{Name: "i6", RawDisk: &compute.ImageRawDisk{Source: "not/a/gcs/url"}}}, true},
+		{"bad storage location case", &Image{Image: compute.Image{Name: "i9", SourceDisk: "d1", StorageLocations: []string{"europe"}}}, true},
+		{"bad zone storage location case", &Image{Image: compute.Image{Name: "i10", SourceDisk: "d1", StorageLocations: []string{"europe-west1-b"}}}, true},
 		{"bad using disk and raw disk case", &Image{Image: compute.Image{Name: "i6", SourceDisk: "d1", RawDisk: &compute.ImageRawDisk{Source: "https://storage.cloud.google.com/bucket/object"}}}, true},
+		{"good source instance case", &Image{ImageBase: ImageBase{SourceInstance: "inst1"}, Image: compute.Image{Name: "i11"}}, false},
+		{"bad source instance dne case", &Image{ImageBase: ImageBase{SourceInstance: "inst2"}, Image: compute.Image{Name: "i12"}}, true},
+		{"bad using disk and source instance case", &Image{ImageBase: ImageBase{SourceInstance: "inst1"}, Image: compute.Image{Name: "i13", SourceDisk: "d1"}}, true},
+		{"bad no source case", &Image{Image: compute.Image{Name: "i14"}}, true},
+		{"good require stopped disk case", &Image{ImageBase: ImageBase{SourceDiskConsistency: "REQUIRE_STOPPED"}, Image: compute.Image{Name: "i22", SourceDisk: "d1"}}, false},
+		{"good guest flush instance case", &Image{ImageBase: ImageBase{SourceInstance: "inst1", SourceDiskConsistency: "GUEST_FLUSH"}, Image: compute.Image{Name: "i23"}}, false},
+		{"bad source disk consistency case", &Image{ImageBase: ImageBase{SourceDiskConsistency: "FLUSH"}, Image: compute.Image{Name: "i24", SourceDisk: "d1"}}, true},
+		{"bad source disk consistency without disk case", &Image{ImageBase: ImageBase{SourceDiskConsistency: "GUEST_FLUSH"}, Image: compute.Image{Name: "i25", SourceImage: "si1"}}, true},
+		{"good source snapshot case", &Image{Image: compute.Image{Name: "i15", SourceSnapshot: fmt.Sprintf("projects/%s/global/snapshots/snap", w.Project)}}, false},
+		{"bad source snapshot dne case", &Image{Image: compute.Image{Name: "i16", SourceSnapshot: fmt.Sprintf("projects/%s/global/snapshots/dne", w.Project)}}, true},
+		{"bad source snapshot URL case", &Image{Image: compute.Image{Name: "i17", SourceSnapshot: "snapshots/snap"}}, true},
+		{"good source snapshot name case", &Image{Image: compute.Image{Name: "i27", SourceSnapshot: "snap"}}, false},
+		{"bad source snapshot name dne case", &Image{Image: compute.Image{Name: "i28", SourceSnapshot: "dne"}}, true},
+		{"good workflow source snapshot case", &Image{Image: compute.Image{Name: "i29", SourceSnapshot: "build-data"}}, false},
+		{"bad using disk and source snapshot case", &Image{Image: compute.Image{Name: "i18", SourceDisk: "d1", SourceSnapshot: fmt.Sprintf("projects/%s/global/snapshots/snap", w.Project)}}, true},
 		{"bad using disk and raw disk and image case", &Image{Image: compute.Image{Name: "i6", SourceDisk: "d1", RawDisk: &compute.ImageRawDisk{Source: "https://storage.cloud.google.com/bucket/object"}}}, true},
+		{"good deprecate previous case", &Image{ImageBase: ImageBase{DeprecatePrevious: &PreviousImageDeprecation{Image: testImage, State: "DEPRECATED"}}, Image: compute.Image{Name: "i19", SourceDisk: "d1"}}, false},
+		{"good deprecate previous workflow image case", &Image{ImageBase: ImageBase{DeprecatePrevious: &PreviousImageDeprecation{Image: "si1", State: "OBSOLETE"}}, Image: compute.Image{Name: "i20", SourceDisk: "d1"}}, false},
+		{"bad deprecate previous state case", &Image{ImageBase: ImageBase{DeprecatePrevious: &PreviousImageDeprecation{Image: testImage, State: "bad"}}, Image: compute.Image{Name: "i21", SourceDisk: "d1"}}, true},
+		{"bad deprecate previous dne case", &Image{ImageBase: ImageBase{DeprecatePrevious: &PreviousImageDeprecation{Image: "dne", State: "DEPRECATED"}}, Image: compute.Image{Name: "i22", SourceDisk: "d1"}}, true},
+		{"bad deprecate previous family case", &Image{ImageBase: ImageBase{DeprecatePrevious: &PreviousImageDeprecation{Image: fmt.Sprintf("projects/%s/global/images/family/fam", testProject), State: "DEPRECATED"}}, Image: compute.Image{Name: "i23", SourceDisk: "d1"}}, true},
 	}
 
 	for testNum, tt := range tests {
 		s, _ := w.NewStep("s" + strconv.Itoa(testNum))
 		s.CreateImages = &CreateImages{Images: []*Image{tt.i}}
-		w.AddDependency(s, d1Creator, d2Deleter, si1Creator)
+		w.AddDependency(s, d1Creator, d2Deleter, si1Creator, inst1Creator, snapshotter)
 
 		// Test sanitation -- clean/set irrelevant fields.
 		tt.i.daisyName = tt.i.Name
@@ -300,3 +363,311 @@ func TestImageValidate(t *testing.T) {
 		}
 	}
 }
+
+func TestImageValidateArchitecture(t *testing.T) {
+	tests := []struct {
+		desc, arch string
+		shouldErr  bool
+	}{
+		{"unset case", "", false},
+		{"arm case", "ARM64", false},
+		{"x86 case", "X86_64", false},
+		{"bad case", "arm", true},
+	}
+	for _, tt := range tests {
+		w := testWorkflow()
+		i := &Image{ImageBase: ImageBase{Resource: Resource{daisyName: "i"}}, Architecture: tt.arch}
+		err := i.validateArchitecture(&Step{w: w})
+		if tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+		got, ok := w.images.architecture("i")
+		if wantOK := tt.arch != "" && !tt.shouldErr; ok != wantOK || (ok && got != tt.arch) {
+			t.Errorf("%s: recorded architecture = %q, %t", tt.desc, got, ok)
+		}
+	}
+}
+
+func TestImageCreateArchitecture(t *testing.T) {
+	var gotArch string
+	var usedCreateImage bool
+	c := &daisyCompute.TestClient{
+		CreateImageFn: func(_ string, _ *compute.Image) error {
+			usedCreateImage = true
+			return nil
+		},
+		CreateImageWithArchitectureFn: func(_ string, _ *compute.Image, arch string) error {
+			gotArch = arch
+			return nil
+		},
+	}
+
+	if err := (&Image{}).create(c); err != nil || !usedCreateImage || gotArch != "" {
+		t.Errorf("image without Architecture: err = %v, used CreateImage = %t, architecture = %q", err, usedCreateImage, gotArch)
+	}
+	usedCreateImage = false
+	if err := (&Image{Architecture: "ARM64"}).create(c); err != nil || usedCreateImage || gotArch != "ARM64" {
+		t.Errorf("image with Architecture: err = %v, used CreateImage = %t, architecture = %q", err, usedCreateImage, gotArch)
+	}
+}
+
+func TestImageValidateClearMetadataOnStop(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	dCreator, _ := w.NewStep("dCreator")
+	iCreator, _ := w.NewStep("iCreator")
+	stopper, _ := w.NewStep("stopper")
+	w.AddDependency(iCreator, dCreator)
+	w.AddDependency(stopper, iCreator)
+	if err := w.disks.regCreate("d", &Resource{link: fmt.Sprintf("projects/%s/zones/%s/disks/d", w.Project, w.Zone)}, dCreator, false); err != nil {
+		t.Fatal(err)
+	}
+	i := &Instance{
+		InstanceBase: InstanceBase{ClearMetadataOnStop: []string{"ssh-keys"}},
+		Instance:     compute.Instance{Name: "inst", Disks: []*compute.AttachedDisk{{Source: "d", Mode: diskModeRW}}},
+	}
+	i.link = fmt.Sprintf("projects/%s/zones/%s/instances/inst", w.Project, w.Zone)
+	iCreator.CreateInstances = &CreateInstances{Instances: []*Instance{i}}
+	if err := w.instances.regCreate("inst", &i.Resource, false, iCreator); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&StopInstances{Instances: []string{"inst"}}).validate(ctx, stopper); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		desc      string
+		dep       *Step
+		shouldErr bool
+	}{
+		{"depends on stop case", stopper, false},
+		{"no stop case", iCreator, true},
+	}
+	for testNum, tt := range tests {
+		s, _ := w.NewStep("s" + strconv.Itoa(testNum))
+		w.AddDependency(s, tt.dep)
+		img := &Image{Image: compute.Image{Name: "i" + strconv.Itoa(testNum), SourceDisk: "d"}}
+		img.daisyName = img.Name
+		img.RealName = img.Name
+		img.link = fmt.Sprintf("projects/%s/global/images/%s", w.Project, img.Name)
+		img.Project = w.Project
+		s.CreateImages = &CreateImages{Images: []*Image{img}}
+		if err := s.CreateImages.validate(ctx, s); (err != nil) != tt.shouldErr {
+			t.Errorf("%s: unexpected error result, want error: %t, got: %v", tt.desc, tt.shouldErr, err)
+		}
+	}
+}
+
+func TestImageUseSourceInstanceBootDisk(t *testing.T) {
+	tests := []struct {
+		desc, status, consistency string
+		disks                     []*compute.AttachedDisk
+		wantStop                  bool
+		wantDisk                  string
+		shouldErr                 bool
+	}{
+		{"running instance case", "RUNNING", "", []*compute.AttachedDisk{{Source: "data"}, {Source: "boot", Boot: true}}, true, "boot", false},
+		{"stopped instance case", "TERMINATED", "", []*compute.AttachedDisk{{Source: "boot", Boot: true}}, false, "boot", false},
+		{"no boot disk case", "TERMINATED", "", []*compute.AttachedDisk{{Source: "data"}}, false, "", true},
+		{"require stopped running instance case", "RUNNING", "REQUIRE_STOPPED", []*compute.AttachedDisk{{Source: "boot", Boot: true}}, false, "", true},
+		{"require stopped stopped instance case", "TERMINATED", "REQUIRE_STOPPED", []*compute.AttachedDisk{{Source: "boot", Boot: true}}, false, "boot", false},
+		{"guest flush running instance case", "RUNNING", "GUEST_FLUSH", []*compute.AttachedDisk{{Source: "boot", Boot: true}}, false, "boot", false},
+		{"guest flush regional boot disk case", "RUNNING", "GUEST_FLUSH", []*compute.AttachedDisk{{Source: "projects/p/regions/r/disks/boot", Boot: true}}, false, "", true},
+	}
+	for _, tt := range tests {
+		w := testWorkflow()
+		w.instances.m = map[string]*Resource{"inst": {link: fmt.Sprintf("projects/%s/zones/%s/instances/inst-real", testProject, testZone)}}
+		var stopped string
+		tc := w.ComputeClient.(*daisyCompute.TestClient)
+		tc.InstanceStatusFn = func(_, _, _ string) (string, error) { return tt.status, nil }
+		tc.StopInstanceFn = func(_, _, name string) error {
+			stopped = name
+			return nil
+		}
+		tc.GetInstanceFn = func(_, _, _ string) (*compute.Instance, error) {
+			return &compute.Instance{Disks: tt.disks}, nil
+		}
+
+		i := &Image{ImageBase: ImageBase{SourceInstance: "inst", SourceDiskConsistency: tt.consistency}, Image: compute.Image{Name: "i"}}
+		err := i.useSourceInstanceBootDisk(i, &Step{name: "s", w: w})
+		if (err != nil) != tt.shouldErr {
+			t.Errorf("%s: unexpected error result, want error: %t, got: %v", tt.desc, tt.shouldErr, err)
+		}
+		if (stopped == "inst-real") != tt.wantStop {
+			t.Errorf("%s: unexpected stop, want stop: %t, stopped: %q", tt.desc, tt.wantStop, stopped)
+		}
+		if i.SourceDisk != tt.wantDisk {
+			t.Errorf("%s: SourceDisk: got %q, want %q", tt.desc, i.SourceDisk, tt.wantDisk)
+		}
+	}
+}
+
+func TestImageCheckSourceDiskStopped(t *testing.T) {
+	tests := []struct {
+		desc      string
+		users     []string
+		status    string
+		shouldErr bool
+	}{
+		{"no users case", nil, "RUNNING", false},
+		{"stopped user case", []string{"https://www.googleapis.com/compute/v1/projects/p/zones/z/instances/inst"}, "TERMINATED", false},
+		{"running user case", []string{"https://www.googleapis.com/compute/v1/projects/p/zones/z/instances/inst"}, "RUNNING", true},
+	}
+	for _, tt := range tests {
+		w := testWorkflow()
+		var gotInstance string
+		tc := w.ComputeClient.(*daisyCompute.TestClient)
+		tc.GetDiskFn = func(_, _, name string) (*compute.Disk, error) {
+			return &compute.Disk{Name: name, Users: tt.users}, nil
+		}
+		tc.InstanceStatusFn = func(project, zone, name string) (string, error) {
+			gotInstance = fmt.Sprintf("projects/%s/zones/%s/instances/%s", project, zone, name)
+			return tt.status, nil
+		}
+
+		i := &Image{ImageBase: ImageBase{SourceDiskConsistency: "REQUIRE_STOPPED"}, Image: compute.Image{Name: "i", SourceDisk: fmt.Sprintf("projects/%s/zones/%s/disks/d", testProject, testZone)}}
+		err := i.checkSourceDiskStopped(i, &Step{name: "s", w: w})
+		if (err != nil) != tt.shouldErr {
+			t.Errorf("%s: unexpected error result, want error: %t, got: %v", tt.desc, tt.shouldErr, err)
+		}
+		if len(tt.users) > 0 && gotInstance != "projects/p/zones/z/instances/inst" {
+			t.Errorf("%s: checked instance %q, want %q", tt.desc, gotInstance, "projects/p/zones/z/instances/inst")
+		}
+	}
+}
+
+func TestImageUseGuestFlushedSnapshot(t *testing.T) {
+	tests := []struct {
+		desc      string
+		deleteErr error
+	}{
+		{"normal case", nil},
+		// The snapshot is left to the workflow's cleanup.
+		{"delete error case", errors.New("error")},
+	}
+	for _, tt := range tests {
+		w := testWorkflow()
+		var gotDisk, deleted string
+		var gotFlush bool
+		tc := w.ComputeClient.(*daisyCompute.TestClient)
+		tc.CreateSnapshotFn = func(project, zone, disk string, _ *compute.Snapshot, guestFlush bool) error {
+			gotDisk, gotFlush = fmt.Sprintf("projects/%s/zones/%s/disks/%s", project, zone, disk), guestFlush
+			return nil
+		}
+		tc.DeleteSnapshotFn = func(_, name string) error {
+			deleted = name
+			return tt.deleteErr
+		}
+
+		disk := fmt.Sprintf("projects/%s/zones/%s/disks/d", testProject, testZone)
+		i := &Image{ImageBase: ImageBase{Resource: Resource{daisyName: "i"}, SourceDiskConsistency: "GUEST_FLUSH"}, Image: compute.Image{Name: "i", SourceDisk: "https://www.googleapis.com/compute/v1/" + disk}}
+		s := &Step{name: "s", w: w}
+		deleteSnapshot, err := i.useGuestFlushedSnapshot(i, s)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.desc, err)
+		}
+		if gotDisk != disk || !gotFlush {
+			t.Errorf("%s: got snapshot of %q with guest flush %t, want %q with guest flush", tt.desc, gotDisk, gotFlush, disk)
+		}
+		snapshot := w.genName("i-flush")
+		if want := fmt.Sprintf("projects/%s/global/snapshots/%s", testProject, snapshot); i.SourceSnapshot != want || i.SourceDisk != "" {
+			t.Errorf("%s: got SourceSnapshot %q and SourceDisk %q, want %q and no SourceDisk", tt.desc, i.SourceSnapshot, i.SourceDisk, want)
+		}
+		res, ok := w.snapshots.get("i-flush")
+		if !ok || res.creator != s || !res.createdInWorkflow {
+			t.Fatalf("%s: snapshot not registered as created by the step, got %+v", tt.desc, res)
+		}
+		deleteSnapshot()
+		if deleted != snapshot {
+			t.Errorf("%s: deleted snapshot %q, want %q", tt.desc, deleted, snapshot)
+		}
+		if res.deleted != (tt.deleteErr == nil) {
+			t.Errorf("%s: snapshot marked deleted: %t, want %t", tt.desc, res.deleted, tt.deleteErr == nil)
+		}
+	}
+}
+
+func TestImageExistsCrossProject(t *testing.T) {
+	forbidden := &googleapi.Error{Code: http.StatusForbidden}
+	tests := []struct {
+		desc, project, family, image string
+		getErr                       error
+		getState                     string
+		want                         bool
+		wantErr                      string
+	}{
+		{"listed image case", testProject, "", "listed", nil, "", true, ""},
+		{"unlistable project image case", "base", "", "base-image", nil, "", true, ""},
+		{"unlistable project image dne case", "base", "", "dne", &googleapi.Error{Code: http.StatusNotFound}, "", false, ""},
+		{"unlistable project image forbidden case", "base", "", "base-image", forbidden, "", false, "no access to image \"base-image\" in project \"base\""},
+		{"unlistable project image obsolete case", "base", "", "base-image", nil, "OBSOLETE", true, "in state \"OBSOLETE\""},
+		{"family forbidden case", "base", "base-family", "", forbidden, "", false, "no access to image \"family/base-family\" in project \"base\""},
+	}
+	for _, tt := range tests {
+		w := testWorkflow()
+		tc := w.ComputeClient.(*daisyCompute.TestClient)
+		tc.ListImagesFn = func(project string, _ ...daisyCompute.ListCallOption) ([]*compute.Image, error) {
+			if project == testProject {
+				return []*compute.Image{{Name: "listed"}}, nil
+			}
+			return nil, forbidden
+		}
+		getImage := func() (*compute.Image, error) {
+			if tt.getErr != nil {
+				return nil, tt.getErr
+			}
+			img := &compute.Image{Name: "base-image"}
+			if tt.getState != "" {
+				img.Deprecated = &compute.DeprecationStatus{State: tt.getState}
+			}
+			return img, nil
+		}
+		tc.GetImageFn = func(_, _ string) (*compute.Image, error) { return getImage() }
+		tc.GetImageFromFamilyFn = func(_, _ string) (*compute.Image, error) { return getImage() }
+
+		got, err := w.imageExists(tt.project, tt.family, tt.image)
+		if got != tt.want {
+			t.Errorf("%s: got exists %t, want %t", tt.desc, got, tt.want)
+		}
+		if tt.wantErr == "" && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		} else if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("%s: got error %v, want one containing %q", tt.desc, err, tt.wantErr)
+		}
+	}
+}
+
+func TestWorkflowImageURL(t *testing.T) {
+	tests := []struct {
+		desc, url, imageProject, want string
+	}{
+		{"full URL case", "projects/p/global/images/family/debian-11", "", "projects/p/global/images/family/debian-11"},
+		{"ImageProject case", "global/images/family/debian-11", "shared", "projects/shared/global/images/family/debian-11"},
+		{"resource project family case", "global/images/family/my-family", "", "projects/test-project/global/images/family/my-family"},
+		{"resource project image case", "global/images/debian-in-project", "", "projects/test-project/global/images/debian-in-project"},
+		{"public family case", "global/images/family/debian-11", "", "projects/debian-cloud/global/images/family/debian-11"},
+		{"public image case", "global/images/ubuntu-2004-focal-v20200101", "", "projects/ubuntu-os-cloud/global/images/ubuntu-2004-focal-v20200101"},
+		{"unknown case", "global/images/family/other", "", "projects/test-project/global/images/family/other"},
+	}
+	for _, tt := range tests {
+		w := testWorkflow()
+		w.ImageProject = tt.imageProject
+		w.ComputeClient.(*daisyCompute.TestClient).GetImageFromFamilyFn = func(project, family string) (*compute.Image, error) {
+			if project == "test-project" && family == "my-family" {
+				return &compute.Image{Name: "i"}, nil
+			}
+			return nil, &googleapi.Error{Code: http.StatusNotFound}
+		}
+		w.ComputeClient.(*daisyCompute.TestClient).ListImagesFn = func(project string, _ ...daisyCompute.ListCallOption) ([]*compute.Image, error) {
+			if project == "test-project" {
+				return []*compute.Image{{Name: "debian-in-project"}}, nil
+			}
+			return nil, nil
+		}
+		if got := w.imageURL(tt.url, "test-project"); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.desc, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/iamcredentials/v1"
+	"google.golang.org/api/option"
+)
+
+// impersonationScope is the scope of impersonated access tokens, enough for
+// all of the APIs the workflow uses.
+const impersonationScope = "https://www.googleapis.com/auth/cloud-platform"
+
+// impersonatedTokenSource generates access tokens for a service account
+// with the IAM Credentials API.
+type impersonatedTokenSource struct {
+	ctx       context.Context
+	svc       *iamcredentials.Service
+	name      string
+	delegates []string
+}
+
+func serviceAccountName(email string) string {
+	return "projects/-/serviceAccounts/" + email
+}
+
+// newImpersonatedTokenSource returns a token source for the service account
+// email, reached through the chain of delegates, using the credentials in
+// opts to call the IAM Credentials API.
+func newImpersonatedTokenSource(ctx context.Context, email string, delegates []string, opts ...option.ClientOption) (oauth2.TokenSource, error) {
+	svc, err := iamcredentials.NewService(ctx, append(opts, option.WithScopes(impersonationScope))...)
+	if err != nil {
+		return nil, err
+	}
+	ts := &impersonatedTokenSource{ctx: ctx, svc: svc, name: serviceAccountName(email)}
+	for _, d := range delegates {
+		ts.delegates = append(ts.delegates, serviceAccountName(d))
+	}
+	return oauth2.ReuseTokenSource(nil, ts), nil
+}
+
+func (ts *impersonatedTokenSource) Token() (*oauth2.Token, error) {
+	req := &iamcredentials.GenerateAccessTokenRequest{Delegates: ts.delegates, Scope: []string{impersonationScope}}
+	resp, err := ts.svc.Projects.ServiceAccounts.GenerateAccessToken(ts.name, req).Context(ts.ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	expiry, err := time.Parse(time.RFC3339, resp.ExpireTime)
+	if err != nil {
+		return nil, err
+	}
+	return &oauth2.Token{AccessToken: resp.AccessToken, TokenType: "Bearer", Expiry: expiry}, nil
+}
+
+// credentialsOptions returns the client options authenticating the
+// workflow's API clients. With ImpersonateServiceAccount set, a token is
+// generated right away so a missing permission fails here rather than in the
+// first API call a step makes.
+func (w *Workflow) credentialsOptions(ctx context.Context) ([]option.ClientOption, DError) {
+	creds := []option.ClientOption{option.WithCredentialsFile(w.OAuthPath)}
+	if w.ImpersonateServiceAccount == "" {
+		return creds, nil
+	}
+	ts, err := newImpersonatedTokenSource(ctx, w.ImpersonateServiceAccount, w.ImpersonateDelegates, append(creds, option.WithUserAgent(w.userAgent()))...)
+	if err != nil {
+		return nil, typedErr(apiError, "failed to create IAM credentials client", err)
+	}
+	if _, err := ts.Token(); err != nil {
+		return nil, typedErrf(apiError, "failed to impersonate service account %q: %v", w.ImpersonateServiceAccount, err)
+	}
+	return []option.ClientOption{option.WithTokenSource(ts)}, nil
+}
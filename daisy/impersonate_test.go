@@ -0,0 +1,89 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"google.golang.org/api/option"
+)
+
+func TestImpersonatedTokenSource(t *testing.T) {
+	var calls int
+	var gotPath string
+	var gotBody map[string][]string
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		gotPath = r.URL.Path
+		if err := json.NewDecoder(r.Body).Decode(&gotBody); err != nil {
+			t.Error(err)
+		}
+		if r.URL.Path != "/v1/projects/-/serviceAccounts/builder@p.iam.gserviceaccount.com:generateAccessToken" {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"error":{"code":403,"message":"permission denied"}}`)
+			return
+		}
+		fmt.Fprintf(w, `{"accessToken":"tok","expireTime":%q}`, time.Now().Add(time.Hour).Format(time.RFC3339))
+	}))
+	defer svr.Close()
+	opts := []option.ClientOption{option.WithEndpoint(svr.URL + "/"), option.WithHTTPClient(http.DefaultClient)}
+
+	ts, err := newImpersonatedTokenSource(context.Background(), "builder@p.iam.gserviceaccount.com", []string{"hop@p.iam.gserviceaccount.com"}, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		tok, err := ts.Token()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if tok.AccessToken != "tok" {
+			t.Errorf("got token %q, want %q", tok.AccessToken, "tok")
+		}
+	}
+	if calls != 1 {
+		t.Errorf("token should be reused until it expires, got %d calls", calls)
+	}
+	want := map[string][]string{"delegates": {"projects/-/serviceAccounts/hop@p.iam.gserviceaccount.com"}, "scope": {impersonationScope}}
+	if diffRes := diff(gotBody, want, 0); diffRes != "" {
+		t.Errorf("request body not as expected: (-got +want)\n%s", diffRes)
+	}
+
+	ts, err = newImpersonatedTokenSource(context.Background(), "other@p.iam.gserviceaccount.com", nil, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ts.Token(); err == nil {
+		t.Errorf("request to %s: should have returned an error", gotPath)
+	}
+}
+
+func TestCredentialsOptions(t *testing.T) {
+	w := testWorkflow()
+	w.OAuthPath = "creds.json"
+	opts, err := w.credentialsOptions(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diffRes := diff(opts, []option.ClientOption{option.WithCredentialsFile("creds.json")}, 0); diffRes != "" {
+		t.Errorf("options not as expected: (-got +want)\n%s", diffRes)
+	}
+}
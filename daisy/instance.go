@@ -19,9 +19,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"math/rand"
+	"net"
 	"net/http"
 	"path"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -32,16 +34,37 @@ import (
 )
 
 const (
-	defaultAccessConfigType = "ONE_TO_ONE_NAT"
-	defaultDiskMode         = diskModeRW
-	defaultDiskType         = "pd-standard"
-	diskModeRO              = "READ_ONLY"
-	diskModeRW              = "READ_WRITE"
+	defaultAccessConfigType          = "ONE_TO_ONE_NAT"
+	defaultDiskMode                  = diskModeRW
+	defaultDiskType                  = "pd-standard"
+	diskModeRO                       = "READ_ONLY"
+	diskModeRW                       = "READ_WRITE"
+	osLoginMetadataKey               = "enable-oslogin"
+	serialPortEnableMetadataKey      = "serial-port-enable"
+	dnsSearchDomainsMetadataKey      = "daisy-dns-search-domains"
+	maxDNSNameLength                 = 253
+	defaultMachineType               = "n1-standard-1"
+	defaultSerialShutdownGracePeriod = "2s"
+	defaultRunningTimeout            = "2m"
 )
 
 var (
 	instanceURLRgx = regexp.MustCompile(fmt.Sprintf(`^(projects/(?P<project>%[1]s)/)?zones/(?P<zone>%[2]s)/instances/(?P<instance>%[2]s)$`, projectRgxStr, rfc1035))
 	validDiskModes = []string{diskModeRO, diskModeRW}
+	// armMachineTypeFamilies are the machine type families with ARM64 CPUs.
+	armMachineTypeFamilies = []string{"c4a", "t2a"}
+	// validGuestOsFeatures are the guest OS features that may be set on an
+	// attached disk.
+	validGuestOsFeatures = []string{"GVNIC", "MULTI_IP_SUBNET", "SECURE_BOOT", "SEV_CAPABLE", "UEFI_COMPATIBLE", "VIRTIO_SCSI_MULTIQUEUE", "WINDOWS"}
+	validDiskInterfaces  = []string{"NVME", "SCSI"}
+	// nvmeOnlyMachineTypeFamilies are the machine type families that can only
+	// attach disks with the NVME interface.
+	nvmeOnlyMachineTypeFamilies = []string{"a3", "c3", "c3d", "c4", "c4a", "h3", "m3", "n4", "t2a", "z3"}
+	// dnsNameRgx matches a lowercase DNS name of one or more labels of up to
+	// 63 characters each.
+	dnsNameRgx = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]{0,61}[a-z0-9])?(\.[a-z0-9]([-a-z0-9]{0,61}[a-z0-9])?)*$`)
+	// serviceAccountRgx matches a service account email.
+	serviceAccountRgx = regexp.MustCompile(`^[a-z0-9][-a-z0-9_.+]*@[-a-z0-9.]+\.[a-z]+$`)
 )
 
 func checkDiskMode(m string) bool {
@@ -50,6 +73,49 @@ func checkDiskMode(m string) bool {
 	return strIn(m, validDiskModes)
 }
 
+// machineTypeArchitecture returns the image architecture a machine type can
+// boot, based on its machine type family.
+func machineTypeArchitecture(mt string) string {
+	family := strings.SplitN(path.Base(mt), "-", 2)[0]
+	if strIn(family, armMachineTypeFamilies) {
+		return imageArchitectureARM64
+	}
+	return imageArchitectureX86
+}
+
+// validateDiskInterface checks an attached disk's Interface is NVME or SCSI
+// and that each of machineTypes, if any, can attach it.
+func validateDiskInterface(pre, iface string, machineTypes []string) DError {
+	if iface == "" {
+		return nil
+	}
+	if !strIn(iface, validDiskInterfaces) {
+		return Errf("%s: bad disk Interface %q, must be one of %q", pre, iface, validDiskInterfaces)
+	}
+	var errs DError
+	for _, mt := range machineTypes {
+		family := strings.SplitN(path.Base(mt), "-", 2)[0]
+		if iface == "SCSI" && strIn(family, nvmeOnlyMachineTypeFamilies) {
+			errs = addErrs(errs, Errf("%s: machine type %q only supports NVME disk Interface", pre, path.Base(mt)))
+		}
+	}
+	return errs
+}
+
+// validateAttachedDiskGuestOsFeatures checks the guest OS features set on an
+// attached disk. Features are only applied to the boot disk.
+func validateAttachedDiskGuestOsFeatures(pre string, features []string, boot bool) (errs DError) {
+	if len(features) > 0 && !boot {
+		errs = addErrs(errs, Errf("%s: GuestOsFeatures can only be set on the boot disk", pre))
+	}
+	for _, f := range features {
+		if !strIn(f, validGuestOsFeatures) {
+			errs = addErrs(errs, Errf("%s: unknown GuestOsFeatures type %q, must be one of %v", pre, f, validGuestOsFeatures))
+		}
+	}
+	return
+}
+
 // instanceExists should only be used during validation for existing GCE instances
 // and should not be relied or populated for daisy created resources.
 func (w *Workflow) instanceExists(project, zone, instance string) (bool, DError) {
@@ -78,15 +144,27 @@ type InstanceInterface interface {
 	populateScopes() DError
 	initializeComputeMetadata()
 	appendComputeMetadata(key string, value *string)
+	removeComputeMetadata(key string)
 	validateNetworks(s *Step) (errs DError)
 	getComputeDisks() []*computeDisk
 	create(cc daisyCompute.Client) error
 	delete(cc daisyCompute.Client, deleteDisk bool) error
 	updateDisksAndNetworksBeforeCreate(w *Workflow)
+	resolveNatIPs(w *Workflow) DError
 	getMetadata() map[string]string
 	setMetadata(md map[string]string)
 	getSourceMachineImage() string
 	setSourceMachineImage(machineImage string)
+	getExternalIPCount() int
+	addDefaultBootDisk(sourceImage string)
+	isPreemptible() bool
+	populateReservationAffinity()
+	getReservationAffinity() *compute.ReservationAffinity
+	getScheduling() *compute.Scheduling
+	getHostname() string
+	getLabels() map[string]string
+	enableDisplay()
+	setRunIDLabel(id string)
 }
 
 // InstanceBase is a base struct for GA/Beta instances.
@@ -97,15 +175,178 @@ type InstanceBase struct {
 	// OAuth2 scopes to give the instance. If left unset
 	// https://www.googleapis.com/auth/devstorage.read_only will be added.
 	Scopes []string `json:",omitempty"`
+	// ServiceAccount is the email of the service account to run the instance
+	// as, with Scopes, instead of the project's default service account.
+	// Mutually exclusive with ServiceAccounts.
+	ServiceAccount string `json:",omitempty"`
 	// StartupScript is the Sources path to a startup script to use in this step.
 	// This will be automatically mapped to the appropriate metadata key.
 	StartupScript string `json:",omitempty"`
+	// StartupScriptURL is a gs:// URL of a startup script that is already in
+	// GCS. It is set as the startup script metadata as is, without uploading
+	// anything. Mutually exclusive with StartupScript.
+	StartupScriptURL string `json:",omitempty"`
+	// InlineStartupScript is the content of a startup script, set as the
+	// startup-script metadata key, or windows-startup-script-<type> for a
+	// Windows script of InlineStartupScriptType type. Mutually exclusive
+	// with StartupScript and StartupScriptURL.
+	InlineStartupScript string `json:",omitempty"`
+	// InlineStartupScriptType is "ps1", "cmd" or "bat" for a Windows
+	// InlineStartupScript, unset otherwise.
+	InlineStartupScriptType string `json:",omitempty"`
 	// RetryWhenExternalIPDenied indicates whether to retry CreateInstances when
 	// it fails due to external IP denied by organization IP.
 	RetryWhenExternalIPDenied bool `json:",omitempty"`
 	// Should an existing instance of the same name be deleted, defaults to false
 	// which will fail validation.
 	OverWrite bool `json:",omitempty"`
+	// EnableOSLogin sets the enable-oslogin metadata key so that access to the
+	// instance is managed through OS Login rather than metadata SSH keys.
+	EnableOSLogin bool `json:",omitempty"`
+	// SerialShutdownGracePeriod is how long to wait after the instance stops
+	// before a final read of its serial port output, so that output flushed
+	// while shutting down is not lost. Defaults to "2s".
+	SerialShutdownGracePeriod string `json:",omitempty"`
+	serialShutdownGracePeriod time.Duration
+	// RunningTimeout is how long to wait for the created instance to reach
+	// RUNNING before streaming its serial port output. Defaults to "2m".
+	RunningTimeout string `json:",omitempty"`
+	runningTimeout time.Duration
+	// StartupTimeout is how long to wait, once the instance is RUNNING, for
+	// output on its first serial port before failing the step. Unset means no
+	// limit.
+	StartupTimeout string `json:",omitempty"`
+	startupTimeout time.Duration
+	// serialStarted receives the result of waiting for StartupTimeout.
+	serialStarted chan DError
+	// serialLogRestarts counts the times serial port logging was restarted
+	// after the instance was stopped and started again.
+	serialLogRestarts int
+	// span traces the instance from its creation until it stops.
+	span *instanceSpan
+	// MachineTypeFallbacks are machine types to retry creating the instance
+	// with, in order, if the zone doesn't have the resources for MachineType.
+	MachineTypeFallbacks []string `json:",omitempty"`
+	// InstanceGroup is the name of an unmanaged instance group in the
+	// instance's zone to add the instance to once it is created. The group is
+	// created if it doesn't exist, in which case it's cleaned up with the
+	// workflow.
+	InstanceGroup string `json:",omitempty"`
+	// SerialPorts are the serial ports, 1 to 4, whose output is streamed to
+	// the daisy logs directory. Defaults to [1]. Ports other than 1 can only be
+	// read with the serial-port-enable metadata key set, which is done
+	// automatically.
+	SerialPorts []int64 `json:",omitempty"`
+	// AdditionalDisks are blank data disks created along with the instance and
+	// deleted with it. They are attached after Disks.
+	AdditionalDisks []*AdditionalDisk `json:",omitempty"`
+	// BootDiskName is the name later steps use to reference the boot disk
+	// created from the first disk's InitializeParams, instead of the
+	// instance's name. The disk is created with a name generated from it,
+	// or with BootDiskName itself if ExactName is set. Mutually exclusive
+	// with InitializeParams.DiskName.
+	BootDiskName string `json:",omitempty"`
+	// BootDiskSizeGb is the size of the boot disk created from the first
+	// disk's InitializeParams, instead of the source image's size. It must be
+	// at least the image's size. Mutually exclusive with
+	// InitializeParams.DiskSizeGb.
+	BootDiskSizeGb int64 `json:",omitempty,string"`
+	// Secrets maps metadata keys to the Sources holding their values. Unlike
+	// Metadata, the values are only read, from where the Sources are, when
+	// the instance is created, and are never logged, kept in the workflow or
+	// uploaded with the other Sources, so those Sources can't also be used
+	// as StartupScript. They are removed from instances that outlive the
+	// workflow during cleanup.
+	Secrets map[string]string `json:",omitempty"`
+	// MetadataFromFile maps metadata keys, e.g. "user-data", to the Sources
+	// holding their values, which are read when the instance is created.
+	// Keys can't also be set in Metadata or Secrets.
+	MetadataFromFile map[string]string `json:",omitempty"`
+	// ClearMetadataOnStop lists metadata keys, e.g. "ssh-keys" or keys
+	// holding tokens, removed from the instance before Daisy stops it, with a
+	// StopInstances step or to create an image from its boot disk, so the
+	// guest environment can remove the credentials they hold while it's
+	// running. Daisy waits 10s for it before stopping the instance, but the
+	// guest doesn't confirm it, so this is best effort. They are also
+	// removed from instances that outlive the workflow during cleanup. An
+	// image created from a disk of the instance must depend on a
+	// StopInstances step stopping it.
+	ClearMetadataOnStop []string `json:",omitempty"`
+	// StructuredMetadata is metadata with values of any type, e.g. objects
+	// read by agents on the instance. Each value is set as its JSON encoding.
+	// Keys can't also be set in Metadata.
+	StructuredMetadata map[string]interface{} `json:",omitempty"`
+	// Container is run on the instance with Container-Optimized OS. The boot
+	// disk defaults to the cos-stable image family if Disks is empty.
+	Container *Container `json:",omitempty"`
+	// SerialLogMetadata is custom metadata set on the instance's serial port
+	// log objects in GCS, on top of the workflow's SerialLogMetadata.
+	SerialLogMetadata map[string]string `json:",omitempty"`
+	// DNSSearchDomains are the DNS search domains for the guest to use, set
+	// space separated as the daisy-dns-search-domains metadata key. Neither
+	// Daisy nor the guest agent applies them, the instance's startup script
+	// has to, e.g. by writing them to resolv.conf.
+	DNSSearchDomains []string `json:",omitempty"`
+	// InstanceTerminationAction is what GCE does to a preemptible instance
+	// when it is preempted, "STOP" or "DELETE". It is sent as
+	// Scheduling.InstanceTerminationAction and can only be set when
+	// Scheduling.Preemptible is.
+	InstanceTerminationAction string `json:",omitempty"`
+	// ScreenshotInterval is how often to save a screenshot of the instance's
+	// display, e.g. a Windows boot or OOBE screen, to the daisy logs
+	// directory while its serial port output is streamed. A display device
+	// is enabled on the instance if this is set. Unset means no screenshots.
+	ScreenshotInterval string `json:",omitempty"`
+	screenshotInterval time.Duration
+	// KeepRunningOnSuccess pauses a WaitForInstancesSignal step once it sees
+	// the instance succeed, so the instance can be inspected, e.g. over SSH,
+	// before later steps and cleanup run. The step continues once
+	// Workflow.Approve is called with its name.
+	KeepRunningOnSuccess bool `json:",omitempty"`
+	// NetworkInterfaceStacks sets the IP stack, e.g. dual-stack IPv4 and
+	// IPv6, of the network interface at the same index in NetworkInterfaces.
+	// The Subnetwork of a dual-stack interface must have IPv6 enabled.
+	NetworkInterfaceStacks []daisyCompute.NetworkInterfaceStack `json:",omitempty"`
+	// ResourceManagerTags are resource manager tags set on the instance and
+	// on the disks created with it, e.g. for IAM conditions. Keys are
+	// "tagKeys/<id>" and values "tagValues/<id>".
+	ResourceManagerTags map[string]string `json:",omitempty"`
+}
+
+var (
+	validInstanceTerminationActions = []string{"DELETE", "STOP"}
+	validStackTypes                 = []string{"IPV4_ONLY", "IPV4_IPV6"}
+	validIpv6AccessTypes            = []string{"INTERNAL", "EXTERNAL"}
+	validWindowsStartupScriptTypes  = []string{"ps1", "cmd", "bat"}
+)
+
+const (
+	anyReservation      = "ANY_RESERVATION"
+	noReservation       = "NO_RESERVATION"
+	specificReservation = "SPECIFIC_RESERVATION"
+	// reservationNameKey is the ReservationAffinity Key selecting a
+	// reservation by name, the default for SPECIFIC_RESERVATION.
+	reservationNameKey = "compute.googleapis.com/reservation-name"
+)
+
+var (
+	validConsumeReservationTypes = []string{anyReservation, noReservation, specificReservation}
+	validOnHostMaintenances      = []string{"MIGRATE", "TERMINATE"}
+	reservationNameRgx           = regexp.MustCompile(fmt.Sprintf(`^(projects/%s/reservations/)?%s$`, projectRgxStr, rfc1035))
+)
+
+// AdditionalDisk is a blank disk that is created and auto-deleted along with
+// an instance, e.g. for scratch space.
+type AdditionalDisk struct {
+	// SizeGb is the size of the disk in GB.
+	SizeGb int64 `json:",omitempty,string"`
+	// Type is the disk type, e.g. "pd-ssd". Defaults to "pd-standard".
+	Type string `json:",omitempty"`
+	// DeviceName defaults to the generated disk name.
+	DeviceName string `json:",omitempty"`
+	// Interface is "NVME" or "SCSI", GCE's default for the machine type is
+	// used if unset.
+	Interface string `json:",omitempty"`
 }
 
 // Instance is used to create a GCE instance using GA API.
@@ -168,7 +409,23 @@ func (i *Instance) appendComputeMetadata(key string, value *string) {
 	i.Instance.Metadata.Items = append(i.Instance.Metadata.Items, &compute.MetadataItems{Key: key, Value: value})
 }
 
+func (i *Instance) removeComputeMetadata(key string) {
+	var items []*compute.MetadataItems
+	for _, item := range i.Instance.Metadata.Items {
+		if item.Key != key {
+			items = append(items, item)
+		}
+	}
+	i.Instance.Metadata.Items = items
+}
+
 func (i *Instance) create(cc daisyCompute.Client) error {
+	if len(i.NetworkInterfaceStacks) > 0 || len(i.ResourceManagerTags) > 0 {
+		return cc.CreateInstanceWithOptions(i.Project, i.Zone, &i.Instance, i.instanceOptions())
+	}
+	if i.InstanceTerminationAction != "" {
+		return cc.CreateInstanceWithTerminationAction(i.Project, i.Zone, &i.Instance, i.InstanceTerminationAction)
+	}
 	return cc.CreateInstance(i.Project, i.Zone, &i.Instance)
 }
 
@@ -198,6 +455,24 @@ func (i *Instance) updateDisksAndNetworksBeforeCreate(w *Workflow) {
 	}
 }
 
+// resolveNatIPs replaces the access config NatIPs that refer to addresses
+// with the addresses' IPs, which are only known once they exist.
+func (i *Instance) resolveNatIPs(w *Workflow) DError {
+	for _, n := range i.NetworkInterfaces {
+		for _, ac := range n.AccessConfigs {
+			if !isAddressReference(ac.NatIP) {
+				continue
+			}
+			ip, err := w.addresses.natIP(ac.NatIP)
+			if err != nil {
+				return err
+			}
+			ac.NatIP = ip
+		}
+	}
+	return nil
+}
+
 func (i *Instance) getMetadata() map[string]string {
 	return i.Metadata
 }
@@ -212,7 +487,64 @@ func (i *Instance) getSourceMachineImage() string {
 
 func (i *Instance) setSourceMachineImage(machineImage string) {}
 
-func (i *Instance) register(name string, s *Step, ir *instanceRegistry, errs DError) {
+func (i *Instance) isPreemptible() bool {
+	return i.Scheduling != nil && i.Scheduling.Preemptible
+}
+
+func (i *Instance) populateReservationAffinity() {
+	if ra := i.ReservationAffinity; ra != nil && ra.ConsumeReservationType == specificReservation && ra.Key == "" {
+		ra.Key = reservationNameKey
+	}
+}
+
+func (i *Instance) getReservationAffinity() *compute.ReservationAffinity {
+	return i.ReservationAffinity
+}
+
+func (i *Instance) getScheduling() *compute.Scheduling {
+	return i.Scheduling
+}
+
+func (i *Instance) getHostname() string {
+	return i.Hostname
+}
+
+func (i *Instance) getLabels() map[string]string {
+	return i.Labels
+}
+
+func (i *Instance) enableDisplay() {
+	i.DisplayDevice = &compute.DisplayDevice{EnableDisplay: true}
+}
+
+// setRunIDLabel labels the instance and the disks created with it.
+func (i *Instance) setRunIDLabel(id string) {
+	i.Labels = withRunIDLabel(i.Labels, id)
+	for _, d := range i.Disks {
+		if p := d.InitializeParams; p != nil && d.Type != "SCRATCH" {
+			p.Labels = withRunIDLabel(p.Labels, id)
+		}
+	}
+}
+
+func (i *Instance) getExternalIPCount() int {
+	var c int
+	for _, n := range i.NetworkInterfaces {
+		c += len(n.AccessConfigs)
+	}
+	return c
+}
+
+// addDefaultBootDisk adds a boot disk created from sourceImage if the
+// instance has no disks.
+func (i *Instance) addDefaultBootDisk(sourceImage string) {
+	if len(i.Disks) == 0 {
+		i.Disks = []*compute.AttachedDisk{{AutoDelete: true, InitializeParams: &compute.AttachedDiskInitializeParams{SourceImage: sourceImage}}}
+	}
+}
+
+func (i *Instance) register(name string, s *Step, ir *instanceRegistry) DError {
+	var errs DError
 	// Register disk attachments.
 	for _, d := range i.Disks {
 		dName := d.Source
@@ -221,7 +553,7 @@ func (i *Instance) register(name string, s *Step, ir *instanceRegistry, errs DEr
 			if parts["disktype"] == "local-ssd" {
 				continue
 			}
-			dName = d.InitializeParams.DiskName
+			dName = i.diskRef(d.Boot, d.InitializeParams.DiskName)
 		}
 		errs = addErrs(errs, ir.w.disks.regAttach(dName, name, d.Mode, s))
 	}
@@ -231,6 +563,7 @@ func (i *Instance) register(name string, s *Step, ir *instanceRegistry, errs DEr
 		nName := n.Network
 		errs = addErrs(errs, ir.w.networks.regConnect(nName, name, s))
 	}
+	return errs
 }
 
 func (i *InstanceBeta) getMachineType() string {
@@ -267,6 +600,16 @@ func (i *InstanceBeta) appendComputeMetadata(key string, value *string) {
 	i.Instance.Metadata.Items = append(i.Instance.Metadata.Items, &computeBeta.MetadataItems{Key: key, Value: value})
 }
 
+func (i *InstanceBeta) removeComputeMetadata(key string) {
+	var items []*computeBeta.MetadataItems
+	for _, item := range i.Instance.Metadata.Items {
+		if item.Key != key {
+			items = append(items, item)
+		}
+	}
+	i.Instance.Metadata.Items = items
+}
+
 func (i *InstanceBeta) initializeComputeMetadata() {
 	if i.Instance.Metadata == nil {
 		i.Instance.Metadata = &computeBeta.Metadata{}
@@ -274,6 +617,12 @@ func (i *InstanceBeta) initializeComputeMetadata() {
 }
 
 func (i *InstanceBeta) create(cc daisyCompute.Client) error {
+	if len(i.NetworkInterfaceStacks) > 0 || len(i.ResourceManagerTags) > 0 {
+		return cc.CreateInstanceBetaWithOptions(i.Project, i.Zone, &i.Instance, i.instanceOptions())
+	}
+	if i.InstanceTerminationAction != "" {
+		return cc.CreateInstanceBetaWithTerminationAction(i.Project, i.Zone, &i.Instance, i.InstanceTerminationAction)
+	}
 	return cc.CreateInstanceBeta(i.Project, i.Zone, &i.Instance)
 }
 
@@ -303,6 +652,24 @@ func (i *InstanceBeta) updateDisksAndNetworksBeforeCreate(w *Workflow) {
 	}
 }
 
+// resolveNatIPs replaces the access config NatIPs that refer to addresses
+// with the addresses' IPs, which are only known once they exist.
+func (i *InstanceBeta) resolveNatIPs(w *Workflow) DError {
+	for _, n := range i.NetworkInterfaces {
+		for _, ac := range n.AccessConfigs {
+			if !isAddressReference(ac.NatIP) {
+				continue
+			}
+			ip, err := w.addresses.natIP(ac.NatIP)
+			if err != nil {
+				return err
+			}
+			ac.NatIP = ip
+		}
+	}
+	return nil
+}
+
 func (i *InstanceBeta) getMetadata() map[string]string {
 	return i.Metadata
 }
@@ -319,7 +686,78 @@ func (i *InstanceBeta) setSourceMachineImage(machineImage string) {
 	i.SourceMachineImage = machineImage
 }
 
-func (i *InstanceBeta) register(name string, s *Step, ir *instanceRegistry, errs DError) {
+func (i *InstanceBeta) isPreemptible() bool {
+	return i.Scheduling != nil && i.Scheduling.Preemptible
+}
+
+func (i *InstanceBeta) enableDisplay() {
+	i.DisplayDevice = &computeBeta.DisplayDevice{EnableDisplay: true}
+}
+
+// setRunIDLabel labels the instance and the disks created with it.
+func (i *InstanceBeta) setRunIDLabel(id string) {
+	i.Labels = withRunIDLabel(i.Labels, id)
+	for _, d := range i.Disks {
+		if p := d.InitializeParams; p != nil && d.Type != "SCRATCH" {
+			p.Labels = withRunIDLabel(p.Labels, id)
+		}
+	}
+}
+
+func (i *InstanceBeta) populateReservationAffinity() {
+	if ra := i.ReservationAffinity; ra != nil && ra.ConsumeReservationType == specificReservation && ra.Key == "" {
+		ra.Key = reservationNameKey
+	}
+}
+
+func (i *InstanceBeta) getReservationAffinity() *compute.ReservationAffinity {
+	if i.ReservationAffinity == nil {
+		return nil
+	}
+	return &compute.ReservationAffinity{
+		ConsumeReservationType: i.ReservationAffinity.ConsumeReservationType,
+		Key:                    i.ReservationAffinity.Key,
+		Values:                 i.ReservationAffinity.Values,
+	}
+}
+
+func (i *InstanceBeta) getScheduling() *compute.Scheduling {
+	if i.Scheduling == nil {
+		return nil
+	}
+	return &compute.Scheduling{
+		AutomaticRestart:  i.Scheduling.AutomaticRestart,
+		OnHostMaintenance: i.Scheduling.OnHostMaintenance,
+		Preemptible:       i.Scheduling.Preemptible,
+	}
+}
+
+func (i *InstanceBeta) getHostname() string {
+	return i.Hostname
+}
+
+func (i *InstanceBeta) getLabels() map[string]string {
+	return i.Labels
+}
+
+func (i *InstanceBeta) getExternalIPCount() int {
+	var c int
+	for _, n := range i.NetworkInterfaces {
+		c += len(n.AccessConfigs)
+	}
+	return c
+}
+
+// addDefaultBootDisk adds a boot disk created from sourceImage if the
+// instance has no disks.
+func (i *InstanceBeta) addDefaultBootDisk(sourceImage string) {
+	if len(i.Disks) == 0 {
+		i.Disks = []*computeBeta.AttachedDisk{{AutoDelete: true, InitializeParams: &computeBeta.AttachedDiskInitializeParams{SourceImage: sourceImage}}}
+	}
+}
+
+func (i *InstanceBeta) register(name string, s *Step, ir *instanceRegistry) DError {
+	var errs DError
 	// Register disk attachments.
 	for _, d := range i.Disks {
 		dName := d.Source
@@ -328,7 +766,7 @@ func (i *InstanceBeta) register(name string, s *Step, ir *instanceRegistry, errs
 			if parts["disktype"] == "local-ssd" {
 				continue
 			}
-			dName = d.InitializeParams.DiskName
+			dName = i.diskRef(d.Boot, d.InitializeParams.DiskName)
 		}
 		errs = addErrs(errs, ir.w.disks.regAttach(dName, name, d.Mode, s))
 	}
@@ -338,6 +776,7 @@ func (i *InstanceBeta) register(name string, s *Step, ir *instanceRegistry, errs
 		nName := n.Network
 		errs = addErrs(errs, ir.w.networks.regConnect(nName, name, s))
 	}
+	return errs
 }
 
 func (ib *InstanceBase) populate(ctx context.Context, ii InstanceInterface, s *Step) DError {
@@ -346,11 +785,23 @@ func (ib *InstanceBase) populate(ctx context.Context, ii InstanceInterface, s *S
 	ii.setZone(zone)
 
 	ii.setDescription(strOr(ii.getDescription(), fmt.Sprintf("Instance created by Daisy in workflow %q on behalf of %s.", s.w.Name, s.w.username)))
+	if ib.Container != nil {
+		ii.addDefaultBootDisk(defaultContainerImage)
+	}
 	errs = addErrs(errs, ii.populateDisks(s.w))
+	ii.setRunIDLabel(s.w.RunID())
 	errs = addErrs(errs, ib.populateMachineType(ii))
+	if len(ib.SerialPorts) == 0 {
+		ib.SerialPorts = []int64{1}
+	}
 	errs = addErrs(errs, ib.populateMetadata(ii, s.w))
 	errs = addErrs(errs, ii.populateNetworks())
 	errs = addErrs(errs, ii.populateScopes())
+	ii.populateReservationAffinity()
+	errs = addErrs(errs, ib.populateSerialShutdownGracePeriod())
+	errs = addErrs(errs, ib.populateRunningTimeout())
+	errs = addErrs(errs, ib.populateStartupTimeout())
+	errs = addErrs(errs, ib.populateScreenshotInterval(ii))
 	ib.link = fmt.Sprintf("projects/%s/zones/%s/instances/%s", ib.Project, ii.getZone(), ii.getName())
 
 	if machineImageURLRgx.MatchString(ii.getSourceMachineImage()) {
@@ -359,15 +810,107 @@ func (ib *InstanceBase) populate(ctx context.Context, ii InstanceInterface, s *S
 	return errs
 }
 
+func (ib *InstanceBase) populateSerialShutdownGracePeriod() DError {
+	ib.SerialShutdownGracePeriod = strOr(ib.SerialShutdownGracePeriod, defaultSerialShutdownGracePeriod)
+	d, err := time.ParseDuration(ib.SerialShutdownGracePeriod)
+	if err != nil {
+		return Errf("bad SerialShutdownGracePeriod %q: %v", ib.SerialShutdownGracePeriod, err)
+	}
+	ib.serialShutdownGracePeriod = d
+	return nil
+}
+
+func (ib *InstanceBase) populateRunningTimeout() DError {
+	ib.RunningTimeout = strOr(ib.RunningTimeout, defaultRunningTimeout)
+	d, err := time.ParseDuration(ib.RunningTimeout)
+	if err != nil {
+		return Errf("bad RunningTimeout %q: %v", ib.RunningTimeout, err)
+	}
+	ib.runningTimeout = d
+	return nil
+}
+
+func (ib *InstanceBase) populateScreenshotInterval(ii InstanceInterface) DError {
+	if ib.ScreenshotInterval == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(ib.ScreenshotInterval)
+	if err != nil {
+		return Errf("bad ScreenshotInterval %q: %v", ib.ScreenshotInterval, err)
+	}
+	if d <= 0 {
+		return Errf("bad ScreenshotInterval %q: must be positive", ib.ScreenshotInterval)
+	}
+	ib.screenshotInterval = d
+	ii.enableDisplay()
+	return nil
+}
+
+func (ib *InstanceBase) populateStartupTimeout() DError {
+	if ib.StartupTimeout == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(ib.StartupTimeout)
+	if err != nil {
+		return Errf("bad StartupTimeout %q: %v", ib.StartupTimeout, err)
+	}
+	ib.startupTimeout = d
+	return nil
+}
+
 func (i *Instance) populateDisks(w *Workflow) DError {
+	for _, ad := range i.AdditionalDisks {
+		i.Disks = append(i.Disks, &compute.AttachedDisk{
+			AutoDelete:       true,
+			DeviceName:       ad.DeviceName,
+			Interface:        ad.Interface,
+			InitializeParams: &compute.AttachedDiskInitializeParams{DiskSizeGb: ad.SizeGb, DiskType: ad.Type},
+		})
+	}
+	var errs DError
+	// The boot disk is the first one, unless another is marked Boot.
+	boot := -1
+	for di, d := range i.Disks {
+		if !d.Boot {
+			continue
+		}
+		if boot >= 0 {
+			errs = addErrs(errs, Errf("cannot create instance: disks %d and %d are both marked Boot, an instance has one boot disk", boot, di))
+			continue
+		}
+		boot = di
+	}
+	if boot > 0 {
+		d := i.Disks[boot]
+		copy(i.Disks[1:boot+1], i.Disks[:boot])
+		i.Disks[0] = d
+	}
 	autonameIdx := 1
 	for di, d := range i.Disks {
 		d.Boot = di == 0
 		d.Mode = strOr(d.Mode, defaultDiskMode)
-		if diskURLRgx.MatchString(d.Source) {
+		if anyDiskURLRgx.MatchString(d.Source) {
 			d.Source = extendPartialURL(d.Source, i.Project)
 		}
 		p := d.InitializeParams
+		if d.Boot && i.BootDiskName != "" {
+			if p == nil {
+				errs = addErrs(errs, Errf("cannot use BootDiskName %q: boot disk has no InitializeParams", i.BootDiskName))
+			} else if p.DiskName != "" {
+				errs = addErrs(errs, Errf("cannot use BootDiskName %q: BootDiskName and InitializeParams.DiskName are mutually exclusive", i.BootDiskName))
+			} else {
+				p.DiskName = i.bootDiskRealName(w)
+			}
+		}
+		if d.Boot && i.BootDiskSizeGb != 0 {
+			if p == nil {
+				errs = addErrs(errs, Errf("cannot use BootDiskSizeGb %d: boot disk has no InitializeParams", i.BootDiskSizeGb))
+			} else if p.DiskSizeGb != 0 {
+				errs = addErrs(errs, Errf("cannot use BootDiskSizeGb %d: BootDiskSizeGb and InitializeParams.DiskSizeGb are mutually exclusive", i.BootDiskSizeGb))
+			} else {
+				p.DiskSizeGb = i.BootDiskSizeGb
+			}
+		}
 		if p != nil {
 			// If name isn't set, set name to "instance-name", "instance-name-2", etc.
 			if p.DiskName == "" {
@@ -383,7 +926,7 @@ func (i *Instance) populateDisks(w *Workflow) DError {
 
 			// Extend SourceImage if short URL.
 			if imageURLRgx.MatchString(p.SourceImage) {
-				p.SourceImage = extendPartialURL(p.SourceImage, i.Project)
+				p.SourceImage = w.imageURL(p.SourceImage, i.Project)
 			}
 
 			// Extend DiskType if short URL, or create extended URL.
@@ -403,18 +946,62 @@ func (i *Instance) populateDisks(w *Workflow) DError {
 			d.DeviceName = path.Base(d.Source)
 		}
 	}
-	return nil
+	return errs
 }
 
 func (i *InstanceBeta) populateDisks(w *Workflow) DError {
+	for _, ad := range i.AdditionalDisks {
+		i.Disks = append(i.Disks, &computeBeta.AttachedDisk{
+			AutoDelete:       true,
+			DeviceName:       ad.DeviceName,
+			Interface:        ad.Interface,
+			InitializeParams: &computeBeta.AttachedDiskInitializeParams{DiskSizeGb: ad.SizeGb, DiskType: ad.Type},
+		})
+	}
+	var errs DError
+	// The boot disk is the first one, unless another is marked Boot.
+	boot := -1
+	for di, d := range i.Disks {
+		if !d.Boot {
+			continue
+		}
+		if boot >= 0 {
+			errs = addErrs(errs, Errf("cannot create instance: disks %d and %d are both marked Boot, an instance has one boot disk", boot, di))
+			continue
+		}
+		boot = di
+	}
+	if boot > 0 {
+		d := i.Disks[boot]
+		copy(i.Disks[1:boot+1], i.Disks[:boot])
+		i.Disks[0] = d
+	}
 	autonameIdx := 1
 	for di, d := range i.Disks {
 		d.Boot = di == 0
 		d.Mode = strOr(d.Mode, defaultDiskMode)
-		if diskURLRgx.MatchString(d.Source) {
+		if anyDiskURLRgx.MatchString(d.Source) {
 			d.Source = extendPartialURL(d.Source, i.Project)
 		}
 		p := d.InitializeParams
+		if d.Boot && i.BootDiskName != "" {
+			if p == nil {
+				errs = addErrs(errs, Errf("cannot use BootDiskName %q: boot disk has no InitializeParams", i.BootDiskName))
+			} else if p.DiskName != "" {
+				errs = addErrs(errs, Errf("cannot use BootDiskName %q: BootDiskName and InitializeParams.DiskName are mutually exclusive", i.BootDiskName))
+			} else {
+				p.DiskName = i.bootDiskRealName(w)
+			}
+		}
+		if d.Boot && i.BootDiskSizeGb != 0 {
+			if p == nil {
+				errs = addErrs(errs, Errf("cannot use BootDiskSizeGb %d: boot disk has no InitializeParams", i.BootDiskSizeGb))
+			} else if p.DiskSizeGb != 0 {
+				errs = addErrs(errs, Errf("cannot use BootDiskSizeGb %d: BootDiskSizeGb and InitializeParams.DiskSizeGb are mutually exclusive", i.BootDiskSizeGb))
+			} else {
+				p.DiskSizeGb = i.BootDiskSizeGb
+			}
+		}
 		if p != nil {
 			// If name isn't set, set name to "instance-name", "instance-name-2", etc.
 			if p.DiskName == "" {
@@ -430,7 +1017,7 @@ func (i *InstanceBeta) populateDisks(w *Workflow) DError {
 
 			// Extend SourceImage if short URL.
 			if imageURLRgx.MatchString(p.SourceImage) {
-				p.SourceImage = extendPartialURL(p.SourceImage, i.Project)
+				p.SourceImage = w.imageURL(p.SourceImage, i.Project)
 			}
 
 			// Extend DiskType if short URL, or create extended URL.
@@ -450,7 +1037,24 @@ func (i *InstanceBeta) populateDisks(w *Workflow) DError {
 			d.DeviceName = path.Base(d.Source)
 		}
 	}
-	return nil
+	return errs
+}
+
+// bootDiskRealName returns the name to create the BootDiskName disk with.
+func (ib *InstanceBase) bootDiskRealName(w *Workflow) string {
+	if ib.ExactName {
+		return ib.BootDiskName
+	}
+	return w.genName(ib.BootDiskName)
+}
+
+// diskRef returns the name the workflow uses to reference a disk created
+// from InitializeParams.
+func (ib *InstanceBase) diskRef(boot bool, diskName string) string {
+	if boot && ib.BootDiskName != "" {
+		return ib.BootDiskName
+	}
+	return diskName
 }
 
 func (ib *InstanceBase) populateMachineType(ii InstanceInterface) DError {
@@ -459,31 +1063,94 @@ func (ib *InstanceBase) populateMachineType(ii InstanceInterface) DError {
 		return nil
 	}
 
-	ii.setMachineType(strOr(ii.getMachineType(), "n1-standard-1"))
-	if machineTypeURLRegex.MatchString(ii.getMachineType()) {
-		ii.setMachineType(extendPartialURL(ii.getMachineType(), ib.Project))
-	} else {
-		ii.setMachineType(fmt.Sprintf("projects/%s/zones/%s/machineTypes/%s", ib.Project, ii.getZone(), ii.getMachineType()))
+	ii.setMachineType(ib.machineTypeURL(ii, strOr(ii.getMachineType(), defaultMachineType)))
+	for i, mt := range ib.MachineTypeFallbacks {
+		ib.MachineTypeFallbacks[i] = ib.machineTypeURL(ii, mt)
 	}
 	return nil
 }
 
+func (ib *InstanceBase) machineTypeURL(ii InstanceInterface, mt string) string {
+	if machineTypeURLRegex.MatchString(mt) {
+		return extendPartialURL(mt, ib.Project)
+	}
+	return fmt.Sprintf("projects/%s/zones/%s/machineTypes/%s", ib.Project, ii.getZone(), mt)
+}
+
 func (ib *InstanceBase) populateMetadata(ii InstanceInterface, w *Workflow) DError {
 	if ii.getMetadata() == nil {
 		ii.setMetadata(map[string]string{})
 	}
 	ii.initializeComputeMetadata()
 
+	for k, v := range ib.StructuredMetadata {
+		if _, ok := ii.getMetadata()[k]; ok {
+			return Errf("StructuredMetadata key %q is also set in metadata", k)
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return Errf("bad value for StructuredMetadata key %q, can't be encoded as JSON: %v", k, err)
+		}
+		ii.getMetadata()[k] = string(b)
+	}
+	if len(ib.DNSSearchDomains) > 0 {
+		if _, ok := ii.getMetadata()[dnsSearchDomainsMetadataKey]; ok {
+			return Errf("DNSSearchDomains is set but metadata %q is also set", dnsSearchDomainsMetadataKey)
+		}
+		ii.getMetadata()[dnsSearchDomainsMetadataKey] = strings.Join(ib.DNSSearchDomains, " ")
+	}
+	if err := w.populateProxyMetadata(ii.getMetadata()); err != nil {
+		return err
+	}
 	ii.getMetadata()["daisy-sources-path"] = "gs://" + path.Join(w.bucket, w.sourcesPath)
 	ii.getMetadata()["daisy-logs-path"] = "gs://" + path.Join(w.bucket, w.logsPath)
 	ii.getMetadata()["daisy-outs-path"] = "gs://" + path.Join(w.bucket, w.outsPath)
+	ii.getMetadata()["daisy-workflow-name"] = w.Name
+	ii.getMetadata()["daisy-workflow-id"] = w.id
 	if ib.StartupScript != "" {
 		if !w.sourceExists(ib.StartupScript) {
 			return Errf("bad value for StartupScript, source not found: %s", ib.StartupScript)
 		}
+		for k, src := range ib.Secrets {
+			if src == ib.StartupScript {
+				return Errf("bad value for StartupScript, source %q holds Secrets key %q and isn't uploaded", src, k)
+			}
+		}
 		ib.StartupScript = "gs://" + path.Join(w.bucket, w.sourcesPath, ib.StartupScript)
 		ii.getMetadata()["startup-script-url"] = ib.StartupScript
 		ii.getMetadata()["windows-startup-script-url"] = ib.StartupScript
+	} else if ib.StartupScriptURL != "" {
+		ii.getMetadata()["startup-script-url"] = ib.StartupScriptURL
+		ii.getMetadata()["windows-startup-script-url"] = ib.StartupScriptURL
+	}
+	if ib.InlineStartupScript != "" {
+		k := "startup-script"
+		if ib.InlineStartupScriptType != "" {
+			k = "windows-startup-script-" + ib.InlineStartupScriptType
+		}
+		if _, ok := ii.getMetadata()[k]; ok {
+			return Errf("InlineStartupScript is set but metadata %q is also set", k)
+		}
+		ii.getMetadata()[k] = ib.InlineStartupScript
+	}
+	if ib.EnableOSLogin {
+		if v, ok := ii.getMetadata()[osLoginMetadataKey]; ok && !strings.EqualFold(v, "true") {
+			return Errf("EnableOSLogin is set but metadata %q is %q", osLoginMetadataKey, v)
+		}
+		ii.getMetadata()[osLoginMetadataKey] = "TRUE"
+	}
+	for _, port := range ib.SerialPorts {
+		if port != 1 {
+			v, ok := ii.getMetadata()[serialPortEnableMetadataKey]
+			if ok && !strings.EqualFold(v, "true") && v != "1" {
+				return Errf("SerialPorts includes port %d but metadata %q is %q", port, serialPortEnableMetadataKey, v)
+			}
+			ii.getMetadata()[serialPortEnableMetadataKey] = strOr(v, "true")
+			break
+		}
+	}
+	if err := ib.populateContainerMetadata(ii); err != nil {
+		return err
 	}
 	for k, v := range ii.getMetadata() {
 		vCopy := v
@@ -515,6 +1182,11 @@ func (i *Instance) populateNetworks() DError {
 		if subnetworkURLRegex.MatchString(n.Subnetwork) {
 			n.Subnetwork = extendPartialURL(n.Subnetwork, i.Project)
 		}
+		for _, ac := range n.AccessConfigs {
+			if addressURLRgx.MatchString(ac.NatIP) {
+				ac.NatIP = extendPartialURL(ac.NatIP, i.Project)
+			}
+		}
 	}
 
 	return nil
@@ -523,6 +1195,10 @@ func (i *Instance) populateNetworks() DError {
 func (i *InstanceBeta) populateNetworks() DError {
 	defaultAcs := []*computeBeta.AccessConfig{{Type: defaultAccessConfigType}}
 
+	// Fields left unset are taken from the machine image.
+	if i.NetworkInterfaces == nil && i.SourceMachineImage != "" {
+		return nil
+	}
 	if i.NetworkInterfaces == nil {
 		i.NetworkInterfaces = []*computeBeta.NetworkInterface{{}}
 	}
@@ -543,27 +1219,94 @@ func (i *InstanceBeta) populateNetworks() DError {
 		if subnetworkURLRegex.MatchString(n.Subnetwork) {
 			n.Subnetwork = extendPartialURL(n.Subnetwork, i.Project)
 		}
+		for _, ac := range n.AccessConfigs {
+			if addressURLRgx.MatchString(ac.NatIP) {
+				ac.NatIP = extendPartialURL(ac.NatIP, i.Project)
+			}
+		}
 	}
 
 	return nil
 }
 
+const scopeURLPrefix = "https://www.googleapis.com/auth/"
+
+// scopeAliases are the gcloud scope aliases that can be used in Scopes in
+// place of full scope URLs.
+var scopeAliases = map[string][]string{
+	"bigquery":              {"bigquery"},
+	"cloud-platform":        {"cloud-platform"},
+	"cloud-source-repos":    {"source.full_control"},
+	"cloud-source-repos-ro": {"source.read_only"},
+	"compute-ro":            {"compute.readonly"},
+	"compute-rw":            {"compute"},
+	"datastore":             {"datastore"},
+	"default":               {"devstorage.read_only", "logging.write", "monitoring.write", "pubsub", "service.management.readonly", "servicecontrol", "trace.append"},
+	"logging-write":         {"logging.write"},
+	"monitoring":            {"monitoring"},
+	"monitoring-read":       {"monitoring.read"},
+	"monitoring-write":      {"monitoring.write"},
+	"pubsub":                {"pubsub"},
+	"service-control":       {"servicecontrol"},
+	"service-management":    {"service.management.readonly"},
+	"sql-admin":             {"sqlservice.admin"},
+	"storage-full":          {"devstorage.full_control"},
+	"storage-ro":            {"devstorage.read_only"},
+	"storage-rw":            {"devstorage.read_write"},
+	"taskqueue":             {"taskqueue"},
+	"trace":                 {"trace.append"},
+	"userinfo-email":        {"userinfo.email"},
+}
+
+// expandScopes replaces scope aliases with their full scope URLs. Unknown
+// aliases are left as is for validation to report.
+func expandScopes(scopes []string) []string {
+	if scopes == nil {
+		return nil
+	}
+	expanded := []string{}
+	for _, scope := range scopes {
+		alias, ok := scopeAliases[scope]
+		if !ok {
+			expanded = append(expanded, scope)
+			continue
+		}
+		for _, a := range alias {
+			if url := scopeURLPrefix + a; !strIn(url, expanded) {
+				expanded = append(expanded, url)
+			}
+		}
+	}
+	return expanded
+}
+
 func (i *Instance) populateScopes() DError {
+	if i.ServiceAccount != "" && i.ServiceAccounts != nil {
+		return Errf("cannot create instance %q: ServiceAccount and ServiceAccounts are mutually exclusive", i.daisyName)
+	}
+	i.Scopes = expandScopes(i.Scopes)
 	if i.Scopes == nil {
 		i.Scopes = append(i.Scopes, "https://www.googleapis.com/auth/devstorage.read_only")
 	}
 	if i.ServiceAccounts == nil {
-		i.ServiceAccounts = []*compute.ServiceAccount{{Email: "default", Scopes: i.Scopes}}
+		i.ServiceAccounts = []*compute.ServiceAccount{{Email: strOr(i.ServiceAccount, "default"), Scopes: i.Scopes}}
 	}
 	return nil
 }
 
 func (i *InstanceBeta) populateScopes() DError {
+	if i.ServiceAccount != "" && i.ServiceAccounts != nil {
+		return Errf("cannot create instance %q: ServiceAccount and ServiceAccounts are mutually exclusive", i.daisyName)
+	}
+	i.Scopes = expandScopes(i.Scopes)
+	if i.Scopes == nil && i.ServiceAccounts == nil && i.ServiceAccount == "" && i.SourceMachineImage != "" {
+		return nil
+	}
 	if i.Scopes == nil {
 		i.Scopes = append(i.Scopes, "https://www.googleapis.com/auth/devstorage.read_only")
 	}
 	if i.ServiceAccounts == nil {
-		i.ServiceAccounts = []*computeBeta.ServiceAccount{{Email: "default", Scopes: i.Scopes}}
+		i.ServiceAccounts = []*computeBeta.ServiceAccount{{Email: strOr(i.ServiceAccount, "default"), Scopes: i.Scopes}}
 	}
 	return nil
 }
@@ -573,44 +1316,315 @@ func (ib *InstanceBase) validate(ctx context.Context, ii InstanceInterface, s *S
 	errs := ib.Resource.validateWithZone(ctx, s, ii.getZone(), pre)
 	errs = addErrs(errs, ib.validateDisks(ii, s))
 	errs = addErrs(errs, ib.validateMachineType(ii, s.w))
+	errs = addErrs(errs, s.w.rootWorkflow().Policy.validateInstance(ib.daisyName, ii.getZone(), append([]string{ii.getMachineType()}, ib.MachineTypeFallbacks...)))
+	errs = addErrs(errs, ib.validateArchitecture(ii, s))
 	errs = addErrs(errs, ii.validateNetworks(s))
 	errs = addErrs(errs, ib.validateSourceMachineImage(ii, s))
+	if ib.StartupScriptURL != "" {
+		if ib.StartupScript != "" {
+			errs = addErrs(errs, Errf("%s: StartupScript and StartupScriptURL are mutually exclusive", pre))
+		}
+		if !gsRegex.MatchString(ib.StartupScriptURL) {
+			errs = addErrs(errs, Errf("%s: bad StartupScriptURL %q, must be a gs://bucket/object URL", pre, ib.StartupScriptURL))
+		}
+	}
+	if ib.InlineStartupScript != "" && (ib.StartupScript != "" || ib.StartupScriptURL != "") {
+		errs = addErrs(errs, Errf("%s: InlineStartupScript is mutually exclusive with StartupScript and StartupScriptURL", pre))
+	}
+	if t := ib.InlineStartupScriptType; t != "" {
+		if ib.InlineStartupScript == "" {
+			errs = addErrs(errs, Errf("%s: InlineStartupScriptType is set without InlineStartupScript", pre))
+		} else if !strIn(t, validWindowsStartupScriptTypes) {
+			errs = addErrs(errs, Errf("%s: bad InlineStartupScriptType %q, must be one of %q", pre, t, validWindowsStartupScriptTypes))
+		}
+	}
+	if ib.InstanceTerminationAction != "" {
+		if !strIn(ib.InstanceTerminationAction, validInstanceTerminationActions) {
+			errs = addErrs(errs, Errf("%s: bad InstanceTerminationAction %q, must be one of %q", pre, ib.InstanceTerminationAction, validInstanceTerminationActions))
+		}
+		if !ii.isPreemptible() {
+			errs = addErrs(errs, Errf("%s: InstanceTerminationAction can only be set for preemptible instances", pre))
+		}
+	}
+	if ra := ii.getReservationAffinity(); ra != nil {
+		errs = addErrs(errs, validateReservationAffinity(pre, ra))
+	}
+	if sc := ii.getScheduling(); sc != nil {
+		errs = addErrs(errs, validateScheduling(pre, sc))
+	}
+	if h := ii.getHostname(); h != "" && (!validDNSName(h) || !strings.Contains(h, ".")) {
+		errs = addErrs(errs, Errf("%s: bad Hostname %q, must be a lowercase fully qualified domain name of at least two labels", pre, h))
+	}
+	for _, d := range ib.DNSSearchDomains {
+		if !validDNSName(d) {
+			errs = addErrs(errs, Errf("%s: bad DNSSearchDomains domain %q", pre, d))
+		}
+	}
+	errs = addErrs(errs, validateResourceManagerTags(pre, ib.ResourceManagerTags))
+	errs = addErrs(errs, validateLabels(pre, ii.getLabels()))
+	if ib.ServiceAccount != "" && !serviceAccountRgx.MatchString(ib.ServiceAccount) {
+		errs = addErrs(errs, Errf("%s: bad ServiceAccount %q, must be a service account email", pre, ib.ServiceAccount))
+	}
+	if ib.InstanceGroup != "" && !checkName(ib.InstanceGroup) {
+		errs = addErrs(errs, Errf("%s: bad InstanceGroup: %q", pre, ib.InstanceGroup))
+	}
+	for k, src := range ib.Secrets {
+		if _, ok := ii.getMetadata()[k]; ok {
+			errs = addErrs(errs, Errf("%s: Secrets key %q is also set in metadata", pre, k))
+		}
+		if !s.w.sourceExists(src) {
+			errs = addErrs(errs, Errf("%s: bad value for Secrets key %q, source not found: %s", pre, k, src))
+		}
+	}
+	for k, src := range ib.MetadataFromFile {
+		if _, ok := ii.getMetadata()[k]; ok {
+			errs = addErrs(errs, Errf("%s: MetadataFromFile key %q is also set in metadata", pre, k))
+		}
+		if _, ok := ib.Secrets[k]; ok {
+			errs = addErrs(errs, Errf("%s: MetadataFromFile key %q is also a Secrets key", pre, k))
+		}
+		if !s.w.sourceExists(src) {
+			errs = addErrs(errs, Errf("%s: bad value for MetadataFromFile key %q, source not found: %s", pre, k, src))
+		}
+	}
+	for _, k := range ib.ClearMetadataOnStop {
+		if k == "" {
+			errs = addErrs(errs, Errf("%s: ClearMetadataOnStop keys can't be empty", pre))
+		}
+	}
+	if ib.Container != nil {
+		errs = addErrs(errs, ib.Container.validate(pre))
+	}
+	for _, ad := range ib.AdditionalDisks {
+		if ad.SizeGb <= 0 {
+			errs = addErrs(errs, Errf("%s: AdditionalDisks SizeGb must be greater than 0", pre))
+		}
+	}
+	for _, scope := range ib.Scopes {
+		if !strings.HasPrefix(scope, "https://") {
+			errs = addErrs(errs, Errf("%s: unknown Scopes alias %q", pre, scope))
+		}
+	}
+	seenPorts := map[int64]bool{}
+	for _, port := range ib.SerialPorts {
+		if port < 1 || port > 4 {
+			errs = addErrs(errs, Errf("%s: bad SerialPorts port %d, must be between 1 and 4", pre, port))
+		} else if seenPorts[port] {
+			errs = addErrs(errs, Errf("%s: duplicate SerialPorts port %d", pre, port))
+		}
+		seenPorts[port] = true
+	}
 
 	// Register creation.
-	errs = addErrs(errs, s.w.instances.regCreate(ib.daisyName, &ib.Resource, ib.OverWrite, s))
+	errs = addErrs(errs, s.w.instances.regCreate(ib.daisyName, &ib.Resource, ib.OverWrite || s.w.canAdopt(&ib.Resource), s))
 	return errs
 }
 
+// validDNSName reports whether n is a lowercase DNS name, e.g.
+// "corp.example.com".
+func validDNSName(n string) bool {
+	return len(n) <= maxDNSNameLength && dnsNameRgx.MatchString(n)
+}
+
+// validateReservationAffinity checks that SPECIFIC_RESERVATION names the
+// reservations to use and that the other types don't.
+func validateReservationAffinity(pre string, ra *compute.ReservationAffinity) (errs DError) {
+	if !strIn(ra.ConsumeReservationType, validConsumeReservationTypes) {
+		return Errf("%s: bad ReservationAffinity ConsumeReservationType %q, must be one of %q", pre, ra.ConsumeReservationType, validConsumeReservationTypes)
+	}
+	if ra.ConsumeReservationType != specificReservation {
+		if ra.Key != "" || len(ra.Values) > 0 {
+			errs = addErrs(errs, Errf("%s: ReservationAffinity Key and Values can only be set for %s", pre, specificReservation))
+		}
+		return
+	}
+	if len(ra.Values) == 0 {
+		errs = addErrs(errs, Errf("%s: ReservationAffinity %s requires the reservation name in Values", pre, specificReservation))
+	}
+	if ra.Key == reservationNameKey {
+		for _, v := range ra.Values {
+			if !reservationNameRgx.MatchString(v) {
+				errs = addErrs(errs, Errf("%s: bad ReservationAffinity reservation name %q", pre, v))
+			}
+		}
+	}
+	return
+}
+
+// validateScheduling checks the OnHostMaintenance policy and that
+// preemptible instances, which GCE can't restart or live migrate, aren't
+// set to be.
+func validateScheduling(pre string, sc *compute.Scheduling) (errs DError) {
+	if sc.OnHostMaintenance != "" && !strIn(sc.OnHostMaintenance, validOnHostMaintenances) {
+		errs = addErrs(errs, Errf("%s: bad Scheduling OnHostMaintenance %q, must be one of %q", pre, sc.OnHostMaintenance, validOnHostMaintenances))
+	}
+	if !sc.Preemptible {
+		return
+	}
+	if sc.AutomaticRestart != nil && *sc.AutomaticRestart {
+		errs = addErrs(errs, Errf("%s: Scheduling AutomaticRestart can't be true for preemptible instances", pre))
+	}
+	if sc.OnHostMaintenance == "MIGRATE" {
+		errs = addErrs(errs, Errf("%s: Scheduling OnHostMaintenance must be TERMINATE for preemptible instances", pre))
+	}
+	return
+}
+
 func (ib *InstanceBase) validateSourceMachineImage(ii InstanceInterface, s *Step) DError {
 	// regUse needs the partal url of a non daisy resource.
 	lookup := ii.getSourceMachineImage()
 	if lookup == "" {
 		return nil
 	}
+	if ib.Container != nil {
+		return Errf("cannot create instance %q: Container can't be used with SourceMachineImage, the machine image provides the boot disk", ib.daisyName)
+	}
+	if strings.Contains(lookup, "/") && !machineImageURLRgx.MatchString(lookup) {
+		return Errf("cannot create instance %q: bad SourceMachineImage %q, must be a workflow machine image name or a global/machineImages partial URL", ib.daisyName, lookup)
+	}
 	if _, err := s.w.machineImages.regUse(lookup, s); err != nil {
 		return newErr("failed to register use of machine image when creating an instance", err)
 	}
 	return nil
 }
 
+// addSourceMetadata adds the values of Secrets and MetadataFromFile to the
+// metadata sent when creating the instance. removeSourceMetadata must be
+// called once the instance is created.
+func (ib *InstanceBase) addSourceMetadata(ctx context.Context, ii InstanceInterface, w *Workflow) DError {
+	for field, m := range map[string]map[string]string{"Secrets": ib.Secrets, "MetadataFromFile": ib.MetadataFromFile} {
+		for k, src := range m {
+			v, err := w.sourceContent(ctx, src)
+			if err != nil {
+				return Errf("failed to read %s key %q from source %q: %v", field, k, src, err)
+			}
+			ii.appendComputeMetadata(k, &v)
+		}
+	}
+	return nil
+}
+
+// removeSourceMetadata removes the values of Secrets and MetadataFromFile
+// from the metadata kept in the workflow, they are read again if the
+// instance is recreated.
+func (ib *InstanceBase) removeSourceMetadata(ii InstanceInterface) {
+	for _, m := range []map[string]string{ib.Secrets, ib.MetadataFromFile} {
+		for k := range m {
+			ii.removeComputeMetadata(k)
+		}
+	}
+}
+
+// removeSensitiveMetadataFromInstance removes Secrets and
+// ClearMetadataOnStop from the metadata of the created instance.
+func (ib *InstanceBase) removeSensitiveMetadataFromInstance(ii InstanceInterface, w *Workflow) DError {
+	keys := append([]string(nil), ib.ClearMetadataOnStop...)
+	for k := range ib.Secrets {
+		keys = append(keys, k)
+	}
+	return w.removeInstanceMetadata(ib.Project, path.Base(ii.getZone()), ii.getName(), keys)
+}
+
+// removeInstanceMetadata removes keys from the metadata of an instance.
+func (w *Workflow) removeInstanceMetadata(project, zone, name string, keys []string) DError {
+	inst, err := w.ComputeClient.GetInstance(project, zone, name)
+	if err != nil {
+		return typedErr(apiError, "failed to get instance metadata", err)
+	}
+	md := &compute.Metadata{}
+	if inst.Metadata != nil {
+		md.Fingerprint = inst.Metadata.Fingerprint
+		for _, item := range inst.Metadata.Items {
+			if !strIn(item.Key, keys) {
+				md.Items = append(md.Items, item)
+			}
+		}
+	}
+	if err := w.ComputeClient.SetInstanceMetadata(project, zone, name, md); err != nil {
+		return typedErr(apiError, "failed to remove sensitive metadata from instance", err)
+	}
+	return nil
+}
+
+// clearMetadataWait is how long Daisy waits after removing the
+// ClearMetadataOnStop keys of an instance before stopping it.
+var clearMetadataWait = 10 * time.Second
+
+// clearMetadataBeforeStop removes keys from the metadata of a running
+// instance about to be stopped or imaged, then waits clearMetadataWait for the guest
+// environment to remove the credentials they hold. The guest doesn't report
+// when it's done, so this is best effort.
+func (w *Workflow) clearMetadataBeforeStop(project, zone, name string, keys []string) DError {
+	if err := w.removeInstanceMetadata(project, zone, name, keys); err != nil {
+		return err
+	}
+	SleepFn(clearMetadataWait)
+	return nil
+}
+
+// adoptExisting checks whether the instance already exists in GCE and, if its
+// configuration matches, reports that it can be used instead of creating it.
+func (ib *InstanceBase) adoptExisting(ii InstanceInterface, w *Workflow) (bool, DError) {
+	existing, err := w.ComputeClient.GetInstance(ib.Project, ii.getZone(), ii.getName())
+	if err != nil {
+		if isNotFound(err) {
+			return false, nil
+		}
+		return false, typedErr(apiError, "failed to get existing instance", err)
+	}
+
+	var m adoptMismatches
+	m.compareLink("MachineType", ii.getMachineType(), existing.MachineType)
+	if ii.getSourceMachineImage() == "" {
+		disks := ii.getComputeDisks()
+		m.compareInt("number of disks", int64(len(disks)), int64(len(existing.Disks)))
+		for di, d := range disks {
+			if di >= len(existing.Disks) {
+				break
+			}
+			field := fmt.Sprintf("Disks[%d]", di)
+			if d.hasInitializeParams {
+				m.compareLink(field, d.diskName, existing.Disks[di].Source)
+			} else {
+				m.compareLink(field, d.source, existing.Disks[di].Source)
+			}
+		}
+	}
+	if err := m.err("instance", ii.getName()); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 type computeDisk struct {
+	deviceName          string
 	mode                string
 	source              string
+	boot                bool
 	hasInitializeParams bool
 	diskName            string
 	sourceImage         string
+	sourceSnapshot      string
 	autoDelete          bool
 	diskType            string
+	diskSizeGb          int64
+	guestOsFeatures     []string
+	diskInterface       string
 }
 
 func (i *Instance) getComputeDisks() []*computeDisk {
 	var computeDisks []*computeDisk
 	for _, d := range i.Disks {
-		computeDisk := computeDisk{mode: d.Mode, source: d.Source, hasInitializeParams: d.InitializeParams != nil, autoDelete: d.AutoDelete}
+		computeDisk := computeDisk{deviceName: d.DeviceName, mode: d.Mode, source: d.Source, boot: d.Boot, hasInitializeParams: d.InitializeParams != nil, autoDelete: d.AutoDelete, diskInterface: d.Interface}
+		for _, f := range d.GuestOsFeatures {
+			computeDisk.guestOsFeatures = append(computeDisk.guestOsFeatures, f.Type)
+		}
 		if computeDisk.hasInitializeParams {
 			computeDisk.diskName = d.InitializeParams.DiskName
 			computeDisk.sourceImage = d.InitializeParams.SourceImage
+			computeDisk.sourceSnapshot = d.InitializeParams.SourceSnapshot
 			computeDisk.diskType = d.InitializeParams.DiskType
+			computeDisk.diskSizeGb = d.InitializeParams.DiskSizeGb
 		}
 		computeDisks = append(computeDisks, &computeDisk)
 	}
@@ -620,11 +1634,16 @@ func (i *Instance) getComputeDisks() []*computeDisk {
 func (i *InstanceBeta) getComputeDisks() []*computeDisk {
 	var computeDisks []*computeDisk
 	for _, d := range i.Disks {
-		computeDisk := computeDisk{mode: d.Mode, source: d.Source, hasInitializeParams: d.InitializeParams != nil, autoDelete: d.AutoDelete}
+		computeDisk := computeDisk{deviceName: d.DeviceName, mode: d.Mode, source: d.Source, boot: d.Boot, hasInitializeParams: d.InitializeParams != nil, autoDelete: d.AutoDelete, diskInterface: d.Interface}
+		for _, f := range d.GuestOsFeatures {
+			computeDisk.guestOsFeatures = append(computeDisk.guestOsFeatures, f.Type)
+		}
 		if computeDisk.hasInitializeParams {
 			computeDisk.diskName = d.InitializeParams.DiskName
 			computeDisk.sourceImage = d.InitializeParams.SourceImage
+			computeDisk.sourceSnapshot = d.InitializeParams.SourceSnapshot
 			computeDisk.diskType = d.InitializeParams.DiskType
+			computeDisk.diskSizeGb = d.InitializeParams.DiskSizeGb
 		}
 		computeDisks = append(computeDisks, &computeDisk)
 	}
@@ -639,10 +1658,29 @@ func (ib *InstanceBase) validateDisks(ii InstanceInterface, s *Step) (errs DErro
 	if len(computeDisks) > 0 && ii.getSourceMachineImage() != "" {
 		errs = addErrs(errs, Errf("cannot create instance: can't provide disks when SourceMachineImage provided"))
 	}
+	deviceNames := map[string]bool{}
+	machineTypes := append([]string{ii.getMachineType()}, ib.MachineTypeFallbacks...)
 	for _, d := range computeDisks {
+		if d.deviceName != "" {
+			if !checkName(d.deviceName) {
+				errs = addErrs(errs, Errf("cannot create instance: bad disk DeviceName: %q", d.deviceName))
+			}
+			if deviceNames[d.deviceName] {
+				errs = addErrs(errs, Errf("cannot create instance: duplicate disk DeviceName: %q", d.deviceName))
+			}
+			deviceNames[d.deviceName] = true
+		}
 		if !checkDiskMode(d.mode) {
 			errs = addErrs(errs, Errf("cannot create instance: bad disk mode: %q", d.mode))
 		}
+		readOnly := path.Base(d.mode) == diskModeRO
+		if d.boot && readOnly {
+			errs = addErrs(errs, Errf("cannot create instance: the boot disk must be attached READ_WRITE"))
+		} else if readOnly && d.hasInitializeParams && d.sourceImage == "" && d.sourceSnapshot == "" && NamedSubexp(diskTypeURLRgx, d.diskType)["disktype"] != "local-ssd" {
+			errs = addErrs(errs, Errf("cannot create instance: disk %q is created blank with the instance, it can't be attached READ_ONLY", d.diskName))
+		}
+		errs = addErrs(errs, validateAttachedDiskGuestOsFeatures("cannot create instance", d.guestOsFeatures, d.boot))
+		errs = addErrs(errs, validateDiskInterface("cannot create instance", d.diskInterface, machineTypes))
 		if d.source != "" && d.hasInitializeParams {
 			errs = addErrs(errs, Errf("cannot create instance: disk.source and disk.initializeParams are mutually exclusive"))
 		}
@@ -650,6 +1688,11 @@ func (ib *InstanceBase) validateDisks(ii InstanceInterface, s *Step) (errs DErro
 			errs = addErrs(errs, ib.validateDiskInitializeParams(d, ii, s))
 		} else {
 			errs = addErrs(errs, ib.validateDiskSource(d.source, ii, s))
+			if d.boot {
+				errs = addErrs(errs, ib.validateBootDiskSource(d.source, s))
+			} else if readOnly && ib.blankDiskSource(d.source, s) {
+				errs = addErrs(errs, Errf("cannot create instance: disk %q is blank and isn't written by another instance first, it can't be attached READ_ONLY", d.source))
+			}
 		}
 	}
 	return
@@ -667,16 +1710,32 @@ func (ib *InstanceBase) validateDiskInitializeParams(d *computeDisk, ii Instance
 		return
 	}
 
-	if _, err := s.w.images.regUse(d.sourceImage, s); err != nil {
-		errs = addErrs(errs, Errf("cannot create instance: can't use InitializeParams.SourceImage %q: %v", d.sourceImage, err))
+	// Non-boot disks, like AdditionalDisks, may be blank.
+	if d.boot && d.sourceImage == "" && d.sourceSnapshot == "" {
+		errs = addErrs(errs, Errf("cannot create instance: no bootable disk found; disk %q is blank, set InitializeParams.SourceImage or SourceSnapshot", d.diskName))
+	} else if d.sourceImage != "" {
+		ir, err := s.w.images.regUse(d.sourceImage, s)
+		if err != nil {
+			errs = addErrs(errs, Errf("cannot create instance: can't use InitializeParams.SourceImage %q: %v", d.sourceImage, err))
+		}
+		// The size of images created by the workflow isn't known yet.
+		if ir != nil && ir.creator == nil && d.diskSizeGb != 0 {
+			if min := s.w.imageDiskSizeGb(ir.link); d.diskSizeGb < min {
+				errs = addErrs(errs, Errf("cannot create instance: disk %q of %dGB is smaller than its SourceImage %q of %dGB", d.diskName, d.diskSizeGb, d.sourceImage, min))
+			}
+		}
 	}
 	if !rfc1035Rgx.MatchString(d.diskName) {
 		errs = addErrs(errs, Errf("cannot create instance: bad InitializeParams.DiskName: %q", d.diskName))
 	}
+	ref := ib.diskRef(d.boot, d.diskName)
+	if ref != d.diskName && !checkName(ref) {
+		errs = addErrs(errs, Errf("cannot create instance: bad BootDiskName: %q", ref))
+	}
 	link := fmt.Sprintf("projects/%s/zones/%s/disks/%s", ib.Project, ii.getZone(), d.diskName)
 	// Set cleanup if not being autodeleted.
 	r := &Resource{RealName: d.diskName, link: link, NoCleanup: d.autoDelete}
-	errs = addErrs(errs, s.w.disks.regCreate(d.diskName, r, s, false))
+	errs = addErrs(errs, s.w.disks.regCreate(ref, r, s, s.w.canAdopt(&ib.Resource)))
 
 	return
 }
@@ -688,33 +1747,96 @@ func (ib *InstanceBase) validateDiskSource(diskSource string, ii InstanceInterfa
 		return addErrs(errs, Errf("cannot create instance: disk %q not found in registry", diskSource))
 	}
 
-	// Ensure disk is in the same project and zone.
+	// Ensure disk is in the same project and zone, or replicated to the zone
+	// for regional disks.
+	if regionalDiskURLRgx.MatchString(dr.link) {
+		if result := NamedSubexp(regionalDiskURLRgx, dr.link); result["project"] != ib.Project {
+			errs = addErrs(errs, Errf("cannot create instance in project %q with disk in project %q: %q", ib.Project, result["project"], diskSource))
+		}
+		return addErrs(errs, validateRegionalDiskZone(s.w, "cannot create instance", dr.link, ii.getZone()))
+	}
 	result := NamedSubexp(diskURLRgx, dr.link)
 	if result["project"] != ib.Project {
 		errs = addErrs(errs, Errf("cannot create instance in project %q with disk in project %q: %q", ib.Project, result["project"], diskSource))
 	}
 	if result["zone"] != ii.getZone() {
-		errs = addErrs(errs, Errf("cannot create instance in project %q with disk in zone %q: %q", ii.getZone(), result["zone"], diskSource))
+		errs = addErrs(errs, Errf("cannot create instance in zone %q with disk in zone %q, zonal disks can only be attached in their zone: %q", ii.getZone(), result["zone"], diskSource))
 	}
 	return errs
 }
 
+// validateBootDiskSource checks that a boot disk created by the workflow isn't
+// blank, as the instance would only fail to boot once it times out. The disk
+// must be created from an image or snapshot, or be attached READ_WRITE to
+// another instance first, e.g. a worker that writes an imported image to it.
+func (ib *InstanceBase) validateBootDiskSource(diskSource string, s *Step) DError {
+	if ib.blankDiskSource(diskSource, s) {
+		return Errf("cannot create instance: no bootable disk found; disk %q is blank and isn't written by another instance first, create it from a SourceImage or SourceSnapshot", diskSource)
+	}
+	return nil
+}
+
+// blankDiskSource reports whether diskSource is a blank disk created by the
+// workflow and not attached READ_WRITE to another instance first.
+func (ib *InstanceBase) blankDiskSource(diskSource string, s *Step) bool {
+	dr, ok := s.w.disks.get(diskSource)
+	if !ok || dr.creator == nil || dr.creator.CreateDisks == nil {
+		// Disks that already exist can't be checked.
+		return false
+	}
+	for _, d := range *dr.creator.CreateDisks {
+		if d.daisyName != diskSource || d.SourceImage != "" || d.SourceSnapshot != "" {
+			continue
+		}
+		if !s.w.disks.attachedRW(diskSource, ib.daisyName) {
+			return true
+		}
+	}
+	return false
+}
+
 func (ib *InstanceBase) validateMachineType(ii InstanceInterface, w *Workflow) (errs DError) {
+	for _, mt := range ib.MachineTypeFallbacks {
+		errs = addErrs(errs, ib.validateMachineTypeURL(ii, mt, w))
+	}
 	if ii.getSourceMachineImage() != "" && ii.getMachineType() == "" {
 		return
 	}
+	return addErrs(errs, ib.validateMachineTypeURL(ii, ii.getMachineType(), w))
+}
+
+// validateArchitecture checks that the machine types can boot a boot disk
+// created from an image with an Architecture set in the workflow.
+func (ib *InstanceBase) validateArchitecture(ii InstanceInterface, s *Step) (errs DError) {
+	for _, d := range ii.getComputeDisks() {
+		if !d.boot || d.sourceImage == "" {
+			continue
+		}
+		arch, ok := s.w.images.architecture(d.sourceImage)
+		if !ok {
+			continue
+		}
+		for _, mt := range append([]string{ii.getMachineType()}, ib.MachineTypeFallbacks...) {
+			if mtArch := machineTypeArchitecture(mt); mtArch != arch {
+				errs = addErrs(errs, Errf("cannot create instance %q: MachineType %q is %s, but boot disk image %q is %s", ib.daisyName, path.Base(mt), mtArch, d.sourceImage, arch))
+			}
+		}
+	}
+	return
+}
 
-	if !machineTypeURLRegex.MatchString(ii.getMachineType()) {
-		errs = addErrs(errs, Errf("can't create instance: bad MachineType: %q", ii.getMachineType()))
+func (ib *InstanceBase) validateMachineTypeURL(ii InstanceInterface, mt string, w *Workflow) (errs DError) {
+	if !machineTypeURLRegex.MatchString(mt) {
+		errs = addErrs(errs, Errf("can't create instance: bad MachineType: %q", mt))
 		return
 	}
 
-	result := NamedSubexp(machineTypeURLRegex, ii.getMachineType())
+	result := NamedSubexp(machineTypeURLRegex, mt)
 	if result["project"] != ib.Project {
-		errs = addErrs(errs, Errf("cannot create instance in project %q with MachineType in project %q: %q", ib.Project, result["project"], ii.getMachineType()))
+		errs = addErrs(errs, Errf("cannot create instance in project %q with MachineType in project %q: %q", ib.Project, result["project"], mt))
 	}
 	if result["zone"] != ii.getZone() {
-		errs = addErrs(errs, Errf("cannot create instance in zone %q with MachineType in zone %q: %q", ii.getZone(), result["zone"], ii.getMachineType()))
+		errs = addErrs(errs, Errf("cannot create instance in zone %q with MachineType in zone %q: %q", ii.getZone(), result["zone"], mt))
 	}
 
 	if exists, err := w.machineTypeExists(result["project"], result["zone"], result["machinetype"]); err != nil {
@@ -725,13 +1847,41 @@ func (ib *InstanceBase) validateMachineType(ii InstanceInterface, w *Workflow) (
 	return
 }
 
+// instanceOptions returns the fields of ib the compute API version Daisy uses
+// has no field for.
+func (ib *InstanceBase) instanceOptions() daisyCompute.InstanceOptions {
+	return daisyCompute.InstanceOptions{TerminationAction: ib.InstanceTerminationAction, NetworkInterfaceStacks: ib.NetworkInterfaceStacks, ResourceManagerTags: ib.ResourceManagerTags}
+}
+
 func (i *Instance) validateNetworks(s *Step) (errs DError) {
-	for _, n := range i.NetworkInterfaces {
+	if i.NetworkInterfaces != nil && len(i.NetworkInterfaces) == 0 {
+		// Explicitly empty, the default interface is only added if unset.
+		return Errf("cannot create instance %q: NetworkInterfaces is empty, but every GCE instance needs a network interface; for no external access, use one with empty AccessConfigs on an isolated network", i.daisyName)
+	}
+	if len(i.NetworkInterfaceStacks) > len(i.NetworkInterfaces) {
+		errs = addErrs(errs, Errf("cannot create instance %q: %d NetworkInterfaceStacks given for %d NetworkInterfaces", i.daisyName, len(i.NetworkInterfaceStacks), len(i.NetworkInterfaces)))
+	}
+	for idx, n := range i.NetworkInterfaces {
+		var sn *Resource
 		if n.Subnetwork != "" {
-			_, err := s.w.subnetworks.regUse(n.Subnetwork, s)
+			var err DError
+			sn, err = s.w.subnetworks.regUse(n.Subnetwork, s)
 			if err != nil {
 				errs = addErrs(errs, err)
 			}
+			errs = addErrs(errs, validateSubnetworkRegion(i.daisyName, n.Subnetwork, sn, i.Zone))
+			errs = addErrs(errs, s.w.validateSubnetworkNetwork(i.daisyName, n.Network, n.Subnetwork, sn))
+		}
+		if idx < len(i.NetworkInterfaceStacks) {
+			errs = addErrs(errs, validateNetworkInterfaceStack(i.daisyName, i.NetworkInterfaceStacks[idx], n.Subnetwork, sn))
+		}
+		for _, r := range n.AliasIpRanges {
+			errs = addErrs(errs, validateAliasIPRange(i.daisyName, sn, r.IpCidrRange, r.SubnetworkRangeName))
+		}
+		for _, ac := range n.AccessConfigs {
+			if isAddressReference(ac.NatIP) {
+				errs = addErrs(errs, s.w.addresses.validateNatIP(i.daisyName, ac.NatIP, i.Zone, s))
+			}
 		}
 
 		if n.Network != "" {
@@ -746,12 +1896,34 @@ func (i *Instance) validateNetworks(s *Step) (errs DError) {
 }
 
 func (i *InstanceBeta) validateNetworks(s *Step) (errs DError) {
-	for _, n := range i.NetworkInterfaces {
+	if i.NetworkInterfaces != nil && len(i.NetworkInterfaces) == 0 {
+		// Explicitly empty, the default interface is only added if unset.
+		return Errf("cannot create instance %q: NetworkInterfaces is empty, but every GCE instance needs a network interface; for no external access, use one with empty AccessConfigs on an isolated network", i.daisyName)
+	}
+	if len(i.NetworkInterfaceStacks) > len(i.NetworkInterfaces) {
+		errs = addErrs(errs, Errf("cannot create instance %q: %d NetworkInterfaceStacks given for %d NetworkInterfaces", i.daisyName, len(i.NetworkInterfaceStacks), len(i.NetworkInterfaces)))
+	}
+	for idx, n := range i.NetworkInterfaces {
+		var sn *Resource
 		if n.Subnetwork != "" {
-			_, err := s.w.subnetworks.regUse(n.Subnetwork, s)
+			var err DError
+			sn, err = s.w.subnetworks.regUse(n.Subnetwork, s)
 			if err != nil {
 				errs = addErrs(errs, err)
 			}
+			errs = addErrs(errs, validateSubnetworkRegion(i.daisyName, n.Subnetwork, sn, i.Zone))
+			errs = addErrs(errs, s.w.validateSubnetworkNetwork(i.daisyName, n.Network, n.Subnetwork, sn))
+		}
+		if idx < len(i.NetworkInterfaceStacks) {
+			errs = addErrs(errs, validateNetworkInterfaceStack(i.daisyName, i.NetworkInterfaceStacks[idx], n.Subnetwork, sn))
+		}
+		for _, r := range n.AliasIpRanges {
+			errs = addErrs(errs, validateAliasIPRange(i.daisyName, sn, r.IpCidrRange, r.SubnetworkRangeName))
+		}
+		for _, ac := range n.AccessConfigs {
+			if isAddressReference(ac.NatIP) {
+				errs = addErrs(errs, s.w.addresses.validateNatIP(i.daisyName, ac.NatIP, i.Zone, s))
+			}
 		}
 
 		if n.Network != "" {
@@ -765,8 +1937,149 @@ func (i *InstanceBeta) validateNetworks(s *Step) (errs DError) {
 	return
 }
 
+// validateNetworkInterfaceStack checks the stack and IPv6 access types of a
+// network interface and that a dual-stack interface has a subnetwork. The
+// subnetworks the workflow creates have no IPv6 support.
+func validateNetworkInterfaceStack(instance string, st daisyCompute.NetworkInterfaceStack, subnetwork string, sn *Resource) DError {
+	pre := fmt.Sprintf("cannot create instance %q", instance)
+	if st.StackType != "" && !strIn(st.StackType, validStackTypes) {
+		return Errf("%s: bad StackType %q, must be one of %q", pre, st.StackType, validStackTypes)
+	}
+	if st.StackType != "IPV4_IPV6" {
+		if st.Ipv6AccessType != "" {
+			return Errf("%s: Ipv6AccessType can only be set with StackType \"IPV4_IPV6\"", pre)
+		}
+		return nil
+	}
+	if !strIn(st.Ipv6AccessType, validIpv6AccessTypes) {
+		return Errf("%s: bad Ipv6AccessType %q, must be one of %q", pre, st.Ipv6AccessType, validIpv6AccessTypes)
+	}
+	if subnetwork == "" {
+		return Errf("%s: a network interface with StackType \"IPV4_IPV6\" must set an IPv6 enabled Subnetwork", pre)
+	}
+	if sn != nil && sn.creator != nil && sn.creator.CreateSubnetworks != nil {
+		return Errf("%s: subnetwork %q is created by the workflow without IPv6, StackType \"IPV4_IPV6\" needs an existing IPv6 enabled subnetwork", pre, subnetwork)
+	}
+	return nil
+}
+
+// validateAliasIPRange checks the IpCidrRange of an alias IP range is an IP
+// address, a netmask such as "/24" or a CIDR range and, if the workflow
+// creates subnetwork sn, that sn has the secondary range named rangeName.
+func validateAliasIPRange(instance string, sn *Resource, cidr, rangeName string) DError {
+	pre := fmt.Sprintf("cannot create instance %q", instance)
+	if !validAliasIPCidrRange(cidr) {
+		return Errf("%s: bad AliasIpRanges IpCidrRange: %q", pre, cidr)
+	}
+	if rangeName == "" || sn == nil || sn.creator == nil || sn.creator.CreateSubnetworks == nil {
+		return nil
+	}
+	for _, def := range *sn.creator.CreateSubnetworks {
+		if &def.Resource != sn {
+			continue
+		}
+		for _, r := range def.SecondaryIpRanges {
+			if r.RangeName == rangeName {
+				return nil
+			}
+		}
+		return Errf("%s: AliasIpRanges SubnetworkRangeName %q is not a secondary range of subnetwork %q", pre, rangeName, def.daisyName)
+	}
+	return nil
+}
+
+// validateSubnetworkRegion checks subnetwork sn is in the region of the
+// instance's zone, when both are known.
+func validateSubnetworkRegion(instance, subnetwork string, sn *Resource, zone string) DError {
+	if sn == nil || zone == "" {
+		return nil
+	}
+	region := NamedSubexp(subnetworkURLRegex, sn.link)["region"]
+	if want := getRegionFromZone(zone); region != "" && region != want {
+		return Errf("cannot create instance %q: subnetwork %q is in region %q, it must be in the instance's region %q", instance, subnetwork, region, want)
+	}
+	return nil
+}
+
+// validateSubnetworkNetwork checks a network interface that sets both
+// Network and a subnetwork the workflow creates uses that subnetwork's
+// network. Without Network, GCE uses the subnetwork's network.
+func (w *Workflow) validateSubnetworkNetwork(instance, network, subnetwork string, sn *Resource) DError {
+	if network == "" || sn == nil || sn.creator == nil || sn.creator.CreateSubnetworks == nil {
+		return nil
+	}
+	for _, def := range *sn.creator.CreateSubnetworks {
+		if &def.Resource != sn {
+			continue
+		}
+		if w.networkName(network) != w.networkName(def.Network) {
+			return Errf("cannot create instance %q: subnetwork %q is in network %q, not in the interface's Network %q", instance, subnetwork, def.Network, network)
+		}
+	}
+	return nil
+}
+
+// networkName returns the GCE name of network n, a workflow network name or a
+// network URL.
+func (w *Workflow) networkName(n string) string {
+	if res, ok := w.networks.get(n); ok {
+		return path.Base(res.link)
+	}
+	return path.Base(n)
+}
+
+func validAliasIPCidrRange(cidr string) bool {
+	if strings.HasPrefix(cidr, "/") {
+		bits, err := strconv.Atoi(cidr[1:])
+		return err == nil && bits >= 0 && bits <= 32
+	}
+	if net.ParseIP(cidr) != nil {
+		return true
+	}
+	_, _, err := net.ParseCIDR(cidr)
+	return err == nil
+}
+
 type instanceRegistry struct {
 	baseResourceRegistry
+	// stoppers are the StopInstances steps stopping each instance.
+	stoppers map[*Resource][]*Step
+}
+
+// createdInstance returns the InstanceBase of the instance the workflow
+// references as name, or nil if it wasn't created by a CreateInstances step.
+func (ir *instanceRegistry) createdInstance(name string) *InstanceBase {
+	r, ok := ir.get(name)
+	if !ok {
+		return nil
+	}
+	return createdInstanceBase(r)
+}
+
+// createdInstanceBase returns the InstanceBase of the instance resource r,
+// or nil if it wasn't created by a CreateInstances step.
+func createdInstanceBase(r *Resource) *InstanceBase {
+	_, ib := createdInstanceOf(r)
+	return ib
+}
+
+// createdInstanceOf returns the instance resource r and its InstanceBase, or
+// nils if it wasn't created by a CreateInstances step.
+func createdInstanceOf(r *Resource) (InstanceInterface, *InstanceBase) {
+	if r.creator == nil || r.creator.CreateInstances == nil {
+		return nil, nil
+	}
+	for _, i := range r.creator.CreateInstances.Instances {
+		if &i.Resource == r {
+			return i, &i.InstanceBase
+		}
+	}
+	for _, i := range r.creator.CreateInstances.InstancesBeta {
+		if &i.Resource == r {
+			return i, &i.InstanceBase
+		}
+	}
+	return nil, nil
 }
 
 func newInstanceRegistry(w *Workflow) *instanceRegistry {
@@ -778,6 +2091,37 @@ func newInstanceRegistry(w *Workflow) *instanceRegistry {
 	return ir
 }
 
+// regStop records that StopInstances step s stops the instance name.
+func (ir *instanceRegistry) regStop(name string, s *Step) {
+	r, ok := ir.get(name)
+	if !ok {
+		return
+	}
+	ir.mx.Lock()
+	defer ir.mx.Unlock()
+	if ir.stoppers == nil {
+		ir.stoppers = map[*Resource][]*Step{}
+	}
+	ir.stoppers[r] = append(ir.stoppers[r], s)
+}
+
+// stoppedBefore reports whether step s depends on a StopInstances step that
+// stops the instance name.
+func (ir *instanceRegistry) stoppedBefore(name string, s *Step) bool {
+	r, ok := ir.get(name)
+	if !ok {
+		return false
+	}
+	ir.mx.Lock()
+	defer ir.mx.Unlock()
+	for _, st := range ir.stoppers[r] {
+		if s.nestedDepends(st) {
+			return true
+		}
+	}
+	return false
+}
+
 // SleepFn function is mocked on testing.
 var SleepFn = time.Sleep
 
@@ -791,6 +2135,10 @@ func (ir *instanceRegistry) deleteFn(res *Resource) DError {
 			continue
 		}
 	}
+	// Instances have to leave their instance group before they're deleted.
+	if err := ir.w.instanceGroups.leave(res.link); err != nil {
+		return err
+	}
 	// Proceed to instance deletion
 	err := ir.w.ComputeClient.DeleteInstance(m["project"], m["zone"], m["instance"])
 	if gErr, ok := err.(*googleapi.Error); ok && gErr.Code == http.StatusNotFound {
@@ -810,6 +2158,11 @@ func (ir *instanceRegistry) startFn(res *Resource) DError {
 
 func (ir *instanceRegistry) stopFn(res *Resource) DError {
 	m := NamedSubexp(instanceURLRgx, res.link)
+	if ib := createdInstanceBase(res); ib != nil && len(ib.ClearMetadataOnStop) > 0 {
+		if err := ir.w.clearMetadataBeforeStop(m["project"], m["zone"], m["instance"], ib.ClearMetadataOnStop); err != nil {
+			return err
+		}
+	}
 	err := ir.w.ComputeClient.StopInstance(m["project"], m["zone"], m["instance"])
 	if gErr, ok := err.(*googleapi.Error); ok && gErr.Code == http.StatusNotFound {
 		return typedErr(resourceDNEError, "failed to stop instance", err)
@@ -824,14 +2177,12 @@ func (ir *instanceRegistry) regCreate(name string, res *Resource, overWrite bool
 	// Find the Instance responsible for this.
 	for _, i := range (*s.CreateInstances).Instances {
 		if &i.Resource == res {
-			i.register(name, s, ir, errs)
-			return errs
+			return addErrs(errs, i.register(name, s, ir))
 		}
 	}
 	for _, i := range (*s.CreateInstances).InstancesBeta {
 		if &i.Resource == res {
-			i.register(name, s, ir, errs)
-			return errs
+			return addErrs(errs, i.register(name, s, ir))
 		}
 	}
 
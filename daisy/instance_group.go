@@ -0,0 +1,118 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"sync"
+
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+)
+
+var (
+	instanceGroupURLRgx = regexp.MustCompile(fmt.Sprintf(`^(projects/(?P<project>%[1]s)/)?zones/(?P<zone>%[2]s)/instanceGroups/(?P<instanceGroup>%[2]s)$`, projectRgxStr, rfc1035))
+)
+
+// instanceGroupRegistry tracks the unmanaged instance groups that instances
+// are added to, see InstanceBase.InstanceGroup. Groups are created on demand
+// when the first instance joins them, so they are registered during run
+// rather than validation, keyed by their link.
+type instanceGroupRegistry struct {
+	baseResourceRegistry
+	joinMx sync.Mutex
+	// members maps the link of each instance that joined a group to the link
+	// of that group.
+	members   map[string]string
+	membersMx sync.Mutex
+}
+
+func newInstanceGroupRegistry(w *Workflow) *instanceGroupRegistry {
+	igr := &instanceGroupRegistry{baseResourceRegistry: baseResourceRegistry{w: w, typeName: "instanceGroup", urlRgx: instanceGroupURLRgx}}
+	igr.baseResourceRegistry.deleteFn = igr.deleteFn
+	igr.members = map[string]string{}
+	igr.init()
+	return igr
+}
+
+func (igr *instanceGroupRegistry) deleteFn(res *Resource) DError {
+	m := NamedSubexp(instanceGroupURLRgx, res.link)
+	err := igr.w.ComputeClient.DeleteInstanceGroup(m["project"], m["zone"], m["instanceGroup"])
+	if gErr, ok := err.(*googleapi.Error); ok && gErr.Code == http.StatusNotFound {
+		return typedErr(resourceDNEError, "failed to delete instance group", err)
+	}
+	return newErr("failed to delete instance group", err)
+}
+
+// join adds the instance to the named unmanaged instance group, creating the
+// group if it doesn't exist yet.
+func (igr *instanceGroupRegistry) join(s *Step, project, zone, name, instance string) DError {
+	w := igr.w
+	zone = path.Base(zone)
+	link := fmt.Sprintf("projects/%s/zones/%s/instanceGroups/%s", project, zone, name)
+
+	igr.joinMx.Lock()
+	if _, ok := igr.get(link); !ok {
+		if _, err := w.ComputeClient.GetInstanceGroup(project, zone, name); err != nil {
+			if !isNotFound(err) {
+				igr.joinMx.Unlock()
+				return newErr("failed to get instance group", err)
+			}
+			w.LogStepInfo(s.name, "CreateInstances", "Creating instance group %q.", name)
+			ig := &compute.InstanceGroup{Name: name, Description: defaultDescription("InstanceGroup", w.Name, w.username)}
+			if err := w.ComputeClient.CreateInstanceGroup(project, zone, ig); err != nil {
+				igr.joinMx.Unlock()
+				return newErr("failed to create instance group", err)
+			}
+			res := &Resource{Project: project, RealName: name, link: link, createdInWorkflow: true}
+			if err := igr.regCreate(link, res, s, true); err != nil {
+				igr.joinMx.Unlock()
+				return err
+			}
+		}
+	}
+	igr.joinMx.Unlock()
+
+	w.LogStepInfo(s.name, "CreateInstances", "Adding instance %q to instance group %q.", path.Base(instance), name)
+	if err := w.ComputeClient.AddInstanceGroupInstances(project, zone, name, []string{instance}); err != nil {
+		return newErr("failed to add instance to instance group", err)
+	}
+	igr.membersMx.Lock()
+	igr.members[instance] = link
+	igr.membersMx.Unlock()
+	return nil
+}
+
+// leave removes the instance from the instance group it joined, if any. It is
+// called before deleting the instance.
+func (igr *instanceGroupRegistry) leave(instance string) DError {
+	igr.membersMx.Lock()
+	link, ok := igr.members[instance]
+	delete(igr.members, instance)
+	igr.membersMx.Unlock()
+	if !ok {
+		return nil
+	}
+
+	m := NamedSubexp(instanceGroupURLRgx, link)
+	err := igr.w.ComputeClient.RemoveInstanceGroupInstances(m["project"], m["zone"], m["instanceGroup"], []string{instance})
+	if err != nil && !isNotFound(err) {
+		return newErr("failed to remove instance from instance group", err)
+	}
+	return nil
+}
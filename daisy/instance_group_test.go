@@ -0,0 +1,113 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"net/http"
+	"testing"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+)
+
+func TestInstanceGroupJoinAndLeave(t *testing.T) {
+	w := testWorkflow()
+	s := &Step{name: "s", w: w}
+	groupExists := false
+	var created, deleted int
+	var added, removed []string
+	tc := w.ComputeClient.(*daisyCompute.TestClient)
+	tc.GetInstanceGroupFn = func(_, _, name string) (*compute.InstanceGroup, error) {
+		if !groupExists {
+			return nil, &googleapi.Error{Code: http.StatusNotFound}
+		}
+		return &compute.InstanceGroup{Name: name}, nil
+	}
+	tc.CreateInstanceGroupFn = func(_, _ string, ig *compute.InstanceGroup) error {
+		created++
+		groupExists = true
+		return nil
+	}
+	tc.DeleteInstanceGroupFn = func(_, _, _ string) error {
+		deleted++
+		return nil
+	}
+	tc.AddInstanceGroupInstancesFn = func(_, _, _ string, instances []string) error {
+		added = append(added, instances...)
+		return nil
+	}
+	tc.RemoveInstanceGroupInstancesFn = func(_, _, _ string, instances []string) error {
+		removed = append(removed, instances...)
+		return nil
+	}
+
+	i1 := "projects/p/zones/z/instances/i1"
+	i2 := "projects/p/zones/z/instances/i2"
+	assert.Nil(t, w.instanceGroups.join(s, "p", "z", "ig", i1))
+	assert.Nil(t, w.instanceGroups.join(s, "p", "zones/z", "ig", i2))
+	assert.Equal(t, 1, created)
+	assert.Equal(t, []string{i1, i2}, added)
+
+	assert.Nil(t, w.instanceGroups.leave(i1))
+	assert.Nil(t, w.instanceGroups.leave(i1))
+	assert.Nil(t, w.instanceGroups.leave("projects/p/zones/z/instances/other"))
+	assert.Equal(t, []string{i1}, removed)
+
+	w.instanceGroups.cleanup()
+	assert.Equal(t, 1, deleted)
+}
+
+func TestInstanceGroupJoinExisting(t *testing.T) {
+	w := testWorkflow()
+	s := &Step{name: "s", w: w}
+	tc := w.ComputeClient.(*daisyCompute.TestClient)
+	tc.GetInstanceGroupFn = func(_, _, name string) (*compute.InstanceGroup, error) {
+		return &compute.InstanceGroup{Name: name}, nil
+	}
+	tc.CreateInstanceGroupFn = func(_, _ string, _ *compute.InstanceGroup) error {
+		t.Error("unexpected instance group creation")
+		return nil
+	}
+	tc.AddInstanceGroupInstancesFn = func(_, _, _ string, _ []string) error { return nil }
+	tc.DeleteInstanceGroupFn = func(_, _, _ string) error {
+		t.Error("existing instance group should not be cleaned up")
+		return nil
+	}
+
+	assert.Nil(t, w.instanceGroups.join(s, "p", "z", "ig", "projects/p/zones/z/instances/i1"))
+	w.instanceGroups.cleanup()
+}
+
+func TestInstanceDeleteLeavesInstanceGroup(t *testing.T) {
+	w := testWorkflow()
+	var calls []string
+	tc := w.ComputeClient.(*daisyCompute.TestClient)
+	tc.RemoveInstanceGroupInstancesFn = func(_, _, _ string, _ []string) error {
+		calls = append(calls, "remove")
+		return nil
+	}
+	tc.DeleteInstanceFn = func(_, _, _ string) error {
+		calls = append(calls, "delete")
+		return nil
+	}
+	tc.GetInstanceFn = func(_, _, _ string) (*compute.Instance, error) { return &compute.Instance{}, nil }
+
+	link := "projects/p/zones/z/instances/i1"
+	w.instanceGroups.members[link] = "projects/p/zones/z/instanceGroups/ig"
+	assert.Nil(t, w.instances.deleteFn(&Resource{link: link}))
+	assert.Equal(t, []string{"remove", "delete"}, calls)
+}
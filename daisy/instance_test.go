@@ -22,8 +22,11 @@ import (
 	"reflect"
 	"sort"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
 	computeBeta "google.golang.org/api/compute/v0.beta"
 	"google.golang.org/api/compute/v1"
 )
@@ -75,6 +78,35 @@ func TestInstancePopulate(t *testing.T) {
 	}
 }
 
+func TestInstancePopulateDescription(t *testing.T) {
+	w := testWorkflow()
+	defaultDesc := fmt.Sprintf("Instance created by Daisy in workflow %q on behalf of %s.", w.Name, w.username)
+
+	tests := []struct {
+		desc, input, want string
+	}{
+		{"default case", "", defaultDesc},
+		{"custom case", "cost-center: 1234", "cost-center: 1234"},
+	}
+
+	for testNum, tt := range tests {
+		s, _ := w.NewStep("s" + strconv.Itoa(testNum))
+		i := &Instance{Instance: compute.Instance{Description: tt.input}}
+		if err := (&i.InstanceBase).populate(context.Background(), i, s); err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		} else if i.Description != tt.want {
+			t.Errorf("%s: want description %q, got %q", tt.desc, tt.want, i.Description)
+		}
+
+		iBeta := &InstanceBeta{Instance: computeBeta.Instance{Description: tt.input}}
+		if err := (&iBeta.InstanceBase).populate(context.Background(), iBeta, s); err != nil {
+			t.Errorf("%s beta: unexpected error: %v", tt.desc, err)
+		} else if iBeta.Description != tt.want {
+			t.Errorf("%s beta: want description %q, got %q", tt.desc, tt.want, iBeta.Description)
+		}
+	}
+}
+
 func TestInstancePopulateDisks(t *testing.T) {
 	w := testWorkflow()
 
@@ -168,6 +200,13 @@ func TestInstancePopulateDisks(t *testing.T) {
 				{InitializeParams: &computeBeta.AttachedDiskInitializeParams{DiskName: fmt.Sprintf("%s-2", iName), SourceImage: "i", DiskType: defDT}, Mode: defaultDiskMode, DeviceName: fmt.Sprintf("%s-2", iName)},
 			},
 		},
+		{
+			"explicit boot disk",
+			[]*compute.AttachedDisk{{Source: "d1"}, {Source: "d2"}, {Source: "boot", Boot: true, AutoDelete: true}},
+			[]*compute.AttachedDisk{{Source: "boot", Mode: defaultDiskMode, Boot: true, AutoDelete: true, DeviceName: "boot"}, {Source: "d1", Mode: defaultDiskMode, DeviceName: "d1"}, {Source: "d2", Mode: defaultDiskMode, DeviceName: "d2"}},
+			[]*computeBeta.AttachedDisk{{Source: "d1"}, {Source: "d2"}, {Source: "boot", Boot: true, AutoDelete: true}},
+			[]*computeBeta.AttachedDisk{{Source: "boot", Mode: defaultDiskMode, Boot: true, AutoDelete: true, DeviceName: "boot"}, {Source: "d1", Mode: defaultDiskMode, DeviceName: "d1"}, {Source: "d2", Mode: defaultDiskMode, DeviceName: "d2"}},
+		},
 	}
 
 	assertTest := func(err DError, desc string, ad, wantAd interface{}) {
@@ -188,6 +227,136 @@ func TestInstancePopulateDisks(t *testing.T) {
 	}
 }
 
+func TestInstancePopulateDisksMultipleBoot(t *testing.T) {
+	w := testWorkflow()
+	i := Instance{Instance: compute.Instance{Name: "foo", Disks: []*compute.AttachedDisk{{Source: "d1", Boot: true}, {Source: "d2", Boot: true}}, Zone: testZone}, InstanceBase: InstanceBase{Resource: Resource{Project: testProject}}}
+	if err := i.populateDisks(w); err == nil {
+		t.Error("populateDisks should have returned an error")
+	}
+	iBeta := InstanceBeta{Instance: computeBeta.Instance{Name: "foo", Disks: []*computeBeta.AttachedDisk{{Source: "d1", Boot: true}, {Source: "d2", Boot: true}}, Zone: testZone}, InstanceBase: InstanceBase{Resource: Resource{Project: testProject}}}
+	if err := iBeta.populateDisks(w); err == nil {
+		t.Error("beta: populateDisks should have returned an error")
+	}
+}
+
+func TestInstancePopulateAdditionalDisks(t *testing.T) {
+	w := testWorkflow()
+	iName := "foo"
+	ads := []*AdditionalDisk{{SizeGb: 10, Type: "pd-ssd"}, {SizeGb: 20, DeviceName: "scratch", Interface: "NVME"}}
+	ssdDT := fmt.Sprintf("projects/%s/zones/%s/diskTypes/pd-ssd", testProject, testZone)
+	defDT := fmt.Sprintf("projects/%s/zones/%s/diskTypes/%s", testProject, testZone, defaultDiskType)
+
+	i := Instance{Instance: compute.Instance{Name: iName, Disks: []*compute.AttachedDisk{{Source: "d1"}}, Zone: testZone}, InstanceBase: InstanceBase{Resource: Resource{Project: testProject}, AdditionalDisks: ads}}
+	want := []*compute.AttachedDisk{
+		{Boot: true, Source: "d1", Mode: defaultDiskMode, DeviceName: "d1"},
+		{AutoDelete: true, InitializeParams: &compute.AttachedDiskInitializeParams{DiskName: iName, DiskSizeGb: 10, DiskType: ssdDT}, Mode: defaultDiskMode, DeviceName: iName},
+		{AutoDelete: true, InitializeParams: &compute.AttachedDiskInitializeParams{DiskName: iName + "-2", DiskSizeGb: 20, DiskType: defDT}, Mode: defaultDiskMode, DeviceName: "scratch", Interface: "NVME"},
+	}
+	if err := i.populateDisks(w); err != nil {
+		t.Errorf("populateDisks returned an unexpected error: %v", err)
+	} else if diffRes := diff(i.Disks, want, 0); diffRes != "" {
+		t.Errorf("AttachedDisks not modified as expected: (-got +want)\n%s", diffRes)
+	}
+
+	iBeta := InstanceBeta{Instance: computeBeta.Instance{Name: iName, Disks: []*computeBeta.AttachedDisk{{Source: "d1"}}, Zone: testZone}, InstanceBase: InstanceBase{Resource: Resource{Project: testProject}, AdditionalDisks: ads}}
+	wantBeta := []*computeBeta.AttachedDisk{
+		{Boot: true, Source: "d1", Mode: defaultDiskMode, DeviceName: "d1"},
+		{AutoDelete: true, InitializeParams: &computeBeta.AttachedDiskInitializeParams{DiskName: iName, DiskSizeGb: 10, DiskType: ssdDT}, Mode: defaultDiskMode, DeviceName: iName},
+		{AutoDelete: true, InitializeParams: &computeBeta.AttachedDiskInitializeParams{DiskName: iName + "-2", DiskSizeGb: 20, DiskType: defDT}, Mode: defaultDiskMode, DeviceName: "scratch", Interface: "NVME"},
+	}
+	if err := iBeta.populateDisks(w); err != nil {
+		t.Errorf("beta: populateDisks returned an unexpected error: %v", err)
+	} else if diffRes := diff(iBeta.Disks, wantBeta, 0); diffRes != "" {
+		t.Errorf("beta: AttachedDisks not modified as expected: (-got +want)\n%s", diffRes)
+	}
+}
+
+func TestInstanceBootDiskName(t *testing.T) {
+	w := testWorkflow()
+	w.images.m = map[string]*Resource{"i": {link: "iLink"}}
+	takenCreator, _ := w.NewStep("takenCreator")
+	w.disks.m = map[string]*Resource{"taken": {RealName: "taken", link: "taken-link", creator: takenCreator}}
+
+	tests := []struct {
+		desc, bootDiskName string
+		exactName          bool
+		p                  *compute.AttachedDiskInitializeParams
+		wantDiskName       string
+		shouldErr          bool
+	}{
+		{"generated name case", "boot", false, &compute.AttachedDiskInitializeParams{SourceImage: "i"}, w.genName("boot"), false},
+		{"exact name case", "boot-exact", true, &compute.AttachedDiskInitializeParams{SourceImage: "i"}, "boot-exact", false},
+		{"bad DiskName also set case", "boot2", false, &compute.AttachedDiskInitializeParams{DiskName: "foo", SourceImage: "i"}, "foo", true},
+		{"bad name case", "bad!", true, &compute.AttachedDiskInitializeParams{SourceImage: "i"}, "bad!", true},
+		{"bad dupe disk case", "taken", false, &compute.AttachedDiskInitializeParams{SourceImage: "i"}, w.genName("taken"), true},
+	}
+
+	for _, tt := range tests {
+		s, _ := w.NewStep(tt.desc)
+		ci := &Instance{
+			Instance:     compute.Instance{Name: "inst", Disks: []*compute.AttachedDisk{{InitializeParams: tt.p}}, Zone: testZone},
+			InstanceBase: InstanceBase{Resource: Resource{Project: testProject, ExactName: tt.exactName}, BootDiskName: tt.bootDiskName},
+		}
+		s.CreateInstances = &CreateInstances{Instances: []*Instance{ci}}
+		err := ci.populateDisks(w)
+		if got := ci.Disks[0].InitializeParams.DiskName; got != tt.wantDiskName {
+			t.Errorf("%s: got DiskName %q, want %q", tt.desc, got, tt.wantDiskName)
+		}
+		err = addErrs(err, (&ci.InstanceBase).validateDiskInitializeParams(ci.getComputeDisks()[0], ci, s))
+		if tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error but didn't", tt.desc)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+	}
+
+	// The boot disk is referenced by BootDiskName.
+	want := &Resource{RealName: w.genName("boot"), link: fmt.Sprintf("projects/%s/zones/%s/disks/%s", testProject, testZone, w.genName("boot")), creator: w.Steps["generated name case"]}
+	if got, ok := w.disks.m["boot"]; !ok || !reflect.DeepEqual(got, want) {
+		t.Errorf("boot disk not registered as expected: got: %+v, want: %+v", got, want)
+	}
+
+	// BootDiskName needs a boot disk created from InitializeParams.
+	ci := &Instance{Instance: compute.Instance{Name: "inst", Disks: []*compute.AttachedDisk{{Source: "d"}}}, InstanceBase: InstanceBase{BootDiskName: "boot"}}
+	if err := ci.populateDisks(w); err == nil {
+		t.Error("boot disk without InitializeParams: should have returned an error but didn't")
+	}
+	ciBeta := &InstanceBeta{Instance: computeBeta.Instance{Name: "inst", Disks: []*computeBeta.AttachedDisk{{InitializeParams: &computeBeta.AttachedDiskInitializeParams{SourceImage: "i"}}}}, InstanceBase: InstanceBase{Resource: Resource{ExactName: true}, BootDiskName: "boot-beta"}}
+	if err := ciBeta.populateDisks(w); err != nil {
+		t.Errorf("beta: unexpected error: %v", err)
+	} else if got := ciBeta.Disks[0].InitializeParams.DiskName; got != "boot-beta" {
+		t.Errorf("beta: got DiskName %q, want %q", got, "boot-beta")
+	}
+}
+
+func TestInstancePopulateScreenshotInterval(t *testing.T) {
+	tests := []struct {
+		desc, interval string
+		want           time.Duration
+		shouldErr      bool
+	}{
+		{"unset case", "", 0, false},
+		{"good case", "30s", 30 * time.Second, false},
+		{"bad duration case", "foo", 0, true},
+		{"bad zero case", "0s", 0, true},
+	}
+	for _, tt := range tests {
+		i := &Instance{InstanceBase: InstanceBase{ScreenshotInterval: tt.interval}}
+		err := i.populateScreenshotInterval(i)
+		if tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+		if i.screenshotInterval != tt.want {
+			t.Errorf("%s: got interval %v, want %v", tt.desc, i.screenshotInterval, tt.want)
+		}
+		if gotDisplay := i.DisplayDevice != nil && i.DisplayDevice.EnableDisplay; gotDisplay != (tt.want > 0) {
+			t.Errorf("%s: got display enabled %v", tt.desc, gotDisplay)
+		}
+	}
+}
+
 func TestInstancePopulateMachineType(t *testing.T) {
 	tests := []struct {
 		desc, mt, wantMt string
@@ -208,8 +377,9 @@ func TestInstancePopulateMachineType(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		i := Instance{Instance: compute.Instance{MachineType: tt.mt, Zone: "bar"}, InstanceBase: InstanceBase{Resource: Resource{Project: "foo"}}}
+		i := Instance{Instance: compute.Instance{MachineType: tt.mt, Zone: "bar"}, InstanceBase: InstanceBase{Resource: Resource{Project: "foo"}, MachineTypeFallbacks: []string{tt.mt}}}
 		assertTest(tt.shouldErr, (&i.InstanceBase).populateMachineType(&i), tt.desc, i.MachineType, tt.wantMt)
+		assertTest(tt.shouldErr, nil, tt.desc+" fallback", i.MachineTypeFallbacks[0], tt.wantMt)
 
 		iBeta := InstanceBeta{Instance: computeBeta.Instance{MachineType: tt.mt, Zone: "bar"}, InstanceBase: InstanceBase{Resource: Resource{Project: "foo"}}}
 		assertTest(tt.shouldErr, (&i.InstanceBase).populateMachineType(&iBeta), tt.desc+" beta", iBeta.MachineType, tt.wantMt)
@@ -223,9 +393,11 @@ func TestInstancePopulateMetadata(t *testing.T) {
 	filePath := "gs://" + path.Join(w.bucket, w.sourcesPath, "file")
 
 	baseMd := map[string]string{
-		"daisy-sources-path": "gs://" + path.Join(w.bucket, w.sourcesPath),
-		"daisy-logs-path":    "gs://" + path.Join(w.bucket, w.logsPath),
-		"daisy-outs-path":    "gs://" + path.Join(w.bucket, w.outsPath),
+		"daisy-sources-path":  "gs://" + path.Join(w.bucket, w.sourcesPath),
+		"daisy-logs-path":     "gs://" + path.Join(w.bucket, w.logsPath),
+		"daisy-outs-path":     "gs://" + path.Join(w.bucket, w.outsPath),
+		"daisy-workflow-name": w.Name,
+		"daisy-workflow-id":   w.id,
 	}
 	getWantMd := func(md map[string]string) *compute.Metadata {
 		if md == nil {
@@ -260,12 +432,32 @@ func TestInstancePopulateMetadata(t *testing.T) {
 		desc          string
 		md            map[string]string
 		startupScript string
+		enableOSLogin bool
+		serialPorts   []int64
 		wantMd        map[string]string
 		shouldErr     bool
 	}{
-		{"defaults case", nil, "", map[string]string{}, false},
-		{"startup script case", nil, "file", map[string]string{"startup-script-url": filePath, "windows-startup-script-url": filePath}, false},
-		{"bad startup script case", nil, "foo", nil, true},
+		{"defaults case", nil, "", false, nil, map[string]string{}, false},
+		{"startup script case", nil, "file", false, nil, map[string]string{"startup-script-url": filePath, "windows-startup-script-url": filePath}, false},
+		{"bad startup script case", nil, "foo", false, nil, nil, true},
+		{"startup script url case", nil, "gs://bucket/script.sh", false, nil, map[string]string{"startup-script-url": "gs://bucket/script.sh", "windows-startup-script-url": "gs://bucket/script.sh"}, false},
+		{"enable os login case", nil, "", true, nil, map[string]string{"enable-oslogin": "TRUE"}, false},
+		{"enable os login matching metadata case", map[string]string{"enable-oslogin": "true"}, "", true, nil, map[string]string{"enable-oslogin": "TRUE"}, false},
+		{"enable os login conflicting metadata case", map[string]string{"enable-oslogin": "false"}, "", true, nil, nil, true},
+		{"serial port 1 case", nil, "", false, []int64{1}, map[string]string{}, false},
+		{"extra serial ports case", nil, "", false, []int64{1, 2}, map[string]string{"serial-port-enable": "true"}, false},
+		{"extra serial ports matching metadata case", map[string]string{"serial-port-enable": "1"}, "", false, []int64{3}, map[string]string{"serial-port-enable": "1"}, false},
+		{"extra serial ports conflicting metadata case", map[string]string{"serial-port-enable": "false"}, "", false, []int64{2}, nil, true},
+	}
+	copyMd := func(md map[string]string) map[string]string {
+		if md == nil {
+			return nil
+		}
+		result := map[string]string{}
+		for k, v := range md {
+			result[k] = v
+		}
+		return result
 	}
 	compFactory := func(items []*compute.MetadataItems) func(i, j int) bool {
 		return func(i, j int) bool { return items[i].Key < items[j].Key }
@@ -297,18 +489,99 @@ func TestInstancePopulateMetadata(t *testing.T) {
 			sort.Slice(wantMdBeta.Items, compFactoryBeta(wantMdBeta.Items))
 		}
 
-		i := Instance{InstanceBase: InstanceBase{StartupScript: tt.startupScript}, Metadata: tt.md}
+		startupScript, startupScriptURL := tt.startupScript, ""
+		if strings.HasPrefix(startupScript, "gs://") {
+			startupScript, startupScriptURL = "", tt.startupScript
+		}
+		i := Instance{InstanceBase: InstanceBase{StartupScript: startupScript, StartupScriptURL: startupScriptURL, EnableOSLogin: tt.enableOSLogin, SerialPorts: tt.serialPorts}, Metadata: copyMd(tt.md)}
 		err := (&i.InstanceBase).populateMetadata(&i, w)
 		sort.Slice(i.Instance.Metadata.Items, compFactory(i.Instance.Metadata.Items))
 		assertTest(tt.shouldErr, err, tt.desc, i.Instance.Metadata, wantMd)
 
-		iBeta := Instance{InstanceBase: InstanceBase{StartupScript: tt.startupScript}, Metadata: tt.md}
+		iBeta := Instance{InstanceBase: InstanceBase{StartupScript: startupScript, StartupScriptURL: startupScriptURL, EnableOSLogin: tt.enableOSLogin, SerialPorts: tt.serialPorts}, Metadata: copyMd(tt.md)}
 		err = (&iBeta.InstanceBase).populateMetadata(&iBeta, w)
 		sort.Slice(iBeta.Instance.Metadata.Items, compFactory(iBeta.Instance.Metadata.Items))
 		assertTest(tt.shouldErr, err, tt.desc+" beta", iBeta.Instance.Metadata, wantMdBeta)
 	}
 }
 
+func TestInstancePopulateMetadataSecretStartupScript(t *testing.T) {
+	w := testWorkflow()
+	w.Sources = map[string]string{"token": "gs://bucket/token"}
+	i := &Instance{InstanceBase: InstanceBase{StartupScript: "token", Secrets: map[string]string{"token": "token"}}}
+	if err := i.InstanceBase.populateMetadata(i, w); err == nil {
+		t.Error("a Secrets source used as StartupScript should have returned an error")
+	}
+}
+
+func TestInstancePopulateInlineStartupScript(t *testing.T) {
+	w := testWorkflow()
+	tests := []struct {
+		desc, scriptType string
+		md               map[string]string
+		wantKey          string
+		shouldErr        bool
+	}{
+		{"linux case", "", nil, "startup-script", false},
+		{"windows case", "ps1", nil, "windows-startup-script-ps1", false},
+		{"key in metadata case", "", map[string]string{"startup-script": "echo"}, "", true},
+	}
+	for _, tt := range tests {
+		i := &Instance{InstanceBase: InstanceBase{InlineStartupScript: "echo hello", InlineStartupScriptType: tt.scriptType}, Metadata: tt.md}
+		err := i.InstanceBase.populateMetadata(i, w)
+		if tt.shouldErr {
+			if err == nil {
+				t.Errorf("%s: should have returned an error", tt.desc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+			continue
+		}
+		if got := i.Metadata[tt.wantKey]; got != "echo hello" {
+			t.Errorf("%s: got metadata %q = %q, want %q", tt.desc, tt.wantKey, got, "echo hello")
+		}
+	}
+}
+
+func TestInstancePopulateStructuredMetadata(t *testing.T) {
+	w := testWorkflow()
+	tests := []struct {
+		desc      string
+		md        map[string]string
+		smd       map[string]interface{}
+		want      map[string]string
+		shouldErr bool
+	}{
+		{"object case", nil, map[string]interface{}{"agent-config": map[string]interface{}{"port": 8080, "tags": []string{"a", "b"}}},
+			map[string]string{"agent-config": `{"port":8080,"tags":["a","b"]}`}, false},
+		{"scalar case", map[string]string{"other": "value"}, map[string]interface{}{"enabled": true, "name": "n"},
+			map[string]string{"other": "value", "enabled": "true", "name": `"n"`}, false},
+		{"key in metadata case", map[string]string{"agent-config": "{}"}, map[string]interface{}{"agent-config": map[string]interface{}{}}, nil, true},
+		{"not serializable case", nil, map[string]interface{}{"agent-config": make(chan int)}, nil, true},
+	}
+	for _, tt := range tests {
+		i := &Instance{InstanceBase: InstanceBase{StructuredMetadata: tt.smd}, Metadata: tt.md}
+		err := i.InstanceBase.populateMetadata(i, w)
+		if tt.shouldErr {
+			if err == nil {
+				t.Errorf("%s: should have returned an error", tt.desc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+			continue
+		}
+		for k, v := range tt.want {
+			if i.Metadata[k] != v {
+				t.Errorf("%s: got metadata %q = %q, want %q", tt.desc, k, i.Metadata[k], v)
+			}
+		}
+	}
+}
+
 func TestInstancePopulateNetworks(t *testing.T) {
 	defaultAcs := []*compute.AccessConfig{{Type: "ONE_TO_ONE_NAT"}}
 	defaultAcsBeta := []*computeBeta.AccessConfig{{Type: "ONE_TO_ONE_NAT"}}
@@ -389,6 +662,8 @@ func TestInstancePopulateNetworks(t *testing.T) {
 
 func TestInstancePopulateScopes(t *testing.T) {
 	defaultScopes := []string{"https://www.googleapis.com/auth/devstorage.read_only"}
+	aliasScopes := []string{"storage-rw", "https://www.googleapis.com/auth/logging.write", "logging-write", "foo"}
+	wantAliasScopes := []string{"https://www.googleapis.com/auth/devstorage.read_write", "https://www.googleapis.com/auth/logging.write", "foo"}
 	tests := []struct {
 		desc                   string
 		input                  []string
@@ -399,6 +674,7 @@ func TestInstancePopulateScopes(t *testing.T) {
 		{"default case", nil, nil, []*compute.ServiceAccount{{Email: "default", Scopes: defaultScopes}}, nil, []*computeBeta.ServiceAccount{{Email: "default", Scopes: defaultScopes}}, false},
 		{"nondefault case", []string{"foo"}, nil, []*compute.ServiceAccount{{Email: "default", Scopes: []string{"foo"}}}, nil, []*computeBeta.ServiceAccount{{Email: "default", Scopes: []string{"foo"}}}, false},
 		{"service accounts override case", []string{"foo"}, []*compute.ServiceAccount{}, []*compute.ServiceAccount{}, []*computeBeta.ServiceAccount{}, []*computeBeta.ServiceAccount{}, false},
+		{"alias case", aliasScopes, nil, []*compute.ServiceAccount{{Email: "default", Scopes: wantAliasScopes}}, nil, []*computeBeta.ServiceAccount{{Email: "default", Scopes: wantAliasScopes}}, false},
 	}
 
 	for _, tt := range tests {
@@ -428,6 +704,99 @@ func TestInstancePopulateScopes(t *testing.T) {
 	}
 }
 
+func TestInstancePopulateScopesServiceAccount(t *testing.T) {
+	sa := "builder@my-project.iam.gserviceaccount.com"
+	defaultScopes := []string{"https://www.googleapis.com/auth/devstorage.read_only"}
+	i := &Instance{InstanceBase: InstanceBase{ServiceAccount: sa}}
+	if err := i.populateScopes(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diffRes := diff(i.ServiceAccounts, []*compute.ServiceAccount{{Email: sa, Scopes: defaultScopes}}, 0); diffRes != "" {
+		t.Errorf("ServiceAccounts not modified as expected: (-got +want)\n%s", diffRes)
+	}
+
+	// A machine image's service account is overridden by ServiceAccount.
+	iBeta := &InstanceBeta{InstanceBase: InstanceBase{ServiceAccount: sa, Scopes: []string{"cloud-platform"}}, Instance: computeBeta.Instance{SourceMachineImage: "mi"}}
+	if err := iBeta.populateScopes(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wantBeta := []*computeBeta.ServiceAccount{{Email: sa, Scopes: []string{"https://www.googleapis.com/auth/cloud-platform"}}}
+	if diffRes := diff(iBeta.ServiceAccounts, wantBeta, 0); diffRes != "" {
+		t.Errorf("ServiceAccounts not modified as expected: (-got +want)\n%s", diffRes)
+	}
+
+	i = &Instance{InstanceBase: InstanceBase{ServiceAccount: sa}, Instance: compute.Instance{ServiceAccounts: []*compute.ServiceAccount{{Email: "default"}}}}
+	if err := i.populateScopes(); err == nil {
+		t.Error("ServiceAccount and ServiceAccounts should have returned an error")
+	}
+}
+
+func TestInstanceBetaPopulateSourceMachineImage(t *testing.T) {
+	// Unset fields are left for the machine image to provide.
+	i := &InstanceBeta{Instance: computeBeta.Instance{SourceMachineImage: "mi"}, InstanceBase: InstanceBase{Resource: Resource{Project: testProject}}}
+	if err := i.populateNetworks(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := i.populateScopes(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if i.NetworkInterfaces != nil || i.ServiceAccounts != nil {
+		t.Errorf("got NetworkInterfaces %v and ServiceAccounts %v, want both unset", i.NetworkInterfaces, i.ServiceAccounts)
+	}
+
+	// Fields that are set override the machine image's and are populated as usual.
+	i = &InstanceBeta{
+		Instance:     computeBeta.Instance{SourceMachineImage: "mi", NetworkInterfaces: []*computeBeta.NetworkInterface{{Network: "global/networks/foo"}}},
+		InstanceBase: InstanceBase{Resource: Resource{Project: testProject}, Scopes: []string{"cloud-platform"}},
+	}
+	if err := i.populateNetworks(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := i.populateScopes(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wantNics := []*computeBeta.NetworkInterface{{Network: fmt.Sprintf("projects/%s/global/networks/foo", testProject), AccessConfigs: []*computeBeta.AccessConfig{{Type: "ONE_TO_ONE_NAT"}}}}
+	if diffRes := diff(i.NetworkInterfaces, wantNics, 0); diffRes != "" {
+		t.Errorf("NetworkInterfaces not modified as expected: (-got +want)\n%s", diffRes)
+	}
+	wantSas := []*computeBeta.ServiceAccount{{Email: "default", Scopes: []string{"https://www.googleapis.com/auth/cloud-platform"}}}
+	if diffRes := diff(i.ServiceAccounts, wantSas, 0); diffRes != "" {
+		t.Errorf("ServiceAccounts not modified as expected: (-got +want)\n%s", diffRes)
+	}
+}
+
+func TestInstancePopulateDNSSearchDomains(t *testing.T) {
+	w := testWorkflow()
+	tests := []struct {
+		desc      string
+		md        map[string]string
+		domains   []string
+		want      string
+		shouldErr bool
+	}{
+		{"normal case", nil, []string{"corp.example.com", "example.com"}, "corp.example.com example.com", false},
+		{"unset case", nil, nil, "", false},
+		{"key in metadata case", map[string]string{dnsSearchDomainsMetadataKey: "example.com"}, []string{"corp.example.com"}, "", true},
+	}
+	for _, tt := range tests {
+		i := &Instance{InstanceBase: InstanceBase{DNSSearchDomains: tt.domains}, Metadata: tt.md}
+		err := i.InstanceBase.populateMetadata(i, w)
+		if tt.shouldErr {
+			if err == nil {
+				t.Errorf("%s: should have returned an error", tt.desc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+			continue
+		}
+		if got := i.Metadata[dnsSearchDomainsMetadataKey]; got != tt.want {
+			t.Errorf("%s: got metadata %q = %q, want %q", tt.desc, dnsSearchDomainsMetadataKey, got, tt.want)
+		}
+	}
+}
+
 func TestInstancesValidate(t *testing.T) {
 	ctx := context.Background()
 	w := testWorkflow()
@@ -441,6 +810,8 @@ func TestInstancesValidate(t *testing.T) {
 	mt := fmt.Sprintf("projects/%s/zones/%s/machineTypes/%s", testProject, testZone, testMachineType)
 	ad := []*compute.AttachedDisk{{Source: fmt.Sprintf("projects/%s/zones/%s/disks/%s", w.Project, w.Zone, testDisk), Mode: defaultDiskMode}}
 	sourceMachineImage := fmt.Sprintf("projects/%s/global/machineImages/%s", w.Project, "test-machine-image")
+	w.Sources = map[string]string{"token": "gs://bucket/token"}
+	tr, f := true, false
 
 	tests := []struct {
 		desc      string
@@ -452,6 +823,64 @@ func TestInstancesValidate(t *testing.T) {
 		{desc: "failure dupe case v1", i: &Instance{Instance: compute.Instance{Name: "i", Disks: ad, MachineType: mt}}, shouldErr: true},
 		{desc: "success simple case v0 beta", iBeta: &InstanceBeta{Instance: computeBeta.Instance{Name: "ib", MachineType: mt, SourceMachineImage: sourceMachineImage}}, shouldErr: false},
 		{desc: "failure dupe case v0 beta", iBeta: &InstanceBeta{Instance: computeBeta.Instance{Name: "ib", MachineType: mt, SourceMachineImage: sourceMachineImage}}, shouldErr: true},
+		{desc: "success serial ports case", i: &Instance{InstanceBase: InstanceBase{SerialPorts: []int64{1, 4}}, Instance: compute.Instance{Name: "i2", Disks: ad, MachineType: mt}}, shouldErr: false},
+		{desc: "failure bad serial port case", i: &Instance{InstanceBase: InstanceBase{SerialPorts: []int64{5}}, Instance: compute.Instance{Name: "i3", Disks: ad, MachineType: mt}}, shouldErr: true},
+		{desc: "failure duplicate serial port case", i: &Instance{InstanceBase: InstanceBase{SerialPorts: []int64{2, 2}}, Instance: compute.Instance{Name: "i4", Disks: ad, MachineType: mt}}, shouldErr: true},
+		{desc: "success instance group case", i: &Instance{InstanceBase: InstanceBase{InstanceGroup: "ig"}, Instance: compute.Instance{Name: "i5", Disks: ad, MachineType: mt}}, shouldErr: false},
+		{desc: "failure bad instance group case", i: &Instance{InstanceBase: InstanceBase{InstanceGroup: "bad_ig"}, Instance: compute.Instance{Name: "i6", Disks: ad, MachineType: mt}}, shouldErr: true},
+		{desc: "success scopes case", i: &Instance{InstanceBase: InstanceBase{Scopes: []string{"https://www.googleapis.com/auth/cloud-platform"}}, Instance: compute.Instance{Name: "i7", Disks: ad, MachineType: mt}}, shouldErr: false},
+		{desc: "success secrets case", i: &Instance{InstanceBase: InstanceBase{Secrets: map[string]string{"token": "token"}}, Instance: compute.Instance{Name: "i10", Disks: ad, MachineType: mt}}, shouldErr: false},
+		{desc: "failure secrets source case", i: &Instance{InstanceBase: InstanceBase{Secrets: map[string]string{"token": "dne"}}, Instance: compute.Instance{Name: "i11", Disks: ad, MachineType: mt}}, shouldErr: true},
+		{desc: "failure secrets metadata conflict case", i: &Instance{InstanceBase: InstanceBase{Secrets: map[string]string{"token": "token"}}, Instance: compute.Instance{Name: "i12", Disks: ad, MachineType: mt}, Metadata: map[string]string{"token": "v"}}, shouldErr: true},
+		{desc: "failure additional disk size case", i: &Instance{InstanceBase: InstanceBase{AdditionalDisks: []*AdditionalDisk{{}}}, Instance: compute.Instance{Name: "i9", Disks: ad, MachineType: mt}}, shouldErr: true},
+		{desc: "success startup script url case", i: &Instance{InstanceBase: InstanceBase{StartupScriptURL: "gs://bucket/startup.sh"}, Instance: compute.Instance{Name: "i13", Disks: ad, MachineType: mt}}, shouldErr: false},
+		{desc: "failure bad startup script url case", i: &Instance{InstanceBase: InstanceBase{StartupScriptURL: "/local/startup.sh"}, Instance: compute.Instance{Name: "i14", Disks: ad, MachineType: mt}}, shouldErr: true},
+		{desc: "failure startup script and url case", i: &Instance{InstanceBase: InstanceBase{StartupScript: "gs://bucket/sources/startup.sh", StartupScriptURL: "gs://bucket/startup.sh"}, Instance: compute.Instance{Name: "i15", Disks: ad, MachineType: mt}}, shouldErr: true},
+		{desc: "success inline startup script case", i: &Instance{InstanceBase: InstanceBase{InlineStartupScript: "echo hello", InlineStartupScriptType: "ps1"}, Instance: compute.Instance{Name: "i50", Disks: ad, MachineType: mt}}, shouldErr: false},
+		{desc: "failure inline startup script and url case", i: &Instance{InstanceBase: InstanceBase{InlineStartupScript: "echo hello", StartupScriptURL: "gs://bucket/startup.sh"}, Instance: compute.Instance{Name: "i51", Disks: ad, MachineType: mt}}, shouldErr: true},
+		{desc: "failure bad inline startup script type case", i: &Instance{InstanceBase: InstanceBase{InlineStartupScript: "echo hello", InlineStartupScriptType: "sh"}, Instance: compute.Instance{Name: "i52", Disks: ad, MachineType: mt}}, shouldErr: true},
+		{desc: "failure inline startup script type without script case", i: &Instance{InstanceBase: InstanceBase{InlineStartupScriptType: "cmd"}, Instance: compute.Instance{Name: "i53", Disks: ad, MachineType: mt}}, shouldErr: true},
+		{desc: "success metadata from file case", i: &Instance{InstanceBase: InstanceBase{MetadataFromFile: map[string]string{"user-data": "token"}}, Instance: compute.Instance{Name: "i54", Disks: ad, MachineType: mt}}, shouldErr: false},
+		{desc: "failure metadata from file source case", i: &Instance{InstanceBase: InstanceBase{MetadataFromFile: map[string]string{"user-data": "dne"}}, Instance: compute.Instance{Name: "i55", Disks: ad, MachineType: mt}}, shouldErr: true},
+		{desc: "failure metadata from file metadata conflict case", i: &Instance{InstanceBase: InstanceBase{MetadataFromFile: map[string]string{"user-data": "token"}}, Instance: compute.Instance{Name: "i56", Disks: ad, MachineType: mt}, Metadata: map[string]string{"user-data": "v"}}, shouldErr: true},
+		{desc: "failure metadata from file secrets conflict case", i: &Instance{InstanceBase: InstanceBase{MetadataFromFile: map[string]string{"token": "token"}, Secrets: map[string]string{"token": "token"}}, Instance: compute.Instance{Name: "i57", Disks: ad, MachineType: mt}}, shouldErr: true},
+		{desc: "success labels and service account case", i: &Instance{InstanceBase: InstanceBase{ServiceAccount: "builder@my-project.iam.gserviceaccount.com"}, Instance: compute.Instance{Name: "i58", Disks: ad, MachineType: mt, Labels: map[string]string{"team": "images", "cost-center": ""}}}, shouldErr: false},
+		{desc: "failure bad label key case", i: &Instance{Instance: compute.Instance{Name: "i59", Disks: ad, MachineType: mt, Labels: map[string]string{"Team": "images"}}}, shouldErr: true},
+		{desc: "failure bad label value beta case", iBeta: &InstanceBeta{Instance: computeBeta.Instance{Name: "ib60", MachineType: mt, SourceMachineImage: sourceMachineImage, Labels: map[string]string{"team": "Images!"}}}, shouldErr: true},
+		{desc: "failure bad service account case", i: &Instance{InstanceBase: InstanceBase{ServiceAccount: "builder"}, Instance: compute.Instance{Name: "i61", Disks: ad, MachineType: mt}}, shouldErr: true},
+		{desc: "success preemptible scheduling case", i: &Instance{Instance: compute.Instance{Name: "i62", Disks: ad, MachineType: mt, Scheduling: &compute.Scheduling{Preemptible: true, AutomaticRestart: &f, OnHostMaintenance: "TERMINATE"}}}, shouldErr: false},
+		{desc: "failure preemptible automatic restart case", i: &Instance{Instance: compute.Instance{Name: "i63", Disks: ad, MachineType: mt, Scheduling: &compute.Scheduling{Preemptible: true, AutomaticRestart: &tr}}}, shouldErr: true},
+		{desc: "failure preemptible migrate beta case", iBeta: &InstanceBeta{Instance: computeBeta.Instance{Name: "ib64", MachineType: mt, SourceMachineImage: sourceMachineImage, Scheduling: &computeBeta.Scheduling{Preemptible: true, OnHostMaintenance: "MIGRATE"}}}, shouldErr: true},
+		{desc: "failure bad on host maintenance case", i: &Instance{Instance: compute.Instance{Name: "i65", Disks: ad, MachineType: mt, Scheduling: &compute.Scheduling{OnHostMaintenance: "RESTART"}}}, shouldErr: true},
+		{desc: "failure unknown scope alias case", i: &Instance{InstanceBase: InstanceBase{Scopes: []string{"storage-rx"}}, Instance: compute.Instance{Name: "i8", Disks: ad, MachineType: mt}}, shouldErr: true},
+		{desc: "success termination action case", i: &Instance{InstanceBase: InstanceBase{InstanceTerminationAction: "DELETE"}, Instance: compute.Instance{Name: "i16", Disks: ad, MachineType: mt, Scheduling: &compute.Scheduling{Preemptible: true}}}, shouldErr: false},
+		{desc: "success termination action beta case", iBeta: &InstanceBeta{InstanceBase: InstanceBase{InstanceTerminationAction: "STOP"}, Instance: computeBeta.Instance{Name: "ib16", MachineType: mt, SourceMachineImage: sourceMachineImage, Scheduling: &computeBeta.Scheduling{Preemptible: true}}}, shouldErr: false},
+		{desc: "failure bad termination action case", i: &Instance{InstanceBase: InstanceBase{InstanceTerminationAction: "RESTART"}, Instance: compute.Instance{Name: "i17", Disks: ad, MachineType: mt, Scheduling: &compute.Scheduling{Preemptible: true}}}, shouldErr: true},
+		{desc: "failure termination action not preemptible case", i: &Instance{InstanceBase: InstanceBase{InstanceTerminationAction: "DELETE"}, Instance: compute.Instance{Name: "i18", Disks: ad, MachineType: mt}}, shouldErr: true},
+		{desc: "success specific reservation case", i: &Instance{Instance: compute.Instance{Name: "i19", Disks: ad, MachineType: mt, ReservationAffinity: &compute.ReservationAffinity{ConsumeReservationType: "SPECIFIC_RESERVATION", Key: reservationNameKey, Values: []string{"res1"}}}}, shouldErr: false},
+		{desc: "success shared reservation case", i: &Instance{Instance: compute.Instance{Name: "i20", Disks: ad, MachineType: mt, ReservationAffinity: &compute.ReservationAffinity{ConsumeReservationType: "SPECIFIC_RESERVATION", Key: reservationNameKey, Values: []string{"projects/other/reservations/res1"}}}}, shouldErr: false},
+		{desc: "success any reservation case", i: &Instance{Instance: compute.Instance{Name: "i21", Disks: ad, MachineType: mt, ReservationAffinity: &compute.ReservationAffinity{ConsumeReservationType: "ANY_RESERVATION"}}}, shouldErr: false},
+		{desc: "success no reservation beta case", iBeta: &InstanceBeta{Instance: computeBeta.Instance{Name: "ib22", MachineType: mt, SourceMachineImage: sourceMachineImage, ReservationAffinity: &computeBeta.ReservationAffinity{ConsumeReservationType: "NO_RESERVATION"}}}, shouldErr: false},
+		{desc: "failure specific reservation without name case", i: &Instance{Instance: compute.Instance{Name: "i23", Disks: ad, MachineType: mt, ReservationAffinity: &compute.ReservationAffinity{ConsumeReservationType: "SPECIFIC_RESERVATION", Key: reservationNameKey}}}, shouldErr: true},
+		{desc: "failure specific reservation without name beta case", iBeta: &InstanceBeta{Instance: computeBeta.Instance{Name: "ib24", MachineType: mt, SourceMachineImage: sourceMachineImage, ReservationAffinity: &computeBeta.ReservationAffinity{ConsumeReservationType: "SPECIFIC_RESERVATION"}}}, shouldErr: true},
+		{desc: "failure bad reservation name case", i: &Instance{Instance: compute.Instance{Name: "i25", Disks: ad, MachineType: mt, ReservationAffinity: &compute.ReservationAffinity{ConsumeReservationType: "SPECIFIC_RESERVATION", Key: reservationNameKey, Values: []string{"Bad_Name"}}}}, shouldErr: true},
+		{desc: "failure name with any reservation case", i: &Instance{Instance: compute.Instance{Name: "i26", Disks: ad, MachineType: mt, ReservationAffinity: &compute.ReservationAffinity{ConsumeReservationType: "ANY_RESERVATION", Values: []string{"res1"}}}}, shouldErr: true},
+		{desc: "success nvme disk case", i: &Instance{Instance: compute.Instance{Name: "i28", Disks: []*compute.AttachedDisk{{Source: ad[0].Source, Mode: defaultDiskMode, Interface: "NVME"}}, MachineType: mt}}, shouldErr: false},
+		{desc: "failure bad disk interface case", i: &Instance{Instance: compute.Instance{Name: "i29", Disks: []*compute.AttachedDisk{{Source: ad[0].Source, Mode: defaultDiskMode, Interface: "IDE"}}, MachineType: mt}}, shouldErr: true},
+		{desc: "failure bad reservation type case", i: &Instance{Instance: compute.Instance{Name: "i27", Disks: ad, MachineType: mt, ReservationAffinity: &compute.ReservationAffinity{ConsumeReservationType: "SOME_RESERVATION"}}}, shouldErr: true},
+		{desc: "success machine image without machine type case", iBeta: &InstanceBeta{Instance: computeBeta.Instance{Name: "ib30", SourceMachineImage: sourceMachineImage}}, shouldErr: false},
+		{desc: "failure bad machine image url case", iBeta: &InstanceBeta{Instance: computeBeta.Instance{Name: "ib31", MachineType: mt, SourceMachineImage: "projects/p/global/images/test-machine-image"}}, shouldErr: true},
+		{desc: "success hostname case", i: &Instance{Instance: compute.Instance{Name: "i33", Disks: ad, MachineType: mt, Hostname: "build.corp.example.com"}}, shouldErr: false},
+		{desc: "failure single label hostname case", i: &Instance{Instance: compute.Instance{Name: "i34", Disks: ad, MachineType: mt, Hostname: "build"}}, shouldErr: true},
+		{desc: "failure bad hostname beta case", iBeta: &InstanceBeta{Instance: computeBeta.Instance{Name: "ib35", MachineType: mt, SourceMachineImage: sourceMachineImage, Hostname: "Build.example.com"}}, shouldErr: true},
+		{desc: "failure long hostname label case", i: &Instance{Instance: compute.Instance{Name: "i36", Disks: ad, MachineType: mt, Hostname: strings.Repeat("a", 64) + ".example.com"}}, shouldErr: true},
+		{desc: "success dns search domains case", i: &Instance{InstanceBase: InstanceBase{DNSSearchDomains: []string{"corp", "corp.example.com"}}, Instance: compute.Instance{Name: "i37", Disks: ad, MachineType: mt}}, shouldErr: false},
+		{desc: "failure bad dns search domain case", i: &Instance{InstanceBase: InstanceBase{DNSSearchDomains: []string{"corp..example.com"}}, Instance: compute.Instance{Name: "i38", Disks: ad, MachineType: mt}}, shouldErr: true},
+		{desc: "success clear metadata on stop case", i: &Instance{InstanceBase: InstanceBase{ClearMetadataOnStop: []string{"ssh-keys"}}, Instance: compute.Instance{Name: "i39", Disks: ad, MachineType: mt}}, shouldErr: false},
+		{desc: "failure empty clear metadata on stop key case", i: &Instance{InstanceBase: InstanceBase{ClearMetadataOnStop: []string{""}}, Instance: compute.Instance{Name: "i40", Disks: ad, MachineType: mt}}, shouldErr: true},
+		{desc: "success resource manager tags case", i: &Instance{InstanceBase: InstanceBase{ResourceManagerTags: map[string]string{"tagKeys/123": "tagValues/456"}}, Instance: compute.Instance{Name: "i41", Disks: ad, MachineType: mt}}, shouldErr: false},
+		{desc: "failure bad resource manager tags case", iBeta: &InstanceBeta{InstanceBase: InstanceBase{ResourceManagerTags: map[string]string{"tagKeys/123": "prod"}}, Instance: computeBeta.Instance{Name: "ib42", MachineType: mt, SourceMachineImage: sourceMachineImage}}, shouldErr: true},
+		{desc: "failure machine image with container case", iBeta: &InstanceBeta{InstanceBase: InstanceBase{Container: &Container{Image: "gcr.io/p/c"}}, Instance: computeBeta.Instance{Name: "ib32", MachineType: mt, SourceMachineImage: sourceMachineImage}}, shouldErr: true},
 	}
 
 	for _, tt := range tests {
@@ -473,6 +902,8 @@ func TestInstancesValidate(t *testing.T) {
 		ib.link = fmt.Sprintf("projects/%s/zones/%s/instances/%s", w.Project, w.Zone, ii.getName())
 		ib.Project = w.Project // Resource{} fields are tested in resource_test.
 		ii.setZone(w.Zone)
+		// Each case attaches the same disk READ_WRITE, to a new instance.
+		w.disks.attachments = map[string]map[string]*diskAttachment{}
 
 		if err := s.validate(ctx); tt.shouldErr && err == nil {
 			t.Errorf("%s: should have returned an error", tt.desc)
@@ -482,6 +913,101 @@ func TestInstancesValidate(t *testing.T) {
 	}
 }
 
+func TestInstancesValidateRWMultiAttach(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		desc         string
+		mode1, mode2 string
+		shouldErr    bool
+	}{
+		{"both RO case", diskModeRO, diskModeRO, false},
+		{"both RW case", diskModeRW, diskModeRW, true},
+		{"RW and RO case", diskModeRW, diskModeRO, true},
+	}
+	for _, tt := range tests {
+		w := testWorkflow()
+		s, e1 := w.NewStep("s")
+		var e2 error
+		w.ComputeClient, e2 = newTestGCEClient()
+		if errs := addErrs(nil, e1, e2); errs != nil {
+			t.Fatalf("test set up error: %v", errs)
+		}
+		mt := fmt.Sprintf("projects/%s/zones/%s/machineTypes/%s", testProject, testZone, testMachineType)
+		d := fmt.Sprintf("projects/%s/zones/%s/disks/%s", w.Project, w.Zone, testDisk)
+		var is []*Instance
+		for n, mode := range []string{tt.mode1, tt.mode2} {
+			i := &Instance{Instance: compute.Instance{Name: fmt.Sprintf("i%d", n), Disks: []*compute.AttachedDisk{{Source: d, Mode: mode}}, MachineType: mt}}
+			i.daisyName = i.Name
+			i.RealName = i.Name
+			i.link = fmt.Sprintf("projects/%s/zones/%s/instances/%s", w.Project, w.Zone, i.Name)
+			i.Project = w.Project
+			i.Zone = w.Zone
+			is = append(is, i)
+		}
+		s.CreateInstances = &CreateInstances{Instances: is}
+
+		err := s.validate(ctx)
+		if tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+	}
+}
+
+func TestValidateDiskInterface(t *testing.T) {
+	tests := []struct {
+		desc         string
+		iface        string
+		machineTypes []string
+		shouldErr    bool
+	}{
+		{"unset case", "", []string{"zones/z/machineTypes/c3-standard-4"}, false},
+		{"nvme case", "NVME", []string{"zones/z/machineTypes/n2-standard-4"}, false},
+		{"scsi case", "SCSI", []string{"zones/z/machineTypes/n2-standard-4"}, false},
+		{"nvme only machine type case", "NVME", []string{"zones/z/machineTypes/c3-standard-4"}, false},
+		{"no machine type case", "SCSI", nil, false},
+		{"bad interface case", "nvme", nil, true},
+		{"scsi on nvme only machine type case", "SCSI", []string{"zones/z/machineTypes/c3-standard-4"}, true},
+		{"scsi on nvme only fallback machine type case", "SCSI", []string{"zones/z/machineTypes/n2-standard-4", "zones/z/machineTypes/t2a-standard-4"}, true},
+	}
+
+	for _, tt := range tests {
+		err := validateDiskInterface("pre", tt.iface, tt.machineTypes)
+		if tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+	}
+}
+
+func TestInstancePopulateReservationAffinity(t *testing.T) {
+	tests := []struct {
+		desc    string
+		ra      *compute.ReservationAffinity
+		wantKey string
+	}{
+		{"specific reservation case", &compute.ReservationAffinity{ConsumeReservationType: "SPECIFIC_RESERVATION", Values: []string{"res1"}}, reservationNameKey},
+		{"specific reservation custom key case", &compute.ReservationAffinity{ConsumeReservationType: "SPECIFIC_RESERVATION", Key: "team", Values: []string{"builds"}}, "team"},
+		{"any reservation case", &compute.ReservationAffinity{ConsumeReservationType: "ANY_RESERVATION"}, ""},
+	}
+
+	for _, tt := range tests {
+		ib := &InstanceBeta{Instance: computeBeta.Instance{ReservationAffinity: &computeBeta.ReservationAffinity{ConsumeReservationType: tt.ra.ConsumeReservationType, Key: tt.ra.Key, Values: tt.ra.Values}}}
+		ib.populateReservationAffinity()
+		if got := ib.ReservationAffinity.Key; got != tt.wantKey {
+			t.Errorf("%s: got beta Key %q, want %q", tt.desc, got, tt.wantKey)
+		}
+
+		i := &Instance{Instance: compute.Instance{ReservationAffinity: tt.ra}}
+		i.populateReservationAffinity()
+		if got := i.ReservationAffinity.Key; got != tt.wantKey {
+			t.Errorf("%s: got Key %q, want %q", tt.desc, got, tt.wantKey)
+		}
+	}
+}
+
 func TestInstanceValidateDisks(t *testing.T) {
 	// Test:
 	// - good case
@@ -490,6 +1016,7 @@ func TestInstanceValidateDisks(t *testing.T) {
 	w := testWorkflow()
 	w.disks.m = map[string]*Resource{
 		testDisk: {link: fmt.Sprintf("projects/%s/zones/%s/disks/%s", w.Project, w.Zone, testDisk)},
+		"d2":     {link: fmt.Sprintf("projects/%s/zones/%s/disks/d2", w.Project, w.Zone)},
 	}
 	m := defaultDiskMode
 
@@ -505,6 +1032,15 @@ func TestInstanceValidateDisks(t *testing.T) {
 		{desc: "error project mismatch case", i: &Instance{Instance: compute.Instance{Disks: []*compute.AttachedDisk{{Source: fmt.Sprintf("projects/foo/zones/%s/disks/%s", w.Zone, testDisk), Mode: m}}}}, shouldErr: true},
 		{desc: "error no disks case", i: &Instance{Instance: compute.Instance{}}, shouldErr: true},
 		{desc: "error disk mode case", i: &Instance{Instance: compute.Instance{Disks: []*compute.AttachedDisk{{Source: testDisk, Mode: "bad mode!"}}, Zone: testZone}}, shouldErr: true},
+		{desc: "success device name case", i: &Instance{Instance: compute.Instance{Disks: []*compute.AttachedDisk{{Source: testDisk, Mode: m, DeviceName: "data"}}, Zone: testZone}}, shouldErr: false},
+		{desc: "error bad device name case", i: &Instance{Instance: compute.Instance{Disks: []*compute.AttachedDisk{{Source: testDisk, Mode: m, DeviceName: "bad!"}}, Zone: testZone}}, shouldErr: true},
+		{desc: "error duplicate device name case", i: &Instance{Instance: compute.Instance{Disks: []*compute.AttachedDisk{{Source: testDisk, Mode: m, DeviceName: "data"}, {Source: "d2", Mode: m, DeviceName: "data"}}, Zone: testZone}}, shouldErr: true},
+		{desc: "success boot disk guest os features case", i: &Instance{Instance: compute.Instance{Disks: []*compute.AttachedDisk{{Source: testDisk, Mode: m, Boot: true, GuestOsFeatures: []*compute.GuestOsFeature{{Type: "UEFI_COMPATIBLE"}}}}, Zone: testZone}}, shouldErr: false},
+		{desc: "success beta boot disk guest os features case", iBeta: &InstanceBeta{Instance: computeBeta.Instance{Disks: []*computeBeta.AttachedDisk{{Source: testDisk, Mode: m, Boot: true, GuestOsFeatures: []*computeBeta.GuestOsFeature{{Type: "SECURE_BOOT"}}}}, Zone: testZone}}, shouldErr: false},
+		{desc: "error bad guest os feature case", i: &Instance{Instance: compute.Instance{Disks: []*compute.AttachedDisk{{Source: testDisk, Mode: m, Boot: true, GuestOsFeatures: []*compute.GuestOsFeature{{Type: "BAD_FEATURE"}}}}, Zone: testZone}}, shouldErr: true},
+		{desc: "error non-boot disk guest os features case", i: &Instance{Instance: compute.Instance{Disks: []*compute.AttachedDisk{{Source: testDisk, Mode: m, Boot: true}, {Source: "d2", Mode: m, GuestOsFeatures: []*compute.GuestOsFeature{{Type: "UEFI_COMPATIBLE"}}}}, Zone: testZone}}, shouldErr: true},
+		{desc: "error read-only boot disk case", i: &Instance{Instance: compute.Instance{Disks: []*compute.AttachedDisk{{Source: testDisk, Mode: diskModeRO, Boot: true}}, Zone: testZone}}, shouldErr: true},
+		{desc: "success read-only data disk case", i: &Instance{Instance: compute.Instance{Disks: []*compute.AttachedDisk{{Source: testDisk, Mode: m, Boot: true}, {Source: "d2", Mode: diskModeRO}}, Zone: testZone}}, shouldErr: false},
 		{desc: "error both disks and source machine image provided", iBeta: &InstanceBeta{Instance: computeBeta.Instance{Disks: []*computeBeta.AttachedDisk{{Source: testDisk}}, Zone: testZone, SourceMachineImage: "source-machine-image"}}, shouldErr: true},
 	}
 
@@ -535,16 +1071,107 @@ func TestInstanceValidateDisks(t *testing.T) {
 	}
 }
 
+func TestInstanceValidateBootDisk(t *testing.T) {
+	w := testWorkflow()
+	create, _ := w.NewStep("create-disks")
+	create.CreateDisks = &CreateDisks{
+		{Resource: Resource{daisyName: "blank"}},
+		{Resource: Resource{daisyName: "from-image"}, Disk: compute.Disk{SourceImage: "image"}},
+		{Resource: Resource{daisyName: "written"}},
+	}
+	w.disks.m = map[string]*Resource{}
+	for _, d := range *create.CreateDisks {
+		d.creator = create
+		d.link = fmt.Sprintf("projects/%s/zones/%s/disks/%s", testProject, testZone, d.daisyName)
+		w.disks.m[d.daisyName] = &d.Resource
+	}
+	w.disks.m["existing"] = &Resource{link: fmt.Sprintf("projects/%s/zones/%s/disks/existing", testProject, testZone)}
+	w.disks.attachments["written"] = map[string]*diskAttachment{"worker": {mode: diskModeRW, attacher: create}}
+	diskType := fmt.Sprintf("projects/%s/zones/%s/diskTypes/pd-standard", testProject, testZone)
+
+	tests := []struct {
+		desc      string
+		disk      *compute.AttachedDisk
+		wantBlank bool
+	}{
+		{"blank disk case", &compute.AttachedDisk{Source: "blank"}, true},
+		{"disk from image case", &compute.AttachedDisk{Source: "from-image"}, false},
+		{"disk written by worker case", &compute.AttachedDisk{Source: "written"}, false},
+		{"existing disk case", &compute.AttachedDisk{Source: "existing"}, false},
+		{"blank InitializeParams case", &compute.AttachedDisk{InitializeParams: &compute.AttachedDiskInitializeParams{DiskName: "boot", DiskType: diskType}}, true},
+		{"snapshot InitializeParams case", &compute.AttachedDisk{InitializeParams: &compute.AttachedDiskInitializeParams{DiskName: "boot", DiskType: diskType, SourceSnapshot: "global/snapshots/snap"}}, false},
+	}
+	for _, tt := range tests {
+		s, _ := w.NewStep(tt.desc)
+		w.AddDependency(s, create)
+		tt.disk.Boot, tt.disk.Mode = true, diskModeRW
+		i := &Instance{InstanceBase: InstanceBase{Resource: Resource{daisyName: "i", Project: testProject}}, Instance: compute.Instance{Name: "i", Zone: testZone, Disks: []*compute.AttachedDisk{tt.disk}}}
+		err := i.validateDisks(i, s)
+		if gotBlank := err != nil && strings.Contains(err.Error(), "no bootable disk found"); gotBlank != tt.wantBlank {
+			t.Errorf("%s: got blank boot disk error %t, want %t: %v", tt.desc, gotBlank, tt.wantBlank, err)
+		}
+	}
+}
+
+func TestInstanceValidateReadOnlyDisk(t *testing.T) {
+	w := testWorkflow()
+	create, _ := w.NewStep("create-disks")
+	create.CreateDisks = &CreateDisks{
+		{Resource: Resource{daisyName: "blank"}},
+		{Resource: Resource{daisyName: "from-image"}, Disk: compute.Disk{SourceImage: "image"}},
+		{Resource: Resource{daisyName: "written"}},
+	}
+	w.disks.m = map[string]*Resource{}
+	for _, d := range *create.CreateDisks {
+		d.creator = create
+		d.link = fmt.Sprintf("projects/%s/zones/%s/disks/%s", testProject, testZone, d.daisyName)
+		w.disks.m[d.daisyName] = &d.Resource
+	}
+	w.disks.m["existing"] = &Resource{link: fmt.Sprintf("projects/%s/zones/%s/disks/existing", testProject, testZone)}
+	w.disks.attachments["written"] = map[string]*diskAttachment{"worker": {mode: diskModeRW, attacher: create}}
+	diskType := fmt.Sprintf("projects/%s/zones/%s/diskTypes/pd-standard", testProject, testZone)
+
+	tests := []struct {
+		desc      string
+		disk      *compute.AttachedDisk
+		shouldErr bool
+	}{
+		{"blank disk case", &compute.AttachedDisk{Source: "blank"}, true},
+		{"disk from image case", &compute.AttachedDisk{Source: "from-image"}, false},
+		{"disk written by worker case", &compute.AttachedDisk{Source: "written"}, false},
+		{"blank InitializeParams case", &compute.AttachedDisk{InitializeParams: &compute.AttachedDiskInitializeParams{DiskName: "data", DiskType: diskType}}, true},
+		{"snapshot InitializeParams case", &compute.AttachedDisk{InitializeParams: &compute.AttachedDiskInitializeParams{DiskName: "data", DiskType: diskType, SourceSnapshot: "global/snapshots/snap"}}, false},
+	}
+	for _, tt := range tests {
+		s, _ := w.NewStep(tt.desc)
+		w.AddDependency(s, create)
+		tt.disk.Mode = diskModeRO
+		boot := &compute.AttachedDisk{Source: "existing", Mode: diskModeRW, Boot: true}
+		i := &Instance{InstanceBase: InstanceBase{Resource: Resource{daisyName: "i", Project: testProject}}, Instance: compute.Instance{Name: "i", Zone: testZone, Disks: []*compute.AttachedDisk{boot, tt.disk}}}
+		err := i.validateDisks(i, s)
+		if gotErr := err != nil && strings.Contains(err.Error(), "can't be attached READ_ONLY"); gotErr != tt.shouldErr {
+			t.Errorf("%s: got read-only disk error %t, want %t: %v", tt.desc, gotErr, tt.shouldErr, err)
+		}
+	}
+}
+
 func TestInstanceValidateDiskSource(t *testing.T) {
 	// Test:
 	// - good case
 	// - disk dne
 	// - disk has wrong project/zone
+	// - regional disk replicated/not replicated to the instance zone
 	w := testWorkflow()
 	w.disks.m = map[string]*Resource{"d": {link: fmt.Sprintf("projects/%s/zones/%s/disks/d", testProject, testZone)}}
 	m := defaultDiskMode
 	p := testProject
 	z := testZone
+	w.ComputeClient.(*daisyCompute.TestClient).GetRegionDiskFn = func(project, region, name string) (*compute.Disk, error) {
+		if name == "other-zones" {
+			return &compute.Disk{Name: name, ReplicaZones: []string{"zones/z1", "zones/z2"}}, nil
+		}
+		return &compute.Disk{Name: name, ReplicaZones: []string{"zones/z1", fmt.Sprintf("projects/%s/zones/%s", p, z)}}, nil
+	}
 
 	tests := []struct {
 		desc      string
@@ -556,6 +1183,9 @@ func TestInstanceValidateDiskSource(t *testing.T) {
 		{"disk dne case", []*compute.AttachedDisk{{Source: "dne", Mode: m}}, []*computeBeta.AttachedDisk{{Source: "dne", Mode: m}}, true},
 		{"bad project case", []*compute.AttachedDisk{{Source: fmt.Sprintf("projects/bad/zones/%s/disks/d", z), Mode: m}}, []*computeBeta.AttachedDisk{{Source: fmt.Sprintf("projects/bad/zones/%s/disks/d", z), Mode: m}}, true},
 		{"bad zone case", []*compute.AttachedDisk{{Source: fmt.Sprintf("projects/%s/zones/bad/disks/d", p), Mode: m}}, []*computeBeta.AttachedDisk{{Source: fmt.Sprintf("projects/%s/zones/bad/disks/d", p), Mode: m}}, true},
+		{"regional disk case", []*compute.AttachedDisk{{Source: fmt.Sprintf("projects/%s/regions/r/disks/rd", p), Mode: m}}, []*computeBeta.AttachedDisk{{Source: fmt.Sprintf("projects/%s/regions/r/disks/rd", p), Mode: m}}, false},
+		{"regional disk bad zone case", []*compute.AttachedDisk{{Source: fmt.Sprintf("projects/%s/regions/r/disks/other-zones", p), Mode: m}}, []*computeBeta.AttachedDisk{{Source: fmt.Sprintf("projects/%s/regions/r/disks/other-zones", p), Mode: m}}, true},
+		{"regional disk bad project case", []*compute.AttachedDisk{{Source: "projects/bad/regions/r/disks/rd", Mode: m}}, []*computeBeta.AttachedDisk{{Source: "projects/bad/regions/r/disks/rd", Mode: m}}, true},
 	}
 
 	for _, tt := range tests {
@@ -578,6 +1208,65 @@ func TestInstanceValidateDiskSource(t *testing.T) {
 	}
 }
 
+func TestInstanceBootDiskSizeGb(t *testing.T) {
+	w := testWorkflow()
+	imageCreator, _ := w.NewStep("imageCreator")
+	w.images.m = map[string]*Resource{
+		"existing": {link: fmt.Sprintf("projects/%s/global/images/existing", testProject)},
+		"family":   {link: fmt.Sprintf("projects/%s/global/images/family/fam", testProject)},
+		"created":  {link: fmt.Sprintf("projects/%s/global/images/created", testProject), creator: imageCreator},
+	}
+	w.ComputeClient.(*daisyCompute.TestClient).GetImageFn = func(_, name string) (*compute.Image, error) {
+		return &compute.Image{Name: name, DiskSizeGb: 10}, nil
+	}
+	w.ComputeClient.(*daisyCompute.TestClient).GetImageFromFamilyFn = func(_, family string) (*compute.Image, error) {
+		return &compute.Image{Name: family, DiskSizeGb: 20}, nil
+	}
+
+	tests := []struct {
+		desc           string
+		bootDiskSizeGb int64
+		p              *compute.AttachedDiskInitializeParams
+		wantSizeGb     int64
+		shouldErr      bool
+	}{
+		{"unset case", 0, &compute.AttachedDiskInitializeParams{SourceImage: "existing"}, 0, false},
+		{"larger case", 50, &compute.AttachedDiskInitializeParams{SourceImage: "existing"}, 50, false},
+		{"same size case", 10, &compute.AttachedDiskInitializeParams{SourceImage: "existing"}, 10, false},
+		{"smaller case", 5, &compute.AttachedDiskInitializeParams{SourceImage: "existing"}, 5, true},
+		{"smaller than family case", 15, &compute.AttachedDiskInitializeParams{SourceImage: "family"}, 15, true},
+		{"image created in workflow case", 5, &compute.AttachedDiskInitializeParams{SourceImage: "created"}, 5, false},
+		{"DiskSizeGb also set case", 50, &compute.AttachedDiskInitializeParams{SourceImage: "existing", DiskSizeGb: 60}, 60, true},
+	}
+
+	for i, tt := range tests {
+		s, _ := w.NewStep(tt.desc)
+		w.AddDependency(s, imageCreator)
+		ci := &Instance{
+			Instance:     compute.Instance{Name: fmt.Sprintf("inst-%d", i), Disks: []*compute.AttachedDisk{{InitializeParams: tt.p}}, Zone: testZone},
+			InstanceBase: InstanceBase{Resource: Resource{Project: testProject}, BootDiskSizeGb: tt.bootDiskSizeGb},
+		}
+		ci.Disks[0].InitializeParams.DiskType = fmt.Sprintf("projects/%s/zones/%s/diskTypes/pd-ssd", testProject, testZone)
+		s.CreateInstances = &CreateInstances{Instances: []*Instance{ci}}
+		err := ci.populateDisks(w)
+		if got := ci.Disks[0].InitializeParams.DiskSizeGb; got != tt.wantSizeGb {
+			t.Errorf("%s: got DiskSizeGb %d, want %d", tt.desc, got, tt.wantSizeGb)
+		}
+		err = addErrs(err, (&ci.InstanceBase).validateDiskInitializeParams(ci.getComputeDisks()[0], ci, s))
+		if tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error but didn't", tt.desc)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+	}
+
+	// BootDiskSizeGb needs a boot disk created from InitializeParams.
+	ciBeta := &InstanceBeta{Instance: computeBeta.Instance{Name: "inst", Disks: []*computeBeta.AttachedDisk{{Source: "d"}}}, InstanceBase: InstanceBase{BootDiskSizeGb: 50}}
+	if err := ciBeta.populateDisks(w); err == nil {
+		t.Error("beta boot disk without InitializeParams: should have returned an error but didn't")
+	}
+}
+
 func TestInstanceValidateDiskInitializeParams(t *testing.T) {
 	// Test:
 	// - good case
@@ -602,6 +1291,7 @@ func TestInstanceValidateDiskInitializeParams(t *testing.T) {
 		{"bad source case", &compute.AttachedDiskInitializeParams{DiskName: "bar", SourceImage: "i2", DiskType: dt}, &computeBeta.AttachedDiskInitializeParams{DiskName: "bar-beta", SourceImage: "i2", DiskType: dt}, true},
 		{"bad disk type case", &compute.AttachedDiskInitializeParams{DiskName: "bar", SourceImage: "i2", DiskType: fmt.Sprintf("projects/bad/zones/%s/diskTypes/pd-ssd", testZone)}, &computeBeta.AttachedDiskInitializeParams{DiskName: "bar-beta", SourceImage: "i2", DiskType: fmt.Sprintf("projects/bad/zones/%s/diskTypes/pd-ssd", testZone)}, true},
 		{"bad disk type case 2", &compute.AttachedDiskInitializeParams{DiskName: "bar", SourceImage: "i2", DiskType: fmt.Sprintf("projects/%s/zones/bad/diskTypes/pd-ssd", testProject)}, &computeBeta.AttachedDiskInitializeParams{DiskName: "bar-beta", SourceImage: "i2", DiskType: fmt.Sprintf("projects/%s/zones/bad/diskTypes/pd-ssd", testProject)}, true},
+		{"blank disk case", &compute.AttachedDiskInitializeParams{DiskName: "blank", DiskSizeGb: 10, DiskType: dt}, &computeBeta.AttachedDiskInitializeParams{DiskName: "blank-beta", DiskSizeGb: 10, DiskType: dt}, false},
 	}
 
 	assertTest := func(shouldErr bool, err DError, desc string) {
@@ -626,6 +1316,12 @@ func TestInstanceValidateDiskInitializeParams(t *testing.T) {
 		assertTest(tt.shouldErr, (&ciBeta.InstanceBase).validateDiskInitializeParams(ciBeta.getComputeDisks()[0], ciBeta, sBeta), tt.desc+" beta")
 	}
 
+	// A blank boot disk can't be used.
+	s, _ := w.NewStep("blank boot disk case")
+	ci := &Instance{Instance: compute.Instance{Disks: []*compute.AttachedDisk{{Boot: true, InitializeParams: &compute.AttachedDiskInitializeParams{DiskName: "blank-boot", DiskType: dt}}}, Zone: testZone}, InstanceBase: InstanceBase{Resource: Resource{Project: testProject}}}
+	s.CreateInstances = &CreateInstances{Instances: []*Instance{ci}}
+	assertTest(true, (&ci.InstanceBase).validateDiskInitializeParams(ci.getComputeDisks()[0], ci, s), "blank boot disk case")
+
 	// Check good disks were created.
 	wantCreator := w.Steps["good case"]
 	wantLink := fmt.Sprintf("projects/%s/zones/%s/disks/foo", testProject, testZone)
@@ -688,6 +1384,50 @@ func TestInstanceValidateMachineType(t *testing.T) {
 
 		ciBeta := &InstanceBeta{Instance: computeBeta.Instance{MachineType: tt.mt, Zone: testZone}, InstanceBase: InstanceBase{Resource: Resource{Project: testProject}}}
 		assertTest(tt.shouldErr, (&ciBeta.InstanceBase).validateMachineType(ciBeta, w), tt.desc+" beta")
+
+		good := fmt.Sprintf("projects/%s/zones/%s/machineTypes/%s", testProject, testZone, testMachineType)
+		ciFallback := &Instance{Instance: compute.Instance{MachineType: good, Zone: testZone}, InstanceBase: InstanceBase{Resource: Resource{Project: testProject}, MachineTypeFallbacks: []string{good, tt.mt}}}
+		assertTest(tt.shouldErr, (&ciFallback.InstanceBase).validateMachineType(ciFallback, w), tt.desc+" fallback")
+	}
+}
+
+func TestInstanceValidateArchitecture(t *testing.T) {
+	w := testWorkflow()
+	w.images.setArchitecture("arm-image", imageArchitectureARM64)
+	w.images.setArchitecture("x86-image", imageArchitectureX86)
+	mt := func(name string) string {
+		return fmt.Sprintf("projects/%s/zones/%s/machineTypes/%s", testProject, testZone, name)
+	}
+
+	tests := []struct {
+		desc      string
+		image     string
+		mt        string
+		fallbacks []string
+		shouldErr bool
+	}{
+		{"arm case", "arm-image", mt("t2a-standard-1"), nil, false},
+		{"arm c4a case", "arm-image", mt("c4a-standard-4"), nil, false},
+		{"x86 case", "x86-image", mt("n1-standard-1"), nil, false},
+		{"no architecture case", "other-image", mt("t2a-standard-1"), nil, false},
+		{"arm image on x86 machine type case", "arm-image", mt("n1-standard-1"), nil, true},
+		{"x86 image on arm machine type case", "x86-image", mt("t2a-standard-1"), nil, true},
+		{"arm image with x86 fallback case", "arm-image", mt("t2a-standard-1"), []string{mt("n2-standard-2")}, true},
+	}
+	for _, tt := range tests {
+		s, _ := w.NewStep(tt.desc)
+		i := &Instance{
+			InstanceBase: InstanceBase{MachineTypeFallbacks: tt.fallbacks},
+			Instance: compute.Instance{MachineType: tt.mt, Disks: []*compute.AttachedDisk{
+				{Boot: true, InitializeParams: &compute.AttachedDiskInitializeParams{SourceImage: tt.image}},
+			}},
+		}
+		err := (&i.InstanceBase).validateArchitecture(i, s)
+		if tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
 	}
 }
 
@@ -695,8 +1435,16 @@ func TestInstanceValidateNetworks(t *testing.T) {
 	w := testWorkflow()
 	acs := []*compute.AccessConfig{{Type: "ONE_TO_ONE_NAT"}}
 	acsBeta := []*computeBeta.AccessConfig{{Type: "ONE_TO_ONE_NAT"}}
-	w.networks.m = map[string]*Resource{testNetwork: {link: fmt.Sprintf("projects/%s/global/networks/%s", testProject, testNetwork)}}
+	w.networks.m = map[string]*Resource{
+		testNetwork:     {link: fmt.Sprintf("projects/%s/global/networks/%s", testProject, testNetwork)},
+		"other-network": {link: fmt.Sprintf("projects/%s/global/networks/other-network", testProject)},
+	}
 	w.subnetworks.m = map[string]*Resource{testSubnetwork: {link: fmt.Sprintf("projects/%s/global/subnetworks/%s", testProject, testSubnetwork)}}
+	createSubnet, _ := w.NewStep("create-subnet")
+	podsSubnet := &Subnetwork{Subnetwork: compute.Subnetwork{Network: testNetwork, SecondaryIpRanges: []*compute.SubnetworkSecondaryRange{{RangeName: "pods", IpCidrRange: "10.4.0.0/14"}}}, Resource: Resource{daisyName: "pods-subnet", creator: createSubnet}}
+	createSubnet.CreateSubnetworks = &CreateSubnetworks{podsSubnet}
+	w.subnetworks.m["pods-subnet"] = &podsSubnet.Resource
+	w.subnetworks.m["regional-subnet"] = &Resource{link: fmt.Sprintf("projects/%s/regions/%s/subnetworks/regional-subnet", testProject, testRegion)}
 
 	r := Resource{Project: testProject}
 	tests := []struct {
@@ -711,6 +1459,12 @@ func TestInstanceValidateNetworks(t *testing.T) {
 			&InstanceBeta{InstanceBase: InstanceBase{Resource: r}, Instance: computeBeta.Instance{NetworkInterfaces: []*computeBeta.NetworkInterface{{Network: testNetwork, AccessConfigs: acsBeta}}}},
 			false,
 		},
+		{
+			"no network interfaces case",
+			&Instance{InstanceBase: InstanceBase{Resource: r}, Instance: compute.Instance{NetworkInterfaces: []*compute.NetworkInterface{}}},
+			&InstanceBeta{InstanceBase: InstanceBase{Resource: r}, Instance: computeBeta.Instance{NetworkInterfaces: []*computeBeta.NetworkInterface{}}},
+			true,
+		},
 		{
 			"good case only subnetwork",
 			&Instance{InstanceBase: InstanceBase{Resource: r}, Instance: compute.Instance{NetworkInterfaces: []*compute.NetworkInterface{{Subnetwork: testSubnetwork, AccessConfigs: acs}}}},
@@ -723,6 +1477,114 @@ func TestInstanceValidateNetworks(t *testing.T) {
 			&InstanceBeta{InstanceBase: InstanceBase{Resource: r}, Instance: computeBeta.Instance{NetworkInterfaces: []*computeBeta.NetworkInterface{{Network: fmt.Sprintf("projects/%s/global/networks/%s", testProject, testNetwork), AccessConfigs: acsBeta}}}},
 			false,
 		},
+		{
+			"good case subnetwork in the instance's region",
+			&Instance{InstanceBase: InstanceBase{Resource: r}, Instance: compute.Instance{Zone: testZone, NetworkInterfaces: []*compute.NetworkInterface{{Subnetwork: "regional-subnet", AccessConfigs: []*compute.AccessConfig{}}}}},
+			&InstanceBeta{InstanceBase: InstanceBase{Resource: r}, Instance: computeBeta.Instance{Zone: testZone, NetworkInterfaces: []*computeBeta.NetworkInterface{{Subnetwork: "regional-subnet", AccessConfigs: []*computeBeta.AccessConfig{}}}}},
+			false,
+		},
+		{
+			"bad subnetwork in another region",
+			&Instance{InstanceBase: InstanceBase{Resource: r}, Instance: compute.Instance{Zone: "other-region-a", NetworkInterfaces: []*compute.NetworkInterface{{Subnetwork: "regional-subnet"}}}},
+			&InstanceBeta{InstanceBase: InstanceBase{Resource: r}, Instance: computeBeta.Instance{Zone: "other-region-a", NetworkInterfaces: []*computeBeta.NetworkInterface{{Subnetwork: "regional-subnet"}}}},
+			true,
+		},
+		{
+			"good case subnetwork in the interface's network",
+			&Instance{InstanceBase: InstanceBase{Resource: r}, Instance: compute.Instance{NetworkInterfaces: []*compute.NetworkInterface{{Network: fmt.Sprintf("projects/%s/global/networks/%s", testProject, testNetwork), Subnetwork: "pods-subnet"}}}},
+			&InstanceBeta{InstanceBase: InstanceBase{Resource: r}, Instance: computeBeta.Instance{NetworkInterfaces: []*computeBeta.NetworkInterface{{Network: fmt.Sprintf("projects/%s/global/networks/%s", testProject, testNetwork), Subnetwork: "pods-subnet"}}}},
+			false,
+		},
+		{
+			"bad subnetwork in another network",
+			&Instance{InstanceBase: InstanceBase{Resource: r}, Instance: compute.Instance{NetworkInterfaces: []*compute.NetworkInterface{{Network: "other-network", Subnetwork: "pods-subnet"}}}},
+			&InstanceBeta{InstanceBase: InstanceBase{Resource: r}, Instance: computeBeta.Instance{NetworkInterfaces: []*computeBeta.NetworkInterface{{Network: "other-network", Subnetwork: "pods-subnet"}}}},
+			true,
+		},
+		{
+			"good case alias IP ranges",
+			&Instance{InstanceBase: InstanceBase{Resource: r}, Instance: compute.Instance{NetworkInterfaces: []*compute.NetworkInterface{{Subnetwork: testSubnetwork, AliasIpRanges: []*compute.AliasIpRange{{IpCidrRange: "10.0.0.0/24"}, {IpCidrRange: "/24"}, {IpCidrRange: "10.1.2.3"}}}}}},
+			&InstanceBeta{InstanceBase: InstanceBase{Resource: r}, Instance: computeBeta.Instance{NetworkInterfaces: []*computeBeta.NetworkInterface{{Subnetwork: testSubnetwork, AliasIpRanges: []*computeBeta.AliasIpRange{{IpCidrRange: "10.0.0.0/24"}, {IpCidrRange: "/24"}, {IpCidrRange: "10.1.2.3"}}}}}},
+			false,
+		},
+		{
+			"good case alias IP secondary range",
+			&Instance{InstanceBase: InstanceBase{Resource: r}, Instance: compute.Instance{NetworkInterfaces: []*compute.NetworkInterface{{Subnetwork: "pods-subnet", AliasIpRanges: []*compute.AliasIpRange{{IpCidrRange: "/24", SubnetworkRangeName: "pods"}}}}}},
+			&InstanceBeta{InstanceBase: InstanceBase{Resource: r}, Instance: computeBeta.Instance{NetworkInterfaces: []*computeBeta.NetworkInterface{{Subnetwork: "pods-subnet", AliasIpRanges: []*computeBeta.AliasIpRange{{IpCidrRange: "/24", SubnetworkRangeName: "pods"}}}}}},
+			false,
+		},
+		{
+			"good case alias IP secondary range of existing subnetwork",
+			&Instance{InstanceBase: InstanceBase{Resource: r}, Instance: compute.Instance{NetworkInterfaces: []*compute.NetworkInterface{{Subnetwork: testSubnetwork, AliasIpRanges: []*compute.AliasIpRange{{IpCidrRange: "/24", SubnetworkRangeName: "pods"}}}}}},
+			&InstanceBeta{InstanceBase: InstanceBase{Resource: r}, Instance: computeBeta.Instance{NetworkInterfaces: []*computeBeta.NetworkInterface{{Subnetwork: testSubnetwork, AliasIpRanges: []*computeBeta.AliasIpRange{{IpCidrRange: "/24", SubnetworkRangeName: "pods"}}}}}},
+			false,
+		},
+		{
+			"bad alias IP range",
+			&Instance{InstanceBase: InstanceBase{Resource: r}, Instance: compute.Instance{NetworkInterfaces: []*compute.NetworkInterface{{Subnetwork: testSubnetwork, AliasIpRanges: []*compute.AliasIpRange{{IpCidrRange: "10.0.0.0/33"}}}}}},
+			&InstanceBeta{InstanceBase: InstanceBase{Resource: r}, Instance: computeBeta.Instance{NetworkInterfaces: []*computeBeta.NetworkInterface{{Subnetwork: testSubnetwork, AliasIpRanges: []*computeBeta.AliasIpRange{{IpCidrRange: "10.0.0.0/33"}}}}}},
+			true,
+		},
+		{
+			"bad alias IP netmask",
+			&Instance{InstanceBase: InstanceBase{Resource: r}, Instance: compute.Instance{NetworkInterfaces: []*compute.NetworkInterface{{Subnetwork: testSubnetwork, AliasIpRanges: []*compute.AliasIpRange{{IpCidrRange: "/33"}}}}}},
+			&InstanceBeta{InstanceBase: InstanceBase{Resource: r}, Instance: computeBeta.Instance{NetworkInterfaces: []*computeBeta.NetworkInterface{{Subnetwork: testSubnetwork, AliasIpRanges: []*computeBeta.AliasIpRange{{IpCidrRange: "/33"}}}}}},
+			true,
+		},
+		{
+			"bad alias IP secondary range",
+			&Instance{InstanceBase: InstanceBase{Resource: r}, Instance: compute.Instance{NetworkInterfaces: []*compute.NetworkInterface{{Subnetwork: "pods-subnet", AliasIpRanges: []*compute.AliasIpRange{{IpCidrRange: "/24", SubnetworkRangeName: "services"}}}}}},
+			&InstanceBeta{InstanceBase: InstanceBase{Resource: r}, Instance: computeBeta.Instance{NetworkInterfaces: []*computeBeta.NetworkInterface{{Subnetwork: "pods-subnet", AliasIpRanges: []*computeBeta.AliasIpRange{{IpCidrRange: "/24", SubnetworkRangeName: "services"}}}}}},
+			true,
+		},
+		{
+			"good case dual-stack",
+			&Instance{InstanceBase: InstanceBase{Resource: r, NetworkInterfaceStacks: []daisyCompute.NetworkInterfaceStack{{StackType: "IPV4_IPV6", Ipv6AccessType: "EXTERNAL"}}}, Instance: compute.Instance{NetworkInterfaces: []*compute.NetworkInterface{{Subnetwork: testSubnetwork}}}},
+			&InstanceBeta{InstanceBase: InstanceBase{Resource: r, NetworkInterfaceStacks: []daisyCompute.NetworkInterfaceStack{{StackType: "IPV4_IPV6", Ipv6AccessType: "EXTERNAL"}}}, Instance: computeBeta.Instance{NetworkInterfaces: []*computeBeta.NetworkInterface{{Subnetwork: testSubnetwork}}}},
+			false,
+		},
+		{
+			"good case IPv4 only",
+			&Instance{InstanceBase: InstanceBase{Resource: r, NetworkInterfaceStacks: []daisyCompute.NetworkInterfaceStack{{StackType: "IPV4_ONLY"}}}, Instance: compute.Instance{NetworkInterfaces: []*compute.NetworkInterface{{Subnetwork: testSubnetwork}}}},
+			&InstanceBeta{InstanceBase: InstanceBase{Resource: r, NetworkInterfaceStacks: []daisyCompute.NetworkInterfaceStack{{StackType: "IPV4_ONLY"}}}, Instance: computeBeta.Instance{NetworkInterfaces: []*computeBeta.NetworkInterface{{Subnetwork: testSubnetwork}}}},
+			false,
+		},
+		{
+			"bad stack type",
+			&Instance{InstanceBase: InstanceBase{Resource: r, NetworkInterfaceStacks: []daisyCompute.NetworkInterfaceStack{{StackType: "IPV6_ONLY"}}}, Instance: compute.Instance{NetworkInterfaces: []*compute.NetworkInterface{{Subnetwork: testSubnetwork}}}},
+			&InstanceBeta{InstanceBase: InstanceBase{Resource: r, NetworkInterfaceStacks: []daisyCompute.NetworkInterfaceStack{{StackType: "IPV6_ONLY"}}}, Instance: computeBeta.Instance{NetworkInterfaces: []*computeBeta.NetworkInterface{{Subnetwork: testSubnetwork}}}},
+			true,
+		},
+		{
+			"bad IPv6 access type",
+			&Instance{InstanceBase: InstanceBase{Resource: r, NetworkInterfaceStacks: []daisyCompute.NetworkInterfaceStack{{StackType: "IPV4_IPV6", Ipv6AccessType: "PUBLIC"}}}, Instance: compute.Instance{NetworkInterfaces: []*compute.NetworkInterface{{Subnetwork: testSubnetwork}}}},
+			&InstanceBeta{InstanceBase: InstanceBase{Resource: r, NetworkInterfaceStacks: []daisyCompute.NetworkInterfaceStack{{StackType: "IPV4_IPV6", Ipv6AccessType: "PUBLIC"}}}, Instance: computeBeta.Instance{NetworkInterfaces: []*computeBeta.NetworkInterface{{Subnetwork: testSubnetwork}}}},
+			true,
+		},
+		{
+			"bad IPv6 access type without dual-stack",
+			&Instance{InstanceBase: InstanceBase{Resource: r, NetworkInterfaceStacks: []daisyCompute.NetworkInterfaceStack{{Ipv6AccessType: "INTERNAL"}}}, Instance: compute.Instance{NetworkInterfaces: []*compute.NetworkInterface{{Subnetwork: testSubnetwork}}}},
+			&InstanceBeta{InstanceBase: InstanceBase{Resource: r, NetworkInterfaceStacks: []daisyCompute.NetworkInterfaceStack{{Ipv6AccessType: "INTERNAL"}}}, Instance: computeBeta.Instance{NetworkInterfaces: []*computeBeta.NetworkInterface{{Subnetwork: testSubnetwork}}}},
+			true,
+		},
+		{
+			"bad dual-stack without subnetwork",
+			&Instance{InstanceBase: InstanceBase{Resource: r, NetworkInterfaceStacks: []daisyCompute.NetworkInterfaceStack{{StackType: "IPV4_IPV6", Ipv6AccessType: "INTERNAL"}}}, Instance: compute.Instance{NetworkInterfaces: []*compute.NetworkInterface{{Subnetwork: ""}}}},
+			&InstanceBeta{InstanceBase: InstanceBase{Resource: r, NetworkInterfaceStacks: []daisyCompute.NetworkInterfaceStack{{StackType: "IPV4_IPV6", Ipv6AccessType: "INTERNAL"}}}, Instance: computeBeta.Instance{NetworkInterfaces: []*computeBeta.NetworkInterface{{Subnetwork: ""}}}},
+			true,
+		},
+		{
+			"bad dual-stack on created subnetwork",
+			&Instance{InstanceBase: InstanceBase{Resource: r, NetworkInterfaceStacks: []daisyCompute.NetworkInterfaceStack{{StackType: "IPV4_IPV6", Ipv6AccessType: "INTERNAL"}}}, Instance: compute.Instance{NetworkInterfaces: []*compute.NetworkInterface{{Subnetwork: "pods-subnet"}}}},
+			&InstanceBeta{InstanceBase: InstanceBase{Resource: r, NetworkInterfaceStacks: []daisyCompute.NetworkInterfaceStack{{StackType: "IPV4_IPV6", Ipv6AccessType: "INTERNAL"}}}, Instance: computeBeta.Instance{NetworkInterfaces: []*computeBeta.NetworkInterface{{Subnetwork: "pods-subnet"}}}},
+			true,
+		},
+		{
+			"bad more stacks than network interfaces",
+			&Instance{InstanceBase: InstanceBase{Resource: r, NetworkInterfaceStacks: []daisyCompute.NetworkInterfaceStack{{StackType: "IPV4_ONLY"}, {StackType: "IPV4_ONLY"}}}, Instance: compute.Instance{NetworkInterfaces: []*compute.NetworkInterface{{Subnetwork: testSubnetwork}}}},
+			&InstanceBeta{InstanceBase: InstanceBase{Resource: r, NetworkInterfaceStacks: []daisyCompute.NetworkInterfaceStack{{StackType: "IPV4_ONLY"}, {StackType: "IPV4_ONLY"}}}, Instance: computeBeta.Instance{NetworkInterfaces: []*computeBeta.NetworkInterface{{Subnetwork: testSubnetwork}}}},
+			true,
+		},
 		{
 			"bad name case",
 			&Instance{InstanceBase: InstanceBase{Resource: r}, Instance: compute.Instance{NetworkInterfaces: []*compute.NetworkInterface{{Network: fmt.Sprintf("projects/%s/global/networks/bad!", testProject), AccessConfigs: acs}}}},
@@ -747,7 +1609,75 @@ func TestInstanceValidateNetworks(t *testing.T) {
 	for _, tt := range tests {
 		s, _ := w.NewStep(tt.desc)
 		s.CreateInstances = &CreateInstances{Instances: []*Instance{tt.ci}, InstancesBeta: []*InstanceBeta{tt.ciBeta}}
+		w.AddDependency(s, createSubnet)
 		assertTest(tt.shouldErr, tt.ci.validateNetworks(s), tt.desc)
 		assertTest(tt.shouldErr, tt.ciBeta.validateNetworks(s), tt.desc+" beta")
 	}
 }
+
+func TestInstanceCreateNetworkInterfaceStacks(t *testing.T) {
+	var gotOpts, gotBetaOpts daisyCompute.InstanceOptions
+	c := &daisyCompute.TestClient{
+		CreateInstanceWithOptionsFn: func(_, _ string, _ *compute.Instance, opts daisyCompute.InstanceOptions) error {
+			gotOpts = opts
+			return nil
+		},
+		CreateInstanceBetaWithOptionsFn: func(_, _ string, _ *computeBeta.Instance, opts daisyCompute.InstanceOptions) error {
+			gotBetaOpts = opts
+			return nil
+		},
+	}
+
+	stacks := []daisyCompute.NetworkInterfaceStack{{StackType: "IPV4_IPV6", Ipv6AccessType: "INTERNAL"}}
+	want := daisyCompute.InstanceOptions{TerminationAction: "DELETE", NetworkInterfaceStacks: stacks}
+	if err := (&Instance{InstanceBase: InstanceBase{InstanceTerminationAction: "DELETE", NetworkInterfaceStacks: stacks}}).create(c); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if diffRes := diff(gotOpts, want, 0); diffRes != "" {
+		t.Errorf("instance options do not match expectation: (-got +want)\n%s", diffRes)
+	}
+	if err := (&InstanceBeta{InstanceBase: InstanceBase{InstanceTerminationAction: "DELETE", NetworkInterfaceStacks: stacks}}).create(c); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if diffRes := diff(gotBetaOpts, want, 0); diffRes != "" {
+		t.Errorf("beta instance options do not match expectation: (-got +want)\n%s", diffRes)
+	}
+}
+
+func TestInstanceCreateTerminationAction(t *testing.T) {
+	var gotAction, gotBetaAction string
+	var usedCreate, usedCreateBeta bool
+	c := &daisyCompute.TestClient{
+		CreateInstanceFn: func(_, _ string, _ *compute.Instance) error {
+			usedCreate = true
+			return nil
+		},
+		CreateInstanceWithTerminationActionFn: func(_, _ string, _ *compute.Instance, action string) error {
+			gotAction = action
+			return nil
+		},
+		CreateInstanceBetaFn: func(_, _ string, _ *computeBeta.Instance) error {
+			usedCreateBeta = true
+			return nil
+		},
+		CreateInstanceBetaWithTerminationActionFn: func(_, _ string, _ *computeBeta.Instance, action string) error {
+			gotBetaAction = action
+			return nil
+		},
+	}
+
+	if err := (&Instance{}).create(c); err != nil || !usedCreate || gotAction != "" {
+		t.Errorf("instance without InstanceTerminationAction: err = %v, used CreateInstance = %t, action = %q", err, usedCreate, gotAction)
+	}
+	usedCreate = false
+	if err := (&Instance{InstanceBase: InstanceBase{InstanceTerminationAction: "DELETE"}}).create(c); err != nil || usedCreate || gotAction != "DELETE" {
+		t.Errorf("instance with InstanceTerminationAction: err = %v, used CreateInstance = %t, action = %q", err, usedCreate, gotAction)
+	}
+	if err := (&InstanceBeta{}).create(c); err != nil || !usedCreateBeta || gotBetaAction != "" {
+		t.Errorf("beta instance without InstanceTerminationAction: err = %v, used CreateInstanceBeta = %t, action = %q", err, usedCreateBeta, gotBetaAction)
+	}
+	usedCreateBeta = false
+	if err := (&InstanceBeta{InstanceBase: InstanceBase{InstanceTerminationAction: "STOP"}}).create(c); err != nil || usedCreateBeta || gotBetaAction != "STOP" {
+		t.Errorf("beta instance with InstanceTerminationAction: err = %v, used CreateInstanceBeta = %t, action = %q", err, usedCreateBeta, gotBetaAction)
+	}
+}
@@ -28,3 +28,25 @@ func (w *Workflow) licenseExists(project, license string) (bool, DError) {
 		return w.ComputeClient.ListLicenses(project)
 	}, project, license)
 }
+
+// validateLicenses checks that licenses are partial URLs of licenses that
+// exist. Lookups that are forbidden, e.g. for licenses in projects the
+// workflow can't list, are skipped if ignoreForbidden is set. pre prefixes
+// the errors.
+func (w *Workflow) validateLicenses(licenses []string, ignoreForbidden bool, pre string) (errs DError) {
+	for _, l := range licenses {
+		result := NamedSubexp(licenseURLRegex, l)
+		if result == nil || result["project"] == "" {
+			errs = addErrs(errs, Errf("%s: bad license %q, must be a projects/<project>/global/licenses/<license> partial URL", pre, l))
+			continue
+		}
+		if exists, err := w.licenseExists(result["project"], result["license"]); err != nil {
+			if !(isGoogleAPIForbiddenError(err) && ignoreForbidden) {
+				errs = addErrs(errs, Errf("%s: bad license lookup: %q, error: %v", pre, l, err))
+			}
+		} else if !exists {
+			errs = addErrs(errs, Errf("%s: license does not exist: %q", pre, l))
+		}
+	}
+	return errs
+}
@@ -57,6 +57,9 @@ func (w *Workflow) createLogger(ctx context.Context) {
 
 	if !w.gcsLoggingDisabled {
 		gcsLogger := NewGCSLogger(ctx, w.StorageClient, w.bucket, path.Join(w.logsPath, "daisy.log"))
+		gcsLogger.storageClass = w.LogsStorageClass
+		gcsLogger.metadata = w.LogsObjectMetadata
+		gcsLogger.setACL = w.setObjectACL
 		l.gcsLogWriter = &syncedWriter{buf: bufio.NewWriter(gcsLogger)}
 		periodicFlush(func() { l.gcsLogWriter.Flush() })
 	}
@@ -187,6 +190,14 @@ func (l *daisyLog) Flush() {
 	}
 }
 
+// stopGCSLogging stops writing the workflow log to GCS, e.g. before the
+// object holding it is deleted.
+func (l *daisyLog) stopGCSLogging() {
+	if l.gcsLogWriter != nil {
+		l.gcsLogWriter.close()
+	}
+}
+
 // LogEntry encapsulates a single log entry.
 type LogEntry struct {
 	LocalTimestamp time.Time `json:"localTimestamp"`
@@ -213,19 +224,37 @@ func (l *daisyLog) WriteLogEntry(e *LogEntry) {
 }
 
 type syncedWriter struct {
-	buf *bufio.Writer
-	mx  sync.Mutex
+	buf    *bufio.Writer
+	mx     sync.Mutex
+	closed bool
 }
 
 func (l *syncedWriter) Write(b []byte) (int, error) {
 	l.mx.Lock()
 	defer l.mx.Unlock()
+	if l.closed {
+		return len(b), nil
+	}
 	return l.buf.Write(b)
 }
 
 func (l *syncedWriter) Flush() error {
 	l.mx.Lock()
 	defer l.mx.Unlock()
+	if l.closed {
+		return nil
+	}
+	return l.buf.Flush()
+}
+
+// close flushes the writer, later writes are dropped.
+func (l *syncedWriter) close() error {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
 	return l.buf.Flush()
 }
 
@@ -233,6 +262,9 @@ func (l *syncedWriter) Flush() error {
 type GCSLogger struct {
 	client         *storage.Client
 	bucket, object string
+	storageClass   string
+	metadata       map[string]string
+	setACL         func(*storage.ObjectAttrs)
 	buf            *bytes.Buffer
 	ctx            context.Context
 }
@@ -249,6 +281,11 @@ func (l *GCSLogger) Write(b []byte) (int, error) {
 	l.buf.Write(b)
 	wc := l.client.Bucket(l.bucket).Object(l.object).NewWriter(l.ctx)
 	wc.ContentType = "text/plain"
+	wc.StorageClass = l.storageClass
+	wc.Metadata = l.metadata
+	if l.setACL != nil {
+		l.setACL(&wc.ObjectAttrs)
+	}
 	if _, err := wc.Write(l.buf.Bytes()); err != nil {
 		return 0, err
 	}
@@ -19,6 +19,7 @@ import (
 	"bytes"
 	"fmt"
 	"regexp"
+	"strings"
 	"sync"
 	"testing"
 
@@ -26,6 +27,8 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
+// MockLogger records what is logged. It's safe for concurrent use, as
+// instances' serial port logs are written from their own goroutines.
 type MockLogger struct {
 	entries        []*LogEntry
 	mx             sync.Mutex
@@ -33,11 +36,15 @@ type MockLogger struct {
 }
 
 func (l *MockLogger) WriteSerialPortLogs(w *Workflow, instance string, buf bytes.Buffer) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
 	l.serialPortLogs = append(l.serialPortLogs, buf.String())
 }
 
 func (l *MockLogger) ReadSerialPortLogs() []string {
-	return l.serialPortLogs
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	return append([]string(nil), l.serialPortLogs...)
 }
 
 func (l *MockLogger) WriteLogEntry(e *LogEntry) {
@@ -52,7 +59,7 @@ func (l *MockLogger) Flush() {}
 func (l *MockLogger) getEntries() []*LogEntry {
 	l.mx.Lock()
 	defer l.mx.Unlock()
-	return l.entries[:]
+	return append([]*LogEntry(nil), l.entries...)
 }
 
 func TestWriteWorkflowInfo(t *testing.T) {
@@ -77,6 +84,24 @@ func TestWriteWorkflowInfo(t *testing.T) {
 	}
 }
 
+func TestStopGCSLogging(t *testing.T) {
+	w := New()
+	w.Name = "Test"
+	w.Logger = newDaisyLogger(false)
+
+	var b bytes.Buffer
+	w.Logger.(*daisyLog).gcsLogWriter = &syncedWriter{buf: bufio.NewWriter(&b)}
+
+	w.LogWorkflowInfo("before")
+	w.Logger.(*daisyLog).stopGCSLogging()
+	w.LogWorkflowInfo("after")
+	w.Logger.(*daisyLog).gcsLogWriter.Flush()
+
+	if got := b.String(); !strings.Contains(got, "before") || strings.Contains(got, "after") {
+		t.Errorf("got log %q, want only the entries written before logging stopped", got)
+	}
+}
+
 func TestWriteStepInfo(t *testing.T) {
 	w := New()
 	w.Name = "Test"
@@ -0,0 +1,241 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	computeBeta "google.golang.org/api/compute/v0.beta"
+	"google.golang.org/api/compute/v1"
+)
+
+// limitedClient is a compute client that allows at most cap(sem) resource
+// create and delete calls at once. All other calls go straight to Client.
+type limitedClient struct {
+	daisyCompute.Client
+	sem chan struct{}
+}
+
+// limitOperations has the workflow's create and delete calls, including its
+// sub workflows', wait for one of MaxConcurrentOperations slots.
+func (w *Workflow) limitOperations() {
+	if w.MaxConcurrentOperations == 0 || w.ComputeClient == nil {
+		return
+	}
+	if _, ok := w.ComputeClient.(*limitedClient); ok {
+		return
+	}
+	w.ComputeClient = &limitedClient{Client: w.ComputeClient, sem: make(chan struct{}, w.MaxConcurrentOperations)}
+}
+
+// unwrap returns the client c limits.
+func (c *limitedClient) unwrap() daisyCompute.Client {
+	return c.Client
+}
+
+// unwrapComputeClient returns the client c wraps, if any, e.g. to set an
+// operation warnings handler on the client created by NewClient.
+func unwrapComputeClient(c daisyCompute.Client) daisyCompute.Client {
+	for {
+		wc, ok := c.(interface{ unwrap() daisyCompute.Client })
+		if !ok {
+			return c
+		}
+		c = wc.unwrap()
+	}
+}
+
+// acquire blocks until an operation slot is free and returns the function
+// releasing it.
+func (c *limitedClient) acquire() func() {
+	c.sem <- struct{}{}
+	return func() { <-c.sem }
+}
+
+// Resource create and delete calls hold an operation slot until they return.
+
+func (c *limitedClient) CreateDisk(project, zone string, d *compute.Disk) error {
+	defer c.acquire()()
+	return c.Client.CreateDisk(project, zone, d)
+}
+
+func (c *limitedClient) CreateDiskWithProvisionedPerformance(project, zone string, d *compute.Disk, iops, throughput int64) error {
+	defer c.acquire()()
+	return c.Client.CreateDiskWithProvisionedPerformance(project, zone, d, iops, throughput)
+}
+
+func (c *limitedClient) CreateDiskWithOptions(project, zone string, d *compute.Disk, opts daisyCompute.DiskOptions) error {
+	defer c.acquire()()
+	return c.Client.CreateDiskWithOptions(project, zone, d, opts)
+}
+
+func (c *limitedClient) CreateAddress(project, region string, a *compute.Address) error {
+	defer c.acquire()()
+	return c.Client.CreateAddress(project, region, a)
+}
+
+func (c *limitedClient) CreateAddressWithLabels(project, region string, a *compute.Address, labels map[string]string) error {
+	defer c.acquire()()
+	return c.Client.CreateAddressWithLabels(project, region, a, labels)
+}
+
+func (c *limitedClient) CreateForwardingRule(project, region string, fr *compute.ForwardingRule) error {
+	defer c.acquire()()
+	return c.Client.CreateForwardingRule(project, region, fr)
+}
+
+func (c *limitedClient) CreateForwardingRuleWithLabels(project, region string, fr *compute.ForwardingRule, labels map[string]string) error {
+	defer c.acquire()()
+	return c.Client.CreateForwardingRuleWithLabels(project, region, fr, labels)
+}
+
+func (c *limitedClient) CreateFirewallRule(project string, i *compute.Firewall) error {
+	defer c.acquire()()
+	return c.Client.CreateFirewallRule(project, i)
+}
+
+func (c *limitedClient) CreateImage(project string, i *compute.Image) error {
+	defer c.acquire()()
+	return c.Client.CreateImage(project, i)
+}
+
+func (c *limitedClient) CreateImageBeta(project string, i *computeBeta.Image) error {
+	defer c.acquire()()
+	return c.Client.CreateImageBeta(project, i)
+}
+
+func (c *limitedClient) CreateImageWithArchitecture(project string, i *compute.Image, architecture string) error {
+	defer c.acquire()()
+	return c.Client.CreateImageWithArchitecture(project, i, architecture)
+}
+
+func (c *limitedClient) CreateInstance(project, zone string, i *compute.Instance) error {
+	defer c.acquire()()
+	return c.Client.CreateInstance(project, zone, i)
+}
+
+func (c *limitedClient) CreateInstanceBeta(project, zone string, i *computeBeta.Instance) error {
+	defer c.acquire()()
+	return c.Client.CreateInstanceBeta(project, zone, i)
+}
+
+func (c *limitedClient) CreateInstanceWithTerminationAction(project, zone string, i *compute.Instance, action string) error {
+	defer c.acquire()()
+	return c.Client.CreateInstanceWithTerminationAction(project, zone, i, action)
+}
+
+func (c *limitedClient) CreateInstanceBetaWithTerminationAction(project, zone string, i *computeBeta.Instance, action string) error {
+	defer c.acquire()()
+	return c.Client.CreateInstanceBetaWithTerminationAction(project, zone, i, action)
+}
+
+func (c *limitedClient) CreateInstanceWithOptions(project, zone string, i *compute.Instance, opts daisyCompute.InstanceOptions) error {
+	defer c.acquire()()
+	return c.Client.CreateInstanceWithOptions(project, zone, i, opts)
+}
+
+func (c *limitedClient) CreateInstanceBetaWithOptions(project, zone string, i *computeBeta.Instance, opts daisyCompute.InstanceOptions) error {
+	defer c.acquire()()
+	return c.Client.CreateInstanceBetaWithOptions(project, zone, i, opts)
+}
+
+func (c *limitedClient) CreateInstanceGroup(project, zone string, ig *compute.InstanceGroup) error {
+	defer c.acquire()()
+	return c.Client.CreateInstanceGroup(project, zone, ig)
+}
+
+func (c *limitedClient) CreateNetwork(project string, n *compute.Network) error {
+	defer c.acquire()()
+	return c.Client.CreateNetwork(project, n)
+}
+
+func (c *limitedClient) CreateSnapshot(project, zone, disk string, sn *compute.Snapshot, guestFlush bool) error {
+	defer c.acquire()()
+	return c.Client.CreateSnapshot(project, zone, disk, sn, guestFlush)
+}
+
+func (c *limitedClient) CreateSubnetwork(project, region string, n *compute.Subnetwork) error {
+	defer c.acquire()()
+	return c.Client.CreateSubnetwork(project, region, n)
+}
+
+func (c *limitedClient) CreateTargetInstance(project, zone string, ti *compute.TargetInstance) error {
+	defer c.acquire()()
+	return c.Client.CreateTargetInstance(project, zone, ti)
+}
+
+func (c *limitedClient) DeleteDisk(project, zone, name string) error {
+	defer c.acquire()()
+	return c.Client.DeleteDisk(project, zone, name)
+}
+
+func (c *limitedClient) DeleteAddress(project, region, name string) error {
+	defer c.acquire()()
+	return c.Client.DeleteAddress(project, region, name)
+}
+
+func (c *limitedClient) DeleteForwardingRule(project, region, name string) error {
+	defer c.acquire()()
+	return c.Client.DeleteForwardingRule(project, region, name)
+}
+
+func (c *limitedClient) DeleteFirewallRule(project, name string) error {
+	defer c.acquire()()
+	return c.Client.DeleteFirewallRule(project, name)
+}
+
+func (c *limitedClient) DeleteImage(project, name string) error {
+	defer c.acquire()()
+	return c.Client.DeleteImage(project, name)
+}
+
+func (c *limitedClient) DeleteInstance(project, zone, name string) error {
+	defer c.acquire()()
+	return c.Client.DeleteInstance(project, zone, name)
+}
+
+func (c *limitedClient) DeleteInstanceGroup(project, zone, name string) error {
+	defer c.acquire()()
+	return c.Client.DeleteInstanceGroup(project, zone, name)
+}
+
+func (c *limitedClient) DeleteNetwork(project, name string) error {
+	defer c.acquire()()
+	return c.Client.DeleteNetwork(project, name)
+}
+
+func (c *limitedClient) DeleteSubnetwork(project, region, name string) error {
+	defer c.acquire()()
+	return c.Client.DeleteSubnetwork(project, region, name)
+}
+
+func (c *limitedClient) DeleteTargetInstance(project, zone, name string) error {
+	defer c.acquire()()
+	return c.Client.DeleteTargetInstance(project, zone, name)
+}
+
+func (c *limitedClient) DeleteSnapshot(project, name string) error {
+	defer c.acquire()()
+	return c.Client.DeleteSnapshot(project, name)
+}
+
+func (c *limitedClient) DeleteMachineImage(project, name string) error {
+	defer c.acquire()()
+	return c.Client.DeleteMachineImage(project, name)
+}
+
+func (c *limitedClient) CreateMachineImage(project string, i *computeBeta.MachineImage) error {
+	defer c.acquire()()
+	return c.Client.CreateMachineImage(project, i)
+}
@@ -0,0 +1,84 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/api/compute/v1"
+)
+
+func TestLimitOperations(t *testing.T) {
+	w := testWorkflow()
+	w.limitOperations()
+	if _, ok := w.ComputeClient.(*limitedClient); ok {
+		t.Error("client should not be limited when MaxConcurrentOperations is 0")
+	}
+
+	w.MaxConcurrentOperations = 2
+	w.limitOperations()
+	lc, ok := w.ComputeClient.(*limitedClient)
+	if !ok {
+		t.Fatal("client should be limited when MaxConcurrentOperations is set")
+	}
+	w.limitOperations()
+	if w.ComputeClient != lc {
+		t.Error("an already limited client should not be wrapped again")
+	}
+}
+
+func TestLimitedClient(t *testing.T) {
+	w := testWorkflow()
+	c, _ := newTestGCEClient()
+	var mx sync.Mutex
+	var running, maxRunning int
+	op := func() error {
+		mx.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mx.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mx.Lock()
+		running--
+		mx.Unlock()
+		return nil
+	}
+	c.CreateDiskFn = func(_, _ string, _ *compute.Disk) error { return op() }
+	c.DeleteImageFn = func(_, _ string) error { return op() }
+	w.ComputeClient = c
+	w.MaxConcurrentOperations = 2
+	w.limitOperations()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			w.ComputeClient.CreateDisk(testProject, testZone, &compute.Disk{})
+		}()
+		go func() {
+			defer wg.Done()
+			w.ComputeClient.DeleteImage(testProject, testImage)
+		}()
+	}
+	wg.Wait()
+	if maxRunning != 2 {
+		t.Errorf("want at most 2 operations at once across calls, got %d", maxRunning)
+	}
+}
@@ -0,0 +1,50 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import "google.golang.org/api/compute/v1"
+
+// OperationWarningRecord is a warning a Compute operation the workflow
+// waited for completed with, e.g. that a disk's size was rounded up.
+type OperationWarningRecord struct {
+	// OperationType is the kind of operation, e.g. "insert" or "delete".
+	OperationType string
+	// Target is the partial URL of the resource the operation acted on.
+	Target  string
+	Code    string
+	Message string
+}
+
+// recordOperationWarnings logs the warnings of the completed operation op
+// and records them on the root workflow for the run summary.
+func (w *Workflow) recordOperationWarnings(op *compute.Operation) {
+	root := w.rootWorkflow()
+	target := partialURL(op.TargetLink)
+	for _, warning := range op.Warnings {
+		root.LogWorkflowInfo("Operation %s on %q completed with warning %s: %s", op.OperationType, target, warning.Code, warning.Message)
+		root.operationWarningRecordsMx.Lock()
+		root.operationWarningRecords = append(root.operationWarningRecords, OperationWarningRecord{op.OperationType, target, warning.Code, warning.Message})
+		root.operationWarningRecordsMx.Unlock()
+	}
+}
+
+// GetOperationWarningRecords returns the warnings of the Compute operations
+// the workflow, including its included and sub workflows, waited for, in the
+// order the operations completed.
+func (w *Workflow) GetOperationWarningRecords() []OperationWarningRecord {
+	w.operationWarningRecordsMx.Lock()
+	defer w.operationWarningRecordsMx.Unlock()
+	return append([]OperationWarningRecord(nil), w.operationWarningRecords...)
+}
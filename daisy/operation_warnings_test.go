@@ -0,0 +1,84 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"google.golang.org/api/compute/v1"
+)
+
+func TestRecordOperationWarnings(t *testing.T) {
+	parent := testWorkflow()
+	w := testWorkflow()
+	w.parent = parent
+
+	link := fmt.Sprintf("projects/%s/zones/%s/disks/d", testProject, testZone)
+	w.recordOperationWarnings(&compute.Operation{
+		OperationType: "insert",
+		TargetLink:    "https://www.googleapis.com/compute/v1/" + link,
+		Warnings: []*compute.OperationWarnings{
+			{Code: "DISK_SIZE_LARGER_THAN_IMAGE_SIZE", Message: "size rounded up"},
+			{Code: "DEPRECATED_RESOURCE_USED", Message: "image deprecated"},
+		},
+	})
+
+	want := []OperationWarningRecord{
+		{"insert", link, "DISK_SIZE_LARGER_THAN_IMAGE_SIZE", "size rounded up"},
+		{"insert", link, "DEPRECATED_RESOURCE_USED", "image deprecated"},
+	}
+	if diffRes := diff(parent.GetOperationWarningRecords(), want, 0); diffRes != "" {
+		t.Errorf("operation warning records not as expected: (-got,+want)\n%s", diffRes)
+	}
+	if got := w.GetOperationWarningRecords(); got != nil {
+		t.Errorf("sub workflow got operation warning records %v, want none", got)
+	}
+}
+
+func TestRunRecordsOperationWarningsWithLimitedClient(t *testing.T) {
+	w := testWorkflow()
+	w.MaxConcurrentOperations = 1
+	link := fmt.Sprintf("projects/%s/zones/%s/disks/d", testProject, testZone)
+	_, c, err := daisyCompute.NewTestClient(func(rw http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(rw, `{"Name":"d","Status":"DONE","OperationType":"insert","TargetLink":"https://www.googleapis.com/compute/v1/%s","Warnings":[{"Code":"DISK_SIZE_LARGER_THAN_IMAGE_SIZE","Message":"size rounded up"}]}`, link)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc := w.ComputeClient.(*daisyCompute.TestClient)
+	c.GetProjectFn = tc.GetProjectFn
+	c.ListZonesFn = tc.ListZonesFn
+	w.ComputeClient = c
+	w.Steps = map[string]*Step{
+		"s": {name: "s", w: w, testType: &mockStep{runImpl: func(_ context.Context, s *Step) DError {
+			if _, ok := s.w.ComputeClient.(*limitedClient); !ok {
+				t.Error("client should be limited when MaxConcurrentOperations is set")
+			}
+			return newErr("failed to create disk", s.w.ComputeClient.CreateDisk(testProject, testZone, &compute.Disk{Name: "d"}))
+		}}},
+	}
+
+	if err := w.Run(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []OperationWarningRecord{{"insert", link, "DISK_SIZE_LARGER_THAN_IMAGE_SIZE", "size rounded up"}}
+	if diffRes := diff(w.GetOperationWarningRecords(), want, 0); diffRes != "" {
+		t.Errorf("operation warning records not as expected: (-got,+want)\n%s", diffRes)
+	}
+}
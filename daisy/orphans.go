@@ -0,0 +1,163 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+)
+
+// RunIDLabel is the label set on the addresses, disks, forwarding rules,
+// images, instances and snapshots a workflow creates. Its value is the RunID
+// of the top-level workflow, which sub and included workflows share, so
+// CleanupOrphans can find them if the workflow dies before it cleans up.
+// Firewall rules, instance groups, machine images, networks, subnetworks and
+// target instances can't be labeled.
+const RunIDLabel = "daisy-run-id"
+
+// RunID returns the value of RunIDLabel for resources created by w: the ID
+// of the top-level workflow and the time RunID was first called, as the ID
+// alone is too short to tell runs apart.
+func (w *Workflow) RunID() string {
+	root := w.rootWorkflow()
+	root.runIDOnce.Do(func() {
+		root.runIDValue = fmt.Sprintf("%s-%s", root.id, time.Now().UTC().Format("20060102-150405"))
+	})
+	return root.runIDValue
+}
+
+// withRunIDLabel returns labels with RunIDLabel set to id.
+func withRunIDLabel(labels map[string]string, id string) map[string]string {
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[RunIDLabel] = id
+	return labels
+}
+
+// CleanupOrphans deletes the forwarding rules, addresses, instances, disks,
+// images and snapshots in project that are labeled with RunIDLabel runID,
+// e.g. after the process running a workflow died before the workflow could
+// clean up. Forwarding rules are deleted first so the addresses they use can
+// be released, addresses are released before instances are deleted, and
+// instances are deleted before disks so their disks can be. It returns the
+// partial URLs of the deleted resources.
+//
+// Firewall rules, instance groups, machine images, networks, subnetworks and
+// target instances aren't labeled, so CleanupOrphans leaves them behind.
+//
+// Resources created with NoCleanup are labeled too; only use CleanupOrphans
+// for runs whose resources should all be removed.
+func CleanupOrphans(cc daisyCompute.Client, project, runID string) ([]string, DError) {
+	if runID == "" {
+		return nil, Errf("cannot clean up orphans: no run ID given")
+	}
+	filter := daisyCompute.Filter(fmt.Sprintf("labels.%s = %s", RunIDLabel, runID))
+	var mx sync.Mutex
+	var deleted []string
+	var errs DError
+	// deleteAll runs the deletes in dels, keyed by resource partial URL.
+	deleteAll := func(dels map[string]func() error) {
+		var wg sync.WaitGroup
+		for link, del := range dels {
+			wg.Add(1)
+			go func(link string, del func() error) {
+				defer wg.Done()
+				err := del()
+				mx.Lock()
+				defer mx.Unlock()
+				if err != nil {
+					errs = addErrs(errs, typedErrf(apiError, "failed to delete orphaned resource %q: %v", link, err))
+					return
+				}
+				deleted = append(deleted, link)
+			}(link, del)
+		}
+		wg.Wait()
+	}
+
+	forwardingRules, err := cc.AggregatedListForwardingRules(project, filter)
+	if err != nil {
+		return nil, typedErr(apiError, "failed to list orphaned forwarding rules", err)
+	}
+	dels := map[string]func() error{}
+	for _, fr := range forwardingRules {
+		region, name := path.Base(fr.Region), fr.Name
+		dels[fmt.Sprintf("projects/%s/regions/%s/forwardingRules/%s", project, region, name)] = func() error { return cc.DeleteForwardingRule(project, region, name) }
+	}
+	deleteAll(dels)
+
+	addresses, err := cc.AggregatedListAddresses(project, filter)
+	if err != nil {
+		return deleted, addErrs(errs, typedErr(apiError, "failed to list orphaned addresses", err))
+	}
+	dels = map[string]func() error{}
+	for _, a := range addresses {
+		region, name := path.Base(a.Region), a.Name
+		dels[fmt.Sprintf("projects/%s/regions/%s/addresses/%s", project, region, name)] = func() error { return cc.DeleteAddress(project, region, name) }
+	}
+	deleteAll(dels)
+
+	instances, err := cc.AggregatedListInstances(project, filter)
+	if err != nil {
+		return deleted, addErrs(errs, typedErr(apiError, "failed to list orphaned instances", err))
+	}
+	dels = map[string]func() error{}
+	for _, i := range instances {
+		zone, name := path.Base(i.Zone), i.Name
+		dels[fmt.Sprintf("projects/%s/zones/%s/instances/%s", project, zone, name)] = func() error { return cc.DeleteInstance(project, zone, name) }
+	}
+	deleteAll(dels)
+
+	disks, err := cc.AggregatedListDisks(project, filter)
+	if err != nil {
+		return deleted, addErrs(errs, typedErr(apiError, "failed to list orphaned disks", err))
+	}
+	dels = map[string]func() error{}
+	for _, d := range disks {
+		zone, name := path.Base(d.Zone), d.Name
+		dels[fmt.Sprintf("projects/%s/zones/%s/disks/%s", project, zone, name)] = func() error { return cc.DeleteDisk(project, zone, name) }
+	}
+	deleteAll(dels)
+
+	images, err := cc.ListImages(project, filter)
+	if err != nil {
+		return deleted, addErrs(errs, typedErr(apiError, "failed to list orphaned images", err))
+	}
+	dels = map[string]func() error{}
+	for _, i := range images {
+		name := i.Name
+		dels[fmt.Sprintf("projects/%s/global/images/%s", project, name)] = func() error { return cc.DeleteImage(project, name) }
+	}
+	deleteAll(dels)
+
+	snapshots, err := cc.ListSnapshots(project, filter)
+	if err != nil {
+		return deleted, addErrs(errs, typedErr(apiError, "failed to list orphaned snapshots", err))
+	}
+	dels = map[string]func() error{}
+	for _, sn := range snapshots {
+		name := sn.Name
+		dels[fmt.Sprintf("projects/%s/global/snapshots/%s", project, name)] = func() error { return cc.DeleteSnapshot(project, name) }
+	}
+	deleteAll(dels)
+	sort.Strings(deleted)
+	return deleted, errs
+}
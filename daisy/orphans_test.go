@@ -0,0 +1,137 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+	"testing"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"google.golang.org/api/compute/v1"
+)
+
+func TestRunIDLabel(t *testing.T) {
+	w := testWorkflow()
+	sw := w.NewSubWorkflow()
+	if got := sw.RunID(); got != w.RunID() {
+		t.Errorf("sub workflow run ID should be the parent's %q, got %q", w.RunID(), got)
+	}
+	if !regexp.MustCompile(`^abcdef-\d{8}-\d{6}$`).MatchString(w.RunID()) {
+		t.Errorf("run ID should be the workflow ID and a timestamp, got %q", w.RunID())
+	}
+
+	i := &Instance{Instance: compute.Instance{
+		Labels: map[string]string{"foo": "bar"},
+		Disks: []*compute.AttachedDisk{
+			{InitializeParams: &compute.AttachedDiskInitializeParams{DiskName: "boot"}},
+			{Source: "d"},
+			{Type: "SCRATCH", InitializeParams: &compute.AttachedDiskInitializeParams{}},
+		},
+	}}
+	i.setRunIDLabel("abc")
+	if diffRes := diff(i.Labels, map[string]string{"foo": "bar", RunIDLabel: "abc"}, 0); diffRes != "" {
+		t.Errorf("instance labels not as expected: (-got +want)\n%s", diffRes)
+	}
+	if diffRes := diff(i.Disks[0].InitializeParams.Labels, map[string]string{RunIDLabel: "abc"}, 0); diffRes != "" {
+		t.Errorf("boot disk labels not as expected: (-got +want)\n%s", diffRes)
+	}
+	if i.Disks[2].InitializeParams.Labels != nil {
+		t.Errorf("scratch disk should not be labeled, got %v", i.Disks[2].InitializeParams.Labels)
+	}
+}
+
+func TestCleanupOrphans(t *testing.T) {
+	_, c, _ := daisyCompute.NewTestClient(nil)
+	wantFilter := daisyCompute.Filter(fmt.Sprintf("labels.%s = abc", RunIDLabel))
+	checkFilter := func(opts []daisyCompute.ListCallOption) error {
+		if len(opts) != 1 || opts[0] != wantFilter {
+			return fmt.Errorf("bad list options: %v", opts)
+		}
+		return nil
+	}
+	var mx sync.Mutex
+	var forwardingRulesDeleted, addressesDeleted, instancesDeleted bool
+	c.AggregatedListForwardingRulesFn = func(_ string, opts ...daisyCompute.ListCallOption) ([]*compute.ForwardingRule, error) {
+		return []*compute.ForwardingRule{{Name: "fr1", Region: "https://www.googleapis.com/compute/v1/projects/p/regions/r1"}}, checkFilter(opts)
+	}
+	c.DeleteForwardingRuleFn = func(_, _, _ string) error {
+		mx.Lock()
+		defer mx.Unlock()
+		forwardingRulesDeleted = true
+		return nil
+	}
+	c.AggregatedListAddressesFn = func(_ string, opts ...daisyCompute.ListCallOption) ([]*compute.Address, error) {
+		return []*compute.Address{{Name: "a1", Region: "https://www.googleapis.com/compute/v1/projects/p/regions/r1"}}, checkFilter(opts)
+	}
+	c.DeleteAddressFn = func(_, _, _ string) error {
+		mx.Lock()
+		defer mx.Unlock()
+		if !forwardingRulesDeleted {
+			return errors.New("forwarding rules should be deleted before addresses are released")
+		}
+		addressesDeleted = true
+		return nil
+	}
+	c.AggregatedListInstancesFn = func(_ string, opts ...daisyCompute.ListCallOption) ([]*compute.Instance, error) {
+		return []*compute.Instance{{Name: "i1", Zone: "https://www.googleapis.com/compute/v1/projects/p/zones/z1"}}, checkFilter(opts)
+	}
+	c.AggregatedListDisksFn = func(_ string, opts ...daisyCompute.ListCallOption) ([]*compute.Disk, error) {
+		return []*compute.Disk{{Name: "d1", Zone: "z1"}, {Name: "bad", Zone: "z1"}}, checkFilter(opts)
+	}
+	c.ListImagesFn = func(_ string, opts ...daisyCompute.ListCallOption) ([]*compute.Image, error) {
+		return []*compute.Image{{Name: "im1"}}, checkFilter(opts)
+	}
+	c.DeleteInstanceFn = func(_, _, _ string) error {
+		mx.Lock()
+		defer mx.Unlock()
+		if !addressesDeleted {
+			return errors.New("addresses should be released before instances are deleted")
+		}
+		instancesDeleted = true
+		return nil
+	}
+	c.DeleteDiskFn = func(_, _, name string) error {
+		mx.Lock()
+		defer mx.Unlock()
+		if !instancesDeleted {
+			return errors.New("instances should be deleted before disks")
+		}
+		if name == "bad" {
+			return errors.New("fail")
+		}
+		return nil
+	}
+	c.DeleteImageFn = func(_, _ string) error { return nil }
+	c.ListSnapshotsFn = func(_ string, opts ...daisyCompute.ListCallOption) ([]*compute.Snapshot, error) {
+		return []*compute.Snapshot{{Name: "sn1"}}, checkFilter(opts)
+	}
+	c.DeleteSnapshotFn = func(_, _ string) error { return nil }
+
+	deleted, err := CleanupOrphans(c, "p", "abc")
+	want := []string{"projects/p/global/images/im1", "projects/p/global/snapshots/sn1", "projects/p/regions/r1/addresses/a1", "projects/p/regions/r1/forwardingRules/fr1", "projects/p/zones/z1/disks/d1", "projects/p/zones/z1/instances/i1"}
+	if diffRes := diff(deleted, want, 0); diffRes != "" {
+		t.Errorf("deleted resources not as expected: (-got +want)\n%s", diffRes)
+	}
+	if err == nil || len(err.errors()) != 1 {
+		t.Errorf("want one error for the failed disk delete, got %v", err)
+	}
+
+	if _, err := CleanupOrphans(c, "p", ""); err == nil {
+		t.Error("empty run ID: should have returned an error")
+	}
+}
@@ -0,0 +1,68 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"path"
+	"strings"
+)
+
+// Policy restricts the resources a workflow may create, e.g. to keep a
+// service running workflows on behalf of others within cost and capacity
+// limits. Violations fail workflow validation with a PolicyViolation error.
+//
+// Entries ending in "*" match by prefix, e.g. "e2-*" or "us-central1-*".
+type Policy struct {
+	// AllowedMachineTypes are the machine type names, e.g. "n1-standard-2",
+	// instances may use, including their MachineTypeFallbacks. Any machine
+	// type is allowed if empty.
+	AllowedMachineTypes []string
+	// AllowedZones are the zones instances may be created in. Any zone is
+	// allowed if empty.
+	AllowedZones []string
+}
+
+// policyAllows returns whether v matches one of allowed, or allowed is empty.
+func policyAllows(allowed []string, v string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, a := range allowed {
+		if a == v || (strings.HasSuffix(a, "*") && strings.HasPrefix(v, strings.TrimSuffix(a, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateInstance checks an instance's zone and machine types against the
+// policy. A nil Policy allows everything.
+func (p *Policy) validateInstance(name, zone string, machineTypes []string) (errs DError) {
+	if p == nil {
+		return nil
+	}
+	if !policyAllows(p.AllowedZones, zone) {
+		errs = addErrs(errs, typedErrf(policyViolationError, "cannot create instance %q: policy does not allow zone %q, allowed zones are %q", name, zone, p.AllowedZones))
+	}
+	for _, mt := range machineTypes {
+		if mt == "" {
+			continue
+		}
+		if mt = path.Base(mt); !policyAllows(p.AllowedMachineTypes, mt) {
+			errs = addErrs(errs, typedErrf(policyViolationError, "cannot create instance %q: policy does not allow MachineType %q, allowed machine types are %q", name, mt, p.AllowedMachineTypes))
+		}
+	}
+	return errs
+}
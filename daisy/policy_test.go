@@ -0,0 +1,134 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"google.golang.org/api/compute/v1"
+)
+
+func TestPolicyAllows(t *testing.T) {
+	tests := []struct {
+		desc    string
+		allowed []string
+		v       string
+		want    bool
+	}{
+		{"empty case", nil, "n1-standard-1", true},
+		{"exact match case", []string{"n1-standard-1"}, "n1-standard-1", true},
+		{"no match case", []string{"n1-standard-1"}, "n1-standard-2", false},
+		{"prefix match case", []string{"e2-*"}, "e2-medium", true},
+		{"prefix no match case", []string{"e2-*"}, "n2-standard-2", false},
+		{"no implicit prefix case", []string{"e2"}, "e2-medium", false},
+	}
+
+	for _, tt := range tests {
+		if got := policyAllows(tt.allowed, tt.v); got != tt.want {
+			t.Errorf("%s: policyAllows(%q, %q) = %t, want %t", tt.desc, tt.allowed, tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestPolicyValidateInstance(t *testing.T) {
+	mt := func(name string) string {
+		return fmt.Sprintf("projects/%s/zones/us-central1-a/machineTypes/%s", testProject, name)
+	}
+	p := &Policy{AllowedMachineTypes: []string{"e2-*", "n1-standard-1"}, AllowedZones: []string{"us-central1-*"}}
+
+	tests := []struct {
+		desc         string
+		p            *Policy
+		zone         string
+		machineTypes []string
+		wantErrs     int
+	}{
+		{"nil policy case", nil, "europe-west1-b", []string{mt("n2-highmem-96")}, 0},
+		{"allowed case", p, "us-central1-a", []string{mt("e2-medium"), mt("n1-standard-1")}, 0},
+		{"unset machine type case", p, "us-central1-a", []string{""}, 0},
+		{"zone violation case", p, "europe-west1-b", []string{mt("e2-medium")}, 1},
+		{"machine type violation case", p, "us-central1-a", []string{mt("n2-highmem-96")}, 1},
+		{"fallback violation case", p, "us-central1-a", []string{mt("e2-medium"), mt("n2-highmem-96")}, 1},
+		{"zone and machine type violation case", p, "europe-west1-b", []string{mt("n2-highmem-96")}, 2},
+	}
+
+	for _, tt := range tests {
+		err := tt.p.validateInstance("i", tt.zone, tt.machineTypes)
+		if tt.wantErrs == 0 {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", tt.desc, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s: expected an error", tt.desc)
+			continue
+		}
+		if got := len(err.errors()); got != tt.wantErrs {
+			t.Errorf("%s: want %d errors, got %d: %v", tt.desc, tt.wantErrs, got, err)
+		}
+		if !err.CausedByErrType(policyViolationError) {
+			t.Errorf("%s: error is not a %s: %v", tt.desc, policyViolationError, err)
+		}
+	}
+}
+
+func TestInstancesValidatePolicy(t *testing.T) {
+	ctx := context.Background()
+	parent := testWorkflow()
+	w := testWorkflow()
+	w.parent = parent
+	s, err := w.NewStep("s")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mt := fmt.Sprintf("projects/%s/zones/%s/machineTypes/%s", testProject, testZone, testMachineType)
+	ad := []*compute.AttachedDisk{{Source: fmt.Sprintf("projects/%s/zones/%s/disks/%s", w.Project, w.Zone, testDisk), Mode: defaultDiskMode}}
+
+	tests := []struct {
+		desc      string
+		policy    *Policy
+		shouldErr bool
+	}{
+		{"no policy case", nil, false},
+		{"allowed case", &Policy{AllowedMachineTypes: []string{testMachineType}, AllowedZones: []string{testZone}}, false},
+		{"disallowed machine type case", &Policy{AllowedMachineTypes: []string{"e2-*"}}, true},
+		{"disallowed zone case", &Policy{AllowedZones: []string{"us-central1-a"}}, true},
+	}
+
+	for n, tt := range tests {
+		// The policy is set by the program running the top level workflow.
+		parent.Policy = tt.policy
+		i := &Instance{Instance: compute.Instance{Name: fmt.Sprintf("i%d", n), Disks: ad, MachineType: mt}}
+		i.daisyName = i.Name
+		i.RealName = i.Name
+		i.link = fmt.Sprintf("projects/%s/zones/%s/instances/%s", w.Project, w.Zone, i.Name)
+		i.Project = w.Project
+		i.Zone = w.Zone
+		s.CreateInstances = &CreateInstances{Instances: []*Instance{i}}
+		// Each case attaches the same disk READ_WRITE, to a new instance.
+		w.disks.attachments = map[string]map[string]*diskAttachment{}
+
+		err := s.validate(ctx)
+		if tt.shouldErr && (err == nil || !err.CausedByErrType(policyViolationError)) {
+			t.Errorf("%s: should have returned a %s error, got: %v", tt.desc, policyViolationError, err)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"time"
+)
+
+// Statuses of a RunResult.
+const (
+	RunStatusSucceeded = "Succeeded"
+	RunStatusFailed    = "Failed"
+	// RunStatusCanceled is a run stopped by CancelWithReason or by closing
+	// Cancel.
+	RunStatusCanceled = "Canceled"
+)
+
+// RunResult summarizes a finished workflow run, once its cleanup is done.
+type RunResult struct {
+	// Name and ID of the workflow.
+	Name, ID string
+	// RunID is the value of RunIDLabel on the resources the run created.
+	RunID string
+	// Status is one of the RunStatus* constants.
+	Status string
+	// Error the run failed with, nil if it succeeded.
+	Error DError
+	// CancelReason is why the workflow's steps were canceled, nil if they
+	// weren't.
+	CancelReason *CancelReason
+	// Outputs are the serial-output values the run collected.
+	Outputs map[string]string
+	// Resources are the resources the run created, including those cleanup
+	// deleted.
+	Resources []ResourceNameRecord
+	// StepErrors are the errors of the ContinueOnError steps that failed.
+	StepErrors []StepErrorRecord
+}
+
+// PostCleanupHook is called once a workflow run and its cleanup have
+// finished, e.g. to notify another system of the result. ctx is done once
+// the hook's timeout has passed.
+type PostCleanupHook func(ctx context.Context, r RunResult) error
+
+type postCleanupHook struct {
+	fn      PostCleanupHook
+	timeout time.Duration
+}
+
+// AddPostCleanupHook registers a hook to call after Run has cleaned up,
+// waiting at most timeout for it. With AsyncCleanup set the hook is called
+// in the background, before WaitForCleanup returns. Errors from the hook are
+// logged. The hook isn't called if the workflow fails validation, as it
+// doesn't run then.
+func (w *Workflow) AddPostCleanupHook(timeout time.Duration, hook PostCleanupHook) {
+	w.postCleanupHooksMx.Lock()
+	defer w.postCleanupHooksMx.Unlock()
+	w.postCleanupHooks = append(w.postCleanupHooks, postCleanupHook{hook, timeout})
+}
+
+// runPostCleanupHooks calls the post-cleanup hooks, one at a time, with the
+// result of a run that returned runErr.
+func (w *Workflow) runPostCleanupHooks(runErr DError) {
+	w.postCleanupHooksMx.Lock()
+	hooks := append([]postCleanupHook(nil), w.postCleanupHooks...)
+	w.postCleanupHooksMx.Unlock()
+	if len(hooks) == 0 {
+		return
+	}
+	r := w.runResult(runErr)
+	for _, h := range hooks {
+		h := h
+		err := runHook(h.timeout, func(ctx context.Context) error { return h.fn(ctx, r) })
+		if err != nil {
+			w.LogWorkflowInfo("Error returned from post-cleanup hook: %v", err)
+		}
+	}
+}
+
+func (w *Workflow) runResult(runErr DError) RunResult {
+	r := RunResult{
+		Name:         w.Name,
+		ID:           w.id,
+		RunID:        w.RunID(),
+		Status:       RunStatusSucceeded,
+		Error:        runErr,
+		CancelReason: w.CancelReason(),
+		StepErrors:   w.GetStepErrorRecords(),
+	}
+	if runErr != nil {
+		r.Status = RunStatusFailed
+		if r.CancelReason != nil && r.CancelReason.Kind == CancelReasonUser {
+			r.Status = RunStatusCanceled
+		}
+	}
+	w.serialControlOutputValuesMx.Lock()
+	if len(w.serialControlOutputValues) > 0 {
+		r.Outputs = map[string]string{}
+		for k, v := range w.serialControlOutputValues {
+			r.Outputs[k] = v
+		}
+	}
+	w.serialControlOutputValuesMx.Unlock()
+	r.Resources = w.GetResourceNameRecords()
+	return r
+}
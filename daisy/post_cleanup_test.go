@@ -0,0 +1,108 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPostCleanupHooks(t *testing.T) {
+	tests := []struct {
+		desc       string
+		runImpl    func(context.Context, *Step) DError
+		wantStatus string
+	}{
+		{"success case", nil, RunStatusSucceeded},
+		{"failure case", func(context.Context, *Step) DError { return Errf("fail") }, RunStatusFailed},
+		{"canceled case", func(_ context.Context, s *Step) DError {
+			s.w.CancelWithReason("interrupted")
+			return nil
+		}, RunStatusCanceled},
+	}
+	for _, tt := range tests {
+		w := testWorkflow()
+		w.Steps = map[string]*Step{
+			"s0": {name: "s0", testType: &mockStep{runImpl: tt.runImpl}, w: w},
+		}
+		w.AddSerialConsoleOutputValue("k", "v")
+		var cleanedUp bool
+		w.addCleanupHook(func() DError {
+			cleanedUp = true
+			return nil
+		})
+		var got []RunResult
+		w.AddPostCleanupHook(time.Minute, func(_ context.Context, r RunResult) error {
+			if !cleanedUp {
+				t.Errorf("%s: post-cleanup hook called before cleanup", tt.desc)
+			}
+			got = append(got, r)
+			return errors.New("fail")
+		})
+		// A hook that doesn't return doesn't stop the others being called.
+		w.AddPostCleanupHook(time.Millisecond, func(ctx context.Context, _ RunResult) error {
+			<-ctx.Done()
+			return nil
+		})
+		w.AddPostCleanupHook(time.Minute, func(_ context.Context, r RunResult) error {
+			got = append(got, r)
+			return nil
+		})
+
+		err := w.Run(context.Background())
+		if len(got) != 2 {
+			t.Errorf("%s: want 2 post-cleanup hook calls, got %d", tt.desc, len(got))
+			continue
+		}
+		r := got[0]
+		if r.Status != tt.wantStatus {
+			t.Errorf("%s: got Status %q, want %q", tt.desc, r.Status, tt.wantStatus)
+		}
+		if (err == nil) != (r.Error == nil) || (err != nil && err.Error() != r.Error.Error()) {
+			t.Errorf("%s: got Error %v, want %v", tt.desc, r.Error, err)
+		}
+		if r.Name != w.Name || r.ID != w.ID() {
+			t.Errorf("%s: got Name %q and ID %q, want %q and %q", tt.desc, r.Name, r.ID, w.Name, w.ID())
+		}
+		if diffRes := diff(r.Outputs, map[string]string{"k": "v"}, 0); diffRes != "" {
+			t.Errorf("%s: Outputs not as expected: (-got,+want)\n%s", tt.desc, diffRes)
+		}
+	}
+}
+
+func TestPostCleanupHooksAsyncCleanup(t *testing.T) {
+	w := testWorkflow()
+	w.AsyncCleanup = true
+	w.Steps = map[string]*Step{
+		"s0": {name: "s0", testType: &mockStep{}, w: w},
+	}
+	called := make(chan struct{})
+	w.AddPostCleanupHook(time.Minute, func(context.Context, RunResult) error {
+		close(called)
+		return nil
+	})
+
+	if err := w.Run(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	w.WaitForCleanup()
+	select {
+	case <-called:
+	default:
+		t.Error("WaitForCleanup returned before the post-cleanup hook was called")
+	}
+}
@@ -0,0 +1,101 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"fmt"
+)
+
+// Actions counted by StepProgress.
+const (
+	ProgressInstancesCreated  = "instances created"
+	ProgressInstancesSignaled = "instances signaled"
+)
+
+// StepProgress is a rough estimate of how far a running step has got, e.g.
+// 3 of 10 instances created by a CreateInstances step.
+type StepProgress struct {
+	// Step is the step name, prefixed with the names of the included and sub
+	// workflows it is in as for step time records.
+	Step string
+	// Action is what is counted, one of the Progress* constants.
+	Action string
+	Done   int
+	Total  int
+}
+
+func (p StepProgress) String() string {
+	return fmt.Sprintf("%s: %d of %d %s", p.Step, p.Done, p.Total, p.Action)
+}
+
+// progress counts the units of work a step has done, reporting each update
+// to the root workflow.
+type progress struct {
+	w *Workflow
+	p *StepProgress
+}
+
+// startProgress starts counting the total units of action step s does.
+func (w *Workflow) startProgress(s *Step, action string, total int) *progress {
+	root := w.rootWorkflow()
+	p := &progress{w: root, p: &StepProgress{Step: w.qualifiedStepName(s.name), Action: action, Total: total}}
+	root.updateProgress(func() StepProgress {
+		root.stepProgress = append(root.stepProgress, p.p)
+		return *p.p
+	})
+	return p
+}
+
+// inc counts one more unit of work done.
+func (p *progress) inc() {
+	p.w.updateProgress(func() StepProgress {
+		p.p.Done++
+		return *p.p
+	})
+}
+
+// updateProgress applies the update f and reports its result to OnProgress.
+// Reports are made one at a time, in the order the updates are made.
+func (w *Workflow) updateProgress(f func() StepProgress) {
+	w.progressReportMx.Lock()
+	defer w.progressReportMx.Unlock()
+	w.stepProgressMx.Lock()
+	sp := f()
+	w.stepProgressMx.Unlock()
+	if w.OnProgress != nil {
+		w.OnProgress(sp)
+	}
+}
+
+// qualifiedStepName prefixes name with the names of the included and sub
+// workflows w is in.
+func (w *Workflow) qualifiedStepName(name string) string {
+	if w.parent == nil {
+		return name
+	}
+	return w.parent.qualifiedStepName(fmt.Sprintf("%s.%s", w.Name, name))
+}
+
+// GetStepProgress returns the progress of the steps that have started, in
+// the order they started.
+func (w *Workflow) GetStepProgress() []StepProgress {
+	w.stepProgressMx.Lock()
+	defer w.stepProgressMx.Unlock()
+	var ps []StepProgress
+	for _, p := range w.stepProgress {
+		ps = append(ps, *p)
+	}
+	return ps
+}
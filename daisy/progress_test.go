@@ -0,0 +1,57 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestStepProgress(t *testing.T) {
+	parent := testWorkflow()
+	var reported []int
+	parent.OnProgress = func(p StepProgress) {
+		reported = append(reported, p.Done)
+	}
+	w := testWorkflow()
+	w.parent = parent
+	w.Name = "sub"
+
+	p := w.startProgress(&Step{name: "s", w: w}, ProgressInstancesCreated, 3)
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.inc()
+		}()
+	}
+	wg.Wait()
+
+	want := []StepProgress{{Step: "sub.s", Action: ProgressInstancesCreated, Done: 3, Total: 3}}
+	if got := parent.GetStepProgress(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got progress %v, want %v", got, want)
+	}
+	if got := w.GetStepProgress(); got != nil {
+		t.Errorf("expected no progress in sub workflow, got %v", got)
+	}
+	if want := []int{0, 1, 2, 3}; !reflect.DeepEqual(reported, want) {
+		t.Errorf("got reported Done %v, want %v", reported, want)
+	}
+	if got, want := want[0].String(), "sub.s: 3 of 3 instances created"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,116 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"net/url"
+	"strings"
+)
+
+const (
+	httpProxyMetadataKey  = "daisy-http-proxy"
+	httpsProxyMetadataKey = "daisy-https-proxy"
+	noProxyMetadataKey    = "daisy-no-proxy"
+)
+
+// ProxyConfig is the HTTP(S) proxy for the guests of a workflow's instances
+// to use for network egress, e.g. in builds without direct internet access.
+// It's only set as metadata: the guest agent doesn't apply it, and fetches
+// the startup script without a proxy, so the startup script has to read and
+// export it itself.
+type ProxyConfig struct {
+	// HTTPProxy is the proxy URL for HTTP requests, e.g.
+	// "http://proxy.example.com:3128", set as the daisy-http-proxy metadata
+	// key.
+	HTTPProxy string `json:",omitempty"`
+	// HTTPSProxy is the proxy URL for HTTPS requests, set as the
+	// daisy-https-proxy metadata key.
+	HTTPSProxy string `json:",omitempty"`
+	// NoProxy are the hosts, domains and IP ranges to reach directly, e.g.
+	// "metadata.google.internal", set comma separated as the daisy-no-proxy
+	// metadata key.
+	NoProxy []string `json:",omitempty"`
+}
+
+// metadata returns the metadata keys and values set for p.
+func (p *ProxyConfig) metadata() map[string]string {
+	md := map[string]string{}
+	if p.HTTPProxy != "" {
+		md[httpProxyMetadataKey] = p.HTTPProxy
+	}
+	if p.HTTPSProxy != "" {
+		md[httpsProxyMetadataKey] = p.HTTPSProxy
+	}
+	if len(p.NoProxy) > 0 {
+		md[noProxyMetadataKey] = strings.Join(p.NoProxy, ",")
+	}
+	return md
+}
+
+func (p *ProxyConfig) validate() DError {
+	if p.HTTPProxy == "" && p.HTTPSProxy == "" {
+		return Errf("Proxy: at least one of HTTPProxy and HTTPSProxy must be set")
+	}
+	var errs DError
+	for _, f := range []struct{ name, v string }{{"HTTPProxy", p.HTTPProxy}, {"HTTPSProxy", p.HTTPSProxy}} {
+		if f.v == "" {
+			continue
+		}
+		if err := validateProxyURL(f.v); err != nil {
+			errs = addErrs(errs, Errf("Proxy: bad %s %q: %v", f.name, f.v, err))
+		}
+	}
+	for _, h := range p.NoProxy {
+		if h == "" || strings.ContainsAny(h, ", \t\n") {
+			errs = addErrs(errs, Errf("Proxy: bad NoProxy entry %q", h))
+		}
+	}
+	return errs
+}
+
+// validateProxyURL checks that u is an absolute http or https URL of a proxy
+// host, optionally with a port and credentials, and nothing else.
+func validateProxyURL(u string) DError {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return newErr("failed to parse proxy URL", err)
+	}
+	if pu.Scheme != "http" && pu.Scheme != "https" {
+		return Errf("scheme must be http or https, got %q", pu.Scheme)
+	}
+	if pu.Hostname() == "" {
+		return Errf("host must be set")
+	}
+	if (pu.Path != "" && pu.Path != "/") || pu.RawQuery != "" || pu.Fragment != "" {
+		return Errf("must not have a path, query or fragment")
+	}
+	return nil
+}
+
+// populateProxyMetadata sets the metadata keys of the workflow's Proxy in
+// the instance metadata md. They can't also be set in the instance's
+// metadata, so all of the workflow's instances use the same proxy.
+func (w *Workflow) populateProxyMetadata(md map[string]string) DError {
+	if w.Proxy == nil {
+		return nil
+	}
+	for k, v := range w.Proxy.metadata() {
+		if _, ok := md[k]; ok {
+			return Errf("Proxy is set but metadata %q is also set", k)
+		}
+		md[k] = v
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"path"
+	"sort"
+	"sync"
+
+	"google.golang.org/api/compute/v1"
+)
+
+const (
+	quotaCPUs           = "CPUS"
+	quotaDisksTotalGb   = "DISKS_TOTAL_GB"
+	quotaSSDTotalGb     = "SSD_TOTAL_GB"
+	quotaInUseAddresses = "IN_USE_ADDRESSES"
+)
+
+// diskTypeQuotas maps disk types to the regional quota metric they count against.
+var diskTypeQuotas = map[string]string{
+	"pd-standard": quotaDisksTotalGb,
+	"pd-balanced": quotaSSDTotalGb,
+	"pd-ssd":      quotaSSDTotalGb,
+}
+
+// quotaRequest holds the amount of each regional quota metric requested,
+// keyed by project and region.
+type quotaRequest map[string]map[string]map[string]float64
+
+func (q quotaRequest) add(project, region, metric string, amount float64) {
+	if amount <= 0 {
+		return
+	}
+	if _, ok := q[project]; !ok {
+		q[project] = map[string]map[string]float64{}
+	}
+	if _, ok := q[project][region]; !ok {
+		q[project][region] = map[string]float64{}
+	}
+	q[project][region][metric] += amount
+}
+
+// cpuCache holds the vCPUs of the machine types looked up by machineTypeCPUs,
+// keyed by project, zone and machine type.
+type cpuCache struct {
+	cpus map[string]int64
+	mu   sync.Mutex
+}
+
+// machineTypeCPUs returns the number of vCPUs of a machine type, using the
+// machine type cache populated during validation when possible. Machine
+// types it has to get are cached, the lookup isn't made holding a lock.
+func (w *Workflow) machineTypeCPUs(project, zone, machineType string) (int64, DError) {
+	w.machineTypeCache.mu.Lock()
+	mt, ok := w.machineTypeCache.exists[project][zone][machineType].(*compute.MachineType)
+	w.machineTypeCache.mu.Unlock()
+	if ok && mt != nil {
+		return mt.GuestCpus, nil
+	}
+	key := path.Join(project, zone, machineType)
+	w.cpuCache.mu.Lock()
+	cpus, ok := w.cpuCache.cpus[key]
+	w.cpuCache.mu.Unlock()
+	if ok {
+		return cpus, nil
+	}
+
+	mt, err := w.ComputeClient.GetMachineType(project, zone, machineType)
+	if err != nil {
+		return 0, typedErr(apiError, "failed to get machine type", err)
+	}
+	if mt != nil {
+		cpus = mt.GuestCpus
+	}
+	w.cpuCache.mu.Lock()
+	if w.cpuCache.cpus == nil {
+		w.cpuCache.cpus = map[string]int64{}
+	}
+	w.cpuCache.cpus[key] = cpus
+	w.cpuCache.mu.Unlock()
+	return cpus, nil
+}
+
+// check compares the requested amounts against the available regional quota,
+// returning an error for every metric that would be exceeded. Regions whose
+// quota can't be read, e.g. without compute.regions.get permission, are
+// skipped with a warning, the preflight doesn't stop workflows that could
+// run.
+func (q quotaRequest) check(w *Workflow) DError {
+	var errs DError
+	for project, regions := range q {
+		for region, metrics := range regions {
+			r, err := w.ComputeClient.GetRegion(project, region)
+			if err != nil {
+				w.LogWorkflowInfo("Warning: skipping quota preflight for project %q region %q, failed to get region: %v", project, region, err)
+				continue
+			}
+			available := map[string]float64{}
+			for _, quota := range r.Quotas {
+				available[quota.Metric] = quota.Limit - quota.Usage
+			}
+			var names []string
+			for m := range metrics {
+				names = append(names, m)
+			}
+			sort.Strings(names)
+			for _, m := range names {
+				have, ok := available[m]
+				if !ok {
+					continue
+				}
+				if need := metrics[m]; need > have {
+					errs = addErrs(errs, Errf("insufficient %s quota in project %q region %q: need %v, have %v", m, project, region, need, have))
+				}
+			}
+		}
+	}
+	return errs
+}
+
+// addInstance adds the vCPUs, disk space and external IPs requested by
+// an instance to the quota request.
+func (q quotaRequest) addInstance(w *Workflow, ii InstanceInterface, ib *InstanceBase) DError {
+	zone := ii.getZone()
+	region := getRegionFromZone(zone)
+	if mt := ii.getMachineType(); mt != "" {
+		result := NamedSubexp(machineTypeURLRegex, mt)
+		project := strOr(result["project"], ib.Project)
+		cpus, err := w.machineTypeCPUs(project, result["zone"], result["machinetype"])
+		if err != nil {
+			return err
+		}
+		q.add(ib.Project, region, quotaCPUs, float64(cpus))
+	}
+	for _, d := range ii.getComputeDisks() {
+		if !d.hasInitializeParams {
+			continue
+		}
+		q.addDisk(ib.Project, zone, d.diskType, w.diskSizeGb(d.diskSizeGb, d.sourceImage))
+	}
+	q.add(ib.Project, region, quotaInUseAddresses, float64(ii.getExternalIPCount()))
+	return nil
+}
+
+// addDisk adds the space of a disk to the quota request. Disks of types
+// without a known quota metric aren't counted.
+func (q quotaRequest) addDisk(project, zone, diskType string, sizeGb int64) {
+	if metric, ok := diskTypeQuotas[path.Base(diskType)]; ok {
+		q.add(project, getRegionFromZone(zone), metric, float64(sizeGb))
+	}
+}
+
+// addCreateDisk adds the space requested by a CreateDisks disk to the quota
+// request. Disks that may be adopted, or that fall back to pd-standard when
+// out of pd-ssd quota, aren't counted.
+func (q quotaRequest) addCreateDisk(w *Workflow, d *Disk) {
+	if w.canAdopt(&d.Resource) || (d.FallbackToPdStandard && path.Base(d.Type) == pdSsd) {
+		return
+	}
+	q.addDisk(d.Project, d.Zone, d.Type, w.diskSizeGb(d.Disk.SizeGb, d.SourceImage))
+}
+
+// diskSizeGb returns the size of a disk created with sizeGb from
+// sourceImage: sizeGb if set, the size of the image otherwise. It's 0 if the
+// image is created by the workflow or can't be read.
+func (w *Workflow) diskSizeGb(sizeGb int64, sourceImage string) int64 {
+	if sizeGb != 0 || sourceImage == "" {
+		return sizeGb
+	}
+	link := sourceImage
+	if r, ok := w.images.get(sourceImage); ok {
+		if r.creator != nil {
+			return 0
+		}
+		link = r.link
+	}
+	return w.imageDiskSizeGb(link)
+}
@@ -0,0 +1,216 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"google.golang.org/api/compute/v1"
+)
+
+func TestQuotaRequestCheck(t *testing.T) {
+	w := testWorkflow()
+	tc := w.ComputeClient.(*daisyCompute.TestClient)
+	tc.GetMachineTypeFn = func(_, _, mt string) (*compute.MachineType, error) {
+		if mt == "mt-4" {
+			return &compute.MachineType{Name: mt, GuestCpus: 4}, nil
+		}
+		return nil, errors.New("bad machine type")
+	}
+	tc.GetRegionFn = func(_, _ string) (*compute.Region, error) {
+		return &compute.Region{Quotas: []*compute.Quota{
+			{Metric: quotaCPUs, Limit: 24, Usage: 10},
+			{Metric: quotaSSDTotalGb, Limit: 500, Usage: 0},
+			{Metric: quotaInUseAddresses, Limit: 8, Usage: 6},
+		}}, nil
+	}
+
+	mt := fmt.Sprintf("projects/%s/zones/%s/machineTypes/mt-4", testProject, testZone)
+	dt := fmt.Sprintf("projects/%s/zones/%s/diskTypes/pd-ssd", testProject, testZone)
+	newInstance := func(diskSize int64, externalIP bool) *Instance {
+		ni := &compute.NetworkInterface{}
+		if externalIP {
+			ni.AccessConfigs = []*compute.AccessConfig{{Type: defaultAccessConfigType}}
+		}
+		return &Instance{
+			InstanceBase: InstanceBase{Resource: Resource{Project: testProject}},
+			Instance: compute.Instance{
+				Zone:              testZone,
+				MachineType:       mt,
+				Disks:             []*compute.AttachedDisk{{InitializeParams: &compute.AttachedDiskInitializeParams{DiskType: dt, DiskSizeGb: diskSize}}},
+				NetworkInterfaces: []*compute.NetworkInterface{ni},
+			},
+		}
+	}
+
+	tests := []struct {
+		desc    string
+		is      []*Instance
+		wantErr []string
+	}{
+		{"within quota case", []*Instance{newInstance(100, true), newInstance(100, true)}, nil},
+		{"cpu quota case", []*Instance{newInstance(10, false), newInstance(10, false), newInstance(10, false), newInstance(10, false)}, []string{"insufficient CPUS quota", "need 16, have 14"}},
+		{"multiple quotas case", []*Instance{newInstance(300, true), newInstance(300, true), newInstance(300, true)}, []string{"SSD_TOTAL_GB", "need 900, have 500", "IN_USE_ADDRESSES", "need 3, have 2"}},
+	}
+
+	for _, tt := range tests {
+		q := quotaRequest{}
+		for _, i := range tt.is {
+			if err := q.addInstance(w, i, &i.InstanceBase); err != nil {
+				t.Fatalf("%s: unexpected error adding instance: %v", tt.desc, err)
+			}
+		}
+		err := q.check(w)
+		if tt.wantErr == nil {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", tt.desc, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+			continue
+		}
+		for _, want := range tt.wantErr {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("%s: error %q does not contain %q", tt.desc, err, want)
+			}
+		}
+	}
+}
+
+func TestQuotaRequestCheckRegionError(t *testing.T) {
+	w := testWorkflow()
+	w.ComputeClient.(*daisyCompute.TestClient).GetRegionFn = func(_, _ string) (*compute.Region, error) {
+		return nil, errors.New("permission denied")
+	}
+	q := quotaRequest{}
+	q.add(testProject, testRegion, quotaCPUs, 1000)
+	if err := q.check(w); err != nil {
+		t.Errorf("a region that can't be read should be skipped, got error: %v", err)
+	}
+	var logged bool
+	for _, e := range w.Logger.(*MockLogger).getEntries() {
+		logged = logged || strings.Contains(e.Message, "skipping quota preflight")
+	}
+	if !logged {
+		t.Error("skipped quota preflight was not logged")
+	}
+}
+
+func TestMachineTypeCPUsCached(t *testing.T) {
+	w := testWorkflow()
+	var calls int
+	w.ComputeClient.(*daisyCompute.TestClient).GetMachineTypeFn = func(_, _, mt string) (*compute.MachineType, error) {
+		calls++
+		return &compute.MachineType{Name: mt, GuestCpus: 4}, nil
+	}
+	for i := 0; i < 2; i++ {
+		if cpus, err := w.machineTypeCPUs(testProject, testZone, "mt-4"); err != nil || cpus != 4 {
+			t.Errorf("got %d vCPUs, error %v, want 4 vCPUs", cpus, err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("machine type looked up %d times, want once", calls)
+	}
+}
+
+func TestQuotaRequestDisks(t *testing.T) {
+	w := testWorkflow()
+	tc := w.ComputeClient.(*daisyCompute.TestClient)
+	tc.GetRegionFn = func(_, _ string) (*compute.Region, error) {
+		return &compute.Region{Quotas: []*compute.Quota{
+			{Metric: quotaDisksTotalGb, Limit: 1000, Usage: 900},
+			{Metric: quotaSSDTotalGb, Limit: 500, Usage: 0},
+		}}, nil
+	}
+	tc.GetImageFn = func(_, name string) (*compute.Image, error) {
+		return &compute.Image{Name: name, DiskSizeGb: 60}, nil
+	}
+	imageCreator, _ := w.NewStep("imageCreator")
+	w.images.m = map[string]*Resource{
+		"existing": {link: fmt.Sprintf("projects/%s/global/images/existing", testProject)},
+		"created":  {link: fmt.Sprintf("projects/%s/global/images/created", testProject), creator: imageCreator},
+	}
+	ssd := fmt.Sprintf("projects/%s/zones/%s/diskTypes/pd-ssd", testProject, testZone)
+	standard := fmt.Sprintf("projects/%s/zones/%s/diskTypes/pd-standard", testProject, testZone)
+	newDisk := func(diskType string, sizeGb int64, sourceImage string) *Disk {
+		return &Disk{Resource: Resource{Project: testProject}, Disk: compute.Disk{Zone: testZone, Type: diskType, SizeGb: sizeGb, SourceImage: sourceImage}}
+	}
+
+	tests := []struct {
+		desc    string
+		ds      []*Disk
+		wantErr []string
+	}{
+		{"within quota case", []*Disk{newDisk(ssd, 200, ""), newDisk(ssd, 200, ""), newDisk(standard, 100, "")}, nil},
+		{"ssd quota case", []*Disk{newDisk(ssd, 200, ""), newDisk(ssd, 200, ""), newDisk(ssd, 200, "")}, []string{"insufficient SSD_TOTAL_GB quota", "need 600, have 500"}},
+		{"standard quota case", []*Disk{newDisk(standard, 60, ""), newDisk(standard, 60, "")}, []string{"insufficient DISKS_TOTAL_GB quota", "need 120, have 100"}},
+		{"image size case", []*Disk{newDisk(standard, 0, "existing"), newDisk(standard, 0, "existing")}, []string{"need 120, have 100"}},
+		{"image created in workflow case", []*Disk{newDisk(standard, 0, "created"), newDisk(standard, 0, "created")}, nil},
+		{"unknown disk type case", []*Disk{newDisk(fmt.Sprintf("projects/%s/zones/%s/diskTypes/hyperdisk-balanced", testProject, testZone), 2000, "")}, nil},
+	}
+
+	for _, tt := range tests {
+		q := quotaRequest{}
+		for _, d := range tt.ds {
+			q.addCreateDisk(w, d)
+		}
+		err := q.check(w)
+		if tt.wantErr == nil {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", tt.desc, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+			continue
+		}
+		for _, want := range tt.wantErr {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("%s: error %q does not contain %q", tt.desc, err, want)
+			}
+		}
+	}
+
+	// pd-ssd disks that fall back to pd-standard aren't held to the pd-ssd quota.
+	q := quotaRequest{}
+	d := newDisk(ssd, 600, "")
+	d.FallbackToPdStandard = true
+	q.addCreateDisk(w, d)
+	if err := q.check(w); err != nil {
+		t.Errorf("fallback case: unexpected error: %v", err)
+	}
+
+	// Inline instance disks without a size count as their source image's size.
+	q = quotaRequest{}
+	for i := 0; i < 2; i++ {
+		ci := &Instance{
+			InstanceBase: InstanceBase{Resource: Resource{Project: testProject}},
+			Instance:     compute.Instance{Zone: testZone, Disks: []*compute.AttachedDisk{{InitializeParams: &compute.AttachedDiskInitializeParams{DiskType: standard, SourceImage: "existing"}}}},
+		}
+		if err := q.addInstance(w, ci, &ci.InstanceBase); err != nil {
+			t.Fatalf("unexpected error adding instance: %v", err)
+		}
+	}
+	if err := q.check(w); err == nil || !strings.Contains(err.Error(), "need 120, have 100") {
+		t.Errorf("inline disk case: got error %v, want insufficient DISKS_TOTAL_GB quota", err)
+	}
+}
@@ -0,0 +1,65 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket holding a single token that refills at a
+// fixed rate. Callers are handed out slots in the order they call wait, so
+// concurrent callers are spaced out evenly rather than bursting together.
+type rateLimiter struct {
+	interval time.Duration
+	mx       sync.Mutex
+	next     time.Time
+}
+
+// newRateLimiter returns a rateLimiter allowing qps requests per second, or
+// nil, which never limits, if qps is not positive.
+func newRateLimiter(qps float64) *rateLimiter {
+	if qps <= 0 {
+		return nil
+	}
+	return &rateLimiter{interval: time.Duration(float64(time.Second) / qps)}
+}
+
+// wait blocks until the caller may make a request. It returns false without
+// waiting any longer if cancel is closed first.
+func (rl *rateLimiter) wait(cancel <-chan struct{}) bool {
+	if rl == nil {
+		return true
+	}
+	rl.mx.Lock()
+	now := time.Now()
+	slot := rl.next
+	if slot.Before(now) {
+		slot = now
+	}
+	rl.next = slot.Add(rl.interval)
+	rl.mx.Unlock()
+
+	d := slot.Sub(now)
+	if d <= 0 {
+		return true
+	}
+	select {
+	case <-time.After(d):
+		return true
+	case <-cancel:
+		return false
+	}
+}
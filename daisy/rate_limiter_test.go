@@ -0,0 +1,94 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterQPSCap(t *testing.T) {
+	qps := 100.0
+	interval := time.Duration(float64(time.Second) / qps)
+	parent := testWorkflow()
+	parent.MaxSerialPollQPS = qps
+
+	// Callers in a parent and a sub workflow share the same limiter.
+	var mx sync.Mutex
+	var calls []time.Time
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		w := parent
+		if i%2 == 1 {
+			w = testWorkflow()
+			w.parent = parent
+		}
+		wg.Add(1)
+		go func(w *Workflow) {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				if !w.waitSerialPoll() {
+					t.Error("waitSerialPoll returned false for a running workflow")
+				}
+				mx.Lock()
+				calls = append(calls, time.Now())
+				mx.Unlock()
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	sort.Slice(calls, func(i, j int) bool { return calls[i].Before(calls[j]) })
+	if got, min := calls[len(calls)-1].Sub(calls[0]), time.Duration(len(calls)-1)*interval; got < min-interval {
+		t.Errorf("%d requests took %s, want at least %s at %v QPS", len(calls), got, min, qps)
+	}
+	// Any one second window may hold at most qps requests; check the same
+	// for a window of 10 intervals.
+	for i := 10; i < len(calls); i++ {
+		if d := calls[i].Sub(calls[i-10]); d < 9*interval {
+			t.Errorf("11 requests within %s, want at most 10 per %s", d, 10*interval)
+		}
+	}
+}
+
+func TestRateLimiterUnlimited(t *testing.T) {
+	rl := newRateLimiter(0)
+	if rl != nil {
+		t.Fatalf("want nil limiter for 0 QPS, got %+v", rl)
+	}
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		if !rl.wait(nil) {
+			t.Fatal("nil limiter wait returned false")
+		}
+	}
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Errorf("nil limiter waited %s", d)
+	}
+}
+
+func TestRateLimiterCancel(t *testing.T) {
+	rl := newRateLimiter(0.1)
+	cancel := make(chan struct{})
+	if !rl.wait(cancel) {
+		t.Fatal("first wait should not block")
+	}
+	close(cancel)
+	if rl.wait(cancel) {
+		t.Error("wait should return false once canceled")
+	}
+}
@@ -0,0 +1,83 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+const redactedValue = "<redacted>"
+
+// isSensitiveMetadataKey reports whether k is in the SensitiveMetadataKeys of
+// the workflow or any of its parents.
+func (w *Workflow) isSensitiveMetadataKey(k string) bool {
+	for ; w != nil; w = w.parent {
+		if strIn(k, w.SensitiveMetadataKeys) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactMetadata returns a copy of md, for logging, with the values of
+// sensitive keys replaced.
+func (w *Workflow) redactMetadata(md map[string]string) map[string]string {
+	if md == nil {
+		return nil
+	}
+	redacted := make(map[string]string, len(md))
+	for k, v := range md {
+		if w.isSensitiveMetadataKey(k) {
+			v = redactedValue
+		}
+		redacted[k] = v
+	}
+	return redacted
+}
+
+// redactStepsMetadata replaces the values of sensitive metadata keys in the
+// workflow's steps, including those of included and sub workflows. It's used
+// before printing the workflow, which is not run afterwards.
+func (w *Workflow) redactStepsMetadata() {
+	for _, s := range w.Steps {
+		if s.CreateInstances != nil {
+			for _, i := range s.CreateInstances.Instances {
+				i.Metadata = w.redactMetadata(i.Metadata)
+			}
+			for _, i := range s.CreateInstances.InstancesBeta {
+				i.Metadata = w.redactMetadata(i.Metadata)
+			}
+		}
+		if s.UpdateInstancesMetadata != nil {
+			for _, sm := range *s.UpdateInstancesMetadata {
+				sm.Metadata = w.redactMetadata(sm.Metadata)
+			}
+		}
+		if s.IncludeWorkflow != nil && s.IncludeWorkflow.Workflow != nil {
+			s.IncludeWorkflow.Workflow.redactStepsMetadata()
+		}
+		if s.SubWorkflow != nil && s.SubWorkflow.Workflow != nil {
+			s.SubWorkflow.Workflow.redactStepsMetadata()
+		}
+		if s.MountAndRun != nil {
+			s.MountAndRun.Metadata = w.redactMetadata(s.MountAndRun.Metadata)
+			if s.MountAndRun.include != nil {
+				s.MountAndRun.include.Workflow.redactStepsMetadata()
+			}
+		}
+		if s.TestImageBoots != nil {
+			s.TestImageBoots.Metadata = w.redactMetadata(s.TestImageBoots.Metadata)
+			if s.TestImageBoots.include != nil {
+				s.TestImageBoots.include.Workflow.redactStepsMetadata()
+			}
+		}
+	}
+}
@@ -0,0 +1,105 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"google.golang.org/api/compute/v1"
+)
+
+func TestRedactMetadata(t *testing.T) {
+	parent := testWorkflow()
+	parent.SensitiveMetadataKeys = []string{"token"}
+	w := testWorkflow()
+	w.parent = parent
+	w.SensitiveMetadataKeys = []string{"key"}
+
+	md := map[string]string{"token": "t", "key": "k", "other": "o"}
+	got := w.redactMetadata(md)
+	want := map[string]string{"token": redactedValue, "key": redactedValue, "other": "o"}
+	if diffRes := diff(got, want, 0); diffRes != "" {
+		t.Errorf("redacted metadata does not match expectation: (-got +want)\n%s", diffRes)
+	}
+	if md["token"] != "t" {
+		t.Errorf("redactMetadata modified its argument: %v", md)
+	}
+	if got := parent.redactMetadata(map[string]string{"key": "k"}); got["key"] != "k" {
+		t.Errorf("key of a sub workflow redacted in its parent: %v", got)
+	}
+	if got := w.redactMetadata(nil); got != nil {
+		t.Errorf("want nil for nil metadata, got %v", got)
+	}
+}
+
+func TestRedactStepsMetadata(t *testing.T) {
+	w := testWorkflow()
+	w.SensitiveMetadataKeys = []string{"token"}
+	sub := testWorkflow()
+	sub.parent = w
+	sub.Steps = map[string]*Step{"s": {UpdateInstancesMetadata: &UpdateInstancesMetadata{{Instance: "i", Metadata: map[string]string{"token": "t3"}}}}}
+	w.Steps = map[string]*Step{
+		"create": {CreateInstances: &CreateInstances{
+			Instances:     []*Instance{{Metadata: map[string]string{"token": "t1", "k": "v"}}},
+			InstancesBeta: []*InstanceBeta{{Metadata: map[string]string{"token": "t2"}}},
+		}},
+		"sub": {SubWorkflow: &SubWorkflow{Workflow: sub}},
+	}
+
+	w.redactStepsMetadata()
+
+	ci := w.Steps["create"].CreateInstances
+	if got := ci.Instances[0].Metadata; got["token"] != redactedValue || got["k"] != "v" {
+		t.Errorf("instance metadata not redacted as expected: %v", got)
+	}
+	if got := ci.InstancesBeta[0].Metadata; got["token"] != redactedValue {
+		t.Errorf("beta instance metadata not redacted: %v", got)
+	}
+	if got := (*sub.Steps["s"].UpdateInstancesMetadata)[0].Metadata; got["token"] != redactedValue {
+		t.Errorf("sub workflow metadata not redacted: %v", got)
+	}
+}
+
+func TestUpdateInstancesMetadataRedactsLog(t *testing.T) {
+	w := testWorkflow()
+	w.SensitiveMetadataKeys = []string{"token"}
+	mockLogger := &MockLogger{}
+	w.Logger = mockLogger
+	w.instances.m = map[string]*Resource{testInstance: {Project: testProject, RealName: testInstance, link: fmt.Sprintf("projects/%s/zones/%s/instances/%s", testProject, testZone, testInstance)}}
+	tc := w.ComputeClient.(*daisyCompute.TestClient)
+	tc.GetInstanceFn = func(_, _, _ string) (*compute.Instance, error) {
+		return &compute.Instance{Metadata: &compute.Metadata{}}, nil
+	}
+	tc.SetInstanceMetadataFn = func(_, _, _ string, _ *compute.Metadata) error { return nil }
+
+	st := &UpdateInstancesMetadata{{Instance: testInstance, Metadata: map[string]string{"token": "secret-value"}, project: testProject, zone: testZone}}
+	if err := st.run(context.Background(), &Step{name: "s", w: w}); err != nil {
+		t.Fatal(err)
+	}
+	var logged bool
+	for _, e := range mockLogger.getEntries() {
+		if strings.Contains(e.Message, "secret-value") {
+			t.Errorf("sensitive metadata value logged: %q", e.Message)
+		}
+		logged = logged || strings.Contains(e.Message, redactedValue)
+	}
+	if !logged {
+		t.Errorf("no log entry with the redacted metadata: %v", mockLogger.getEntries())
+	}
+}
@@ -71,6 +71,11 @@ func (r *Resource) populateHelper(ctx context.Context, s *Step, name string) DEr
 		errs = addErrs(errs, Errf("ExactName and RealName must be used mutually exclusively"))
 	} else if r.ExactName {
 		r.RealName = name
+	} else if r.RealName == "" && r.NoCleanup && s.w.NoCleanupNameTemplate != "" {
+		r.RealName = strings.ToLower(strings.Replace(s.w.NoCleanupNameTemplate, "{name}", name, -1))
+		if !checkName(r.RealName) {
+			errs = addErrs(errs, Errf("NoCleanupNameTemplate %q gives bad name %q for %q, names must be at most 63 lowercase letters, digits or hyphens and start with a letter", s.w.NoCleanupNameTemplate, r.RealName, name))
+		}
 	} else if r.RealName == "" {
 		r.RealName = s.w.genName(name)
 	}
@@ -120,6 +125,15 @@ func (r *Resource) validateWithRegion(ctx context.Context, s *Step, re, errPrefi
 	return errs
 }
 
+// wrapErr prefixes e with the resource's type, link and workflow reference so
+// errors from steps handling many resources point at the one that failed.
+func (r *Resource) wrapErr(e DError, typeName string) DError {
+	if e == nil {
+		return nil
+	}
+	return wrapErrf(e, "%s %s (workflow reference %q)", typeName, strOr(r.link, r.RealName), r.daisyName)
+}
+
 func defaultDescription(resourceTypeName, wfName, user string) string {
 	return fmt.Sprintf("%s created by Daisy in workflow %q on behalf of %s.", resourceTypeName, wfName, user)
 }
@@ -131,6 +145,15 @@ func extendPartialURL(url, project string) string {
 	return fmt.Sprintf("projects/%s/%s", project, url)
 }
 
+// partialURL returns the partial URL of a GCE resource URL, e.g.
+// "projects/p/zones/z/disks/d" for the disk's self link.
+func partialURL(url string) string {
+	if i := strings.Index(url, "projects/"); i > 0 {
+		return url[i:]
+	}
+	return url
+}
+
 func (w *Workflow) resourceExists(url string) (bool, DError) {
 	if !strings.HasPrefix(url, "projects/") {
 		return false, Errf("partial GCE resource URL %q needs leading \"projects/PROJECT/\"", url)
@@ -145,9 +168,15 @@ func (w *Workflow) resourceExists(url string) (bool, DError) {
 	case diskURLRgx.MatchString(url):
 		result := NamedSubexp(diskURLRgx, url)
 		return w.diskExists(result["project"], result["zone"], result["disk"])
+	case regionalDiskURLRgx.MatchString(url):
+		result := NamedSubexp(regionalDiskURLRgx, url)
+		return w.regionalDiskExists(result["project"], result["region"], result["disk"])
 	case imageURLRgx.MatchString(url):
 		result := NamedSubexp(imageURLRgx, url)
 		return w.imageExists(result["project"], result["family"], result["image"])
+	case snapshotURLRgx.MatchString(url):
+		result := NamedSubexp(snapshotURLRgx, url)
+		return w.snapshotExists(result["project"], result["snapshot"])
 	case machineImageURLRgx.MatchString(url):
 		result := NamedSubexp(machineImageURLRgx, url)
 		return w.machineImageExists(result["project"], result["machineImage"])
@@ -160,6 +189,9 @@ func (w *Workflow) resourceExists(url string) (bool, DError) {
 	case targetInstanceURLRegex.MatchString(url):
 		result := NamedSubexp(targetInstanceURLRegex, url)
 		return w.targetInstanceExists(result["project"], result["zone"], result["targetInstance"])
+	case addressURLRgx.MatchString(url):
+		result := NamedSubexp(addressURLRgx, url)
+		return w.addressExists(result["project"], result["region"], result["address"])
 	case forwardingRuleURLRegex.MatchString(url):
 		result := NamedSubexp(forwardingRuleURLRegex, url)
 		return w.forwardingRuleExists(result["project"], result["region"], result["forwardingRule"])
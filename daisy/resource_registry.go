@@ -16,6 +16,7 @@ package daisy
 
 import (
 	"fmt"
+	"path"
 	"regexp"
 	"strings"
 	"sync"
@@ -37,26 +38,54 @@ func (r *baseResourceRegistry) init() {
 	r.m = map[string]*Resource{}
 }
 
+// hasRealName reports whether a resource in r has the real name name.
+func (r *baseResourceRegistry) hasRealName(name string) bool {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	for _, res := range r.m {
+		if res.RealName == name || (res.link != "" && path.Base(res.link) == name) {
+			return true
+		}
+	}
+	return false
+}
+
 func (r *baseResourceRegistry) cleanup() {
 	var wg sync.WaitGroup
 	for name, res := range r.m {
 		if res.creator == nil || // placeholder resource
 			(res.creator != nil && !res.createdInWorkflow) || // resource isn‘t created successfully
-			(res.NoCleanup && !r.w.forceCleanup) || // resource is flagged to avoid cleanup
+			r.keep(res) || // resource or its creator is flagged to avoid cleanup
 			res.deleted { // resource has been deleted
 			continue
 		}
 		wg.Add(1)
-		go func(name string) {
+		go func(name string, res *Resource) {
 			defer wg.Done()
+			r.w.runPreDeleteHooks(r.typeName, name, res)
 			if err := r.delete(name); err != nil && err.etype() != resourceDNEError {
 				fmt.Println(err)
 			}
-		}(name)
+		}(name, res)
 	}
 	wg.Wait()
 }
 
+// keep reports whether cleanup should leave res in place. The Cleanup of the
+// step that created res takes precedence over ForceCleanupOnError, which
+// takes precedence over the resource's NoCleanup.
+func (r *baseResourceRegistry) keep(res *Resource) bool {
+	switch res.creator.Cleanup {
+	case cleanupAlways:
+		return false
+	case cleanupOnSuccess:
+		return r.w.failed
+	case cleanupNever:
+		return true
+	}
+	return res.NoCleanup && !r.w.forceCleanup
+}
+
 func (r *baseResourceRegistry) delete(name string) DError {
 	res, ok := r.get(name)
 	if !ok {
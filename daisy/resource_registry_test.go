@@ -15,6 +15,8 @@
 package daisy
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"reflect"
 	"sync"
@@ -130,6 +132,92 @@ func TestResourceRegistryForcedCleanup(t *testing.T) {
 	}
 }
 
+func TestResourceRegistryCleanupStepPolicy(t *testing.T) {
+	tests := []struct {
+		desc, cleanup                   string
+		noCleanup, failed, forceCleanup bool
+		wantDeleted                     bool
+	}{
+		{"default case", "", false, false, false, true},
+		{"default NoCleanup case", "", true, false, false, false},
+		{"default forced case", "", true, true, true, true},
+		{"always NoCleanup case", cleanupAlways, true, false, false, true},
+		{"always failed case", cleanupAlways, true, true, false, true},
+		{"on success case", cleanupOnSuccess, true, false, false, true},
+		{"on success failed case", cleanupOnSuccess, false, true, false, false},
+		{"on success forced case", cleanupOnSuccess, false, true, true, false},
+		{"never case", cleanupNever, false, false, false, false},
+		{"never forced case", cleanupNever, false, true, true, false},
+	}
+
+	for _, tt := range tests {
+		w := testWorkflow()
+		w.failed = tt.failed
+		w.forceCleanup = tt.forceCleanup
+		s := &Step{Cleanup: tt.cleanup}
+		d := &Resource{RealName: "d", link: "link", NoCleanup: tt.noCleanup, creator: s, createdInWorkflow: true}
+		w.disks.m = map[string]*Resource{"d": d}
+
+		w.cleanup()
+
+		if d.deleted != tt.wantDeleted {
+			t.Errorf("%s: got deleted %t, want %t", tt.desc, d.deleted, tt.wantDeleted)
+		}
+	}
+}
+
+func TestResourceRegistryCleanupPreDeleteHooks(t *testing.T) {
+	w := testWorkflow()
+	s := &Step{}
+
+	in1 := &Resource{RealName: "in1", link: "in1link", creator: s, createdInWorkflow: true}
+	w.instances.m = map[string]*Resource{"in1": in1}
+
+	var mx sync.Mutex
+	var got []string
+	w.AddPreDeleteHook("instance", time.Minute, func(_ context.Context, name, link string) error {
+		mx.Lock()
+		defer mx.Unlock()
+		got = append(got, fmt.Sprintf("%s %s deleted=%v", name, link, in1.deleted))
+		return nil
+	})
+	// A hung hook is given up on once its timeout passes.
+	w.AddPreDeleteHook("instance", time.Millisecond, func(context.Context, string, string) error {
+		<-make(chan struct{})
+		return nil
+	})
+	w.AddPreDeleteHook("disk", time.Minute, func(_ context.Context, name, _ string) error {
+		t.Errorf("disk hook called for %q", name)
+		return nil
+	})
+
+	w.cleanup()
+
+	if want := []string{"in1 in1link deleted=false"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("pre-delete hooks got %q, want %q", got, want)
+	}
+	if !in1.deleted {
+		t.Error("cleanup didn't delete \"in1\"")
+	}
+}
+
+func TestPreDeleteHooksFromParent(t *testing.T) {
+	parent := testWorkflow()
+	child := testWorkflow()
+	child.parent = parent
+	var called bool
+	parent.AddPreDeleteHook("disk", time.Minute, func(_ context.Context, _, _ string) error {
+		called = true
+		return errors.New("fail")
+	})
+
+	child.runPreDeleteHooks("disk", "d1", &Resource{link: "link"})
+
+	if !called {
+		t.Error("parent pre-delete hook was not called")
+	}
+}
+
 func TestResourceRegistryConcurrency(t *testing.T) {
 	rr := baseResourceRegistry{w: testWorkflow()}
 	rr.init()
@@ -16,6 +16,8 @@ package daisy
 
 import (
 	"context"
+	"errors"
+	"strings"
 	"testing"
 )
 
@@ -69,6 +71,38 @@ func TestResourcePopulate(t *testing.T) {
 	}
 }
 
+func TestResourcePopulateNoCleanupNameTemplate(t *testing.T) {
+	w := testWorkflow()
+	s, _ := w.NewStep("foo")
+
+	tests := []struct {
+		desc, template string
+		r              Resource
+		wantName       string
+		wantErr        bool
+	}{
+		{"template case", "golden-{name}-B123", Resource{NoCleanup: true}, "golden-image-b123", false},
+		{"cleaned up case", "golden-{name}-B123", Resource{}, w.genName("image"), false},
+		{"RealName case", "golden-{name}-B123", Resource{NoCleanup: true, RealName: "foo"}, "foo", false},
+		{"ExactName case", "golden-{name}-B123", Resource{NoCleanup: true, ExactName: true}, "image", false},
+		{"no template case", "", Resource{NoCleanup: true}, w.genName("image"), false},
+		{"bad charset case", "golden_{name}", Resource{NoCleanup: true}, "", true},
+		{"too long case", "{name}-" + strings.Repeat("a", 60), Resource{NoCleanup: true}, "", true},
+	}
+
+	for _, tt := range tests {
+		w.NoCleanupNameTemplate = tt.template
+		gotName, err := tt.r.populateWithGlobal(context.Background(), s, "image")
+		if tt.wantErr && err == nil {
+			t.Errorf("%s: should have returned an error but didn't", tt.desc)
+		} else if !tt.wantErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		} else if err == nil && gotName != tt.wantName {
+			t.Errorf("%s: name population wrong; got: %q, want: %q", tt.desc, gotName, tt.wantName)
+		}
+	}
+}
+
 func TestResourceNameHelper(t *testing.T) {
 	w := testWorkflow()
 	want := w.genName("foo")
@@ -132,3 +166,28 @@ func TestResourceValidateWithZone(t *testing.T) {
 		}
 	}
 }
+
+func TestResourceWrapErr(t *testing.T) {
+	inner := Errf("client error")
+	tests := []struct {
+		desc string
+		r    *Resource
+		want string
+	}{
+		{"link case", &Resource{daisyName: "foo", RealName: "foo-abc", link: "projects/p/zones/z/instances/foo-abc"}, `instance projects/p/zones/z/instances/foo-abc (workflow reference "foo"): client error`},
+		{"no link case", &Resource{daisyName: "foo", RealName: "foo-abc"}, `instance foo-abc (workflow reference "foo"): client error`},
+	}
+	for _, tt := range tests {
+		err := tt.r.wrapErr(inner, "instance")
+		if err.Error() != tt.want {
+			t.Errorf("%s: unexpected error, got: %q, want: %q", tt.desc, err, tt.want)
+		}
+		if !errors.Is(err, inner) {
+			t.Errorf("%s: wrapped error doesn't unwrap to %v", tt.desc, inner)
+		}
+	}
+
+	if err := (&Resource{}).wrapErr(nil, "instance"); err != nil {
+		t.Errorf("wrapping a nil error returned %v, want nil", err)
+	}
+}
@@ -0,0 +1,103 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+
+	"cloud.google.com/go/storage"
+)
+
+// serialLogRewriteBytes is the size up to which a serial log's GCS object is
+// rewritten whole, past it only new output is uploaded and appended.
+var serialLogRewriteBytes = 1 << 20
+
+// serialLogMaxComponents is how many components a serial log object may be
+// composed of. GCS rejects composing objects past 1024, so the object is
+// rewritten whole before it gets there.
+var serialLogMaxComponents int64 = 1024
+
+// serialLogObject is the GCS object a growing serial log is streamed to.
+// GCS objects can't be appended to, so once the log is larger than
+// serialLogRewriteBytes the output added since the last upload is written
+// to a part object, composed onto the log object and deleted, rather than
+// uploading the whole log again. Each append adds a component, once there
+// are serialLogMaxComponents the whole log is uploaded again.
+type serialLogObject struct {
+	w        *Workflow
+	name     string
+	metadata map[string]string
+	created  bool
+	// uploaded is how many bytes of the log the object holds.
+	uploaded int
+	// components is how many components the object is composed of, counted
+	// locally as the object is only written to through o.
+	components int64
+}
+
+func newSerialLogObject(w *Workflow, name string, metadata map[string]string) *serialLogObject {
+	return &serialLogObject{w: w, name: name, metadata: metadata}
+}
+
+// sync uploads the bytes of log, the whole log so far, that the object
+// doesn't hold yet. If it fails, the next call uploads them along with
+// those added since, so the object ends up holding the complete log as
+// long as the last call succeeds.
+func (o *serialLogObject) sync(ctx context.Context, log []byte) error {
+	if o.created && o.uploaded == len(log) {
+		return nil
+	}
+	defer o.w.acquireSerialLogWriter()()
+	bkt := o.w.StorageClient.Bucket(o.w.bucket)
+	dst := bkt.Object(o.name)
+	if !o.created || len(log) <= serialLogRewriteBytes || o.components >= serialLogMaxComponents {
+		if err := o.write(ctx, dst, log); err != nil {
+			return err
+		}
+		o.created, o.uploaded, o.components = true, len(log), 1
+		return nil
+	}
+
+	part := bkt.Object(o.name + ".part")
+	if err := o.write(ctx, part, log[o.uploaded:]); err != nil {
+		return err
+	}
+	c := dst.ComposerFrom(dst, part)
+	o.setAttrs(&c.ObjectAttrs)
+	if _, err := c.Run(ctx); err != nil {
+		return err
+	}
+	o.uploaded = len(log)
+	o.components++
+	// The next part overwrites this one if it can't be deleted.
+	part.Delete(ctx)
+	return nil
+}
+
+func (o *serialLogObject) write(ctx context.Context, oh *storage.ObjectHandle, b []byte) error {
+	wc := oh.NewWriter(ctx)
+	o.setAttrs(&wc.ObjectAttrs)
+	if _, err := wc.Write(b); err != nil {
+		return err // dont try to close the writer
+	}
+	return wc.Close()
+}
+
+func (o *serialLogObject) setAttrs(attrs *storage.ObjectAttrs) {
+	attrs.ContentType = strOr(o.w.SerialLogContentType, "text/plain")
+	attrs.StorageClass = strOr(o.w.SerialLogStorageClass, o.w.LogsStorageClass)
+	attrs.Metadata = o.metadata
+	o.w.setObjectACL(attrs)
+}
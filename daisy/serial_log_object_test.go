@@ -0,0 +1,153 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/option"
+)
+
+func TestSerialLogObjectSync(t *testing.T) {
+	defer func(n int) { serialLogRewriteBytes = n }(serialLogRewriteBytes)
+	serialLogRewriteBytes = 5
+
+	// The uploaded contents are the multipart body's last part, after the
+	// object's attributes.
+	contentsRgx := regexp.MustCompile(`\r\n\r\n([^{\r][^\r]*)\r\n--`)
+	var mx sync.Mutex
+	var reqs []string
+	failCompose := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mx.Lock()
+		defer mx.Unlock()
+		switch {
+		case r.Method == "POST" && strings.Contains(r.URL.Path, "/compose"):
+			if failCompose {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			reqs = append(reqs, "compose")
+		case r.Method == "POST":
+			name := "logs/s.log"
+			if strings.Contains(string(body), `"name":"logs/s.log.part"`) {
+				name += ".part"
+			}
+			var contents string
+			if ms := contentsRgx.FindAllStringSubmatch(string(body), -1); len(ms) > 0 {
+				contents = ms[len(ms)-1][1]
+			}
+			reqs = append(reqs, fmt.Sprintf("upload %s %q", name, contents))
+		case r.Method == "DELETE":
+			reqs = append(reqs, "delete "+r.URL.Path)
+		}
+		fmt.Fprint(w, `{"kind":"storage#object","bucket":"test-bucket","name":"logs/s.log"}`)
+	}))
+	defer ts.Close()
+
+	w := testWorkflow()
+	var err error
+	w.StorageClient, err = storage.NewClient(context.Background(), option.WithEndpoint(ts.URL), option.WithHTTPClient(http.DefaultClient))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.bucket = "test-bucket"
+	o := newSerialLogObject(w, "logs/s.log", nil)
+
+	tests := []struct {
+		desc, log   string
+		failCompose bool
+		want        []string
+	}{
+		{"rewrite case", "hello", false, []string{`upload logs/s.log "hello"`}},
+		{"no new output case", "hello", false, nil},
+		{"append case", "hello world", false, []string{`upload logs/s.log.part " world"`, "compose", "delete /b/test-bucket/o/logs/s.log.part"}},
+		{"failed append case", "hello world again", true, []string{`upload logs/s.log.part " again"`}},
+		{"retried append case", "hello world again", false, []string{`upload logs/s.log.part " again"`, "compose", "delete /b/test-bucket/o/logs/s.log.part"}},
+	}
+	for _, tt := range tests {
+		mx.Lock()
+		reqs, failCompose = nil, tt.failCompose
+		mx.Unlock()
+		if err := o.sync(context.Background(), []byte(tt.log)); (err != nil) != tt.failCompose {
+			t.Errorf("%s: got error %v, want error %t", tt.desc, err, tt.failCompose)
+		}
+		if diffRes := diff(reqs, tt.want, 0); diffRes != "" {
+			t.Errorf("%s: requests not as expected: (-got,+want)\n%s", tt.desc, diffRes)
+		}
+	}
+	if o.uploaded != len("hello world again") {
+		t.Errorf("got %d bytes uploaded, want %d", o.uploaded, len("hello world again"))
+	}
+}
+
+func TestSerialLogObjectSyncComponentLimit(t *testing.T) {
+	defer func(n int, c int64) { serialLogRewriteBytes, serialLogMaxComponents = n, c }(serialLogRewriteBytes, serialLogMaxComponents)
+	serialLogRewriteBytes, serialLogMaxComponents = 1, 3
+
+	var mx sync.Mutex
+	var reqs []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mx.Lock()
+		defer mx.Unlock()
+		switch {
+		case r.Method == "POST" && strings.Contains(r.URL.Path, "/compose"):
+			reqs = append(reqs, "compose")
+		case r.Method == "POST" && strings.Contains(string(body), `"name":"logs/s.log.part"`):
+			reqs = append(reqs, "upload part")
+		case r.Method == "POST":
+			reqs = append(reqs, "upload")
+		}
+		fmt.Fprint(w, `{"kind":"storage#object","bucket":"test-bucket","name":"logs/s.log"}`)
+	}))
+	defer ts.Close()
+
+	w := testWorkflow()
+	var err error
+	w.StorageClient, err = storage.NewClient(context.Background(), option.WithEndpoint(ts.URL), option.WithHTTPClient(http.DefaultClient))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.bucket = "test-bucket"
+	o := newSerialLogObject(w, "logs/s.log", nil)
+
+	// The object is rewritten whole instead of appended to once it has
+	// serialLogMaxComponents components, then appended to again.
+	var log string
+	for i := 0; i < 5; i++ {
+		log += "line\n"
+		if err := o.sync(context.Background(), []byte(log)); err != nil {
+			t.Fatalf("sync %d: unexpected error: %v", i, err)
+		}
+	}
+	want := []string{"upload", "upload part", "compose", "upload part", "compose", "upload", "upload part", "compose"}
+	if diffRes := diff(reqs, want, 0); diffRes != "" {
+		t.Errorf("requests not as expected: (-got,+want)\n%s", diffRes)
+	}
+	if o.components != 2 {
+		t.Errorf("got %d components, want 2", o.components)
+	}
+}
@@ -0,0 +1,90 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"net/http"
+	"path"
+	"strings"
+
+	"google.golang.org/api/googleapi"
+)
+
+// snapshotRegistry tracks the snapshots created by SnapshotInstanceDisks
+// steps for cleanup, and the existing snapshots images are created from.
+// Created snapshots are only known once the step runs, so they are
+// registered then rather than during validation.
+type snapshotRegistry struct {
+	baseResourceRegistry
+}
+
+func newSnapshotRegistry(w *Workflow) *snapshotRegistry {
+	sr := &snapshotRegistry{baseResourceRegistry: baseResourceRegistry{w: w, typeName: "snapshot", urlRgx: snapshotURLRgx}}
+	sr.baseResourceRegistry.deleteFn = sr.deleteFn
+	sr.init()
+	return sr
+}
+
+// snapshotExists reports whether the snapshot exists. Unlike other resources,
+// snapshots aren't listed into a cache, a project can hold a great many.
+func (w *Workflow) snapshotExists(project, snapshot string) (bool, DError) {
+	if _, err := w.ComputeClient.GetSnapshot(project, snapshot); err != nil {
+		if gErr, ok := err.(*googleapi.Error); ok && gErr.Code == http.StatusNotFound {
+			return false, nil
+		}
+		return false, typedErr(apiError, "failed to get snapshot", err)
+	}
+	return true, nil
+}
+
+func (sr *snapshotRegistry) deleteFn(res *Resource) DError {
+	m := NamedSubexp(snapshotURLRgx, res.link)
+	err := sr.w.ComputeClient.DeleteSnapshot(m["project"], m["snapshot"])
+	if gErr, ok := err.(*googleapi.Error); ok && gErr.Code == http.StatusNotFound {
+		return typedErr(resourceDNEError, "failed to delete snapshot", err)
+	}
+	return newErr("failed to delete snapshot", err)
+}
+
+// createdBefore reports whether one of the SnapshotInstanceDisks steps that
+// step s depends on creates the snapshot named name. The snapshots of an
+// instance's disks are named after its Name and their device names, which
+// are only known once the step runs, so any name with the Name prefix counts.
+func (sr *snapshotRegistry) createdBefore(name string, s *Step) bool {
+	for _, st := range sr.w.Steps {
+		if st.SnapshotInstanceDisks == nil || !s.nestedDepends(st) {
+			continue
+		}
+		for _, si := range *st.SnapshotInstanceDisks {
+			if strings.HasPrefix(name, strOr(si.Name, path.Base(si.Instance))+"-") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// regCreate registers step s as the creator of the snapshot res, named name
+// in the workflow, while s runs.
+func (sr *snapshotRegistry) regCreate(name string, res *Resource, s *Step) DError {
+	sr.mx.Lock()
+	defer sr.mx.Unlock()
+	if r, ok := sr.m[name]; ok {
+		return Errf("cannot create %s %q; already created by step %q", sr.typeName, name, r.creator.name)
+	}
+	res.creator = s
+	sr.m[name] = res
+	return nil
+}
@@ -17,6 +17,7 @@ package daisy
 import (
 	"bytes"
 	"context"
+	"hash/crc32"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -67,7 +68,9 @@ func (w *Workflow) recursiveGCS(ctx context.Context, bkt, prefix, dst string) DE
 		srcPath := w.StorageClient.Bucket(bkt).Object(objAttr.Name)
 		o := path.Join(w.sourcesPath, dst, strings.TrimPrefix(objAttr.Name, prefix))
 		dstPath := w.StorageClient.Bucket(w.bucket).Object(o)
-		if _, err := dstPath.CopierFrom(srcPath).Run(ctx); err != nil {
+		c := dstPath.CopierFrom(srcPath)
+		w.setObjectACL(&c.ObjectAttrs)
+		if _, err := c.Run(ctx); err != nil {
 			return typedErr(apiError, "failed to upload GCS object", err)
 		}
 	}
@@ -123,23 +126,89 @@ func (w *Workflow) sourceContent(ctx context.Context, s string) (string, error)
 	return string(d), nil
 }
 
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// fileCRC32C returns the CRC32C checksum, as used by GCS, and size of the
+// content read from r.
+func fileCRC32C(r io.Reader) (uint32, int64, error) {
+	h := crc32.New(crc32cTable)
+	n, err := io.Copy(h, r)
+	return h.Sum32(), n, err
+}
+
 func (w *Workflow) uploadFile(ctx context.Context, src, obj string) DError {
 	obj = filepath.ToSlash(obj)
 	dstPath := w.StorageClient.Bucket(w.bucket).Object(path.Join(w.sourcesPath, obj))
-	gcs := dstPath.NewWriter(ctx)
 	f, err := os.Open(src)
 	if err != nil {
 		return newErr("failed to open local file for uploading", err)
 	}
+	defer f.Close()
+	crc, size, err := fileCRC32C(f)
+	if err != nil {
+		return newErr("failed to read local file for uploading", err)
+	}
+	// Runs that share a SharedSourcesDir find their sources already uploaded.
+	if attrs, err := dstPath.Attrs(ctx); err == nil && attrs.Size == size && attrs.CRC32C == crc {
+		w.LogWorkflowInfo("Skipping upload of %q, gs://%s/%s has the same CRC32C.", src, w.bucket, dstPath.ObjectName())
+		return nil
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return newErr("failed to read local file for uploading", err)
+	}
+	gcs := dstPath.NewWriter(ctx)
+	gcs.CRC32C = crc
+	gcs.SendCRC32C = true
+	// A non-zero ChunkSize makes this a resumable upload.
+	gcs.ChunkSize = w.sourceUploadChunkSize()
+	w.setObjectACL(&gcs.ObjectAttrs)
 	if _, err := io.Copy(gcs, f); err != nil {
 		return newErr("failed to copy local file to GCS", err)
 	}
 	return newErr("failed to close GCS object", gcs.Close())
 }
 
+func (w *Workflow) sourceUploadChunkSize() int {
+	if w.SourceUploadChunkSize > 0 {
+		return w.SourceUploadChunkSize
+	}
+	return googleapi.DefaultUploadChunkSize
+}
+
+// secretSources returns the Sources read for instance Secrets by the steps
+// of w and of the workflows it includes. Sub workflows upload their own.
+func (w *Workflow) secretSources() map[string]bool {
+	srcs := map[string]bool{}
+	for _, st := range w.Steps {
+		if st.IncludeWorkflow != nil && st.IncludeWorkflow.Workflow != nil {
+			for src := range st.IncludeWorkflow.Workflow.secretSources() {
+				srcs[src] = true
+			}
+		}
+		if st.CreateInstances == nil {
+			continue
+		}
+		for _, i := range st.CreateInstances.Instances {
+			for _, src := range i.Secrets {
+				srcs[src] = true
+			}
+		}
+		for _, i := range st.CreateInstances.InstancesBeta {
+			for _, src := range i.Secrets {
+				srcs[src] = true
+			}
+		}
+	}
+	return srcs
+}
+
+// uploadSources uploads the workflow's Sources to its sources path. Sources
+// holding Secrets are read from where they are when instances are created
+// and never uploaded.
 func (w *Workflow) uploadSources(ctx context.Context) DError {
+	secrets := w.secretSources()
 	for dst, origPath := range w.Sources {
-		if origPath == "" {
+		if origPath == "" || secrets[dst] {
 			continue
 		}
 		// GCS to GCS.
@@ -152,7 +221,9 @@ func (w *Workflow) uploadSources(ctx context.Context) DError {
 			}
 			src := w.StorageClient.Bucket(bkt).Object(objPath)
 			dstPath := w.StorageClient.Bucket(w.bucket).Object(path.Join(w.sourcesPath, dst))
-			if _, err := dstPath.CopierFrom(src).Run(ctx); err != nil {
+			c := dstPath.CopierFrom(src)
+			w.setObjectACL(&c.ObjectAttrs)
+			if _, err := c.Run(ctx); err != nil {
 				if gErr, ok := err.(*googleapi.Error); ok && gErr.Code == http.StatusNotFound {
 					return typedErrf(resourceDNEError, "error copying from file %s: %v", origPath, err)
 				}
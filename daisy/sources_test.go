@@ -15,12 +15,26 @@
 package daisy
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"path/filepath"
 	"reflect"
+	"regexp"
 	"strings"
+	"sync"
 	"testing"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
 )
 
 func TestUploadSources(t *testing.T) {
@@ -116,3 +130,187 @@ func TestUploadSources(t *testing.T) {
 		}
 	}
 }
+
+func TestUploadSourcesSkipsSecrets(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, f := range []string{"token", "script"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, f), []byte(f), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := testWorkflow()
+	iw := testWorkflow()
+	iw.Steps = map[string]*Step{
+		"create": {CreateInstances: &CreateInstances{InstancesBeta: []*InstanceBeta{{InstanceBase: InstanceBase{Secrets: map[string]string{"other": "included-token"}}}}}},
+	}
+	w.Steps = map[string]*Step{
+		"create":  {CreateInstances: &CreateInstances{Instances: []*Instance{{InstanceBase: InstanceBase{Secrets: map[string]string{"token": "token"}}}}}},
+		"include": {IncludeWorkflow: &IncludeWorkflow{Workflow: iw}},
+	}
+	w.bucket, w.sourcesPath = "bucket", "sources"
+	w.Sources = map[string]string{"token": filepath.Join(dir, "token"), "included-token": filepath.Join(dir, "token"), "script": filepath.Join(dir, "script")}
+	testGCSObjs = nil
+	if err := w.uploadSources(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{w.sourcesPath + "/script"}; !reflect.DeepEqual(testGCSObjs, want) {
+		t.Errorf("got uploaded objects %q, want %q", testGCSObjs, want)
+	}
+}
+
+func TestUploadFileSkipsUnchanged(t *testing.T) {
+	content := []byte("Hello world")
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.Checksum(content, crc32cTable))
+	otherCRC := make([]byte, 4)
+	binary.BigEndian.PutUint32(otherCRC, crc32.Checksum([]byte("Hello world!"), crc32cTable))
+
+	nameRgx := regexp.MustCompile(`"name":"([^"]+)"`)
+	var uploaded []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			switch strings.TrimPrefix(r.URL.Path, "/b/bucket/o/") {
+			case "sources/same":
+				fmt.Fprintf(w, `{"name": "sources/same", "size": "%d", "crc32c": %q}`, len(content), base64.StdEncoding.EncodeToString(crc))
+			case "sources/changed":
+				fmt.Fprintf(w, `{"name": "sources/changed", "size": "%d", "crc32c": %q}`, len(content), base64.StdEncoding.EncodeToString(otherCRC))
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+			return
+		}
+		if r.Method == "POST" {
+			body, _ := ioutil.ReadAll(r.Body)
+			n := nameRgx.FindStringSubmatch(string(body))[1]
+			uploaded = append(uploaded, n)
+			fmt.Fprintf(w, `{"kind":"storage#object","bucket":"bucket","name":%q}`, n)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("error when setting up test file: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	testPath := filepath.Join(dir, "test")
+	if err := ioutil.WriteFile(testPath, content, 0600); err != nil {
+		t.Fatalf("error when setting up test file: %s", err)
+	}
+
+	w := testWorkflow()
+	w.StorageClient, err = storage.NewClient(context.Background(), option.WithEndpoint(ts.URL), option.WithHTTPClient(http.DefaultClient))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.bucket = "bucket"
+	w.sourcesPath = "sources"
+
+	tests := []struct {
+		desc, obj string
+		want      []string
+	}{
+		{"same content case", "same", nil},
+		{"changed content case", "changed", []string{"sources/changed"}},
+		{"new object case", "new", []string{"sources/new"}},
+	}
+
+	for _, tt := range tests {
+		uploaded = nil
+		if err := w.uploadFile(context.Background(), testPath, tt.obj); err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+		if !reflect.DeepEqual(uploaded, tt.want) {
+			t.Errorf("%s: unexpected uploads, want: %q, got: %q", tt.desc, tt.want, uploaded)
+		}
+	}
+}
+
+func TestUploadFileResumesChunks(t *testing.T) {
+	content := make([]byte, 3*googleapi.MinUploadChunkSize)
+	for i := range content {
+		content[i] = byte(i)
+	}
+
+	var got []byte
+	var mx sync.Mutex
+	failed := map[string]bool{}
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mx.Lock()
+		defer mx.Unlock()
+		switch {
+		case r.Method == "GET":
+			w.WriteHeader(http.StatusNotFound)
+		case r.URL.Query().Get("uploadType") == "resumable":
+			w.Header().Set("Location", ts.URL+"/session")
+		case r.URL.Path == "/session":
+			body, _ := ioutil.ReadAll(r.Body)
+			rng := r.Header.Get("Content-Range")
+			// Fail the second and third chunks once, which the uploader should
+			// retry without resending earlier chunks.
+			if !failed[rng] && strings.HasSuffix(rng, "/*") && !strings.HasPrefix(rng, "bytes 0-") {
+				failed[rng] = true
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			got = append(got, body...)
+			if strings.HasSuffix(rng, "/*") {
+				w.Header().Set("X-Http-Status-Code-Override", "308")
+				return
+			}
+			fmt.Fprint(w, `{"kind":"storage#object","bucket":"bucket","name":"sources/new"}`)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("error when setting up test file: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	testPath := filepath.Join(dir, "test")
+	if err := ioutil.WriteFile(testPath, content, 0600); err != nil {
+		t.Fatalf("error when setting up test file: %s", err)
+	}
+
+	w := testWorkflow()
+	w.StorageClient, err = storage.NewClient(context.Background(), option.WithEndpoint(ts.URL), option.WithHTTPClient(http.DefaultClient))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.bucket = "bucket"
+	w.sourcesPath = "sources"
+	w.SourceUploadChunkSize = googleapi.MinUploadChunkSize
+
+	if err := w.uploadFile(context.Background(), testPath, "new"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(failed) != 2 {
+		t.Errorf("want 2 failed chunks, got %d: %v", len(failed), failed)
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("uploaded content differs from file, got %d bytes, want %d", len(got), len(content))
+	}
+}
+
+func TestSourceUploadChunkSize(t *testing.T) {
+	w := testWorkflow()
+	if got := w.sourceUploadChunkSize(); got != googleapi.DefaultUploadChunkSize {
+		t.Errorf("want default %d, got %d", googleapi.DefaultUploadChunkSize, got)
+	}
+	w.SourceUploadChunkSize = 2 * googleapi.MinUploadChunkSize
+	if got := w.sourceUploadChunkSize(); got != 2*googleapi.MinUploadChunkSize {
+		t.Errorf("want %d, got %d", 2*googleapi.MinUploadChunkSize, got)
+	}
+}
@@ -22,6 +22,19 @@ import (
 	"time"
 )
 
+// Step.ReadyWhen values.
+const (
+	readyWhenAll          = "ALL"
+	readyWhenFirstRunning = "FIRST_RUNNING"
+)
+
+// Step.Cleanup values.
+const (
+	cleanupAlways    = "ALWAYS"
+	cleanupOnSuccess = "ON_SUCCESS"
+	cleanupNever     = "NEVER"
+)
+
 type stepImpl interface {
 	// populate modifies the step type field values.
 	// populate should set defaults, extend GCE partial URLs to full partial
@@ -33,6 +46,59 @@ type stepImpl interface {
 	run(ctx context.Context, s *Step) DError
 }
 
+// StepCondition is a condition on a serial-output value, as reported by
+// WaitForInstancesSignal StatusMatch. Steps that report the value should be
+// dependencies of the conditional step.
+type StepCondition struct {
+	// SerialOutputKey is the key of the serial-output value to check.
+	SerialOutputKey string
+	// SerialOutputValue is the value the serial-output value must be equal to.
+	// If unset, the condition is met whenever the key has been reported.
+	SerialOutputValue string `json:",omitempty"`
+	// Invert the condition, i.e. run the step only if it is not met.
+	Not bool `json:",omitempty"`
+}
+
+func (c *StepCondition) validate() DError {
+	if c.SerialOutputKey == "" {
+		return Errf("RunIf: SerialOutputKey must be set")
+	}
+	return nil
+}
+
+func (c *StepCondition) met(w *Workflow) bool {
+	v, ok := w.lookupSerialConsoleOutputValue(c.SerialOutputKey)
+	met := ok && (c.SerialOutputValue == "" || v == c.SerialOutputValue)
+	return met != c.Not
+}
+
+// VarCondition is a condition on the value of a workflow var.
+type VarCondition struct {
+	// Var is the name of the workflow var to check.
+	Var string
+	// Value is the value the var must be equal to. If unset, the condition is
+	// met whenever the var is non-empty.
+	Value string `json:",omitempty"`
+	// Invert the condition, i.e. it is met only if the var doesn't match.
+	Not bool `json:",omitempty"`
+}
+
+func (c *VarCondition) validate(w *Workflow) DError {
+	if c.Var == "" {
+		return Errf("Skip: Var must be set")
+	}
+	if _, ok := w.Vars[c.Var]; !ok {
+		return Errf("Skip: unknown workflow Var %q", c.Var)
+	}
+	return nil
+}
+
+func (c *VarCondition) met(w *Workflow) bool {
+	v := w.Vars[c.Var].Value
+	met := (c.Value == "" && v != "") || (c.Value != "" && v == c.Value)
+	return met != c.Not
+}
+
 // Step is a single daisy workflow step.
 type Step struct {
 	name string
@@ -44,9 +110,40 @@ type Step struct {
 	// Must be parsable by https://golang.org/pkg/time/#ParseDuration.
 	Timeout string `json:",omitempty"`
 	timeout time.Duration
+	// RunIf makes running this step conditional on the serial-output values
+	// reported by earlier steps. The step is skipped if the condition isn't met.
+	RunIf *StepCondition `json:",omitempty"`
+	// Skip skips this step if the condition on a workflow var is met, e.g. for
+	// steps that only apply to some OS variants. The step is still validated.
+	Skip *VarCondition `json:",omitempty"`
+	// ReadyWhen lets the steps that depend on this step start before it
+	// finishes. Only CreateInstances steps support it: "FIRST_RUNNING" starts
+	// dependents once the step's first instance is RUNNING, e.g. a server
+	// that clients are waiting for, and they may not use its other instances.
+	// The default, "ALL", waits for the whole step. The workflow still fails
+	// if the step fails after its dependents start.
+	ReadyWhen string `json:",omitempty"`
+	// ready is set by traverseDAG if ReadyWhen is "FIRST_RUNNING".
+	ready chan struct{}
+	// Cleanup overrides when the resources this step creates are deleted at
+	// the end of the workflow: "ALWAYS", "ON_SUCCESS" (kept for debugging if
+	// the workflow fails) or "NEVER". It takes precedence over
+	// ForceCleanupOnError and the resources' NoCleanup.
+	Cleanup string `json:",omitempty"`
+	// ContinueOnError lets the workflow carry on if this step fails, e.g. for
+	// best-effort diagnostic steps. The error is logged and recorded, see
+	// Workflow.GetStepErrorRecords, and the steps that depend on this step
+	// still run. A step that times out still fails the workflow.
+	ContinueOnError bool `json:",omitempty"`
+	// Retries reruns a failed CreateDisks or CreateInstances step up to
+	// Retries more times, e.g. for flaky provisioning. Before each retry the
+	// resources the step created are deleted, and those with generated names
+	// are given fresh ones. The step Timeout covers all attempts.
+	Retries int `json:",omitempty"`
 	// Only one of the below fields should exist for each instance of Step.
 	AttachDisks               *AttachDisks               `json:",omitempty"`
 	DetachDisks               *DetachDisks               `json:",omitempty"`
+	CreateAddresses           *CreateAddresses           `json:",omitempty"`
 	CreateDisks               *CreateDisks               `json:",omitempty"`
 	CreateForwardingRules     *CreateForwardingRules     `json:",omitempty"`
 	CreateFirewallRules       *CreateFirewallRules       `json:",omitempty"`
@@ -57,16 +154,25 @@ type Step struct {
 	CreateSubnetworks         *CreateSubnetworks         `json:",omitempty"`
 	CreateTargetInstances     *CreateTargetInstances     `json:",omitempty"`
 	CopyGCSObjects            *CopyGCSObjects            `json:",omitempty"`
+	CopyImages                *CopyImages                `json:",omitempty"`
 	ResizeDisks               *ResizeDisks               `json:",omitempty"`
+	ResizeInstances           *ResizeInstances           `json:",omitempty"`
+	SnapshotInstanceDisks     *SnapshotInstanceDisks     `json:",omitempty"`
 	StartInstances            *StartInstances            `json:",omitempty"`
 	StopInstances             *StopInstances             `json:",omitempty"`
 	DeleteResources           *DeleteResources           `json:",omitempty"`
 	DeprecateImages           *DeprecateImages           `json:",omitempty"`
 	IncludeWorkflow           *IncludeWorkflow           `json:",omitempty"`
+	MountAndRun               *MountAndRun               `json:",omitempty"`
 	SubWorkflow               *SubWorkflow               `json:",omitempty"`
+	TestImageBoots            *TestImageBoots            `json:",omitempty"`
 	WaitForInstancesSignal    *WaitForInstancesSignal    `json:",omitempty"`
 	WaitForAnyInstancesSignal *WaitForAnyInstancesSignal `json:",omitempty"`
+	WaitForInstancesHealthy   *WaitForInstancesHealthy   `json:",omitempty"`
+	WaitForApproval           *WaitForApproval           `json:",omitempty"`
+	WaitForCondition          *WaitForCondition          `json:",omitempty"`
 	UpdateInstancesMetadata   *UpdateInstancesMetadata   `json:",omitempty"`
+	Print                     *Print                     `json:",omitempty"`
 	// Used for unit tests.
 	testType stepImpl
 }
@@ -96,6 +202,10 @@ func (s *Step) stepImpl() (stepImpl, DError) {
 		matchCount++
 		result = s.DetachDisks
 	}
+	if s.CreateAddresses != nil {
+		matchCount++
+		result = s.CreateAddresses
+	}
 	if s.CreateDisks != nil {
 		matchCount++
 		result = s.CreateDisks
@@ -136,10 +246,22 @@ func (s *Step) stepImpl() (stepImpl, DError) {
 		matchCount++
 		result = s.CopyGCSObjects
 	}
+	if s.CopyImages != nil {
+		matchCount++
+		result = s.CopyImages
+	}
 	if s.ResizeDisks != nil {
 		matchCount++
 		result = s.ResizeDisks
 	}
+	if s.ResizeInstances != nil {
+		matchCount++
+		result = s.ResizeInstances
+	}
+	if s.SnapshotInstanceDisks != nil {
+		matchCount++
+		result = s.SnapshotInstanceDisks
+	}
 	if s.StartInstances != nil {
 		matchCount++
 		result = s.StartInstances
@@ -160,10 +282,18 @@ func (s *Step) stepImpl() (stepImpl, DError) {
 		matchCount++
 		result = s.IncludeWorkflow
 	}
+	if s.MountAndRun != nil {
+		matchCount++
+		result = s.MountAndRun
+	}
 	if s.SubWorkflow != nil {
 		matchCount++
 		result = s.SubWorkflow
 	}
+	if s.TestImageBoots != nil {
+		matchCount++
+		result = s.TestImageBoots
+	}
 	if s.WaitForInstancesSignal != nil {
 		matchCount++
 		result = s.WaitForInstancesSignal
@@ -172,10 +302,26 @@ func (s *Step) stepImpl() (stepImpl, DError) {
 		matchCount++
 		result = s.WaitForAnyInstancesSignal
 	}
+	if s.WaitForInstancesHealthy != nil {
+		matchCount++
+		result = s.WaitForInstancesHealthy
+	}
+	if s.WaitForApproval != nil {
+		matchCount++
+		result = s.WaitForApproval
+	}
+	if s.WaitForCondition != nil {
+		matchCount++
+		result = s.WaitForCondition
+	}
 	if s.UpdateInstancesMetadata != nil {
 		matchCount++
 		result = s.UpdateInstancesMetadata
 	}
+	if s.Print != nil {
+		matchCount++
+		result = s.Print
+	}
 	if s.testType != nil {
 		matchCount++
 		result = s.testType
@@ -243,7 +389,7 @@ func (s *Step) nestedDepends(other *Step) bool {
 }
 
 // getChain returns the step chain getting to a step. A link in the chain represents an IncludeWorkflow step, a
-// SubWorkflow step, or the step itself.
+// SubWorkflow step, a MountAndRun or TestImageBoots step, or the step itself.
 // For example, workflow A has a step s1 which includes workflow B. B has a step s2 which subworkflows C. Finally,
 // C has a step s3. s3.getChain() will return []*Step{s1, s2, s3}
 func (s *Step) getChain() []*Step {
@@ -260,11 +406,29 @@ func (s *Step) getChain() []*Step {
 		if st.SubWorkflow != nil && st.SubWorkflow.Workflow == s.w {
 			return append(st.getChain(), s)
 		}
+		if st.MountAndRun != nil && st.MountAndRun.include != nil && st.MountAndRun.include.Workflow == s.w {
+			return append(st.getChain(), s)
+		}
+		if st.TestImageBoots != nil && st.TestImageBoots.include != nil && st.TestImageBoots.include.Workflow == s.w {
+			return append(st.getChain(), s)
+		}
 	}
 	// We shouldn't get here.
 	return nil
 }
 
+// signalReady starts the steps that depend on s if s.ReadyWhen allows them
+// to start before s finishes. Only the first signal has an effect.
+func (s *Step) signalReady() {
+	if s.ready == nil {
+		return
+	}
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}
+
 func (s *Step) populate(ctx context.Context) DError {
 	s.w.LogWorkflowInfo("Populating step %q", s.name)
 	impl, err := s.stepImpl()
@@ -295,34 +459,95 @@ func (s *Step) run(ctx context.Context) DError {
 	} else {
 		st = t.Name()
 	}
+	if s.Skip != nil && s.Skip.met(s.w) {
+		s.w.LogWorkflowInfo("Skipping step %q (%s), Skip condition on var %q met.", s.name, st, s.Skip.Var)
+		return nil
+	}
+	if s.RunIf != nil && !s.RunIf.met(s.w) {
+		s.w.LogWorkflowInfo("Skipping step %q (%s), RunIf condition on serial-output key %q not met.", s.name, st, s.RunIf.SerialOutputKey)
+		return nil
+	}
 	s.w.LogWorkflowInfo("Running step %q (%s)", s.name, st)
-	if err = impl.run(ctx, s); err != nil {
-		return s.wrapRunError(err)
+	ctx, span := s.w.tracer().Start(ctx, "daisy.step", map[string]string{"daisy.step.name": s.w.qualifiedStepName(s.name), "daisy.step.type": st})
+	var spanErr DError
+	defer func() { span.End(spanErr) }()
+	for attempt := 1; ; attempt++ {
+		if err = impl.run(ctx, s); err == nil || attempt > s.Retries || s.w.isCanceled() {
+			break
+		}
+		s.w.LogWorkflowInfo("Step %q (%s) attempt %d of %d failed, retrying: %v", s.name, st, attempt, s.Retries+1, err)
+		if rErr := s.prepareRetry(attempt); rErr != nil {
+			err = addErrs(err, rErr)
+			break
+		}
+	}
+	if err != nil {
+		err = s.wrapRunError(err)
+		spanErr = err
+		if s.ContinueOnError && !s.w.isCanceled() {
+			s.w.LogWorkflowInfo("Step %q (%s) failed, continuing as ContinueOnError is set: %v", s.name, st, err)
+			s.w.recordStepError(s.name, err)
+			return nil
+		}
+		return err
 	}
 	select {
 	case <-s.w.Cancel:
 		// return an error to indicate a canceled workflow is not 'success'
-		s.w.LogWorkflowInfo("Step %q (%s) is canceled.", s.name, st)
-		return Errf("Step %q (%s) is canceled.", s.name, st)
+		err := s.canceledErr(st)
+		s.w.LogWorkflowInfo("%v", err)
+		spanErr = err
+		return err
 	default:
-		s.w.LogWorkflowInfo("Step %q (%s) successfully finished.", s.name, st)
+		s.w.LogWorkflowInfo("Step %q (%s) successfully finished in %s.", s.name, st, time.Since(startTime).Round(time.Millisecond))
 	}
 	return nil
 }
 
 func (s *Step) validate(ctx context.Context) DError {
+	if err := s.validateStep(ctx); err != nil {
+		return s.wrapValidateError(err)
+	}
+	return nil
+}
+
+// validateStep is validate without the step name added to the errors, which
+// keeps the individual errors apart.
+func (s *Step) validateStep(ctx context.Context) DError {
 	s.w.LogWorkflowInfo("Validating step %q", s.name)
 	if !rfc1035Rgx.MatchString(strings.ToLower(s.name)) {
-		return s.wrapValidateError(Errf("step name must start with a letter and only contain letters, numbers, and hyphens"))
+		return Errf("step name must start with a letter and only contain letters, numbers, and hyphens")
 	}
 	impl, err := s.stepImpl()
 	if err != nil {
-		return s.wrapValidateError(err)
+		return err
 	}
-	if err = impl.validate(ctx, s); err != nil {
-		return s.wrapValidateError(err)
+	if s.RunIf != nil {
+		if err = s.RunIf.validate(); err != nil {
+			return err
+		}
 	}
-	return nil
+	if s.Skip != nil {
+		if err = s.Skip.validate(s.w); err != nil {
+			return err
+		}
+	}
+	switch s.ReadyWhen {
+	case "", readyWhenAll:
+	case readyWhenFirstRunning:
+		if s.CreateInstances == nil {
+			return Errf("ReadyWhen %q is only supported by CreateInstances steps", s.ReadyWhen)
+		}
+	default:
+		return Errf("ReadyWhen must be one of %q, got %q", []string{readyWhenAll, readyWhenFirstRunning}, s.ReadyWhen)
+	}
+	if s.Cleanup != "" && !strIn(s.Cleanup, []string{cleanupAlways, cleanupOnSuccess, cleanupNever}) {
+		return Errf("Cleanup must be one of %q, got %q", []string{cleanupAlways, cleanupOnSuccess, cleanupNever}, s.Cleanup)
+	}
+	if err = s.validateRetries(); err != nil {
+		return err
+	}
+	return impl.validate(ctx, s)
 }
 
 func (s *Step) wrapPopulateError(e DError) DError {
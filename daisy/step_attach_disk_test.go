@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"testing"
 
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
 	"google.golang.org/api/compute/v1"
 )
 
@@ -46,6 +47,13 @@ func TestAttachDisksValidate(t *testing.T) {
 		testDisk: {Project: testProject, RealName: testDisk, link: fmt.Sprintf("projects/%s/zones/%s/disks/%s", testProject, testZone, testDisk)},
 		"bad":    {Project: "bad", RealName: testDisk, link: "link"},
 	}
+	w.ComputeClient.(*daisyCompute.TestClient).GetRegionDiskFn = func(_, _, name string) (*compute.Disk, error) {
+		if name == "other-zones" {
+			return &compute.Disk{Name: name, ReplicaZones: []string{"zones/z1", "zones/z2"}}, nil
+		}
+		return &compute.Disk{Name: name, ReplicaZones: []string{"zones/z1", "zones/" + testZone}}, nil
+	}
+	regional := fmt.Sprintf("projects/%s/regions/r/disks/rd", testProject)
 
 	tests := []struct {
 		desc    string
@@ -60,6 +68,13 @@ func TestAttachDisksValidate(t *testing.T) {
 		{"bad zone case", &AttachDisks{{Instance: testInstance, AttachedDisk: compute.AttachedDisk{Mode: diskModeRW, Source: fmt.Sprintf("projects/%s/zones/bad/disks/bad", testProject)}}}, true},
 		{"url case", &AttachDisks{{Instance: testInstance, AttachedDisk: compute.AttachedDisk{Mode: diskModeRW, Source: fmt.Sprintf("projects/%s/zones/%s/disks/%s", testProject, testZone, testDisk)}}}, false},
 		{"resolve instance and disk case", &AttachDisks{{Instance: testInstance, AttachedDisk: compute.AttachedDisk{Mode: diskModeRW, Source: testDisk}}}, false},
+		{"boot guest os features case", &AttachDisks{{Instance: testInstance, AttachedDisk: compute.AttachedDisk{Mode: diskModeRW, Source: testDisk, Boot: true, GuestOsFeatures: []*compute.GuestOsFeature{{Type: "UEFI_COMPATIBLE"}}}}}, false},
+		{"bad guest os feature case", &AttachDisks{{Instance: testInstance, AttachedDisk: compute.AttachedDisk{Mode: diskModeRW, Source: testDisk, Boot: true, GuestOsFeatures: []*compute.GuestOsFeature{{Type: "bad"}}}}}, true},
+		{"regional disk case", &AttachDisks{{Instance: testInstance, AttachedDisk: compute.AttachedDisk{Mode: diskModeRW, Source: regional}}}, false},
+		{"regional disk force attach case", &AttachDisks{{Instance: testInstance, ForceAttach: true, AttachedDisk: compute.AttachedDisk{Mode: diskModeRW, Source: regional}}}, false},
+		{"regional disk bad zone case", &AttachDisks{{Instance: testInstance, AttachedDisk: compute.AttachedDisk{Mode: diskModeRW, Source: fmt.Sprintf("projects/%s/regions/r/disks/other-zones", testProject)}}}, true},
+		{"zonal disk force attach case", &AttachDisks{{Instance: testInstance, ForceAttach: true, AttachedDisk: compute.AttachedDisk{Mode: diskModeRW, Source: testDisk}}}, true},
+		{"non-boot guest os features case", &AttachDisks{{Instance: testInstance, AttachedDisk: compute.AttachedDisk{Mode: diskModeRW, Source: testDisk, GuestOsFeatures: []*compute.GuestOsFeature{{Type: "UEFI_COMPATIBLE"}}}}}, true},
 	}
 	for _, tt := range tests {
 		err := tt.ads.validate(ctx, s)
@@ -98,3 +113,102 @@ func TestAttachDisksRun(t *testing.T) {
 		}
 	}
 }
+
+func TestAttachDisksRunAttachedElsewhere(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	s := &Step{w: w}
+	w.ComputeClient.(*daisyCompute.TestClient).GetDiskFn = func(_, _, _ string) (*compute.Disk, error) {
+		return &compute.Disk{Users: []string{fmt.Sprintf("projects/%s/zones/%s/instances/other", testProject, testZone)}}, nil
+	}
+	var attached bool
+	w.ComputeClient.(*daisyCompute.TestClient).AttachDiskFn = func(_, _, _ string, _ *compute.AttachedDisk) error {
+		attached = true
+		return nil
+	}
+	source := fmt.Sprintf("projects/%s/zones/%s/disks/%s", testProject, testZone, testDisk)
+
+	ads := &AttachDisks{{Instance: testInstance, AttachedDisk: compute.AttachedDisk{Mode: diskModeRO, Source: source}, project: testProject, zone: testZone}}
+	if err := ads.run(ctx, s); err != nil {
+		t.Errorf("unexpected error attaching READ_ONLY: %v", err)
+	}
+	if !attached {
+		t.Error("READ_ONLY disk was not attached")
+	}
+
+	attached = false
+	ads = &AttachDisks{{Instance: testInstance, AttachedDisk: compute.AttachedDisk{Mode: diskModeRW, Source: source}, project: testProject, zone: testZone}}
+	want := fmt.Sprintf("cannot attach disk %q to instance %q in READ_WRITE mode, it is already attached to instance \"other\"", testDisk, testInstance)
+	if err := ads.run(ctx, s); err == nil || err.Error() != want {
+		t.Errorf("did not get expected error, got: %v, want: %q", err, want)
+	}
+	if attached {
+		t.Error("READ_WRITE disk was attached")
+	}
+}
+
+func TestAttachDisksRunAttachedToSameInstance(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	s := &Step{w: w}
+	w.ComputeClient.(*daisyCompute.TestClient).GetDiskFn = func(_, _, _ string) (*compute.Disk, error) {
+		return &compute.Disk{Users: []string{fmt.Sprintf("https://www.googleapis.com/compute/v1/projects/%s/zones/%s/instances/%s", testProject, testZone, testInstance)}}, nil
+	}
+	w.ComputeClient.(*daisyCompute.TestClient).AttachDiskFn = func(_, _, _ string, _ *compute.AttachedDisk) error { return nil }
+	source := fmt.Sprintf("projects/%s/zones/%s/disks/%s", testProject, testZone, testDisk)
+
+	tests := []struct {
+		desc, instance string
+	}{
+		{"name", testInstance},
+		{"url", fmt.Sprintf("projects/%s/zones/%s/instances/%s", testProject, testZone, testInstance)},
+	}
+	for _, tt := range tests {
+		ads := &AttachDisks{{Instance: tt.instance, AttachedDisk: compute.AttachedDisk{Mode: diskModeRW, Source: source}, project: testProject, zone: testZone}}
+		if err := ads.run(ctx, s); err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+	}
+
+	// An instance with the same name in another zone is a different user.
+	ads := &AttachDisks{{Instance: testInstance, AttachedDisk: compute.AttachedDisk{Mode: diskModeRW, Source: source}, project: testProject, zone: "other-zone"}}
+	if err := ads.run(ctx, s); err == nil {
+		t.Error("expected error for an instance of the same name in another zone")
+	}
+}
+
+func TestAttachDisksRunForceAttach(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	s := &Step{w: w}
+	w.ComputeClient.(*daisyCompute.TestClient).GetRegionDiskFn = func(_, _, _ string) (*compute.Disk, error) {
+		return &compute.Disk{Users: []string{fmt.Sprintf("projects/%s/zones/z1/instances/other", testProject)}}, nil
+	}
+	var gotAttach, gotForceAttach bool
+	w.ComputeClient.(*daisyCompute.TestClient).AttachDiskFn = func(_, _, _ string, _ *compute.AttachedDisk) error {
+		gotAttach = true
+		return nil
+	}
+	w.ComputeClient.(*daisyCompute.TestClient).ForceAttachDiskFn = func(project, zone, _ string, _ *compute.AttachedDisk) error {
+		if project != testProject || zone != testZone {
+			t.Errorf("got force attach in projects/%s/zones/%s, want projects/%s/zones/%s", project, zone, testProject, testZone)
+		}
+		gotForceAttach = true
+		return nil
+	}
+	source := fmt.Sprintf("projects/%s/regions/r/disks/%s", testProject, testDisk)
+
+	ads := &AttachDisks{{Instance: testInstance, AttachedDisk: compute.AttachedDisk{Mode: diskModeRW, Source: source}, project: testProject, zone: testZone}}
+	want := fmt.Sprintf("cannot attach disk %q to instance %q in READ_WRITE mode, it is already attached to instance \"other\"", testDisk, testInstance)
+	if err := ads.run(ctx, s); err == nil || err.Error() != want {
+		t.Errorf("did not get expected error, got: %v, want: %q", err, want)
+	}
+
+	ads = &AttachDisks{{Instance: testInstance, ForceAttach: true, AttachedDisk: compute.AttachedDisk{Mode: diskModeRW, Source: source}, project: testProject, zone: testZone}}
+	if err := ads.run(ctx, s); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if !gotForceAttach || gotAttach {
+		t.Errorf("got force attach %t and attach %t, want only force attach", gotForceAttach, gotAttach)
+	}
+}
@@ -16,6 +16,7 @@ package daisy
 
 import (
 	"context"
+	"fmt"
 	"path"
 	"sync"
 
@@ -30,7 +31,11 @@ type AttachDisk struct {
 	compute.AttachedDisk
 
 	// Instance to attach to.
-	Instance      string
+	Instance string
+	// ForceAttach attaches a regional disk even if it is still attached to
+	// an instance in another zone, e.g. to fail over to the disk's other
+	// replica zone. Only valid for regional disks.
+	ForceAttach   bool `json:",omitempty"`
 	project, zone string
 }
 
@@ -40,7 +45,7 @@ func (a *AttachDisks) populate(ctx context.Context, s *Step) DError {
 		if ad.DeviceName == "" {
 			ad.DeviceName = path.Base(ad.Source)
 		}
-		if diskURLRgx.MatchString(ad.Source) {
+		if anyDiskURLRgx.MatchString(ad.Source) {
 			ad.Source = extendPartialURL(ad.Source, s.w.Project)
 		}
 	}
@@ -56,6 +61,12 @@ func (a *AttachDisks) validate(ctx context.Context, s *Step) (errs DError) {
 		if ad.Source == "" {
 			errs = addErrs(errs, Errf("cannot attach disk: AttachedDisk.Source is empty"))
 		}
+		var features []string
+		for _, f := range ad.GuestOsFeatures {
+			features = append(features, f.Type)
+		}
+		errs = addErrs(errs, validateAttachedDiskGuestOsFeatures("cannot attach disk", features, ad.Boot))
+		errs = addErrs(errs, validateDiskInterface("cannot attach disk", ad.Interface, nil))
 
 		ir, err := s.w.instances.regUse(ad.Instance, s)
 		if ir == nil {
@@ -71,18 +82,30 @@ func (a *AttachDisks) validate(ctx context.Context, s *Step) (errs DError) {
 		}
 		addErrs(errs, err)
 
-		// Ensure disk is in the same project and zone.
-		disk := NamedSubexp(diskURLRgx, dr.link)
+		// Ensure disk is in the same project and zone, or replicated to the
+		// instance zone for regional disks.
 		instance := NamedSubexp(instanceURLRgx, ir.link)
-		if disk["project"] != instance["project"] {
-			errs = addErrs(errs, Errf("cannot attach disk in project %q to instance in project %q: %q", disk["project"], instance["project"], ad.Source))
-		}
-		if disk["zone"] != instance["zone"] {
-			errs = addErrs(errs, Errf("cannot attach disk in zone %q to instance in zone %q: %q", disk["zone"], instance["zone"], ad.Source))
+		if regionalDiskURLRgx.MatchString(dr.link) {
+			disk := NamedSubexp(regionalDiskURLRgx, dr.link)
+			if disk["project"] != instance["project"] {
+				errs = addErrs(errs, Errf("cannot attach disk in project %q to instance in project %q: %q", disk["project"], instance["project"], ad.Source))
+			}
+			errs = addErrs(errs, validateRegionalDiskZone(s.w, "cannot attach disk", dr.link, instance["zone"]))
+		} else {
+			disk := NamedSubexp(diskURLRgx, dr.link)
+			if disk["project"] != instance["project"] {
+				errs = addErrs(errs, Errf("cannot attach disk in project %q to instance in project %q: %q", disk["project"], instance["project"], ad.Source))
+			}
+			if disk["zone"] != instance["zone"] {
+				errs = addErrs(errs, Errf("cannot attach disk in zone %q to instance in zone %q, zonal disks can only be attached in their zone: %q", disk["zone"], instance["zone"], ad.Source))
+			}
+			if ad.ForceAttach {
+				errs = addErrs(errs, Errf("cannot attach disk %q: ForceAttach is only supported for regional disks", ad.Source))
+			}
 		}
 
-		ad.project = disk["project"]
-		ad.zone = disk["zone"]
+		ad.project = instance["project"]
+		ad.zone = instance["zone"]
 
 		// Register disk attachments.
 		errs = addErrs(errs, s.w.instances.w.disks.regAttach(ad.Source, ad.Instance, ad.Mode, s))
@@ -110,8 +133,19 @@ func (a *AttachDisks) run(ctx context.Context, s *Step) DError {
 				ad.Instance = instRes.RealName
 			}
 
-			w.LogStepInfo(s.name, "AttachDisks", "Attaching disk %q to instance %q.", ad.AttachedDisk.Source, inst)
-			if err := w.ComputeClient.AttachDisk(ad.project, ad.zone, ad.Instance, &ad.AttachedDisk); err != nil {
+			if err := ad.checkNotAttachedElsewhere(w); err != nil {
+				e <- err
+				return
+			}
+
+			attach := w.ComputeClient.AttachDisk
+			if ad.ForceAttach {
+				attach = w.ComputeClient.ForceAttachDisk
+				w.LogStepInfo(s.name, "AttachDisks", "Force attaching disk %q to instance %q.", ad.AttachedDisk.Source, inst)
+			} else {
+				w.LogStepInfo(s.name, "AttachDisks", "Attaching disk %q to instance %q.", ad.AttachedDisk.Source, inst)
+			}
+			if err := attach(ad.project, ad.zone, ad.Instance, &ad.AttachedDisk); err != nil {
 				e <- newErr("failed to attach disk", err)
 				return
 			}
@@ -131,3 +165,34 @@ func (a *AttachDisks) run(ctx context.Context, s *Step) DError {
 		return nil
 	}
 }
+
+// checkNotAttachedElsewhere returns an error if the disk is to be attached
+// READ_WRITE but is attached to another instance. Conflicts between
+// attachments made by the workflow are caught during validation, this
+// catches disks attached outside of it. ForceAttach skips the check, the
+// disk is detached from its other users by the attach request.
+func (ad *AttachDisk) checkNotAttachedElsewhere(w *Workflow) DError {
+	if path.Base(ad.Mode) != diskModeRW || ad.ForceAttach {
+		return nil
+	}
+	var d *compute.Disk
+	var err error
+	if m := NamedSubexp(regionalDiskURLRgx, ad.Source); m != nil {
+		d, err = w.ComputeClient.GetRegionDisk(m["project"], m["region"], m["disk"])
+	} else {
+		d, err = w.ComputeClient.GetDisk(ad.project, ad.zone, path.Base(ad.Source))
+	}
+	if err != nil {
+		// Leave it to the attach request to report.
+		return nil
+	}
+	// Users are full instance URLs, ad.Instance may be a name or a URL.
+	inst := path.Base(ad.Instance)
+	want := fmt.Sprintf("projects/%s/zones/%s/instances/%s", ad.project, ad.zone, inst)
+	for _, u := range d.Users {
+		if partialURL(u) != want {
+			return Errf("cannot attach disk %q to instance %q in %s mode, it is already attached to instance %q", path.Base(ad.Source), inst, diskModeRW, path.Base(u))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sync"
+
+	"google.golang.org/api/compute/v1"
+)
+
+// CopyImages is a Daisy CopyImages workflow step.
+type CopyImages []*CopyImage
+
+// CopyImage creates a copy of an image in each of Projects, e.g. to
+// distribute a newly built image to the projects that use it. Each copy is
+// an image created in the workflow, referenced as "<Name>-<project>".
+type CopyImage struct {
+	// SourceImage is the image to copy, a workflow image name or an image
+	// partial URL.
+	SourceImage string
+	// Projects to create the copies in.
+	Projects []string
+	// Name of the copies, defaults to the name of SourceImage.
+	Name string `json:",omitempty"`
+	// Description and Family to set on the copies.
+	Description string `json:",omitempty"`
+	Family      string `json:",omitempty"`
+	// Should the copies be kept after the workflow?
+	NoCleanup bool `json:",omitempty"`
+	// If set Daisy will use this as the name of the copies instead of
+	// generating a name. Mutually exclusive with ExactName.
+	RealName string `json:",omitempty"`
+	// If set, Daisy will use Name as the name of the copies instead of
+	// generating a name. Mutually exclusive with RealName.
+	ExactName bool `json:",omitempty"`
+
+	images []*Image
+}
+
+func (c *CopyImages) populate(ctx context.Context, s *Step) DError {
+	var errs DError
+	for _, ci := range *c {
+		errs = addErrs(errs, ci.populate(ctx, s))
+	}
+	return errs
+}
+
+func (ci *CopyImage) populate(ctx context.Context, s *Step) DError {
+	if imageURLRgx.MatchString(ci.SourceImage) {
+		ci.SourceImage = s.w.imageURL(ci.SourceImage, s.w.Project)
+	}
+	ci.Name = strOr(ci.Name, path.Base(ci.SourceImage))
+	if ci.ExactName && ci.RealName != "" {
+		return Errf("cannot copy image %q: ExactName and RealName must be used mutually exclusively", ci.SourceImage)
+	}
+	realName := ci.RealName
+	if ci.ExactName {
+		realName = ci.Name
+	} else if realName == "" {
+		realName = s.w.genName(ci.Name)
+	}
+
+	var errs DError
+	ci.images = nil
+	for _, p := range ci.Projects {
+		i := &Image{
+			Image: compute.Image{
+				Name:        fmt.Sprintf("%s-%s", ci.Name, p),
+				SourceImage: ci.SourceImage,
+				Description: ci.Description,
+				Family:      ci.Family,
+			},
+			ImageBase: ImageBase{Resource: Resource{Project: p, NoCleanup: ci.NoCleanup, RealName: realName}},
+		}
+		errs = addErrs(errs, (&i.ImageBase).populate(ctx, i, s))
+		ci.images = append(ci.images, i)
+	}
+	return errs
+}
+
+func (c *CopyImages) validate(ctx context.Context, s *Step) DError {
+	var errs DError
+	for _, ci := range *c {
+		if len(ci.Projects) == 0 {
+			errs = addErrs(errs, Errf("cannot copy image %q: no Projects given", ci.SourceImage))
+		}
+		seen := map[string]bool{}
+		for _, p := range ci.Projects {
+			if seen[p] {
+				errs = addErrs(errs, Errf("cannot copy image %q: duplicate project %q", ci.SourceImage, p))
+			}
+			seen[p] = true
+		}
+		for _, i := range ci.images {
+			errs = addErrs(errs, (&i.ImageBase).validate(ctx, i, i.Licenses, s))
+		}
+	}
+	return errs
+}
+
+func (c *CopyImages) run(ctx context.Context, s *Step) DError {
+	var wg sync.WaitGroup
+	w := s.w
+	e := make(chan DError)
+	for _, ci := range *c {
+		for _, i := range ci.images {
+			wg.Add(1)
+			go func(i *Image) {
+				defer wg.Done()
+				// Get the source image link if the source is created in the workflow.
+				if image, ok := w.images.get(i.SourceImage); ok {
+					i.SourceImage = image.link
+				}
+				w.logResourceCreation(s, "CopyImages", "image", &i.Resource)
+				if err := i.create(w.ComputeClient); err != nil {
+					e <- i.wrapErr(newErr("failed to copy image", err), "image")
+					return
+				}
+				i.markCreatedInWorkflow()
+				w.LogStepInfo(s.name, "CopyImages", "Copied %s to %s.", i.SourceImage, i.link)
+			}(i)
+		}
+	}
+
+	go func() {
+		wg.Wait()
+		e <- nil
+	}()
+
+	select {
+	case err := <-e:
+		return err
+	case <-w.Cancel:
+		// Wait so images being created now can be deleted.
+		wg.Wait()
+		return nil
+	}
+}
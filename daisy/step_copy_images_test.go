@@ -0,0 +1,186 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+
+	"google.golang.org/api/compute/v1"
+)
+
+func TestCopyImagesPopulate(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	s, _ := w.NewStep("s")
+
+	tests := []struct {
+		desc                    string
+		ci                      *CopyImage
+		wantNames, wantRealName []string
+		wantSource              string
+	}{
+		{
+			"workflow image case",
+			&CopyImage{SourceImage: "src", Projects: []string{"p1", "p2"}},
+			[]string{"src-p1", "src-p2"},
+			[]string{w.genName("src"), w.genName("src")},
+			"src",
+		},
+		{
+			"partial URL case",
+			&CopyImage{SourceImage: "global/images/foo", Projects: []string{"p1"}, ExactName: true},
+			[]string{"foo-p1"},
+			[]string{"foo"},
+			fmt.Sprintf("projects/%s/global/images/foo", testProject),
+		},
+		{
+			"name and real name case",
+			&CopyImage{SourceImage: "projects/other/global/images/foo", Projects: []string{"p1"}, Name: "bar", RealName: "baz"},
+			[]string{"bar-p1"},
+			[]string{"baz"},
+			"projects/other/global/images/foo",
+		},
+	}
+
+	for _, tt := range tests {
+		c := &CopyImages{tt.ci}
+		if err := c.populate(ctx, s); err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+			continue
+		}
+		var gotNames, gotRealNames []string
+		for i, img := range tt.ci.images {
+			gotNames = append(gotNames, img.daisyName)
+			gotRealNames = append(gotRealNames, img.Name)
+			if img.SourceImage != tt.wantSource {
+				t.Errorf("%s: got SourceImage %q, want %q", tt.desc, img.SourceImage, tt.wantSource)
+			}
+			if want := fmt.Sprintf("projects/%s/global/images/%s", tt.ci.Projects[i], tt.wantRealName[i]); img.link != want {
+				t.Errorf("%s: got link %q, want %q", tt.desc, img.link, want)
+			}
+		}
+		if diffRes := diff(gotNames, tt.wantNames, 0); diffRes != "" {
+			t.Errorf("%s: names not as expected: (-got +want)\n%s", tt.desc, diffRes)
+		}
+		if diffRes := diff(gotRealNames, tt.wantRealName, 0); diffRes != "" {
+			t.Errorf("%s: real names not as expected: (-got +want)\n%s", tt.desc, diffRes)
+		}
+	}
+
+	c := &CopyImages{{SourceImage: "src", Projects: []string{"p1"}, RealName: "foo", ExactName: true}}
+	if err := c.populate(ctx, s); err == nil {
+		t.Error("ExactName and RealName: should have returned an error, but didn't")
+	}
+}
+
+func TestCopyImagesValidate(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	c, _ := newTestGCEClient()
+	c.GetProjectFn = func(string) (*compute.Project, error) { return nil, nil }
+	w.ComputeClient = c
+	srcCreator, _ := w.NewStep("srcCreator")
+	if err := w.images.regCreate("src", &Resource{link: fmt.Sprintf("projects/%s/global/images/src", w.Project)}, srcCreator, false); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		desc      string
+		ci        *CopyImage
+		shouldErr bool
+	}{
+		{"good case", &CopyImage{SourceImage: "src", Projects: []string{"p1", "p2"}}, false},
+		{"no projects case", &CopyImage{SourceImage: "src", Name: "a"}, true},
+		{"duplicate project case", &CopyImage{SourceImage: "src", Name: "b", Projects: []string{"p1", "p1"}}, true},
+		{"bad source case", &CopyImage{SourceImage: "dne", Projects: []string{"p1"}}, true},
+	}
+
+	for i, tt := range tests {
+		s, _ := w.NewStep(fmt.Sprintf("s%d", i))
+		w.AddDependency(s, srcCreator)
+		s.CopyImages = &CopyImages{tt.ci}
+		if err := s.CopyImages.populate(ctx, s); err != nil {
+			t.Errorf("%s: unexpected populate error: %v", tt.desc, err)
+			continue
+		}
+		if err := s.CopyImages.validate(ctx, s); err == nil && tt.shouldErr {
+			t.Errorf("%s: should have returned an error, but didn't", tt.desc)
+		} else if err != nil && !tt.shouldErr {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+	}
+
+	if _, ok := w.images.get("src-p1"); !ok {
+		t.Error("copy of src to p1 was not registered")
+	}
+}
+
+func TestCopyImagesRun(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	s, _ := w.NewStep("s")
+	w.images.m = map[string]*Resource{"src": {RealName: "src-abcdef", link: "projects/p/global/images/src-abcdef"}}
+
+	var mx sync.Mutex
+	var got []string
+	c, _ := newTestGCEClient()
+	c.CreateImageFn = func(p string, i *compute.Image) error {
+		if p == "bad" {
+			return errors.New("bad project")
+		}
+		if i.SourceImage != "projects/p/global/images/src-abcdef" {
+			return errors.New("bad source image: " + i.SourceImage)
+		}
+		mx.Lock()
+		defer mx.Unlock()
+		got = append(got, p+"/"+i.Name)
+		return nil
+	}
+	w.ComputeClient = c
+
+	ci := &CopyImage{SourceImage: "src", Projects: []string{"p1", "p2"}, ExactName: true}
+	cis := &CopyImages{ci}
+	if err := cis.populate(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+	if err := cis.run(ctx, s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sort.Strings(got)
+	if diffRes := diff(got, []string{"p1/src", "p2/src"}, 0); diffRes != "" {
+		t.Errorf("created images not as expected: (-got +want)\n%s", diffRes)
+	}
+	var links []string
+	for _, r := range w.GetResourceNameRecords() {
+		links = append(links, r.Link)
+	}
+	sort.Strings(links)
+	if diffRes := diff(links, []string{"projects/p1/global/images/src", "projects/p2/global/images/src"}, 0); diffRes != "" {
+		t.Errorf("recorded links not as expected: (-got +want)\n%s", diffRes)
+	}
+
+	cis = &CopyImages{{SourceImage: "src", Projects: []string{"bad"}}}
+	if err := cis.populate(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+	if err := cis.run(ctx, s); err == nil {
+		t.Error("should have returned an error, but didn't")
+	}
+}
@@ -0,0 +1,73 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"sync"
+)
+
+// CreateAddresses is a Daisy CreateAddresses workflow step.
+type CreateAddresses []*Address
+
+func (c *CreateAddresses) populate(ctx context.Context, s *Step) DError {
+	var errs DError
+	for _, a := range *c {
+		errs = addErrs(errs, a.populate(ctx, s))
+	}
+	return errs
+}
+
+func (c *CreateAddresses) validate(ctx context.Context, s *Step) DError {
+	var errs DError
+	for _, a := range *c {
+		errs = addErrs(errs, a.validate(ctx, s))
+	}
+	return errs
+}
+
+func (c *CreateAddresses) run(ctx context.Context, s *Step) DError {
+	var wg sync.WaitGroup
+	w := s.w
+	e := make(chan DError)
+	for _, a := range *c {
+		wg.Add(1)
+		go func(a *Address) {
+			defer wg.Done()
+
+			w.logResourceCreation(s, "CreateAddresses", "address", &a.Resource)
+			if err := w.ComputeClient.CreateAddressWithLabels(a.Project, a.Region, &a.Address, a.labels); err != nil {
+				e <- a.wrapErr(newErr("failed to create addresses", err), "address")
+				return
+			}
+			a.createdInWorkflow = true
+			w.recordResourceName("address", &a.Resource)
+		}(a)
+	}
+
+	go func() {
+		wg.Wait()
+		e <- nil
+	}()
+
+	select {
+	case err := <-e:
+		return err
+	case <-w.Cancel:
+		// Wait so addresses being created now can be released.
+		wg.Wait()
+		return nil
+	}
+}
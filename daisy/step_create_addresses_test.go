@@ -0,0 +1,72 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"google.golang.org/api/compute/v1"
+)
+
+func TestCreateAddressesRun(t *testing.T) {
+	ctx := context.Background()
+	e := Errf("error")
+	tests := []struct {
+		desc      string
+		clientErr error
+		wantErr   DError
+	}{
+		{"good case", nil, nil},
+		{"client error case", e, e},
+	}
+	for _, tt := range tests {
+		w := testWorkflow()
+		s, _ := w.NewStep("s")
+		var got *compute.Address
+		var gotLabels map[string]string
+		w.ComputeClient = &daisyCompute.TestClient{CreateAddressWithLabelsFn: func(p, r string, a *compute.Address, labels map[string]string) error {
+			if p != testProject || r != testRegion {
+				t.Errorf("%s: address created in project %q and region %q, want %q and %q", tt.desc, p, r, testProject, testRegion)
+			}
+			got, gotLabels = a, labels
+			return tt.clientErr
+		}}
+		ca := &CreateAddresses{{Address: compute.Address{Name: "a"}}}
+		if err := ca.populate(ctx, s); err != nil {
+			t.Fatalf("%s: unexpected populate error: %v", tt.desc, err)
+		}
+		if err := ca.run(ctx, s); !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: unexpected error returned, got: %v, want: %v", tt.desc, err, tt.wantErr)
+		}
+		want := &compute.Address{
+			Name:        w.genName("a"),
+			Region:      testRegion,
+			AddressType: defaultAddressType,
+			Description: defaultDescription("Address", w.Name, w.username),
+		}
+		if diffRes := diff(got, want, 0); diffRes != "" {
+			t.Errorf("%s: client got incorrect Address: (-got,+want)\n%s", tt.desc, diffRes)
+		}
+		if diffRes := diff(gotLabels, map[string]string{RunIDLabel: w.RunID()}, 0); diffRes != "" {
+			t.Errorf("%s: client got incorrect labels: (-got,+want)\n%s", tt.desc, diffRes)
+		}
+		if created := (*ca)[0].createdInWorkflow; created != (tt.clientErr == nil) {
+			t.Errorf("%s: got createdInWorkflow %t, want %t", tt.desc, created, tt.clientErr == nil)
+		}
+	}
+}
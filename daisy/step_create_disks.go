@@ -42,10 +42,20 @@ func (c *CreateDisks) populate(ctx context.Context, s *Step) DError {
 
 func (c *CreateDisks) validate(ctx context.Context, s *Step) DError {
 	var errs DError
+	q := quotaRequest{}
 	for _, d := range *c {
-		errs = addErrs(errs, d.validate(ctx, s))
+		if err := d.validate(ctx, s); err != nil {
+			errs = addErrs(errs, err)
+			continue
+		}
+		q.addCreateDisk(s.w, d)
 	}
-	return errs
+	if errs != nil {
+		return errs
+	}
+
+	// Fail early rather than leaving a partially created batch of disks.
+	return q.check(s.w)
 }
 
 func (c *CreateDisks) run(ctx context.Context, s *Step) DError {
@@ -64,19 +74,35 @@ func (c *CreateDisks) run(ctx context.Context, s *Step) DError {
 				}
 			}
 
-			w.LogStepInfo(s.name, "CreateDisks", "Creating disk %q.", cd.Name)
-			if err := w.ComputeClient.CreateDisk(cd.Project, cd.Zone, &cd.Disk); err != nil {
+			if w.canAdopt(&cd.Resource) {
+				adopted, err := cd.adoptExisting(w)
+				if err != nil {
+					e <- cd.wrapErr(err, "disk")
+					return
+				}
+				if adopted {
+					w.LogStepInfo(s.name, "CreateDisks", "Adopting existing disk %q.", cd.Name)
+					w.recordResourceName("disk", &cd.Resource)
+					cd.createdInWorkflow = true
+					return
+				}
+			}
+
+			w.logResourceCreation(s, "CreateDisks", "disk", &cd.Resource)
+			attrs := map[string]string{"daisy.disk.name": cd.Name, "daisy.disk.zone": cd.Zone}
+			create := func() error { return cd.create(w.ComputeClient) }
+			if err := w.traceCall(ctx, "compute.disks.insert", attrs, create); err != nil {
 				// Fallback to pd-standard to avoid quota issue.
 				if cd.FallbackToPdStandard && strings.HasSuffix(cd.Type, pdSsd) && isQuotaExceeded(err) {
 					w.LogStepInfo(s.name, "CreateDisks", "Falling back to pd-standard for disk %v. "+
 						"It may be caused by insufficient pd-ssd quota. Consider increasing pd-ssd quota to "+
 						"avoid using ps-standard for better performance.", cd.Name)
 					cd.Type = strings.TrimRight(cd.Type, pdSsd) + pdStandard
-					err = w.ComputeClient.CreateDisk(cd.Project, cd.Zone, &cd.Disk)
+					err = w.traceCall(ctx, "compute.disks.insert", attrs, create)
 				}
 
 				if err != nil {
-					e <- newErr("failed to create disk", err)
+					e <- cd.wrapErr(newErr("failed to create disk", err), "disk")
 					return
 				}
 			}
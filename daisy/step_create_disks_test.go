@@ -16,10 +16,14 @@ package daisy
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net/http"
 	"testing"
 
 	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
 	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
 )
 
 func TestCreateDisksRun(t *testing.T) {
@@ -58,7 +62,7 @@ func TestCreateDisksRun(t *testing.T) {
 		}
 		w.ComputeClient = &daisyCompute.TestClient{CreateDiskFn: fake}
 		cds := &CreateDisks{{Disk: tt.d, FallbackToPdStandard: tt.fallbackToPdStandard}}
-		if err := cds.run(ctx, s); err != tt.wantErr {
+		if err := cds.run(ctx, s); !errors.Is(err, tt.wantErr) {
 			t.Errorf("%s: unexpected error returned, got: %v, want: %v", tt.desc, err, tt.wantErr)
 		}
 		if diffRes := diff(gotD, tt.wantD, 0); diffRes != "" {
@@ -66,3 +70,94 @@ func TestCreateDisksRun(t *testing.T) {
 		}
 	}
 }
+
+func TestCreateDisksRunProvisionedPerformance(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	s := &Step{w: w}
+
+	var gotIops, gotThroughput int64
+	var usedCreateDisk bool
+	w.ComputeClient = &daisyCompute.TestClient{
+		CreateDiskFn: func(_, _ string, _ *compute.Disk) error {
+			usedCreateDisk = true
+			return nil
+		},
+		CreateDiskWithProvisionedPerformanceFn: func(_, _ string, _ *compute.Disk, iops, throughput int64) error {
+			gotIops, gotThroughput = iops, throughput
+			return nil
+		},
+	}
+	cds := &CreateDisks{{Disk: compute.Disk{Type: "prefix/hyperdisk-balanced"}, provisionedIops: 10000, provisionedThroughput: 500}}
+	if err := cds.run(ctx, s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if usedCreateDisk || gotIops != 10000 || gotThroughput != 500 {
+		t.Errorf("disk not created with provisioned performance, CreateDisk used: %t, got IOPS: %d, throughput: %d", usedCreateDisk, gotIops, gotThroughput)
+	}
+}
+
+func TestCreateDisksRunResourceManagerTags(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	s := &Step{w: w}
+
+	var got daisyCompute.DiskOptions
+	w.ComputeClient = &daisyCompute.TestClient{
+		CreateDiskWithOptionsFn: func(_, _ string, _ *compute.Disk, opts daisyCompute.DiskOptions) error {
+			got = opts
+			return nil
+		},
+	}
+	tags := map[string]string{"tagKeys/123": "tagValues/456"}
+	cds := &CreateDisks{{Disk: compute.Disk{Type: "prefix/hyperdisk-balanced"}, provisionedIops: 10000, ResourceManagerTags: tags}}
+	if err := cds.run(ctx, s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diffRes := diff(got, daisyCompute.DiskOptions{ProvisionedIops: 10000, ResourceManagerTags: tags}, 0); diffRes != "" {
+		t.Errorf("disk not created with the expected options: (-got,+want)\n%s", diffRes)
+	}
+}
+
+func TestCreateDisksRunAdoptExisting(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	w.AdoptExistingResources = true
+	s := &Step{w: w}
+	dt := fmt.Sprintf("projects/%s/zones/%s/diskTypes/pd-ssd", testProject, testZone)
+
+	tests := []struct {
+		desc        string
+		exactName   bool
+		existing    *compute.Disk
+		getErr      error
+		wantCreated bool
+		shouldErr   bool
+	}{
+		{"matching disk case", true, &compute.Disk{Type: dt, SizeGb: 10}, nil, false, false},
+		{"missing disk case", true, nil, &googleapi.Error{Code: http.StatusNotFound}, true, false},
+		{"mismatched disk case", true, &compute.Disk{Type: dt, SizeGb: 20}, nil, false, true},
+		{"get error case", true, nil, errors.New("error"), false, true},
+		{"no ExactName case", false, &compute.Disk{Type: dt, SizeGb: 10}, nil, true, false},
+	}
+	for _, tt := range tests {
+		var created bool
+		w.ComputeClient = &daisyCompute.TestClient{
+			CreateDiskFn: func(_, _ string, _ *compute.Disk) error { created = true; return nil },
+			GetDiskFn:    func(_, _, _ string) (*compute.Disk, error) { return tt.existing, tt.getErr },
+		}
+		d := &Disk{Disk: compute.Disk{Name: "d", Type: dt, SizeGb: 10}, Resource: Resource{ExactName: tt.exactName}}
+		err := (&CreateDisks{d}).run(ctx, s)
+		if tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+		if created != tt.wantCreated {
+			t.Errorf("%s: disk created: got %t, want %t", tt.desc, created, tt.wantCreated)
+		}
+		if !tt.shouldErr && !d.createdInWorkflow {
+			t.Errorf("%s: disk should be marked as created in workflow", tt.desc)
+		}
+	}
+}
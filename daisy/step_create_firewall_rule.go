@@ -51,9 +51,9 @@ func (c *CreateFirewallRules) run(ctx context.Context, s *Step) DError {
 				fir.Network = networkRes.link
 			}
 
-			w.LogStepInfo(s.name, "CreateFirewallRules", "Creating firewall rule %q.", fir.Name)
+			w.logResourceCreation(s, "CreateFirewallRules", "firewall rule", &fir.Resource)
 			if err := w.ComputeClient.CreateFirewallRule(fir.Project, &fir.Firewall); err != nil {
-				e <- newErr("failed to create firewall", err)
+				e <- fir.wrapErr(newErr("failed to create firewall", err), "firewall rule")
 				return
 			}
 			fir.createdInWorkflow = true
@@ -16,6 +16,7 @@ package daisy
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"testing"
 
@@ -104,7 +105,7 @@ func TestCreateFirewallRulesRun(t *testing.T) {
 		w.ComputeClient = &daisyCompute.TestClient{CreateFirewallRuleFn: fake}
 		cds := &CreateFirewallRules{{Firewall: tt.n}}
 		cds.populate(ctx, s)
-		if err := cds.run(ctx, s); err != tt.wantErr {
+		if err := cds.run(ctx, s); !errors.Is(err, tt.wantErr) {
 			t.Errorf("%s: unexpected error returned, got: %v, want: %v", tt.desc, err, tt.wantErr)
 		}
 		if diff := pretty.Compare(gotN, tt.wantN); diff != "" {
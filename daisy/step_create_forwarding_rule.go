@@ -47,9 +47,9 @@ func (c *CreateForwardingRules) run(ctx context.Context, s *Step) DError {
 		go func(fr *ForwardingRule) {
 			defer wg.Done()
 
-			w.LogStepInfo(s.name, "CreateForwardingRules", "Creating forwarding-rule %q.", fr.Name)
-			if err := w.ComputeClient.CreateForwardingRule(fr.Project, fr.Region, &fr.ForwardingRule); err != nil {
-				e <- newErr("failed to create forwarding rules", err)
+			w.logResourceCreation(s, "CreateForwardingRules", "forwarding-rule", &fr.Resource)
+			if err := w.ComputeClient.CreateForwardingRuleWithLabels(fr.Project, fr.Region, &fr.ForwardingRule, fr.labels); err != nil {
+				e <- fr.wrapErr(newErr("failed to create forwarding rules", err), "forwarding rule")
 				return
 			}
 			fr.createdInWorkflow = true
@@ -16,6 +16,7 @@ package daisy
 
 import (
 	"context"
+	"errors"
 	"testing"
 
 	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
@@ -48,15 +49,22 @@ func TestCreateForwardingRulesRun(t *testing.T) {
 
 	for _, tt := range tests {
 		var gotN compute.ForwardingRule
-		fake := func(_, _ string, n *compute.ForwardingRule) error { gotN = *n; return tt.clientErr }
-		w.ComputeClient = &daisyCompute.TestClient{CreateForwardingRuleFn: fake}
+		var gotLabels map[string]string
+		fake := func(_, _ string, n *compute.ForwardingRule, labels map[string]string) error {
+			gotN, gotLabels = *n, labels
+			return tt.clientErr
+		}
+		w.ComputeClient = &daisyCompute.TestClient{CreateForwardingRuleWithLabelsFn: fake}
 		cds := &CreateForwardingRules{{ForwardingRule: tt.n}}
 		cds.populate(ctx, s)
-		if err := cds.run(ctx, s); err != tt.wantErr {
+		if err := cds.run(ctx, s); !errors.Is(err, tt.wantErr) {
 			t.Errorf("%s: unexpected error returned, got: %v, want: %v", tt.desc, err, tt.wantErr)
 		}
 		if diff := pretty.Compare(gotN, tt.wantN); diff != "" {
 			t.Errorf("%s: client got incorrect ForwardingRule, diff: %s", tt.desc, diff)
 		}
+		if diff := pretty.Compare(gotLabels, map[string]string{RunIDLabel: w.RunID()}); diff != "" {
+			t.Errorf("%s: client got incorrect labels, diff: %s", tt.desc, diff)
+		}
 	}
 }
@@ -79,6 +79,7 @@ func (ci *CreateImages) validate(ctx context.Context, s *Step) DError {
 	} else {
 		for _, i := range ci.Images {
 			errs = addErrs(errs, (&i.ImageBase).validate(ctx, i, i.Licenses, s))
+			errs = addErrs(errs, i.validateArchitecture(s))
 		}
 	}
 
@@ -90,41 +91,74 @@ func (ci *CreateImages) run(ctx context.Context, s *Step) DError {
 	w := s.w
 	e := make(chan DError)
 
-	createImage := func(ci ImageInterface, overwrite bool) {
+	createImage := func(ci ImageInterface, ib *ImageBase) {
 		defer wg.Done()
+		r := &ib.Resource
+		if ib.SourceInstance != "" {
+			if err := ib.useSourceInstanceBootDisk(ci, s); err != nil {
+				e <- r.wrapErr(err, "image")
+				return
+			}
+		}
 		// Get source disk link if SourceDisk is a daisy reference to a disk.
 		if d, ok := w.disks.get(ci.getSourceDisk()); ok {
 			ci.setSourceDisk(d.link)
 		}
+		// A SourceSnapshot name is a snapshot created by an earlier step.
+		if snap := ci.getSourceSnapshot(); rfc1035Rgx.MatchString(snap) {
+			sn, ok := w.snapshots.get(snap)
+			if !ok {
+				e <- r.wrapErr(Errf("source snapshot %q was not created by the workflow", snap), "image")
+				return
+			}
+			ci.setSourceSnapshot(sn.link)
+		}
+		switch ib.SourceDiskConsistency {
+		case sourceDiskRequireStopped:
+			if err := ib.checkSourceDiskStopped(ci, s); err != nil {
+				e <- r.wrapErr(err, "image")
+				return
+			}
+		case sourceDiskGuestFlush:
+			deleteSnapshot, err := ib.useGuestFlushedSnapshot(ci, s)
+			if err != nil {
+				e <- r.wrapErr(err, "image")
+				return
+			}
+			defer deleteSnapshot()
+		}
 
 		// Delete existing if OverWrite is true.
-		if overwrite {
+		if ib.OverWrite {
 			// Just try to delete it, a 404 here indicates the image doesn't exist.
 			if err := ci.delete(w.ComputeClient); err != nil {
 				if apiErr, ok := err.(*googleapi.Error); !ok || apiErr.Code != 404 {
-					e <- Errf("error deleting existing image: %v", err)
+					e <- r.wrapErr(Errf("error deleting existing image: %v", err), "image")
 					return
 				}
 			}
 		}
 
-		w.LogStepInfo(s.name, "CreateImages", "Creating image %q.", ci.getName())
+		w.logResourceCreation(s, "CreateImages", "image", r)
 		if err := ci.create(w.ComputeClient); err != nil {
-			e <- newErr("failed to create images", err)
+			e <- r.wrapErr(newErr("failed to create images", err), "image")
 			return
 		}
 		ci.markCreatedInWorkflow()
+		if ib.DeprecatePrevious != nil {
+			w.addImageDeprecation(s, ib.DeprecatePrevious, r)
+		}
 	}
 
 	if imageUsesBetaFeatures(ci.ImagesBeta) {
 		for _, i := range ci.ImagesBeta {
 			wg.Add(1)
-			go createImage(i, i.OverWrite)
+			go createImage(i, &i.ImageBase)
 		}
 	} else {
 		for _, i := range ci.Images {
 			wg.Add(1)
-			go createImage(i, i.OverWrite)
+			go createImage(i, &i.ImageBase)
 		}
 	}
 
@@ -16,8 +16,12 @@ package daisy
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"reflect"
 	"testing"
 
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
 	computeBeta "google.golang.org/api/compute/v0.beta"
 	"google.golang.org/api/compute/v1"
 )
@@ -57,3 +61,101 @@ func TestCreateImagesRun(t *testing.T) {
 		}
 	}
 }
+
+func TestCreateImagesRunWorkflowSnapshot(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	s := &Step{w: w}
+	link := fmt.Sprintf("projects/%s/global/snapshots/%s", testProject, w.genName("build-data"))
+	w.snapshots.m = map[string]*Resource{"build-data": {RealName: w.genName("build-data"), link: link}}
+	var got string
+	w.ComputeClient.(*daisyCompute.TestClient).CreateImageFn = func(_ string, i *compute.Image) error {
+		got = i.SourceSnapshot
+		return nil
+	}
+
+	ci := &Image{ImageBase: ImageBase{Resource: Resource{Project: testProject}}, Image: compute.Image{Name: testImage, SourceSnapshot: "build-data"}}
+	if err := (&CreateImages{Images: []*Image{ci}}).run(ctx, s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != link {
+		t.Errorf("image created from snapshot %q, want %q", got, link)
+	}
+
+	// The SnapshotInstanceDisks step didn't snapshot a disk with that name.
+	ci = &Image{ImageBase: ImageBase{Resource: Resource{Project: testProject}}, Image: compute.Image{Name: testImage, SourceSnapshot: "build-dne"}}
+	if err := (&CreateImages{Images: []*Image{ci}}).run(ctx, s); err == nil {
+		t.Error("should have returned an error")
+	}
+}
+
+func TestCreateImagesRunDeprecatePrevious(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	s := &Step{w: w}
+	w.disks.m = map[string]*Resource{testDisk: {RealName: w.genName(testDisk), link: testDisk}}
+	var gotProject, gotImage string
+	var gotStatus *compute.DeprecationStatus
+	w.ComputeClient.(*daisyCompute.TestClient).DeprecateImageFn = func(project, image string, status *compute.DeprecationStatus) error {
+		gotProject, gotImage, gotStatus = project, image, status
+		return nil
+	}
+
+	tests := []struct {
+		desc, state, wantReplacement string
+		noCleanup                    bool
+	}{
+		{"deprecated case", "DEPRECATED", fmt.Sprintf("projects/%s/global/images/%s", testProject, testImage), true},
+		{"active case", "ACTIVE", "", true},
+		{"image cleaned up case", "DEPRECATED", "", false},
+	}
+	for _, tt := range tests {
+		gotStatus = nil
+		w.imageDeprecations = nil
+		ci := &Image{
+			ImageBase: ImageBase{
+				Resource:          Resource{Project: testProject, NoCleanup: tt.noCleanup, creator: s, link: fmt.Sprintf("projects/%s/global/images/%s", testProject, testImage)},
+				DeprecatePrevious: &PreviousImageDeprecation{Image: "old", State: tt.state, link: "projects/other-project/global/images/old"},
+			},
+			Image: compute.Image{Name: testImage, SourceDisk: testDisk},
+		}
+		if err := (&CreateImages{Images: []*Image{ci}}).run(ctx, s); err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+			continue
+		}
+		// The previous image is only deprecated once the workflow succeeds.
+		if gotStatus != nil {
+			t.Errorf("%s: previous image deprecated when the image was created", tt.desc)
+		}
+		if err := w.deprecatePreviousImages(); err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+			continue
+		}
+		if !tt.noCleanup {
+			if gotStatus != nil {
+				t.Errorf("%s: previous image deprecated though the image is cleaned up", tt.desc)
+			}
+			continue
+		}
+		want := &compute.DeprecationStatus{State: tt.state, Replacement: tt.wantReplacement}
+		if gotProject != "other-project" || gotImage != "old" || !reflect.DeepEqual(gotStatus, want) {
+			t.Errorf("%s: got deprecation of projects/%s/global/images/%s to %+v, want projects/other-project/global/images/old to %+v", tt.desc, gotProject, gotImage, gotStatus, want)
+		}
+	}
+
+	// A failure to deprecate the previous image fails the workflow.
+	w.imageDeprecations = nil
+	w.ComputeClient.(*daisyCompute.TestClient).DeprecateImageFn = func(_, _ string, _ *compute.DeprecationStatus) error {
+		return errors.New("fail")
+	}
+	ci := &Image{
+		ImageBase: ImageBase{Resource: Resource{Project: testProject, NoCleanup: true, creator: s}, DeprecatePrevious: &PreviousImageDeprecation{Image: "old", State: "DEPRECATED", link: "projects/other-project/global/images/old"}},
+		Image:     compute.Image{Name: testImage, SourceDisk: testDisk},
+	}
+	if err := (&CreateImages{Images: []*Image{ci}}).run(ctx, s); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := w.deprecatePreviousImages(); err == nil {
+		t.Error("should have returned an error")
+	}
+}
@@ -19,12 +19,15 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"path"
+	"regexp"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
 	"google.golang.org/api/googleapi"
 )
 
@@ -51,24 +54,370 @@ func (ci *CreateInstances) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
-func logSerialOutput(ctx context.Context, s *Step, ii InstanceInterface, ib *InstanceBase, port int64, interval time.Duration) {
+// serialTimeoutTailLines is how many lines of serial port output are logged
+// when a workflow times out while an instance is still RUNNING.
+const serialTimeoutTailLines = 20
+
+// combinedSerialLogObj is the object in the root workflow's logs directory
+// the combined serial log is written to.
+const combinedSerialLogObj = "combined-serial.log"
+
+// serialLineWriter writes serial port output to out one line at a time, each
+// prefixed with the instance and port, so that the output of instances
+// logging concurrently stays readable. A trailing partial line is held until
+// it's completed or flushed.
+type serialLineWriter struct {
+	out    func([]byte) error
+	prefix string
+	buf    []byte
+}
+
+// newSerialLineWriter returns a serialLineWriter to the root workflow's
+// serialStdout, nil if serial output isn't echoed to stdout.
+func newSerialLineWriter(w *Workflow, instance string, port int64) *serialLineWriter {
+	root := w.rootWorkflow()
+	if root.serialStdout == nil {
+		return nil
+	}
+	return &serialLineWriter{out: root.writeSerialStdout, prefix: serialLinePrefix(instance, port)}
+}
+
+// newCombinedSerialLineWriter returns a serialLineWriter to the root
+// workflow's combined serial log, nil if CombinedSerialLog isn't set.
+func newCombinedSerialLineWriter(w *Workflow, instance string, port int64) *serialLineWriter {
+	root := w.rootWorkflow()
+	if !root.CombinedSerialLog {
+		return nil
+	}
+	return &serialLineWriter{out: root.writeCombinedSerialLog, prefix: serialLinePrefix(instance, port)}
+}
+
+func serialLinePrefix(instance string, port int64) string {
+	return fmt.Sprintf("[%s serial-port%d]: ", instance, port)
+}
+
+func (lw *serialLineWriter) Write(b []byte) (int, error) {
+	lw.buf = append(lw.buf, b...)
+	i := bytes.LastIndexByte(lw.buf, '\n')
+	if i < 0 {
+		return len(b), nil
+	}
+	var out bytes.Buffer
+	for _, l := range bytes.SplitAfter(lw.buf[:i+1], []byte("\n")) {
+		if len(l) > 0 {
+			out.WriteString(lw.prefix)
+			out.Write(l)
+		}
+	}
+	lw.buf = append([]byte(nil), lw.buf[i+1:]...)
+	return len(b), lw.out(out.Bytes())
+}
+
+// flush writes the held partial line, if any.
+func (lw *serialLineWriter) flush() error {
+	if len(lw.buf) == 0 {
+		return nil
+	}
+	line := lw.prefix + string(lw.buf) + "\n"
+	lw.buf = nil
+	return lw.out([]byte(line))
+}
+
+func (w *Workflow) writeSerialStdout(b []byte) error {
+	w.serialStdoutMx.Lock()
+	defer w.serialStdoutMx.Unlock()
+	_, err := w.serialStdout.Write(b)
+	return err
+}
+
+// combinedSerialLogInterval is how often the combined serial log is
+// uploaded to its GCS object.
+var combinedSerialLogInterval = 3 * time.Second
+
+// writeCombinedSerialLog appends b to the combined serial log, which
+// streamCombinedSerialLog uploads.
+func (w *Workflow) writeCombinedSerialLog(b []byte) error {
+	w.combinedSerialLogMx.Lock()
+	defer w.combinedSerialLogMx.Unlock()
+	w.combinedSerialLog.Write(b)
+	return nil
+}
+
+// streamCombinedSerialLog uploads the combined serial log to its GCS object,
+// as for the per-instance logs, every combinedSerialLogInterval until ctx is
+// done or the returned function is called. That function uploads the log one
+// last time, once all the instances' output has been written to it.
+func (w *Workflow) streamCombinedSerialLog(ctx context.Context) func() {
+	logsObj := path.Join(w.logsPath, combinedSerialLogObj)
+	w.LogWorkflowInfo("Streaming combined serial port output to https://storage.cloud.google.com/%s/%s", w.bucket, logsObj)
+	w.combinedSerialLogObj = newSerialLogObject(w, logsObj, w.LogsObjectMetadata)
+	var gcsErr bool
+	flush := func(ctx context.Context) {
+		w.combinedSerialLogMx.Lock()
+		log := append([]byte(nil), w.combinedSerialLog.Bytes()...)
+		w.combinedSerialLogMx.Unlock()
+		if err := w.combinedSerialLogObj.sync(ctx, log); err != nil {
+			if !gcsErr {
+				gcsErr = true
+				w.LogWorkflowInfo("Error writing combined serial log to GCS: %v", err)
+			}
+			return
+		}
+		gcsErr = false
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(combinedSerialLogInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				flush(ctx)
+			case <-ctx.Done():
+				return
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+		saveCtx := ctx
+		if ctx.Err() != nil {
+			var cancel context.CancelFunc
+			saveCtx, cancel = context.WithTimeout(context.Background(), logFlushTimeout)
+			defer cancel()
+		}
+		flush(saveCtx)
+	}
+}
+
+// serialTimestampFormat is the ISO-8601 format of the time prefixed to each
+// serial port log line if SerialLogTimestamps is set.
+const serialTimestampFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// serialTimestamper prefixes lines of serial port output with the time they
+// were received. Output arrives in chunks that may split lines, so a trailing
+// partial line is held until it's completed or flushed, and stamped with the
+// time its first part was received.
+type serialTimestamper struct {
+	buf   string
+	first time.Time
+}
+
+// stamp returns the lines completed by contents, each prefixed with its
+// receive time.
+func (ts *serialTimestamper) stamp(contents string, now time.Time) string {
+	if contents == "" {
+		return ""
+	}
+	if ts.buf == "" {
+		ts.first = now
+	}
+	ts.buf += contents
+	i := strings.LastIndexByte(ts.buf, '\n')
+	if i < 0 {
+		return ""
+	}
+	var out strings.Builder
+	for n, l := range strings.SplitAfter(ts.buf[:i+1], "\n") {
+		if l == "" {
+			continue
+		}
+		t := now
+		if n == 0 {
+			t = ts.first
+		}
+		out.WriteString(t.UTC().Format(serialTimestampFormat) + " " + l)
+	}
+	ts.buf = ts.buf[i+1:]
+	ts.first = now
+	return out.String()
+}
+
+// flush returns the held partial line, if any, prefixed with its receive
+// time.
+func (ts *serialTimestamper) flush() string {
+	if ts.buf == "" {
+		return ""
+	}
+	l := ts.first.UTC().Format(serialTimestampFormat) + " " + ts.buf
+	ts.buf = ""
+	return l
+}
+
+// logSerialOutput streams the serial port output of an instance until it stops
+// or the workflow is canceled. It returns an error if the instance stopped
+// abnormally, i.e. before its first serial port produced any output. The
+// error only fails the step if the instance has a StartupTimeout, otherwise
+// it's logged and ends the instance's span. If the workflow timed out, the
+// output is read one last time and, if the instance is still RUNNING, its
+// last lines are logged to help diagnose the hang.
+func logSerialOutput(ctx context.Context, s *Step, ii InstanceInterface, ib *InstanceBase, port int64, interval time.Duration) DError {
+	return streamSerialOutput(ctx, s, ii, ib, port, interval, fmt.Sprintf("%s-serial-port%d.log", ii.getName(), port), ib.serialStarted)
+}
+
+// streamSerialOutput is logSerialOutput, saving the output to the log object
+// named logName. The first port signals serialStarted, if not nil, once it
+// has output or within StartupTimeout.
+func streamSerialOutput(ctx context.Context, s *Step, ii InstanceInterface, ib *InstanceBase, port int64, interval time.Duration, logName string, serialStarted chan DError) DError {
 	w := s.w
-	w.stepWait.Add(1)
-	defer w.stepWait.Done()
+	var stopErr DError
+
+	// Only the first port is held to StartupTimeout, others may never have
+	// any output. The waiting step is signaled however streaming ends, an
+	// instance whose output can't be streamed is taken to have started.
+	var started chan DError
+	var startup <-chan time.Time
+	if serialStarted != nil && port == ib.SerialPorts[0] {
+		started = serialStarted
+		startup = time.After(ib.startupTimeout)
+	}
+	signalStarted := func(err DError) {
+		if started != nil {
+			started <- err
+			started, startup = nil, nil
+		}
+	}
+	defer func() { signalStarted(stopErr) }()
 
-	logsObj := path.Join(w.logsPath, fmt.Sprintf("%s-serial-port%d.log", ii.getName(), port))
-	w.LogStepInfo(s.name, "CreateInstances", "Streaming instance %q serial port %d output to https://storage.cloud.google.com/%s/%s", ii.getName(), port, w.bucket, logsObj)
+	var sw io.WriteCloser
+	logsObj := path.Join(w.logsPath, logName)
+	link := "gs://" + path.Join(w.bucket, logsObj)
+	if w.SerialLogWriter != nil {
+		var err error
+		if sw, err = w.SerialLogWriter(ii.getName(), port); err != nil {
+			w.LogStepInfo(s.name, "CreateInstances", "Instance %q: error creating serial port %d log writer: %v", ii.getName(), port, err)
+			return nil
+		}
+		defer func() {
+			if err := sw.Close(); err != nil {
+				w.LogStepInfo(s.name, "CreateInstances", "Instance %q: error closing serial port %d log writer: %v", ii.getName(), port, err)
+			}
+		}()
+		w.LogStepInfo(s.name, "CreateInstances", "Streaming instance %q serial port %d output.", ii.getName(), port)
+		link = ""
+	} else {
+		w.LogStepInfo(s.name, "CreateInstances", "Streaming instance %q serial port %d output to https://storage.cloud.google.com/%s/%s", ii.getName(), port, w.bucket, logsObj)
+	}
 	var start int64
 	var buf bytes.Buffer
-	var gcsErr bool
+	// received counts the bytes of output read, which buf holds more of if
+	// lines are timestamped.
+	var received int64
+	var ts *serialTimestamper
+	if w.SerialLogTimestamps {
+		ts = &serialTimestamper{}
+	}
+	var gcsErr, writerErr bool
 	var readFromSerial bool
 	var numErr int
+	var repairing bool
+	var tail string
+	reason := SerialLogEndError
 	tick := time.Tick(interval)
 
+	var objMetadata map[string]string
+	if len(w.LogsObjectMetadata) > 0 || len(w.SerialLogMetadata) > 0 || len(ib.SerialLogMetadata) > 0 {
+		objMetadata = map[string]string{}
+		for k, v := range w.LogsObjectMetadata {
+			objMetadata[k] = v
+		}
+		for k, v := range w.SerialLogMetadata {
+			objMetadata[k] = v
+		}
+		for k, v := range ib.SerialLogMetadata {
+			objMetadata[k] = v
+		}
+	}
+
+	var teeErr bool
+	tee := newSerialLineWriter(w, ii.getName(), port)
+	if tee != nil {
+		defer func() {
+			if err := tee.flush(); err != nil && !teeErr {
+				w.LogStepInfo(s.name, "CreateInstances", "Instance %q: error writing serial port %d output to stdout: %v", ii.getName(), port, err)
+			}
+		}()
+	}
+
+	var combinedErr bool
+	combined := newCombinedSerialLineWriter(w, ii.getName(), port)
+	if combined != nil {
+		defer func() {
+			if err := combined.flush(); err != nil && !combinedErr {
+				w.LogStepInfo(s.name, "CreateInstances", "Instance %q: error writing serial port %d output to the combined serial log: %v", ii.getName(), port, err)
+			}
+		}()
+	}
+
+	// store appends contents to the serial port log, streaming it to the
+	// custom writer if there is one and uploading it to the GCS log object
+	// otherwise, within MaxSerialLogWriters. Failed GCS uploads are retried
+	// with the next output, a failure is logged once until an upload works.
+	saveCtx := ctx
+	gcsObj := newSerialLogObject(w, logsObj, objMetadata)
+	store := func(contents string) {
+		buf.WriteString(contents)
+		if sw != nil {
+			if _, err := io.WriteString(sw, contents); err != nil && !writerErr {
+				writerErr = true
+				w.LogStepInfo(s.name, "CreateInstances", "Instance %q: error writing serial port %d log: %v", ii.getName(), port, err)
+			}
+			return
+		}
+		if err := gcsObj.sync(saveCtx, buf.Bytes()); err != nil {
+			if !gcsErr {
+				gcsErr = true
+				w.LogStepInfo(s.name, "CreateInstances", "Instance %q: error writing log to GCS: %v", ii.getName(), err)
+			}
+			return
+		}
+		gcsErr = false
+	}
+
+	// save stores contents, timestamping its lines if SerialLogTimestamps is
+	// set. It's also echoed to stdout if enabled and written to the combined
+	// serial log if CombinedSerialLog is set.
+	save := func(contents string) {
+		if contents != "" && received == 0 {
+			w.recordInstanceTime(ii.getName(), func(r *InstanceTimeRecord) {
+				if r.FirstSerialOutputTime.IsZero() {
+					r.FirstSerialOutputTime = time.Now()
+				}
+			})
+		}
+		received += int64(len(contents))
+		if tee != nil {
+			if _, err := io.WriteString(tee, contents); err != nil && !teeErr {
+				teeErr = true
+				w.LogStepInfo(s.name, "CreateInstances", "Instance %q: error writing serial port %d output to stdout: %v", ii.getName(), port, err)
+			}
+		}
+		if combined != nil {
+			if _, err := io.WriteString(combined, contents); err != nil && !combinedErr {
+				combinedErr = true
+				w.LogStepInfo(s.name, "CreateInstances", "Instance %q: error writing serial port %d output to the combined serial log: %v", ii.getName(), port, err)
+			}
+		}
+		if ts != nil {
+			contents = ts.stamp(contents, time.Now())
+		}
+		store(contents)
+	}
+
 Loop:
 	for {
 		select {
 		case <-tick:
+			if !w.waitSerialPoll() {
+				reason = SerialLogEndCanceled
+				break Loop
+			}
 			resp, err := w.ComputeClient.GetSerialPortOutput(path.Base(ib.Project), path.Base(ii.getZone()), ii.getName(), port, start)
 			if err != nil {
 				numErr++
@@ -77,8 +426,46 @@ Loop:
 				case "TERMINATED", "STOPPED", "STOPPING":
 					// Instance is stopped or stopping.
 					if sErr == nil {
+						// Output written just before shutdown may not be readable
+						// until after GCE reports the instance as stopped, so read
+						// one last time after the grace period.
+						select {
+						case <-time.After(ib.serialShutdownGracePeriod):
+						case <-w.Cancel:
+						}
+						w.waitSerialPoll()
+						if resp, err := w.ComputeClient.GetSerialPortOutput(path.Base(ib.Project), path.Base(ii.getZone()), ii.getName(), port, start); err == nil && resp.Contents != "" {
+							readFromSerial = true
+							save(resp.Contents)
+						}
+						recordInstanceStopped(w, ii.getName())
+						if ii.isPreemptible() && status == "TERMINATED" {
+							stopErr = s.preemptedErr("CreateInstances", path.Base(ib.Project), path.Base(ii.getZone()), ii.getName())
+						}
+						if stopErr != nil {
+							reason = SerialLogEndPreempted
+						} else if !readFromSerial && (len(ib.SerialPorts) == 0 || port == ib.SerialPorts[0]) {
+							stopErr = neverStartedErr(w, ii, ib)
+						} else {
+							reason = SerialLogEndStopped
+						}
 						break Loop
 					}
+				case "REPAIRING":
+					// GCE is recovering the instance from a host failure, it
+					// comes back RUNNING or ends up TERMINATED.
+					if !repairing {
+						repairing = true
+						w.LogStepInfo(s.name, "CreateInstances", "Instance %q is being repaired after a host failure, waiting for it.", ii.getName())
+					}
+					numErr = 0
+					continue
+				case "SUSPENDING", "SUSPENDED":
+					// A suspended instance has no output until it's resumed,
+					// which Daisy doesn't do.
+					w.LogStepInfo(s.name, "CreateInstances", "WARNING: Instance %q is %s, no longer streaming serial port %d output.", ii.getName(), status, port)
+					reason = SerialLogEndSuspended
+					break Loop
 				}
 				if numErr > 10 {
 					// Only emit an error log if we were able to read *some* data from the
@@ -92,32 +479,284 @@ Loop:
 				}
 				continue
 			}
-			readFromSerial = true
+			if resp.Next < start {
+				// The serial output was reset, usually by a reboot, so start
+				// points past its end. Reread the new output from the beginning.
+				w.LogStepInfo(s.name, "CreateInstances", "Instance %q: serial port %d output was reset, the instance may have rebooted.", ii.getName(), port)
+				start = 0
+				w.waitSerialPoll()
+				if resp, err = w.ComputeClient.GetSerialPortOutput(path.Base(ib.Project), path.Base(ii.getZone()), ii.getName(), port, start); err != nil {
+					continue
+				}
+			}
+			if resp.Contents != "" {
+				readFromSerial = true
+			}
 			numErr = 0
+			repairing = false
 			start = resp.Next
-			buf.WriteString(resp.Contents)
-			wc := w.StorageClient.Bucket(w.bucket).Object(logsObj).NewWriter(ctx)
-			wc.ContentType = "text/plain"
-			if _, err := wc.Write(buf.Bytes()); err != nil && !gcsErr {
-				gcsErr = true
-				w.LogStepInfo(s.name, "CreateInstances", "Instance %q: error writing log to GCS: %v", ii.getName(), err)
-				continue
-			} else if err != nil { // dont try to close the writer
-				continue
+			if w.MaxSerialBytes > 0 && received+int64(len(resp.Contents)) > w.MaxSerialBytes {
+				save(resp.Contents[:w.MaxSerialBytes-received])
+				w.LogStepInfo(s.name, "CreateInstances", "WARNING: Instance %q: serial port %d output exceeded MaxSerialBytes (%d bytes), no longer streaming it.", ii.getName(), port, w.MaxSerialBytes)
+				reason = SerialLogEndTruncated
+				break Loop
 			}
-			if err := wc.Close(); err != nil && !gcsErr {
-				gcsErr = true
-				w.LogStepInfo(s.name, "CreateInstances", "Instance %q: error saving log to GCS: %v", ii.getName(), err)
-				continue
+			save(resp.Contents)
+			if resp.Contents != "" {
+				signalStarted(nil)
 			}
 
 			if w.isCanceled() {
+				reason = SerialLogEndCanceled
 				break Loop
 			}
+		case <-startup:
+			stopErr = Errf("no serial port %d output within StartupTimeout (%s) of reaching RUNNING", port, ib.StartupTimeout)
+			break Loop
+		case <-ctx.Done():
+			reason = SerialLogEndCanceled
+			break Loop
+		}
+	}
+
+	if reason == SerialLogEndCanceled && w.timedOut(ctx) {
+		if ctx.Err() != nil {
+			saveCtx = context.Background()
+		}
+		// The poll limiter stops with the workflow, so this read skips it.
+		if resp, err := w.ComputeClient.GetSerialPortOutput(path.Base(ib.Project), path.Base(ii.getZone()), ii.getName(), port, start); err == nil && resp.Next >= start && resp.Contents != "" {
+			contents := resp.Contents
+			if w.MaxSerialBytes > 0 && received+int64(len(contents)) > w.MaxSerialBytes {
+				contents = contents[:w.MaxSerialBytes-received]
+			}
+			save(contents)
 		}
+		if status, err := w.ComputeClient.InstanceStatus(path.Base(ib.Project), path.Base(ii.getZone()), ii.getName()); err == nil && status == "RUNNING" {
+			reason = SerialLogEndTimedOut
+			tail = lastLines(buf.String(), serialTimeoutTailLines)
+			w.LogStepInfo(s.name, "CreateInstances", "WARNING: Instance %q timed out while RUNNING, last %d lines of serial port %d output:\n%s", ii.getName(), serialTimeoutTailLines, port, tail)
+		}
+	}
+
+	if ctx.Err() != nil {
+		saveCtx = context.Background()
+	}
+	if ts != nil {
+		if rest := ts.flush(); rest != "" {
+			store(rest)
+		}
+	}
+	if gcsErr {
+		// Try once more to upload the output the last upload missed.
+		store("")
 	}
 
 	w.Logger.WriteSerialPortLogs(w, ii.getName(), buf)
+	if stopErr != nil {
+		w.LogStepInfo(s.name, "CreateInstances", "Instance %q: %v", ii.getName(), stopErr)
+	}
+	dest := ""
+	if link != "" {
+		dest = " to " + link
+	}
+	w.LogStepInfo(s.name, "CreateInstances", "Instance %q: finished streaming serial port %d output (%s), %d bytes written%s.", ii.getName(), port, reason, buf.Len(), dest)
+	w.recordSerialLog(SerialLogRecord{Instance: ii.getName(), Port: port, Bytes: int64(buf.Len()), Link: link, Reason: reason, Tail: tail})
+	return stopErr
+}
+
+// recordInstanceStopped records the time instance was first seen stopped.
+func recordInstanceStopped(w *Workflow, instance string) {
+	now := time.Now()
+	w.recordInstanceTime(instance, func(r *InstanceTimeRecord) {
+		if r.StoppedTime.IsZero() {
+			r.StoppedTime = now
+		}
+	})
+}
+
+// preemptedErr returns an InstancePreempted error if GCE preempted the
+// stopped instance. An instance whose preemption can't be checked is taken
+// to have stopped by itself.
+func (s *Step) preemptedErr(stepType, project, zone, name string) DError {
+	preempted, err := s.w.ComputeClient.InstancePreempted(project, zone, name)
+	if err != nil {
+		s.w.LogStepInfo(s.name, stepType, "Instance %q: error checking whether it was preempted: %v", name, err)
+		return nil
+	}
+	if !preempted {
+		return nil
+	}
+	return typedErrf(instancePreemptedError, "instance %q was preempted", name)
+}
+
+// lastLines returns the last n lines of s.
+func lastLines(s string, n int) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// logScreenshots saves a screenshot of the instance's display every
+// ScreenshotInterval until the instance stops or the workflow is canceled.
+// Screenshots identical to the previous one are skipped, the others are
+// written to the logs path as "<instance>-screenshot-<n>.png".
+func logScreenshots(ctx context.Context, s *Step, ii InstanceInterface, ib *InstanceBase) {
+	w := s.w
+
+	project, zone := path.Base(ib.Project), path.Base(ii.getZone())
+	prefix := path.Join(w.logsPath, ii.getName()+"-screenshot-")
+	w.LogStepInfo(s.name, "CreateInstances", "Saving instance %q screenshots to https://storage.cloud.google.com/%s/%s*.png", ii.getName(), w.bucket, prefix)
+	ticker := time.NewTicker(ib.screenshotInterval)
+	defer ticker.Stop()
+	var last []byte
+	var saved, numErr int
+Loop:
+	for {
+		select {
+		case <-ticker.C:
+		case <-w.Cancel:
+			break Loop
+		}
+		data, err := w.ComputeClient.GetScreenshot(project, zone, ii.getName())
+		if err != nil {
+			numErr++
+			status, sErr := w.ComputeClient.InstanceStatus(project, zone, ii.getName())
+			if sErr == nil && (status == "TERMINATED" || status == "STOPPED" || status == "STOPPING") {
+				break Loop
+			}
+			if numErr > 10 {
+				w.LogStepInfo(s.name, "CreateInstances", "Instance %q: error getting screenshot: %v", ii.getName(), err)
+				break Loop
+			}
+			continue
+		}
+		numErr = 0
+		if bytes.Equal(data, last) {
+			continue
+		}
+		last = data
+		wc := w.StorageClient.Bucket(w.bucket).Object(fmt.Sprintf("%s%d.png", prefix, saved+1)).NewWriter(ctx)
+		wc.ContentType = "image/png"
+		wc.StorageClass = w.LogsStorageClass
+		wc.Metadata = w.LogsObjectMetadata
+		w.setObjectACL(&wc.ObjectAttrs)
+		if _, err := wc.Write(data); err != nil {
+			w.LogStepInfo(s.name, "CreateInstances", "Instance %q: error writing screenshot to GCS: %v", ii.getName(), err)
+			continue // dont try to close the writer
+		}
+		if err := wc.Close(); err != nil {
+			w.LogStepInfo(s.name, "CreateInstances", "Instance %q: error saving screenshot to GCS: %v", ii.getName(), err)
+			continue
+		}
+		saved++
+	}
+	w.LogStepInfo(s.name, "CreateInstances", "Instance %q: finished saving screenshots, %d saved.", ii.getName(), saved)
+}
+
+// goLogInstance runs f, which saves an instance's logs, in a goroutine that
+// cleanup waits for before deleting the instance, so the final output isn't
+// lost. Cleanup runs on the root workflow, so that's where it is tracked.
+func goLogInstance(w *Workflow, f func()) {
+	wg := &w.rootWorkflow().stepWait
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		f()
+	}()
+}
+
+// logSerialPorts starts streaming the output of each of the instance's
+// SerialPorts. If the instance has a StartupTimeout it waits for the first
+// port's output and returns an error if there was none in time.
+func (ib *InstanceBase) logSerialPorts(ctx context.Context, s *Step, ii InstanceInterface) DError {
+	if len(ib.SerialPorts) == 0 {
+		ib.SerialPorts = []int64{1}
+	}
+	if ib.startupTimeout > 0 {
+		ib.serialStarted = make(chan DError, 1)
+	}
+	for _, port := range ib.SerialPorts {
+		port := port
+		goLogInstance(s.w, func() { ib.span.End(logSerialOutput(ctx, s, ii, ib, port, 3*time.Second)) })
+	}
+	if ib.screenshotInterval > 0 {
+		goLogInstance(s.w, func() { logScreenshots(ctx, s, ii, ib) })
+	}
+	if ib.serialStarted == nil {
+		return nil
+	}
+	select {
+	case err := <-ib.serialStarted:
+		return err
+	case <-s.w.Cancel:
+	}
+	return nil
+}
+
+// restartSerialLogs streams the output of the instance's SerialPorts again
+// once step s has started it after it stopped. The output starts over, so
+// it's saved to new log objects, e.g. "inst-serial-port1-restart1.log". The
+// instance isn't held to StartupTimeout again, an error is only logged.
+func (ib *InstanceBase) restartSerialLogs(ctx context.Context, s *Step, ii InstanceInterface) {
+	ib.serialLogRestarts++
+	for _, port := range ib.SerialPorts {
+		port := port
+		logName := fmt.Sprintf("%s-serial-port%d-restart%d.log", ii.getName(), port, ib.serialLogRestarts)
+		goLogInstance(s.w, func() {
+			if err := streamSerialOutput(ctx, s, ii, ib, port, 3*time.Second, logName, nil); err != nil {
+				s.w.LogStepInfo(s.name, "ResizeInstances", "Instance %q: %v", ii.getName(), err)
+			}
+		})
+	}
+}
+
+// waitForInstanceRunning waits for a newly created instance to leave the
+// PROVISIONING and STAGING states, so that serial port output is only read
+// once there is some. It returns an error if the instance stops, or doesn't
+// reach RUNNING within the instance's RunningTimeout.
+func waitForInstanceRunning(s *Step, ii InstanceInterface, ib *InstanceBase, interval time.Duration) DError {
+	w := s.w
+	timeout := time.After(ib.runningTimeout)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		i, err := w.ComputeClient.GetInstance(path.Base(ib.Project), path.Base(ii.getZone()), ii.getName())
+		if err != nil {
+			// Leave it to the serial port streaming to deal with.
+			w.LogStepInfo(s.name, "CreateInstances", "Instance %q: error getting instance status: %v", ii.getName(), err)
+			return nil
+		}
+		switch i.Status {
+		case "PROVISIONING", "STAGING":
+		case "TERMINATED", "STOPPED", "STOPPING", "SUSPENDING", "SUSPENDED":
+			if i.StatusMessage != "" {
+				return Errf("instance %q stopped with status %s before reaching RUNNING: %s", ii.getName(), i.Status, i.StatusMessage)
+			}
+			return Errf("instance %q stopped with status %s before reaching RUNNING", ii.getName(), i.Status)
+		default:
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-timeout:
+			return Errf("instance %q did not reach RUNNING within %s, status is %s", ii.getName(), ib.RunningTimeout, i.Status)
+		case <-w.Cancel:
+			return nil
+		}
+	}
+}
+
+// neverStartedErr returns the error for an instance that stopped before any
+// serial port output could be read from it, which is the case when it failed
+// to boot rather than shutting down normally.
+func neverStartedErr(w *Workflow, ii InstanceInterface, ib *InstanceBase) DError {
+	i, err := w.ComputeClient.GetInstance(path.Base(ib.Project), path.Base(ii.getZone()), ii.getName())
+	if err == nil && i != nil && i.StatusMessage != "" {
+		return Errf("instance stopped with status %s without producing any serial port output: %s", i.Status, i.StatusMessage)
+	}
+	return Errf("instance stopped without producing any serial port output, it may have failed to boot")
 }
 
 // populate preprocesses fields: Name, Project, Zone, Description, MachineType, NetworkInterfaces, Scopes, ServiceAccounts, and daisyName.
@@ -142,28 +781,61 @@ func (ci *CreateInstances) populate(ctx context.Context, s *Step) DError {
 
 func (ci *CreateInstances) validate(ctx context.Context, s *Step) DError {
 	var errs DError
+	q := quotaRequest{}
 	if ci.instanceUsesBetaFeatures() {
 		for _, i := range ci.InstancesBeta {
-			errs = addErrs(errs, (&i.InstanceBase).validate(ctx, i, s))
+			if err := (&i.InstanceBase).validate(ctx, i, s); err != nil {
+				errs = addErrs(errs, err)
+				continue
+			}
+			errs = addErrs(errs, q.addInstance(s.w, i, &i.InstanceBase))
 		}
 	} else {
 		for _, i := range ci.Instances {
-			errs = addErrs(errs, (&i.InstanceBase).validate(ctx, i, s))
+			if err := (&i.InstanceBase).validate(ctx, i, s); err != nil {
+				errs = addErrs(errs, err)
+				continue
+			}
+			errs = addErrs(errs, q.addInstance(s.w, i, &i.InstanceBase))
 		}
 	}
-	return errs
+	if errs != nil {
+		return errs
+	}
+
+	// Fail early rather than leaving a partially created batch of instances.
+	return q.check(s.w)
 }
 
 func (ci *CreateInstances) run(ctx context.Context, s *Step) DError {
 	var wg sync.WaitGroup
 	w := s.w
 	eChan := make(chan DError)
+	total := len(ci.Instances)
+	if ci.instanceUsesBetaFeatures() {
+		total = len(ci.InstancesBeta)
+	}
+	p := w.startProgress(s, ProgressInstancesCreated, total)
+	// With ReadyWhen FIRST_RUNNING, dependents start once the first instance
+	// is RUNNING, validation checks they don't use the others.
+	var first *InstanceBase
+	if ibs := ci.instanceBases(); len(ibs) > 0 {
+		first = ibs[0]
+	}
 	createInstance := func(ii InstanceInterface, ib *InstanceBase) {
+		ictx, span := w.tracer().Start(ctx, "daisy.instance", map[string]string{"daisy.instance.name": ii.getName(), "daisy.instance.zone": path.Base(ii.getZone())})
+		ib.span = &instanceSpan{Span: span}
+		// fail ends the instance's span with err, its serial port logging
+		// ends it otherwise.
+		fail := func(err DError) {
+			ib.span.End(err)
+			eChan <- err
+		}
 		// Just try to delete it, a 404 here indicates the instance doesn't exist.
 		if ib.OverWrite {
 			if err := ii.delete(w.ComputeClient, true); err != nil {
 				if apiErr, ok := err.(*googleapi.Error); !ok || apiErr.Code != 404 {
-					eChan <- Errf("error deleting existing instance: %v", err)
+					fail(ib.wrapErr(Errf("error deleting existing instance: %v", err), "instance"))
 					return
 				}
 			}
@@ -177,27 +849,102 @@ func (ci *CreateInstances) run(ctx context.Context, s *Step) DError {
 		}
 		defer wg.Done()
 		ii.updateDisksAndNetworksBeforeCreate(w)
+		if err := ii.resolveNatIPs(w); err != nil {
+			fail(ib.wrapErr(err, "instance"))
+			return
+		}
 
-		w.LogStepInfo(s.name, "CreateInstances", "Creating instance %q.", ii.getName())
+		if w.canAdopt(&ib.Resource) && !ib.OverWrite {
+			adopted, err := ib.adoptExisting(ii, w)
+			if err != nil {
+				fail(ib.wrapErr(err, "instance"))
+				return
+			}
+			if adopted {
+				w.LogStepInfo(s.name, "CreateInstances", "Adopting existing instance %q.", ii.getName())
+				w.recordResourceName("instance", &ib.Resource)
+				ib.createdInWorkflow = true
+				p.inc()
+				if err := ib.logSerialPorts(ictx, s, ii); err != nil {
+					fail(ib.wrapErr(err, "instance"))
+				}
+				return
+			}
+		}
+
+		w.logResourceCreation(s, "CreateInstances", "instance", &ib.Resource)
 
-		if err := ii.create(w.ComputeClient); err != nil {
+		if err := ib.addSourceMetadata(ctx, ii, w); err != nil {
+			fail(ib.wrapErr(err, "instance"))
+			return
+		}
+		if w.PreInsertInstance != nil {
+			if err := w.PreInsertInstance(s.name, ii); err != nil {
+				ib.removeSourceMetadata(ii)
+				fail(ib.wrapErr(Errf("PreInsertInstance failed for instance %q: %v", ii.getName(), err), "instance"))
+				return
+			}
+		}
+		createStart := time.Now()
+		create := func() error { return ii.create(w.ComputeClient) }
+		err := w.traceCall(ictx, "compute.instances.insert", nil, create)
+		if err != nil {
 			// Fallback to no-external-ip mode to workaround organization policy.
 			if ib.RetryWhenExternalIPDenied && isExternalIPDeniedByOrganizationPolicy(err) {
 				w.LogStepInfo(s.name, "CreateInstances", "Falling back to no-external-ip mode "+
 					"for creating instance %v due to the fact that external IP is denied by organization policy.", ii.getName())
 
 				UpdateInstanceNoExternalIP(s)
-				err = ii.create(w.ComputeClient)
+				err = w.traceCall(ictx, "compute.instances.insert", nil, create)
 			}
 
-			if err != nil {
-				eChan <- newErr("failed to create instances", err)
-				return
+			// Fallback to other machine types if the zone is out of resources.
+			for _, mt := range ib.MachineTypeFallbacks {
+				if err == nil || !isResourcePoolExhausted(err) {
+					break
+				}
+				w.LogStepInfo(s.name, "CreateInstances", "Machine type %q is unavailable for instance %q, "+
+					"falling back to machine type %q.", path.Base(ii.getMachineType()), ii.getName(), path.Base(mt))
+				ii.setMachineType(mt)
+				err = w.traceCall(ictx, "compute.instances.insert", nil, create)
 			}
 		}
+		ib.removeSourceMetadata(ii)
+		if err != nil {
+			fail(ib.wrapErr(newErr("failed to create instances", err), "instance"))
+			return
+		}
 
 		ib.createdInWorkflow = true
-		go logSerialOutput(ctx, s, ii, ib, 1, 3*time.Second)
+		created := time.Now()
+		w.recordInstanceTime(ii.getName(), func(r *InstanceTimeRecord) {
+			r.CreateStartTime, r.CreatedTime = createStart, created
+		})
+		w.LogStepInfo(s.name, "CreateInstances", "Instance %q created in %s.", ii.getName(), created.Sub(createStart).Round(time.Millisecond))
+		ib.span.AddEvent("created")
+		p.inc()
+		if (len(ib.Secrets) > 0 || len(ib.ClearMetadataOnStop) > 0) && ib.NoCleanup {
+			w.addCleanupHook(func() DError {
+				return ib.removeSensitiveMetadataFromInstance(ii, w)
+			})
+		}
+		if ib.InstanceGroup != "" {
+			if err := w.instanceGroups.join(s, ib.Project, ii.getZone(), ib.InstanceGroup, ib.link); err != nil {
+				fail(ib.wrapErr(err, "instance"))
+				return
+			}
+		}
+		if err := waitForInstanceRunning(s, ii, ib, time.Second); err != nil {
+			fail(ib.wrapErr(err, "instance"))
+			return
+		}
+		ib.span.AddEvent("running")
+		if ib == first && !w.isCanceled() {
+			s.signalReady()
+		}
+		if err := ib.logSerialPorts(ictx, s, ii); err != nil {
+			fail(ib.wrapErr(err, "instance"))
+		}
 	}
 
 	if ci.instanceUsesBetaFeatures() {
@@ -227,6 +974,22 @@ func (ci *CreateInstances) run(ctx context.Context, s *Step) DError {
 	}
 }
 
+// instanceBases returns the InstanceBase of each instance the step creates,
+// in order.
+func (ci *CreateInstances) instanceBases() []*InstanceBase {
+	var ibs []*InstanceBase
+	if ci.instanceUsesBetaFeatures() {
+		for _, i := range ci.InstancesBeta {
+			ibs = append(ibs, &i.InstanceBase)
+		}
+	} else {
+		for _, i := range ci.Instances {
+			ibs = append(ibs, &i.InstanceBase)
+		}
+	}
+	return ibs
+}
+
 func (ci *CreateInstances) instanceUsesBetaFeatures() bool {
 	for _, instanceBeta := range ci.InstancesBeta {
 		if instanceBeta != nil && instanceBeta.SourceMachineImage != "" {
@@ -237,6 +1000,12 @@ func (ci *CreateInstances) instanceUsesBetaFeatures() bool {
 	return len(ci.Instances) == 0
 }
 
+var resourcePoolExhaustedRegex = regexp.MustCompile(fmt.Sprintf("(?m)^"+compute.OperationErrorCodeFormat+"$", "ZONE_RESOURCE_POOL_EXHAUSTED(_WITH_DETAILS)?"))
+
+func isResourcePoolExhausted(err error) bool {
+	return resourcePoolExhaustedRegex.FindIndex([]byte(err.Error())) != nil
+}
+
 func isExternalIPDeniedByOrganizationPolicy(err error) bool {
 	if gErr, ok := err.(*googleapi.Error); ok && gErr.Code == http.StatusPreconditionFailed {
 		return strings.Contains(gErr.Message, "constraints/compute.vmExternalIpAccess")
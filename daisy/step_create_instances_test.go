@@ -15,15 +15,27 @@
 package daisy
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"cloud.google.com/go/storage"
 	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
 	"github.com/stretchr/testify/assert"
 	computeBeta "google.golang.org/api/compute/v0.beta"
 	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/option"
 )
 
 func TestLogSerialOutput(t *testing.T) {
@@ -143,6 +155,776 @@ func TestLogSerialOutputStopsAfterTenRetries(t *testing.T) {
 	testSerialOutput(&iBeta, &iBeta.InstanceBase)
 }
 
+func TestLogSerialOutputInstanceNeverStarted(t *testing.T) {
+	tests := []struct {
+		desc      string
+		responses []string
+		port      int64
+		shouldErr bool
+	}{
+		{"stopped after output case", []string{"hello", "-"}, 1, false},
+		{"stopped without output case", []string{"-"}, 1, true},
+		{"stopped after empty output case", []string{"", "-"}, 1, true},
+		{"other port without output case", []string{"-"}, 2, false},
+	}
+
+	for _, tt := range tests {
+		w := testWorkflow()
+		callNum := 0
+		w.ComputeClient.(*daisyCompute.TestClient).GetSerialPortOutputFn = func(_, _, _ string, _, next int64) (*compute.SerialPortOutput, error) {
+			response := "-"
+			if callNum < len(tt.responses) {
+				response = tt.responses[callNum]
+			}
+			callNum++
+			// "-" stands for a failed read.
+			if response == "-" {
+				return nil, errors.New("fail")
+			}
+			return &compute.SerialPortOutput{Contents: response, Next: next + int64(len(response))}, nil
+		}
+		w.ComputeClient.(*daisyCompute.TestClient).InstanceStatusFn = func(_, _, _ string) (string, error) {
+			return "TERMINATED", nil
+		}
+
+		i := Instance{Instance: compute.Instance{Name: "i1"}}
+		i.SerialPorts = []int64{1, 2}
+		err := logSerialOutput(context.Background(), &Step{name: "foo", w: w}, &i, &i.InstanceBase, tt.port, 1*time.Microsecond)
+		if tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+	}
+}
+
+func TestLogSerialOutputReset(t *testing.T) {
+	w := testWorkflow()
+	mockLogger := &MockLogger{}
+	w.Logger = mockLogger
+	// The instance reboots after writing "hello", its new output is "abc".
+	responses := []*compute.SerialPortOutput{{Contents: "hello", Next: 5}, {Next: 3}, {Contents: "abc", Next: 3}}
+	var gotStarts []int64
+	w.ComputeClient.(*daisyCompute.TestClient).GetSerialPortOutputFn = func(_, _, _ string, _, start int64) (*compute.SerialPortOutput, error) {
+		gotStarts = append(gotStarts, start)
+		if len(gotStarts) > len(responses) {
+			return nil, errors.New("fail")
+		}
+		return responses[len(gotStarts)-1], nil
+	}
+	w.ComputeClient.(*daisyCompute.TestClient).InstanceStatusFn = func(_, _, _ string) (string, error) {
+		return "TERMINATED", nil
+	}
+
+	i := Instance{Instance: compute.Instance{Name: "i1"}}
+	if err := logSerialOutput(context.Background(), &Step{name: "foo", w: w}, &i, &i.InstanceBase, 1, 1*time.Microsecond); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	assert.Equal(t, []string{"helloabc"}, w.Logger.ReadSerialPortLogs())
+	assert.Equal(t, []int64{0, 5, 0, 3, 3}, gotStarts)
+	found := false
+	for _, e := range mockLogger.getEntries() {
+		if e.Message == "Instance \"i1\": serial port 1 output was reset, the instance may have rebooted." {
+			found = true
+		}
+	}
+	assert.True(t, found, "serial output reset wasn't logged")
+}
+
+func TestSerialTimestamper(t *testing.T) {
+	t1 := time.Date(2020, 1, 2, 3, 4, 5, 6000000, time.UTC)
+	t2 := t1.Add(time.Second)
+	t3 := t2.Add(time.Second)
+
+	ts := &serialTimestamper{}
+	var got []string
+	got = append(got, ts.stamp("a\nb", t1))
+	got = append(got, ts.stamp("c\nd\n", t2))
+	got = append(got, ts.stamp("", t3))
+	got = append(got, ts.stamp("e", t3))
+	got = append(got, ts.flush())
+	got = append(got, ts.flush())
+
+	want := []string{
+		"2020-01-02T03:04:05.006Z a\n",
+		"2020-01-02T03:04:05.006Z bc\n2020-01-02T03:04:06.006Z d\n",
+		"",
+		"",
+		"2020-01-02T03:04:07.006Z e",
+		"",
+	}
+	assert.Equal(t, want, got)
+}
+
+func TestLogSerialOutputTimestamps(t *testing.T) {
+	w := testWorkflow()
+	w.SerialLogTimestamps = true
+	responses := []string{"hel", "lo\nwor", "ld"}
+	callNum := 0
+	w.ComputeClient.(*daisyCompute.TestClient).GetSerialPortOutputFn = func(_, _, _ string, _, next int64) (*compute.SerialPortOutput, error) {
+		if callNum >= len(responses) {
+			return nil, errors.New("fail")
+		}
+		r := responses[callNum]
+		callNum++
+		return &compute.SerialPortOutput{Contents: r, Next: next + int64(len(r))}, nil
+	}
+	w.ComputeClient.(*daisyCompute.TestClient).InstanceStatusFn = func(_, _, _ string) (string, error) {
+		return "TERMINATED", nil
+	}
+
+	i := Instance{Instance: compute.Instance{Name: "i1"}}
+	if err := logSerialOutput(context.Background(), &Step{name: "foo", w: w}, &i, &i.InstanceBase, 1, 1*time.Microsecond); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	logs := w.Logger.ReadSerialPortLogs()
+	if len(logs) != 1 {
+		t.Fatalf("got %d serial port logs, want 1", len(logs))
+	}
+	ts := `\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{3}Z `
+	if !regexp.MustCompile("^" + ts + "hello\n" + ts + "world$").MatchString(logs[0]) {
+		t.Errorf("serial port log not timestamped as expected, got %q", logs[0])
+	}
+	if got := w.GetSerialLogRecords()[0].Bytes; got != int64(len(logs[0])) {
+		t.Errorf("got Bytes %d, want %d", got, len(logs[0]))
+	}
+}
+
+type testSerialLogWriter struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (w *testSerialLogWriter) Close() error {
+	w.closed = true
+	return nil
+}
+
+func TestLogSerialOutputCustomWriter(t *testing.T) {
+	w := testWorkflow()
+	responses := []string{"hello", " go", ""}
+	callNum := 0
+	w.ComputeClient.(*daisyCompute.TestClient).GetSerialPortOutputFn = func(_, _, _ string, _, next int64) (*compute.SerialPortOutput, error) {
+		var response string
+		if callNum < len(responses) {
+			response = responses[callNum]
+		}
+		callNum++
+		if response == "" {
+			return nil, errors.New("fail")
+		}
+		return &compute.SerialPortOutput{Contents: response, Next: next + int64(len(response))}, nil
+	}
+	w.ComputeClient.(*daisyCompute.TestClient).InstanceStatusFn = func(_, _, _ string) (string, error) {
+		return "TERMINATED", nil
+	}
+
+	sw := &testSerialLogWriter{}
+	var gotInstance string
+	var gotPort int64
+	w.SerialLogWriter = func(instance string, port int64) (io.WriteCloser, error) {
+		gotInstance, gotPort = instance, port
+		return sw, nil
+	}
+
+	i := Instance{Instance: compute.Instance{Name: "i1"}}
+	logSerialOutput(context.Background(), &Step{name: "foo", w: w}, &i, &i.InstanceBase, 2, 1*time.Microsecond)
+
+	assert.Equal(t, "i1", gotInstance)
+	assert.Equal(t, int64(2), gotPort)
+	assert.Equal(t, "hello go", sw.String())
+	assert.True(t, sw.closed)
+	logs := w.Logger.ReadSerialPortLogs()
+	assert.Equal(t, 1, len(logs))
+	assert.Equal(t, "hello go", logs[0])
+}
+
+func TestLogSerialOutputStdout(t *testing.T) {
+	w := testWorkflow()
+	responses := []string{"hello\nwor", "ld\npartial", ""}
+	callNum := 0
+	w.ComputeClient.(*daisyCompute.TestClient).GetSerialPortOutputFn = func(_, _, _ string, _, next int64) (*compute.SerialPortOutput, error) {
+		var response string
+		if callNum < len(responses) {
+			response = responses[callNum]
+		}
+		callNum++
+		if response == "" {
+			return nil, errors.New("fail")
+		}
+		return &compute.SerialPortOutput{Contents: response, Next: next + int64(len(response))}, nil
+	}
+	w.ComputeClient.(*daisyCompute.TestClient).InstanceStatusFn = func(_, _, _ string) (string, error) {
+		return "TERMINATED", nil
+	}
+	var stdout bytes.Buffer
+	w.EnableSerialOutputStdout()
+	w.serialStdout = &stdout
+
+	// The setting is read from the root workflow.
+	iw := w.NewSubWorkflow()
+	iw.ComputeClient = w.ComputeClient
+	iw.StorageClient = w.StorageClient
+	iw.Logger = w.Logger
+	i := Instance{Instance: compute.Instance{Name: "i1"}}
+	logSerialOutput(context.Background(), &Step{name: "foo", w: iw}, &i, &i.InstanceBase, 1, 1*time.Microsecond)
+
+	assert.Equal(t, "[i1 serial-port1]: hello\n[i1 serial-port1]: world\n[i1 serial-port1]: partial\n", stdout.String())
+	assert.Equal(t, []string{"hello\nworld\npartial"}, w.Logger.ReadSerialPortLogs())
+}
+
+func TestSerialLineWriter(t *testing.T) {
+	w := testWorkflow()
+	var stdout bytes.Buffer
+	w.serialStdout = &stdout
+	lw := newSerialLineWriter(w, "i1", 2)
+
+	for _, in := range []string{"a", "b\nc\n", "\nd"} {
+		if _, err := lw.Write([]byte(in)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	assert.Equal(t, "[i1 serial-port2]: ab\n[i1 serial-port2]: c\n[i1 serial-port2]: \n", stdout.String())
+	if err := lw.flush(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert.Equal(t, "[i1 serial-port2]: ab\n[i1 serial-port2]: c\n[i1 serial-port2]: \n[i1 serial-port2]: d\n", stdout.String())
+
+	w.serialStdout = nil
+	assert.Nil(t, newSerialLineWriter(w, "i1", 1))
+}
+
+func TestLogSerialOutputCombined(t *testing.T) {
+	w := testWorkflow()
+	w.CombinedSerialLog = true
+	w.bucket = "bucket"
+	w.logsPath = "logs"
+	out := map[string]string{"i1": "a\nb", "i2": "c\n"}
+	w.ComputeClient.(*daisyCompute.TestClient).GetSerialPortOutputFn = func(_, _, n string, _, next int64) (*compute.SerialPortOutput, error) {
+		if next == 0 {
+			return &compute.SerialPortOutput{Contents: out[n], Next: int64(len(out[n]))}, nil
+		}
+		return nil, errors.New("fail")
+	}
+	w.ComputeClient.(*daisyCompute.TestClient).InstanceStatusFn = func(_, _, _ string) (string, error) {
+		return "RUNNING", nil
+	}
+
+	for _, n := range []string{"i1", "i2"} {
+		i := Instance{Instance: compute.Instance{Name: n}}
+		logSerialOutput(context.Background(), &Step{name: "foo", w: w}, &i, &i.InstanceBase, 1, 1*time.Microsecond)
+	}
+	assert.Equal(t, "[i1 serial-port1]: a\n[i1 serial-port1]: b\n[i2 serial-port1]: c\n", w.combinedSerialLog.String())
+	assert.Equal(t, []string{"a\nb", "c\n"}, w.Logger.ReadSerialPortLogs())
+
+	w.CombinedSerialLog = false
+	assert.Nil(t, newCombinedSerialLineWriter(w, "i1", 1))
+}
+
+func TestStreamCombinedSerialLog(t *testing.T) {
+	defer func(d time.Duration) { combinedSerialLogInterval = d }(combinedSerialLogInterval)
+	combinedSerialLogInterval = time.Millisecond
+
+	contentsRgx := regexp.MustCompile(`\r\n\r\n([^{\r][^\r]*)\r\n--`)
+	var mx sync.Mutex
+	var uploads []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mx.Lock()
+		defer mx.Unlock()
+		if ms := contentsRgx.FindAllStringSubmatch(string(body), -1); r.Method == "POST" && len(ms) > 0 {
+			uploads = append(uploads, ms[len(ms)-1][1])
+		}
+		fmt.Fprint(w, `{"kind":"storage#object","bucket":"bucket","name":"logs/combined-serial.log"}`)
+	}))
+	defer ts.Close()
+
+	w := testWorkflow()
+	var err error
+	w.StorageClient, err = storage.NewClient(context.Background(), option.WithEndpoint(ts.URL), option.WithHTTPClient(http.DefaultClient))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.bucket = "bucket"
+	w.logsPath = "logs"
+	ctx, cancel := context.WithCancel(context.Background())
+	stop := w.streamCombinedSerialLog(ctx)
+	w.writeCombinedSerialLog([]byte("a\n"))
+	time.Sleep(50 * time.Millisecond)
+	// Output written once the workflow's context is done is still uploaded
+	// when streaming stops.
+	cancel()
+	w.writeCombinedSerialLog([]byte("b\n"))
+	stop()
+
+	mx.Lock()
+	defer mx.Unlock()
+	if len(uploads) < 2 || uploads[0] != "a\n" || uploads[len(uploads)-1] != "a\nb\n" {
+		t.Errorf("got uploads %q, want \"a\\n\" first and \"a\\nb\\n\" last", uploads)
+	}
+}
+
+func TestLogSerialOutputMaxSerialLogWriters(t *testing.T) {
+	// The GCS server holds each upload open for a while to let them overlap.
+	var mx sync.Mutex
+	var open, maxOpen, uploads int
+	ts := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		mx.Lock()
+		open++
+		uploads++
+		if open > maxOpen {
+			maxOpen = open
+		}
+		mx.Unlock()
+		ioutil.ReadAll(r.Body)
+		time.Sleep(10 * time.Millisecond)
+		mx.Lock()
+		open--
+		mx.Unlock()
+		fmt.Fprint(rw, `{"kind":"storage#object","bucket":"bucket","name":"object"}`)
+	}))
+	defer ts.Close()
+	sc, err := storage.NewClient(context.Background(), option.WithEndpoint(ts.URL), option.WithHTTPClient(http.DefaultClient))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The cap is shared with the parent workflow.
+	parent := testWorkflow()
+	parent.MaxSerialLogWriters = 2
+	w := testWorkflow()
+	w.parent = parent
+	w.StorageClient = sc
+	w.bucket = "bucket"
+	w.ComputeClient.(*daisyCompute.TestClient).GetSerialPortOutputFn = func(_, _, _ string, _, next int64) (*compute.SerialPortOutput, error) {
+		if next == 0 {
+			return &compute.SerialPortOutput{Contents: "a\n", Next: 2}, nil
+		}
+		return nil, errors.New("fail")
+	}
+	w.ComputeClient.(*daisyCompute.TestClient).InstanceStoppedFn = func(_, _, _ string) (bool, error) {
+		return true, nil
+	}
+
+	var wg sync.WaitGroup
+	for n := 0; n < 6; n++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			i := Instance{Instance: compute.Instance{Name: fmt.Sprintf("i%d", n)}}
+			logSerialOutput(context.Background(), &Step{name: "foo", w: w}, &i, &i.InstanceBase, 1, 1*time.Microsecond)
+		}(n)
+	}
+	wg.Wait()
+
+	if uploads < 6 {
+		t.Errorf("got %d serial log uploads, want at least 6", uploads)
+	}
+	if maxOpen > 2 {
+		t.Errorf("got %d serial log writers open at once, want at most MaxSerialLogWriters (2)", maxOpen)
+	}
+}
+
+func TestLogSerialOutputShutdownGracePeriod(t *testing.T) {
+	w := testWorkflow()
+	// The instance is reported as stopped after the second read fails, the
+	// final read after the grace period returns the trailing output.
+	responses := []string{"hello", "", " bye"}
+	callNum := 0
+	w.ComputeClient.(*daisyCompute.TestClient).GetSerialPortOutputFn = func(_, _, _ string, _, next int64) (*compute.SerialPortOutput, error) {
+		var response string
+		if callNum < len(responses) {
+			response = responses[callNum]
+		}
+		callNum++
+		if response == "" {
+			return nil, errors.New("fail")
+		}
+		return &compute.SerialPortOutput{Contents: response, Next: next + int64(len(response))}, nil
+	}
+	w.ComputeClient.(*daisyCompute.TestClient).InstanceStatusFn = func(_, _, _ string) (string, error) {
+		return "TERMINATED", nil
+	}
+	sw := &testSerialLogWriter{}
+	w.SerialLogWriter = func(string, int64) (io.WriteCloser, error) {
+		return sw, nil
+	}
+
+	i := Instance{Instance: compute.Instance{Name: "i1"}}
+	i.serialShutdownGracePeriod = 1 * time.Millisecond
+	err := logSerialOutput(context.Background(), &Step{name: "foo", w: w}, &i, &i.InstanceBase, 1, 1*time.Microsecond)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 3, callNum)
+	assert.Equal(t, "hello bye", sw.String())
+	assert.Equal(t, []SerialLogRecord{{Instance: "i1", Port: 1, Bytes: 9, Reason: SerialLogEndStopped}}, w.GetSerialLogRecords())
+	times := w.GetInstanceTimeRecords()
+	if assert.Len(t, times, 1) {
+		assert.False(t, times[0].FirstSerialOutputTime.IsZero(), "first serial output time not recorded")
+		assert.False(t, times[0].StoppedTime.IsZero(), "stopped time not recorded")
+		assert.False(t, times[0].StoppedTime.Before(times[0].FirstSerialOutputTime), "stopped before first serial output")
+	}
+}
+
+func TestLogSerialOutputPreempted(t *testing.T) {
+	w := testWorkflow()
+	responses := []string{"hello", ""}
+	callNum := 0
+	tc := w.ComputeClient.(*daisyCompute.TestClient)
+	tc.GetSerialPortOutputFn = func(_, _, _ string, _, next int64) (*compute.SerialPortOutput, error) {
+		response := ""
+		if callNum < len(responses) {
+			response = responses[callNum]
+		}
+		callNum++
+		if response == "" {
+			return nil, errors.New("fail")
+		}
+		return &compute.SerialPortOutput{Contents: response, Next: next + int64(len(response))}, nil
+	}
+	tc.InstanceStatusFn = func(_, _, _ string) (string, error) {
+		return "TERMINATED", nil
+	}
+	tc.InstancePreemptedFn = func(_, _, _ string) (bool, error) {
+		return true, nil
+	}
+	w.SerialLogWriter = func(string, int64) (io.WriteCloser, error) {
+		return &testSerialLogWriter{}, nil
+	}
+
+	i := Instance{Instance: compute.Instance{Name: "i1", Scheduling: &compute.Scheduling{Preemptible: true}}}
+	i.serialShutdownGracePeriod = 1 * time.Millisecond
+	err := logSerialOutput(context.Background(), &Step{name: "foo", w: w}, &i, &i.InstanceBase, 1, 1*time.Microsecond)
+
+	if err == nil || !err.CausedByErrType(instancePreemptedError) {
+		t.Errorf("got error %v, want an %s error", err, instancePreemptedError)
+	}
+	assert.Equal(t, []SerialLogRecord{{Instance: "i1", Port: 1, Bytes: 5, Reason: SerialLogEndPreempted}}, w.GetSerialLogRecords())
+}
+
+func TestLogSerialOutputMaxSerialBytes(t *testing.T) {
+	w := testWorkflow()
+	w.MaxSerialBytes = 7
+	responses := []string{"hello", " world", " again"}
+	callNum := 0
+	w.ComputeClient.(*daisyCompute.TestClient).GetSerialPortOutputFn = func(_, _, _ string, _, next int64) (*compute.SerialPortOutput, error) {
+		response := responses[callNum]
+		callNum++
+		return &compute.SerialPortOutput{Contents: response, Next: next + int64(len(response))}, nil
+	}
+	sw := &testSerialLogWriter{}
+	w.SerialLogWriter = func(string, int64) (io.WriteCloser, error) {
+		return sw, nil
+	}
+
+	i := Instance{Instance: compute.Instance{Name: "i1"}}
+	err := logSerialOutput(context.Background(), &Step{name: "foo", w: w}, &i, &i.InstanceBase, 1, 1*time.Microsecond)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, callNum)
+	assert.Equal(t, "hello w", sw.String())
+	assert.True(t, sw.closed)
+	assert.Equal(t, []SerialLogRecord{{Instance: "i1", Port: 1, Bytes: 7, Reason: SerialLogEndTruncated}}, w.GetSerialLogRecords())
+}
+
+func TestLogSerialOutputRepairingSuspended(t *testing.T) {
+	w := testWorkflow()
+	// The instance is repaired for more polls than errors are otherwise
+	// retried, then writes more output and is suspended.
+	callNum := 0
+	w.ComputeClient.(*daisyCompute.TestClient).GetSerialPortOutputFn = func(_, _, _ string, _, next int64) (*compute.SerialPortOutput, error) {
+		callNum++
+		switch {
+		case callNum == 1:
+			return &compute.SerialPortOutput{Contents: "hello", Next: 5}, nil
+		case callNum == 16:
+			return &compute.SerialPortOutput{Contents: " world", Next: 11}, nil
+		}
+		return nil, errors.New("fail")
+	}
+	w.ComputeClient.(*daisyCompute.TestClient).InstanceStatusFn = func(_, _, _ string) (string, error) {
+		if callNum < 16 {
+			return "REPAIRING", nil
+		}
+		return "SUSPENDED", nil
+	}
+	sw := &testSerialLogWriter{}
+	w.SerialLogWriter = func(string, int64) (io.WriteCloser, error) {
+		return sw, nil
+	}
+
+	i := Instance{Instance: compute.Instance{Name: "i1"}}
+	err := logSerialOutput(context.Background(), &Step{name: "foo", w: w}, &i, &i.InstanceBase, 1, 1*time.Microsecond)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 17, callNum)
+	assert.Equal(t, "hello world", sw.String())
+	assert.Equal(t, []SerialLogRecord{{Instance: "i1", Port: 1, Bytes: 11, Reason: SerialLogEndSuspended}}, w.GetSerialLogRecords())
+}
+
+func TestLogSerialOutputCompletionRecord(t *testing.T) {
+	w := testWorkflow()
+	w.bucket = "test-bucket"
+	w.logsPath = "logs"
+	w.ComputeClient.(*daisyCompute.TestClient).GetSerialPortOutputFn = func(_, _, _ string, _, next int64) (*compute.SerialPortOutput, error) {
+		if next == 0 {
+			return &compute.SerialPortOutput{Contents: "hello", Next: 5}, nil
+		}
+		return nil, errors.New("fail")
+	}
+	w.ComputeClient.(*daisyCompute.TestClient).InstanceStatusFn = func(_, _, _ string) (string, error) {
+		return "RUNNING", nil
+	}
+	mockLogger := &MockLogger{}
+	w.Logger = mockLogger
+
+	i := Instance{Instance: compute.Instance{Name: "i1"}}
+	err := logSerialOutput(context.Background(), &Step{name: "foo", w: w}, &i, &i.InstanceBase, 2, 1*time.Microsecond)
+
+	assert.Nil(t, err)
+	entries := mockLogger.getEntries()
+	assert.Equal(t, `Instance "i1": finished streaming serial port 2 output (error), 5 bytes written to gs://test-bucket/logs/i1-serial-port2.log.`, entries[len(entries)-1].Message)
+	assert.Equal(t, []SerialLogRecord{{Instance: "i1", Port: 2, Bytes: 5, Link: "gs://test-bucket/logs/i1-serial-port2.log", Reason: SerialLogEndError}}, w.GetSerialLogRecords())
+}
+
+func TestLogSerialOutputObjectMetadata(t *testing.T) {
+	var mx sync.Mutex
+	var uploads []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mx.Lock()
+		uploads = append(uploads, string(body))
+		mx.Unlock()
+		fmt.Fprint(w, `{"kind":"storage#object","bucket":"test-bucket","name":"logs/i1-serial-port1.log"}`)
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		desc, contentType, storageClass string
+		serialStorageClass              string
+		logsMd, wfMd, instanceMd        map[string]string
+		want                            []string
+	}{
+		{"default case", "", "", "", nil, nil, nil, []string{`"contentType":"text/plain"`}},
+		{"custom case", "application/gzip", "", "", nil, map[string]string{"build-id": "b1", "owner": "wf"}, map[string]string{"owner": "instance"},
+			[]string{`"contentType":"application/gzip"`, `"build-id":"b1"`, `"owner":"instance"`}},
+		{"logs attrs case", "", "NEARLINE", "", map[string]string{"retention": "short", "owner": "logs"}, map[string]string{"owner": "wf"}, nil,
+			[]string{`"storageClass":"NEARLINE"`, `"retention":"short"`, `"owner":"wf"`}},
+		{"serial storage class case", "", "NEARLINE", "COLDLINE", nil, nil, nil, []string{`"storageClass":"COLDLINE"`}},
+	}
+	for _, tt := range tests {
+		mx.Lock()
+		uploads = nil
+		mx.Unlock()
+		w := testWorkflow()
+		var err error
+		w.StorageClient, err = storage.NewClient(context.Background(), option.WithEndpoint(ts.URL), option.WithHTTPClient(http.DefaultClient))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.bucket = "test-bucket"
+		w.logsPath = "logs"
+		w.SerialLogContentType = tt.contentType
+		w.SerialLogMetadata = tt.wfMd
+		w.LogsStorageClass = tt.storageClass
+		w.SerialLogStorageClass = tt.serialStorageClass
+		w.LogsObjectMetadata = tt.logsMd
+		w.ComputeClient.(*daisyCompute.TestClient).GetSerialPortOutputFn = func(_, _, _ string, _, next int64) (*compute.SerialPortOutput, error) {
+			if next == 0 {
+				return &compute.SerialPortOutput{Contents: "hello", Next: 5}, nil
+			}
+			return nil, errors.New("fail")
+		}
+		w.ComputeClient.(*daisyCompute.TestClient).InstanceStatusFn = func(_, _, _ string) (string, error) {
+			return "RUNNING", nil
+		}
+
+		i := Instance{InstanceBase: InstanceBase{SerialLogMetadata: tt.instanceMd}, Instance: compute.Instance{Name: "i1"}}
+		logSerialOutput(context.Background(), &Step{name: "foo", w: w}, &i, &i.InstanceBase, 1, 1*time.Microsecond)
+
+		mx.Lock()
+		got := append([]string(nil), uploads...)
+		mx.Unlock()
+		if len(got) == 0 {
+			t.Fatalf("%s: serial log was not uploaded", tt.desc)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(got[0], want) {
+				t.Errorf("%s: upload request missing %s: %s", tt.desc, want, got[0])
+			}
+		}
+		if tt.wfMd != nil && tt.wfMd["owner"] != "wf" {
+			t.Errorf("%s: workflow SerialLogMetadata was modified: %v", tt.desc, tt.wfMd)
+		}
+	}
+}
+
+func TestLogScreenshots(t *testing.T) {
+	var mx sync.Mutex
+	var uploads []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mx.Lock()
+		uploads = append(uploads, string(body))
+		mx.Unlock()
+		fmt.Fprint(w, `{"kind":"storage#object","bucket":"test-bucket","name":"logs/i1-screenshot-1.png"}`)
+	}))
+	defer ts.Close()
+
+	w := testWorkflow()
+	var err error
+	w.StorageClient, err = storage.NewClient(context.Background(), option.WithEndpoint(ts.URL), option.WithHTTPClient(http.DefaultClient))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.bucket = "test-bucket"
+	w.logsPath = "logs"
+	screens := []string{"boot", "boot", "oobe"}
+	w.ComputeClient.(*daisyCompute.TestClient).GetScreenshotFn = func(_, _, _ string) ([]byte, error) {
+		if len(screens) == 0 {
+			return nil, errors.New("fail")
+		}
+		screen := screens[0]
+		screens = screens[1:]
+		return []byte(screen), nil
+	}
+	w.ComputeClient.(*daisyCompute.TestClient).InstanceStatusFn = func(_, _, _ string) (string, error) {
+		return "TERMINATED", nil
+	}
+
+	i := Instance{InstanceBase: InstanceBase{screenshotInterval: time.Microsecond}, Instance: compute.Instance{Name: "i1"}}
+	logScreenshots(context.Background(), &Step{name: "foo", w: w}, &i, &i.InstanceBase)
+
+	mx.Lock()
+	defer mx.Unlock()
+	if len(uploads) != 2 {
+		t.Fatalf("want 2 screenshots saved, got %d", len(uploads))
+	}
+	for n, want := range []string{"boot", "oobe"} {
+		for _, part := range []string{`"contentType":"image/png"`, fmt.Sprintf(`"name":"logs/i1-screenshot-%d.png"`, n+1), want} {
+			if !strings.Contains(uploads[n], part) {
+				t.Errorf("upload %d missing %s: %s", n, part, uploads[n])
+			}
+		}
+	}
+}
+
+func TestLogSerialPortsStartupTimeout(t *testing.T) {
+	w := testWorkflow()
+	w.ComputeClient.(*daisyCompute.TestClient).GetSerialPortOutputFn = func(_, _, _ string, _, next int64) (*compute.SerialPortOutput, error) {
+		return &compute.SerialPortOutput{Next: next}, nil
+	}
+	w.SerialLogWriter = func(string, int64) (io.WriteCloser, error) {
+		return &testSerialLogWriter{}, nil
+	}
+
+	i := Instance{Instance: compute.Instance{Name: "i1"}}
+	i.SerialPorts = []int64{1, 2}
+	i.StartupTimeout = "1ms"
+	i.startupTimeout = 1 * time.Millisecond
+	err := i.logSerialPorts(context.Background(), &Step{name: "foo", w: w}, &i)
+
+	assert.EqualError(t, err, `no serial port 1 output within StartupTimeout (1ms) of reaching RUNNING`)
+	close(w.Cancel)
+}
+
+func TestLogSerialOutputStartupTimeoutMet(t *testing.T) {
+	w := testWorkflow()
+	responses := []string{"hello", ""}
+	callNum := 0
+	w.ComputeClient.(*daisyCompute.TestClient).GetSerialPortOutputFn = func(_, _, _ string, _, next int64) (*compute.SerialPortOutput, error) {
+		var response string
+		if callNum < len(responses) {
+			response = responses[callNum]
+		}
+		callNum++
+		if response == "" {
+			return nil, errors.New("fail")
+		}
+		return &compute.SerialPortOutput{Contents: response, Next: next + int64(len(response))}, nil
+	}
+	w.ComputeClient.(*daisyCompute.TestClient).InstanceStatusFn = func(_, _, _ string) (string, error) {
+		return "TERMINATED", nil
+	}
+	w.SerialLogWriter = func(string, int64) (io.WriteCloser, error) {
+		return &testSerialLogWriter{}, nil
+	}
+
+	i := Instance{Instance: compute.Instance{Name: "i1"}}
+	i.SerialPorts = []int64{1}
+	i.startupTimeout = time.Minute
+	i.serialShutdownGracePeriod = time.Millisecond
+	i.serialStarted = make(chan DError, 1)
+	err := logSerialOutput(context.Background(), &Step{name: "foo", w: w}, &i, &i.InstanceBase, 1, 1*time.Microsecond)
+
+	assert.Nil(t, err)
+	assert.Nil(t, <-i.serialStarted)
+}
+
+func TestLogSerialOutputWriterErrorSignalsStarted(t *testing.T) {
+	w := testWorkflow()
+	w.SerialLogWriter = func(string, int64) (io.WriteCloser, error) {
+		return nil, errors.New("no writer")
+	}
+
+	i := Instance{Instance: compute.Instance{Name: "i1"}}
+	i.SerialPorts = []int64{1}
+	i.startupTimeout = time.Minute
+	i.serialStarted = make(chan DError, 1)
+	logSerialOutput(context.Background(), &Step{name: "foo", w: w}, &i, &i.InstanceBase, 1, 1*time.Microsecond)
+
+	select {
+	case err := <-i.serialStarted:
+		assert.Nil(t, err)
+	default:
+		t.Error("StartupTimeout wait not signaled when the serial log writer can't be created")
+	}
+}
+
+func TestWaitForInstanceRunning(t *testing.T) {
+	tests := []struct {
+		desc          string
+		statuses      []string
+		statusMessage string
+		timeout       time.Duration
+		wantErr       string
+		wantCalls     int
+	}{
+		{"running", []string{"RUNNING"}, "", time.Minute, "", 1},
+		{"staging then running", []string{"PROVISIONING", "STAGING", "RUNNING"}, "", time.Minute, "", 3},
+		{"stopped", []string{"STAGING", "TERMINATED"}, "", time.Minute, `instance "i1" stopped with status TERMINATED before reaching RUNNING`, 2},
+		{"stopped with message", []string{"TERMINATED"}, "boot failed", time.Minute, `instance "i1" stopped with status TERMINATED before reaching RUNNING: boot failed`, 1},
+		{"timeout", []string{"STAGING"}, "", time.Millisecond, `instance "i1" did not reach RUNNING within 1ms, status is STAGING`, -1},
+	}
+
+	for _, tt := range tests {
+		w := testWorkflow()
+		calls := 0
+		w.ComputeClient.(*daisyCompute.TestClient).GetInstanceFn = func(_, _, _ string) (*compute.Instance, error) {
+			status := tt.statuses[len(tt.statuses)-1]
+			if calls < len(tt.statuses) {
+				status = tt.statuses[calls]
+			}
+			calls++
+			return &compute.Instance{Status: status, StatusMessage: tt.statusMessage}, nil
+		}
+
+		i := Instance{Instance: compute.Instance{Name: "i1"}}
+		i.RunningTimeout = tt.timeout.String()
+		i.runningTimeout = tt.timeout
+		err := waitForInstanceRunning(&Step{name: "foo", w: w}, &i, &i.InstanceBase, 10*time.Microsecond)
+
+		if tt.wantErr == "" {
+			assert.Nil(t, err, tt.desc)
+		} else if assert.NotNil(t, err, tt.desc) {
+			assert.Equal(t, tt.wantErr, err.Error(), tt.desc)
+		}
+		if tt.wantCalls >= 0 {
+			assert.Equal(t, tt.wantCalls, calls, tt.desc)
+		}
+	}
+}
+
 func TestCreateInstancesRun(t *testing.T) {
 	ctx := context.Background()
 	var createErr DError
@@ -186,6 +968,9 @@ func TestCreateInstancesRun(t *testing.T) {
 	if i2.NetworkInterfaces[0].Subnetwork != w.subnetworks.m["s"].link {
 		t.Errorf("instance network link did not resolve properly: want: %q, got: %q", w.subnetworks.m["s"].link, i2.NetworkInterfaces[0].Network)
 	}
+	if got, want := w.GetStepProgress(), []StepProgress{{Action: ProgressInstancesCreated, Done: 3, Total: 3}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got progress %v, want %v", got, want)
+	}
 
 	// Bad case: compute client Instance error.
 	w.instances.m = map[string]*Resource{}
@@ -195,7 +980,7 @@ func TestCreateInstancesRun(t *testing.T) {
 			{InstanceBase: InstanceBase{Resource: Resource{daisyName: "i0"}}, Instance: compute.Instance{Name: "realI0", MachineType: "foo-type", Disks: []*compute.AttachedDisk{{Source: "d0"}}}},
 		},
 	}
-	if err := ci.run(ctx, s); err != createErr {
+	if err := ci.run(ctx, s); !errors.Is(err, createErr) {
 		t.Errorf("CreateInstances.run() should have return compute client error: %v != %v", err, createErr)
 	}
 	ci = &CreateInstances{
@@ -203,7 +988,285 @@ func TestCreateInstancesRun(t *testing.T) {
 			{InstanceBase: InstanceBase{Resource: Resource{daisyName: "i0"}}, Instance: computeBeta.Instance{Name: "realI0", MachineType: "foo-type", Disks: []*computeBeta.AttachedDisk{{Source: "d0"}}}},
 		},
 	}
-	if err := ci.run(ctx, s); err != createErr {
+	if err := ci.run(ctx, s); !errors.Is(err, createErr) {
 		t.Errorf("CreateInstances.run() should have return compute client error: %v != %v", err, createErr)
 	}
 }
+
+func TestCreateInstancesRunSignalsReady(t *testing.T) {
+	tests := []struct {
+		desc      string
+		failed    []string
+		wantReady bool
+	}{
+		{"running case", nil, true},
+		{"failed case", []string{"i0", "i1"}, false},
+		// Only the first instance, which dependents may use, signals ready.
+		{"first failed case", []string{"i0"}, false},
+	}
+	for _, tt := range tests {
+		// The step returns on the first failure, its other instances may
+		// still be checked during the next case.
+		tt := tt
+		w := testWorkflow()
+		w.ComputeClient.(*daisyCompute.TestClient).CreateInstanceFn = func(_, _ string, _ *compute.Instance) error { return nil }
+		w.ComputeClient.(*daisyCompute.TestClient).GetInstanceFn = func(_, _, name string) (*compute.Instance, error) {
+			if strIn(name, tt.failed) {
+				return &compute.Instance{Status: "TERMINATED"}, nil
+			}
+			return &compute.Instance{Status: "RUNNING"}, nil
+		}
+		s := &Step{name: "s", w: w, ReadyWhen: readyWhenFirstRunning, ready: make(chan struct{}, 1)}
+		ci := &CreateInstances{Instances: []*Instance{
+			{InstanceBase: InstanceBase{Resource: Resource{daisyName: "i0"}, runningTimeout: time.Minute}, Instance: compute.Instance{Name: "i0"}},
+			{InstanceBase: InstanceBase{Resource: Resource{daisyName: "i1"}, runningTimeout: time.Minute}, Instance: compute.Instance{Name: "i1"}},
+		}}
+		if err := ci.run(context.Background(), s); (len(tt.failed) > 0) != (err != nil) {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+		select {
+		case <-s.ready:
+			if !tt.wantReady {
+				t.Errorf("%s: step signaled ready without its first instance RUNNING", tt.desc)
+			}
+		default:
+			if tt.wantReady {
+				t.Errorf("%s: step did not signal ready", tt.desc)
+			}
+		}
+	}
+}
+
+func TestCreateInstancesRunSecrets(t *testing.T) {
+	w := testWorkflow()
+	w.Sources = map[string]string{"token": "./test_data/test.txt"}
+	w.workflowDir = "."
+	var gotMd map[string]string
+	w.ComputeClient.(*daisyCompute.TestClient).CreateInstanceFn = func(_, _ string, i *compute.Instance) error {
+		gotMd = map[string]string{}
+		for _, item := range i.Metadata.Items {
+			gotMd[item.Key] = *item.Value
+		}
+		return nil
+	}
+	other := "other"
+	w.ComputeClient.(*daisyCompute.TestClient).GetInstanceFn = func(_, _, _ string) (*compute.Instance, error) {
+		secret := "this is a test"
+		return &compute.Instance{Metadata: &compute.Metadata{Fingerprint: "fp", Items: []*compute.MetadataItems{{Key: "token", Value: &secret}, {Key: "other", Value: &other}}}}, nil
+	}
+	var setMd *compute.Metadata
+	w.ComputeClient.(*daisyCompute.TestClient).SetInstanceMetadataFn = func(_, _, _ string, md *compute.Metadata) error {
+		setMd = md
+		return nil
+	}
+
+	i := &Instance{
+		InstanceBase: InstanceBase{Resource: Resource{daisyName: "i", NoCleanup: true}, Secrets: map[string]string{"token": "token"}, MetadataFromFile: map[string]string{"user-data": "token"}},
+		Instance:     compute.Instance{Name: "i", Metadata: &compute.Metadata{Items: []*compute.MetadataItems{{Key: "other", Value: &other}}}},
+	}
+	ci := &CreateInstances{Instances: []*Instance{i}}
+	hooks := len(w.cleanupHooks)
+	if err := ci.run(context.Background(), &Step{w: w}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assert.Equal(t, map[string]string{"token": "this is a test", "user-data": "this is a test", "other": "other"}, gotMd)
+	assert.Equal(t, []*compute.MetadataItems{{Key: "other", Value: &other}}, i.Instance.Metadata.Items, "secret kept in workflow metadata")
+
+	// The instance isn't cleaned up, so cleanup removes the secret from it.
+	if assert.Equal(t, hooks+1, len(w.cleanupHooks)) {
+		if err := w.cleanupHooks[hooks](); err != nil {
+			t.Errorf("unexpected cleanup error: %v", err)
+		}
+		assert.Equal(t, &compute.Metadata{Fingerprint: "fp", Items: []*compute.MetadataItems{{Key: "other", Value: &other}}}, setMd)
+	}
+}
+
+func TestCreateInstancesRunMachineTypeFallbacks(t *testing.T) {
+	exhaustedErr := Errf("Some error\nCode: ZONE_RESOURCE_POOL_EXHAUSTED\nMessage: some message.")
+	otherErr := Errf("client error")
+	tests := []struct {
+		desc      string
+		errs      map[string]DError
+		fallbacks []string
+		wantMts   []string
+		wantErr   DError
+	}{
+		{"no fallback needed case", nil, []string{"mt2"}, []string{"mt1"}, nil},
+		{"fallback case", map[string]DError{"mt1": exhaustedErr}, []string{"mt2", "mt3"}, []string{"mt1", "mt2"}, nil},
+		{"all exhausted case", map[string]DError{"mt1": exhaustedErr, "mt2": exhaustedErr}, []string{"mt2"}, []string{"mt1", "mt2"}, exhaustedErr},
+		{"other error case", map[string]DError{"mt1": otherErr}, []string{"mt2"}, []string{"mt1"}, otherErr},
+	}
+
+	for _, tt := range tests {
+		w := testWorkflow()
+		var gotMts []string
+		w.ComputeClient.(*daisyCompute.TestClient).CreateInstanceFn = func(_, _ string, i *compute.Instance) error {
+			gotMts = append(gotMts, i.MachineType)
+			if err, ok := tt.errs[i.MachineType]; ok {
+				return err
+			}
+			return nil
+		}
+		i := &Instance{InstanceBase: InstanceBase{Resource: Resource{daisyName: "i"}, MachineTypeFallbacks: tt.fallbacks}, Instance: compute.Instance{Name: "i", MachineType: "mt1"}}
+		ci := &CreateInstances{Instances: []*Instance{i}}
+		err := ci.run(context.Background(), &Step{w: w})
+		assert.True(t, errors.Is(err, tt.wantErr), "%s: got error %v, want %v", tt.desc, err, tt.wantErr)
+		assert.Equal(t, tt.wantMts, gotMts, tt.desc)
+	}
+}
+
+func TestCreateInstancesRunInstanceTimeRecords(t *testing.T) {
+	w := testWorkflow()
+	w.ComputeClient.(*daisyCompute.TestClient).CreateInstanceFn = func(_, _ string, i *compute.Instance) error {
+		time.Sleep(time.Millisecond)
+		return nil
+	}
+	ci := &CreateInstances{Instances: []*Instance{{InstanceBase: InstanceBase{Resource: Resource{daisyName: "i"}}, Instance: compute.Instance{Name: "real-i", MachineType: "mt"}}}}
+	if err := ci.run(context.Background(), &Step{name: "create", w: w}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	times := w.GetInstanceTimeRecords()
+	if assert.Len(t, times, 1) {
+		assert.Equal(t, "real-i", times[0].Instance)
+		assert.True(t, times[0].CreateDuration() >= time.Millisecond, "got CreateDuration %s, want at least 1ms", times[0].CreateDuration())
+	}
+}
+
+func TestCreateInstancesRunPreInsertInstance(t *testing.T) {
+	w := testWorkflow()
+	var mx sync.Mutex
+	var gotHostnames []string
+	w.ComputeClient.(*daisyCompute.TestClient).CreateInstanceFn = func(_, _ string, i *compute.Instance) error {
+		mx.Lock()
+		defer mx.Unlock()
+		gotHostnames = append(gotHostnames, i.Hostname)
+		return nil
+	}
+	w.ComputeClient.(*daisyCompute.TestClient).CreateInstanceBetaFn = func(_, _ string, i *computeBeta.Instance) error {
+		mx.Lock()
+		defer mx.Unlock()
+		gotHostnames = append(gotHostnames, i.Hostname)
+		return nil
+	}
+	var gotSteps []string
+	w.PreInsertInstance = func(step string, ii InstanceInterface) error {
+		mx.Lock()
+		gotSteps = append(gotSteps, step)
+		mx.Unlock()
+		switch i := ii.(type) {
+		case *Instance:
+			i.Hostname = "host.example.com"
+		case *InstanceBeta:
+			if i.Name == "bad" {
+				return errors.New("hook error")
+			}
+			i.Hostname = "beta.example.com"
+		}
+		return nil
+	}
+	s := &Step{name: "create", w: w}
+
+	ci := &CreateInstances{Instances: []*Instance{
+		{InstanceBase: InstanceBase{Resource: Resource{daisyName: "i0"}}, Instance: compute.Instance{Name: "i0", MachineType: "mt"}},
+		{InstanceBase: InstanceBase{Resource: Resource{daisyName: "i1"}}, Instance: compute.Instance{Name: "i1", MachineType: "mt"}},
+	}}
+	if err := ci.run(context.Background(), s); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	assert.Equal(t, []string{"host.example.com", "host.example.com"}, gotHostnames)
+	assert.Equal(t, []string{"create", "create"}, gotSteps)
+
+	gotHostnames = nil
+	ci = &CreateInstances{InstancesBeta: []*InstanceBeta{{InstanceBase: InstanceBase{Resource: Resource{daisyName: "ib"}}, Instance: computeBeta.Instance{Name: "ib", MachineType: "mt", SourceMachineImage: "mi"}}}}
+	if err := ci.run(context.Background(), s); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	assert.Equal(t, []string{"beta.example.com"}, gotHostnames)
+
+	gotHostnames = nil
+	ci = &CreateInstances{InstancesBeta: []*InstanceBeta{{InstanceBase: InstanceBase{Resource: Resource{daisyName: "bad"}}, Instance: computeBeta.Instance{Name: "bad", MachineType: "mt", SourceMachineImage: "mi"}}}}
+	want := `PreInsertInstance failed for instance "bad": hook error`
+	if err := ci.run(context.Background(), s); err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("did not get expected error, got: %v, want: %q", err, want)
+	}
+	if gotHostnames != nil {
+		t.Errorf("instance should not have been inserted, got: %q", gotHostnames)
+	}
+}
+
+func TestLogSerialOutputTimedOut(t *testing.T) {
+	tests := []struct {
+		desc       string
+		cancel     CancelReason
+		status     string
+		wantReason string
+		wantTail   string
+	}{
+		{"step timeout while running", CancelReason{Kind: CancelReasonStepTimeout, Step: "wait"}, "RUNNING", SerialLogEndTimedOut, "boot\nhung"},
+		{"step timeout after stopping", CancelReason{Kind: CancelReasonStepTimeout, Step: "wait"}, "TERMINATED", SerialLogEndCanceled, ""},
+		{"user cancel", CancelReason{Kind: CancelReasonUser}, "RUNNING", SerialLogEndCanceled, ""},
+	}
+	for _, tt := range tests {
+		w := testWorkflow()
+		// The workflow is canceled during the first read, a step timeout
+		// gets a final read of the remaining output.
+		responses := []string{"boot\n", "hung\n"}
+		callNum := 0
+		w.ComputeClient.(*daisyCompute.TestClient).GetSerialPortOutputFn = func(_, _, _ string, _, next int64) (*compute.SerialPortOutput, error) {
+			if callNum >= len(responses) {
+				return nil, errors.New("fail")
+			}
+			response := responses[callNum]
+			if callNum == 0 {
+				w.setCancelReason(tt.cancel)
+				close(w.Cancel)
+			}
+			callNum++
+			return &compute.SerialPortOutput{Contents: response, Next: next + int64(len(response))}, nil
+		}
+		w.ComputeClient.(*daisyCompute.TestClient).InstanceStatusFn = func(_, _, _ string) (string, error) {
+			return tt.status, nil
+		}
+		sw := &testSerialLogWriter{}
+		w.SerialLogWriter = func(string, int64) (io.WriteCloser, error) {
+			return sw, nil
+		}
+
+		i := Instance{Instance: compute.Instance{Name: "i1"}}
+		if err := logSerialOutput(context.Background(), &Step{name: "foo", w: w}, &i, &i.InstanceBase, 1, 1*time.Microsecond); err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+		wantOutput := "boot\n"
+		if tt.cancel.Kind == CancelReasonStepTimeout {
+			wantOutput = "boot\nhung\n"
+		}
+		if sw.String() != wantOutput {
+			t.Errorf("%s: got output %q, want %q", tt.desc, sw.String(), wantOutput)
+		}
+		want := []SerialLogRecord{{Instance: "i1", Port: 1, Bytes: int64(len(wantOutput)), Reason: tt.wantReason, Tail: tt.wantTail}}
+		if diffRes := diff(w.GetSerialLogRecords(), want, 0); diffRes != "" {
+			t.Errorf("%s: serial log records not as expected: (-got +want)\n%s", tt.desc, diffRes)
+		}
+	}
+}
+
+func TestLastLines(t *testing.T) {
+	tests := []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{"", 2, ""},
+		{"a", 2, "a"},
+		{"a\nb\nc\n", 2, "b\nc"},
+		{"a\nb\nc", 2, "b\nc"},
+		{"a\nb\nc", 3, "a\nb\nc"},
+		{"a\nb\nc", 5, "a\nb\nc"},
+	}
+	for _, tt := range tests {
+		if got := lastLines(tt.s, tt.n); got != tt.want {
+			t.Errorf("lastLines(%q, %d) = %q, want %q", tt.s, tt.n, got, tt.want)
+		}
+	}
+}
@@ -62,16 +62,16 @@ func (c *CreateMachineImages) run(ctx context.Context, s *Step) DError {
 				// Just try to delete it, a 404 here indicates the machine image doesn't exist.
 				if err := w.ComputeClient.DeleteMachineImage(mi.Project, mi.Name); err != nil {
 					if apiErr, ok := err.(*googleapi.Error); !ok || apiErr.Code != 404 {
-						eChan <- Errf("error deleting existing machine image: %v", err)
+						eChan <- mi.wrapErr(Errf("error deleting existing machine image: %v", err), "machine image")
 						return
 					}
 				}
 			}
 
-			w.LogStepInfo(s.name, "CreateMachineImages", "Creating machine image %q.", mi.Name)
+			w.logResourceCreation(s, "CreateMachineImages", "machine image", &mi.Resource)
 
 			if err := w.ComputeClient.CreateMachineImage(mi.Project, &mi.MachineImage); err != nil {
-				eChan <- newErr("failed to create machine image", err)
+				eChan <- mi.wrapErr(newErr("failed to create machine image", err), "machine image")
 				return
 			}
 			mi.createdInWorkflow = true
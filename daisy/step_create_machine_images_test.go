@@ -16,7 +16,9 @@ package daisy
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"testing"
 
 	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
@@ -92,7 +94,7 @@ func TestCreateMachineImagesRunFailureOnComputeCreateError(t *testing.T) {
 	cmi := &CreateMachineImages{
 		{Resource: Resource{daisyName: "mi0"}, MachineImage: computeBeta.MachineImage{Name: "realMI0", SourceInstance: "si"}},
 	}
-	if err := cmi.run(ctx, s); err != createErr {
+	if err := cmi.run(ctx, s); !errors.Is(err, createErr) {
 		t.Errorf("CreateInstances.run() should have return compute client error: %v != %v", err, createErr)
 	}
 }
@@ -115,7 +117,7 @@ func TestCreateMachineImagesRunFailureOnComputeDeleteOnOverwriteError(t *testing
 		{OverWrite: true, Resource: Resource{daisyName: "mi0"}, MachineImage: computeBeta.MachineImage{Name: "realMI0", SourceInstance: "si"}},
 	}
 	expectedErrorMessage := fmt.Sprintf("error deleting existing machine image: %v", deleteErr)
-	if err := cmi.run(ctx, s); fmt.Sprintf("%v", err) != expectedErrorMessage {
+	if err := cmi.run(ctx, s); !strings.HasSuffix(fmt.Sprintf("%v", err), expectedErrorMessage) {
 		t.Errorf("CreateInstances.run() should have return compute client error: %v != %v", err, expectedErrorMessage)
 	}
 }
@@ -47,9 +47,9 @@ func (c *CreateNetworks) run(ctx context.Context, s *Step) DError {
 		go func(n *Network) {
 			defer wg.Done()
 
-			w.LogStepInfo(s.name, "CreateNetworks", "Creating network %q.", n.Name)
+			w.logResourceCreation(s, "CreateNetworks", "network", &n.Resource)
 			if err := w.ComputeClient.CreateNetwork(n.Project, &n.Network); err != nil {
-				e <- newErr("failed to create networks", err)
+				e <- n.wrapErr(newErr("failed to create networks", err), "network")
 				return
 			}
 			n.createdInWorkflow = true
@@ -51,9 +51,9 @@ func (c *CreateSubnetworks) run(ctx context.Context, s *Step) DError {
 				sn.Network = networkRes.link
 			}
 
-			w.LogStepInfo(s.name, "CreateSubnetworks", "Creating subnetwork %q.", sn.Name)
+			w.logResourceCreation(s, "CreateSubnetworks", "subnetwork", &sn.Resource)
 			if err := w.ComputeClient.CreateSubnetwork(sn.Project, sn.Region, &sn.Subnetwork); err != nil {
-				e <- newErr("failed to create subnetworks", err)
+				e <- sn.wrapErr(newErr("failed to create subnetworks", err), "subnetwork")
 				return
 			}
 			sn.createdInWorkflow = true
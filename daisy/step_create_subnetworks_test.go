@@ -16,6 +16,7 @@ package daisy
 
 import (
 	"context"
+	"errors"
 	"testing"
 
 	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
@@ -50,7 +51,7 @@ func TestCreateSubnetworksRun(t *testing.T) {
 		w.ComputeClient = &daisyCompute.TestClient{CreateSubnetworkFn: fake}
 		cds := &CreateSubnetworks{{Subnetwork: tt.n}}
 		cds.populate(ctx, s)
-		if err := cds.run(ctx, s); err != tt.wantErr {
+		if err := cds.run(ctx, s); !errors.Is(err, tt.wantErr) {
 			t.Errorf("%s: unexpected error returned, got: %v, want: %v", tt.desc, err, tt.wantErr)
 		}
 		if diff := pretty.Compare(gotN, tt.wantN); diff != "" {
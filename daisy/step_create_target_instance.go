@@ -47,9 +47,9 @@ func (c *CreateTargetInstances) run(ctx context.Context, s *Step) DError {
 		go func(ti *TargetInstance) {
 			defer wg.Done()
 
-			w.LogStepInfo(s.name, "CreateTargetInstances", "Creating target instance %q.", ti.Name)
+			w.logResourceCreation(s, "CreateTargetInstances", "target instance", &ti.Resource)
 			if err := w.ComputeClient.CreateTargetInstance(ti.Project, ti.Zone, &ti.TargetInstance); err != nil {
-				e <- newErr("failed to create target instances", err)
+				e <- ti.wrapErr(newErr("failed to create target instances", err), "target instance")
 				return
 			}
 			ti.createdInWorkflow = true
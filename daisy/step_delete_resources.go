@@ -187,26 +187,9 @@ func (d *DeleteResources) validate(ctx context.Context, s *Step) DError {
 		}
 
 		// Check if bucket exists and is writeable.
-		writableBkts.mx.Lock()
-		if !strIn(bkt, writableBkts.bkts) {
-			if _, err := s.w.StorageClient.Bucket(bkt).Attrs(ctx); err != nil {
-				return Errf("error reading bucket %q: %v", bkt, err)
-			}
-
-			tObj := s.w.StorageClient.Bucket(bkt).Object(fmt.Sprintf("daisy-validate-%s-%s", s.name, s.w.id))
-			w := tObj.NewWriter(ctx)
-			if _, err := w.Write(nil); err != nil {
-				return newErr("failed to write to GCS object when deleting resources", err)
-			}
-			if err := w.Close(); err != nil {
-				return Errf("error writing to bucket %q: %v", bkt, err)
-			}
-			if err := tObj.Delete(ctx); err != nil {
-				return Errf("error deleting file %+v after write validation: %v", tObj, err)
-			}
-			writableBkts.bkts = append(writableBkts.bkts, bkt)
+		if err := validateBktWritable(ctx, s.w.StorageClient, bkt, fmt.Sprintf("daisy-validate-%s-%s", s.name, s.w.id)); err != nil {
+			return err
 		}
-		writableBkts.mx.Unlock()
 	}
 
 	return nil
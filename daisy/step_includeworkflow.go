@@ -54,6 +54,22 @@ func (i *IncludeWorkflow) populate(ctx context.Context, s *Step) DError {
 	i.Workflow.Name = i.Workflow.parent.Name
 	i.Workflow.Project = i.Workflow.parent.Project
 	i.Workflow.Zone = i.Workflow.parent.Zone
+	i.Workflow.Region = i.Workflow.parent.Region
+	i.Workflow.ImageProject = i.Workflow.parent.ImageProject
+	i.Workflow.MaxSerialBytes = i.Workflow.parent.MaxSerialBytes
+	i.Workflow.FailOnMaxSerialBytes = i.Workflow.parent.FailOnMaxSerialBytes
+	i.Workflow.SerialLogContentType = i.Workflow.parent.SerialLogContentType
+	i.Workflow.SerialLogMetadata = i.Workflow.parent.SerialLogMetadata
+	i.Workflow.SerialLogStorageClass = i.Workflow.parent.SerialLogStorageClass
+	i.Workflow.SerialLogTimestamps = i.Workflow.parent.SerialLogTimestamps
+	i.Workflow.LogsStorageClass = i.Workflow.parent.LogsStorageClass
+	i.Workflow.LogsObjectMetadata = i.Workflow.parent.LogsObjectMetadata
+	i.Workflow.OutsStorageClass = i.Workflow.parent.OutsStorageClass
+	i.Workflow.OutsObjectMetadata = i.Workflow.parent.OutsObjectMetadata
+	i.Workflow.ObjectPredefinedACL = i.Workflow.parent.ObjectPredefinedACL
+	i.Workflow.ObjectACLRules = i.Workflow.parent.ObjectACLRules
+	i.Workflow.Proxy = i.Workflow.parent.Proxy
+	i.Workflow.SourceUploadChunkSize = i.Workflow.parent.SourceUploadChunkSize
 	i.Workflow.DefaultTimeout = i.Workflow.parent.DefaultTimeout
 	i.Workflow.autovars = i.Workflow.parent.autovars
 	i.Workflow.bucket = i.Workflow.parent.bucket
@@ -63,6 +79,10 @@ func (i *IncludeWorkflow) populate(ctx context.Context, s *Step) DError {
 	i.Workflow.outsPath = i.Workflow.parent.outsPath
 	i.Workflow.externalLogging = i.Workflow.parent.externalLogging
 	i.Workflow.Logger = i.Workflow.parent.Logger
+	i.Workflow.SerialLogWriter = i.Workflow.parent.SerialLogWriter
+	i.Workflow.PreInsertInstance = i.Workflow.parent.PreInsertInstance
+	i.Workflow.AdoptExistingResources = i.Workflow.parent.AdoptExistingResources
+	i.Workflow.NoCleanupNameTemplate = i.Workflow.parent.NoCleanupNameTemplate
 	i.Workflow.Name = s.name
 	i.Workflow.DefaultTimeout = s.Timeout
 
@@ -0,0 +1,166 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+
+	"google.golang.org/api/compute/v1"
+)
+
+const (
+	defaultWorkerImage        = "projects/debian-cloud/global/images/family/debian-12"
+	defaultMountAndRunSuccess = "MountAndRunSuccess"
+	defaultMountAndRunFailure = "MountAndRunFailure"
+	// mountAndRunDeviceName is the device name of the disk on the worker, the
+	// guest sees it as /dev/disk/by-id/google-daisy-target.
+	mountAndRunDeviceName = "daisy-target"
+)
+
+// MountAndRun is a Daisy MountAndRun workflow step. It attaches a disk to a
+// temporary worker instance, runs a startup script on the worker that
+// mounts and modifies the disk, deletes the worker and optionally creates an
+// image from the disk.
+//
+// The step is expanded into an included workflow of CreateInstances,
+// WaitForInstancesSignal, DeleteResources and CreateImages steps. Workflows
+// that need more control over the worker can use those steps directly.
+type MountAndRun struct {
+	// Disk to mount, either a workflow disk name or a partial URL. It is
+	// attached READ_WRITE as /dev/disk/by-id/google-daisy-target.
+	Disk string
+	// StartupScript is the Sources path to the script run on the worker. It
+	// may be omitted if WorkerImage is set to an image that does the work
+	// itself, e.g. from a baked-in systemd unit.
+	StartupScript string `json:",omitempty"`
+	// WorkerImage is the worker's boot disk image, defaults to debian-12.
+	WorkerImage string `json:",omitempty"`
+	// MachineType of the worker, defaults to the CreateInstances default.
+	MachineType string `json:",omitempty"`
+	// Metadata set on the worker, for the script to read.
+	Metadata map[string]string `json:",omitempty"`
+	// SuccessMatch is printed to the worker's serial port 1 by the script
+	// when it's done, defaults to "MountAndRunSuccess".
+	SuccessMatch string `json:",omitempty"`
+	// FailureMatch fails the step if printed, defaults to
+	// "MountAndRunFailure".
+	FailureMatch FailureMatches `json:"failureMatch,omitempty"`
+	// Image to create from Disk once the worker is deleted. SourceDisk is
+	// set to Disk.
+	Image *Image `json:",omitempty"`
+
+	include *IncludeWorkflow
+}
+
+func (m *MountAndRun) populate(ctx context.Context, s *Step) DError {
+	m.WorkerImage = strOr(m.WorkerImage, defaultWorkerImage)
+	m.SuccessMatch = strOr(m.SuccessMatch, defaultMountAndRunSuccess)
+	if len(m.FailureMatch) == 0 {
+		m.FailureMatch = FailureMatches{defaultMountAndRunFailure}
+	}
+	if m.StartupScript != "" && !s.w.sourceExists(m.StartupScript) {
+		return Errf("bad value for StartupScript, source not found: %s", m.StartupScript)
+	}
+	m.include = &IncludeWorkflow{Workflow: m.workflow(s.name)}
+	return m.include.populate(ctx, s)
+}
+
+// workflow builds the workflow the step is expanded into. Its resources are
+// named after the step, as they share the parent's namespace.
+func (m *MountAndRun) workflow(name string) *Workflow {
+	ti := &tempInstance{
+		name:          name + "-worker",
+		image:         m.WorkerImage,
+		machineType:   m.MachineType,
+		startupScript: m.StartupScript,
+		disks:         []*compute.AttachedDisk{{Source: m.Disk, DeviceName: mountAndRunDeviceName, Mode: diskModeRW}},
+		metadata:      m.Metadata,
+		successMatch:  m.SuccessMatch,
+		failureMatch:  m.FailureMatch,
+	}
+	iw := ti.workflow("create-worker", "wait-for-worker", "delete-worker")
+	if m.Image != nil {
+		m.Image.SourceDisk = m.Disk
+		iw.Steps["create-image"] = &Step{CreateImages: &CreateImages{Images: []*Image{m.Image}}}
+		iw.Dependencies["create-image"] = []string{"delete-worker"}
+	}
+	return iw
+}
+
+// tempInstance is the temporary instance of the workflows MountAndRun and
+// TestImageBoots are expanded into.
+type tempInstance struct {
+	name, image, machineType, startupScript string
+	// disks are attached besides the boot disk, booted from image.
+	disks    []*compute.AttachedDisk
+	metadata map[string]string
+	// successMatch and failureMatch are waited for on serial port 1.
+	successMatch string
+	failureMatch FailureMatches
+}
+
+// workflow builds a workflow whose steps create, wait and del create the
+// instance, wait for it to signal on serial port 1 and delete it.
+func (ti *tempInstance) workflow(create, wait, del string) *Workflow {
+	metadata := map[string]string{}
+	for k, v := range ti.metadata {
+		metadata[k] = v
+	}
+	disks := append([]*compute.AttachedDisk{
+		{Boot: true, AutoDelete: true, InitializeParams: &compute.AttachedDiskInitializeParams{SourceImage: ti.image}},
+	}, ti.disks...)
+
+	iw := New()
+	if ti.startupScript != "" {
+		// The script is one of the parent's Sources, the empty value isn't
+		// copied back up.
+		iw.Sources = map[string]string{ti.startupScript: ""}
+	}
+	iw.Steps = map[string]*Step{
+		create: {CreateInstances: &CreateInstances{Instances: []*Instance{{
+			Instance:     compute.Instance{Name: ti.name, MachineType: ti.machineType, Disks: disks},
+			InstanceBase: InstanceBase{StartupScript: ti.startupScript},
+			Metadata:     metadata,
+		}}}},
+		wait: {WaitForInstancesSignal: &WaitForInstancesSignal{{
+			Name:         ti.name,
+			SerialOutput: &SerialOutput{Port: 1, SuccessMatch: ti.successMatch, FailureMatch: ti.failureMatch},
+		}}},
+		del: {DeleteResources: &DeleteResources{Instances: []string{ti.name}}},
+	}
+	iw.Dependencies = map[string][]string{
+		wait: {create},
+		del:  {wait},
+	}
+	return iw
+}
+
+func (m *MountAndRun) validate(ctx context.Context, s *Step) (errs DError) {
+	if m.Disk == "" {
+		errs = addErrs(errs, Errf("cannot mount and run: Disk is empty"))
+	}
+	// The default worker image has nothing to run without a script.
+	if m.StartupScript == "" && strOr(m.WorkerImage, defaultWorkerImage) == defaultWorkerImage {
+		errs = addErrs(errs, Errf("cannot mount and run: StartupScript is empty, it's required unless WorkerImage is set"))
+	}
+	if errs != nil {
+		return errs
+	}
+	return m.include.validate(ctx, s)
+}
+
+func (m *MountAndRun) run(ctx context.Context, s *Step) DError {
+	return m.include.run(ctx, s)
+}
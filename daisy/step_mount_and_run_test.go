@@ -0,0 +1,155 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"google.golang.org/api/compute/v1"
+)
+
+func TestMountAndRunPopulate(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	w.Sources = map[string]string{"script.sh": "path/script.sh"}
+	s, _ := w.NewStep("customize")
+	s.MountAndRun = &MountAndRun{
+		Disk:          "target",
+		StartupScript: "script.sh",
+		Metadata:      map[string]string{"key": "value"},
+		Image:         &Image{Image: compute.Image{Name: "image"}},
+	}
+	if err := w.populateStep(ctx, s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	m := s.MountAndRun
+	if m.WorkerImage != defaultWorkerImage || m.SuccessMatch != defaultMountAndRunSuccess {
+		t.Errorf("got WorkerImage %q and SuccessMatch %q, want defaults", m.WorkerImage, m.SuccessMatch)
+	}
+	iw := m.include.Workflow
+	if iw.parent != w || iw.Name != "customize" {
+		t.Errorf("included workflow not set up, got parent %v and name %q", iw.parent, iw.Name)
+	}
+	wantDeps := map[string][]string{
+		"wait-for-worker": {"create-worker"},
+		"delete-worker":   {"wait-for-worker"},
+		"create-image":    {"delete-worker"},
+	}
+	if diffRes := diff(iw.Dependencies, wantDeps, 0); diffRes != "" {
+		t.Errorf("dependencies not as expected: (-got,+want)\n%s", diffRes)
+	}
+
+	i := iw.Steps["create-worker"].CreateInstances.Instances[0]
+	if i.daisyName != "customize-worker" {
+		t.Errorf("got worker name %q, want %q", i.daisyName, "customize-worker")
+	}
+	if d := i.Disks[1]; d.Source != "target" || d.DeviceName != mountAndRunDeviceName || d.Mode != diskModeRW {
+		t.Errorf("got target disk %q, device name %q and mode %q, want %q, %q and %q", d.Source, d.DeviceName, d.Mode, "target", mountAndRunDeviceName, diskModeRW)
+	}
+	if i.Metadata["key"] != "value" || i.Metadata["startup-script-url"] == "" {
+		t.Errorf("worker metadata not set, got %v", i.Metadata)
+	}
+	if len(w.Sources) != 1 {
+		t.Errorf("parent Sources changed, got %v", w.Sources)
+	}
+
+	so := iw.Steps["wait-for-worker"].WaitForInstancesSignal
+	if got := (*so)[0].SerialOutput; got.SuccessMatch != defaultMountAndRunSuccess || len(got.FailureMatch) != 1 || got.FailureMatch[0] != defaultMountAndRunFailure {
+		t.Errorf("got serial output %+v, want default matches", got)
+	}
+	if got := iw.Steps["create-image"].CreateImages.Images[0].SourceDisk; got != "target" {
+		t.Errorf("got image SourceDisk %q, want %q", got, "target")
+	}
+}
+
+func TestMountAndRunPopulateNoStartupScript(t *testing.T) {
+	w := testWorkflow()
+	s, _ := w.NewStep("customize")
+	s.MountAndRun = &MountAndRun{Disk: "target", WorkerImage: "projects/p/global/images/baked-worker"}
+	if err := w.populateStep(context.Background(), s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	iw := s.MountAndRun.include.Workflow
+	if len(iw.Sources) != 0 {
+		t.Errorf("got Sources %v, want none", iw.Sources)
+	}
+	i := iw.Steps["create-worker"].CreateInstances.Instances[0]
+	if _, ok := i.Metadata["startup-script-url"]; ok {
+		t.Errorf("worker metadata has a startup script, got %v", i.Metadata)
+	}
+	if got := (*iw.Steps["wait-for-worker"].WaitForInstancesSignal)[0].SerialOutput.SuccessMatch; got != defaultMountAndRunSuccess {
+		t.Errorf("got SuccessMatch %q, want %q", got, defaultMountAndRunSuccess)
+	}
+}
+
+func TestMountAndRunPopulateSourceNotFound(t *testing.T) {
+	w := testWorkflow()
+	s, _ := w.NewStep("customize")
+	s.MountAndRun = &MountAndRun{Disk: "target", StartupScript: "dne.sh"}
+	if err := w.populateStep(context.Background(), s); err == nil {
+		t.Error("should have returned an error")
+	}
+}
+
+func TestMountAndRunValidate(t *testing.T) {
+	w := testWorkflow()
+	s, _ := w.NewStep("customize")
+
+	tests := []struct {
+		desc string
+		m    *MountAndRun
+	}{
+		{"no disk case", &MountAndRun{StartupScript: "script.sh"}},
+		{"no script case", &MountAndRun{Disk: "target"}},
+		{"no script with default worker image case", &MountAndRun{Disk: "target", WorkerImage: defaultWorkerImage}},
+	}
+	for _, tt := range tests {
+		if err := tt.m.validate(context.Background(), s); err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		}
+	}
+}
+
+func TestMountAndRunValidateDiskDependency(t *testing.T) {
+	ctx := context.Background()
+	for _, dep := range []bool{true, false} {
+		w := testWorkflow()
+		w.Sources = map[string]string{"script.sh": "path/script.sh"}
+		w.ComputeClient.(*daisyCompute.TestClient).ListNetworksFn = func(_ string, _ ...daisyCompute.ListCallOption) ([]*compute.Network, error) {
+			return []*compute.Network{{Name: "default"}}, nil
+		}
+		create, _ := w.NewStep("create-disk")
+		w.disks.m = map[string]*Resource{"target": {creator: create, link: fmt.Sprintf("projects/%s/zones/%s/disks/target", testProject, testZone)}}
+		s, _ := w.NewStep("customize")
+		s.MountAndRun = &MountAndRun{Disk: "target", StartupScript: "script.sh", MachineType: testMachineType}
+		if dep {
+			w.AddDependency(s, create)
+		}
+		if err := w.populateStep(ctx, s); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		err := s.MountAndRun.validate(ctx, s)
+		if dep && err != nil {
+			t.Errorf("unexpected error: %v", err)
+		} else if !dep && err == nil {
+			t.Error("should have returned an error for a disk created by a step it doesn't depend on")
+		}
+	}
+}
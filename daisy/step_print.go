@@ -0,0 +1,42 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+)
+
+// Print is a Daisy Print workflow step, it writes a message to the workflow
+// logs when run.
+type Print struct {
+	// Message to log. Vars are substituted like in any other step field.
+	Message string
+}
+
+func (p *Print) populate(ctx context.Context, s *Step) DError {
+	return nil
+}
+
+func (p *Print) validate(ctx context.Context, s *Step) DError {
+	if p.Message == "" {
+		return Errf("cannot print: Message is empty")
+	}
+	return nil
+}
+
+func (p *Print) run(ctx context.Context, s *Step) DError {
+	s.w.LogStepInfo(s.name, "Print", "%s", p.Message)
+	return nil
+}
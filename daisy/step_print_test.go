@@ -0,0 +1,68 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"testing"
+)
+
+func TestPrintValidate(t *testing.T) {
+	tests := []struct {
+		desc      string
+		p         *Print
+		shouldErr bool
+	}{
+		{"normal case", &Print{Message: "foo"}, false},
+		{"empty message case", &Print{}, true},
+	}
+
+	for _, tt := range tests {
+		w := testWorkflow()
+		s := &Step{name: "s", w: w, Print: tt.p}
+		err := tt.p.validate(context.Background(), s)
+		if tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+	}
+}
+
+func TestPrintRun(t *testing.T) {
+	w := testWorkflow()
+	w.Vars = map[string]Var{"image": {Value: "my-image"}}
+	w.Steps = map[string]*Step{"print": {Print: &Print{Message: "building image ${image}"}}}
+	if err := w.populate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	mockLogger := &MockLogger{}
+	w.Logger = mockLogger
+
+	s := w.Steps["print"]
+	if err := s.Print.run(context.Background(), s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	entries := mockLogger.getEntries()
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 log entry, got %d", len(entries))
+	}
+	if got, want := entries[0].Message, "building image my-image"; got != want {
+		t.Errorf("got message %q, want %q", got, want)
+	}
+	if got, want := entries[0].StepType, "Print"; got != want {
+		t.Errorf("got step type %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,145 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// ResizeInstances is a Daisy ResizeInstances workflow step.
+type ResizeInstances []*ResizeInstance
+
+// ResizeInstance changes the machine type of a GCE instance. A running
+// instance is stopped for the change and started again, a stopped one is
+// left stopped.
+type ResizeInstance struct {
+	// Name of the instance to resize.
+	Name string
+	// MachineType to change to, either a name or a partial URL in the
+	// instance's zone.
+	MachineType string
+
+	project, zone, instance string
+}
+
+func (r *ResizeInstances) populate(ctx context.Context, s *Step) DError {
+	for _, ri := range *r {
+		if instanceURLRgx.MatchString(ri.Name) {
+			ri.Name = extendPartialURL(ri.Name, s.w.Project)
+		}
+	}
+	return nil
+}
+
+func (r *ResizeInstances) validate(ctx context.Context, s *Step) DError {
+	var errs DError
+	for _, ri := range *r {
+		ir, err := s.w.instances.regUse(ri.Name, s)
+		if ir == nil {
+			// Return now, the rest of this function can't be run without ir.
+			return addErrs(errs, Errf("cannot resize instance: %v", err))
+		}
+		m := NamedSubexp(instanceURLRgx, ir.link)
+		ri.project, ri.zone, ri.instance = m["project"], m["zone"], m["instance"]
+
+		pre := fmt.Sprintf("cannot resize instance %q", ri.Name)
+		if ri.MachineType == "" {
+			errs = addErrs(errs, Errf("%s: MachineType is empty", pre))
+			continue
+		}
+		if !machineTypeURLRegex.MatchString(ri.MachineType) {
+			ri.MachineType = fmt.Sprintf("zones/%s/machineTypes/%s", ri.zone, ri.MachineType)
+		}
+		result := NamedSubexp(machineTypeURLRegex, ri.MachineType)
+		if result["project"] != "" && result["project"] != ri.project {
+			errs = addErrs(errs, Errf("%s: MachineType %q is not in the instance's project %q", pre, ri.MachineType, ri.project))
+			continue
+		}
+		if result["zone"] != ri.zone {
+			errs = addErrs(errs, Errf("%s: MachineType %q is not in the instance's zone %q", pre, ri.MachineType, ri.zone))
+			continue
+		}
+		if exists, err := s.w.machineTypeExists(ri.project, ri.zone, result["machinetype"]); err != nil {
+			errs = addErrs(errs, Errf("%s: bad machineType lookup: %q, error: %v", pre, result["machinetype"], err))
+		} else if !exists {
+			errs = addErrs(errs, Errf("%s: machineType does not exist: %q", pre, result["machinetype"]))
+		}
+	}
+	return errs
+}
+
+func (r *ResizeInstances) run(ctx context.Context, s *Step) DError {
+	var wg sync.WaitGroup
+	w := s.w
+	e := make(chan DError)
+	for _, ri := range *r {
+		wg.Add(1)
+		go func(ri *ResizeInstance) {
+			defer wg.Done()
+			if err := ri.resize(ctx, s); err != nil {
+				e <- err
+			}
+		}(ri)
+	}
+
+	go func() {
+		wg.Wait()
+		e <- nil
+	}()
+
+	select {
+	case err := <-e:
+		return err
+	case <-w.Cancel:
+		return nil
+	}
+}
+
+// resize stops the instance if it's running, changes its machine type and
+// starts it again, waiting for each operation. The serial port logs of an
+// instance the workflow created are restarted with it.
+func (ri *ResizeInstance) resize(ctx context.Context, s *Step) DError {
+	w := s.w
+	status, err := w.ComputeClient.InstanceStatus(ri.project, ri.zone, ri.instance)
+	if err != nil {
+		return typedErr(apiError, "failed to get instance status", err)
+	}
+	running := status != "TERMINATED" && status != "STOPPED"
+	if running {
+		w.LogStepInfo(s.name, "ResizeInstances", "Stopping instance %q to change its machine type.", ri.instance)
+		if err := w.ComputeClient.StopInstance(ri.project, ri.zone, ri.instance); err != nil {
+			return typedErr(apiError, "failed to stop instance", err)
+		}
+	}
+	w.LogStepInfo(s.name, "ResizeInstances", "Changing instance %q machine type to %q.", ri.instance, ri.MachineType)
+	if err := w.ComputeClient.SetMachineType(ri.project, ri.zone, ri.instance, ri.MachineType); err != nil {
+		return typedErr(apiError, "failed to set instance machine type", err)
+	}
+	if !running || w.isCanceled() {
+		return nil
+	}
+	w.LogStepInfo(s.name, "ResizeInstances", "Starting instance %q.", ri.instance)
+	if err := w.ComputeClient.StartInstance(ri.project, ri.zone, ri.instance); err != nil {
+		return typedErr(apiError, "failed to start instance", err)
+	}
+	if res, ok := w.instances.get(ri.Name); ok {
+		if ii, ib := createdInstanceOf(res); ii != nil {
+			ib.restartSerialLogs(ctx, s, ii)
+		}
+	}
+	return nil
+}
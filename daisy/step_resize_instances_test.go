@@ -0,0 +1,174 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"google.golang.org/api/compute/v1"
+)
+
+func TestResizeInstancesPopulate(t *testing.T) {
+	w := testWorkflow()
+	s, _ := w.NewStep("s")
+	ri := &ResizeInstances{{Name: "i", MachineType: "m"}, {Name: "zones/z/instances/i", MachineType: "m"}}
+	if err := ri.populate(context.Background(), s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := &ResizeInstances{{Name: "i", MachineType: "m"}, {Name: fmt.Sprintf("projects/%s/zones/z/instances/i", w.Project), MachineType: "m"}}
+	if diffRes := diff(ri, want, 0); diffRes != "" {
+		t.Errorf("ResizeInstances not populated as expected: (-got,+want)\n%s", diffRes)
+	}
+}
+
+func TestResizeInstancesValidate(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	s, _ := w.NewStep("s")
+	iCreator, _ := w.NewStep("iCreator")
+	iCreator.CreateInstances = &CreateInstances{Instances: []*Instance{{}}}
+	w.AddDependency(s, iCreator)
+	if err := w.instances.regCreate("instance1", &Resource{link: fmt.Sprintf("projects/%s/zones/%s/instances/instance1", testProject, testZone)}, false, iCreator); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		desc, name, machineType, want string
+		shouldErr                     bool
+	}{
+		{"name case", "instance1", testMachineType, fmt.Sprintf("zones/%s/machineTypes/%s", testZone, testMachineType), false},
+		{"partial URL case", "instance1", fmt.Sprintf("projects/%s/zones/%s/machineTypes/%s", testProject, testZone, testMachineType), "", false},
+		{"instance DNE case", "dne", testMachineType, "", true},
+		{"no machine type case", "instance1", "", "", true},
+		{"machine type DNE case", "instance1", "dne", "", true},
+		{"other zone case", "instance1", fmt.Sprintf("zones/other-zone/machineTypes/%s", testMachineType), "", true},
+		{"other project case", "instance1", fmt.Sprintf("projects/other-project/zones/%s/machineTypes/%s", testZone, testMachineType), "", true},
+	}
+	for _, tt := range tests {
+		ri := &ResizeInstance{Name: tt.name, MachineType: tt.machineType}
+		err := (&ResizeInstances{ri}).validate(ctx, s)
+		if tt.shouldErr {
+			if err == nil {
+				t.Errorf("%s: should have returned an error", tt.desc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+		if tt.want != "" && ri.MachineType != tt.want {
+			t.Errorf("%s: got MachineType %q, want %q", tt.desc, ri.MachineType, tt.want)
+		}
+	}
+}
+
+func TestResizeInstancesRun(t *testing.T) {
+	ctx := context.Background()
+	mt := fmt.Sprintf("zones/%s/machineTypes/%s", testZone, testMachineType)
+	e := errors.New("error")
+
+	tests := []struct {
+		desc      string
+		status    string
+		clientErr error
+		wantCalls []string
+		shouldErr bool
+	}{
+		{"running case", "RUNNING", nil, []string{"stop", "set " + mt, "start"}, false},
+		{"stopped case", "TERMINATED", nil, []string{"set " + mt}, false},
+		{"set machine type error case", "RUNNING", e, []string{"stop", "set " + mt}, true},
+	}
+	for _, tt := range tests {
+		w := testWorkflow()
+		s, _ := w.NewStep("s")
+		var calls []string
+		tc := w.ComputeClient.(*daisyCompute.TestClient)
+		tc.InstanceStatusFn = func(_, _, _ string) (string, error) { return tt.status, nil }
+		tc.StopInstanceFn = func(_, _, _ string) error { calls = append(calls, "stop"); return nil }
+		tc.SetMachineTypeFn = func(_, _, _, machineType string) error {
+			calls = append(calls, "set "+machineType)
+			return tt.clientErr
+		}
+		tc.StartInstanceFn = func(_, _, _ string) error { calls = append(calls, "start"); return nil }
+
+		ri := &ResizeInstances{{Name: "instance1", MachineType: mt, project: testProject, zone: testZone, instance: "instance1"}}
+		err := ri.run(ctx, s)
+		if tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+		if diffRes := diff(calls, tt.wantCalls, 0); diffRes != "" {
+			t.Errorf("%s: calls not as expected: (-got,+want)\n%s", tt.desc, diffRes)
+		}
+	}
+}
+
+func TestResizeInstancesRunRestartsSerialLogs(t *testing.T) {
+	w := testWorkflow()
+	s, _ := w.NewStep("s")
+	creator, _ := w.NewStep("creator")
+	i := &Instance{InstanceBase: InstanceBase{SerialPorts: []int64{1}}, Instance: compute.Instance{Name: "instance1", Zone: testZone}}
+	i.Project = testProject
+	i.creator = creator
+	creator.CreateInstances = &CreateInstances{Instances: []*Instance{i}}
+	w.instances.m = map[string]*Resource{"instance1": &i.Resource}
+
+	var mx sync.Mutex
+	var calls []string
+	started := false
+	tc := w.ComputeClient.(*daisyCompute.TestClient)
+	tc.InstanceStatusFn = func(_, _, _ string) (string, error) {
+		mx.Lock()
+		defer mx.Unlock()
+		if started {
+			// The restarted logging ends once the instance is stopped.
+			return "TERMINATED", nil
+		}
+		return "RUNNING", nil
+	}
+	tc.StopInstanceFn = func(_, _, _ string) error { return nil }
+	tc.SetMachineTypeFn = func(_, _, _, _ string) error { return nil }
+	tc.StartInstanceFn = func(_, _, _ string) error {
+		mx.Lock()
+		defer mx.Unlock()
+		started = true
+		return nil
+	}
+	tc.GetSerialPortOutputFn = func(_, _, name string, port, _ int64) (*compute.SerialPortOutput, error) {
+		mx.Lock()
+		defer mx.Unlock()
+		calls = append(calls, fmt.Sprintf("%s port %d started %t", name, port, started))
+		return nil, errors.New("error")
+	}
+
+	ri := &ResizeInstances{{Name: "instance1", MachineType: "zones/z/machineTypes/mt", project: testProject, zone: testZone, instance: "instance1"}}
+	if err := ri.run(context.Background(), s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	w.stepWait.Wait()
+	if len(calls) == 0 || calls[0] != "instance1 port 1 started true" {
+		t.Errorf("serial port output not read after the restart, got calls %q", calls)
+	}
+	if i.serialLogRestarts != 1 {
+		t.Errorf("serialLogRestarts = %d, want 1", i.serialLogRestarts)
+	}
+}
@@ -0,0 +1,118 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import "fmt"
+
+func (s *Step) validateRetries() DError {
+	if s.Retries < 0 {
+		return Errf("Retries must not be negative, got %d", s.Retries)
+	}
+	if s.Retries == 0 {
+		return nil
+	}
+	if s.CreateDisks == nil && s.CreateInstances == nil {
+		return Errf("Retries is only supported by CreateDisks and CreateInstances steps")
+	}
+	if s.ReadyWhen == readyWhenFirstRunning {
+		return Errf("Retries can't be used with ReadyWhen %q, dependent steps may use the instances of a failed attempt", s.ReadyWhen)
+	}
+	return nil
+}
+
+// prepareRetry readies the step for attempt attempt+1 after attempt failed.
+// The resources the step created are deleted first, so a retry never runs
+// alongside the leftovers of a failed attempt. Resources with generated
+// names are then given fresh ones, names set with ExactName are kept.
+func (s *Step) prepareRetry(attempt int) DError {
+	var errs DError
+	for _, r := range []*baseResourceRegistry{&s.w.instances.baseResourceRegistry, &s.w.disks.baseResourceRegistry} {
+		errs = addErrs(errs, r.deleteCreatedBy(s))
+	}
+	if errs != nil {
+		return Errf("cannot retry step %q, failed to delete the resources of attempt %d: %v", s.name, attempt, errs)
+	}
+
+	if s.CreateDisks != nil {
+		for _, d := range *s.CreateDisks {
+			if s.w.retryName(&d.Resource, attempt) {
+				d.Name = d.RealName
+				d.link = fmt.Sprintf("projects/%s/zones/%s/disks/%s", d.Project, d.Zone, d.Name)
+			}
+		}
+	}
+	if s.CreateInstances != nil {
+		for _, i := range s.CreateInstances.Instances {
+			s.w.retryInstanceName(&i.InstanceBase, i, attempt)
+		}
+		for _, i := range s.CreateInstances.InstancesBeta {
+			s.w.retryInstanceName(&i.InstanceBase, i, attempt)
+		}
+	}
+	return nil
+}
+
+// retryInstanceName renames the instance as retryName does. Disks created
+// with the instance keep their names, other steps refer to them by those.
+func (w *Workflow) retryInstanceName(ib *InstanceBase, ii InstanceInterface, attempt int) {
+	if w.retryName(&ib.Resource, attempt) {
+		ii.setName(ib.RealName)
+		ib.link = fmt.Sprintf("projects/%s/zones/%s/instances/%s", ib.Project, ii.getZone(), ib.RealName)
+	}
+}
+
+// retryName gives r a fresh generated name for the attempt after attempt,
+// unless r's name wasn't generated by the workflow. It reports whether r
+// was renamed.
+func (w *Workflow) retryName(r *Resource, attempt int) bool {
+	if r.ExactName || r.RealName != w.genName(retryDaisyName(r.daisyName, attempt-1)) {
+		return false
+	}
+	r.RealName = w.genName(retryDaisyName(r.daisyName, attempt))
+	return true
+}
+
+func retryDaisyName(name string, attempt int) string {
+	if attempt == 0 {
+		return name
+	}
+	return fmt.Sprintf("%s-retry%d", name, attempt)
+}
+
+// deleteCreatedBy deletes the resources step s created, including those it
+// may have failed to finish creating, and marks them as not created, e.g.
+// before s is retried. Resources that don't exist are skipped.
+func (r *baseResourceRegistry) deleteCreatedBy(s *Step) DError {
+	r.mx.Lock()
+	names := map[string]*Resource{}
+	for name, res := range r.m {
+		if res.creator == s && !res.deleted {
+			names[name] = res
+		}
+	}
+	r.mx.Unlock()
+
+	var errs DError
+	for name, res := range names {
+		r.w.LogStepInfo(s.name, "Retry", "Deleting %s %q before retrying.", r.typeName, res.RealName)
+		if err := r.delete(name); err != nil && err.etype() != resourceDNEError {
+			errs = addErrs(errs, err)
+			continue
+		}
+		res.deleted = false
+		res.createdInWorkflow = false
+	}
+	return errs
+}
@@ -0,0 +1,115 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"google.golang.org/api/compute/v1"
+)
+
+func TestStepValidateRetries(t *testing.T) {
+	w := testWorkflow()
+	tests := []struct {
+		desc      string
+		s         *Step
+		shouldErr bool
+	}{
+		{"no retries case", &Step{testType: &mockStep{}}, false},
+		{"CreateDisks case", &Step{Retries: 2, CreateDisks: &CreateDisks{}}, false},
+		{"CreateInstances case", &Step{Retries: 2, CreateInstances: &CreateInstances{}}, false},
+		{"negative case", &Step{Retries: -1, CreateDisks: &CreateDisks{}}, true},
+		{"unsupported step type case", &Step{Retries: 2, testType: &mockStep{}}, true},
+		{"ReadyWhen FIRST_RUNNING case", &Step{Retries: 2, ReadyWhen: readyWhenFirstRunning, CreateInstances: &CreateInstances{}}, true},
+	}
+	for _, tt := range tests {
+		tt.s.name, tt.s.w = "s", w
+		if err := tt.s.validateRetries(); (err != nil) != tt.shouldErr {
+			t.Errorf("%s: got error %v, want error %t", tt.desc, err, tt.shouldErr)
+		}
+	}
+}
+
+func TestStepRunRetries(t *testing.T) {
+	tests := []struct {
+		desc      string
+		retries   int
+		failures  int
+		exactName bool
+		shouldErr bool
+	}{
+		{"success after retry case", 2, 2, false, false},
+		{"exact name case", 1, 1, true, false},
+		{"retries exhausted case", 1, 2, false, true},
+		{"no retries case", 0, 1, false, true},
+	}
+	for _, tt := range tests {
+		w := testWorkflow()
+		s, _ := w.NewStep("s")
+		d := &Disk{Disk: compute.Disk{Name: "d"}}
+		d.Resource = Resource{daisyName: "d", ExactName: tt.exactName, Project: testProject}
+		d.RealName = w.genName("d")
+		if tt.exactName {
+			d.RealName = "d"
+		}
+		d.Name, d.Zone = d.RealName, testZone
+		d.link = fmt.Sprintf("projects/%s/zones/%s/disks/%s", testProject, testZone, d.RealName)
+		s.CreateDisks = &CreateDisks{d}
+		s.Retries = tt.retries
+		if err := w.disks.regCreate("d", &d.Resource, s, false); err != nil {
+			t.Fatal(err)
+		}
+
+		var created, deleted []string
+		w.ComputeClient = &daisyCompute.TestClient{
+			CreateDiskFn: func(_, _ string, cd *compute.Disk) error {
+				created = append(created, cd.Name)
+				if len(created) <= tt.failures {
+					return errors.New("flaky")
+				}
+				return nil
+			},
+			DeleteDiskFn: func(_, _, name string) error {
+				deleted = append(deleted, name)
+				return nil
+			},
+		}
+
+		err := s.run(context.Background())
+		if (err != nil) != tt.shouldErr {
+			t.Errorf("%s: got error %v, want error %t", tt.desc, err, tt.shouldErr)
+		}
+		if want := tt.retries + 1; tt.shouldErr && len(created) != want {
+			t.Errorf("%s: got %d attempts, want %d", tt.desc, len(created), want)
+		}
+		// Every attempt but the last one is cleaned up, under its own name.
+		wantDeleted := append([]string(nil), created[:len(created)-1]...)
+		if diffRes := diff(deleted, wantDeleted, 0); diffRes != "" {
+			t.Errorf("%s: deleted disks not as expected: (-got,+want)\n%s", tt.desc, diffRes)
+		}
+		for i := 1; i < len(created); i++ {
+			if fresh := created[i] != created[i-1]; fresh == tt.exactName {
+				t.Errorf("%s: attempt %d used name %q after %q, want fresh name: %t", tt.desc, i+1, created[i], created[i-1], !tt.exactName)
+			}
+		}
+		if last := created[len(created)-1]; d.link != fmt.Sprintf("projects/%s/zones/%s/disks/%s", testProject, testZone, last) {
+			t.Errorf("%s: disk link %q not updated to the name of the last attempt %q", tt.desc, d.link, last)
+		}
+	}
+}
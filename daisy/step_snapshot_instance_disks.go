@@ -0,0 +1,148 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sync"
+
+	"google.golang.org/api/compute/v1"
+)
+
+// SnapshotInstanceDisks is a Daisy SnapshotInstanceDisks workflow step.
+type SnapshotInstanceDisks []*SnapshotInstanceDisk
+
+// SnapshotInstanceDisk snapshots every disk attached to a GCE instance, e.g.
+// to capture the state of a failed build. The snapshot of the disk with
+// device name d is named "<Name>-<d>" in the workflow, its real name is
+// generated from that as for other resources.
+type SnapshotInstanceDisk struct {
+	// Instance to snapshot the disks of.
+	Instance string
+	// Name prefixes the snapshot names, defaults to the instance's name.
+	Name string `json:",omitempty"`
+	// GuestFlush flushes the guest's disk buffers before each snapshot of a
+	// running instance.
+	GuestFlush bool `json:",omitempty"`
+	// NoCleanup keeps the snapshots once the workflow ends.
+	NoCleanup bool `json:",omitempty"`
+
+	project, zone, instance string
+}
+
+func (sd *SnapshotInstanceDisks) populate(ctx context.Context, s *Step) DError {
+	for _, si := range *sd {
+		if instanceURLRgx.MatchString(si.Instance) {
+			si.Instance = extendPartialURL(si.Instance, s.w.Project)
+		}
+	}
+	return nil
+}
+
+// validate checks that each SnapshotInstanceDisk has its own Name, the
+// snapshots are only registered once the step runs and the disks are known,
+// so two with the same Name would collide then.
+func (sd *SnapshotInstanceDisks) validate(ctx context.Context, s *Step) DError {
+	var errs DError
+	names := map[string]string{}
+	for _, si := range *sd {
+		ir, err := s.w.instances.regUse(si.Instance, s)
+		if ir == nil {
+			// Return now, the rest of this function can't be run without ir.
+			return addErrs(errs, Errf("cannot snapshot instance disks: %v", err))
+		}
+		m := NamedSubexp(instanceURLRgx, ir.link)
+		si.project, si.zone, si.instance = m["project"], m["zone"], m["instance"]
+		si.Name = strOr(si.Name, path.Base(si.Instance))
+		if !rfc1035Rgx.MatchString(si.Name) {
+			errs = addErrs(errs, Errf("cannot snapshot disks of instance %q: bad Name %q", si.Instance, si.Name))
+		}
+		if other, ok := names[si.Name]; ok {
+			errs = addErrs(errs, Errf("cannot snapshot disks of instance %q: Name %q is also used for instance %q", si.Instance, si.Name, other))
+		}
+		names[si.Name] = si.Instance
+	}
+	return errs
+}
+
+func (sd *SnapshotInstanceDisks) run(ctx context.Context, s *Step) DError {
+	var wg sync.WaitGroup
+	w := s.w
+	e := make(chan DError)
+	for _, si := range *sd {
+		wg.Add(1)
+		go func(si *SnapshotInstanceDisk) {
+			defer wg.Done()
+			if err := si.snapshot(s); err != nil {
+				e <- err
+			}
+		}(si)
+	}
+
+	go func() {
+		wg.Wait()
+		e <- nil
+	}()
+
+	select {
+	case err := <-e:
+		return err
+	case <-w.Cancel:
+		return nil
+	}
+}
+
+// snapshot snapshots, one at a time, the disks attached to the instance
+// when the step runs. Local SSDs and regional disks are skipped.
+func (si *SnapshotInstanceDisk) snapshot(s *Step) DError {
+	w := s.w
+	inst, err := w.ComputeClient.GetInstance(si.project, si.zone, si.instance)
+	if err != nil {
+		return typedErr(apiError, "failed to get instance", err)
+	}
+	for _, d := range inst.Disks {
+		if w.isCanceled() {
+			return nil
+		}
+		if d.Type == "SCRATCH" {
+			continue
+		}
+		m := NamedSubexp(diskURLRgx, partialURL(d.Source))
+		if m == nil {
+			w.LogStepInfo(s.name, "SnapshotInstanceDisks", "Skipping disk %q of instance %q, only zonal disks can be snapshotted.", d.DeviceName, si.instance)
+			continue
+		}
+		name := fmt.Sprintf("%s-%s", si.Name, d.DeviceName)
+		res := &Resource{daisyName: name, RealName: w.genName(name), Project: strOr(m["project"], si.project), NoCleanup: si.NoCleanup}
+		res.link = fmt.Sprintf("projects/%s/global/snapshots/%s", res.Project, res.RealName)
+		sn := &compute.Snapshot{
+			Name:        res.RealName,
+			Description: fmt.Sprintf("Snapshot of disk %q of instance %q created by Daisy in workflow %q.", m["disk"], si.instance, w.Name),
+			Labels:      withRunIDLabel(nil, w.RunID()),
+		}
+		if err := w.snapshots.regCreate(name, res, s); err != nil {
+			return err
+		}
+		w.LogStepInfo(s.name, "SnapshotInstanceDisks", "Creating snapshot %q of disk %q of instance %q.", sn.Name, m["disk"], si.instance)
+		if err := w.ComputeClient.CreateSnapshot(res.Project, m["zone"], m["disk"], sn, si.GuestFlush); err != nil {
+			return typedErr(apiError, "failed to create snapshot of instance disk", err)
+		}
+		res.createdInWorkflow = true
+		w.recordResourceName("snapshot", res)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"google.golang.org/api/compute/v1"
+)
+
+func TestSnapshotInstanceDisksPopulate(t *testing.T) {
+	w := testWorkflow()
+	s, _ := w.NewStep("s")
+	sd := &SnapshotInstanceDisks{{Instance: "i"}, {Instance: "zones/z/instances/i"}}
+	if err := sd.populate(context.Background(), s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := &SnapshotInstanceDisks{{Instance: "i"}, {Instance: fmt.Sprintf("projects/%s/zones/z/instances/i", w.Project)}}
+	if diffRes := diff(sd, want, 0); diffRes != "" {
+		t.Errorf("SnapshotInstanceDisks not populated as expected: (-got,+want)\n%s", diffRes)
+	}
+}
+
+func TestSnapshotInstanceDisksValidate(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	s, _ := w.NewStep("s")
+	iCreator, _ := w.NewStep("iCreator")
+	iCreator.CreateInstances = &CreateInstances{Instances: []*Instance{{}}}
+	w.AddDependency(s, iCreator)
+	if err := w.instances.regCreate("instance1", &Resource{link: fmt.Sprintf("projects/%s/zones/%s/instances/instance1-real", testProject, testZone)}, false, iCreator); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		desc, instance, name, wantName string
+		shouldErr                      bool
+	}{
+		{"default name case", "instance1", "", "instance1", false},
+		{"name case", "instance1", "failed-build", "failed-build", false},
+		{"bad name case", "instance1", "Bad_Name", "", true},
+		{"instance DNE case", "dne", "", "", true},
+	}
+	for _, tt := range tests {
+		si := &SnapshotInstanceDisk{Instance: tt.instance, Name: tt.name}
+		err := (&SnapshotInstanceDisks{si}).validate(ctx, s)
+		if tt.shouldErr {
+			if err == nil {
+				t.Errorf("%s: should have returned an error", tt.desc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+		if si.Name != tt.wantName || si.instance != "instance1-real" {
+			t.Errorf("%s: got Name %q and instance %q, want %q and %q", tt.desc, si.Name, si.instance, tt.wantName, "instance1-real")
+		}
+	}
+
+	// The snapshot names of two instances with the same Name would collide.
+	sd := &SnapshotInstanceDisks{{Instance: "instance1", Name: "failed-build"}, {Instance: "instance1", Name: "failed-build"}}
+	if err := sd.validate(ctx, s); err == nil {
+		t.Error("duplicate Name case: should have returned an error")
+	}
+}
+
+func TestSnapshotInstanceDisksRun(t *testing.T) {
+	ctx := context.Background()
+	for _, noCleanup := range []bool{false, true} {
+		w := testWorkflow()
+		s, _ := w.NewStep("s")
+		tc := w.ComputeClient.(*daisyCompute.TestClient)
+		tc.GetInstanceFn = func(_, _, _ string) (*compute.Instance, error) {
+			return &compute.Instance{Disks: []*compute.AttachedDisk{
+				{DeviceName: "boot", Source: fmt.Sprintf("https://www.googleapis.com/compute/v1/projects/%s/zones/%s/disks/boot-real", testProject, testZone)},
+				{DeviceName: "data", Source: fmt.Sprintf("projects/%s/zones/%s/disks/data-real", testProject, testZone)},
+				{DeviceName: "local-ssd-0", Type: "SCRATCH"},
+				{DeviceName: "regional", Source: fmt.Sprintf("projects/%s/regions/r/disks/regional", testProject)},
+			}}, nil
+		}
+		var mx sync.Mutex
+		var snapshotted, deleted []string
+		tc.CreateSnapshotFn = func(_, _, disk string, sn *compute.Snapshot, guestFlush bool) error {
+			snapshotted = append(snapshotted, disk)
+			if !guestFlush {
+				t.Errorf("snapshot %q of disk %q not guest flushed", sn.Name, disk)
+			}
+			return nil
+		}
+		tc.DeleteSnapshotFn = func(_, name string) error {
+			// Cleanup deletes the snapshots concurrently.
+			mx.Lock()
+			defer mx.Unlock()
+			deleted = append(deleted, name)
+			return nil
+		}
+
+		sd := &SnapshotInstanceDisks{{Instance: "i", Name: "i", GuestFlush: true, NoCleanup: noCleanup, project: testProject, zone: testZone, instance: "i-real"}}
+		if err := sd.run(ctx, s); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if diffRes := diff(snapshotted, []string{"boot-real", "data-real"}, 0); diffRes != "" {
+			t.Errorf("snapshotted disks not as expected: (-got,+want)\n%s", diffRes)
+		}
+		res, ok := w.snapshots.get("i-data")
+		if !ok {
+			t.Fatal("snapshot i-data not registered")
+		}
+		if want := fmt.Sprintf("projects/%s/global/snapshots/%s", testProject, w.genName("i-data")); res.link != want {
+			t.Errorf("got snapshot link %q, want %q", res.link, want)
+		}
+
+		w.snapshots.cleanup()
+		sort.Strings(deleted)
+		var want []string
+		if !noCleanup {
+			want = []string{w.genName("i-boot"), w.genName("i-data")}
+		}
+		if diffRes := diff(deleted, want, 0); diffRes != "" {
+			t.Errorf("NoCleanup %t: deleted snapshots not as expected: (-got,+want)\n%s", noCleanup, diffRes)
+		}
+	}
+}
@@ -39,6 +39,7 @@ func (st *StopInstances) validate(ctx context.Context, s *Step) DError {
 		if _, err := s.w.instances.regUse(i, s); err != nil {
 			return err
 		}
+		s.w.instances.regStop(i, s)
 	}
 	return nil
 }
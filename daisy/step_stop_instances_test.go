@@ -18,6 +18,10 @@ import (
 	"context"
 	"fmt"
 	"testing"
+	"time"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"google.golang.org/api/compute/v1"
 )
 
 func TestStopInstancesPopulate(t *testing.T) {
@@ -92,3 +96,49 @@ func TestStopInstancesRun(t *testing.T) {
 		}
 	}
 }
+
+func TestStopInstancesRunClearMetadataOnStop(t *testing.T) {
+	w := testWorkflow()
+	s, _ := w.NewStep("s")
+	creator, _ := w.NewStep("creator")
+	i := &Instance{InstanceBase: InstanceBase{ClearMetadataOnStop: []string{"ssh-keys"}}}
+	i.link = fmt.Sprintf("projects/%s/zones/%s/instances/in0", testProject, testZone)
+	i.creator = creator
+	creator.CreateInstances = &CreateInstances{Instances: []*Instance{i}}
+	w.instances.m = map[string]*Resource{"in0": &i.Resource}
+
+	var calls []string
+	other := "other"
+	tc := w.ComputeClient.(*daisyCompute.TestClient)
+	tc.GetInstanceFn = func(_, _, _ string) (*compute.Instance, error) {
+		keys := "user:ssh-rsa key"
+		return &compute.Instance{Metadata: &compute.Metadata{Fingerprint: "fp", Items: []*compute.MetadataItems{{Key: "ssh-keys", Value: &keys}, {Key: "other", Value: &other}}}}, nil
+	}
+	var setMd *compute.Metadata
+	tc.SetInstanceMetadataFn = func(_, _, _ string, md *compute.Metadata) error {
+		calls = append(calls, "set metadata")
+		setMd = md
+		return nil
+	}
+	tc.StopInstanceFn = func(_, _, _ string) error {
+		calls = append(calls, "stop")
+		return nil
+	}
+	defer func(f func(time.Duration)) { SleepFn = f }(SleepFn)
+	SleepFn = func(d time.Duration) {
+		if d == clearMetadataWait {
+			calls = append(calls, "wait")
+		}
+	}
+
+	if err := (&StopInstances{Instances: []string{"in0"}}).run(context.Background(), s); err != nil {
+		t.Fatalf("error running StopInstances.run(): %v", err)
+	}
+	if diffRes := diff(calls, []string{"set metadata", "wait", "stop"}, 0); diffRes != "" {
+		t.Errorf("calls not as expected: (-got,+want)\n%s", diffRes)
+	}
+	want := &compute.Metadata{Fingerprint: "fp", Items: []*compute.MetadataItems{{Key: "other", Value: &other}}}
+	if diffRes := diff(setMd, want, 0); diffRes != "" {
+		t.Errorf("metadata not as expected: (-got,+want)\n%s", diffRes)
+	}
+}
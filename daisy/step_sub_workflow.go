@@ -43,10 +43,30 @@ func (s *SubWorkflow) populate(ctx context.Context, st *Step) DError {
 	s.Workflow.Name = st.name
 	s.Workflow.Project = s.Workflow.parent.Project
 	s.Workflow.Zone = s.Workflow.parent.Zone
+	s.Workflow.Region = s.Workflow.parent.Region
+	s.Workflow.ImageProject = s.Workflow.parent.ImageProject
+	s.Workflow.MaxSerialBytes = s.Workflow.parent.MaxSerialBytes
+	s.Workflow.FailOnMaxSerialBytes = s.Workflow.parent.FailOnMaxSerialBytes
+	s.Workflow.SerialLogContentType = s.Workflow.parent.SerialLogContentType
+	s.Workflow.SerialLogMetadata = s.Workflow.parent.SerialLogMetadata
+	s.Workflow.SerialLogStorageClass = s.Workflow.parent.SerialLogStorageClass
+	s.Workflow.SerialLogTimestamps = s.Workflow.parent.SerialLogTimestamps
+	s.Workflow.LogsStorageClass = s.Workflow.parent.LogsStorageClass
+	s.Workflow.LogsObjectMetadata = s.Workflow.parent.LogsObjectMetadata
+	s.Workflow.OutsStorageClass = s.Workflow.parent.OutsStorageClass
+	s.Workflow.OutsObjectMetadata = s.Workflow.parent.OutsObjectMetadata
+	s.Workflow.ObjectPredefinedACL = s.Workflow.parent.ObjectPredefinedACL
+	s.Workflow.ObjectACLRules = s.Workflow.parent.ObjectACLRules
+	s.Workflow.Proxy = s.Workflow.parent.Proxy
+	s.Workflow.SourceUploadChunkSize = s.Workflow.parent.SourceUploadChunkSize
 	s.Workflow.OAuthPath = s.Workflow.parent.OAuthPath
 	s.Workflow.ComputeClient = s.Workflow.parent.ComputeClient
 	s.Workflow.StorageClient = s.Workflow.parent.StorageClient
 	s.Workflow.Logger = s.Workflow.parent.Logger
+	s.Workflow.SerialLogWriter = s.Workflow.parent.SerialLogWriter
+	s.Workflow.PreInsertInstance = s.Workflow.parent.PreInsertInstance
+	s.Workflow.AdoptExistingResources = s.Workflow.parent.AdoptExistingResources
+	s.Workflow.NoCleanupNameTemplate = s.Workflow.parent.NoCleanupNameTemplate
 	s.Workflow.DefaultTimeout = st.Timeout
 
 	var errs DError
@@ -96,8 +116,15 @@ func (s *SubWorkflow) run(ctx context.Context, st *Step) DError {
 
 	// Prerun work has already been done. Just run(), not Run().
 	st.w.LogStepInfo(st.name, "SubWorkflow", "Running subworkflow %q", s.Workflow.Name)
-	if err := s.Workflow.run(ctx); err != nil {
+	err := s.Workflow.run(ctx)
+	// The subworkflow's outs are under the parent's scratch path, not its
+	// outs path, so the parent's cleanup doesn't rewrite them.
+	if oErr := s.Workflow.setOutsObjectAttrs(ctx); oErr != nil {
+		s.Workflow.LogWorkflowInfo("Error setting storage class and metadata of outs objects: %v", oErr)
+	}
+	if err != nil {
 		s.Workflow.LogStepInfo(st.name, "SubWorkflow", "Error running subworkflow %q: %v", s.Workflow.Name, err)
+		s.Workflow.failed = true
 		return err
 	}
 	return nil
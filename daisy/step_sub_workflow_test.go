@@ -25,6 +25,8 @@ func TestSubWorkflowPopulate(t *testing.T) {
 	ctx := context.Background()
 	w := testWorkflow()
 	w.populate(ctx)
+	w.OutsStorageClass = "COLDLINE"
+	w.OutsObjectMetadata = map[string]string{"owner": "daisy"}
 	sw := w.NewSubWorkflow()
 	sw.Vars = map[string]Var{"foo": {Value: "bar1"}, "baz": {Value: "gaz"}}
 	s := &Step{
@@ -52,6 +54,12 @@ func TestSubWorkflowPopulate(t *testing.T) {
 	if sw.GCSPath != wantGCSPath {
 		t.Errorf("unexpected subworkflow GCSPath: %q != %q", sw.GCSPath, wantGCSPath)
 	}
+	if sw.OutsStorageClass != w.OutsStorageClass {
+		t.Errorf("unexpected subworkflow OutsStorageClass: %q != %q", sw.OutsStorageClass, w.OutsStorageClass)
+	}
+	if !reflect.DeepEqual(sw.OutsObjectMetadata, w.OutsObjectMetadata) {
+		t.Errorf("unexpected subworkflow OutsObjectMetadata: %v != %v", sw.OutsObjectMetadata, w.OutsObjectMetadata)
+	}
 	wantVars := map[string]Var{"foo": {Value: "bar2"}, "baz": {Value: "gaz"}}
 	if !reflect.DeepEqual(sw.Vars, wantVars) {
 		t.Errorf("unexpected subworkflow Vars: %v != %v", sw.Vars, wantVars)
@@ -15,6 +15,7 @@
 package daisy
 
 import (
+	"context"
 	"reflect"
 	"testing"
 )
@@ -210,6 +211,22 @@ func TestStepImpl(t *testing.T) {
 			Step{WaitForInstancesSignal: &WaitForInstancesSignal{}},
 			reflect.TypeOf(&WaitForInstancesSignal{}),
 		},
+		{
+			Step{WaitForInstancesHealthy: &WaitForInstancesHealthy{}},
+			reflect.TypeOf(&WaitForInstancesHealthy{}),
+		},
+		{
+			Step{WaitForApproval: &WaitForApproval{}},
+			reflect.TypeOf(&WaitForApproval{}),
+		},
+		{
+			Step{CopyImages: &CopyImages{}},
+			reflect.TypeOf(&CopyImages{}),
+		},
+		{
+			Step{WaitForCondition: &WaitForCondition{}},
+			reflect.TypeOf(&WaitForCondition{}),
+		},
 	}
 
 	for _, tt := range tests {
@@ -237,3 +254,175 @@ func TestStepImpl(t *testing.T) {
 		t.Fatal("malformed step should have thrown an error")
 	}
 }
+
+func TestStepRunIf(t *testing.T) {
+	tests := []struct {
+		desc    string
+		runIf   *StepCondition
+		wantRun bool
+	}{
+		{"no condition case", nil, true},
+		{"key reported case", &StepCondition{SerialOutputKey: "status"}, true},
+		{"key not reported case", &StepCondition{SerialOutputKey: "other"}, false},
+		{"value match case", &StepCondition{SerialOutputKey: "status", SerialOutputValue: "failed"}, true},
+		{"value mismatch case", &StepCondition{SerialOutputKey: "status", SerialOutputValue: "ok"}, false},
+		{"not case", &StepCondition{SerialOutputKey: "status", SerialOutputValue: "ok", Not: true}, true},
+		{"reported by parent case", &StepCondition{SerialOutputKey: "parent-key"}, true},
+	}
+
+	for _, tt := range tests {
+		parent := testWorkflow()
+		parent.AddSerialConsoleOutputValue("parent-key", "v")
+		w := testWorkflow()
+		w.parent = parent
+		w.AddSerialConsoleOutputValue("status", "failed")
+		ran := false
+		s := &Step{name: "s", w: w, RunIf: tt.runIf, testType: &mockStep{runImpl: func(context.Context, *Step) DError {
+			ran = true
+			return nil
+		}}}
+		if err := s.run(context.Background()); err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+		if ran != tt.wantRun {
+			t.Errorf("%s: step ran: %t, want: %t", tt.desc, ran, tt.wantRun)
+		}
+	}
+}
+
+func TestStepValidateRunIf(t *testing.T) {
+	w := testWorkflow()
+	s := &Step{name: "s", w: w, RunIf: &StepCondition{}, testType: &mockStep{}}
+	if err := s.validate(context.Background()); err == nil {
+		t.Error("expected error for RunIf without SerialOutputKey")
+	}
+	s.RunIf.SerialOutputKey = "key"
+	if err := s.validate(context.Background()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestStepValidateReadyWhen(t *testing.T) {
+	w := testWorkflow()
+	tests := []struct {
+		desc, readyWhen string
+		shouldErr       bool
+	}{
+		{"unset case", "", false},
+		{"all case", readyWhenAll, false},
+		{"first running on other step case", readyWhenFirstRunning, true},
+		{"bad value case", "FIRST", true},
+	}
+	for _, tt := range tests {
+		s := &Step{name: "s", w: w, ReadyWhen: tt.readyWhen, testType: &mockStep{}}
+		err := s.validate(context.Background())
+		if tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+	}
+}
+
+func TestStepValidateCleanup(t *testing.T) {
+	w := testWorkflow()
+	tests := []struct {
+		desc, cleanup string
+		shouldErr     bool
+	}{
+		{"unset case", "", false},
+		{"always case", cleanupAlways, false},
+		{"on success case", cleanupOnSuccess, false},
+		{"never case", cleanupNever, false},
+		{"bad value case", "SOMETIMES", true},
+	}
+	for _, tt := range tests {
+		s := &Step{name: "s", w: w, Cleanup: tt.cleanup, testType: &mockStep{}}
+		err := s.validate(context.Background())
+		if tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+	}
+}
+
+func TestStepSkip(t *testing.T) {
+	tests := []struct {
+		desc    string
+		skip    *VarCondition
+		wantRun bool
+	}{
+		{"no condition case", nil, true},
+		{"value match case", &VarCondition{Var: "os", Value: "windows"}, false},
+		{"value mismatch case", &VarCondition{Var: "os", Value: "linux"}, true},
+		{"not case", &VarCondition{Var: "os", Value: "linux", Not: true}, false},
+		{"non-empty case", &VarCondition{Var: "os"}, false},
+		{"empty case", &VarCondition{Var: "empty"}, true},
+		{"empty not case", &VarCondition{Var: "empty", Not: true}, false},
+	}
+
+	for _, tt := range tests {
+		w := testWorkflow()
+		w.Vars = map[string]Var{"os": {Value: "windows"}, "empty": {}}
+		ran := false
+		s := &Step{name: "s", w: w, Skip: tt.skip, testType: &mockStep{runImpl: func(context.Context, *Step) DError {
+			ran = true
+			return nil
+		}}}
+		if err := s.run(context.Background()); err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+		if ran != tt.wantRun {
+			t.Errorf("%s: step ran: %t, want: %t", tt.desc, ran, tt.wantRun)
+		}
+	}
+}
+
+func TestStepContinueOnError(t *testing.T) {
+	parent := testWorkflow()
+	w := testWorkflow()
+	w.parent = parent
+	w.Name = "sub"
+	s := &Step{name: "s", w: w, ContinueOnError: true, testType: &mockStep{runImpl: func(context.Context, *Step) DError {
+		return Errf("failure")
+	}}}
+	if err := s.run(context.Background()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	got := parent.GetStepErrorRecords()
+	if len(got) != 1 || got[0].Step != "sub.s" || got[0].Error.Error() != s.wrapRunError(Errf("failure")).Error() {
+		t.Errorf("got step error records %v, want one for \"sub.s\"", got)
+	}
+
+	// A canceled workflow still fails the step.
+	close(w.Cancel)
+	if err := s.run(context.Background()); err == nil {
+		t.Error("should have returned an error")
+	}
+}
+
+func TestStepValidateSkip(t *testing.T) {
+	w := testWorkflow()
+	w.Vars = map[string]Var{"os": {Value: "windows"}}
+	validated := false
+	s := &Step{name: "s", w: w, Skip: &VarCondition{}, testType: &mockStep{validateImpl: func(context.Context, *Step) DError {
+		validated = true
+		return nil
+	}}}
+	if err := s.validate(context.Background()); err == nil {
+		t.Error("expected error for Skip without Var")
+	}
+	s.Skip.Var = "dne"
+	if err := s.validate(context.Background()); err == nil {
+		t.Error("expected error for Skip with unknown Var")
+	}
+	// Skipped steps are validated all the same.
+	s.Skip.Var = "os"
+	if err := s.validate(context.Background()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if !validated {
+		t.Error("skipped step was not validated")
+	}
+}
@@ -0,0 +1,82 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import "context"
+
+// defaultTestImageBootsSuccess is printed to serial port 1 by the guest
+// environment once it has run the startup scripts, on Linux and Windows.
+const defaultTestImageBootsSuccess = "Finished running startup scripts"
+
+// TestImageBoots is a Daisy TestImageBoots workflow step. It boots a
+// temporary instance from an image, waits for the instance to signal it's
+// ready on serial port 1 and deletes the instance, failing if it doesn't
+// boot, e.g. as a smoke test before publishing the image.
+//
+// Like MountAndRun, the step is expanded into an included workflow of
+// CreateInstances, WaitForInstancesSignal and DeleteResources steps.
+type TestImageBoots struct {
+	// Image to boot, either a workflow image name or a partial URL.
+	Image string
+	// MachineType of the instance, defaults to the CreateInstances default.
+	MachineType string `json:",omitempty"`
+	// Metadata set on the instance.
+	Metadata map[string]string `json:",omitempty"`
+	// StartupScript is an optional Sources path to a script run on the
+	// instance, e.g. to check the image further and print SuccessMatch.
+	StartupScript string `json:",omitempty"`
+	// SuccessMatch is printed to serial port 1 once the instance is ready,
+	// defaults to "Finished running startup scripts".
+	SuccessMatch string `json:",omitempty"`
+	// FailureMatch fails the step if printed.
+	FailureMatch FailureMatches `json:"failureMatch,omitempty"`
+
+	include *IncludeWorkflow
+}
+
+func (t *TestImageBoots) populate(ctx context.Context, s *Step) DError {
+	t.SuccessMatch = strOr(t.SuccessMatch, defaultTestImageBootsSuccess)
+	if t.StartupScript != "" && !s.w.sourceExists(t.StartupScript) {
+		return Errf("bad value for StartupScript, source not found: %s", t.StartupScript)
+	}
+	t.include = &IncludeWorkflow{Workflow: t.workflow(s.name)}
+	return t.include.populate(ctx, s)
+}
+
+// workflow builds the workflow the step is expanded into. Its instance is
+// named after the step, as it shares the parent's namespace.
+func (t *TestImageBoots) workflow(name string) *Workflow {
+	ti := &tempInstance{
+		name:          name + "-instance",
+		image:         t.Image,
+		machineType:   t.MachineType,
+		startupScript: t.StartupScript,
+		metadata:      t.Metadata,
+		successMatch:  t.SuccessMatch,
+		failureMatch:  t.FailureMatch,
+	}
+	return ti.workflow("create-instance", "wait-for-boot", "delete-instance")
+}
+
+func (t *TestImageBoots) validate(ctx context.Context, s *Step) DError {
+	if t.Image == "" {
+		return Errf("cannot test image boots: Image is empty")
+	}
+	return t.include.validate(ctx, s)
+}
+
+func (t *TestImageBoots) run(ctx context.Context, s *Step) DError {
+	return t.include.run(ctx, s)
+}
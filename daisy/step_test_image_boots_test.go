@@ -0,0 +1,108 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"google.golang.org/api/compute/v1"
+)
+
+func TestTestImageBootsPopulate(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	s, _ := w.NewStep("boot-test")
+	s.TestImageBoots = &TestImageBoots{Image: "golden", Metadata: map[string]string{"key": "value"}}
+	if err := w.populateStep(ctx, s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tib := s.TestImageBoots
+	if tib.SuccessMatch != defaultTestImageBootsSuccess {
+		t.Errorf("got SuccessMatch %q, want %q", tib.SuccessMatch, defaultTestImageBootsSuccess)
+	}
+	iw := tib.include.Workflow
+	if iw.parent != w || iw.Name != "boot-test" {
+		t.Errorf("included workflow not set up, got parent %v and name %q", iw.parent, iw.Name)
+	}
+	wantDeps := map[string][]string{
+		"wait-for-boot":   {"create-instance"},
+		"delete-instance": {"wait-for-boot"},
+	}
+	if diffRes := diff(iw.Dependencies, wantDeps, 0); diffRes != "" {
+		t.Errorf("dependencies not as expected: (-got,+want)\n%s", diffRes)
+	}
+
+	i := iw.Steps["create-instance"].CreateInstances.Instances[0]
+	if i.daisyName != "boot-test-instance" {
+		t.Errorf("got instance name %q, want %q", i.daisyName, "boot-test-instance")
+	}
+	if d := i.Disks[0]; !d.AutoDelete || d.InitializeParams.SourceImage != "golden" {
+		t.Errorf("got boot disk AutoDelete %t and SourceImage %q, want true and %q", d.AutoDelete, d.InitializeParams.SourceImage, "golden")
+	}
+	if i.Metadata["key"] != "value" {
+		t.Errorf("instance metadata not set, got %v", i.Metadata)
+	}
+	so := (*iw.Steps["wait-for-boot"].WaitForInstancesSignal)[0].SerialOutput
+	if so.Port != 1 || so.SuccessMatch != defaultTestImageBootsSuccess {
+		t.Errorf("got serial output %+v, want port 1 and the default SuccessMatch", so)
+	}
+	if got := iw.Steps["delete-instance"].DeleteResources.Instances; len(got) != 1 || got[0] != "boot-test-instance" {
+		t.Errorf("got deleted instances %q, want %q", got, []string{"boot-test-instance"})
+	}
+}
+
+func TestTestImageBootsPopulateSourceNotFound(t *testing.T) {
+	w := testWorkflow()
+	s, _ := w.NewStep("boot-test")
+	s.TestImageBoots = &TestImageBoots{Image: "golden", StartupScript: "dne.sh"}
+	if err := w.populateStep(context.Background(), s); err == nil {
+		t.Error("should have returned an error")
+	}
+}
+
+func TestTestImageBootsValidate(t *testing.T) {
+	ctx := context.Background()
+	s := &Step{name: "boot-test", w: testWorkflow()}
+	if err := (&TestImageBoots{}).validate(ctx, s); err == nil {
+		t.Error("no image case: should have returned an error")
+	}
+
+	for _, dep := range []bool{true, false} {
+		w := testWorkflow()
+		w.ComputeClient.(*daisyCompute.TestClient).ListNetworksFn = func(_ string, _ ...daisyCompute.ListCallOption) ([]*compute.Network, error) {
+			return []*compute.Network{{Name: "default"}}, nil
+		}
+		create, _ := w.NewStep("create-image")
+		w.images.m = map[string]*Resource{"golden": {creator: create, link: fmt.Sprintf("projects/%s/global/images/golden", testProject)}}
+		s, _ := w.NewStep("boot-test")
+		s.TestImageBoots = &TestImageBoots{Image: "golden", MachineType: testMachineType}
+		if dep {
+			w.AddDependency(s, create)
+		}
+		if err := w.populateStep(ctx, s); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		err := s.TestImageBoots.validate(ctx, s)
+		if dep && err != nil {
+			t.Errorf("unexpected error: %v", err)
+		} else if !dep && err == nil {
+			t.Error("should have returned an error for an image created by a step it doesn't depend on")
+		}
+	}
+}
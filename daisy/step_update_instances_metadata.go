@@ -95,7 +95,7 @@ func (c *UpdateInstancesMetadata) run(ctx context.Context, s *Step) DError {
 				}
 			}
 
-			w.LogStepInfo(s.name, "UpdateInstancesMetadata", "Set Instance %q metadata to %q.", inst, sm.Metadata)
+			w.LogStepInfo(s.name, "UpdateInstancesMetadata", "Set Instance %q metadata to %q.", inst, w.redactMetadata(sm.Metadata))
 			if err := w.ComputeClient.SetInstanceMetadata(sm.project, sm.zone, sm.Instance, &metadata); err != nil {
 				e <- newErr("failed to set instance metadata", err)
 				return
@@ -0,0 +1,146 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+// WaitForApproval is a Daisy WaitForApproval workflow step. It pauses the
+// workflow until it is approved to continue, e.g. before publishing an image.
+// Resources created so far are kept while it waits. Approval is given by
+// calling Workflow.Approve or by creating GCSObject; use the step Timeout to
+// limit how long to wait.
+type WaitForApproval struct {
+	// Message logged when the step starts waiting, e.g. what to check before
+	// approving.
+	Message string `json:",omitempty"`
+	// GCSObject, e.g. "gs://bucket/approvals/release", approves the step
+	// once it exists.
+	GCSObject string `json:",omitempty"`
+	// Interval to check for GCSObject (default is 10s).
+	// Must be parsable by https://golang.org/pkg/time/#ParseDuration.
+	Interval string `json:",omitempty"`
+	interval time.Duration
+	bucket   string
+	object   string
+}
+
+// Approve lets the WaitForApproval step named step, or a WaitForInstancesSignal
+// step paused by KeepRunningOnSuccess, continue. It may be called before the
+// step starts waiting. Steps of included and sub workflows are approved
+// through the top level workflow.
+func (w *Workflow) Approve(step string) {
+	w = w.rootWorkflow()
+	w.approvalsMx.Lock()
+	defer w.approvalsMx.Unlock()
+	c := w.approvalChanLocked(step)
+	select {
+	case <-c:
+	default:
+		close(c)
+	}
+}
+
+// approvalChan returns the channel closed once step is approved.
+func (w *Workflow) approvalChan(step string) <-chan struct{} {
+	w = w.rootWorkflow()
+	w.approvalsMx.Lock()
+	defer w.approvalsMx.Unlock()
+	return w.approvalChanLocked(step)
+}
+
+func (w *Workflow) approvalChanLocked(step string) chan struct{} {
+	if w.approvals == nil {
+		w.approvals = map[string]chan struct{}{}
+	}
+	c, ok := w.approvals[step]
+	if !ok {
+		c = make(chan struct{})
+		w.approvals[step] = c
+	}
+	return c
+}
+
+func (a *WaitForApproval) populate(ctx context.Context, s *Step) DError {
+	a.Interval = strOr(a.Interval, defaultInterval)
+	var err error
+	if a.interval, err = time.ParseDuration(a.Interval); err != nil {
+		return newErr("failed to parse duration for step wait_for_approval", err)
+	}
+	return nil
+}
+
+func (a *WaitForApproval) validate(ctx context.Context, s *Step) DError {
+	if a.interval <= 0 {
+		return Errf("cannot wait for approval, no interval given")
+	}
+	if a.GCSObject == "" {
+		return nil
+	}
+	var err DError
+	if a.bucket, a.object, err = splitGCSPath(a.GCSObject); err != nil {
+		return err
+	}
+	if a.object == "" {
+		return Errf("cannot wait for approval, GCSObject %q is a bucket, not an object", a.GCSObject)
+	}
+	return nil
+}
+
+func (a *WaitForApproval) run(ctx context.Context, s *Step) DError {
+	w := s.w
+	approved := w.approvalChan(s.name)
+	msg := "Waiting for approval"
+	if a.GCSObject != "" {
+		msg += fmt.Sprintf(", or for %s to exist", a.GCSObject)
+	}
+	if a.Message != "" {
+		msg += ": " + a.Message
+	}
+	w.LogStepInfo(s.name, "WaitForApproval", "%s", msg)
+	if w.ApprovalRequested != nil {
+		w.ApprovalRequested(s.name, a.Message)
+	}
+
+	var poll <-chan time.Time
+	if a.GCSObject != "" {
+		ticker := time.NewTicker(a.interval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+	for {
+		select {
+		case <-approved:
+			w.LogStepInfo(s.name, "WaitForApproval", "Approved.")
+			return nil
+		case <-w.Cancel:
+			return nil
+		case <-poll:
+			_, err := w.StorageClient.Bucket(a.bucket).Object(a.object).Attrs(ctx)
+			if err == nil {
+				w.LogStepInfo(s.name, "WaitForApproval", "Approved by %s.", a.GCSObject)
+				return nil
+			}
+			if err != storage.ErrObjectNotExist {
+				return typedErrf(apiError, "error checking for approval object %s: %v", a.GCSObject, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,126 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWaitForApprovalPopulate(t *testing.T) {
+	got := &WaitForApproval{}
+	if err := got.populate(context.Background(), &Step{}); err != nil {
+		t.Fatal(err)
+	}
+	want := &WaitForApproval{Interval: "10s", interval: 10 * time.Second}
+	if diffRes := diff(got, want, 0); diffRes != "" {
+		t.Errorf("populated WaitForApproval does not match expectation: (-got +want)\n%s", diffRes)
+	}
+
+	bad := &WaitForApproval{Interval: "soon"}
+	if err := bad.populate(context.Background(), &Step{}); err == nil {
+		t.Error("expected error for bad Interval")
+	}
+}
+
+func TestWaitForApprovalValidate(t *testing.T) {
+	w := testWorkflow()
+	s, _ := w.NewStep("s")
+
+	tests := []struct {
+		desc      string
+		a         *WaitForApproval
+		shouldErr bool
+	}{
+		{"no GCSObject case", &WaitForApproval{interval: time.Second}, false},
+		{"GCSObject case", &WaitForApproval{GCSObject: "gs://bucket/approved", interval: time.Second}, false},
+		{"bucket only case", &WaitForApproval{GCSObject: "gs://bucket", interval: time.Second}, true},
+		{"bad GCSObject case", &WaitForApproval{GCSObject: "bucket/approved", interval: time.Second}, true},
+		{"no interval case", &WaitForApproval{}, true},
+	}
+
+	for _, tt := range tests {
+		if err := tt.a.validate(context.Background(), s); (err != nil) != tt.shouldErr {
+			t.Errorf("%s: unexpected validate result, want error: %t, got: %v", tt.desc, tt.shouldErr, err)
+		}
+	}
+}
+
+func TestWaitForApprovalRun(t *testing.T) {
+	tests := []struct {
+		desc     string
+		a        *WaitForApproval
+		approve  func(w *Workflow)
+		canceled bool
+	}{
+		{"approved before waiting case", &WaitForApproval{}, func(w *Workflow) { w.Approve("s") }, false},
+		{"approved while waiting case", &WaitForApproval{}, func(w *Workflow) {
+			go func() {
+				time.Sleep(10 * time.Millisecond)
+				w.Approve("s")
+			}()
+		}, false},
+		{"approved twice case", &WaitForApproval{}, func(w *Workflow) { w.Approve("s"); w.Approve("s") }, false},
+		{"GCS object case", &WaitForApproval{GCSObject: "gs://bucket/approved"}, func(*Workflow) {}, false},
+		{"canceled case", &WaitForApproval{GCSObject: "gs://bucket/dne"}, func(w *Workflow) { close(w.Cancel) }, true},
+	}
+
+	for _, tt := range tests {
+		w := testWorkflow()
+		s, _ := w.NewStep("s")
+		var requested []string
+		w.ApprovalRequested = func(step, message string) { requested = append(requested, step, message) }
+		tt.a.Message = "check the image"
+		tt.a.Interval = "1ms"
+		if err := tt.a.populate(context.Background(), s); err != nil {
+			t.Fatalf("%s: populate error: %v", tt.desc, err)
+		}
+		if err := tt.a.validate(context.Background(), s); err != nil {
+			t.Fatalf("%s: validate error: %v", tt.desc, err)
+		}
+		tt.approve(w)
+		if err := tt.a.run(context.Background(), s); err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+		if diffRes := diff(requested, []string{"s", "check the image"}, 0); diffRes != "" {
+			t.Errorf("%s: ApprovalRequested not called as expected: (-got +want)\n%s", tt.desc, diffRes)
+		}
+	}
+}
+
+func TestWaitForApprovalThroughParent(t *testing.T) {
+	parent := testWorkflow()
+	child := testWorkflow()
+	child.parent = parent
+	s, _ := child.NewStep("s")
+
+	a := &WaitForApproval{}
+	if err := a.populate(context.Background(), s); err != nil {
+		t.Fatal(err)
+	}
+	parent.Approve("s")
+
+	done := make(chan DError)
+	go func() { done <- a.run(context.Background(), s) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("step approved through the parent workflow is still waiting")
+	}
+}
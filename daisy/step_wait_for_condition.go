@@ -0,0 +1,174 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+// Condition reports whether a WaitForCondition step may continue. An error
+// fails the step.
+type Condition func(ctx context.Context) (bool, error)
+
+// AddCondition registers c as name, for WaitForCondition steps to poll.
+// Conditions are shared with included and sub workflows.
+func (w *Workflow) AddCondition(name string, c Condition) {
+	w = w.rootWorkflow()
+	w.conditionsMx.Lock()
+	defer w.conditionsMx.Unlock()
+	if w.conditions == nil {
+		w.conditions = map[string]Condition{}
+	}
+	w.conditions[name] = c
+}
+
+func (w *Workflow) condition(name string) (Condition, bool) {
+	w = w.rootWorkflow()
+	w.conditionsMx.Lock()
+	defer w.conditionsMx.Unlock()
+	c, ok := w.conditions[name]
+	return c, ok
+}
+
+// WaitForCondition is a Daisy WaitForCondition workflow step. It polls a
+// condition until it is satisfied, either a Condition registered by name with
+// Workflow.AddCondition or an instance guest attribute. Use the step Timeout
+// to limit how long to wait.
+type WaitForCondition struct {
+	// Condition is the name of a Condition added with Workflow.AddCondition.
+	Condition string `json:",omitempty"`
+	// GuestAttribute waits for an instance guest attribute to be set.
+	GuestAttribute *GuestAttributeCondition `json:",omitempty"`
+	// Interval to poll the condition (default is 10s).
+	// Must be parsable by https://golang.org/pkg/time/#ParseDuration.
+	Interval string `json:",omitempty"`
+	interval time.Duration
+}
+
+// GuestAttributeCondition is satisfied once an instance's guest attribute is
+// set, e.g. by the guest running
+// `curl -X PUT --data "done" -H "Metadata-Flavor: Google" http://metadata.google.internal/computeMetadata/v1/instance/guest-attributes/daisy/progress`.
+type GuestAttributeCondition struct {
+	// Instance name to read the guest attribute of.
+	Instance string
+	// Key of the guest attribute, "<namespace>/<key>", e.g. "daisy/progress".
+	Key string
+	// Value the guest attribute must have, any value is accepted if unset.
+	Value string `json:",omitempty"`
+	// FailureValues fail the step if the guest attribute has one of them.
+	FailureValues []string `json:",omitempty"`
+}
+
+func (c *WaitForCondition) populate(ctx context.Context, s *Step) DError {
+	c.Interval = strOr(c.Interval, defaultInterval)
+	var err error
+	if c.interval, err = time.ParseDuration(c.Interval); err != nil {
+		return newErr("failed to parse duration for step wait_for_condition", err)
+	}
+	return nil
+}
+
+func (c *WaitForCondition) validate(ctx context.Context, s *Step) DError {
+	if c.interval <= 0 {
+		return Errf("cannot wait for condition, no interval given")
+	}
+	if (c.Condition == "") == (c.GuestAttribute == nil) {
+		return Errf("cannot wait for condition, exactly one of Condition or GuestAttribute must be given")
+	}
+	if c.Condition != "" {
+		if _, ok := s.w.condition(c.Condition); !ok {
+			return Errf("cannot wait for condition, Condition %q was not added to the workflow", c.Condition)
+		}
+		return nil
+	}
+	ga := c.GuestAttribute
+	if _, err := s.w.instances.regUse(ga.Instance, s); err != nil {
+		return err
+	}
+	if parts := strings.Split(ga.Key, "/"); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return Errf("%q: cannot wait for guest attribute, bad Key %q, must be \"<namespace>/<key>\"", ga.Instance, ga.Key)
+	}
+	return nil
+}
+
+func (c *WaitForCondition) run(ctx context.Context, s *Step) DError {
+	w := s.w
+	check := func() (bool, DError) {
+		cond, _ := w.condition(c.Condition)
+		ok, err := cond(ctx)
+		if err != nil {
+			return false, Errf("WaitForCondition: condition %q: %v", c.Condition, err)
+		}
+		return ok, nil
+	}
+	if c.Condition != "" {
+		w.LogStepInfo(s.name, "WaitForCondition", "Waiting for condition %q.", c.Condition)
+	} else {
+		ga := c.GuestAttribute
+		i, ok := w.instances.get(ga.Instance)
+		if !ok {
+			return Errf("unresolved instance %q", ga.Instance)
+		}
+		m := NamedSubexp(instanceURLRgx, i.link)
+		check = func() (bool, DError) {
+			return ga.check(w, m["project"], m["zone"], m["instance"])
+		}
+		msg := fmt.Sprintf("Instance %q: waiting for guest attribute %q", m["instance"], ga.Key)
+		if ga.Value != "" {
+			msg += fmt.Sprintf(" to be %q", ga.Value)
+		}
+		w.LogStepInfo(s.name, "WaitForCondition", "%s.", msg)
+	}
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		ok, err := check()
+		if err != nil {
+			return err
+		}
+		if ok {
+			w.LogStepInfo(s.name, "WaitForCondition", "Condition satisfied.")
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-w.Cancel:
+			return nil
+		}
+	}
+}
+
+// check reports whether the guest attribute has been set to an accepted value.
+func (ga *GuestAttributeCondition) check(w *Workflow, project, zone, instance string) (bool, DError) {
+	attr, err := w.ComputeClient.GetGuestAttributes(project, zone, instance, "", ga.Key)
+	if err != nil {
+		if gErr, ok := err.(*googleapi.Error); ok && gErr.Code == http.StatusNotFound {
+			// Not set yet.
+			return false, nil
+		}
+		return false, typedErrf(apiError, "WaitForCondition: instance %q: failed to get guest attribute %q: %v", instance, ga.Key, err)
+	}
+	if strIn(attr.VariableValue, ga.FailureValues) {
+		return false, Errf("WaitForCondition: instance %q: guest attribute %q has failure value %q", instance, ga.Key, attr.VariableValue)
+	}
+	return ga.Value == "" || attr.VariableValue == ga.Value, nil
+}
@@ -0,0 +1,161 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	computeBeta "google.golang.org/api/compute/v0.beta"
+	"google.golang.org/api/googleapi"
+)
+
+func TestWaitForConditionPopulate(t *testing.T) {
+	c := &WaitForCondition{Condition: "c"}
+	if err := c.populate(context.Background(), &Step{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.interval != 10*time.Second {
+		t.Errorf("got interval %s, want 10s", c.interval)
+	}
+
+	c = &WaitForCondition{Condition: "c", Interval: "bad"}
+	if err := c.populate(context.Background(), &Step{}); err == nil {
+		t.Error("should have returned an error")
+	}
+}
+
+func TestWaitForConditionValidate(t *testing.T) {
+	w := testWorkflow()
+	w.AddCondition("ready", func(context.Context) (bool, error) { return true, nil })
+	w.instances.m = map[string]*Resource{"i1": {link: fmt.Sprintf("projects/%s/zones/%s/instances/i1", testProject, testZone)}}
+
+	tests := []struct {
+		desc      string
+		c         *WaitForCondition
+		shouldErr bool
+	}{
+		{"condition case", &WaitForCondition{Condition: "ready"}, false},
+		{"guest attribute case", &WaitForCondition{GuestAttribute: &GuestAttributeCondition{Instance: "i1", Key: "daisy/progress"}}, false},
+		{"no interval case", &WaitForCondition{Condition: "ready", interval: -1}, true},
+		{"nothing to wait for case", &WaitForCondition{}, true},
+		{"condition and guest attribute case", &WaitForCondition{Condition: "ready", GuestAttribute: &GuestAttributeCondition{Instance: "i1", Key: "daisy/progress"}}, true},
+		{"unknown condition case", &WaitForCondition{Condition: "dne"}, true},
+		{"instance dne case", &WaitForCondition{GuestAttribute: &GuestAttributeCondition{Instance: "dne", Key: "daisy/progress"}}, true},
+		{"key without namespace case", &WaitForCondition{GuestAttribute: &GuestAttributeCondition{Instance: "i1", Key: "progress"}}, true},
+		{"empty key case", &WaitForCondition{GuestAttribute: &GuestAttributeCondition{Instance: "i1", Key: "daisy/"}}, true},
+	}
+
+	for _, tt := range tests {
+		if tt.c.interval == 0 {
+			tt.c.interval = time.Microsecond
+		}
+		s, _ := w.NewStep(tt.desc)
+		s.WaitForCondition = tt.c
+		err := tt.c.validate(context.Background(), s)
+		if tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+	}
+}
+
+func TestWaitForConditionRun(t *testing.T) {
+	w := testWorkflow()
+	calls := 0
+	w.AddCondition("ready", func(context.Context) (bool, error) {
+		calls++
+		return calls == 3, nil
+	})
+	w.AddCondition("broken", func(context.Context) (bool, error) {
+		return false, errors.New("datastore unavailable")
+	})
+	s := &Step{name: "wait", w: w}
+
+	c := &WaitForCondition{Condition: "ready", interval: time.Microsecond}
+	if err := c.run(context.Background(), s); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("got %d calls, want 3", calls)
+	}
+
+	c = &WaitForCondition{Condition: "broken", interval: time.Microsecond}
+	want := `WaitForCondition: condition "broken": datastore unavailable`
+	if err := c.run(context.Background(), s); err == nil || err.Error() != want {
+		t.Errorf("did not get expected error, got: %v, want: %q", err, want)
+	}
+}
+
+func TestWaitForConditionRunGuestAttribute(t *testing.T) {
+	w := testWorkflow()
+	w.instances.m = map[string]*Resource{"i1": {link: fmt.Sprintf("projects/%s/zones/%s/instances/%s", testProject, testZone, "real-i1")}}
+	var values []string
+	var gotKeys []string
+	w.ComputeClient.(*daisyCompute.TestClient).GetGuestAttributesFn = func(project, zone, name, queryPath, variableKey string) (*computeBeta.GuestAttributes, error) {
+		if project != testProject || zone != testZone || name != "real-i1" {
+			t.Errorf("unexpected instance projects/%s/zones/%s/instances/%s", project, zone, name)
+		}
+		gotKeys = append(gotKeys, variableKey)
+		if len(values) == 0 {
+			return nil, errors.New("no more values")
+		}
+		v := values[0]
+		values = values[1:]
+		if v == "" {
+			return nil, &googleapi.Error{Code: http.StatusNotFound}
+		}
+		return &computeBeta.GuestAttributes{VariableKey: variableKey, VariableValue: v}, nil
+	}
+	s := &Step{name: "wait", w: w}
+
+	tests := []struct {
+		desc      string
+		ga        *GuestAttributeCondition
+		values    []string
+		wantCalls int
+		shouldErr bool
+	}{
+		{"any value case", &GuestAttributeCondition{Instance: "i1", Key: "daisy/progress"}, []string{"", "10"}, 2, false},
+		{"value case", &GuestAttributeCondition{Instance: "i1", Key: "daisy/progress", Value: "done"}, []string{"", "10", "done"}, 3, false},
+		{"failure value case", &GuestAttributeCondition{Instance: "i1", Key: "daisy/progress", Value: "done", FailureValues: []string{"failed"}}, []string{"10", "failed", "done"}, 2, true},
+		{"API error case", &GuestAttributeCondition{Instance: "i1", Key: "daisy/progress"}, nil, 1, true},
+	}
+
+	for _, tt := range tests {
+		values, gotKeys = tt.values, nil
+		c := &WaitForCondition{GuestAttribute: tt.ga, interval: time.Microsecond}
+		err := c.run(context.Background(), s)
+		if tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+		if len(gotKeys) != tt.wantCalls {
+			t.Errorf("%s: got %d guest attribute requests, want %d", tt.desc, len(gotKeys), tt.wantCalls)
+		}
+		for _, k := range gotKeys {
+			if k != "daisy/progress" {
+				t.Errorf("%s: got variable key %q, want %q", tt.desc, k, "daisy/progress")
+			}
+		}
+	}
+}
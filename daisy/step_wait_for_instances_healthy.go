@@ -0,0 +1,172 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WaitForInstancesHealthy is a Daisy WaitForInstancesHealthy workflow step.
+type WaitForInstancesHealthy []*InstanceHealthCheck
+
+// InstanceHealthCheck waits for an HTTP endpoint on an instance to respond
+// with status 200.
+type InstanceHealthCheck struct {
+	// Instance name to wait for.
+	Name string
+	// Port the endpoint is served on.
+	Port int64
+	// Path of the endpoint, defaults to "/".
+	Path string `json:",omitempty"`
+	// UseExternalIP polls the instance's external IP instead of its internal
+	// IP, for when Daisy isn't running in the instance's network.
+	UseExternalIP bool `json:",omitempty"`
+	// Interval to poll the endpoint (default is 10s). Each request times out
+	// after Interval.
+	// Must be parsable by https://golang.org/pkg/time/#ParseDuration.
+	Interval string `json:",omitempty"`
+	interval time.Duration
+}
+
+func (w *WaitForInstancesHealthy) populate(ctx context.Context, s *Step) DError {
+	for _, hc := range *w {
+		hc.Interval = strOr(hc.Interval, defaultInterval)
+		var err error
+		if hc.interval, err = time.ParseDuration(hc.Interval); err != nil {
+			return newErr("failed to parse duration for step wait_for_instances_healthy", err)
+		}
+		hc.Path = strOr(hc.Path, "/")
+	}
+	return nil
+}
+
+func (w *WaitForInstancesHealthy) validate(ctx context.Context, s *Step) DError {
+	for _, hc := range *w {
+		if _, err := s.w.instances.regUse(hc.Name, s); err != nil {
+			return err
+		}
+		if hc.Port < 1 || hc.Port > 65535 {
+			return Errf("%q: cannot wait for instance health, bad Port %d", hc.Name, hc.Port)
+		}
+		if !strings.HasPrefix(hc.Path, "/") {
+			return Errf("%q: cannot wait for instance health, Path %q must start with \"/\"", hc.Name, hc.Path)
+		}
+		if hc.interval <= 0 {
+			return Errf("%q: cannot wait for instance health, no interval given", hc.Name)
+		}
+	}
+	return nil
+}
+
+func (w *WaitForInstancesHealthy) run(ctx context.Context, s *Step) DError {
+	var wg sync.WaitGroup
+	e := make(chan DError)
+	for _, hc := range *w {
+		wg.Add(1)
+		go func(hc *InstanceHealthCheck) {
+			defer wg.Done()
+			if err := waitForInstanceHealthy(s, hc); err != nil {
+				e <- err
+			}
+		}(hc)
+	}
+
+	go func() {
+		wg.Wait()
+		e <- nil
+	}()
+
+	select {
+	case err := <-e:
+		return err
+	case <-s.w.Cancel:
+		return nil
+	}
+}
+
+// instanceIP returns the internal IP, or with external set the external IP,
+// of the instance's first network interface.
+func instanceIP(s *Step, project, zone, name string, external bool) (string, DError) {
+	inst, err := s.w.ComputeClient.GetInstance(project, zone, name)
+	if err != nil {
+		return "", typedErr(apiError, "failed to get instance", err)
+	}
+	if len(inst.NetworkInterfaces) == 0 {
+		return "", Errf("instance %q has no network interfaces", name)
+	}
+	nic := inst.NetworkInterfaces[0]
+	if !external {
+		if nic.NetworkIP == "" {
+			return "", Errf("instance %q has no internal IP", name)
+		}
+		return nic.NetworkIP, nil
+	}
+	for _, ac := range nic.AccessConfigs {
+		if ac.NatIP != "" {
+			return ac.NatIP, nil
+		}
+	}
+	return "", Errf("instance %q has no external IP", name)
+}
+
+func waitForInstanceHealthy(s *Step, hc *InstanceHealthCheck) DError {
+	w := s.w
+	i, ok := w.instances.get(hc.Name)
+	if !ok {
+		return Errf("unresolved instance %q", hc.Name)
+	}
+	m := NamedSubexp(instanceURLRgx, i.link)
+	ip, err := instanceIP(s, m["project"], m["zone"], m["instance"], hc.UseExternalIP)
+	if err != nil {
+		return err
+	}
+	url := fmt.Sprintf("http://%s%s", net.JoinHostPort(ip, strconv.FormatInt(hc.Port, 10)), hc.Path)
+	w.LogStepInfo(s.name, "WaitForInstancesHealthy", "Instance %q: waiting for %s to return HTTP 200.", hc.Name, url)
+
+	client := &http.Client{Timeout: hc.interval}
+	tick := time.Tick(hc.interval)
+	var lastStatus string
+	for {
+		select {
+		case <-w.Cancel:
+			return nil
+		case <-tick:
+			var status string
+			resp, err := client.Get(url)
+			if err != nil {
+				status = err.Error()
+			} else {
+				resp.Body.Close()
+				if resp.StatusCode == http.StatusOK {
+					w.LogStepInfo(s.name, "WaitForInstancesHealthy", "Instance %q: %s is healthy.", hc.Name, url)
+					return nil
+				}
+				status = resp.Status
+			}
+			// Only log changes so that a long wait doesn't flood the logs.
+			if status != lastStatus {
+				w.LogStepInfo(s.name, "WaitForInstancesHealthy", "Instance %q: %s is not healthy yet: %s", hc.Name, url, status)
+				lastStatus = status
+			}
+		}
+	}
+}
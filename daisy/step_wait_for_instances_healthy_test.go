@@ -0,0 +1,126 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"google.golang.org/api/compute/v1"
+)
+
+func TestWaitForInstancesHealthyPopulate(t *testing.T) {
+	got := &WaitForInstancesHealthy{{Name: "i", Port: 80}, {Name: "j", Port: 80, Path: "/healthz", Interval: "1s"}}
+	if err := got.populate(context.Background(), &Step{}); err != nil {
+		t.Fatal(err)
+	}
+	want := &WaitForInstancesHealthy{
+		{Name: "i", Port: 80, Path: "/", Interval: "10s", interval: 10 * time.Second},
+		{Name: "j", Port: 80, Path: "/healthz", Interval: "1s", interval: time.Second},
+	}
+	if diffRes := diff(got, want, 0); diffRes != "" {
+		t.Errorf("populated WaitForInstancesHealthy does not match expectation: (-got +want)\n%s", diffRes)
+	}
+
+	bad := &WaitForInstancesHealthy{{Name: "i", Port: 80, Interval: "soon"}}
+	if err := bad.populate(context.Background(), &Step{}); err == nil {
+		t.Error("expected error for bad Interval")
+	}
+}
+
+func TestWaitForInstancesHealthyValidate(t *testing.T) {
+	w := testWorkflow()
+	s, _ := w.NewStep("s")
+	iCreator, _ := w.NewStep("iCreator")
+	iCreator.CreateInstances = &CreateInstances{Instances: []*Instance{{}}}
+	w.AddDependency(s, iCreator)
+	if err := w.instances.regCreate("instance1", &Resource{link: fmt.Sprintf("projects/%s/zones/%s/instances/instance1", testProject, testZone)}, false, iCreator); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		desc      string
+		hc        *InstanceHealthCheck
+		shouldErr bool
+	}{
+		{"normal case", &InstanceHealthCheck{Name: "instance1", Port: 8080, Path: "/", interval: time.Second}, false},
+		{"instance DNE case", &InstanceHealthCheck{Name: "instance2", Port: 8080, Path: "/", interval: time.Second}, true},
+		{"no port case", &InstanceHealthCheck{Name: "instance1", Path: "/", interval: time.Second}, true},
+		{"bad port case", &InstanceHealthCheck{Name: "instance1", Port: 70000, Path: "/", interval: time.Second}, true},
+		{"bad path case", &InstanceHealthCheck{Name: "instance1", Port: 8080, Path: "healthz", interval: time.Second}, true},
+		{"no interval case", &InstanceHealthCheck{Name: "instance1", Port: 8080, Path: "/"}, true},
+	}
+
+	for _, tt := range tests {
+		st := &WaitForInstancesHealthy{tt.hc}
+		if err := st.validate(context.Background(), s); (err != nil) != tt.shouldErr {
+			t.Errorf("%s: unexpected validate result, want error: %t, got: %v", tt.desc, tt.shouldErr, err)
+		}
+	}
+}
+
+func TestWaitForInstancesHealthyRun(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Unhealthy for the first two requests.
+		if atomic.AddInt32(&requests, 1) < 3 || r.URL.Path != "/healthz" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+	host, port, err := net.SplitHostPort(ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, _ := strconv.ParseInt(port, 10, 64)
+
+	tests := []struct {
+		desc      string
+		external  bool
+		nic       *compute.NetworkInterface
+		shouldErr bool
+	}{
+		{"internal IP case", false, &compute.NetworkInterface{NetworkIP: host}, false},
+		{"external IP case", true, &compute.NetworkInterface{NetworkIP: "10.0.0.2", AccessConfigs: []*compute.AccessConfig{{NatIP: host}}}, false},
+		{"no external IP case", true, &compute.NetworkInterface{NetworkIP: host}, true},
+	}
+
+	for _, tt := range tests {
+		atomic.StoreInt32(&requests, 0)
+		w := testWorkflow()
+		w.ComputeClient.(*daisyCompute.TestClient).GetInstanceFn = func(_, _, _ string) (*compute.Instance, error) {
+			return &compute.Instance{NetworkInterfaces: []*compute.NetworkInterface{tt.nic}}, nil
+		}
+		w.instances.m = map[string]*Resource{"i": {link: fmt.Sprintf("projects/%s/zones/%s/instances/i", testProject, testZone)}}
+		s := &Step{name: "s", w: w}
+		st := &WaitForInstancesHealthy{{Name: "i", Port: p, Path: "/healthz", UseExternalIP: tt.external, interval: 10 * time.Millisecond}}
+
+		err := st.run(context.Background(), s)
+		if (err != nil) != tt.shouldErr {
+			t.Errorf("%s: unexpected run result, want error: %t, got: %v", tt.desc, tt.shouldErr, err)
+		}
+		if got := atomic.LoadInt32(&requests); !tt.shouldErr && got != 3 {
+			t.Errorf("%s: want 3 health check requests, got %d", tt.desc, got)
+		}
+	}
+}
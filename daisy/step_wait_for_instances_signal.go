@@ -18,14 +18,21 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"path"
 	"regexp"
 	"strings"
 	"sync"
 	"time"
+
+	"cloud.google.com/go/storage"
 )
 
 const (
 	defaultInterval = "10s"
+	// maxOutputValueSize is the size of the largest Output object that is
+	// added to the serial-output values.
+	maxOutputValueSize = 1 << 20
 )
 
 var (
@@ -69,6 +76,29 @@ type SerialOutput struct {
 	SuccessMatch string         `json:",omitempty"`
 	FailureMatch FailureMatches `json:"failureMatch,omitempty"`
 	StatusMatch  string         `json:",omitempty"`
+	// Timeout is how long to wait for a match in total, e.g. "20m".
+	// Must be parsable by https://golang.org/pkg/time/#ParseDuration.
+	Timeout string `json:",omitempty"`
+	timeout time.Duration
+	// IdleTimeout is how long the instance may go without writing new
+	// output before the wait fails, e.g. "2m", to tell a hung instance from
+	// a slow one. Must be parsable by https://golang.org/pkg/time/#ParseDuration.
+	IdleTimeout string `json:",omitempty"`
+	idleTimeout time.Duration
+}
+
+// OutputSignal waits for an object to be uploaded to the workflow's outs
+// path, e.g. a results file the instance's startup script uploads to its
+// daisy-outs-path metadata value once it's done. Once the object exists its
+// content is added to the serial-output values, if it's at most 1 MiB.
+type OutputSignal struct {
+	// Object path relative to the outs path, e.g. "results/build.json".
+	Object string
+	// NonEmpty fails the wait if the object is empty.
+	NonEmpty bool `json:",omitempty"`
+	// Key the object's content is added to the serial-output values as,
+	// defaults to Object.
+	Key string `json:",omitempty"`
 }
 
 // InstanceSignal waits for a signal from an instance.
@@ -83,6 +113,8 @@ type InstanceSignal struct {
 	Stopped bool `json:",omitempty"`
 	// Wait for a string match in the serial output.
 	SerialOutput *SerialOutput `json:",omitempty"`
+	// Wait for an object in the outs path.
+	Output *OutputSignal `json:",omitempty"`
 }
 
 func waitForInstanceStopped(s *Step, project, zone, name string, interval time.Duration) DError {
@@ -99,6 +131,10 @@ func waitForInstanceStopped(s *Step, project, zone, name string, interval time.D
 				return typedErr(apiError, "failed to check whether instance is stopped", err)
 			}
 			if stopped {
+				recordInstanceStopped(w, name)
+				if err := s.preemptedErr("WaitForInstancesSignal", project, zone, name); err != nil {
+					return err
+				}
 				w.LogStepInfo(s.name, "WaitForInstancesSignal", "Instance %q stopped.", name)
 				return nil
 			}
@@ -118,15 +154,42 @@ func waitForSerialOutput(s *Step, project, zone, name string, so *SerialOutput,
 	if so.StatusMatch != "" {
 		msg += fmt.Sprintf(", StatusMatch: %q", so.StatusMatch)
 	}
+	if so.Timeout != "" {
+		msg += fmt.Sprintf(", Timeout: %s", so.Timeout)
+	}
+	if so.IdleTimeout != "" {
+		msg += fmt.Sprintf(", IdleTimeout: %s", so.IdleTimeout)
+	}
 	w.LogStepInfo(s.name, "WaitForInstancesSignal", msg+".")
 	var start int64
+	// received counts the output read, start goes back to 0 if the instance
+	// reboots.
+	var received int64
 	var errs int
+	var checkedPreempted bool
 	tick := time.Tick(interval)
+	var timeout, idle <-chan time.Time
+	if so.timeout > 0 {
+		timeout = time.After(so.timeout)
+	}
+	var idleTimer *time.Timer
+	if so.idleTimeout > 0 {
+		idleTimer = time.NewTimer(so.idleTimeout)
+		defer idleTimer.Stop()
+		idle = idleTimer.C
+	}
 	for {
 		select {
 		case <-s.w.Cancel:
 			return nil
+		case <-timeout:
+			return Errf("WaitForInstancesSignal: instance %q: no match in serial port %d output within Timeout (%s)", name, so.Port, so.Timeout)
+		case <-idle:
+			return Errf("WaitForInstancesSignal: instance %q: no new serial port %d output for IdleTimeout (%s), the instance may be hung", name, so.Port, so.IdleTimeout)
 		case <-tick:
+			if !w.waitSerialPoll() {
+				return nil
+			}
 			resp, err := w.ComputeClient.GetSerialPortOutput(project, zone, name, so.Port, start)
 			if err != nil {
 				status, sErr := w.ComputeClient.InstanceStatus(project, zone, name)
@@ -136,10 +199,22 @@ func waitForSerialOutput(s *Step, project, zone, name string, so *SerialOutput,
 					err = fmt.Errorf("%v, InstanceStatus: %q", err, status)
 				}
 
-				// Wait until machine restarts to evaluate SerialOutput.
-				if status == "TERMINATED" || status == "STOPPED" || status == "STOPPING" {
+				// A preempted instance won't signal, check once each time it's
+				// seen stopped.
+				if status == "TERMINATED" && !checkedPreempted && sErr == nil {
+					checkedPreempted = true
+					if err := s.preemptedErr("WaitForInstancesSignal", project, zone, name); err != nil {
+						return err
+					}
+				}
+				// Wait until machine restarts, or is repaired after a host
+				// failure, to evaluate SerialOutput.
+				if status == "TERMINATED" || status == "STOPPED" || status == "STOPPING" || status == "REPAIRING" {
 					continue
 				}
+				if status == "SUSPENDING" || status == "SUSPENDED" {
+					return Errf("WaitForInstancesSignal: instance %q is %s, it won't signal until it's resumed", name, status)
+				}
 
 				// Retry up to 3 times in a row on any error if we successfully got InstanceStatus.
 				if errs < 3 {
@@ -149,7 +224,18 @@ func waitForSerialOutput(s *Step, project, zone, name string, so *SerialOutput,
 
 				return Errf("WaitForInstancesSignal: instance %q: error getting serial port: %v", name, err)
 			}
+			if idleTimer != nil && resp.Contents != "" {
+				if !idleTimer.Stop() {
+					<-idleTimer.C
+				}
+				idleTimer.Reset(so.idleTimeout)
+			}
+			checkedPreempted = false
 			start = resp.Next
+			received += int64(len(resp.Contents))
+			if w.FailOnMaxSerialBytes && w.MaxSerialBytes > 0 && received > w.MaxSerialBytes {
+				return Errf("WaitForInstancesSignal: instance %q: serial port %d output exceeded MaxSerialBytes (%d bytes)", name, so.Port, w.MaxSerialBytes)
+			}
 			for _, ln := range strings.Split(resp.Contents, "\n") {
 				if so.StatusMatch != "" {
 					if i := strings.Index(ln, so.StatusMatch); i != -1 {
@@ -178,12 +264,51 @@ func waitForSerialOutput(s *Step, project, zone, name string, so *SerialOutput,
 	}
 }
 
+func waitForOutput(ctx context.Context, s *Step, name string, o *OutputSignal, interval time.Duration) DError {
+	w := s.w
+	obj := path.Join(w.outsPath, o.Object)
+	w.LogStepInfo(s.name, "WaitForInstancesSignal", "Instance %q: waiting for gs://%s/%s.", name, w.bucket, obj)
+	tick := time.Tick(interval)
+	for {
+		select {
+		case <-w.Cancel:
+			return nil
+		case <-tick:
+			attrs, err := w.StorageClient.Bucket(w.bucket).Object(obj).Attrs(ctx)
+			if err == storage.ErrObjectNotExist {
+				continue
+			}
+			if err != nil {
+				return typedErrf(apiError, "WaitForInstancesSignal: instance %q: error checking for output gs://%s/%s: %v", name, w.bucket, obj, err)
+			}
+			if attrs.Size == 0 && o.NonEmpty {
+				return Errf("WaitForInstancesSignal: instance %q: output gs://%s/%s is empty", name, w.bucket, obj)
+			}
+			w.LogStepInfo(s.name, "WaitForInstancesSignal", "Instance %q: output gs://%s/%s found.", name, w.bucket, obj)
+			if attrs.Size > maxOutputValueSize {
+				w.LogStepInfo(s.name, "WaitForInstancesSignal", "Instance %q: output gs://%s/%s is larger than %d bytes, not adding it to the serial-output values.", name, w.bucket, obj, maxOutputValueSize)
+				return nil
+			}
+			r, err := w.StorageClient.Bucket(w.bucket).Object(obj).NewReader(ctx)
+			if err != nil {
+				return typedErrf(apiError, "WaitForInstancesSignal: instance %q: error reading output gs://%s/%s: %v", name, w.bucket, obj, err)
+			}
+			defer r.Close()
+			b, err := ioutil.ReadAll(r)
+			if err != nil {
+				return typedErrf(apiError, "WaitForInstancesSignal: instance %q: error reading output gs://%s/%s: %v", name, w.bucket, obj, err)
+			}
+			w.rootWorkflow().AddSerialConsoleOutputValue(strOr(o.Key, o.Object), string(b))
+			return nil
+		}
+	}
+}
+
+// extractOutputValue adds the serial-output value reported in s, if any, to
+// the root workflow, with the values of Output signals.
 func extractOutputValue(w *Workflow, s string) {
 	if matches := serialOutputValueRegex.FindStringSubmatch(s); matches != nil && len(matches) == 3 {
-		for w.parent != nil {
-			w = w.parent
-		}
-		w.AddSerialConsoleOutputValue(matches[1], matches[2])
+		w.rootWorkflow().AddSerialConsoleOutputValue(matches[1], matches[2])
 	}
 }
 
@@ -207,23 +332,36 @@ func populateForWaitForInstancesSignal(w *[]*InstanceSignal, sn string) DError {
 		if err != nil {
 			return newErr(fmt.Sprintf("failed to parse duration for step %v", sn), err)
 		}
+		if so := ws.SerialOutput; so != nil {
+			if so.Timeout != "" {
+				if so.timeout, err = time.ParseDuration(so.Timeout); err != nil {
+					return newErr(fmt.Sprintf("failed to parse SerialOutput.Timeout for step %v", sn), err)
+				}
+			}
+			if so.IdleTimeout != "" {
+				if so.idleTimeout, err = time.ParseDuration(so.IdleTimeout); err != nil {
+					return newErr(fmt.Sprintf("failed to parse SerialOutput.IdleTimeout for step %v", sn), err)
+				}
+			}
+		}
 	}
 	return nil
 }
 
 func (w *WaitForInstancesSignal) run(ctx context.Context, s *Step) DError {
 	is := (*[]*InstanceSignal)(w)
-	return runForWaitForInstancesSignal(is, s, true)
+	return runForWaitForInstancesSignal(ctx, is, s, true)
 }
 
 func (w *WaitForAnyInstancesSignal) run(ctx context.Context, s *Step) DError {
 	is := (*[]*InstanceSignal)(w)
-	return runForWaitForInstancesSignal(is, s, false)
+	return runForWaitForInstancesSignal(ctx, is, s, false)
 }
 
-func runForWaitForInstancesSignal(w *[]*InstanceSignal, s *Step, waitAll bool) DError {
+func runForWaitForInstancesSignal(ctx context.Context, w *[]*InstanceSignal, s *Step, waitAll bool) DError {
 	var wg sync.WaitGroup
 	e := make(chan DError)
+	p := s.w.startProgress(s, ProgressInstancesSignaled, len(*w))
 	for _, is := range *w {
 		wg.Add(1)
 		go func(is *InstanceSignal) {
@@ -236,28 +374,51 @@ func runForWaitForInstancesSignal(w *[]*InstanceSignal, s *Step, waitAll bool) D
 			m := NamedSubexp(instanceURLRgx, i.link)
 			serialSig := make(chan struct{})
 			stoppedSig := make(chan struct{})
+			outputSig := make(chan struct{})
+			// The instance is counted once, whichever signal comes first.
+			var signaled sync.Once
 			if is.Stopped {
 				go func() {
 					if err := waitForInstanceStopped(s, m["project"], m["zone"], m["instance"], is.interval); err != nil {
 						e <- err
+					} else if !s.w.isCanceled() {
+						signaled.Do(p.inc)
 					}
 					close(stoppedSig)
 				}()
 			}
 			if is.SerialOutput != nil {
 				go func() {
-					if err := waitForSerialOutput(s, m["project"], m["zone"], m["instance"], is.SerialOutput, is.interval); err != nil || !waitAll {
+					err := waitForSerialOutput(s, m["project"], m["zone"], m["instance"], is.SerialOutput, is.interval)
+					if err == nil && !s.w.isCanceled() {
+						signaled.Do(p.inc)
+					}
+					if err != nil || !waitAll {
 						// send a signal to end other waiting instances
 						e <- err
 					}
 					close(serialSig)
 				}()
 			}
+			if is.Output != nil {
+				go func() {
+					err := waitForOutput(ctx, s, m["instance"], is.Output, is.interval)
+					if err == nil && !s.w.isCanceled() {
+						signaled.Do(p.inc)
+					}
+					if err != nil || !waitAll {
+						e <- err
+					}
+					close(outputSig)
+				}()
+			}
 			select {
 			case <-serialSig:
 				return
 			case <-stoppedSig:
 				return
+			case <-outputSig:
+				return
 			}
 		}(is)
 	}
@@ -267,10 +428,38 @@ func runForWaitForInstancesSignal(w *[]*InstanceSignal, s *Step, waitAll bool) D
 	}()
 	select {
 	case err := <-e:
-		return err
+		if err != nil {
+			return err
+		}
 	case <-s.w.Cancel:
 		return nil
 	}
+	return keepRunningOnSuccess(w, s)
+}
+
+// keepRunningOnSuccess waits for the step to be approved if any of the
+// instances it watched have KeepRunningOnSuccess set.
+func keepRunningOnSuccess(w *[]*InstanceSignal, s *Step) DError {
+	var keep []string
+	for _, is := range *w {
+		if ib := s.w.instances.createdInstance(is.Name); ib != nil && ib.KeepRunningOnSuccess {
+			keep = append(keep, is.Name)
+		}
+	}
+	if len(keep) == 0 {
+		return nil
+	}
+	msg := fmt.Sprintf("instances %q are kept running for debugging (KeepRunningOnSuccess)", keep)
+	s.w.LogStepInfo(s.name, "WaitForInstancesSignal", "Waiting for approval to continue, %s.", msg)
+	if s.w.ApprovalRequested != nil {
+		s.w.ApprovalRequested(s.name, msg)
+	}
+	select {
+	case <-s.w.approvalChan(s.name):
+		s.w.LogStepInfo(s.name, "WaitForInstancesSignal", "Approved.")
+	case <-s.w.Cancel:
+	}
+	return nil
 }
 
 func (w *WaitForInstancesSignal) validate(ctx context.Context, s *Step) DError {
@@ -292,7 +481,7 @@ func validateForWaitForInstancesSignal(w *[]*InstanceSignal, s *Step) DError {
 		if i.interval == 0*time.Second {
 			return Errf("%q: cannot wait for instance signal, no interval given", i.Name)
 		}
-		if i.SerialOutput == nil && i.Stopped == false {
+		if i.SerialOutput == nil && i.Stopped == false && i.Output == nil {
 			return Errf("%q: cannot wait for instance signal, nothing to wait for", i.Name)
 		}
 		if i.SerialOutput != nil {
@@ -302,6 +491,17 @@ func validateForWaitForInstancesSignal(w *[]*InstanceSignal, s *Step) DError {
 			if i.SerialOutput.SuccessMatch == "" && len(i.SerialOutput.FailureMatch) == 0 {
 				return Errf("%q: cannot wait for instance signal via SerialOutput, no SuccessMatch or FailureMatch given", i.Name)
 			}
+			if i.SerialOutput.timeout < 0 || i.SerialOutput.idleTimeout < 0 {
+				return Errf("%q: cannot wait for instance signal via SerialOutput, Timeout and IdleTimeout must not be negative", i.Name)
+			}
+			if i.SerialOutput.timeout > 0 && i.SerialOutput.idleTimeout > i.SerialOutput.timeout {
+				return Errf("%q: cannot wait for instance signal via SerialOutput, IdleTimeout (%s) is longer than Timeout (%s)", i.Name, i.SerialOutput.IdleTimeout, i.SerialOutput.Timeout)
+			}
+		}
+		if i.Output != nil {
+			if o := path.Clean(i.Output.Object); i.Output.Object == "" || o == "." || strings.HasPrefix(o, "../") || path.IsAbs(o) {
+				return Errf("%q: cannot wait for instance signal via Output, bad Object %q, must be a path in the outs path", i.Name, i.Output.Object)
+			}
 		}
 	}
 	return nil
@@ -19,11 +19,13 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"path"
 	"reflect"
 	"testing"
 	"time"
 
 	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"github.com/GoogleCloudPlatform/compute-image-tools/daisy/fakes"
 	compute "google.golang.org/api/compute/v1"
 	"google.golang.org/api/googleapi"
 )
@@ -31,9 +33,12 @@ import (
 func TestWaitForInstanceStopped(t *testing.T) {
 	w := testWorkflow()
 
+	var ops string
 	svr, c, err := daisyCompute.NewTestClient(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == "GET" && r.URL.String() == fmt.Sprintf("/%s/zones/%s/instances/%s?alt=json&prettyPrint=false", testProject, testZone, "foo") {
 			fmt.Fprint(w, `{"Status":"TERMINATED"}`)
+		} else if r.Method == "GET" && r.URL.Path == fmt.Sprintf("/%s/zones/%s/operations", testProject, testZone) {
+			fmt.Fprintf(w, `{"items":[%s]}`, ops)
 		} else {
 			w.WriteHeader(500)
 			fmt.Fprintln(w, "URL and Method not recognized:", r.Method, r.URL)
@@ -49,6 +54,11 @@ func TestWaitForInstanceStopped(t *testing.T) {
 	if err := waitForInstanceStopped(s, testProject, testZone, "foo", 1*time.Microsecond); err != nil {
 		t.Fatalf("error running waitForInstanceStopped: %v", err)
 	}
+
+	ops = `{"operationType":"compute.instances.preempted"}`
+	if err := waitForInstanceStopped(s, testProject, testZone, "foo", 1*time.Microsecond); err == nil || !err.CausedByErrType(instancePreemptedError) {
+		t.Errorf("got error %v, want an %s error", err, instancePreemptedError)
+	}
 }
 
 func TestWaitForInstancesSignalPopulate(t *testing.T) {
@@ -71,6 +81,23 @@ func testWaitForSignalPopulate(t *testing.T, waitAny bool) {
 	}
 }
 
+func TestWaitForInstancesSignalPopulateTimeouts(t *testing.T) {
+	ws := &WaitForInstancesSignal{{Name: "test", SerialOutput: &SerialOutput{Port: 1, SuccessMatch: "test", Timeout: "20m", IdleTimeout: "2m"}}}
+	if err := ws.populate(context.Background(), &Step{}); err != nil {
+		t.Fatalf("error running populate: %v", err)
+	}
+	if so := (*ws)[0].SerialOutput; so.timeout != 20*time.Minute || so.idleTimeout != 2*time.Minute {
+		t.Errorf("got timeout %s and idleTimeout %s, want 20m and 2m", so.timeout, so.idleTimeout)
+	}
+
+	for _, so := range []*SerialOutput{{Port: 1, Timeout: "soon"}, {Port: 1, IdleTimeout: "2"}} {
+		ws := &WaitForInstancesSignal{{Name: "test", SerialOutput: so}}
+		if err := ws.populate(context.Background(), &Step{}); err == nil {
+			t.Errorf("populate should have returned an error for %+v", so)
+		}
+	}
+}
+
 func TestWaitForInstancesSignalRun(t *testing.T) {
 	testWaitForSignalRun(t, false)
 }
@@ -192,6 +219,162 @@ func testWaitForSignalRun(t *testing.T, waitAny bool) {
 	}
 }
 
+func TestWaitForSerialOutputMaxSerialBytes(t *testing.T) {
+	w := testWorkflow()
+	w.MaxSerialBytes = 5
+	w.ComputeClient.(*daisyCompute.TestClient).GetSerialPortOutputFn = func(_, _, _ string, _, _ int64) (*compute.SerialPortOutput, error) {
+		return &compute.SerialPortOutput{Contents: "0123 success", Next: 12}, nil
+	}
+	s := &Step{name: "foo", w: w}
+	so := &SerialOutput{Port: 1, SuccessMatch: "success"}
+
+	if err := waitForSerialOutput(s, testProject, testZone, "i1", so, 1*time.Microsecond); err != nil {
+		t.Errorf("unexpected error without FailOnMaxSerialBytes: %v", err)
+	}
+
+	w.FailOnMaxSerialBytes = true
+	want := `WaitForInstancesSignal: instance "i1": serial port 1 output exceeded MaxSerialBytes (5 bytes)`
+	if err := waitForSerialOutput(s, testProject, testZone, "i1", so, 1*time.Microsecond); err == nil || err.Error() != want {
+		t.Errorf("did not get expected error, got: %v, want: %q", err, want)
+	}
+}
+
+func TestWaitForSerialOutputMaxSerialBytesRebooting(t *testing.T) {
+	w := testWorkflow()
+	w.MaxSerialBytes = 10
+	w.FailOnMaxSerialBytes = true
+	// The instance reboots between reads, the serial port offset stays below
+	// MaxSerialBytes though more than that is read in total.
+	w.ComputeClient.(*daisyCompute.TestClient).GetSerialPortOutputFn = func(_, _, _ string, _, _ int64) (*compute.SerialPortOutput, error) {
+		return &compute.SerialPortOutput{Contents: "boot", Next: 4}, nil
+	}
+	s := &Step{name: "foo", w: w}
+	so := &SerialOutput{Port: 1, SuccessMatch: "success", Timeout: "1s", timeout: time.Second}
+
+	want := `WaitForInstancesSignal: instance "i1": serial port 1 output exceeded MaxSerialBytes (10 bytes)`
+	if err := waitForSerialOutput(s, testProject, testZone, "i1", so, 1*time.Microsecond); err == nil || err.Error() != want {
+		t.Errorf("did not get expected error, got: %v, want: %q", err, want)
+	}
+}
+
+func TestWaitForSerialOutputInstanceStatus(t *testing.T) {
+	w := testWorkflow()
+	statuses := []string{"REPAIRING", "REPAIRING", "REPAIRING", "REPAIRING", "SUSPENDED"}
+	callNum := 0
+	w.ComputeClient.(*daisyCompute.TestClient).GetSerialPortOutputFn = func(_, _, _ string, _, _ int64) (*compute.SerialPortOutput, error) {
+		return nil, errors.New("fail")
+	}
+	w.ComputeClient.(*daisyCompute.TestClient).InstanceStatusFn = func(_, _, _ string) (string, error) {
+		status := statuses[callNum]
+		callNum++
+		return status, nil
+	}
+	s := &Step{name: "foo", w: w}
+	so := &SerialOutput{Port: 1, SuccessMatch: "success"}
+
+	// Errors while the instance is repaired don't count towards the 3 retries.
+	want := `WaitForInstancesSignal: instance "i1" is SUSPENDED, it won't signal until it's resumed`
+	if err := waitForSerialOutput(s, testProject, testZone, "i1", so, 1*time.Microsecond); err == nil || err.Error() != want {
+		t.Errorf("did not get expected error, got: %v, want: %q", err, want)
+	}
+	if callNum != len(statuses) {
+		t.Errorf("unexpected number of InstanceStatus calls, got: %d, want: %d", callNum, len(statuses))
+	}
+}
+
+func TestWaitForSerialOutputPreempted(t *testing.T) {
+	w := testWorkflow()
+	tc := w.ComputeClient.(*daisyCompute.TestClient)
+	tc.GetSerialPortOutputFn = func(_, _, _ string, _, _ int64) (*compute.SerialPortOutput, error) {
+		return nil, errors.New("fail")
+	}
+	tc.InstanceStatusFn = func(_, _, _ string) (string, error) {
+		return "TERMINATED", nil
+	}
+	var checks int
+	tc.InstancePreemptedFn = func(_, _, _ string) (bool, error) {
+		checks++
+		return checks > 1, nil
+	}
+	s := &Step{name: "foo", w: w}
+	so := &SerialOutput{Port: 1, SuccessMatch: "success", Timeout: "50ms", timeout: 50 * time.Millisecond}
+
+	// Preemption is only checked once while the instance stays stopped.
+	err := waitForSerialOutput(s, testProject, testZone, "i1", so, 1*time.Microsecond)
+	if err == nil || err.CausedByErrType(instancePreemptedError) || checks != 1 {
+		t.Errorf("got error %v after %d checks, want a timeout after 1 check", err, checks)
+	}
+	if err := waitForSerialOutput(s, testProject, testZone, "i1", so, 1*time.Microsecond); err == nil || !err.CausedByErrType(instancePreemptedError) {
+		t.Errorf("got error %v, want an %s error", err, instancePreemptedError)
+	}
+}
+
+func TestWaitForSerialOutputTimeouts(t *testing.T) {
+	tests := []struct {
+		desc     string
+		contents string
+		so       *SerialOutput
+		want     string
+	}{
+		{"idle case", "", &SerialOutput{Port: 1, SuccessMatch: "success", IdleTimeout: "10ms", idleTimeout: 10 * time.Millisecond},
+			`WaitForInstancesSignal: instance "i1": no new serial port 1 output for IdleTimeout (10ms), the instance may be hung`},
+		{"timeout case", "working\n", &SerialOutput{Port: 1, SuccessMatch: "success", Timeout: "50ms", timeout: 50 * time.Millisecond, IdleTimeout: "10ms", idleTimeout: 10 * time.Millisecond},
+			`WaitForInstancesSignal: instance "i1": no match in serial port 1 output within Timeout (50ms)`},
+		{"success case", "success\n", &SerialOutput{Port: 1, SuccessMatch: "success", Timeout: "50ms", timeout: 50 * time.Millisecond, IdleTimeout: "10ms", idleTimeout: 10 * time.Millisecond}, ""},
+	}
+	for _, tt := range tests {
+		w := testWorkflow()
+		var next int64
+		w.ComputeClient.(*daisyCompute.TestClient).GetSerialPortOutputFn = func(_, _, _ string, _, _ int64) (*compute.SerialPortOutput, error) {
+			next += int64(len(tt.contents))
+			return &compute.SerialPortOutput{Contents: tt.contents, Next: next}, nil
+		}
+		s := &Step{name: "foo", w: w}
+		err := waitForSerialOutput(s, testProject, testZone, "i1", tt.so, 1*time.Millisecond)
+		if tt.want == "" && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		} else if tt.want != "" && (err == nil || err.Error() != tt.want) {
+			t.Errorf("%s: did not get expected error, got: %v, want: %q", tt.desc, err, tt.want)
+		}
+	}
+}
+
+func TestWaitForInstancesSignalKeepRunningOnSuccess(t *testing.T) {
+	w := testWorkflow()
+	w.ComputeClient.(*daisyCompute.TestClient).GetSerialPortOutputFn = func(_, _, _ string, _, _ int64) (*compute.SerialPortOutput, error) {
+		return &compute.SerialPortOutput{Contents: "success", Next: 7}, nil
+	}
+	creator, _ := w.NewStep("create")
+	i1 := &Instance{InstanceBase: InstanceBase{KeepRunningOnSuccess: true, Resource: Resource{link: fmt.Sprintf("projects/%s/zones/%s/instances/i1", testProject, testZone)}}}
+	i2 := &Instance{InstanceBase: InstanceBase{Resource: Resource{link: fmt.Sprintf("projects/%s/zones/%s/instances/i2", testProject, testZone)}}}
+	creator.CreateInstances = &CreateInstances{Instances: []*Instance{i1, i2}}
+	i1.creator, i2.creator = creator, creator
+	w.instances.m = map[string]*Resource{"i1": &i1.Resource, "i2": &i2.Resource}
+	var gotStep, gotMessage string
+	w.ApprovalRequested = func(step, message string) {
+		gotStep, gotMessage = step, message
+		w.Approve(step)
+	}
+
+	s := &Step{name: "wait", w: w}
+	ws := &WaitForInstancesSignal{{Name: "i2", interval: 1 * time.Microsecond, SerialOutput: &SerialOutput{Port: 1, SuccessMatch: "success"}}}
+	if err := ws.run(context.Background(), s); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if gotStep != "" {
+		t.Errorf("step without KeepRunningOnSuccess instances requested approval")
+	}
+
+	*ws = append(*ws, &InstanceSignal{Name: "i1", interval: 1 * time.Microsecond, SerialOutput: &SerialOutput{Port: 1, SuccessMatch: "success"}})
+	if err := ws.run(context.Background(), s); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	want := `instances ["i1"] are kept running for debugging (KeepRunningOnSuccess)`
+	if gotStep != "wait" || gotMessage != want {
+		t.Errorf("got approval request (%q, %q), want (%q, %q)", gotStep, gotMessage, "wait", want)
+	}
+}
+
 func TestWaitForInstancesSignalValidate(t *testing.T) {
 	testWaitForSignalValidate(t, false)
 }
@@ -221,10 +404,15 @@ func testWaitForSignalValidate(t *testing.T, waitAny bool) {
 		{"normal SerialOutput FailureMatch", getStep(waitAny, []*InstanceSignal{{Name: "instance1", SerialOutput: &SerialOutput{Port: 1, FailureMatch: []string{"fail"}}, interval: 1 * time.Second}}), false},
 		{"normal SerialOutput SuccessMatch FailureMatch", getStep(waitAny, []*InstanceSignal{{Name: "instance1", SerialOutput: &SerialOutput{Port: 1, SuccessMatch: "test", FailureMatch: []string{"fail"}}, interval: 1 * time.Second}}), false},
 		{"normal SerialOutput SuccessMatch FailureMatch-es", getStep(waitAny, []*InstanceSignal{{Name: "instance1", SerialOutput: &SerialOutput{Port: 1, SuccessMatch: "test", FailureMatch: []string{"fail", "fail2"}}, interval: 1 * time.Second}}), false},
+		{"SerialOutput timeouts", getStep(waitAny, []*InstanceSignal{{Name: "instance1", SerialOutput: &SerialOutput{Port: 1, SuccessMatch: "test", timeout: 20 * time.Minute, idleTimeout: 2 * time.Minute}, interval: 1 * time.Second}}), false},
+		{"SerialOutput IdleTimeout longer than Timeout", getStep(waitAny, []*InstanceSignal{{Name: "instance1", SerialOutput: &SerialOutput{Port: 1, SuccessMatch: "test", timeout: 2 * time.Minute, idleTimeout: 20 * time.Minute}, interval: 1 * time.Second}}), true},
 		{"SerialOutput no port", getStep(waitAny, []*InstanceSignal{{Name: "instance1", SerialOutput: &SerialOutput{SuccessMatch: "test"}, interval: 1 * time.Second}}), true},
 		{"SerialOutput no SuccessMatch or FailureMatch or FailureMatches", getStep(waitAny, []*InstanceSignal{{Name: "instance1", SerialOutput: &SerialOutput{Port: 1}, interval: 1 * time.Second}}), true},
 		{"instance DNE error check", getStep(waitAny, []*InstanceSignal{{Name: "instance1", Stopped: true, interval: 1 * time.Second}, {Name: "instance2", Stopped: true, interval: 1 * time.Second}}), true},
 		{"no interval", getStep(waitAny, []*InstanceSignal{{Name: "instance1", Stopped: true, Interval: "0s"}}), true},
+		{"normal Output", getStep(waitAny, []*InstanceSignal{{Name: "instance1", Output: &OutputSignal{Object: "results/out.json"}, interval: 1 * time.Second}}), false},
+		{"Output no Object", getStep(waitAny, []*InstanceSignal{{Name: "instance1", Output: &OutputSignal{}, interval: 1 * time.Second}}), true},
+		{"Output Object outside outs path", getStep(waitAny, []*InstanceSignal{{Name: "instance1", Output: &OutputSignal{Object: "../logs/daisy.log"}, interval: 1 * time.Second}}), true},
 		{"no signal", getStep(waitAny, []*InstanceSignal{{Name: "instance1", interval: 1 * time.Second}}), true},
 	}
 
@@ -295,3 +483,91 @@ func getStep(waitAny bool, iss []*InstanceSignal) stepImpl {
 	}
 	return &si
 }
+
+func TestWaitForOutput(t *testing.T) {
+	large := make([]byte, maxOutputValueSize+1)
+	tests := []struct {
+		desc      string
+		o         *OutputSignal
+		data      []byte
+		later     bool
+		wantKey   string
+		wantValue string
+		shouldErr bool
+	}{
+		{"exists case", &OutputSignal{Object: "results.json"}, []byte("done"), false, "results.json", "done", false},
+		{"uploaded while waiting case", &OutputSignal{Object: "results.json"}, []byte("done"), true, "results.json", "done", false},
+		{"Key case", &OutputSignal{Object: "a/results.json", Key: "results"}, []byte("done"), false, "results", "done", false},
+		{"empty case", &OutputSignal{Object: "results.json"}, nil, false, "results.json", "", false},
+		{"NonEmpty case", &OutputSignal{Object: "results.json", NonEmpty: true}, nil, false, "", "", true},
+		{"too large case", &OutputSignal{Object: "results.json"}, large, false, "", "", false},
+	}
+	for _, tt := range tests {
+		gcs, err := fakes.NewStorage("bucket")
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := testWorkflow()
+		w.StorageClient = gcs.Client
+		w.bucket = "bucket"
+		w.outsPath = "scratch/outs"
+		s, _ := w.NewStep("s")
+		if tt.later {
+			go func() {
+				time.Sleep(10 * time.Millisecond)
+				gcs.WriteObject("bucket", path.Join(w.outsPath, tt.o.Object), tt.data)
+			}()
+		} else {
+			gcs.WriteObject("bucket", path.Join(w.outsPath, tt.o.Object), tt.data)
+		}
+
+		err = waitForOutput(context.Background(), s, "i", tt.o, time.Millisecond)
+		gcs.Close()
+		if (err != nil) != tt.shouldErr {
+			t.Errorf("%s: got error %v, want error %t", tt.desc, err, tt.shouldErr)
+			continue
+		}
+		var want map[string]string
+		if tt.wantKey != "" {
+			want = map[string]string{tt.wantKey: tt.wantValue}
+		}
+		if diffRes := diff(w.serialControlOutputValues, want, 0); diffRes != "" {
+			t.Errorf("%s: serial-output values not as expected: (-got,+want)\n%s", tt.desc, diffRes)
+		}
+	}
+}
+
+func TestWaitForOutputCanceled(t *testing.T) {
+	gcs, err := fakes.NewStorage("bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gcs.Close()
+	w := testWorkflow()
+	w.StorageClient = gcs.Client
+	w.bucket = "bucket"
+	s, _ := w.NewStep("s")
+	close(w.Cancel)
+	if err := waitForOutput(context.Background(), s, "i", &OutputSignal{Object: "dne"}, time.Millisecond); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestExtractOutputValue(t *testing.T) {
+	root := testWorkflow()
+	child := testWorkflow()
+	child.parent = root
+
+	extractOutputValue(child, "status: <serial-output key:'my-key' value:'my-value'>")
+	extractOutputValue(child, "status: no value")
+	// Values are kept on the root workflow, like those of Output signals.
+	if got := root.GetSerialConsoleOutputValue("my-key"); got != "my-value" {
+		t.Errorf("root value = %q, want %q", got, "my-value")
+	}
+	if got := child.GetSerialConsoleOutputValue("my-key"); got != "" {
+		t.Errorf("child value = %q, want none", got)
+	}
+	if got, ok := child.lookupSerialConsoleOutputValue("my-key"); !ok || got != "my-value" {
+		t.Errorf("child lookup = %q, %t, want %q", got, ok, "my-value")
+	}
+}
@@ -15,10 +15,14 @@
 package daisy
 
 import (
+	"context"
 	"fmt"
 	"path"
 	"regexp"
+	"strings"
 	"sync"
+
+	"cloud.google.com/go/storage"
 )
 
 var (
@@ -46,8 +50,61 @@ var (
 	gsHTTPRegex3 = regexp.MustCompile(fmt.Sprintf(`^http[s]?://(?:commondata)?storage\.googleapis\.com/%s/%s$`, bucket, object))
 
 	gcsAPIBase = "https://storage.cloud.google.com"
+
+	validStorageClasses = []string{"STANDARD", "NEARLINE", "COLDLINE", "ARCHIVE", "MULTI_REGIONAL", "REGIONAL"}
+
+	validPredefinedObjectACLs = []string{"authenticatedRead", "bucketOwnerFullControl", "bucketOwnerRead", "private", "projectPrivate", "publicRead"}
+	validObjectACLRoles       = []string{string(storage.RoleOwner), string(storage.RoleReader)}
 )
 
+// validateObjectACL validates ObjectPredefinedACL and ObjectACLRules,
+// upper-casing the rules' roles as for CopyGCSObjects.
+func (w *Workflow) validateObjectACL() DError {
+	if w.ObjectPredefinedACL != "" && len(w.ObjectACLRules) > 0 {
+		return Errf("ObjectPredefinedACL and ObjectACLRules are mutually exclusive")
+	}
+	if w.ObjectPredefinedACL != "" && !strIn(w.ObjectPredefinedACL, validPredefinedObjectACLs) {
+		return Errf("ObjectPredefinedACL must be one of %q, got %q", validPredefinedObjectACLs, w.ObjectPredefinedACL)
+	}
+	for _, acl := range w.ObjectACLRules {
+		acl.Role = storage.ACLRole(strings.ToUpper(string(acl.Role)))
+		if acl.Entity == "" {
+			return Errf("ObjectACLRules: ACLRule.Entity must not be empty: %+v", acl)
+		}
+		if !strIn(string(acl.Role), validObjectACLRoles) {
+			return Errf("ObjectACLRules: ACLRule.Role invalid: %q not one of %q", acl.Role, validObjectACLRoles)
+		}
+	}
+	return nil
+}
+
+// checkBucketAllowsObjectACL returns an error if ObjectPredefinedACL or
+// ObjectACLRules is set and the workflow's bucket has uniform bucket-level
+// access, where GCS rejects writes that set object ACLs. Included and sub
+// workflows use the same bucket and ACLs as their parent, which checked.
+func (w *Workflow) checkBucketAllowsObjectACL(ctx context.Context) DError {
+	if w.parent != nil || (w.ObjectPredefinedACL == "" && len(w.ObjectACLRules) == 0) {
+		return nil
+	}
+	attrs, err := w.StorageClient.Bucket(w.bucket).Attrs(ctx)
+	if err != nil {
+		return typedErrf(apiError, "failed to get the attributes of bucket %q: %v", w.bucket, err)
+	}
+	if attrs.UniformBucketLevelAccess.Enabled {
+		return Errf("ObjectPredefinedACL and ObjectACLRules can't be used with bucket %q, it has uniform bucket-level access so GCS rejects object ACLs", w.bucket)
+	}
+	return nil
+}
+
+// setObjectACL sets ObjectPredefinedACL or ObjectACLRules on the attributes
+// of an object Daisy writes.
+func (w *Workflow) setObjectACL(attrs *storage.ObjectAttrs) {
+	attrs.PredefinedACL = w.ObjectPredefinedACL
+	for _, acl := range w.ObjectACLRules {
+		attrs.ACL = append(attrs.ACL, *acl)
+	}
+}
+
 func getGCSAPIPath(p string) (string, DError) {
 	b, o, e := splitGCSPath(p)
 	if e != nil {
@@ -77,3 +134,29 @@ type validatedBkts struct {
 
 var writableBkts validatedBkts
 var readableBkts validatedBkts
+
+// validateBktWritable checks that bkt exists and that objects can be written
+// to it by writing and deleting a test object, obj.
+func validateBktWritable(ctx context.Context, client *storage.Client, bkt, obj string) DError {
+	writableBkts.mx.Lock()
+	defer writableBkts.mx.Unlock()
+	if strIn(bkt, writableBkts.bkts) {
+		return nil
+	}
+	if _, err := client.Bucket(bkt).Attrs(ctx); err != nil {
+		return Errf("error reading bucket %q: %v", bkt, err)
+	}
+	tObj := client.Bucket(bkt).Object(obj)
+	w := tObj.NewWriter(ctx)
+	if _, err := w.Write(nil); err != nil {
+		return Errf("error writing to bucket %q: %v", bkt, err)
+	}
+	if err := w.Close(); err != nil {
+		return Errf("error writing to bucket %q: %v", bkt, err)
+	}
+	if err := tObj.Delete(ctx); err != nil {
+		return Errf("error deleting file %+v after write validation: %v", tObj, err)
+	}
+	writableBkts.bkts = append(writableBkts.bkts, bkt)
+	return nil
+}
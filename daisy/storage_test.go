@@ -15,7 +15,14 @@
 package daisy
 
 import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/option"
 )
 
 func TestGetGCSAPIPath(t *testing.T) {
@@ -61,3 +68,94 @@ func TestSplitGCSPath(t *testing.T) {
 		}
 	}
 }
+
+func TestValidateObjectACL(t *testing.T) {
+	tests := []struct {
+		desc       string
+		predefined string
+		rules      []*storage.ACLRule
+		shouldErr  bool
+	}{
+		{"unset case", "", nil, false},
+		{"predefined case", "projectPrivate", nil, false},
+		{"rules case", "", []*storage.ACLRule{{Entity: "group-team@example.com", Role: "reader"}}, false},
+		{"bad predefined case", "public", nil, true},
+		{"no entity case", "", []*storage.ACLRule{{Role: storage.RoleReader}}, true},
+		{"bad role case", "", []*storage.ACLRule{{Entity: "allUsers", Role: storage.RoleWriter}}, true},
+		{"both case", "projectPrivate", []*storage.ACLRule{{Entity: "allUsers", Role: storage.RoleReader}}, true},
+	}
+	for _, tt := range tests {
+		w := testWorkflow()
+		w.ObjectPredefinedACL = tt.predefined
+		w.ObjectACLRules = tt.rules
+		err := w.validateObjectACL()
+		if tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+	}
+}
+
+func TestSetObjectACL(t *testing.T) {
+	w := testWorkflow()
+	w.ObjectACLRules = []*storage.ACLRule{{Entity: "group-team@example.com", Role: "reader"}}
+	if err := w.validateObjectACL(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var attrs storage.ObjectAttrs
+	w.setObjectACL(&attrs)
+	want := []storage.ACLRule{{Entity: "group-team@example.com", Role: storage.RoleReader}}
+	if diffRes := diff(attrs.ACL, want, 0); diffRes != "" {
+		t.Errorf("ACL not as expected: (-got,+want)\n%s", diffRes)
+	}
+
+	w.ObjectACLRules = nil
+	w.ObjectPredefinedACL = "bucketOwnerRead"
+	attrs = storage.ObjectAttrs{}
+	w.setObjectACL(&attrs)
+	if attrs.PredefinedACL != "bucketOwnerRead" || attrs.ACL != nil {
+		t.Errorf("got PredefinedACL %q and ACL %v, want %q and no ACL", attrs.PredefinedACL, attrs.ACL, "bucketOwnerRead")
+	}
+}
+
+func TestCheckBucketAllowsObjectACL(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/b/uniform":
+			fmt.Fprint(w, `{"kind":"storage#bucket","name":"uniform","iamConfiguration":{"uniformBucketLevelAccess":{"enabled":true}}}`)
+		case "/b/fine-grained":
+			fmt.Fprint(w, `{"kind":"storage#bucket","name":"fine-grained"}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	client, err := storage.NewClient(context.Background(), option.WithEndpoint(ts.URL), option.WithHTTPClient(http.DefaultClient))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		desc, bucket, predefined string
+		child, shouldErr         bool
+	}{
+		{"no ACL case", "uniform", "", false, false},
+		{"fine-grained bucket case", "fine-grained", "projectPrivate", false, false},
+		{"uniform bucket case", "uniform", "projectPrivate", false, true},
+		{"child workflow case", "uniform", "projectPrivate", true, false},
+		{"bucket error case", "dne", "projectPrivate", false, true},
+	}
+	for _, tt := range tests {
+		w := testWorkflow()
+		w.StorageClient = client
+		w.bucket = tt.bucket
+		w.ObjectPredefinedACL = tt.predefined
+		if tt.child {
+			w.parent = testWorkflow()
+		}
+		if err := w.checkBucketAllowsObjectACL(context.Background()); (err != nil) != tt.shouldErr {
+			t.Errorf("%s: got error %v, want error %t", tt.desc, err, tt.shouldErr)
+		}
+	}
+}
@@ -53,7 +53,7 @@ func (sn *Subnetwork) populate(ctx context.Context, s *Step) DError {
 	sn.Name, errs = sn.Resource.populateWithGlobal(ctx, s, sn.Name)
 
 	sn.Description = strOr(sn.Description, defaultDescription("Subnetwork", s.w.Name, s.w.username))
-	sn.link = fmt.Sprintf("projects/%s/regions/%s/subnetworks/%s", sn.Project, getRegionFromZone(s.w.Zone), sn.Name)
+	sn.link = fmt.Sprintf("projects/%s/regions/%s/subnetworks/%s", sn.Project, strOr(sn.Region, getRegionFromZone(s.w.Zone)), sn.Name)
 	return errs
 }
 
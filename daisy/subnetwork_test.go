@@ -34,6 +34,7 @@ func TestSubnetworkPopulate(t *testing.T) {
 		sn, want *Subnetwork
 	}{
 		{"defaults case", &Subnetwork{}, &Subnetwork{Subnetwork: compute.Subnetwork{Description: desc}, Resource: Resource{link: fmt.Sprintf("projects/%s/regions/%s/subnetworks/%s", w.Project, getRegionFromZone(w.Zone), name)}}},
+		{"region case", &Subnetwork{Subnetwork: compute.Subnetwork{Region: "r"}}, &Subnetwork{Subnetwork: compute.Subnetwork{Description: desc, Region: "r"}, Resource: Resource{link: fmt.Sprintf("projects/%s/regions/r/subnetworks/%s", w.Project, name)}}},
 	}
 
 	for _, tt := range tests {
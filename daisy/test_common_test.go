@@ -77,6 +77,7 @@ var (
 	testRegion         = "test-zo"
 	testDisk           = "test-disk"
 	testForwardingRule = "test-forwarding-rule"
+	testAddress        = "test-address"
 	testFirewallRule   = "test-firewall-rule"
 	testImage          = "test-image"
 	testMachineImage   = "test-machine-image"
@@ -207,6 +208,15 @@ func newTestGCEClient() (*daisyCompute.TestClient, error) {
 		}
 		return []*compute.ForwardingRule{{Name: testForwardingRule}}, nil
 	}
+	c.ListAddressesFn = func(p, r string, _ ...daisyCompute.ListCallOption) ([]*compute.Address, error) {
+		if p != testProject {
+			return nil, errors.New("bad project: " + p)
+		}
+		if r != testRegion {
+			return nil, errors.New("bad region: " + r)
+		}
+		return []*compute.Address{{Name: testAddress}}, nil
+	}
 	c.ListLicensesFn = func(p string, _ ...daisyCompute.ListCallOption) ([]*compute.License, error) {
 		if p != testProject {
 			return nil, errors.New("bad project: " + p)
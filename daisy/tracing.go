@@ -0,0 +1,92 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"sync"
+)
+
+// Tracer starts the spans Daisy traces a workflow's execution with: one for
+// each workflow, including included and sub workflows, with child spans for
+// its steps, the instances they create, from creation until they stop, and
+// the Compute API insert calls of CreateInstances and CreateDisks. Other
+// Compute and GCS API calls, e.g. deletions, aren't traced. It can be an
+// adapter for an OpenTelemetry trace.Tracer, keeping the span in the
+// returned context so that child spans nest under it.
+type Tracer interface {
+	// Start starts a span named name, with attributes attrs, as a child of
+	// the span in ctx, if any, and returns a context holding the new span.
+	Start(ctx context.Context, name string, attrs map[string]string) (context.Context, Span)
+}
+
+// Span is a traced operation started by a Tracer.
+type Span interface {
+	// AddEvent records that something happened during the operation, e.g.
+	// that an instance is running.
+	AddEvent(name string)
+	// End ends the span. err is the error the operation failed with, if any.
+	End(err error)
+}
+
+type noopTracer struct{}
+
+func (noopTracer) Start(ctx context.Context, _ string, _ map[string]string) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) AddEvent(string) {}
+func (noopSpan) End(error)       {}
+
+// tracer returns the Tracer of the workflow or its closest parent with one,
+// or a no-op tracer if none has.
+func (w *Workflow) tracer() Tracer {
+	for ; w != nil; w = w.parent {
+		if w.Tracer != nil {
+			return w.Tracer
+		}
+	}
+	return noopTracer{}
+}
+
+// traceCall runs f, an API call, in a span named name.
+func (w *Workflow) traceCall(ctx context.Context, name string, attrs map[string]string, f func() error) error {
+	_, span := w.tracer().Start(ctx, name, attrs)
+	err := f()
+	span.End(err)
+	return err
+}
+
+// instanceSpan is the span of an instance, from its creation until it
+// stops. Its serial port logging goroutines all end it, only the first
+// one counts.
+type instanceSpan struct {
+	Span
+	once sync.Once
+}
+
+func (s *instanceSpan) AddEvent(name string) {
+	if s != nil {
+		s.Span.AddEvent(name)
+	}
+}
+
+func (s *instanceSpan) End(err error) {
+	if s != nil {
+		s.once.Do(func() { s.Span.End(err) })
+	}
+}
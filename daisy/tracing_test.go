@@ -0,0 +1,143 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testSpanKey struct{}
+
+type testSpan struct {
+	tr     *testTracer
+	Name   string
+	Parent string
+	Attrs  map[string]string
+	Events []string
+	Err    string
+	Ended  int
+}
+
+func (s *testSpan) AddEvent(name string) {
+	s.tr.mx.Lock()
+	defer s.tr.mx.Unlock()
+	s.Events = append(s.Events, name)
+}
+
+func (s *testSpan) End(err error) {
+	s.tr.mx.Lock()
+	defer s.tr.mx.Unlock()
+	s.Ended++
+	if err != nil {
+		s.Err = err.Error()
+	}
+}
+
+// testTracer records the spans it starts, in the order they're started.
+type testTracer struct {
+	mx    sync.Mutex
+	spans []*testSpan
+}
+
+func (tr *testTracer) Start(ctx context.Context, name string, attrs map[string]string) (context.Context, Span) {
+	tr.mx.Lock()
+	defer tr.mx.Unlock()
+	s := &testSpan{tr: tr, Name: name, Attrs: attrs}
+	if p, ok := ctx.Value(testSpanKey{}).(*testSpan); ok {
+		s.Parent = p.Name
+	}
+	tr.spans = append(tr.spans, s)
+	return context.WithValue(ctx, testSpanKey{}, s), s
+}
+
+func TestWorkflowRunTracing(t *testing.T) {
+	w := testWorkflow()
+	tr := &testTracer{}
+	w.Tracer = tr
+	s1, _ := w.NewStep("s1")
+	s1.testType = &mockStep{}
+	s1.timeout = time.Minute
+	s2, _ := w.NewStep("s2")
+	s2.timeout = time.Minute
+	s2.testType = &mockStep{runImpl: func(ctx context.Context, s *Step) DError {
+		return newErr("call failed", s.w.traceCall(ctx, "call", nil, func() error { return errors.New("call error") }))
+	}}
+	w.AddDependency(s2, s1)
+
+	if err := w.run(context.Background()); err == nil {
+		t.Fatal("expected an error")
+	}
+	want := []*testSpan{
+		{Name: "daisy.workflow", Attrs: map[string]string{"daisy.workflow.name": testWf, "daisy.workflow.id": "abcdef"}, Ended: 1},
+		{Name: "daisy.step", Parent: "daisy.workflow", Attrs: map[string]string{"daisy.step.name": "s1", "daisy.step.type": "mockStep"}, Ended: 1},
+		{Name: "daisy.step", Parent: "daisy.workflow", Attrs: map[string]string{"daisy.step.name": "s2", "daisy.step.type": "mockStep"}, Ended: 1},
+		{Name: "call", Parent: "daisy.step", Err: "call error", Ended: 1},
+	}
+	if len(tr.spans) != len(want) {
+		t.Fatalf("got %d spans, want %d", len(tr.spans), len(want))
+	}
+	for _, s := range tr.spans {
+		s.tr = nil
+	}
+	// Errors are wrapped as they go up, only check that they're recorded.
+	for i, s := range tr.spans[:3] {
+		if (s.Err != "") != (i != 1) {
+			t.Errorf("span %d: got error %q, want error %t", i, s.Err, i != 1)
+		}
+		s.Err = ""
+	}
+	if diffRes := diff(tr.spans, want, 0); diffRes != "" {
+		t.Errorf("spans not as expected: (-got,+want)\n%s", diffRes)
+	}
+}
+
+func TestWorkflowTracer(t *testing.T) {
+	parent := testWorkflow()
+	child := testWorkflow()
+	child.parent = parent
+	if _, ok := child.tracer().(noopTracer); !ok {
+		t.Errorf("got tracer %T, want noopTracer", child.tracer())
+	}
+	tr := &testTracer{}
+	parent.Tracer = tr
+	if got := child.tracer(); got != tr {
+		t.Errorf("got tracer %v, want the parent's", got)
+	}
+}
+
+func TestInstanceSpan(t *testing.T) {
+	var is *instanceSpan
+	is.AddEvent("nil span")
+	is.End(nil)
+
+	tr := &testTracer{}
+	_, span := tr.Start(context.Background(), "daisy.instance", nil)
+	is = &instanceSpan{Span: span}
+	is.AddEvent("running")
+	is.End(errors.New("first"))
+	is.End(errors.New("second"))
+
+	got := span.(*testSpan)
+	if got.Ended != 1 || got.Err != "first" {
+		t.Errorf("span ended %d times with error %q, want once with %q", got.Ended, got.Err, "first")
+	}
+	if diffRes := diff(got.Events, []string{"running"}, 0); diffRes != "" {
+		t.Errorf("events not as expected: (-got,+want)\n%s", diffRes)
+	}
+}
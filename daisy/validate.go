@@ -32,6 +32,51 @@ func checkName(s string) bool {
 	return len(s) < 64 && rfc1035Rgx.MatchString(s)
 }
 
+var (
+	tagKeyRgx   = regexp.MustCompile(`^tagKeys/[0-9]+$`)
+	tagValueRgx = regexp.MustCompile(`^tagValues/[0-9]+$`)
+)
+
+// GCE label keys and values are lowercase letters, digits, underscores and
+// dashes, at most 63 characters, keys must start with a letter.
+var (
+	labelKeyRgx   = regexp.MustCompile(`^[\p{Ll}\p{Lo}][\p{Ll}\p{Lo}\p{N}_-]{0,62}$`)
+	labelValueRgx = regexp.MustCompile(`^[\p{Ll}\p{Lo}\p{N}_-]{0,63}$`)
+)
+
+const maxLabels = 64
+
+// validateResourceManagerTags checks that tags maps tag keys in the
+// "tagKeys/<id>" format to tag values in the "tagValues/<id>" format.
+func validateResourceManagerTags(pre string, tags map[string]string) (errs DError) {
+	for k, v := range tags {
+		if !tagKeyRgx.MatchString(k) {
+			errs = addErrs(errs, Errf("%s: bad ResourceManagerTags key %q, must be tagKeys/<id>", pre, k))
+		}
+		if !tagValueRgx.MatchString(v) {
+			errs = addErrs(errs, Errf("%s: bad ResourceManagerTags value %q for key %q, must be tagValues/<id>", pre, v, k))
+		}
+	}
+	return
+}
+
+// validateLabels checks labels against GCE's label constraints, so bad
+// labels fail validation rather than the insert call.
+func validateLabels(pre string, labels map[string]string) (errs DError) {
+	if len(labels) > maxLabels {
+		errs = addErrs(errs, Errf("%s: %d Labels set, at most %d are allowed", pre, len(labels), maxLabels))
+	}
+	for k, v := range labels {
+		if !labelKeyRgx.MatchString(k) {
+			errs = addErrs(errs, Errf("%s: bad Labels key %q, must start with a lowercase letter and contain at most 63 lowercase letters, digits, underscores and dashes", pre, k))
+		}
+		if !labelValueRgx.MatchString(v) {
+			errs = addErrs(errs, Errf("%s: bad Labels value %q for key %q, must contain at most 63 lowercase letters, digits, underscores and dashes", pre, v, k))
+		}
+	}
+	return
+}
+
 func (w *Workflow) validateRequiredFields() DError {
 	if w.Name == "" {
 		return Errf("must provide workflow field 'Name'")
@@ -42,6 +87,19 @@ func (w *Workflow) validateRequiredFields() DError {
 	if w.Project == "" {
 		return Errf("must provide workflow field 'Project'")
 	}
+	if len(w.Steps) == 0 {
+		return Errf("must provide at least one step in workflow field 'Steps'")
+	}
+	for name := range w.Steps {
+		if name == "" {
+			return Errf("no name defined for Step %q", name)
+		}
+	}
+	if w.Zone != "" && w.Region != "" && getRegionFromZone(w.Zone) != w.Region {
+		return Errf("zone %q is not in region %q", w.Zone, w.Region)
+	}
+	// API lookups go last so that offline validation reports the static
+	// problems above before giving up on a lookup it can't make.
 	if exists, err := projectExists(w.ComputeClient, w.Project); err != nil {
 		return Errf("bad project lookup: %q, error: %v", w.Project, err)
 	} else if !exists {
@@ -54,23 +112,68 @@ func (w *Workflow) validateRequiredFields() DError {
 			return Errf("zone does not exist: %q", w.Zone)
 		}
 	}
-	if len(w.Steps) == 0 {
-		return Errf("must provide at least one step in workflow field 'Steps'")
-	}
-	for name := range w.Steps {
-		if name == "" {
-			return Errf("no name defined for Step %q", name)
-		}
-	}
 	return nil
 }
 
 func (w *Workflow) validate(ctx context.Context) DError {
-	return w.validateDAG(ctx)
+	if err := w.validateDAG(ctx); err != nil {
+		return err
+	}
+	// An included workflow shares its parent's registries, so the users of
+	// its instances aren't all known yet, the parent checks its steps.
+	if w.parent != nil && w.parent.instances == w.instances {
+		return nil
+	}
+	return w.validateFirstRunningUsers()
+}
+
+// validateFirstRunningUsers checks that the steps that depend on a
+// CreateInstances step with ReadyWhen FIRST_RUNNING only use its first
+// instance, the only one that is sure to exist when they start.
+func (w *Workflow) validateFirstRunningUsers() DError {
+	var errs DError
+	seen := map[string]bool{}
+	var check func(*Workflow)
+	check = func(cw *Workflow) {
+		for _, s := range cw.Steps {
+			if s.IncludeWorkflow != nil && s.IncludeWorkflow.Workflow != nil {
+				check(s.IncludeWorkflow.Workflow)
+			}
+			if s.ReadyWhen != readyWhenFirstRunning || s.CreateInstances == nil {
+				continue
+			}
+			ibs := s.CreateInstances.instanceBases()
+			for i := 1; i < len(ibs); i++ {
+				res, ok := w.instances.get(ibs[i].daisyName)
+				if !ok {
+					continue
+				}
+				for _, u := range res.users {
+					key := u.name + "/" + ibs[i].daisyName
+					if seen[key] || !u.nestedDepends(s) {
+						continue
+					}
+					seen[key] = true
+					errs = addErrs(errs, Errf("step %q uses instance %q of step %q, which may not exist when it starts: with ReadyWhen %q, dependents may only use the step's first instance", u.name, ibs[i].daisyName, s.name, readyWhenFirstRunning))
+				}
+			}
+		}
+	}
+	check(w)
+	return errs
 }
 
 // Step through the step DAG, calling each step's validate().
 func (w *Workflow) validateDAG(ctx context.Context) DError {
+	if err := w.validateDependencies(); err != nil {
+		return err
+	}
+	return w.traverseDAG(func(s *Step) DError { return s.validate(ctx) })
+}
+
+// validateDependencies checks Dependencies for missing steps and cycles, and
+// removes duplicate entries.
+func (w *Workflow) validateDependencies() DError {
 	// Sanitation.
 	for s, deps := range w.Dependencies {
 		// Check for missing steps.
@@ -99,7 +202,7 @@ func (w *Workflow) validateDAG(ctx context.Context) DError {
 			return Errf("cyclic dependency on step %v", s)
 		}
 	}
-	return w.traverseDAG(func(s *Step) DError { return s.validate(ctx) })
+	return nil
 }
 
 func (w *Workflow) validateVarsSubbed() DError {
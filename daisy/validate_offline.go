@@ -0,0 +1,149 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"google.golang.org/api/option"
+)
+
+const (
+	liveValidationError = "LiveValidationRequired"
+
+	offlineProject = "daisy-offline-project"
+	offlineZone    = "daisy-offline-zone-a"
+	offlineGCSPath = "gs://daisy-offline-bucket"
+)
+
+// errOfflineAPICall is returned for every Compute or Storage API request made
+// during offline validation.
+var errOfflineAPICall = errors.New("API call not available during offline validation")
+
+type offlineTransport struct{}
+
+func (offlineTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, errOfflineAPICall
+}
+
+// ValidateWorkflowFile reads the workflow file at path and runs all of the
+// workflow validation that doesn't need GCP credentials, such as step types,
+// required fields and references between steps. It keeps going after a step
+// fails validation so all errors are reported at once.
+//
+// staticErrs are problems with the workflow itself. liveErrs are checks that
+// could not be completed offline because they need the Compute or Storage
+// APIs, e.g. whether a source image exists; Validate performs those.
+//
+// Project, Zone, GCSPath and required Vars that are unset in the file are
+// given placeholder values; a placeholder Zone is in Region if one is set.
+func ValidateWorkflowFile(path string) (staticErrs, liveErrs DError) {
+	ctx := context.Background()
+	w, err := NewFromFile(path)
+	if err != nil {
+		return ToDError(err), nil
+	}
+	if derr := w.populateOfflineClients(ctx); derr != nil {
+		return derr, nil
+	}
+	w.DisableGCSLogging()
+	w.DisableCloudLogging()
+	w.DisableStdoutLogging()
+	w.Project = strOr(w.Project, offlineProject)
+	if w.Region != "" {
+		// Zone selection needs the API, any zone in Region will do here.
+		w.Zone = strOr(w.Zone, w.Region+"-a")
+	}
+	w.Zone = strOr(w.Zone, offlineZone)
+	w.GCSPath = strOr(w.GCSPath, offlineGCSPath)
+	for k, v := range w.Vars {
+		if v.Required && v.Value == "" {
+			v.Value = strings.ToLower(strings.Replace(k, "_", "-", -1))
+			w.Vars[k] = v
+		}
+	}
+
+	var mx sync.Mutex
+	collect := func(e DError) {
+		mx.Lock()
+		defer mx.Unlock()
+		for _, err := range e.errors() {
+			if strings.Contains(err.Error(), errOfflineAPICall.Error()) {
+				liveErrs = addErrs(liveErrs, typedErr(liveValidationError, "check requires API access", err))
+			} else {
+				staticErrs = addErrs(staticErrs, err)
+			}
+		}
+	}
+
+	// A failed API lookup here only prevents the remaining lookups, so
+	// population and step validation can still go ahead.
+	if err := w.validateRequiredFields(); err != nil {
+		collect(err)
+		if staticErrs != nil {
+			return staticErrs, liveErrs
+		}
+	}
+	if err := w.populateFields(ctx); err != nil {
+		collect(err)
+		return staticErrs, liveErrs
+	}
+	// Steps that fail to populate are left out of step validation.
+	unpopulated := map[string]bool{}
+	for name, s := range w.Steps {
+		s.name = name
+		s.w = w
+		if err := w.populateStep(ctx, s); err != nil {
+			collect(Errf("error populating step %q: %v", name, err))
+			unpopulated[name] = true
+		}
+	}
+	if err := w.validateDependencies(); err != nil {
+		collect(err)
+		return staticErrs, liveErrs
+	}
+	w.traverseDAG(func(s *Step) DError {
+		if unpopulated[s.name] {
+			return nil
+		}
+		if err := s.validateStep(ctx); err != nil {
+			for _, e := range err.errors() {
+				collect(Errf("step %q validation error: %v", s.name, e))
+			}
+		}
+		return nil
+	})
+	return staticErrs, liveErrs
+}
+
+// populateOfflineClients sets API clients whose requests all fail with
+// errOfflineAPICall without leaving the process.
+func (w *Workflow) populateOfflineClients(ctx context.Context) DError {
+	hc := &http.Client{Transport: offlineTransport{}}
+	var err error
+	if w.ComputeClient, err = compute.NewClient(ctx, option.WithHTTPClient(hc)); err != nil {
+		return newErr("failed to create offline compute client", err)
+	}
+	if w.StorageClient, err = storage.NewClient(ctx, option.WithHTTPClient(hc)); err != nil {
+		return newErr("failed to create offline storage client", err)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateWorkflowFile(t *testing.T) {
+	staticErrs, liveErrs := ValidateWorkflowFile("./test_data/test_offline.wf.json")
+
+	var got []string
+	if staticErrs != nil {
+		for _, err := range staticErrs.errors() {
+			got = append(got, err.Error())
+		}
+	}
+	sort.Strings(got)
+	want := []string{
+		`error populating step "no-type": no step type defined`,
+		`step "create-instance" validation error: cannot create instance: disk "missing" not found in registry`,
+		`step "create-instance" validation error: missing reference for disk "missing"`,
+	}
+	assert.Equal(t, want, got)
+
+	if assert.NotNil(t, liveErrs) {
+		for _, errType := range liveErrs.errorsType() {
+			assert.Equal(t, liveValidationError, errType)
+		}
+		for _, err := range liveErrs.errors() {
+			assert.Contains(t, err.Error(), errOfflineAPICall.Error())
+		}
+	}
+}
+
+func TestValidateWorkflowFileReadError(t *testing.T) {
+	staticErrs, liveErrs := ValidateWorkflowFile("./test_data/notexist.wf.json")
+	assert.NotNil(t, staticErrs)
+	assert.Nil(t, liveErrs)
+}
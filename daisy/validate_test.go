@@ -16,7 +16,9 @@ package daisy
 
 import (
 	"context"
+	"fmt"
 	"reflect"
+	"strings"
 	"sync"
 	"testing"
 )
@@ -44,6 +46,52 @@ func TestCheckName(t *testing.T) {
 	}
 }
 
+func TestValidateResourceManagerTags(t *testing.T) {
+	tests := []struct {
+		desc      string
+		tags      map[string]string
+		shouldErr bool
+	}{
+		{"nil case", nil, false},
+		{"normal case", map[string]string{"tagKeys/123": "tagValues/456", "tagKeys/789": "tagValues/12"}, false},
+		{"bad key case", map[string]string{"123/env": "tagValues/456"}, true},
+		{"bad value case", map[string]string{"tagKeys/123": "prod"}, true},
+		{"empty value case", map[string]string{"tagKeys/123": ""}, true},
+	}
+	for _, tt := range tests {
+		if err := validateResourceManagerTags("pre", tt.tags); (err != nil) != tt.shouldErr {
+			t.Errorf("%s: got error %v, want error %t", tt.desc, err, tt.shouldErr)
+		}
+	}
+}
+
+func TestValidateLabels(t *testing.T) {
+	tooMany := map[string]string{}
+	for i := 0; i <= maxLabels; i++ {
+		tooMany[fmt.Sprintf("key%d", i)] = "v"
+	}
+	tests := []struct {
+		desc      string
+		labels    map[string]string
+		shouldErr bool
+	}{
+		{"nil case", nil, false},
+		{"normal case", map[string]string{"team": "images", "cost_center": "12-34", "empty": ""}, false},
+		{"uppercase key case", map[string]string{"Team": "images"}, true},
+		{"key starting with digit case", map[string]string{"1team": "images"}, true},
+		{"empty key case", map[string]string{"": "images"}, true},
+		{"long key case", map[string]string{strings.Repeat("k", 64): "v"}, true},
+		{"bad value case", map[string]string{"team": "images/prod"}, true},
+		{"long value case", map[string]string{"team": strings.Repeat("v", 64)}, true},
+		{"too many labels case", tooMany, true},
+	}
+	for _, tt := range tests {
+		if err := validateLabels("pre", tt.labels); (err != nil) != tt.shouldErr {
+			t.Errorf("%s: got error %v, want error %t", tt.desc, err, tt.shouldErr)
+		}
+	}
+}
+
 func TestValidateVarsSubbed(t *testing.T) {
 	w := testWorkflow()
 
@@ -64,6 +112,30 @@ func TestValidateVarsSubbed(t *testing.T) {
 	//}
 }
 
+func TestValidateVarsSubbedMetadata(t *testing.T) {
+	newWorkflow := func(md map[string]string) *Workflow {
+		w := testWorkflow()
+		w.Vars = map[string]Var{"build_id": {Value: "42"}}
+		w.Steps = map[string]*Step{"s0": {CreateInstances: &CreateInstances{Instances: []*Instance{{Metadata: md}}}}}
+		return w
+	}
+
+	w := newWorkflow(map[string]string{"build-id": "${build_id}", "bucket": "${BUCKET}"})
+	if err := w.populate(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	md := w.Steps["s0"].CreateInstances.Instances[0].Metadata
+	if md["build-id"] != "42" || md["bucket"] != w.bucket {
+		t.Errorf("metadata vars not substituted: %v", md)
+	}
+
+	w = newWorkflow(map[string]string{"build-id": "${build_number}"})
+	want := `Unresolved var "${build_number}" found in "${build_number}"`
+	if err := w.populate(context.Background()); err == nil || err.Error() != want {
+		t.Errorf("did not get expected error, got: %v, want: %q", err, want)
+	}
+}
+
 func TestValidateWorkflow(t *testing.T) {
 	ctx := context.Background()
 	// Normal, good validation.
@@ -99,6 +171,42 @@ func TestValidateWorkflow(t *testing.T) {
 	}
 }
 
+func TestValidateFirstRunningUsers(t *testing.T) {
+	w := testWorkflow()
+	ci := &CreateInstances{Instances: []*Instance{
+		{InstanceBase: InstanceBase{Resource: Resource{daisyName: "i0"}}},
+		{InstanceBase: InstanceBase{Resource: Resource{daisyName: "i1"}}},
+	}}
+	creator := &Step{name: "creator", w: w, ReadyWhen: readyWhenFirstRunning, CreateInstances: ci}
+	firstUser := &Step{name: "firstUser", w: w}
+	secondUser := &Step{name: "secondUser", w: w}
+	w.Steps = map[string]*Step{"creator": creator, "firstUser": firstUser, "secondUser": secondUser}
+	w.Dependencies = map[string][]string{"firstUser": {"creator"}, "secondUser": {"creator"}}
+	w.instances.m = map[string]*Resource{
+		"i0": {creator: creator, users: []*Step{firstUser}},
+		"i1": {creator: creator, users: []*Step{secondUser}},
+	}
+
+	tests := []struct {
+		desc      string
+		readyWhen string
+		wantErr   bool
+	}{
+		{"first running case", readyWhenFirstRunning, true},
+		{"all running case", "", false},
+	}
+
+	for _, tt := range tests {
+		creator.ReadyWhen = tt.readyWhen
+		err := w.validateFirstRunningUsers()
+		if tt.wantErr != (err != nil) {
+			t.Errorf("%s: wantErr %t, got %v", tt.desc, tt.wantErr, err)
+		} else if err != nil && !strings.Contains(err.Error(), `"secondUser"`) {
+			t.Errorf("%s: error should be about secondUser, got %v", tt.desc, err)
+		}
+	}
+}
+
 func TestValidateDAG(t *testing.T) {
 	ctx := context.Background()
 	calls := make([]int, 5)
@@ -20,7 +20,9 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
@@ -33,28 +35,64 @@ import (
 	"cloud.google.com/go/logging"
 	"cloud.google.com/go/storage"
 	"github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
+	"gopkg.in/yaml.v2"
 )
 
 const defaultTimeout = "10m"
 
-func daisyBkt(ctx context.Context, client *storage.Client, project string) (string, DError) {
+// defaultComputeBasePath is the Compute API endpoint used when the workflow
+// has no compute client to read it from.
+const defaultComputeBasePath = "https://compute.googleapis.com/compute/v1/projects/"
+
+// Version is the Daisy version reported in the default user-agent. Release
+// builds set it with -ldflags "-X <package path>.Version=<version>".
+var Version = "dev"
+
+// daisyBkt returns the name of the default Daisy bucket for project, creating
+// it in location if it doesn't exist. An existing bucket is used wherever it
+// is. An empty location leaves it to GCS. The bool reports whether the bucket
+// was created.
+func daisyBkt(ctx context.Context, client *storage.Client, project, location string) (string, bool, DError) {
 	dBkt := strings.Replace(project, ":", "-", -1) + "-daisy-bkt"
 	it := client.Buckets(ctx, project)
 	for bucketAttrs, err := it.Next(); err != iterator.Done; bucketAttrs, err = it.Next() {
 		if err != nil {
-			return "", typedErr(apiError, "failed to iterate buckets", err)
+			return "", false, typedErr(apiError, "failed to iterate buckets", err)
 		}
 		if bucketAttrs.Name == dBkt {
-			return dBkt, nil
+			return dBkt, false, nil
 		}
 	}
 
-	if err := client.Bucket(dBkt).Create(ctx, project, nil); err != nil {
-		return "", typedErr(apiError, "failed to create bucket", err)
+	var attrs *storage.BucketAttrs
+	if location != "" {
+		attrs = &storage.BucketAttrs{Location: location}
+	}
+	if err := client.Bucket(dBkt).Create(ctx, project, attrs); err != nil {
+		return "", false, typedErr(apiError, "failed to create bucket", err)
 	}
-	return dBkt, nil
+	return dBkt, true, nil
+}
+
+// bucketLocation returns the location to create the scratch bucket in, empty
+// to leave it to GCS if Zone isn't in a valid region.
+func (w *Workflow) bucketLocation() string {
+	if w.BucketLocation != "" {
+		return strings.ToLower(w.BucketLocation)
+	}
+	if r := getRegionFromZone(w.Zone); validBucketLocation(r) {
+		return r
+	}
+	return ""
+}
+
+// validBucketLocation reports whether l is a region or a multi-region.
+func validBucketLocation(l string) bool {
+	l = strings.ToLower(l)
+	return regionRgx.MatchString(l) || strIn(l, multiRegionStorageLocations)
 }
 
 // TimeRecord is a type with info of a step execution time
@@ -64,6 +102,111 @@ type TimeRecord struct {
 	EndTime   time.Time
 }
 
+// InstanceTimeRecord holds when an instance reached each stage of its life in
+// the workflow. Stages it didn't reach are zero.
+type InstanceTimeRecord struct {
+	Instance string
+	// CreateStartTime is when the insert request was made.
+	CreateStartTime time.Time
+	// CreatedTime is when the instance was created.
+	CreatedTime time.Time
+	// FirstSerialOutputTime is when serial port output was first read.
+	FirstSerialOutputTime time.Time
+	// StoppedTime is when the instance was first seen stopped.
+	StoppedTime time.Time
+}
+
+// CreateDuration is how long creating the instance took.
+func (r InstanceTimeRecord) CreateDuration() time.Duration {
+	return sinceStage(r.CreateStartTime, r.CreatedTime)
+}
+
+// TimeToFirstSerialOutput is how long after its creation the instance wrote
+// serial port output.
+func (r InstanceTimeRecord) TimeToFirstSerialOutput() time.Duration {
+	return sinceStage(r.CreatedTime, r.FirstSerialOutputTime)
+}
+
+// TimeToStop is how long after its creation the instance stopped.
+func (r InstanceTimeRecord) TimeToStop() time.Duration {
+	return sinceStage(r.CreatedTime, r.StoppedTime)
+}
+
+// sinceStage is end - start, or 0 if either stage wasn't reached.
+func sinceStage(start, end time.Time) time.Duration {
+	if start.IsZero() || end.IsZero() {
+		return 0
+	}
+	return end.Sub(start)
+}
+
+func (r InstanceTimeRecord) String() string {
+	var parts []string
+	if d := r.CreateDuration(); d != 0 {
+		parts = append(parts, fmt.Sprintf("created in %s", d.Round(time.Millisecond)))
+	}
+	if d := r.TimeToFirstSerialOutput(); d != 0 {
+		parts = append(parts, fmt.Sprintf("first serial output after %s", d.Round(time.Millisecond)))
+	}
+	if d := r.TimeToStop(); d != 0 {
+		parts = append(parts, fmt.Sprintf("stopped after %s", d.Round(time.Millisecond)))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// ResourceNameRecord maps the name a workflow uses to reference a resource to
+// the name the resource was created with.
+type ResourceNameRecord struct {
+	Type     string
+	Name     string
+	RealName string
+	// Link is the partial URL of the resource, e.g.
+	// "projects/p/global/images/i".
+	Link string
+	// SelfLink is the full URL of the resource, e.g.
+	// "https://compute.googleapis.com/compute/v1/projects/p/global/images/i".
+	SelfLink string
+}
+
+// StepErrorRecord is the error of a step with ContinueOnError set, which
+// failed without failing the workflow.
+type StepErrorRecord struct {
+	// Step is the step name, prefixed with the names of the included and sub
+	// workflows it is in as for step time records.
+	Step  string
+	Error DError
+}
+
+// SerialLogRecord summarizes the streaming of an instance's serial port
+// output once it has ended.
+type SerialLogRecord struct {
+	Instance string
+	Port     int64
+	// Bytes is the amount of output written to the log.
+	Bytes int64
+	// Link is the GCS object holding the log, unset when SerialLogWriter is.
+	Link string
+	// Reason streaming ended, one of the SerialLogEnd* constants.
+	Reason string
+	// Tail is the last lines of output, set when Reason is
+	// SerialLogEndTimedOut.
+	Tail string
+}
+
+// Reasons serial port output streaming ended.
+const (
+	SerialLogEndStopped   = "stopped"
+	SerialLogEndCanceled  = "canceled"
+	SerialLogEndError     = "error"
+	SerialLogEndTruncated = "truncated"
+	SerialLogEndSuspended = "suspended"
+	// SerialLogEndPreempted is GCE preempting the instance.
+	SerialLogEndPreempted = "preempted"
+	// SerialLogEndTimedOut is the workflow timing out while the instance was
+	// still RUNNING.
+	SerialLogEndTimedOut = "timed out"
+)
+
 // Var is a type with a flexible JSON representation. A Var can be represented
 // by either a string, or by this struct definition. A Var that is represented
 // by a string will unmarshal into the struct: {Value: <string>, Required: false, Description: ""}.
@@ -98,12 +241,70 @@ type Workflow struct {
 	Project string `json:",omitempty"`
 	// Zone to run in.
 	Zone string `json:",omitempty"`
+	// ImageProject is the project that image and image family partial URLs
+	// without a project, e.g. "global/images/family/debian-11", are looked up
+	// in. If unset they are looked up in the project of the resource using
+	// them, falling back to the public image project for the family.
+	ImageProject string `json:",omitempty"`
+	// Region to pick Zone from when Zone is unset.
+	Region string `json:",omitempty"`
 	// GCS Path to use for scratch data and write logs/results to.
 	GCSPath string `json:",omitempty"`
+	// Directory within GCSPath to use for scratch data, defaults to a unique
+	// "daisy-<name>-<datetime>-<id>" directory. If set, each run still uses
+	// its own "daisy-<name>-<datetime>-<id>" directory within it.
+	ScratchDir string `json:",omitempty"`
+	// Directories within the scratch directory to upload sources to and to
+	// write logs and outputs to, default to "sources", "logs" and "outs".
+	SourcesDir string `json:",omitempty"`
+	LogsDir    string `json:",omitempty"`
+	OutsDir    string `json:",omitempty"`
+	// Directory within GCSPath to upload sources to instead of SourcesDir,
+	// so runs setting the same SharedSourcesDir skip uploading unchanged
+	// files. It is shared by the runs and never cleaned up.
+	SharedSourcesDir string `json:",omitempty"`
+	// Delete the scratch directory, e.g. uploaded sources, once the workflow
+	// completes successfully. Outputs and SharedSourcesDir are always kept.
+	CleanupScratchOnSuccess bool `json:",omitempty"`
+	// Also delete logs, other than the workflow log itself, when
+	// CleanupScratchOnSuccess is set.
+	CleanupLogsOnSuccess bool `json:",omitempty"`
+	// Delete this run's scratch data, including outputs and the workflow
+	// log, and then the scratch bucket if it's empty, once the workflow
+	// completes successfully. Only applies when GCSPath is unset and Daisy
+	// created the bucket for this run.
+	DeleteCreatedBucketOnSuccess bool `json:",omitempty"`
+	// BucketLocation is where the scratch bucket is created when GCSPath is
+	// unset and the bucket doesn't exist yet, a region or one of the
+	// multi-regions "asia", "eu" and "us". Defaults to the region of Zone.
+	BucketLocation string `json:",omitempty"`
+	// CleanupTimeout limits how long Run waits for cleanup to finish, e.g.
+	// "10m". Deletions still in progress after this are abandoned. Unset means
+	// no limit. Must be parsable by https://golang.org/pkg/time/#ParseDuration.
+	CleanupTimeout string `json:",omitempty"`
+	cleanupTimeout time.Duration
+	// AsyncCleanup makes Run return as soon as the steps finish and clean up
+	// in the background. Use WaitForCleanup to wait for it to finish.
+	AsyncCleanup bool `json:",omitempty"`
+	cleanupDone  chan struct{}
 	// Path to OAuth credentials file.
 	OAuthPath string `json:",omitempty"`
+	// ImpersonateServiceAccount is the email of a service account that all
+	// API requests are made as, using tokens generated with the OAuthPath or
+	// default credentials. Those need the Service Account Token Creator role
+	// on it, or on the first of ImpersonateDelegates.
+	ImpersonateServiceAccount string `json:",omitempty"`
+	// ImpersonateDelegates are the emails of the service accounts in the
+	// delegation chain to ImpersonateServiceAccount, each with the Service
+	// Account Token Creator role on the next.
+	ImpersonateDelegates []string `json:",omitempty"`
 	// Sources used by this workflow, map of destination to source.
 	Sources map[string]string `json:",omitempty"`
+	// SourceUploadChunkSize is the size, in bytes, of the chunks local
+	// sources are uploaded to GCS in. Each chunk is retried on transient
+	// errors, so a failed upload resumes instead of starting over. Must be a
+	// multiple of 256 KiB, defaults to 8 MiB.
+	SourceUploadChunkSize int `json:",omitempty"`
 	// Vars defines workflow variables, substitution is done at Workflow run time.
 	Vars  map[string]Var   `json:",omitempty"`
 	Steps map[string]*Step `json:",omitempty"`
@@ -113,12 +314,82 @@ type Workflow struct {
 	// Must be parsable by https://golang.org/pkg/time/#ParseDuration.
 	DefaultTimeout string `json:",omitempty"`
 	defaultTimeout time.Duration
+	// MaxSerialBytes limits how much serial port output is streamed from each
+	// port of an instance; output past it is not logged. Unlimited if 0.
+	MaxSerialBytes int64 `json:",omitempty"`
+	// FailOnMaxSerialBytes fails WaitForInstancesSignal steps watching the
+	// serial port output of an instance once it exceeds MaxSerialBytes.
+	FailOnMaxSerialBytes bool `json:",omitempty"`
+	// MaxSerialPollQPS limits the combined rate of serial port output requests
+	// made for all instances in the workflow, including its sub workflows, to
+	// avoid hitting project API rate limits. Unlimited if 0.
+	MaxSerialPollQPS float64 `json:",omitempty"`
+	// MaxSerialLogWriters limits how many serial port log GCS objects the
+	// workflow, including its sub workflows, writes at once, to bound the
+	// memory and connections used when streaming many instances' serial port
+	// output. Unlimited if 0.
+	MaxSerialLogWriters int `json:",omitempty"`
+	// MaxConcurrentOperations limits how many resource create and delete
+	// API calls the workflow, including its sub workflows, makes at once
+	// across all of its steps, e.g. to stay within quota. Unlimited if 0.
+	MaxConcurrentOperations int `json:",omitempty"`
+	// SerialLogContentType is the content type of serial port log objects
+	// written to GCS, defaults to "text/plain".
+	SerialLogContentType string `json:",omitempty"`
+	// SerialLogMetadata is custom metadata, e.g. a build ID, set on serial port
+	// log objects written to GCS. Instances can add to or override it with
+	// their own SerialLogMetadata.
+	SerialLogMetadata map[string]string `json:",omitempty"`
+	// SerialLogStorageClass is the storage class of serial port log objects
+	// written to GCS, e.g. "COLDLINE" as they are rarely read. Defaults to
+	// LogsStorageClass.
+	SerialLogStorageClass string `json:",omitempty"`
+	// SerialLogTimestamps prefixes each line of the serial port logs with the
+	// time Daisy received it, to correlate them with other logs.
+	SerialLogTimestamps bool `json:",omitempty"`
+	// CombinedSerialLog also writes the serial port output of all instances,
+	// including those of included and sub workflows, to a single
+	// combined-serial.log object in the logs directory. Lines are interleaved
+	// as they're read, each prefixed with its instance and port. It's
+	// uploaded periodically rather than on every write.
+	CombinedSerialLog bool `json:",omitempty"`
+	// LogsStorageClass is the storage class, e.g. "NEARLINE", of the log
+	// objects Daisy writes to GCS: daisy.log, serial port logs and
+	// screenshots. The bucket's default storage class is used if unset.
+	LogsStorageClass string `json:",omitempty"`
+	// LogsObjectMetadata is custom metadata set on the log objects Daisy
+	// writes to GCS, e.g. to match bucket lifecycle rules.
+	LogsObjectMetadata map[string]string `json:",omitempty"`
+	// OutsStorageClass is the storage class set on the objects in the outs
+	// path once the workflow finishes.
+	OutsStorageClass string `json:",omitempty"`
+	// OutsObjectMetadata is custom metadata set on the objects in the outs
+	// path once the workflow finishes.
+	OutsObjectMetadata map[string]string `json:",omitempty"`
+	// ObjectPredefinedACL is a predefined ACL, e.g. "projectPrivate", set on
+	// the objects Daisy writes: logs, sources and, once the workflow
+	// finishes, outs. GCS rejects object ACLs in buckets with uniform
+	// bucket-level access, so the workflow fails to populate if the bucket
+	// has it. Mutually exclusive with ObjectACLRules.
+	ObjectPredefinedACL string `json:",omitempty"`
+	// ObjectACLRules are ACL rules, e.g. READER for "group-team@example.com",
+	// set on the same objects instead of the bucket's default object ACL.
+	ObjectACLRules []*storage.ACLRule `json:",omitempty"`
+	// SensitiveMetadataKeys are instance metadata keys whose values are
+	// redacted wherever Daisy logs or prints metadata, e.g. tokens. They also
+	// apply to included and sub workflows.
+	SensitiveMetadataKeys []string `json:",omitempty"`
+	// Proxy is the HTTP(S) proxy set in the metadata of every instance the
+	// workflow creates, including those of included and sub workflows, which
+	// use their parent's. The instances' startup scripts have to apply it.
+	Proxy *ProxyConfig `json:",omitempty"`
 
 	// Working fields.
 	autovars              map[string]string
 	workflowDir           string
 	parent                *Workflow
 	bucket                string
+	bucketCreated         bool
 	scratchPath           string
 	sourcesPath           string
 	logsPath              string
@@ -128,30 +399,98 @@ type Workflow struct {
 	gcsLoggingDisabled    bool
 	cloudLoggingDisabled  bool
 	stdoutLoggingDisabled bool
+	serialStdout          io.Writer
+	serialStdoutMx        sync.Mutex
+	combinedSerialLog     bytes.Buffer
+	combinedSerialLogObj  *serialLogObject
+	combinedSerialLogMx   sync.Mutex
+	stopCombinedSerialLog func()
+	unsetWarningsHandler  func()
 	id                    string
+	runIDValue            string
+	runIDOnce             sync.Once
 	Logger                Logger `json:"-"`
 	cleanupHooks          []func() DError
 	cleanupHooksMx        sync.Mutex
+	preDeleteHooks        map[string][]preDeleteHook
+	preDeleteHooksMx      sync.Mutex
+	postCleanupHooks      []postCleanupHook
+	postCleanupHooksMx    sync.Mutex
+	imageDeprecations     []imageDeprecation
+	imageDeprecationsMx   sync.Mutex
 	recordTimeMx          sync.Mutex
 	stepWait              sync.WaitGroup
 	logProcessHook        func(string) string
 
+	// SerialLogWriter, if set, is used in place of GCS as the destination of
+	// instance serial port output.
+	SerialLogWriter SerialLogWriterFactory `json:"-"`
+
+	// IsRetriable, if set, decides which failed Compute API calls are attempted
+	// again, e.g. to treat quota errors as fatal, in place of
+	// compute.IsRetriable. Only applies to a ComputeClient from
+	// compute.NewClient.
+	IsRetriable func(error) bool `json:"-"`
+
+	// Tracer, if set, traces the workflow's execution, e.g. to send spans to
+	// an OpenTelemetry backend. Included and sub workflows use their
+	// parent's. Only the insert calls of CreateInstances and CreateDisks are
+	// traced among the API calls. Nothing is traced by default.
+	Tracer Tracer `json:"-"`
+
+	// PreInsertInstance, if set, is called with each instance a CreateInstances
+	// step is about to insert, an *Instance or *InstanceBeta, once Daisy has
+	// assembled it. It is called concurrently for the instances of a step.
+	// It may change the instance, e.g. to set API fields Daisy
+	// has no option for yet; an error fails the step. This is an advanced
+	// escape hatch: changing fields Daisy also manages, e.g. disks, metadata
+	// or network interfaces, is unsupported and may break the workflow.
+	PreInsertInstance func(step string, i InstanceInterface) error `json:"-"`
+
+	// Policy, if set, restricts the resources the workflow may create. It is
+	// set by the program running the workflow rather than the workflow file,
+	// and applies to included and sub workflows too.
+	Policy *Policy `json:"-"`
+
+	// ApprovalRequested, if set, is called with the step name and Message
+	// when a WaitForApproval step starts waiting, e.g. to notify an operator.
+	// The step continues once Approve is called or its GCSObject exists. It
+	// is also called when a WaitForInstancesSignal step pauses for instances
+	// with KeepRunningOnSuccess.
+	ApprovalRequested func(step, message string) `json:"-"`
+
+	// OnProgress, if set, is called each time a step's StepProgress changes,
+	// e.g. to update a progress bar. Only the top-level workflow's is called,
+	// with the progress of included and sub workflow steps too. Calls are
+	// made one at a time.
+	OnProgress func(StepProgress) `json:"-"`
+
+	// UserAgent is sent with Compute, Storage and Cloud Logging API requests,
+	// defaults to "daisy/<Version>".
+	UserAgent string `json:",omitempty"`
+
 	// Optional compute endpoint override.stepWait
-	ComputeEndpoint    string          `json:",omitempty"`
+	ComputeEndpoint string `json:",omitempty"`
+	// StorageEndpoint optionally overrides the Cloud Storage API endpoint,
+	// e.g. to test against an emulator or to use a private endpoint.
+	StorageEndpoint    string          `json:",omitempty"`
 	ComputeClient      compute.Client  `json:"-"`
 	StorageClient      *storage.Client `json:"-"`
 	cloudLoggingClient *logging.Client
 
 	// Resource registries.
 	disks           *diskRegistry
+	addresses       *addressRegistry
 	forwardingRules *forwardingRuleRegistry
 	firewallRules   *firewallRuleRegistry
 	images          *imageRegistry
 	machineImages   *machineImageRegistry
+	snapshots       *snapshotRegistry
 	instances       *instanceRegistry
 	networks        *networkRegistry
 	subnetworks     *subnetworkRegistry
 	targetInstances *targetInstanceRegistry
+	instanceGroups  *instanceGroupRegistry
 	objects         *objectRegistry
 
 	// Cache of resources
@@ -160,6 +499,7 @@ type Workflow struct {
 	diskCache           twoDResourceCache
 	subnetworkCache     twoDResourceCache
 	targetInstanceCache twoDResourceCache
+	addressCache        twoDResourceCache
 	forwardingRuleCache twoDResourceCache
 	imageCache          oneDResourceCache
 	imageFamilyCache    oneDResourceCache
@@ -169,14 +509,68 @@ type Workflow struct {
 	zonesCache          oneDResourceCache
 	regionsCache        oneDResourceCache
 	licenseCache        oneDResourceCache
+	cpuCache            cpuCache
 
 	stepTimeRecords             []TimeRecord
+	resourceNameRecords         []ResourceNameRecord
+	resourceNameRecordsMx       sync.Mutex
+	serialLogRecords            []SerialLogRecord
+	serialLogRecordsMx          sync.Mutex
+	instanceTimeRecords         []*InstanceTimeRecord
+	instanceTimeRecordsMx       sync.Mutex
+	stepErrorRecords            []StepErrorRecord
+	stepErrorRecordsMx          sync.Mutex
+	operationWarningRecords     []OperationWarningRecord
+	operationWarningRecordsMx   sync.Mutex
+	stepProgress                []*StepProgress
+	stepProgressMx              sync.Mutex
+	progressReportMx            sync.Mutex
+	serialPollLimiter           *rateLimiter
+	serialPollLimiterOnce       sync.Once
+	serialLogWriters            chan struct{}
+	serialLogWritersOnce        sync.Once
 	serialControlOutputValues   map[string]string
 	serialControlOutputValuesMx sync.Mutex
+	approvals                   map[string]chan struct{}
+	approvalsMx                 sync.Mutex
+	conditions                  map[string]Condition
+	conditionsMx                sync.Mutex
+	cancelReason                *CancelReason
+	cancelMx                    sync.Mutex
+	// genNames maps the full names genName was called with to the names it
+	// generated, kept in genNamesTaken.
+	genNames      map[string]string
+	genNamesTaken map[string]bool
+	genNamesMx    sync.Mutex
 	//Forces cleanup on error of all resources, including those marked with NoCleanup
 	ForceCleanupOnError bool
+	// AdoptExistingResources allows resources using ExactName that already
+	// exist to be adopted by the workflow instead of being recreated, provided
+	// their configuration matches. Currently supported for disks and instances.
+	AdoptExistingResources bool `json:",omitempty"`
+	// NoCleanupNameTemplate, if set, names the NoCleanup resources that set
+	// neither RealName nor ExactName in place of the generated name, e.g.
+	// "{name}-${BUILD_ID}" so kept images can be found by name. "{name}" is
+	// replaced with the resource's name in the workflow and vars are
+	// substituted as usual; the result is lower-cased and must be a valid GCE
+	// resource name.
+	NoCleanupNameTemplate string `json:",omitempty"`
 	// forceCleanup is set to true when resources should be forced clean, even when NoCleanup is set to true
 	forceCleanup bool
+	// failed is set when the workflow fails, before it cleans up.
+	failed bool
+}
+
+// SerialLogWriterFactory returns the writer that serial port output of the
+// given instance and port is streamed to. The writer is closed once the
+// instance stops or the workflow ends.
+type SerialLogWriterFactory func(instance string, port int64) (io.WriteCloser, error)
+
+// EnableSerialOutputStdout echoes instance serial port output to stdout, in
+// addition to GCS or SerialLogWriter, for interactive runs. Output is written
+// a line at a time, prefixed with the instance name.
+func (w *Workflow) EnableSerialOutputStdout() {
+	w.serialStdout = os.Stdout
 }
 
 //DisableCloudLogging disables logging to Cloud Logging for this workflow.
@@ -212,8 +606,24 @@ func (w *Workflow) AddSerialConsoleOutputValue(k, v string) {
 	w.serialControlOutputValuesMx.Unlock()
 }
 
+// lookupSerialConsoleOutputValue gets a serial-output value by key from this
+// workflow or, if it isn't set here, from its parents.
+func (w *Workflow) lookupSerialConsoleOutputValue(k string) (string, bool) {
+	for ; w != nil; w = w.parent {
+		w.serialControlOutputValuesMx.Lock()
+		v, ok := w.serialControlOutputValues[k]
+		w.serialControlOutputValuesMx.Unlock()
+		if ok {
+			return v, true
+		}
+	}
+	return "", false
+}
+
 // GetSerialConsoleOutputValue gets an serial-output value by key.
 func (w *Workflow) GetSerialConsoleOutputValue(k string) string {
+	w.serialControlOutputValuesMx.Lock()
+	defer w.serialControlOutputValuesMx.Unlock()
 	return w.serialControlOutputValues[k]
 }
 
@@ -223,6 +633,66 @@ func (w *Workflow) addCleanupHook(hook func() DError) {
 	w.cleanupHooksMx.Unlock()
 }
 
+// PreDeleteHook is called during cleanup before a resource created by the
+// workflow is deleted, e.g. to capture data from it. name is the resource's
+// name in the workflow and link its partial URL. ctx is done once the hook's
+// timeout has passed.
+type PreDeleteHook func(ctx context.Context, name, link string) error
+
+type preDeleteHook struct {
+	fn      PreDeleteHook
+	timeout time.Duration
+}
+
+// AddPreDeleteHook registers a hook to call during cleanup before each
+// resource of resourceType, such as "instance" or "disk", is deleted. Cleanup
+// waits at most timeout for the hook. Errors from the hook are logged and
+// don't prevent the deletion. The hook is also called for the resources of
+// sub-workflows.
+func (w *Workflow) AddPreDeleteHook(resourceType string, timeout time.Duration, hook PreDeleteHook) {
+	w.preDeleteHooksMx.Lock()
+	defer w.preDeleteHooksMx.Unlock()
+	if w.preDeleteHooks == nil {
+		w.preDeleteHooks = map[string][]preDeleteHook{}
+	}
+	w.preDeleteHooks[resourceType] = append(w.preDeleteHooks[resourceType], preDeleteHook{hook, timeout})
+}
+
+// runPreDeleteHooks calls the pre-delete hooks that the workflow and its
+// parents have for resourceType, one at a time.
+func (w *Workflow) runPreDeleteHooks(resourceType, name string, res *Resource) {
+	for p := w; p != nil; p = p.parent {
+		p.preDeleteHooksMx.Lock()
+		hooks := append([]preDeleteHook(nil), p.preDeleteHooks[resourceType]...)
+		p.preDeleteHooksMx.Unlock()
+		for _, h := range hooks {
+			if err := h.run(name, res.link); err != nil {
+				w.LogWorkflowInfo("Error returned from pre-delete hook for %s %q: %v", resourceType, name, err)
+			}
+		}
+	}
+}
+
+func (h preDeleteHook) run(name, link string) error {
+	return runHook(h.timeout, func(ctx context.Context) error { return h.fn(ctx, name, link) })
+}
+
+// runHook calls f, returning an error if it doesn't return within timeout.
+// ctx is done once timeout has passed.
+func runHook(timeout time.Duration, f func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	// Buffered so a hook that ignores ctx doesn't leak a blocked goroutine.
+	errc := make(chan error, 1)
+	go func() { errc <- f(ctx) }()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("hook did not return within %s", timeout)
+	}
+}
+
 // SetLogProcessHook sets a hook function to process log string
 func (w *Workflow) SetLogProcessHook(hook func(string) string) {
 	w.logProcessHook = hook
@@ -246,6 +716,11 @@ func (w *Workflow) Validate(ctx context.Context) DError {
 	}
 
 	w.LogWorkflowInfo("Validating workflow")
+	if err := validateBktWritable(ctx, w.StorageClient, w.bucket, fmt.Sprintf("daisy-validate-%s", w.id)); err != nil {
+		w.LogWorkflowInfo("Error validating workflow: %v", err)
+		close(w.Cancel)
+		return Errf("cannot use GCSPath %q: %v", w.GCSPath, err)
+	}
 	if err := w.validate(ctx); err != nil {
 		w.LogWorkflowInfo("Error validating workflow: %v", err)
 		close(w.Cancel)
@@ -280,11 +755,12 @@ func (w *Workflow) RunWithModifiers(
 	if postValidateWorkflowModifier != nil {
 		postValidateWorkflowModifier(w)
 	}
-	defer w.cleanup()
 	defer func() {
 		if err != nil {
 			w.forceCleanup = w.ForceCleanupOnError
+			w.failed = true
 		}
+		w.cleanupAfterRun(ctx, err)
 	}()
 
 	w.LogWorkflowInfo("Workflow Project: %s", w.Project)
@@ -298,16 +774,49 @@ func (w *Workflow) RunWithModifiers(
 		close(w.Cancel)
 		return err
 	}
+	if w.CombinedSerialLog {
+		w.stopCombinedSerialLog = w.streamCombinedSerialLog(ctx)
+	}
+	// Left unset if another workflow sharing the client is running.
+	w.unsetWarningsHandler = compute.SetOperationWarningsHandler(unwrapComputeClient(w.ComputeClient), w.recordOperationWarnings)
 	w.LogWorkflowInfo("Running workflow")
 	defer func() {
 		for k, v := range w.serialControlOutputValues {
 			w.LogWorkflowInfo("Serial-output value -> %v:%v", k, v)
 		}
+		for _, r := range w.GetResourceNameRecords() {
+			w.LogWorkflowInfo("Created %s -> %v:%v", r.Type, r.Name, r.RealName)
+		}
+		for _, r := range w.stepTimeRecords {
+			w.LogWorkflowInfo("Step time -> %s:%s", r.Name, r.EndTime.Sub(r.StartTime).Round(time.Millisecond))
+		}
+		for _, r := range w.GetStepErrorRecords() {
+			w.LogWorkflowInfo("Step error (ContinueOnError) -> %s: %v", r.Step, r.Error)
+		}
+		for _, r := range w.GetOperationWarningRecords() {
+			w.LogWorkflowInfo("Operation warning -> %s %s: %s: %s", r.OperationType, r.Target, r.Code, r.Message)
+		}
+		for _, r := range w.GetInstanceTimeRecords() {
+			if str := r.String(); str != "" {
+				w.LogWorkflowInfo("Instance time -> %s: %s", r.Instance, str)
+			}
+		}
 	}()
 	if err = w.run(ctx); err != nil {
 		w.LogWorkflowInfo("Error running workflow: %v", err)
 		return err
 	}
+	// Steps not yet started when the workflow is canceled are skipped.
+	if w.isCanceled() {
+		w.setCancelReason(CancelReason{Kind: CancelReasonUser})
+		err = typedErrf(workflowCanceledError, "workflow %q was canceled: %s", w.Name, w.CancelReason())
+		w.LogWorkflowInfo("Error running workflow: %v", err)
+		return err
+	}
+	if err = w.deprecatePreviousImages(); err != nil {
+		w.LogWorkflowInfo("Error running workflow: %v", err)
+		return err
+	}
 
 	return nil
 }
@@ -327,6 +836,309 @@ func (w *Workflow) GetStepTimeRecords() []TimeRecord {
 	return w.stepTimeRecords
 }
 
+// recordStepError records the error of step stepName, which has
+// ContinueOnError set, on the root workflow.
+func (w *Workflow) recordStepError(stepName string, err DError) {
+	root := w.rootWorkflow()
+	root.stepErrorRecordsMx.Lock()
+	defer root.stepErrorRecordsMx.Unlock()
+	root.stepErrorRecords = append(root.stepErrorRecords, StepErrorRecord{w.qualifiedStepName(stepName), err})
+}
+
+// GetStepErrorRecords returns the errors of the steps that failed without
+// failing the workflow as they have ContinueOnError set, in the order they
+// failed.
+func (w *Workflow) GetStepErrorRecords() []StepErrorRecord {
+	w.stepErrorRecordsMx.Lock()
+	defer w.stepErrorRecordsMx.Unlock()
+	return append([]StepErrorRecord(nil), w.stepErrorRecords...)
+}
+
+func (w *Workflow) recordResourceName(typeName string, r *Resource) {
+	if w.parent == nil {
+		w.resourceNameRecordsMx.Lock()
+		w.resourceNameRecords = append(w.resourceNameRecords, ResourceNameRecord{typeName, r.daisyName, r.RealName, r.link, w.selfLink(r.link)})
+		w.resourceNameRecordsMx.Unlock()
+	} else {
+		w.parent.recordResourceName(typeName, r)
+	}
+}
+
+// selfLink returns the full URL of the partial URL link, relative to the
+// compute endpoint the workflow uses.
+func (w *Workflow) selfLink(link string) string {
+	if link == "" {
+		return ""
+	}
+	base := defaultComputeBasePath
+	if w.ComputeClient != nil && w.ComputeClient.BasePath() != "" {
+		base = w.ComputeClient.BasePath()
+	}
+	// BasePath includes "projects/", which link starts with.
+	base = strings.TrimSuffix(strings.TrimSuffix(base, "/"), "/projects")
+	return base + "/" + link
+}
+
+// GetResourceNameRecords returns the workflow and real names of each resource
+// created by the workflow.
+func (w *Workflow) GetResourceNameRecords() []ResourceNameRecord {
+	w.resourceNameRecordsMx.Lock()
+	defer w.resourceNameRecordsMx.Unlock()
+	return append([]ResourceNameRecord(nil), w.resourceNameRecords...)
+}
+
+func (w *Workflow) recordSerialLog(r SerialLogRecord) {
+	if w.parent == nil {
+		w.serialLogRecordsMx.Lock()
+		w.serialLogRecords = append(w.serialLogRecords, r)
+		w.serialLogRecordsMx.Unlock()
+	} else {
+		w.parent.recordSerialLog(r)
+	}
+}
+
+// GetSerialLogRecords returns a record for each instance serial port whose
+// output streaming has ended.
+func (w *Workflow) GetSerialLogRecords() []SerialLogRecord {
+	w.serialLogRecordsMx.Lock()
+	defer w.serialLogRecordsMx.Unlock()
+	return append([]SerialLogRecord(nil), w.serialLogRecords...)
+}
+
+// recordInstanceTime calls f with the time record of instance, the real name
+// of an instance, to set the time of a stage.
+func (w *Workflow) recordInstanceTime(instance string, f func(r *InstanceTimeRecord)) {
+	w = w.rootWorkflow()
+	w.instanceTimeRecordsMx.Lock()
+	defer w.instanceTimeRecordsMx.Unlock()
+	for _, r := range w.instanceTimeRecords {
+		if r.Instance == instance {
+			f(r)
+			return
+		}
+	}
+	r := &InstanceTimeRecord{Instance: instance}
+	w.instanceTimeRecords = append(w.instanceTimeRecords, r)
+	f(r)
+}
+
+// GetInstanceTimeRecords returns a time record for each instance the workflow
+// created.
+func (w *Workflow) GetInstanceTimeRecords() []InstanceTimeRecord {
+	w.instanceTimeRecordsMx.Lock()
+	defer w.instanceTimeRecordsMx.Unlock()
+	var rs []InstanceTimeRecord
+	for _, r := range w.instanceTimeRecords {
+		rs = append(rs, *r)
+	}
+	return rs
+}
+
+// rootWorkflow returns the top level workflow w is included in or is a sub
+// workflow of, or w itself.
+func (w *Workflow) rootWorkflow() *Workflow {
+	for w.parent != nil {
+		w = w.parent
+	}
+	return w
+}
+
+// waitSerialPoll blocks until a serial port output request may be made
+// without exceeding MaxSerialPollQPS, which is shared with parent workflows.
+// It returns false if the workflow is canceled first.
+func (w *Workflow) waitSerialPoll() bool {
+	if w.parent != nil {
+		return w.parent.waitSerialPoll()
+	}
+	w.serialPollLimiterOnce.Do(func() {
+		w.serialPollLimiter = newRateLimiter(w.MaxSerialPollQPS)
+	})
+	return w.serialPollLimiter.wait(w.Cancel)
+}
+
+// acquireSerialLogWriter blocks until a serial port log GCS object may be
+// written without exceeding MaxSerialLogWriters, which is shared with parent
+// workflows, and returns the function releasing the writer.
+func (w *Workflow) acquireSerialLogWriter() func() {
+	if w.parent != nil {
+		return w.parent.acquireSerialLogWriter()
+	}
+	w.serialLogWritersOnce.Do(func() {
+		if w.MaxSerialLogWriters > 0 {
+			w.serialLogWriters = make(chan struct{}, w.MaxSerialLogWriters)
+		}
+	})
+	if w.serialLogWriters == nil {
+		return func() {}
+	}
+	w.serialLogWriters <- struct{}{}
+	return func() { <-w.serialLogWriters }
+}
+
+// logResourceCreation logs that the resource r is being created, identifying
+// it by both its real name and the name the workflow references it by.
+func (w *Workflow) logResourceCreation(s *Step, stepType, typeName string, r *Resource) {
+	w.LogStepInfo(s.name, stepType, "Creating %s %q (workflow reference %q).", typeName, r.RealName, r.daisyName)
+	w.recordResourceName(typeName, r)
+}
+
+// setOutsObjectAttrs rewrites the objects in the outs path with
+// OutsStorageClass, OutsObjectMetadata and the object ACL, if any are set.
+func (w *Workflow) setOutsObjectAttrs(ctx context.Context) DError {
+	if w.OutsStorageClass == "" && len(w.OutsObjectMetadata) == 0 && w.ObjectPredefinedACL == "" && len(w.ObjectACLRules) == 0 {
+		return nil
+	}
+	w.LogWorkflowInfo("Setting storage class, metadata and ACL of objects in gs://%s/%s", w.bucket, w.outsPath)
+	var errs DError
+	bkt := w.StorageClient.Bucket(w.bucket)
+	it := bkt.Objects(ctx, &storage.Query{Prefix: w.outsPath + "/"})
+	for objAttr, err := it.Next(); err != iterator.Done; objAttr, err = it.Next() {
+		if err != nil {
+			return addErrs(errs, typedErr(apiError, "failed to iterate outs objects", err))
+		}
+		metadata := map[string]string{}
+		for k, v := range objAttr.Metadata {
+			metadata[k] = v
+		}
+		for k, v := range w.OutsObjectMetadata {
+			metadata[k] = v
+		}
+		obj := bkt.Object(objAttr.Name)
+		c := obj.CopierFrom(obj)
+		c.ContentType = objAttr.ContentType
+		c.ContentEncoding = objAttr.ContentEncoding
+		c.ContentDisposition = objAttr.ContentDisposition
+		c.ContentLanguage = objAttr.ContentLanguage
+		c.CacheControl = objAttr.CacheControl
+		c.StorageClass = strOr(w.OutsStorageClass, objAttr.StorageClass)
+		c.Metadata = metadata
+		w.setObjectACL(&c.ObjectAttrs)
+		if _, err := c.Run(ctx); err != nil {
+			errs = addErrs(errs, typedErrf(apiError, "failed to rewrite outs object %q: %v", objAttr.Name, err))
+		}
+	}
+	return errs
+}
+
+// cleanupScratch deletes the objects in the scratch path, keeping outputs and,
+// unless CleanupLogsOnSuccess is set, logs.
+func (w *Workflow) cleanupScratch(ctx context.Context) DError {
+	w.LogWorkflowInfo("Deleting scratch data from gs://%s/%s", w.bucket, w.scratchPath)
+	keep := []string{w.outsPath + "/", path.Join(w.logsPath, "daisy.log")}
+	if !w.CleanupLogsOnSuccess {
+		keep = append(keep, w.logsPath+"/")
+	}
+
+	var errs DError
+	bkt := w.StorageClient.Bucket(w.bucket)
+	it := bkt.Objects(ctx, &storage.Query{Prefix: w.scratchPath + "/"})
+Loop:
+	for objAttr, err := it.Next(); err != iterator.Done; objAttr, err = it.Next() {
+		if err != nil {
+			return addErrs(errs, typedErr(apiError, "failed to iterate scratch objects for deletion", err))
+		}
+		for _, k := range keep {
+			if strings.HasPrefix(objAttr.Name, k) {
+				continue Loop
+			}
+		}
+		if err := bkt.Object(objAttr.Name).Delete(ctx); err != nil {
+			errs = addErrs(errs, typedErrf(apiError, "failed to delete scratch object %q: %v", objAttr.Name, err))
+		}
+	}
+	return errs
+}
+
+// deleteCreatedBucket deletes this run's scratch data, then the workflow's
+// bucket if nothing else is left in it. It does nothing unless Daisy created
+// the bucket for this workflow, so a user-provided bucket, or one shared
+// with a parent workflow, is never deleted. The default bucket is shared by
+// the project's runs, the data of other runs is never deleted and keeps the
+// bucket in place.
+func (w *Workflow) deleteCreatedBucket(ctx context.Context) DError {
+	if !w.bucketCreated || w.parent != nil {
+		return nil
+	}
+	w.LogWorkflowInfo("Deleting gs://%s/%s and bucket gs://%s created for this workflow", w.bucket, w.scratchPath, w.bucket)
+	// The workflow log is deleted too, stop rewriting it.
+	if l, ok := w.Logger.(*daisyLog); ok {
+		l.stopGCSLogging()
+	}
+	var errs DError
+	bkt := w.StorageClient.Bucket(w.bucket)
+	it := bkt.Objects(ctx, &storage.Query{Prefix: w.scratchPath + "/"})
+	for objAttr, err := it.Next(); err != iterator.Done; objAttr, err = it.Next() {
+		if err != nil {
+			return addErrs(errs, typedErr(apiError, "failed to iterate scratch objects for deletion", err))
+		}
+		if err := bkt.Object(objAttr.Name).Delete(ctx); err != nil {
+			errs = addErrs(errs, typedErrf(apiError, "failed to delete object %q: %v", objAttr.Name, err))
+		}
+	}
+	if errs != nil {
+		return errs
+	}
+	if _, err := bkt.Objects(ctx, nil).Next(); err != iterator.Done {
+		if err != nil {
+			return typedErr(apiError, "failed to check whether bucket is empty", err)
+		}
+		w.LogWorkflowInfo("Not deleting bucket gs://%s, it holds the data of other runs", w.bucket)
+		return nil
+	}
+	if err := bkt.Delete(ctx); err != nil {
+		return typedErrf(apiError, "failed to delete bucket %q: %v", w.bucket, err)
+	}
+	return nil
+}
+
+// cleanupAfterRun cleans up the workflow's resources, and its scratch data
+// if it succeeded, then calls the post-cleanup hooks with the result of the
+// run, runErr. With AsyncCleanup set this is done in the background.
+func (w *Workflow) cleanupAfterRun(ctx context.Context, runErr DError) {
+	succeeded := runErr == nil
+	cleanup := func(ctx context.Context) {
+		w.cleanup()
+		if oErr := w.setOutsObjectAttrs(ctx); oErr != nil {
+			w.LogWorkflowInfo("Error setting storage class and metadata of outs objects: %v", oErr)
+		}
+		// Never delete scratch data on failure so it can be used for debugging.
+		if succeeded && w.CleanupScratchOnSuccess {
+			if cErr := w.cleanupScratch(ctx); cErr != nil {
+				w.LogWorkflowInfo("Error cleaning up scratch path: %v", cErr)
+			}
+		}
+		if succeeded && w.DeleteCreatedBucketOnSuccess {
+			if bErr := w.deleteCreatedBucket(ctx); bErr != nil {
+				w.LogWorkflowInfo("Error deleting bucket: %v", bErr)
+			}
+		}
+		w.runPostCleanupHooks(runErr)
+	}
+	if !w.AsyncCleanup {
+		cleanup(ctx)
+		return
+	}
+	w.LogWorkflowInfo("Workflow %q cleaning up in the background.", w.Name)
+	w.cleanupDone = make(chan struct{})
+	go func() {
+		defer close(w.cleanupDone)
+		// The caller may cancel ctx once Run returns.
+		cleanup(context.Background())
+	}()
+}
+
+// WaitForCleanup waits for cleanup that Run started in the background,
+// because AsyncCleanup is set, to finish. It returns immediately otherwise.
+func (w *Workflow) WaitForCleanup() {
+	if w.cleanupDone != nil {
+		<-w.cleanupDone
+	}
+}
+
+// logFlushTimeout bounds how long cleanup waits for instance serial port logs
+// and screenshots to be saved before deleting the instances.
+const logFlushTimeout = 30 * time.Second
+
 func (w *Workflow) cleanup() {
 	startTime := time.Now()
 	w.LogWorkflowInfo("Workflow %q cleaning up (this may take up to 2 minutes).", w.Name)
@@ -338,7 +1150,8 @@ func (w *Workflow) cleanup() {
 	}
 
 	// Allow goroutines that are watching w.Cancel an opportunity
-	// to detect that the workflow was cancelled and to cleanup.
+	// to detect that the workflow was cancelled and to cleanup, in particular
+	// to save the instances' final serial port output before they are deleted.
 	c := make(chan struct{})
 	go func() {
 		w.stepWait.Wait()
@@ -346,19 +1159,57 @@ func (w *Workflow) cleanup() {
 	}()
 	select {
 	case <-c:
-	case <-time.After(4 * time.Second):
+	case <-time.After(logFlushTimeout):
+		w.LogWorkflowInfo("Workflow %q: instance logs were not saved within %s, they may be incomplete.", w.Name, logFlushTimeout)
+	}
+	if w.stopCombinedSerialLog != nil {
+		w.stopCombinedSerialLog()
+		w.stopCombinedSerialLog = nil
 	}
 
-	for _, hook := range w.cleanupHooks {
-		if err := hook(); err != nil {
-			w.LogWorkflowInfo("Error returned from cleanup hook: %s", err)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, hook := range w.cleanupHooks {
+			if err := hook(); err != nil {
+				w.LogWorkflowInfo("Error returned from cleanup hook: %s", err)
+			}
+		}
+	}()
+	var timeout <-chan time.Time
+	if w.cleanupTimeout > 0 {
+		timeout = time.After(w.cleanupTimeout)
+	}
+	select {
+	case <-done:
+		w.LogWorkflowInfo("Workflow %q finished cleanup.", w.Name)
+	case <-timeout:
+		w.LogWorkflowInfo("Workflow %q did not finish cleanup within CleanupTimeout (%s), some resources may not have been deleted.", w.Name, w.CleanupTimeout)
+		if w.Logger != nil {
+			w.Logger.Flush()
 		}
 	}
-	w.LogWorkflowInfo("Workflow %q finished cleanup.", w.Name)
+	if w.unsetWarningsHandler != nil {
+		w.unsetWarningsHandler()
+		w.unsetWarningsHandler = nil
+	}
 	w.recordStepTime("workflow cleanup", startTime, time.Now())
 }
 
+// maxGenNameLength is the length limit of GCE resource names.
+const maxGenNameLength = 63
+
+// genName generates the real name of the resource n, see genNameLen.
 func (w *Workflow) genName(n string) string {
+	return w.genNameLen(n, maxGenNameLength)
+}
+
+// genNameLen generates a name of at most maxLen characters from n, the names
+// of the workflow and its parents and the workflow ID. Long names are
+// truncated. The same n always gets the same name, and if a different n
+// truncates to a name already generated or used by a registered resource, a
+// random suffix is added to it.
+func (w *Workflow) genNameLen(n string, maxLen int) string {
 	name := w.Name
 	for parent := w.parent; parent != nil; parent = parent.parent {
 		name = parent.Name + "-" + name
@@ -367,14 +1218,107 @@ func (w *Workflow) genName(n string) string {
 	if n != "" {
 		prefix = fmt.Sprintf("%s-%s", n, name)
 	}
-	if len(prefix) > 57 {
-		prefix = prefix[0:56]
+	full := strings.ToLower(fmt.Sprintf("%s-%s", prefix, w.id))
+
+	root := w.rootWorkflow()
+	root.genNamesMx.Lock()
+	defer root.genNamesMx.Unlock()
+	if result, ok := root.genNames[full]; ok {
+		return result
+	}
+	if root.genNames == nil {
+		root.genNames = map[string]string{}
+		root.genNamesTaken = map[string]bool{}
+	}
+
+	// Room is left for "-" and a 5 character ID.
+	if len(prefix) > maxLen-6 {
+		prefix = prefix[0 : maxLen-7]
+	}
+	result := truncateGenName(fmt.Sprintf("%s-%s", prefix, w.id), maxLen)
+	for root.genNamesTaken[result] || root.nameRegistered(result) {
+		// Room is left for the random suffix, the ID and the hyphens around them.
+		if l := maxLen - len(w.id) - 6; l < 0 {
+			prefix = ""
+		} else if len(prefix) > l {
+			prefix = prefix[0:l]
+		}
+		result = truncateGenName(fmt.Sprintf("%s-%s-%s", prefix, randString(4), w.id), maxLen)
+	}
+	root.genNames[full] = result
+	root.genNamesTaken[result] = true
+	return result
+}
+
+// nameRegistered reports whether a resource registered in w or the workflows
+// it includes or runs already has the real name name.
+func (w *Workflow) nameRegistered(name string) bool {
+	for _, r := range w.registries() {
+		if r.hasRealName(name) {
+			return true
+		}
 	}
-	result := fmt.Sprintf("%s-%s", prefix, w.id)
-	if len(result) > 64 {
-		result = result[0:63]
+	for _, s := range w.Steps {
+		if s.IncludeWorkflow != nil && s.IncludeWorkflow.Workflow != nil && s.IncludeWorkflow.Workflow.nameRegistered(name) {
+			return true
+		}
+		if s.SubWorkflow != nil && s.SubWorkflow.Workflow != nil && s.SubWorkflow.Workflow.nameRegistered(name) {
+			return true
+		}
 	}
-	return strings.ToLower(result)
+	return false
+}
+
+// registries returns the compute resource registries of w, skipping those
+// that aren't populated.
+func (w *Workflow) registries() []*baseResourceRegistry {
+	var rs []*baseResourceRegistry
+	if w.disks != nil {
+		rs = append(rs, &w.disks.baseResourceRegistry)
+	}
+	if w.addresses != nil {
+		rs = append(rs, &w.addresses.baseResourceRegistry)
+	}
+	if w.forwardingRules != nil {
+		rs = append(rs, &w.forwardingRules.baseResourceRegistry)
+	}
+	if w.firewallRules != nil {
+		rs = append(rs, &w.firewallRules.baseResourceRegistry)
+	}
+	if w.images != nil {
+		rs = append(rs, &w.images.baseResourceRegistry)
+	}
+	if w.machineImages != nil {
+		rs = append(rs, &w.machineImages.baseResourceRegistry)
+	}
+	if w.snapshots != nil {
+		rs = append(rs, &w.snapshots.baseResourceRegistry)
+	}
+	if w.instances != nil {
+		rs = append(rs, &w.instances.baseResourceRegistry)
+	}
+	if w.networks != nil {
+		rs = append(rs, &w.networks.baseResourceRegistry)
+	}
+	if w.subnetworks != nil {
+		rs = append(rs, &w.subnetworks.baseResourceRegistry)
+	}
+	if w.targetInstances != nil {
+		rs = append(rs, &w.targetInstances.baseResourceRegistry)
+	}
+	if w.instanceGroups != nil {
+		rs = append(rs, &w.instanceGroups.baseResourceRegistry)
+	}
+	return rs
+}
+
+// truncateGenName lower-cases name and truncates it to maxLen characters,
+// removing hyphens truncation leaves at its end.
+func truncateGenName(name string, maxLen int) string {
+	if len(name) > maxLen {
+		name = strings.TrimRight(name[0:maxLen], "-")
+	}
+	return strings.ToLower(name)
 }
 
 func (w *Workflow) getSourceGCSAPIPath(s string) string {
@@ -386,7 +1330,15 @@ func (w *Workflow) PopulateClients(ctx context.Context) error {
 	// API clients instantiation.
 	var err error
 
-	computeOptions := []option.ClientOption{option.WithCredentialsFile(w.OAuthPath)}
+	if derr := w.validateEndpoints(); derr != nil {
+		return derr
+	}
+	ua := option.WithUserAgent(w.userAgent())
+	creds, derr := w.credentialsOptions(ctx)
+	if derr != nil {
+		return derr
+	}
+	computeOptions := append([]option.ClientOption{ua}, creds...)
 	if w.ComputeEndpoint != "" {
 		computeOptions = append(computeOptions, option.WithEndpoint(w.ComputeEndpoint))
 	}
@@ -397,8 +1349,14 @@ func (w *Workflow) PopulateClients(ctx context.Context) error {
 			return typedErr(apiError, "failed to create compute client", err)
 		}
 	}
+	if w.IsRetriable != nil {
+		compute.SetIsRetriable(w.ComputeClient, w.IsRetriable)
+	}
 
-	storageOptions := []option.ClientOption{option.WithCredentialsFile(w.OAuthPath)}
+	storageOptions := append([]option.ClientOption{ua}, creds...)
+	if w.StorageEndpoint != "" {
+		storageOptions = append(storageOptions, option.WithEndpoint(w.StorageEndpoint))
+	}
 	if w.StorageClient == nil {
 		w.StorageClient, err = storage.NewClient(ctx, storageOptions...)
 		if err != nil {
@@ -406,8 +1364,8 @@ func (w *Workflow) PopulateClients(ctx context.Context) error {
 		}
 	}
 
-	loggingOptions := []option.ClientOption{option.WithCredentialsFile(w.OAuthPath)}
-	if w.externalLogging && w.cloudLoggingClient == nil {
+	loggingOptions := append([]option.ClientOption{ua}, creds...)
+	if w.externalLogging && !w.cloudLoggingDisabled && w.cloudLoggingClient == nil {
 		w.cloudLoggingClient, err = logging.NewClient(ctx, w.Project, loggingOptions...)
 		if err != nil {
 			return err
@@ -416,6 +1374,36 @@ func (w *Workflow) PopulateClients(ctx context.Context) error {
 	return nil
 }
 
+// validateEndpoints checks that the API endpoint overrides are absolute
+// http(s) URLs, the clients would otherwise fail on the first request.
+func (w *Workflow) validateEndpoints() DError {
+	for _, e := range []struct{ name, endpoint string }{
+		{"ComputeEndpoint", w.ComputeEndpoint},
+		{"StorageEndpoint", w.StorageEndpoint},
+	} {
+		if e.endpoint == "" {
+			continue
+		}
+		u, err := url.Parse(e.endpoint)
+		if err != nil {
+			return Errf("bad %s %q: %v", e.name, e.endpoint, err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return Errf("bad %s %q: must be an http or https URL, e.g. \"https://compute.example.com/compute/v1/\"", e.name, e.endpoint)
+		}
+	}
+	return nil
+}
+
+// userAgent returns the user-agent to identify API requests made by the
+// workflow with.
+func (w *Workflow) userAgent() string {
+	if w.UserAgent != "" {
+		return w.UserAgent
+	}
+	return "daisy/" + Version
+}
+
 func (w *Workflow) populateStep(ctx context.Context, s *Step) DError {
 	if s.Timeout == "" {
 		s.Timeout = w.DefaultTimeout
@@ -443,6 +1431,23 @@ func (w *Workflow) populateStep(ctx context.Context, s *Step) DError {
 // - sets up logger.
 // - runs populate on each step.
 func (w *Workflow) populate(ctx context.Context) DError {
+	if err := w.populateFields(ctx); err != nil {
+		return err
+	}
+
+	// Run populate on each step.
+	for name, s := range w.Steps {
+		s.name = name
+		s.w = w
+		if err := w.populateStep(ctx, s); err != nil {
+			return Errf("error populating step %q: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// populateFields does everything populate does, except populating the steps.
+func (w *Workflow) populateFields(ctx context.Context) DError {
 	for k, v := range w.Vars {
 		if v.Required && v.Value == "" {
 			return Errf("cannot populate workflow, required var %q is unset", k)
@@ -479,24 +1484,87 @@ func (w *Workflow) populate(ctx context.Context) DError {
 		return Errf("failed to parse timeout for workflow: %v", err)
 	}
 	w.defaultTimeout = timeout
+	if w.CleanupTimeout != "" {
+		if w.cleanupTimeout, err = time.ParseDuration(w.CleanupTimeout); err != nil {
+			return Errf("failed to parse CleanupTimeout for workflow: %v", err)
+		}
+	}
+
+	if w.MaxSerialPollQPS < 0 {
+		return Errf("MaxSerialPollQPS must not be negative, got %v", w.MaxSerialPollQPS)
+	}
+	if w.MaxSerialLogWriters < 0 {
+		return Errf("MaxSerialLogWriters must not be negative, got %d", w.MaxSerialLogWriters)
+	}
+	if w.MaxConcurrentOperations < 0 {
+		return Errf("MaxConcurrentOperations must not be negative, got %d", w.MaxConcurrentOperations)
+	}
+	if w.parent == nil {
+		w.limitOperations()
+	}
+	if w.SourceUploadChunkSize < 0 || w.SourceUploadChunkSize%googleapi.MinUploadChunkSize != 0 {
+		return Errf("SourceUploadChunkSize must be a non-negative multiple of %d, got %d", googleapi.MinUploadChunkSize, w.SourceUploadChunkSize)
+	}
+	if w.LogsStorageClass != "" && !strIn(w.LogsStorageClass, validStorageClasses) {
+		return Errf("LogsStorageClass must be one of %q, got %q", validStorageClasses, w.LogsStorageClass)
+	}
+	if w.SerialLogStorageClass != "" && !strIn(w.SerialLogStorageClass, validStorageClasses) {
+		return Errf("SerialLogStorageClass must be one of %q, got %q", validStorageClasses, w.SerialLogStorageClass)
+	}
+	if w.OutsStorageClass != "" && !strIn(w.OutsStorageClass, validStorageClasses) {
+		return Errf("OutsStorageClass must be one of %q, got %q", validStorageClasses, w.OutsStorageClass)
+	}
+	if err := w.validateObjectACL(); err != nil {
+		return err
+	}
+	if w.Proxy != nil {
+		if err := w.Proxy.validate(); err != nil {
+			return err
+		}
+	}
+
+	// Pick a zone in Region if no Zone is set.
+	zoneSelected := false
+	if w.Zone == "" && w.Region != "" {
+		if err := w.selectZone(); err != nil {
+			return err
+		}
+		zoneSelected = true
+	}
 
 	// Set up GCS paths.
 	if w.GCSPath == "" {
-		dBkt, err := daisyBkt(ctx, w.StorageClient, w.Project)
+		if w.BucketLocation != "" && !validBucketLocation(w.BucketLocation) {
+			return Errf("BucketLocation must be a region or one of %q, got %q", multiRegionStorageLocations, w.BucketLocation)
+		}
+		dBkt, created, err := daisyBkt(ctx, w.StorageClient, w.Project, w.bucketLocation())
 		if err != nil {
 			return err
 		}
 		w.GCSPath = "gs://" + dBkt
+		w.bucketCreated = created
 	}
 	bkt, p, derr := splitGCSPath(w.GCSPath)
 	if err != nil {
 		return derr
 	}
 	w.bucket = bkt
-	w.scratchPath = path.Join(p, fmt.Sprintf("daisy-%s-%s-%s", w.Name, now.Format("20060102-15:04:05"), w.id))
-	w.sourcesPath = path.Join(w.scratchPath, "sources")
-	w.logsPath = path.Join(w.scratchPath, "logs")
-	w.outsPath = path.Join(w.scratchPath, "outs")
+	if err := w.checkBucketAllowsObjectACL(ctx); err != nil {
+		return err
+	}
+	runDir := fmt.Sprintf("daisy-%s-%s-%s", w.Name, now.Format("20060102-15:04:05"), w.id)
+	w.scratchPath = path.Join(p, runDir)
+	if w.ScratchDir != "" {
+		// Runs sharing a ScratchDir each get their own directory in it, which
+		// is all cleanupScratch deletes.
+		w.scratchPath = path.Join(p, w.ScratchDir, runDir)
+	}
+	w.sourcesPath = path.Join(w.scratchPath, strOr(w.SourcesDir, "sources"))
+	if w.SharedSourcesDir != "" {
+		w.sourcesPath = path.Join(p, w.SharedSourcesDir)
+	}
+	w.logsPath = path.Join(w.scratchPath, strOr(w.LogsDir, "logs"))
+	w.outsPath = path.Join(w.scratchPath, strOr(w.OutsDir, "outs"))
 
 	// Generate more autovars from workflow fields. Run second round of var substitution.
 	w.autovars["NAME"] = w.Name
@@ -504,6 +1572,7 @@ func (w *Workflow) populate(ctx context.Context) DError {
 	w.autovars["ZONE"] = w.Zone
 	w.autovars["PROJECT"] = w.Project
 	w.autovars["GCSPATH"] = w.GCSPath
+	w.autovars["BUCKET"] = w.bucket
 	w.autovars["SCRATCHPATH"] = fmt.Sprintf("gs://%s/%s", w.bucket, w.scratchPath)
 	w.autovars["SOURCESPATH"] = fmt.Sprintf("gs://%s/%s", w.bucket, w.sourcesPath)
 	w.autovars["LOGSPATH"] = fmt.Sprintf("gs://%s/%s", w.bucket, w.logsPath)
@@ -528,14 +1597,8 @@ func (w *Workflow) populate(ctx context.Context) DError {
 	if w.Logger == nil {
 		w.createLogger(ctx)
 	}
-
-	// Run populate on each step.
-	for name, s := range w.Steps {
-		s.name = name
-		s.w = w
-		if err := w.populateStep(ctx, s); err != nil {
-			return Errf("error populating step %q: %v", name, err)
-		}
+	if zoneSelected {
+		w.LogWorkflowInfo("Selected zone %q in region %q.", w.Zone, w.Region)
 	}
 	return nil
 }
@@ -564,14 +1627,17 @@ func (w *Workflow) includeWorkflow(iw *Workflow) {
 	iw.Cancel = w.Cancel
 	iw.parent = w
 	iw.disks = w.disks
+	iw.addresses = w.addresses
 	iw.forwardingRules = w.forwardingRules
 	iw.firewallRules = w.firewallRules
 	iw.images = w.images
 	iw.machineImages = w.machineImages
+	iw.snapshots = w.snapshots
 	iw.instances = w.instances
 	iw.networks = w.networks
 	iw.subnetworks = w.subnetworks
 	iw.targetInstances = w.targetInstances
+	iw.instanceGroups = w.instanceGroups
 	iw.objects = w.objects
 }
 
@@ -637,6 +1703,7 @@ func (w *Workflow) Print(ctx context.Context) {
 		fmt.Println("Error running populate:", err)
 	}
 
+	w.redactStepsMetadata()
 	b, err := json.MarshalIndent(w, "", "  ")
 	if err != nil {
 		fmt.Println("Error marshalling workflow for printing:", err)
@@ -645,9 +1712,12 @@ func (w *Workflow) Print(ctx context.Context) {
 }
 
 func (w *Workflow) run(ctx context.Context) DError {
-	return w.traverseDAG(func(s *Step) DError {
+	ctx, span := w.tracer().Start(ctx, "daisy.workflow", map[string]string{"daisy.workflow.name": w.Name, "daisy.workflow.id": w.id})
+	err := w.traverseDAG(func(s *Step) DError {
 		return w.runStep(ctx, s)
 	})
+	span.End(err)
+	return err
 }
 
 func (w *Workflow) runStep(ctx context.Context, s *Step) DError {
@@ -664,9 +1734,14 @@ func (w *Workflow) runStep(ctx context.Context, s *Step) DError {
 
 	select {
 	case err := <-e:
+		if err != nil {
+			w.setCancelReason(CancelReason{Kind: CancelReasonStepFailure, Step: s.name, Message: err.Error()})
+		}
 		return err
 	case <-timeout:
-		return s.getTimeoutError()
+		err := s.getTimeoutError()
+		w.setCancelReason(CancelReason{Kind: CancelReasonStepTimeout, Step: s.name, Message: err.Error()})
+		return err
 	}
 }
 
@@ -677,16 +1752,23 @@ func (w *Workflow) traverseDAG(f func(*Step) DError) DError {
 	// running = the currently running steps.
 	// start = map of steps' start channels/semaphores.
 	// done = map of steps' done channels for signaling step completion.
+	// ready = map of running steps' ready channels, for steps whose
+	// dependents may start before they finish, see Step.ReadyWhen.
 	waiting := map[string][]string{}
 	var running []string
 	start := map[string]chan DError{}
 	done := map[string]chan DError{}
+	ready := map[string]chan struct{}{}
 
 	// Setup: channels, copy dependencies.
-	for name := range w.Steps {
+	for name, s := range w.Steps {
 		waiting[name] = w.Dependencies[name]
 		start[name] = make(chan DError)
 		done[name] = make(chan DError)
+		if s.ReadyWhen == readyWhenFirstRunning {
+			s.ready = make(chan struct{}, 1)
+			ready[name] = s.ready
+		}
 	}
 	// Setup: goroutine for each step. Each waits to be notified to start.
 	for name, s := range w.Steps {
@@ -726,8 +1808,8 @@ func (w *Workflow) traverseDAG(f func(*Step) DError) DError {
 			continue
 		}
 
-		// Get next finished step. Return the step error if it erred.
-		finished, err := stepsListen(running, done)
+		// Get next finished or ready step. Return the step error if it erred.
+		finished, isReady, err := stepsListen(running, done, ready)
 		if err != nil {
 			return err
 		}
@@ -737,6 +1819,13 @@ func (w *Workflow) traverseDAG(f func(*Step) DError) DError {
 			waiting[name] = filter(deps, finished)
 		}
 
+		// A ready step keeps running, only its dependents are started.
+		delete(ready, finished)
+		if isReady {
+			w.LogWorkflowInfo("Step %q is ready, starting the steps that depend on it.", finished)
+			continue
+		}
+
 		// Remove finished from currently running list.
 		running = filter(running, finished)
 	}
@@ -766,21 +1855,27 @@ func New() *Workflow {
 
 	// Resource registries and cleanup.
 	w.disks = newDiskRegistry(w)
+	w.addresses = newAddressRegistry(w)
 	w.forwardingRules = newForwardingRuleRegistry(w)
 	w.firewallRules = newFirewallRuleRegistry(w)
 	w.images = newImageRegistry(w)
 	w.machineImages = newMachineImageRegistry(w)
+	w.snapshots = newSnapshotRegistry(w)
 	w.instances = newInstanceRegistry(w)
 	w.networks = newNetworkRegistry(w)
 	w.subnetworks = newSubnetworkRegistry(w)
 	w.objects = newObjectRegistry(w)
 	w.targetInstances = newTargetInstanceRegistry(w)
+	w.instanceGroups = newInstanceGroupRegistry(w)
 	w.addCleanupHook(func() DError {
 		w.instances.cleanup() // instances need to be done before disks/networks
+		w.instanceGroups.cleanup()
 		w.images.cleanup()
 		w.machineImages.cleanup()
+		w.snapshots.cleanup()
 		w.disks.cleanup()
 		w.forwardingRules.cleanup()
+		w.addresses.cleanup() // addresses need to be done after instances/forwarding rules using them
 		w.targetInstances.cleanup()
 		w.firewallRules.cleanup()
 		w.subnetworks.cleanup()
@@ -802,6 +1897,26 @@ func NewFromFile(file string) (*Workflow, error) {
 	return w, nil
 }
 
+// NewFromBytes unmarshals a JSON or YAML workflow held in memory, for callers
+// that don't have the workflow in a file. Relative Sources, IncludeWorkflow
+// and SubWorkflow paths are resolved against dir, or against the current
+// directory if dir is empty.
+func NewFromBytes(data []byte, dir string) (*Workflow, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, newErr("failed to get absolute path of workflow directory", err)
+	}
+	// YAML is a superset of JSON, but JSON is unmarshaled directly so syntax
+	// errors point at the offending line.
+	isYAML := !bytes.HasPrefix(bytes.TrimSpace(data), []byte("{"))
+
+	w := New()
+	if err := parseWorkflow("workflow", data, dir, isYAML, w); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
 // JSONError turns an error from json.Unmarshal and returns a more user
 // friendly error.
 func JSONError(file string, data []byte, err error) error {
@@ -836,11 +1951,27 @@ func readWorkflow(file string, w *Workflow) DError {
 		return newErr("failed to read workflow file", err)
 	}
 
-	w.workflowDir, err = filepath.Abs(filepath.Dir(file))
+	dir, err := filepath.Abs(filepath.Dir(file))
 	if err != nil {
 		return newErr("failed to get absolute path of workflow file", err)
 	}
 
+	return parseWorkflow(file, data, dir, isYAMLFile(file), w)
+}
+
+// parseWorkflow unmarshals the JSON or YAML workflow data into w. Relative
+// paths in the workflow are resolved against dir, which must be absolute.
+// file names the workflow in errors.
+func parseWorkflow(file string, data []byte, dir string, isYAML bool, w *Workflow) DError {
+	w.workflowDir = dir
+
+	if isYAML {
+		var err error
+		if data, err = yamlToJSON(data); err != nil {
+			return newErr("failed to unmarshal workflow file", fmt.Errorf("%s: %v", file, err))
+		}
+	}
+
 	if err := json.Unmarshal(data, &w); err != nil {
 		return newErr("failed to unmarshal workflow file", JSONError(file, data, err))
 	}
@@ -857,19 +1988,79 @@ func readWorkflow(file string, w *Workflow) DError {
 	return nil
 }
 
-// stepsListen returns the first step that finishes/errs.
-func stepsListen(names []string, chans map[string]chan DError) (string, DError) {
+// isYAMLFile reports whether a workflow file is YAML rather than JSON, based
+// on its extension.
+func isYAMLFile(file string) bool {
+	ext := strings.ToLower(filepath.Ext(file))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// yamlToJSON converts a YAML document to JSON so that it unmarshals into the
+// same structs as a JSON workflow. Anchors and aliases are expanded.
+func yamlToJSON(data []byte) ([]byte, error) {
+	var v interface{}
+	if err := yaml.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	v, err := jsonCompatible(v)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// jsonCompatible replaces the map[interface{}]interface{} values produced by
+// the YAML decoder with maps that encoding/json can marshal.
+func jsonCompatible(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := map[string]interface{}{}
+		for k, val := range v {
+			ks, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("map key %v is not a string", k)
+			}
+			var err error
+			if m[ks], err = jsonCompatible(val); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case []interface{}:
+		for i, val := range v {
+			var err error
+			if v[i], err = jsonCompatible(val); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return v, nil
+}
+
+// stepsListen returns the first step that finishes/errs, or that signals it
+// is ready on its ready channel.
+func stepsListen(names []string, chans map[string]chan DError, ready map[string]chan struct{}) (string, bool, DError) {
 	cases := make([]reflect.SelectCase, len(names))
 	for i, name := range names {
 		cases[i] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(chans[name])}
 	}
+	readyNames := []string{}
+	for _, name := range names {
+		if c, ok := ready[name]; ok {
+			cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(c)})
+			readyNames = append(readyNames, name)
+		}
+	}
 	caseIndex, value, recvOk := reflect.Select(cases)
+	if caseIndex >= len(names) {
+		return readyNames[caseIndex-len(names)], true, nil
+	}
 	name := names[caseIndex]
 	if recvOk {
 		// recvOk -> a step failed, return the error.
-		return name, value.Interface().(DError)
+		return name, false, value.Interface().(DError)
 	}
-	return name, nil
+	return name, false, nil
 }
 
 // IterateWorkflowSteps iterates over all workflow steps, including included
@@ -880,6 +2071,12 @@ func (w *Workflow) IterateWorkflowSteps(cb func(step *Step)) {
 			//recurse into included workflow
 			step.IncludeWorkflow.Workflow.IterateWorkflowSteps(cb)
 		}
+		if step.MountAndRun != nil && step.MountAndRun.include != nil {
+			step.MountAndRun.include.Workflow.IterateWorkflowSteps(cb)
+		}
+		if step.TestImageBoots != nil && step.TestImageBoots.include != nil {
+			step.TestImageBoots.include.Workflow.IterateWorkflowSteps(cb)
+		}
 		cb(step)
 	}
 }
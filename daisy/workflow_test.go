@@ -17,17 +17,22 @@ package daisy
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"reflect"
 	"regexp"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -76,7 +81,7 @@ func TestDaisyBkt(t *testing.T) {
 		t.Fatal(err)
 	}
 	project := "foo-project"
-	got, err := daisyBkt(context.Background(), client, project)
+	got, created, err := daisyBkt(context.Background(), client, project, "us-central1")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -84,9 +89,12 @@ func TestDaisyBkt(t *testing.T) {
 	if got != project+"-daisy-bkt" {
 		t.Errorf("bucket does not match, got: %q, want: %q", got, want)
 	}
+	if !created {
+		t.Errorf("expected bucket %q to be reported as created", got)
+	}
 
 	project = "bar-project"
-	got, err = daisyBkt(context.Background(), client, project)
+	got, created, err = daisyBkt(context.Background(), client, project, "us-central1")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -94,6 +102,9 @@ func TestDaisyBkt(t *testing.T) {
 	if got != project+"-daisy-bkt" {
 		t.Errorf("bucket does not match, got: %q, want: %q", got, want)
 	}
+	if created {
+		t.Errorf("expected existing bucket %q not to be reported as created", got)
+	}
 }
 
 func TestCleanup(t *testing.T) {
@@ -125,6 +136,27 @@ func TestCleanup(t *testing.T) {
 	}
 }
 
+func TestCleanupWaitsForInstanceLogs(t *testing.T) {
+	w := testWorkflow()
+	included := testWorkflow()
+	included.parent = w
+	var flushed int32
+	for _, lw := range []*Workflow{w, included} {
+		goLogInstance(lw, func() {
+			<-w.Cancel
+			time.Sleep(10 * time.Millisecond)
+			atomic.AddInt32(&flushed, 1)
+		})
+	}
+	w.addCleanupHook(func() DError {
+		if got := atomic.LoadInt32(&flushed); got != 2 {
+			t.Errorf("instances deleted before their logs were saved, %d of 2 saved", got)
+		}
+		return nil
+	})
+	w.cleanup()
+}
+
 func TestGenName(t *testing.T) {
 	tests := []struct{ name, wfName, wfID, want string }{
 		{"name", "wfname", "123456789", "name-wfname-123456789"},
@@ -145,6 +177,75 @@ func TestGenName(t *testing.T) {
 	}
 }
 
+func TestGenNameLen(t *testing.T) {
+	tests := []struct {
+		name, wfID string
+		maxLen     int
+		want       string
+	}{
+		{"name", "abcde", 63, "name-wf-abcde"},
+		{"Long-Name", "abcde", 16, "long-name-abcde"},
+		{"longer-name", "abcde", 18, "longer-name-abcde"},
+		{"n", "abcdefghij-x", 16, "n-wf-abcdefghij"},
+	}
+	for _, tt := range tests {
+		w := &Workflow{Name: "wf", id: tt.wfID}
+		if got := w.genNameLen(tt.name, tt.maxLen); got != tt.want {
+			t.Errorf("genNameLen(%q, %d) = %q, want %q", tt.name, tt.maxLen, got, tt.want)
+		}
+	}
+}
+
+func TestGenNameCollision(t *testing.T) {
+	parent := &Workflow{Name: "wf", id: "abcde"}
+	w := &Workflow{Name: "sub", id: "abcde", parent: parent}
+	long := strings.Repeat("a", 60)
+
+	first := w.genName(long + "-1")
+	if got := w.genName(long + "-1"); got != first {
+		t.Errorf("genName not stable for the same name, got %q then %q", first, got)
+	}
+	// Both names truncate to first, the second gets a random suffix instead.
+	second := w.genName(long + "-2")
+	if second == first {
+		t.Errorf("genName generated %q for two different names", first)
+	}
+	// Collisions are checked across the workflow and its parents.
+	third := parent.genName(long + "-sub")
+	for _, got := range []string{first, second, third} {
+		if len(got) > maxGenNameLength || !rfc1035Rgx.MatchString(got) {
+			t.Errorf("bad generated name %q", got)
+		}
+	}
+	if third == first || third == second {
+		t.Errorf("parent generated name %q already generated for its sub workflow", third)
+	}
+	if !strings.HasSuffix(second, "-abcde") || !strings.HasSuffix(third, "-abcde") {
+		t.Errorf("generated names %q and %q don't end with the workflow ID", second, third)
+	}
+}
+
+func TestGenNameRegistered(t *testing.T) {
+	w := testWorkflow()
+	sub := testWorkflow()
+	sub.parent = w
+	w.Steps = map[string]*Step{"sub": {name: "sub", w: w, SubWorkflow: &SubWorkflow{Workflow: sub}}}
+	// An existing disk and an instance of the sub workflow already use the
+	// names genName would generate.
+	w.disks.m["d"] = &Resource{RealName: "d-test-wf-abcdef"}
+	sub.instances.m["i"] = &Resource{link: "projects/p/zones/z/instances/i-test-wf-abcdef"}
+
+	for _, n := range []string{"d", "i"} {
+		got := w.genName(n)
+		if got == n+"-test-wf-abcdef" || !strings.HasPrefix(got, n+"-test-wf-") || !strings.HasSuffix(got, "-abcdef") {
+			t.Errorf("genName(%q) = %q, want a name with a random suffix", n, got)
+		}
+	}
+	if got, want := w.genName("other"), "other-test-wf-abcdef"; got != want {
+		t.Errorf("genName(%q) = %q, want %q", "other", got, want)
+	}
+}
+
 func TestGetSourceGCSAPIPath(t *testing.T) {
 	w := testWorkflow()
 	w.sourcesPath = "my/sources"
@@ -199,6 +300,90 @@ func TestNewFromFileError(t *testing.T) {
 	}
 }
 
+func TestNewFromFileYAML(t *testing.T) {
+	want, err := NewFromFile("./test_data/test_sub.wf.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := NewFromFile("./test_data/test_sub.wf.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantJSON, _ := json.Marshal(want)
+	gotJSON, _ := json.Marshal(got)
+	if string(gotJSON) != string(wantJSON) {
+		t.Errorf("YAML workflow does not match JSON workflow:\ngot:  %s\nwant: %s", gotJSON, wantJSON)
+	}
+}
+
+func TestNewFromBytes(t *testing.T) {
+	for _, file := range []string{"./test_data/test_sub.wf.json", "./test_data/test_sub.wf.yaml"} {
+		want, err := NewFromFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := NewFromBytes(data, "test_data")
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", file, err)
+		}
+
+		if got.workflowDir != want.workflowDir {
+			t.Errorf("%s: got workflowDir %q, want %q", file, got.workflowDir, want.workflowDir)
+		}
+		wantJSON, _ := json.Marshal(want)
+		gotJSON, _ := json.Marshal(got)
+		if string(gotJSON) != string(wantJSON) {
+			t.Errorf("%s: workflow from bytes does not match workflow from file:\ngot:  %s\nwant: %s", file, gotJSON, wantJSON)
+		}
+	}
+}
+
+func TestNewFromBytesError(t *testing.T) {
+	tests := []struct{ data, error string }{
+		{`{"test": value}`, "workflow: JSON syntax error in line 1: invalid character 'v' looking for beginning of value \n{\"test\": value}\n         ^"},
+		{"Steps:\n  s1: [\n", "workflow: yaml: line 2: did not find expected node content"},
+	}
+
+	for i, tt := range tests {
+		if _, err := NewFromBytes([]byte(tt.data), ""); err == nil {
+			t.Errorf("expected error, got nil for test %d", i+1)
+		} else if err.Error() != tt.error {
+			t.Errorf("did not get expected error from NewFromBytes():\ngot: %q\nwant: %q", err.Error(), tt.error)
+		}
+	}
+}
+
+func TestNewFromFileYAMLError(t *testing.T) {
+	td, err := ioutil.TempDir(os.TempDir(), "")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(td)
+	tf := filepath.Join(td, "test.wf.yml")
+
+	tests := []struct{ data, error string }{
+		{"Steps:\n  s1: [\n", tf + ": yaml: line 2: did not find expected node content"},
+		{"Vars:\n  1: {}\n", tf + ": map key 1 is not a string"},
+	}
+
+	for i, tt := range tests {
+		if err := ioutil.WriteFile(tf, []byte(tt.data), 0600); err != nil {
+			t.Fatalf("error creating yaml file: %v", err)
+		}
+
+		if _, err := NewFromFile(tf); err == nil {
+			t.Errorf("expected error, got nil for test %d", i+1)
+		} else if err.Error() != tt.error {
+			t.Errorf("did not get expected error from NewFromFile():\ngot: %q\nwant: %q", err.Error(), tt.error)
+		}
+	}
+}
+
 func TestNewFromFile(t *testing.T) {
 	got, derr := NewFromFile("./test_data/test.wf.json")
 	if derr != nil {
@@ -225,6 +410,7 @@ func TestNewFromFile(t *testing.T) {
 	want.Name = "some-name"
 	want.Project = "some-project"
 	want.Zone = "us-central1-a"
+	want.Region = "us-central1"
 	want.GCSPath = "gs://some-bucket/images"
 	want.OAuthPath = filepath.Join(wd, "test_data", "somefile")
 	want.Sources = map[string]string{}
@@ -557,6 +743,8 @@ func TestPopulate(t *testing.T) {
 		"test-var":  {Value: "wf-zone-this-should-populate-wf-name"},
 	}
 	want.autovars = got.autovars
+	want.genNames = got.genNames
+	want.genNamesTaken = got.genNamesTaken
 	want.bucket = "bar-project-daisy-bkt"
 	want.scratchPath = got.scratchPath
 	want.sourcesPath = fmt.Sprintf("%s/sources", got.scratchPath)
@@ -594,6 +782,398 @@ func TestPopulate(t *testing.T) {
 	}
 }
 
+func TestPopulateCustomGCSDirs(t *testing.T) {
+	w := testWorkflow()
+	w.GCSPath = "gs://my-bucket/prefix"
+	w.ScratchDir = "scratch-${ID}"
+	w.SourcesDir = "src"
+	w.LogsDir = "log/dir"
+	if err := w.populate(context.Background()); err != nil {
+		t.Fatalf("error populating workflow: %v", err)
+	}
+
+	// The run has its own directory within ScratchDir.
+	wantDir := "prefix/scratch-" + w.id
+	wantScratch := w.scratchPath
+	if path.Dir(wantScratch) != wantDir || !strings.HasPrefix(path.Base(wantScratch), "daisy-"+w.Name+"-") || !strings.HasSuffix(wantScratch, "-"+w.id) {
+		t.Errorf("unexpected scratch path: got %q, want a daisy-%s-<datetime>-%s directory in %q", wantScratch, w.Name, w.id, wantDir)
+	}
+	if w.bucket != "my-bucket" {
+		t.Errorf("unexpected bucket: got %q, want %q", w.bucket, "my-bucket")
+	}
+	for _, tt := range []struct{ got, want string }{
+		{w.sourcesPath, wantScratch + "/src"},
+		{w.logsPath, wantScratch + "/log/dir"},
+		{w.outsPath, wantScratch + "/outs"},
+		{w.autovars["SCRATCHPATH"], "gs://my-bucket/" + wantScratch},
+	} {
+		if tt.got != tt.want {
+			t.Errorf("unexpected path: got %q, want %q", tt.got, tt.want)
+		}
+	}
+}
+
+func TestPopulateSharedSourcesDir(t *testing.T) {
+	w := testWorkflow()
+	w.GCSPath = "gs://my-bucket/prefix"
+	w.SharedSourcesDir = "shared"
+	if err := w.populate(context.Background()); err != nil {
+		t.Fatalf("error populating workflow: %v", err)
+	}
+	if want := "prefix/shared"; w.sourcesPath != want {
+		t.Errorf("unexpected sources path: got %q, want %q", w.sourcesPath, want)
+	}
+	if strings.HasPrefix(w.sourcesPath, w.scratchPath+"/") {
+		t.Errorf("sources path %q should not be in scratch path %q", w.sourcesPath, w.scratchPath)
+	}
+}
+
+func TestPopulateStorageClasses(t *testing.T) {
+	tests := []struct {
+		desc      string
+		set       func(w *Workflow)
+		shouldErr bool
+	}{
+		{"valid case", func(w *Workflow) {
+			w.LogsStorageClass, w.SerialLogStorageClass, w.OutsStorageClass = "NEARLINE", "COLDLINE", "STANDARD"
+		}, false},
+		{"bad LogsStorageClass case", func(w *Workflow) { w.LogsStorageClass = "nearline" }, true},
+		{"bad SerialLogStorageClass case", func(w *Workflow) { w.SerialLogStorageClass = "COLD" }, true},
+		{"bad OutsStorageClass case", func(w *Workflow) { w.OutsStorageClass = "FAST" }, true},
+	}
+	for _, tt := range tests {
+		w := testWorkflow()
+		tt.set(w)
+		err := w.populate(context.Background())
+		if tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+	}
+}
+
+func TestPopulateBucketLocation(t *testing.T) {
+	tests := []struct {
+		desc, zone, location, want string
+		shouldErr                  bool
+	}{
+		{"zone region case", "us-west1-b", "", "us-west1", false},
+		{"region override case", "us-west1-b", "europe-west4", "europe-west4", false},
+		{"multi-region override case", "us-west1-b", "EU", "eu", false},
+		{"no zone case", "", "", "", false},
+		{"bad zone case", "zone", "", "", false},
+		{"bad override case", "us-west1-b", "mars", "", true},
+	}
+	for _, tt := range tests {
+		w := testWorkflow()
+		w.Project, w.Zone, w.BucketLocation, w.GCSPath = "foo-project", tt.zone, tt.location, ""
+		err := w.populate(context.Background())
+		if tt.shouldErr {
+			if err == nil {
+				t.Errorf("%s: should have returned an error", tt.desc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+			continue
+		}
+		if got := w.bucketLocation(); got != tt.want {
+			t.Errorf("%s: unexpected bucket location: got %q, want %q", tt.desc, got, tt.want)
+		}
+		if w.bucket != "foo-project-daisy-bkt" {
+			t.Errorf("%s: unexpected bucket: %q", tt.desc, w.bucket)
+		}
+	}
+}
+
+func TestCleanupScratch(t *testing.T) {
+	objs := []string{"s/sources/file", "s/sources/dir/file", "s/logs/serial.log", "s/logs/daisy.log", "s/outs/out", "s/other", "s/fail"}
+	var deleted []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			var items []string
+			for _, o := range objs {
+				items = append(items, fmt.Sprintf(`{"kind": "storage#object", "name": %q}`, o))
+			}
+			fmt.Fprintf(w, `{"kind": "storage#objects", "items": [%s]}`, strings.Join(items, ","))
+			return
+		}
+		if r.Method == "DELETE" {
+			name, _ := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), "/b/bucket/o/"))
+			if name == "s/fail" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			deleted = append(deleted, name)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		desc        string
+		cleanupLogs bool
+		want        []string
+	}{
+		{"keep logs case", false, []string{"s/sources/file", "s/sources/dir/file", "s/other"}},
+		{"delete logs case", true, []string{"s/sources/file", "s/sources/dir/file", "s/logs/serial.log", "s/other"}},
+	}
+
+	for _, tt := range tests {
+		deleted = nil
+		w := testWorkflow()
+		var err error
+		w.StorageClient, err = storage.NewClient(context.Background(), option.WithEndpoint(ts.URL), option.WithHTTPClient(http.DefaultClient))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.bucket = "bucket"
+		w.scratchPath = "s"
+		w.logsPath = "s/logs"
+		w.outsPath = "s/outs"
+		w.CleanupLogsOnSuccess = tt.cleanupLogs
+
+		derr := w.cleanupScratch(context.Background())
+		if derr == nil || !strings.Contains(derr.Error(), "s/fail") {
+			t.Errorf("%s: expected error for failed delete, got: %v", tt.desc, derr)
+		}
+		if !reflect.DeepEqual(deleted, tt.want) {
+			t.Errorf("%s: unexpected deleted objects, want: %q, got: %q", tt.desc, tt.want, deleted)
+		}
+	}
+}
+
+func TestSetOutsObjectAttrs(t *testing.T) {
+	var rewrites []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			fmt.Fprint(w, `{"kind": "storage#objects", "items": [{"kind": "storage#object", "name": "s/outs/out", "contentType": "text/plain", "contentEncoding": "gzip", "cacheControl": "no-cache", "metadata": {"owner": "guest"}}]}`)
+			return
+		}
+		if r.Method == "POST" && strings.Contains(r.URL.Path, "/rewriteTo/") {
+			body, _ := ioutil.ReadAll(r.Body)
+			rewrites = append(rewrites, r.URL.Path+" "+string(body))
+			fmt.Fprint(w, `{"kind": "storage#rewriteResponse", "done": true, "resource": {"bucket": "bucket", "name": "s/outs/out"}}`)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		desc         string
+		storageClass string
+		md           map[string]string
+		want         []string
+	}{
+		{"unset case", "", nil, nil},
+		{"storage class case", "COLDLINE", nil, []string{`"storageClass":"COLDLINE"`, `"contentType":"text/plain"`, `"contentEncoding":"gzip"`, `"cacheControl":"no-cache"`, `"owner":"guest"`}},
+		{"metadata case", "", map[string]string{"retention": "long", "owner": "daisy"}, []string{`"retention":"long"`, `"owner":"daisy"`}},
+	}
+
+	for _, tt := range tests {
+		rewrites = nil
+		w := testWorkflow()
+		var err error
+		w.StorageClient, err = storage.NewClient(context.Background(), option.WithEndpoint(ts.URL), option.WithHTTPClient(http.DefaultClient))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.bucket = "bucket"
+		w.outsPath = "s/outs"
+		w.OutsStorageClass = tt.storageClass
+		w.OutsObjectMetadata = tt.md
+
+		if err := w.setOutsObjectAttrs(context.Background()); err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+		if tt.want == nil {
+			if len(rewrites) != 0 {
+				t.Errorf("%s: unexpected rewrites: %q", tt.desc, rewrites)
+			}
+			continue
+		}
+		if len(rewrites) != 1 {
+			t.Fatalf("%s: want 1 rewrite, got: %q", tt.desc, rewrites)
+		}
+		if !strings.HasPrefix(rewrites[0], "/b/bucket/o/s/outs/out/rewriteTo/b/bucket/o/s/outs/out ") {
+			t.Errorf("%s: unexpected rewrite request: %s", tt.desc, rewrites[0])
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(rewrites[0], want) {
+				t.Errorf("%s: rewrite request missing %s: %s", tt.desc, want, rewrites[0])
+			}
+		}
+	}
+}
+
+func TestInstanceTimeRecord(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		desc string
+		r    InstanceTimeRecord
+		want string
+	}{
+		{"empty case", InstanceTimeRecord{}, ""},
+		{"created case", InstanceTimeRecord{CreateStartTime: start, CreatedTime: start.Add(12 * time.Second)}, "created in 12s"},
+		{"all stages case", InstanceTimeRecord{CreateStartTime: start, CreatedTime: start.Add(10 * time.Second), FirstSerialOutputTime: start.Add(40 * time.Second), StoppedTime: start.Add(10 * time.Minute)},
+			"created in 10s, first serial output after 30s, stopped after 9m50s"},
+		{"stopped without serial output case", InstanceTimeRecord{CreateStartTime: start, CreatedTime: start.Add(time.Second), StoppedTime: start.Add(time.Minute)}, "created in 1s, stopped after 59s"},
+		{"not created case", InstanceTimeRecord{FirstSerialOutputTime: start, StoppedTime: start.Add(time.Minute)}, ""},
+	}
+
+	for _, tt := range tests {
+		if got := tt.r.String(); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestRecordInstanceTime(t *testing.T) {
+	w := testWorkflow()
+	sub := testWorkflow()
+	sub.parent = w
+	now := time.Now()
+	w.recordInstanceTime("i1", func(r *InstanceTimeRecord) { r.CreatedTime = now })
+	sub.recordInstanceTime("i2", func(r *InstanceTimeRecord) { r.CreatedTime = now })
+	sub.recordInstanceTime("i1", func(r *InstanceTimeRecord) { r.StoppedTime = now.Add(time.Minute) })
+
+	want := []InstanceTimeRecord{{Instance: "i1", CreatedTime: now, StoppedTime: now.Add(time.Minute)}, {Instance: "i2", CreatedTime: now}}
+	if diffRes := diff(w.GetInstanceTimeRecords(), want, 0); diffRes != "" {
+		t.Errorf("instance time records do not match expectation: (-got +want)\n%s", diffRes)
+	}
+}
+
+func TestDeleteCreatedBucket(t *testing.T) {
+	var mx sync.Mutex
+	var objects, deleted []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mx.Lock()
+		defer mx.Unlock()
+		if r.Method == "GET" && strings.HasPrefix(r.URL.Path, "/b/bucket/o") {
+			var items []string
+			for _, o := range objects {
+				if strings.HasPrefix(o, r.URL.Query().Get("prefix")) {
+					items = append(items, fmt.Sprintf(`{"kind": "storage#object", "name": %q}`, o))
+				}
+			}
+			fmt.Fprintf(w, `{"kind": "storage#objects", "items": [%s]}`, strings.Join(items, ","))
+			return
+		}
+		if r.Method == "DELETE" {
+			name, _ := url.PathUnescape(r.URL.EscapedPath())
+			deleted = append(deleted, name)
+			for i, o := range objects {
+				if "/b/bucket/o/"+o == name {
+					objects = append(objects[:i], objects[i+1:]...)
+					break
+				}
+			}
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		desc    string
+		created bool
+		child   bool
+		objects []string
+		want    []string
+	}{
+		{"created bucket case", true, false, []string{"s/logs/daisy.log", "s/outs/out"}, []string{"/b/bucket/o/s/logs/daisy.log", "/b/bucket/o/s/outs/out", "/b/bucket"}},
+		{"other runs case", true, false, []string{"other/logs/daisy.log", "s/outs/out"}, []string{"/b/bucket/o/s/outs/out"}},
+		{"user bucket case", false, false, []string{"s/outs/out"}, nil},
+		{"child workflow case", true, true, []string{"s/outs/out"}, nil},
+	}
+
+	for _, tt := range tests {
+		mx.Lock()
+		objects, deleted = append([]string(nil), tt.objects...), nil
+		mx.Unlock()
+		w := testWorkflow()
+		var err error
+		w.StorageClient, err = storage.NewClient(context.Background(), option.WithEndpoint(ts.URL), option.WithHTTPClient(http.DefaultClient))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.bucket = "bucket"
+		w.scratchPath = "s"
+		w.bucketCreated = tt.created
+		if tt.child {
+			w.parent = testWorkflow()
+		}
+
+		if derr := w.deleteCreatedBucket(context.Background()); derr != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, derr)
+		}
+		if !reflect.DeepEqual(deleted, tt.want) {
+			t.Errorf("%s: unexpected deletes, want: %q, got: %q", tt.desc, tt.want, deleted)
+		}
+	}
+}
+
+func TestLogResourceCreation(t *testing.T) {
+	parent := testWorkflow()
+	w := testWorkflow()
+	w.parent = parent
+	mockLogger := &MockLogger{}
+	w.Logger = mockLogger
+	s := &Step{name: "s", w: w}
+
+	w.logResourceCreation(s, "CreateDisks", "disk", &Resource{daisyName: "d", RealName: "d-wf-123"})
+
+	entries := mockLogger.getEntries()
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 log entry, got %d", len(entries))
+	}
+	if got, want := entries[0].Message, `Creating disk "d-wf-123" (workflow reference "d").`; got != want {
+		t.Errorf("got message %q, want %q", got, want)
+	}
+	want := []ResourceNameRecord{{Type: "disk", Name: "d", RealName: "d-wf-123"}}
+	if got := parent.GetResourceNameRecords(); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected parent resource name records, got: %+v, want: %+v", got, want)
+	}
+	if got := w.GetResourceNameRecords(); got != nil {
+		t.Errorf("expected no resource name records in sub workflow, got: %+v", got)
+	}
+	// The records returned are a copy.
+	parent.GetResourceNameRecords()[0].Name = "changed"
+	if got := parent.GetResourceNameRecords(); !reflect.DeepEqual(got, want) {
+		t.Errorf("resource name records changed through a returned copy, got: %+v, want: %+v", got, want)
+	}
+}
+
+func TestRecordResourceNameSelfLink(t *testing.T) {
+	w := testWorkflow()
+	base := strings.TrimSuffix(w.ComputeClient.BasePath(), "/")
+	link := "projects/p/zones/z/instances/i"
+	w.recordResourceName("instance", &Resource{daisyName: "i", RealName: "i-wf-123", link: link})
+	w.ComputeClient = nil
+	w.recordResourceName("instance", &Resource{daisyName: "i", RealName: "i-wf-123", link: link})
+
+	got := w.GetResourceNameRecords()
+	want := []string{
+		strings.TrimSuffix(base, "/projects") + "/" + link,
+		"https://compute.googleapis.com/compute/v1/" + link,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d records, want %d", len(got), len(want))
+	}
+	for i, r := range got {
+		if r.Link != link {
+			t.Errorf("record %d: got Link %q, want %q", i, r.Link, link)
+		}
+		if r.SelfLink != want[i] {
+			t.Errorf("record %d: got SelfLink %q, want %q", i, r.SelfLink, want[i])
+		}
+	}
+}
+
 func TestRequiredVars(t *testing.T) {
 	w := testWorkflow()
 
@@ -702,6 +1282,68 @@ func TestTraverseDAG(t *testing.T) {
 	if err := checkCallOrder(); err != nil {
 		t.Errorf("call order error: %s", err)
 	}
+
+	callOrder = []int{}
+
+	// s2 failure with ContinueOnError, s3 still runs.
+	w = testTraverseWorkflow(mockRun)
+	w.Steps["s2"].ContinueOnError = true
+	if err := w.Run(ctx); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	if err := checkCallOrder(); err != nil {
+		t.Errorf("call order error: %s", err)
+	}
+	rw.Lock()
+	s3Ran := false
+	for _, i := range callOrder {
+		s3Ran = s3Ran || i == 3
+	}
+	if !s3Ran {
+		t.Errorf("want s3 to run, got call order %v", callOrder)
+	}
+	rw.Unlock()
+	wantRecords := []StepErrorRecord{{Step: "s2", Error: want}}
+	if diffRes := diff(w.GetStepErrorRecords(), wantRecords, 0); diffRes != "" {
+		t.Errorf("step error records not as expected: (-got,+want)\n%s", diffRes)
+	}
+}
+
+func TestTraverseDAGReadyWhen(t *testing.T) {
+	// s0 only finishes once s1, which depends on it, has run.
+	s1Ran := make(chan struct{})
+	w := testWorkflow()
+	w.Steps = map[string]*Step{
+		"s0": {name: "s0", ReadyWhen: readyWhenFirstRunning, timeout: time.Minute, w: w, testType: &mockStep{runImpl: func(_ context.Context, s *Step) DError {
+			s.signalReady()
+			select {
+			case <-s1Ran:
+				return nil
+			case <-time.After(10 * time.Second):
+				return Errf("s1 did not start before s0 finished")
+			}
+		}}},
+		"s1": {name: "s1", timeout: time.Minute, w: w, testType: &mockStep{runImpl: func(context.Context, *Step) DError {
+			close(s1Ran)
+			return nil
+		}}},
+		"s2": {name: "s2", timeout: time.Minute, w: w, testType: &mockStep{}},
+	}
+	w.Dependencies = map[string][]string{"s1": {"s0"}, "s2": {"s0", "s1"}}
+	if err := w.run(context.Background()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// An error after the step is ready still fails the workflow.
+	w.Steps["s0"].testType = &mockStep{runImpl: func(_ context.Context, s *Step) DError {
+		s.signalReady()
+		s.signalReady()
+		return Errf("failure")
+	}}
+	w.Steps["s1"].testType = &mockStep{}
+	if err := w.run(context.Background()); err == nil {
+		t.Error("should have returned an error")
+	}
 }
 
 func TestForceCleanupSetOnRunError(t *testing.T) {
@@ -929,6 +1571,62 @@ func TestWrite(t *testing.T) {
 	}
 }
 
+func TestRunAsyncCleanup(t *testing.T) {
+	ctx := context.Background()
+	w := testWorkflow()
+	w.AsyncCleanup = true
+	w.Steps = map[string]*Step{
+		"s0": {name: "s0", testType: &mockStep{}, w: w},
+	}
+	release := make(chan struct{})
+	cleanedUp := make(chan struct{})
+	w.addCleanupHook(func() DError {
+		<-release
+		close(cleanedUp)
+		return nil
+	})
+
+	if err := w.Run(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	select {
+	case <-cleanedUp:
+		t.Fatal("Run waited for cleanup")
+	default:
+	}
+
+	close(release)
+	w.WaitForCleanup()
+	select {
+	case <-cleanedUp:
+	default:
+		t.Error("WaitForCleanup returned before cleanup finished")
+	}
+}
+
+func TestCleanupTimeout(t *testing.T) {
+	w := testWorkflow()
+	w.CleanupTimeout = "1ms"
+	w.cleanupTimeout = time.Millisecond
+	hung := make(chan struct{})
+	defer close(hung)
+	w.addCleanupHook(func() DError {
+		<-hung
+		return nil
+	})
+
+	done := make(chan struct{})
+	go func() {
+		w.cleanup()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("cleanup did not return after CleanupTimeout")
+	}
+}
+
 func TestRunStepTimeout(t *testing.T) {
 	w := testWorkflow()
 	s, _ := w.NewStep("test")
@@ -943,6 +1641,30 @@ func TestRunStepTimeout(t *testing.T) {
 	}
 }
 
+func TestValidateEndpoints(t *testing.T) {
+	tests := []struct {
+		desc, compute, storage string
+		shouldErr              bool
+	}{
+		{"unset case", "", "", false},
+		{"emulator case", "http://localhost:8080/compute/v1/", "http://localhost:9000/storage/v1/", false},
+		{"no scheme case", "compute.example.com/compute/v1/", "", true},
+		{"bad scheme case", "", "gs://bucket", true},
+		{"no host case", "https:///compute/v1/", "", true},
+		{"unparsable case", "", "http://[::1", true},
+	}
+	for _, tt := range tests {
+		w := testWorkflow()
+		w.ComputeEndpoint, w.StorageEndpoint = tt.compute, tt.storage
+		err := w.validateEndpoints()
+		if tt.shouldErr && err == nil {
+			t.Errorf("%s: should have returned an error", tt.desc)
+		} else if !tt.shouldErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		}
+	}
+}
+
 func TestPopulateClients(t *testing.T) {
 	w := testWorkflow()
 
@@ -996,3 +1718,14 @@ func tryPopulateClients(t *testing.T, w *Workflow) {
 		t.Errorf("Failed to populate clients for workflow: %v", err)
 	}
 }
+
+func TestUserAgent(t *testing.T) {
+	w := testWorkflow()
+	if got, want := w.userAgent(), "daisy/"+Version; got != want {
+		t.Errorf("default user-agent: got %q, want %q", got, want)
+	}
+	w.UserAgent = "my-tool/1.0"
+	if got, want := w.userAgent(), "my-tool/1.0"; got != want {
+		t.Errorf("user-agent: got %q, want %q", got, want)
+	}
+}
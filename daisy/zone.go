@@ -15,11 +15,96 @@
 package daisy
 
 import (
+	"path"
+	"sort"
+	"strings"
+
 	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
 )
 
+// SelectedZoneOutput is the serial-output value the zone picked from Region
+// is recorded as, e.g. in RunResult.Outputs, when Zone is unset.
+const SelectedZoneOutput = "SelectedZone"
+
 func (w *Workflow) zoneExists(project, zone string) (bool, DError) {
 	return w.zonesCache.resourceExists(func(project string, opts ...daisyCompute.ListCallOption) (interface{}, error) {
 		return w.ComputeClient.ListZones(project)
 	}, project, zone)
 }
+
+// selectZone sets Zone to the first zone in Region, by name, that is UP and
+// offers the machine types requested by the workflow's CreateInstances steps,
+// and records it as the SelectedZoneOutput serial-output value. Quotas are
+// regional, the same for every candidate zone, so the quota preflight can't
+// tell them apart; it runs against the region during validation instead. The
+// zone's capacity isn't checked, an instance insert that fails with
+// ZONE_RESOURCE_POOL_EXHAUSTED isn't retried in another zone.
+func (w *Workflow) selectZone() DError {
+	zones, err := w.ComputeClient.ListZones(w.Project)
+	if err != nil {
+		return typedErr(apiError, "failed to list zones", err)
+	}
+	var candidates []string
+	for _, z := range zones {
+		if path.Base(z.Region) == w.Region && z.Status == "UP" {
+			candidates = append(candidates, z.Name)
+		}
+	}
+	sort.Strings(candidates)
+
+	machineTypes := w.requestedMachineTypes()
+Candidates:
+	for _, zone := range candidates {
+		for _, mt := range machineTypes {
+			if exists, err := w.machineTypeExists(w.Project, zone, mt); err != nil || !exists {
+				continue Candidates
+			}
+		}
+		w.Zone = zone
+		w.AddSerialConsoleOutputValue(SelectedZoneOutput, zone)
+		return nil
+	}
+	return Errf("no zone in region %q is available with machine types %q", w.Region, machineTypes)
+}
+
+// requestedMachineTypes returns the machine types, by name, of the instances
+// created by the CreateInstances steps of the workflow and of its included
+// and sub workflows. Machine types given as URLs are left out, as they
+// already name their zone, as are those set from an included or sub
+// workflow's Vars, which aren't substituted yet.
+func (w *Workflow) requestedMachineTypes() []string {
+	var mts []string
+	w.addRequestedMachineTypes(&mts)
+	sort.Strings(mts)
+	return mts
+}
+
+func (w *Workflow) addRequestedMachineTypes(mts *[]string) {
+	add := func(ii InstanceInterface) {
+		mt := ii.getMachineType()
+		if mt == "" {
+			if ii.getSourceMachineImage() != "" {
+				return
+			}
+			mt = defaultMachineType
+		}
+		if !strings.ContainsAny(mt, "/$") && !strIn(mt, *mts) {
+			*mts = append(*mts, mt)
+		}
+	}
+	for _, s := range w.Steps {
+		switch {
+		case s.CreateInstances != nil:
+			for _, i := range s.CreateInstances.Instances {
+				add(i)
+			}
+			for _, i := range s.CreateInstances.InstancesBeta {
+				add(i)
+			}
+		case s.IncludeWorkflow != nil && s.IncludeWorkflow.Workflow != nil:
+			s.IncludeWorkflow.Workflow.addRequestedMachineTypes(mts)
+		case s.SubWorkflow != nil && s.SubWorkflow.Workflow != nil:
+			s.SubWorkflow.Workflow.addRequestedMachineTypes(mts)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+//  Copyright 2020 Google Inc. All Rights Reserved.
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package daisy
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	daisyCompute "github.com/GoogleCloudPlatform/compute-image-tools/daisy/compute"
+	computeBeta "google.golang.org/api/compute/v0.beta"
+	"google.golang.org/api/compute/v1"
+)
+
+func TestSelectZone(t *testing.T) {
+	zones := []*compute.Zone{
+		{Name: "us-central1-c", Region: "projects/p/regions/us-central1", Status: "UP"},
+		{Name: "us-central1-b", Region: "projects/p/regions/us-central1", Status: "UP"},
+		{Name: "us-central1-a", Region: "projects/p/regions/us-central1", Status: "DOWN"},
+		{Name: "us-east1-b", Region: "projects/p/regions/us-east1", Status: "UP"},
+	}
+	machineTypes := map[string][]*compute.MachineType{
+		"us-central1-a": {{Name: "n1-standard-1"}, {Name: "n2-standard-8"}},
+		"us-central1-b": {{Name: "n1-standard-1"}},
+		"us-central1-c": {{Name: "n1-standard-1"}, {Name: "n2-standard-8"}},
+		"us-east1-b":    {{Name: "n1-standard-1"}, {Name: "n2-standard-8"}},
+	}
+
+	tests := []struct {
+		desc, region string
+		instances    []*Instance
+		want         string
+		wantErr      bool
+	}{
+		{"no instances", "us-central1", nil, "us-central1-b", false},
+		{"default machine type", "us-central1", []*Instance{{}}, "us-central1-b", false},
+		{"machine type not in first zone", "us-central1", []*Instance{{}, {Instance: compute.Instance{MachineType: "n2-standard-8"}}}, "us-central1-c", false},
+		{"machine type URL", "us-central1", []*Instance{{Instance: compute.Instance{MachineType: "zones/us-east1-b/machineTypes/n2-standard-8"}}}, "us-central1-b", false},
+		{"other region", "us-east1", []*Instance{{Instance: compute.Instance{MachineType: "n2-standard-8"}}}, "us-east1-b", false},
+		{"machine type unavailable", "us-central1", []*Instance{{Instance: compute.Instance{MachineType: "n9-standard-1"}}}, "", true},
+		{"unknown region", "europe-west1", nil, "", true},
+		{"var machine type", "us-central1", []*Instance{{Instance: compute.Instance{MachineType: "${machine_type}"}}}, "us-central1-b", false},
+	}
+
+	for _, tt := range tests {
+		w := testWorkflow()
+		w.Zone = ""
+		w.Region = tt.region
+		w.Steps = map[string]*Step{"create": {CreateInstances: &CreateInstances{Instances: tt.instances}}}
+		w.ComputeClient.(*daisyCompute.TestClient).ListZonesFn = func(project string, opts ...daisyCompute.ListCallOption) ([]*compute.Zone, error) {
+			return zones, nil
+		}
+		w.ComputeClient.(*daisyCompute.TestClient).ListMachineTypesFn = func(project, zone string, opts ...daisyCompute.ListCallOption) ([]*compute.MachineType, error) {
+			return machineTypes[zone], nil
+		}
+		w.ComputeClient.(*daisyCompute.TestClient).GetMachineTypeFn = func(project, zone, machineType string) (*compute.MachineType, error) {
+			return nil, errors.New("not found")
+		}
+
+		err := w.selectZone()
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected error, got zone %q", tt.desc, w.Zone)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.desc, err)
+		} else if w.Zone != tt.want {
+			t.Errorf("%s: got zone %q, want %q", tt.desc, w.Zone, tt.want)
+		} else if got := w.GetSerialConsoleOutputValue(SelectedZoneOutput); got != tt.want {
+			t.Errorf("%s: got %s output %q, want %q", tt.desc, SelectedZoneOutput, got, tt.want)
+		}
+	}
+}
+
+func TestRequestedMachineTypes(t *testing.T) {
+	included := &Workflow{Steps: map[string]*Step{"create": {CreateInstances: &CreateInstances{Instances: []*Instance{{Instance: compute.Instance{MachineType: "n2-standard-8"}}}}}}}
+	sub := &Workflow{Steps: map[string]*Step{"create": {CreateInstances: &CreateInstances{InstancesBeta: []*InstanceBeta{{Instance: computeBeta.Instance{MachineType: "e2-small"}}}}}}}
+	w := testWorkflow()
+	w.Steps = map[string]*Step{
+		"create":  {CreateInstances: &CreateInstances{Instances: []*Instance{{}}}},
+		"include": {IncludeWorkflow: &IncludeWorkflow{Workflow: included}},
+		"sub":     {SubWorkflow: &SubWorkflow{Workflow: sub}},
+	}
+	want := []string{"e2-small", "n1-standard-1", "n2-standard-8"}
+	if got := w.requestedMachineTypes(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got machine types %q, want %q", got, want)
+	}
+}
@@ -36,6 +36,40 @@ func (m *MockClient) EXPECT() *MockClientMockRecorder {
 	return m.recorder
 }
 
+// AddInstanceGroupInstances mocks base method
+func (m *MockClient) AddInstanceGroupInstances(arg0, arg1, arg2 string, arg3 []string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddInstanceGroupInstances", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AddInstanceGroupInstances indicates an expected call of AddInstanceGroupInstances
+func (mr *MockClientMockRecorder) AddInstanceGroupInstances(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddInstanceGroupInstances", reflect.TypeOf((*MockClient)(nil).AddInstanceGroupInstances), arg0, arg1, arg2, arg3)
+}
+
+// AggregatedListAddresses mocks base method
+func (m *MockClient) AggregatedListAddresses(arg0 string, arg1 ...compute.ListCallOption) ([]*v1.Address, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{arg0}
+	for _, a := range arg1 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "AggregatedListAddresses", varargs...)
+	ret0, _ := ret[0].([]*v1.Address)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AggregatedListAddresses indicates an expected call of AggregatedListAddresses
+func (mr *MockClientMockRecorder) AggregatedListAddresses(arg0 interface{}, arg1 ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{arg0}, arg1...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AggregatedListAddresses", reflect.TypeOf((*MockClient)(nil).AggregatedListAddresses), varargs...)
+}
+
 // AggregatedListDisks mocks base method
 func (m *MockClient) AggregatedListDisks(arg0 string, arg1 ...compute.ListCallOption) ([]*v1.Disk, error) {
 	m.ctrl.T.Helper()
@@ -56,6 +90,26 @@ func (mr *MockClientMockRecorder) AggregatedListDisks(arg0 interface{}, arg1 ...
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AggregatedListDisks", reflect.TypeOf((*MockClient)(nil).AggregatedListDisks), varargs...)
 }
 
+// AggregatedListForwardingRules mocks base method
+func (m *MockClient) AggregatedListForwardingRules(arg0 string, arg1 ...compute.ListCallOption) ([]*v1.ForwardingRule, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{arg0}
+	for _, a := range arg1 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "AggregatedListForwardingRules", varargs...)
+	ret0, _ := ret[0].([]*v1.ForwardingRule)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AggregatedListForwardingRules indicates an expected call of AggregatedListForwardingRules
+func (mr *MockClientMockRecorder) AggregatedListForwardingRules(arg0 interface{}, arg1 ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{arg0}, arg1...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AggregatedListForwardingRules", reflect.TypeOf((*MockClient)(nil).AggregatedListForwardingRules), varargs...)
+}
+
 // AggregatedListInstances mocks base method
 func (m *MockClient) AggregatedListInstances(arg0 string, arg1 ...compute.ListCallOption) ([]*v1.Instance, error) {
 	m.ctrl.T.Helper()
@@ -124,6 +178,34 @@ func (mr *MockClientMockRecorder) BasePath() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BasePath", reflect.TypeOf((*MockClient)(nil).BasePath))
 }
 
+// CreateAddress mocks base method
+func (m *MockClient) CreateAddress(arg0, arg1 string, arg2 *v1.Address) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateAddress", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateAddress indicates an expected call of CreateAddress
+func (mr *MockClientMockRecorder) CreateAddress(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateAddress", reflect.TypeOf((*MockClient)(nil).CreateAddress), arg0, arg1, arg2)
+}
+
+// CreateAddressWithLabels mocks base method
+func (m *MockClient) CreateAddressWithLabels(arg0, arg1 string, arg2 *v1.Address, arg3 map[string]string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateAddressWithLabels", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateAddressWithLabels indicates an expected call of CreateAddressWithLabels
+func (mr *MockClientMockRecorder) CreateAddressWithLabels(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateAddressWithLabels", reflect.TypeOf((*MockClient)(nil).CreateAddressWithLabels), arg0, arg1, arg2, arg3)
+}
+
 // CreateDisk mocks base method
 func (m *MockClient) CreateDisk(arg0, arg1 string, arg2 *v1.Disk) error {
 	m.ctrl.T.Helper()
@@ -152,6 +234,34 @@ func (mr *MockClientMockRecorder) CreateFirewallRule(arg0, arg1 interface{}) *go
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateFirewallRule", reflect.TypeOf((*MockClient)(nil).CreateFirewallRule), arg0, arg1)
 }
 
+// CreateDiskWithOptions mocks base method
+func (m *MockClient) CreateDiskWithOptions(arg0, arg1 string, arg2 *v1.Disk, arg3 compute.DiskOptions) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateDiskWithOptions", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateDiskWithOptions indicates an expected call of CreateDiskWithOptions
+func (mr *MockClientMockRecorder) CreateDiskWithOptions(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateDiskWithOptions", reflect.TypeOf((*MockClient)(nil).CreateDiskWithOptions), arg0, arg1, arg2, arg3)
+}
+
+// CreateDiskWithProvisionedPerformance mocks base method
+func (m *MockClient) CreateDiskWithProvisionedPerformance(arg0, arg1 string, arg2 *v1.Disk, arg3, arg4 int64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateDiskWithProvisionedPerformance", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateDiskWithProvisionedPerformance indicates an expected call of CreateDiskWithProvisionedPerformance
+func (mr *MockClientMockRecorder) CreateDiskWithProvisionedPerformance(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateDiskWithProvisionedPerformance", reflect.TypeOf((*MockClient)(nil).CreateDiskWithProvisionedPerformance), arg0, arg1, arg2, arg3, arg4)
+}
+
 // CreateForwardingRule mocks base method
 func (m *MockClient) CreateForwardingRule(arg0, arg1 string, arg2 *v1.ForwardingRule) error {
 	m.ctrl.T.Helper()
@@ -166,6 +276,20 @@ func (mr *MockClientMockRecorder) CreateForwardingRule(arg0, arg1, arg2 interfac
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateForwardingRule", reflect.TypeOf((*MockClient)(nil).CreateForwardingRule), arg0, arg1, arg2)
 }
 
+// CreateForwardingRuleWithLabels mocks base method
+func (m *MockClient) CreateForwardingRuleWithLabels(arg0, arg1 string, arg2 *v1.ForwardingRule, arg3 map[string]string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateForwardingRuleWithLabels", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateForwardingRuleWithLabels indicates an expected call of CreateForwardingRuleWithLabels
+func (mr *MockClientMockRecorder) CreateForwardingRuleWithLabels(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateForwardingRuleWithLabels", reflect.TypeOf((*MockClient)(nil).CreateForwardingRuleWithLabels), arg0, arg1, arg2, arg3)
+}
+
 // CreateImage mocks base method
 func (m *MockClient) CreateImage(arg0 string, arg1 *v1.Image) error {
 	m.ctrl.T.Helper()
@@ -194,6 +318,20 @@ func (mr *MockClientMockRecorder) CreateImageBeta(arg0, arg1 interface{}) *gomoc
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateImageBeta", reflect.TypeOf((*MockClient)(nil).CreateImageBeta), arg0, arg1)
 }
 
+// CreateImageWithArchitecture mocks base method
+func (m *MockClient) CreateImageWithArchitecture(arg0 string, arg1 *v1.Image, arg2 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateImageWithArchitecture", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateImageWithArchitecture indicates an expected call of CreateImageWithArchitecture
+func (mr *MockClientMockRecorder) CreateImageWithArchitecture(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateImageWithArchitecture", reflect.TypeOf((*MockClient)(nil).CreateImageWithArchitecture), arg0, arg1, arg2)
+}
+
 // CreateInstance mocks base method
 func (m *MockClient) CreateInstance(arg0, arg1 string, arg2 *v1.Instance) error {
 	m.ctrl.T.Helper()
@@ -222,6 +360,76 @@ func (mr *MockClientMockRecorder) CreateInstanceBeta(arg0, arg1, arg2 interface{
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateInstanceBeta", reflect.TypeOf((*MockClient)(nil).CreateInstanceBeta), arg0, arg1, arg2)
 }
 
+// CreateInstanceBetaWithOptions mocks base method
+func (m *MockClient) CreateInstanceBetaWithOptions(arg0, arg1 string, arg2 *v0_beta.Instance, arg3 compute.InstanceOptions) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateInstanceBetaWithOptions", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateInstanceBetaWithOptions indicates an expected call of CreateInstanceBetaWithOptions
+func (mr *MockClientMockRecorder) CreateInstanceBetaWithOptions(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateInstanceBetaWithOptions", reflect.TypeOf((*MockClient)(nil).CreateInstanceBetaWithOptions), arg0, arg1, arg2, arg3)
+}
+
+// CreateInstanceBetaWithTerminationAction mocks base method
+func (m *MockClient) CreateInstanceBetaWithTerminationAction(arg0, arg1 string, arg2 *v0_beta.Instance, arg3 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateInstanceBetaWithTerminationAction", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateInstanceBetaWithTerminationAction indicates an expected call of CreateInstanceBetaWithTerminationAction
+func (mr *MockClientMockRecorder) CreateInstanceBetaWithTerminationAction(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateInstanceBetaWithTerminationAction", reflect.TypeOf((*MockClient)(nil).CreateInstanceBetaWithTerminationAction), arg0, arg1, arg2, arg3)
+}
+
+// CreateInstanceGroup mocks base method
+func (m *MockClient) CreateInstanceGroup(arg0, arg1 string, arg2 *v1.InstanceGroup) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateInstanceGroup", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateInstanceGroup indicates an expected call of CreateInstanceGroup
+func (mr *MockClientMockRecorder) CreateInstanceGroup(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateInstanceGroup", reflect.TypeOf((*MockClient)(nil).CreateInstanceGroup), arg0, arg1, arg2)
+}
+
+// CreateInstanceWithOptions mocks base method
+func (m *MockClient) CreateInstanceWithOptions(arg0, arg1 string, arg2 *v1.Instance, arg3 compute.InstanceOptions) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateInstanceWithOptions", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateInstanceWithOptions indicates an expected call of CreateInstanceWithOptions
+func (mr *MockClientMockRecorder) CreateInstanceWithOptions(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateInstanceWithOptions", reflect.TypeOf((*MockClient)(nil).CreateInstanceWithOptions), arg0, arg1, arg2, arg3)
+}
+
+// CreateInstanceWithTerminationAction mocks base method
+func (m *MockClient) CreateInstanceWithTerminationAction(arg0, arg1 string, arg2 *v1.Instance, arg3 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateInstanceWithTerminationAction", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateInstanceWithTerminationAction indicates an expected call of CreateInstanceWithTerminationAction
+func (mr *MockClientMockRecorder) CreateInstanceWithTerminationAction(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateInstanceWithTerminationAction", reflect.TypeOf((*MockClient)(nil).CreateInstanceWithTerminationAction), arg0, arg1, arg2, arg3)
+}
+
 // CreateMachineImage mocks base method
 func (m *MockClient) CreateMachineImage(arg0 string, arg1 *v0_beta.MachineImage) error {
 	m.ctrl.T.Helper()
@@ -250,6 +458,20 @@ func (mr *MockClientMockRecorder) CreateNetwork(arg0, arg1 interface{}) *gomock.
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateNetwork", reflect.TypeOf((*MockClient)(nil).CreateNetwork), arg0, arg1)
 }
 
+// CreateSnapshot mocks base method
+func (m *MockClient) CreateSnapshot(arg0, arg1, arg2 string, arg3 *v1.Snapshot, arg4 bool) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateSnapshot", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateSnapshot indicates an expected call of CreateSnapshot
+func (mr *MockClientMockRecorder) CreateSnapshot(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateSnapshot", reflect.TypeOf((*MockClient)(nil).CreateSnapshot), arg0, arg1, arg2, arg3, arg4)
+}
+
 // CreateSubnetwork mocks base method
 func (m *MockClient) CreateSubnetwork(arg0, arg1 string, arg2 *v1.Subnetwork) error {
 	m.ctrl.T.Helper()
@@ -278,6 +500,20 @@ func (mr *MockClientMockRecorder) CreateTargetInstance(arg0, arg1, arg2 interfac
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateTargetInstance", reflect.TypeOf((*MockClient)(nil).CreateTargetInstance), arg0, arg1, arg2)
 }
 
+// DeleteAddress mocks base method
+func (m *MockClient) DeleteAddress(arg0, arg1, arg2 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteAddress", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteAddress indicates an expected call of DeleteAddress
+func (mr *MockClientMockRecorder) DeleteAddress(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteAddress", reflect.TypeOf((*MockClient)(nil).DeleteAddress), arg0, arg1, arg2)
+}
+
 // DeleteDisk mocks base method
 func (m *MockClient) DeleteDisk(arg0, arg1, arg2 string) error {
 	m.ctrl.T.Helper()
@@ -348,6 +584,20 @@ func (mr *MockClientMockRecorder) DeleteInstance(arg0, arg1, arg2 interface{}) *
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteInstance", reflect.TypeOf((*MockClient)(nil).DeleteInstance), arg0, arg1, arg2)
 }
 
+// DeleteInstanceGroup mocks base method
+func (m *MockClient) DeleteInstanceGroup(arg0, arg1, arg2 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteInstanceGroup", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteInstanceGroup indicates an expected call of DeleteInstanceGroup
+func (mr *MockClientMockRecorder) DeleteInstanceGroup(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteInstanceGroup", reflect.TypeOf((*MockClient)(nil).DeleteInstanceGroup), arg0, arg1, arg2)
+}
+
 // DeleteMachineImage mocks base method
 func (m *MockClient) DeleteMachineImage(arg0, arg1 string) error {
 	m.ctrl.T.Helper()
@@ -446,6 +696,35 @@ func (mr *MockClientMockRecorder) DetachDisk(arg0, arg1, arg2, arg3 interface{})
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DetachDisk", reflect.TypeOf((*MockClient)(nil).DetachDisk), arg0, arg1, arg2, arg3)
 }
 
+// ForceAttachDisk mocks base method
+func (m *MockClient) ForceAttachDisk(arg0, arg1, arg2 string, arg3 *v1.AttachedDisk) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ForceAttachDisk", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ForceAttachDisk indicates an expected call of ForceAttachDisk
+func (mr *MockClientMockRecorder) ForceAttachDisk(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ForceAttachDisk", reflect.TypeOf((*MockClient)(nil).ForceAttachDisk), arg0, arg1, arg2, arg3)
+}
+
+// GetAddress mocks base method
+func (m *MockClient) GetAddress(arg0, arg1, arg2 string) (*v1.Address, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAddress", arg0, arg1, arg2)
+	ret0, _ := ret[0].(*v1.Address)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAddress indicates an expected call of GetAddress
+func (mr *MockClientMockRecorder) GetAddress(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAddress", reflect.TypeOf((*MockClient)(nil).GetAddress), arg0, arg1, arg2)
+}
+
 // GetDisk mocks base method
 func (m *MockClient) GetDisk(arg0, arg1, arg2 string) (*v1.Disk, error) {
 	m.ctrl.T.Helper()
@@ -461,6 +740,21 @@ func (mr *MockClientMockRecorder) GetDisk(arg0, arg1, arg2 interface{}) *gomock.
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetDisk", reflect.TypeOf((*MockClient)(nil).GetDisk), arg0, arg1, arg2)
 }
 
+// GetRegionDisk mocks base method
+func (m *MockClient) GetRegionDisk(arg0, arg1, arg2 string) (*v1.Disk, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRegionDisk", arg0, arg1, arg2)
+	ret0, _ := ret[0].(*v1.Disk)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRegionDisk indicates an expected call of GetRegionDisk
+func (mr *MockClientMockRecorder) GetRegionDisk(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRegionDisk", reflect.TypeOf((*MockClient)(nil).GetRegionDisk), arg0, arg1, arg2)
+}
+
 // GetFirewallRule mocks base method
 func (m *MockClient) GetFirewallRule(arg0, arg1 string) (*v1.Firewall, error) {
 	m.ctrl.T.Helper()
@@ -581,6 +875,21 @@ func (mr *MockClientMockRecorder) GetInstanceBeta(arg0, arg1, arg2 interface{})
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetInstanceBeta", reflect.TypeOf((*MockClient)(nil).GetInstanceBeta), arg0, arg1, arg2)
 }
 
+// GetInstanceGroup mocks base method
+func (m *MockClient) GetInstanceGroup(arg0, arg1, arg2 string) (*v1.InstanceGroup, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetInstanceGroup", arg0, arg1, arg2)
+	ret0, _ := ret[0].(*v1.InstanceGroup)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetInstanceGroup indicates an expected call of GetInstanceGroup
+func (mr *MockClientMockRecorder) GetInstanceGroup(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetInstanceGroup", reflect.TypeOf((*MockClient)(nil).GetInstanceGroup), arg0, arg1, arg2)
+}
+
 // GetLicense mocks base method
 func (m *MockClient) GetLicense(arg0, arg1 string) (*v1.License, error) {
 	m.ctrl.T.Helper()
@@ -656,6 +965,36 @@ func (mr *MockClientMockRecorder) GetProject(arg0 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetProject", reflect.TypeOf((*MockClient)(nil).GetProject), arg0)
 }
 
+// GetRegion mocks base method
+func (m *MockClient) GetRegion(arg0, arg1 string) (*v1.Region, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRegion", arg0, arg1)
+	ret0, _ := ret[0].(*v1.Region)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRegion indicates an expected call of GetRegion
+func (mr *MockClientMockRecorder) GetRegion(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRegion", reflect.TypeOf((*MockClient)(nil).GetRegion), arg0, arg1)
+}
+
+// GetScreenshot mocks base method
+func (m *MockClient) GetScreenshot(arg0, arg1, arg2 string) ([]byte, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetScreenshot", arg0, arg1, arg2)
+	ret0, _ := ret[0].([]byte)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetScreenshot indicates an expected call of GetScreenshot
+func (mr *MockClientMockRecorder) GetScreenshot(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetScreenshot", reflect.TypeOf((*MockClient)(nil).GetScreenshot), arg0, arg1, arg2)
+}
+
 // GetSerialPortOutput mocks base method
 func (m *MockClient) GetSerialPortOutput(arg0, arg1, arg2 string, arg3, arg4 int64) (*v1.SerialPortOutput, error) {
 	m.ctrl.T.Helper()
@@ -731,6 +1070,21 @@ func (mr *MockClientMockRecorder) GetZone(arg0, arg1 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetZone", reflect.TypeOf((*MockClient)(nil).GetZone), arg0, arg1)
 }
 
+// InstancePreempted mocks base method
+func (m *MockClient) InstancePreempted(arg0, arg1, arg2 string) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "InstancePreempted", arg0, arg1, arg2)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// InstancePreempted indicates an expected call of InstancePreempted
+func (mr *MockClientMockRecorder) InstancePreempted(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "InstancePreempted", reflect.TypeOf((*MockClient)(nil).InstancePreempted), arg0, arg1, arg2)
+}
+
 // InstanceStatus mocks base method
 func (m *MockClient) InstanceStatus(arg0, arg1, arg2 string) (string, error) {
 	m.ctrl.T.Helper()
@@ -761,6 +1115,26 @@ func (mr *MockClientMockRecorder) InstanceStopped(arg0, arg1, arg2 interface{})
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "InstanceStopped", reflect.TypeOf((*MockClient)(nil).InstanceStopped), arg0, arg1, arg2)
 }
 
+// ListAddresses mocks base method
+func (m *MockClient) ListAddresses(arg0, arg1 string, arg2 ...compute.ListCallOption) ([]*v1.Address, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{arg0, arg1}
+	for _, a := range arg2 {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "ListAddresses", varargs...)
+	ret0, _ := ret[0].([]*v1.Address)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListAddresses indicates an expected call of ListAddresses
+func (mr *MockClientMockRecorder) ListAddresses(arg0, arg1 interface{}, arg2 ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{arg0, arg1}, arg2...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListAddresses", reflect.TypeOf((*MockClient)(nil).ListAddresses), varargs...)
+}
+
 // ListDisks mocks base method
 func (m *MockClient) ListDisks(arg0, arg1 string, arg2 ...compute.ListCallOption) ([]*v1.Disk, error) {
 	m.ctrl.T.Helper()
@@ -1041,6 +1415,20 @@ func (mr *MockClientMockRecorder) ListZones(arg0 interface{}, arg1 ...interface{
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListZones", reflect.TypeOf((*MockClient)(nil).ListZones), varargs...)
 }
 
+// RemoveInstanceGroupInstances mocks base method
+func (m *MockClient) RemoveInstanceGroupInstances(arg0, arg1, arg2 string, arg3 []string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RemoveInstanceGroupInstances", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RemoveInstanceGroupInstances indicates an expected call of RemoveInstanceGroupInstances
+func (mr *MockClientMockRecorder) RemoveInstanceGroupInstances(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveInstanceGroupInstances", reflect.TypeOf((*MockClient)(nil).RemoveInstanceGroupInstances), arg0, arg1, arg2, arg3)
+}
+
 // ResizeDisk mocks base method
 func (m *MockClient) ResizeDisk(arg0, arg1, arg2 string, arg3 *v1.DisksResizeRequest) error {
 	m.ctrl.T.Helper()
@@ -1151,6 +1539,20 @@ func (mr *MockClientMockRecorder) StartInstance(arg0, arg1, arg2 interface{}) *g
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StartInstance", reflect.TypeOf((*MockClient)(nil).StartInstance), arg0, arg1, arg2)
 }
 
+// SetMachineType mocks base method
+func (m *MockClient) SetMachineType(arg0, arg1, arg2, arg3 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetMachineType", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetMachineType indicates an expected call of SetMachineType
+func (mr *MockClientMockRecorder) SetMachineType(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetMachineType", reflect.TypeOf((*MockClient)(nil).SetMachineType), arg0, arg1, arg2, arg3)
+}
+
 // StopInstance mocks base method
 func (m *MockClient) StopInstance(arg0, arg1, arg2 string) error {
 	m.ctrl.T.Helper()